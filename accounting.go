@@ -0,0 +1,353 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often days that are over are exported, and what has been counted is saved
+const accountingCheckInterval = time.Minute
+const accountingSaveInterval = 10 * time.Minute
+
+// The team that groups without the team tag are counted under
+const accountingUnassigned = "unassigned"
+
+// The last commit that was counted for a partition of a group
+type accountedCommit struct {
+	Offset    int64 `json:"offset"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// What usage accounting has counted. This is saved to the state file, so it survives restarts
+type accountingState struct {
+	// Messages consumed by day (YYYY-MM-DD), then team, then cluster
+	Days    map[string]map[string]map[string]int64 `json:"days"`
+	Commits map[string]*accountedCommit            `json:"commits"`
+
+	// The last day that was written to the CSV directory
+	Exported string `json:"exported"`
+}
+
+// The messages consumed by the groups of a team in a cluster on a day
+type TeamUsage struct {
+	Day      string `json:"day"`
+	Team     string `json:"team"`
+	Cluster  string `json:"cluster"`
+	Messages int64  `json:"messages"`
+}
+
+// Usage accounting counts the messages consumed by each team each day for chargeback, as the difference between the
+// offsets each group commits for a partition. The team of a group is one of its tags. The first commit for a partition
+// is only a starting point, and a commit that goes back (the group was reset) starts again from there. Commits are
+// counted on the day they were made, and ones older than the last counted commit for the partition (such as when the
+// offsets topic is read again after a restart) are skipped, so nothing is counted twice
+type UsageAccounting struct {
+	app      *ApplicationContext
+	location *time.Location
+	lock     sync.Mutex
+	state    *accountingState
+	lastSave time.Time
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Create usage accounting, loading what was counted before a restart from the state file. The config must have been
+// validated
+func NewUsageAccounting(app *ApplicationContext) (*UsageAccounting, error) {
+	accounting := &UsageAccounting{
+		app:      app,
+		location: displayLocation(app.Config),
+		state:    &accountingState{},
+		quit:     make(chan struct{}),
+	}
+	data, err := ioutil.ReadFile(app.Config.Accounting.StateFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, accounting.state); err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %v", app.Config.Accounting.StateFile, err)
+		}
+	}
+	if accounting.state.Days == nil {
+		accounting.state.Days = make(map[string]map[string]map[string]int64)
+	}
+	if accounting.state.Commits == nil {
+		accounting.state.Commits = make(map[string]*accountedCommit)
+	}
+	return accounting, nil
+}
+
+// Count the messages consumed since the last commit for the partition. This is one of the storage module's commit
+// hooks
+func (accounting *UsageAccounting) Record(offset *storage.PartitionOffset) {
+	team := accounting.app.Storage.GroupTags(offset.Group)[accounting.app.Config.Accounting.TeamTag]
+	if team == "" {
+		team = accountingUnassigned
+	}
+	key := fmt.Sprintf("%s/%s/%s/%v", offset.Cluster, offset.Group, offset.Topic, offset.Partition)
+
+	accounting.lock.Lock()
+	defer accounting.lock.Unlock()
+	last, ok := accounting.state.Commits[key]
+	if !ok {
+		accounting.state.Commits[key] = &accountedCommit{Offset: offset.Offset, Timestamp: offset.Timestamp}
+		return
+	}
+	if offset.Timestamp <= last.Timestamp {
+		return
+	}
+	if offset.Offset > last.Offset {
+		day := accounting.day(offset.Timestamp)
+		if _, ok := accounting.state.Days[day]; !ok {
+			accounting.state.Days[day] = make(map[string]map[string]int64)
+		}
+		if _, ok := accounting.state.Days[day][team]; !ok {
+			accounting.state.Days[day][team] = make(map[string]int64)
+		}
+		accounting.state.Days[day][team][offset.Cluster] += offset.Offset - last.Offset
+	}
+	last.Offset = offset.Offset
+	last.Timestamp = offset.Timestamp
+}
+
+// Return the day of a timestamp (in milliseconds) in the display timezone
+func (accounting *UsageAccounting) day(timestamp int64) string {
+	return time.Unix(0, timestamp*int64(time.Millisecond)).In(accounting.location).Format("2006-01-02")
+}
+
+func (accounting *UsageAccounting) Start() {
+	accounting.lastSave = time.Now()
+	accounting.wg.Add(1)
+	go func() {
+		defer accounting.wg.Done()
+
+		ticker := time.NewTicker(accountingCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-accounting.quit:
+				return
+			case now := <-ticker.C:
+				accounting.check(now)
+			}
+		}
+	}()
+}
+
+// Stop exporting days, and save what has been counted
+func (accounting *UsageAccounting) Stop() {
+	close(accounting.quit)
+	accounting.wg.Wait()
+	if err := accounting.Flush(); err != nil {
+		log.Errorf("Cannot save usage accounting state: %v", err)
+	}
+}
+
+// Save what has been counted to the state file. It is written to a temporary file first, so a crash while writing
+// leaves the last one in place
+func (accounting *UsageAccounting) Flush() error {
+	accounting.lock.Lock()
+	data, err := json.Marshal(accounting.state)
+	accounting.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	filename := accounting.app.Config.Accounting.StateFile
+	if err := ioutil.WriteFile(filename+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// Export the days that are over, and now and then, forget old days and partitions and save the state
+func (accounting *UsageAccounting) check(now time.Time) {
+	if accounting.app.Config.Accounting.CsvDir != "" {
+		accounting.export(now)
+	}
+	if now.Sub(accounting.lastSave) < accountingSaveInterval {
+		return
+	}
+	accounting.lastSave = now
+	accounting.prune(now)
+	if err := accounting.Flush(); err != nil {
+		log.Errorf("Cannot save usage accounting state: %v", err)
+	}
+}
+
+// Write each day that is over and hasn't been exported yet to the CSV directory. A day that can't be written is tried
+// again on the next check
+func (accounting *UsageAccounting) export(now time.Time) {
+	today := now.In(accounting.location).Format("2006-01-02")
+	accounting.lock.Lock()
+	days := make([]string, 0)
+	for day := range accounting.state.Days {
+		if (day < today) && (day > accounting.state.Exported) {
+			days = append(days, day)
+		}
+	}
+	accounting.lock.Unlock()
+	sort.Strings(days)
+
+	for _, day := range days {
+		filename := filepath.Join(accounting.app.Config.Accounting.CsvDir, "usage-"+day+".csv")
+		file, err := os.Create(filename)
+		if err != nil {
+			log.Errorf("Cannot export usage for %s: %v", day, err)
+			return
+		}
+		err = writeUsageCSV(file, accounting.Usage(day, day, ""))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Errorf("Cannot export usage for %s to %s: %v", day, filename, err)
+			return
+		}
+		log.Infof("Exported usage for %s to %s", day, filename)
+
+		accounting.lock.Lock()
+		accounting.state.Exported = day
+		accounting.lock.Unlock()
+	}
+}
+
+// Forget the days that are older than the configured days, and the partitions that haven't had a commit for longer
+// than groups are kept
+func (accounting *UsageAccounting) prune(now time.Time) {
+	oldest := now.In(accounting.location).AddDate(0, 0, -accounting.app.Config.Accounting.Days).Format("2006-01-02")
+	cutoff := now.UnixNano()/int64(time.Millisecond) - accounting.app.Config.Lagcheck.ExpireGroup*1000
+
+	accounting.lock.Lock()
+	defer accounting.lock.Unlock()
+	for day := range accounting.state.Days {
+		if day < oldest {
+			delete(accounting.state.Days, day)
+		}
+	}
+	for key, commit := range accounting.state.Commits {
+		if commit.Timestamp < cutoff {
+			delete(accounting.state.Commits, key)
+		}
+	}
+}
+
+// Return the usage from the first day to the last (YYYY-MM-DD, either of which can be empty for no limit), for one
+// team or every team, sorted by day, team, and cluster
+func (accounting *UsageAccounting) Usage(from string, to string, team string) []*TeamUsage {
+	accounting.lock.Lock()
+	defer accounting.lock.Unlock()
+
+	usage := make([]*TeamUsage, 0)
+	for day, teams := range accounting.state.Days {
+		if ((from != "") && (day < from)) || ((to != "") && (day > to)) {
+			continue
+		}
+		for name, clusters := range teams {
+			if (team != "") && (name != team) {
+				continue
+			}
+			for cluster, messages := range clusters {
+				usage = append(usage, &TeamUsage{Day: day, Team: name, Cluster: cluster, Messages: messages})
+			}
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		switch {
+		case usage[i].Day != usage[j].Day:
+			return usage[i].Day < usage[j].Day
+		case usage[i].Team != usage[j].Team:
+			return usage[i].Team < usage[j].Team
+		default:
+			return usage[i].Cluster < usage[j].Cluster
+		}
+	})
+	return usage
+}
+
+// Write the usage as CSV, with a header line
+func writeUsageCSV(w io.Writer, usage []*TeamUsage) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"day", "team", "cluster", "messages"})
+	for _, row := range usage {
+		writer.Write([]string{row.Day, row.Team, row.Cluster, strconv.FormatInt(row.Messages, 10)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type HTTPResponseAccounting struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Usage   []*TeamUsage            `json:"usage"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/accounting, which returns the messages consumed by each team in each cluster each day.
+// ?from=YYYY-MM-DD and ?to=YYYY-MM-DD limit the days, ?team=(team) picks one team, and ?format=csv returns CSV.
+// Clusters the token can't see are left out
+func handleAccounting(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if app.Accounting == nil {
+		return makeErrorResponse(http.StatusNotFound, "usage accounting is not enabled", w, r)
+	}
+	query := r.URL.Query()
+	for _, param := range []string{"from", "to"} {
+		if value := query.Get(param); value != "" {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				return makeErrorResponse(http.StatusBadRequest, param+" must be a day, as YYYY-MM-DD", w, r)
+			}
+		}
+	}
+
+	usage := make([]*TeamUsage, 0)
+	for _, row := range app.Accounting.Usage(query.Get("from"), query.Get("to"), query.Get("team")) {
+		if app.AdminAudit.ClusterAllowed(r, row.Cluster) {
+			usage = append(usage, row)
+		}
+	}
+
+	if query.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		if err := writeUsageCSV(w, usage); err != nil {
+			log.Errorf("Cannot write usage as CSV: %v", err)
+		}
+		return 200, ""
+	}
+	jsonStr, err := json.Marshal(HTTPResponseAccounting{
+		Error:   false,
+		Message: "usage returned",
+		Usage:   usage,
+		Request: makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
@@ -0,0 +1,336 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One destructive API call (or an attempt at one that was denied) in the admin audit log
+type AdminRecord struct {
+	Timestamp  int64  `json:"timestamp"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remote_addr"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Action     string `json:"action"`
+	Status     int    `json:"status"`
+}
+
+// Destructive API calls (deleting groups, changing expected groups and ignored partitions, and pausing clusters) need
+// the token of one of the [admin-user] sections, sent as "Authorization: Bearer (token)". Every call, including those
+// that are denied, is written to the admin audit log file (encrypted line by line if there is an encryption key), which
+// can be read back with GET /v2/admin/audit. If no admin users are configured, the calls are allowed for everyone (as
+// they were before), but are still logged
+//
+// A token can be scoped to some clusters, in which case it can only be used for the calls under those clusters and
+// the cluster list (which only shows them), and can be made read-only so it can't make destructive calls. Scoped
+// tokens need require-token set in [httpserver], so that every call needs a token and a scoped token is the only way to
+// see anything. Only tokens that are neither scoped nor read-only can read the admin audit log
+type AdminAudit struct {
+	app   *ApplicationContext
+	users map[string]*adminUser
+	lock  sync.Mutex
+	file  *os.File
+}
+
+type adminUser struct {
+	token    string
+	clusters map[string]bool
+	readOnly bool
+}
+
+func NewAdminAudit(app *ApplicationContext) (*AdminAudit, error) {
+	admin := &AdminAudit{
+		app:   app,
+		users: make(map[string]*adminUser, len(app.Config.AdminUser)),
+	}
+	for user, cfg := range app.Config.AdminUser {
+		token := cfg.Token
+		if cfg.TokenFile != "" {
+			contents, err := ioutil.ReadFile(cfg.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read token for admin user %s: %v", user, err)
+			}
+			token = strings.TrimSpace(string(contents))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("admin user %s has an empty token", user)
+		}
+		admin.users[user] = &adminUser{
+			token:    token,
+			clusters: make(map[string]bool, len(cfg.Cluster)),
+			readOnly: cfg.ReadOnly,
+		}
+		for _, cluster := range cfg.Cluster {
+			admin.users[user].clusters[cluster] = true
+		}
+	}
+	if len(admin.users) == 0 {
+		log.Warn("No admin users are configured, so anyone who can reach the HTTP server can delete groups and pause clusters")
+	}
+
+	file, err := os.OpenFile(app.Config.Audit.AdminFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	admin.file = file
+	return admin, nil
+}
+
+func (admin *AdminAudit) Stop() {
+	admin.lock.Lock()
+	defer admin.lock.Unlock()
+	admin.file.Close()
+}
+
+// Find the admin user for the request's token. Every token is checked in constant time, so the time taken doesn't give
+// away how much of a token was right
+func (admin *AdminAudit) Authenticate(r *http.Request) (string, bool) {
+	if len(admin.users) == 0 {
+		return "", true
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	found := ""
+	for user, cfg := range admin.users {
+		if subtle.ConstantTimeCompare(token, []byte(cfg.token)) == 1 {
+			found = user
+		}
+	}
+	return found, found != ""
+}
+
+// Check the request's token against the cluster it is for, before it is handled. This returns 0 if the request can go
+// ahead, or the status and message to deny it with. Destructive calls are checked again in adminAction
+func (admin *AdminAudit) Authorize(r *http.Request) (int, string) {
+	if (admin == nil) || (len(admin.users) == 0) {
+		return 0, ""
+	}
+	user, ok := admin.Authenticate(r)
+	if !ok {
+		if admin.app.Config.Httpserver.RequireToken {
+			return http.StatusUnauthorized, "a token is required"
+		}
+		// Without require-token, calls without a valid token are allowed as before
+		return 0, ""
+	}
+	if len(admin.users[user].clusters) == 0 {
+		return 0, ""
+	}
+
+	cluster, isList := requestCluster(r.URL.Path)
+	switch {
+	case isList:
+		return 0, ""
+	case cluster == "":
+		return http.StatusForbidden, "this token can only be used for the calls for its clusters"
+	case !admin.users[user].clusters[cluster]:
+		return http.StatusForbidden, "this token cannot be used for cluster " + cluster
+	}
+	return 0, ""
+}
+
+// Return whether the request's token (or the lack of one) can see the cluster, for the calls that cover every cluster
+func (admin *AdminAudit) ClusterAllowed(r *http.Request, cluster string) bool {
+	if admin == nil {
+		return true
+	}
+	user, ok := admin.Authenticate(r)
+	if !ok || (user == "") || (len(admin.users[user].clusters) == 0) {
+		return true
+	}
+	return admin.users[user].clusters[cluster]
+}
+
+// Return the cluster a v2 API path is for, or whether it is the cluster list. Calls that aren't for one cluster (such as
+// GraphQL and the export stream) return neither
+func requestCluster(path string) (string, bool) {
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case (len(pathParts) == 2) && (pathParts[0] == "v2") && ((pathParts[1] == "kafka") || (pathParts[1] == "zookeeper")):
+		return "", true
+	case (len(pathParts) >= 3) && (pathParts[0] == "v2") && (pathParts[1] == "kafka"):
+		return pathParts[2], false
+	case (len(pathParts) >= 4) && (pathParts[0] == "v2") && (pathParts[1] == "admin") && (pathParts[2] == "kafka"):
+		return pathParts[3], false
+	}
+	return "", false
+}
+
+// Write a record to the file. This is synchronous (unlike the offset commit audit log), as destructive calls are rare,
+// and the record should be on disk before the call returns
+func (admin *AdminAudit) Record(record *AdminRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Cannot encode admin audit record: %v", err)
+		return
+	}
+	if admin.app.Encryptor != nil {
+		line = admin.app.Encryptor.SealLine(line)
+	}
+
+	admin.lock.Lock()
+	defer admin.lock.Unlock()
+	if _, err := admin.file.Write(append(line, '\n')); err != nil {
+		log.Errorf("Cannot write to admin audit file %s: %v", admin.app.Config.Audit.AdminFile, err)
+	}
+}
+
+// Read the records from the file, oldest first, keeping only those by the user (if set) at or after since (a
+// timestamp in milliseconds). If there are more than limit records, the newest ones are returned
+func (admin *AdminAudit) Query(user string, since int64, limit int) ([]*AdminRecord, error) {
+	data, err := ioutil.ReadFile(admin.app.Config.Audit.AdminFile)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*AdminRecord, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if admin.app.Encryptor != nil {
+			sealed, err := base64.StdEncoding.DecodeString(string(line))
+			if err != nil {
+				return nil, err
+			}
+			if line, err = admin.app.Encryptor.Open(sealed); err != nil {
+				return nil, err
+			}
+		}
+
+		record := &AdminRecord{}
+		if err := json.Unmarshal(line, record); err != nil {
+			return nil, err
+		}
+		if ((user != "") && (record.User != user)) || (record.Timestamp < since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records, scanner.Err()
+}
+
+// Run a destructive API call if the request has an admin token, and record it in the admin audit log
+func adminAction(app *ApplicationContext, w http.ResponseWriter, r *http.Request, action string, handler func() (int, string)) (int, string) {
+	user, ok := app.AdminAudit.Authenticate(r)
+	var status int
+	var body string
+	switch {
+	case ok && (user != "") && app.AdminAudit.users[user].readOnly:
+		status, body = makeErrorResponse(http.StatusForbidden, "this token cannot make admin calls", w, r)
+	case ok:
+		status, body = handler()
+	default:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		status, body = makeErrorResponse(http.StatusUnauthorized, "an admin token is required", w, r)
+	}
+
+	if user == "" {
+		user = "anonymous"
+	}
+	app.AdminAudit.Record(&AdminRecord{
+		Timestamp:  time.Now().Unix() * 1000,
+		User:       user,
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Action:     action,
+		Status:     status,
+	})
+	return status, body
+}
+
+// Wrap a handler that only does destructive calls in adminAction
+func adminHandler(action string, handler func(*ApplicationContext, http.ResponseWriter, *http.Request) (int, string)) func(*ApplicationContext, http.ResponseWriter, *http.Request) (int, string) {
+	return func(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+		return adminAction(app, w, r, action, func() (int, string) {
+			return handler(app, w, r)
+		})
+	}
+}
+
+type HTTPResponseAdminAudit struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Records []*AdminRecord          `json:"records"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/admin/audit?user=(user)&since=(timestamp in ms)&limit=(N), which needs an admin token too. The log
+// covers every cluster, so tokens that are scoped or read-only can't read it
+func handleAdminAudit(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	user, ok := app.AdminAudit.Authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return makeErrorResponse(http.StatusUnauthorized, "an admin token is required", w, r)
+	}
+	if (user != "") && ((len(app.AdminAudit.users[user].clusters) > 0) || app.AdminAudit.users[user].readOnly) {
+		return makeErrorResponse(http.StatusForbidden, "this token cannot read the admin audit log", w, r)
+	}
+
+	var since int64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		if since, err = strconv.ParseInt(sinceStr, 10, 64); err != nil {
+			return makeErrorResponse(http.StatusBadRequest, "since must be a timestamp in milliseconds", w, r)
+		}
+	}
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); (err != nil) || (limit < 1) {
+			return makeErrorResponse(http.StatusBadRequest, "limit must be a positive number", w, r)
+		}
+	}
+
+	records, err := app.AdminAudit.Query(r.URL.Query().Get("user"), since, limit)
+	if err != nil {
+		log.Errorf("Cannot read admin audit file %s: %v", app.Config.Audit.AdminFile, err)
+		return makeErrorResponse(http.StatusInternalServerError, "cannot read the admin audit log", w, r)
+	}
+
+	jsonStr, err := json.Marshal(HTTPResponseAdminAudit{
+		Error:   false,
+		Message: "admin audit log returned",
+		Records: records,
+		Request: makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
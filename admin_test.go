@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/gcfg.v1"
+)
+
+const adminUsers = `
+[admin-user "oncall"]
+token=oncall-token
+
+[admin-user "contractors"]
+token=contractors-token
+cluster=local
+
+[admin-user "viewers"]
+token=viewers-token
+read-only=true
+`
+
+// Validate the harness config plus extra, without starting anything
+func validateTestConfig(t *testing.T, extra string) error {
+	config := &BurrowConfig{}
+	if err := gcfg.ReadStringInto(config, harnessConfig+extra); err != nil {
+		t.Fatalf("Cannot parse config: %v", err)
+	}
+	logDir, err := ioutil.TempDir("", "burrow-config")
+	if err != nil {
+		t.Fatalf("Cannot create log dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(logDir) })
+	config.General.LogDir = logDir
+	return ValidateConfig(&ApplicationContext{Config: config, Metrics: NewMetrics(), StatusLinks: NewStatusLinks()})
+}
+
+func Test_adminScopedUserNeedsRequireToken(t *testing.T) {
+	err := validateTestConfig(t, adminUsers)
+	if (err == nil) || !strings.Contains(err.Error(), "Admin user contractors is scoped to clusters") {
+		t.Errorf("Expected the scoped user to need require-token, got %v", err)
+	}
+	if err := validateTestConfig(t, adminUsers+"\n[httpserver]\nrequire-token=true\n"); err != nil {
+		t.Errorf("Expected the scoped user to be allowed with require-token, got %v", err)
+	}
+}
+
+func Test_adminAuditLogAccess(t *testing.T) {
+	harness := newTestHarness(t, adminUsers+"\n[httpserver]\nrequire-token=true\n")
+	get := func(token string) int {
+		request, _ := http.NewRequest("GET", harness.api.URL+"/v2/admin/audit", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Cannot get the admin audit log: %v", err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	for token, expected := range map[string]int{
+		"":                  http.StatusUnauthorized,
+		"wrong-token":       http.StatusUnauthorized,
+		"contractors-token": http.StatusForbidden,
+		"viewers-token":     http.StatusForbidden,
+		"oncall-token":      http.StatusOK,
+	} {
+		if status := get(token); status != expected {
+			t.Errorf("Expected %v for token %q, got %v", expected, token, status)
+		}
+	}
+}
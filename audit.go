@@ -0,0 +1,213 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// One line in the audit log
+type AuditRecord struct {
+	Cluster   string `json:"cluster"`
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// The audit log writes every consumer offset commit that the storage module accepts to a file (which is rotated by
+// size, and encrypted line by line if there is an encryption key) and/or a Kafka topic. Records are written in the order they are accepted by a single goroutine, so that
+// recording a commit never waits on disk or the network
+type AuditLog struct {
+	app      *ApplicationContext
+	records  chan *AuditRecord
+	file     *os.File
+	fileSize int64
+	producer sarama.AsyncProducer
+	wg       sync.WaitGroup
+	done     chan struct{}
+	stopped  bool
+	stopLock sync.RWMutex
+}
+
+func NewAuditLog(app *ApplicationContext) (*AuditLog, error) {
+	auditLog := &AuditLog{
+		app:     app,
+		records: make(chan *AuditRecord, 10000),
+		done:    make(chan struct{}),
+	}
+
+	if app.Config.Audit.File != "" {
+		if err := auditLog.openFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if app.Config.Audit.KafkaTopic != "" {
+		clientConfig := newSaramaConfig(app, app.Config.Audit.KafkaCluster)
+		clientConfig.Producer.RequiredAcks = sarama.WaitForAll
+		clientConfig.Producer.Return.Errors = true
+		producer, err := sarama.NewAsyncProducer(app.Config.Kafka[app.Config.Audit.KafkaCluster].Brokers, clientConfig)
+		if err != nil {
+			if auditLog.file != nil {
+				auditLog.file.Close()
+			}
+			return nil, err
+		}
+		auditLog.producer = producer
+
+		auditLog.wg.Add(1)
+		go func() {
+			defer auditLog.wg.Done()
+			for err := range producer.Errors() {
+				log.Errorf("Cannot write offset commit to audit topic %s: %v", app.Config.Audit.KafkaTopic, err.Err)
+			}
+		}()
+	}
+
+	go auditLog.writer()
+	return auditLog, nil
+}
+
+// Record an accepted offset commit. This is the storage module's commit hook
+func (auditLog *AuditLog) Record(offset *storage.PartitionOffset) {
+	auditLog.stopLock.RLock()
+	defer auditLog.stopLock.RUnlock()
+	if auditLog.stopped {
+		log.Warnf("Offset commit for group %s in cluster %s was accepted after the audit log was stopped", offset.Group, offset.Cluster)
+		return
+	}
+
+	auditLog.records <- &AuditRecord{
+		Cluster:   offset.Cluster,
+		Group:     offset.Group,
+		Topic:     offset.Topic,
+		Partition: offset.Partition,
+		Offset:    offset.Offset,
+		Timestamp: offset.Timestamp,
+	}
+}
+
+func (auditLog *AuditLog) writer() {
+	defer close(auditLog.done)
+
+	for record := range auditLog.records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			log.Errorf("Cannot encode audit record: %v", err)
+			continue
+		}
+
+		if auditLog.file != nil {
+			if auditLog.app.Encryptor != nil {
+				auditLog.writeFile(append(auditLog.app.Encryptor.SealLine(line), '\n'))
+			} else {
+				auditLog.writeFile(append(line, '\n'))
+			}
+		}
+		if auditLog.producer != nil {
+			auditLog.producer.Input() <- &sarama.ProducerMessage{
+				Topic: auditLog.app.Config.Audit.KafkaTopic,
+				Key:   sarama.StringEncoder(record.Group),
+				Value: sarama.ByteEncoder(line),
+			}
+		}
+	}
+}
+
+func (auditLog *AuditLog) openFile() error {
+	file, err := os.OpenFile(auditLog.app.Config.Audit.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	auditLog.file = file
+	auditLog.fileSize = info.Size()
+	return nil
+}
+
+func (auditLog *AuditLog) writeFile(line []byte) {
+	if auditLog.fileSize+int64(len(line)) > auditLog.app.Config.Audit.MaxSize*1024*1024 {
+		auditLog.rotate()
+	}
+	if auditLog.file == nil {
+		// The file could not be reopened after the last rotation
+		if err := auditLog.openFile(); err != nil {
+			log.Errorf("Cannot open audit file %s: %v", auditLog.app.Config.Audit.File, err)
+			return
+		}
+	}
+
+	written, err := auditLog.file.Write(line)
+	auditLog.fileSize += int64(written)
+	if err != nil {
+		log.Errorf("Cannot write offset commit to audit file %s: %v", auditLog.app.Config.Audit.File, err)
+	}
+}
+
+// Move the current file aside with a timestamp suffix and start a new one. If max-backups is set, the oldest rotated
+// files are removed so that only that many are kept
+func (auditLog *AuditLog) rotate() {
+	filename := auditLog.app.Config.Audit.File
+	auditLog.file.Close()
+	auditLog.file = nil
+
+	rotated := filename + "." + time.Now().UTC().Format("20060102T150405.000")
+	if err := os.Rename(filename, rotated); err != nil {
+		log.Errorf("Cannot rotate audit file %s: %v", filename, err)
+	}
+	if err := auditLog.openFile(); err != nil {
+		log.Errorf("Cannot open audit file %s: %v", filename, err)
+	}
+
+	if auditLog.app.Config.Audit.MaxBackups > 0 {
+		backups, _ := filepath.Glob(filename + ".*")
+		sort.Strings(backups)
+		for len(backups) > auditLog.app.Config.Audit.MaxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				log.Errorf("Cannot remove old audit file %s: %v", backups[0], err)
+			}
+			backups = backups[1:]
+		}
+	}
+}
+
+// Stop the audit log, after writing out any records that are waiting. This should be called after the storage module
+// is stopped, so that nothing else is recorded
+func (auditLog *AuditLog) Stop() {
+	auditLog.stopLock.Lock()
+	auditLog.stopped = true
+	close(auditLog.records)
+	auditLog.stopLock.Unlock()
+	<-auditLog.done
+
+	if auditLog.producer != nil {
+		// Closing the producer flushes the messages it has buffered and ends the errors goroutine
+		auditLog.producer.AsyncClose()
+	}
+	auditLog.wg.Wait()
+	if auditLog.file != nil {
+		auditLog.file.Close()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linkedin/burrow/storage"
+)
+
+// The config for an audit log that writes to a file in a new directory, which is removed when the test ends
+func testAuditApp(t *testing.T, maxSize int64, maxBackups int) (*ApplicationContext, string) {
+	dir, err := ioutil.TempDir("", "burrow-audit")
+	if err != nil {
+		t.Fatalf("Cannot create dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	app := &ApplicationContext{Config: &BurrowConfig{}}
+	app.Config.Audit.File = filepath.Join(dir, "audit.log")
+	app.Config.Audit.MaxSize = maxSize
+	app.Config.Audit.MaxBackups = maxBackups
+	return app, dir
+}
+
+func startAuditLog(t *testing.T, app *ApplicationContext) *AuditLog {
+	auditLog, err := NewAuditLog(app)
+	if err != nil {
+		t.Fatalf("Cannot start audit log: %v", err)
+	}
+	return auditLog
+}
+
+func readAuditRecords(t *testing.T, filename string, encryptor *Encryptor) []*AuditRecord {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Cannot read audit file: %v", err)
+	}
+	records := make([]*AuditRecord, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		data := []byte(line)
+		if encryptor != nil {
+			sealed, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				t.Fatalf("Cannot decode line %q: %v", line, err)
+			}
+			if data, err = encryptor.Open(sealed); err != nil {
+				t.Fatalf("Cannot open line %q: %v", line, err)
+			}
+		}
+		record := &AuditRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			t.Fatalf("Cannot decode record %q: %v", data, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func Test_auditLogFile(t *testing.T) {
+	app, _ := testAuditApp(t, 100, 0)
+	auditLog := startAuditLog(t, app)
+	for offset := int64(1); offset <= 3; offset++ {
+		auditLog.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Partition: 2, Offset: offset, Timestamp: offset * 1000})
+	}
+	auditLog.Stop()
+
+	// Commits accepted after the log is stopped are dropped
+	auditLog.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Partition: 2, Offset: 4})
+
+	records := readAuditRecords(t, app.Config.Audit.File, nil)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %v", len(records))
+	}
+	for i, record := range records {
+		if (record.Cluster != "local") || (record.Group != "payments") || (record.Topic != "orders") ||
+			(record.Partition != 2) || (record.Offset != int64(i+1)) || (record.Timestamp != int64(i+1)*1000) {
+			t.Errorf("Record %v: unexpected %+v", i, record)
+		}
+	}
+}
+
+func Test_auditLogEncrypted(t *testing.T) {
+	app, _ := testAuditApp(t, 100, 0)
+	app.Encryptor = testEncryptor(t, bytes.Repeat([]byte{2}, 32))
+	auditLog := startAuditLog(t, app)
+	auditLog.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Offset: 10})
+	auditLog.Stop()
+
+	contents, _ := ioutil.ReadFile(app.Config.Audit.File)
+	if bytes.Contains(contents, []byte("payments")) {
+		t.Errorf("Expected the audit file to be encrypted, got %s", contents)
+	}
+	records := readAuditRecords(t, app.Config.Audit.File, app.Encryptor)
+	if (len(records) != 1) || (records[0].Group != "payments") || (records[0].Offset != 10) {
+		t.Errorf("Unexpected records %+v", records)
+	}
+}
+
+// A file that would go over max-size is moved aside, and only max-backups of the moved files are kept
+func Test_auditLogRotation(t *testing.T) {
+	app, dir := testAuditApp(t, 1, 1)
+	filename := app.Config.Audit.File
+	full := bytes.Repeat([]byte("x"), 1024*1024-10)
+	ioutil.WriteFile(filename, full, 0640)
+	ioutil.WriteFile(filename+".20000101T000000.000", []byte("old"), 0640)
+
+	auditLog := startAuditLog(t, app)
+	auditLog.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Offset: 10})
+	auditLog.Stop()
+
+	records := readAuditRecords(t, filename, nil)
+	if (len(records) != 1) || (records[0].Offset != 10) {
+		t.Errorf("Expected the new file to have only the new record, got %+v", records)
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "audit.log.*"))
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup, got %v", backups)
+	}
+	if contents, _ := ioutil.ReadFile(backups[0]); !bytes.Equal(contents, full) {
+		t.Errorf("Expected the backup to be the full file, not the oldest one")
+	}
+}
@@ -0,0 +1,122 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Handle GET /v2/export/checkpoint?cluster=(cluster), which returns the offset rings of the given clusters (every
+// cluster, if none are given) as a storage checkpoint, for a new instance to bootstrap from. This needs an admin token,
+// and is encoded with the codec for the Accept header, or the configured codec
+func handleCheckpointExport(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if _, ok := app.AdminAudit.Authenticate(r); !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return makeErrorResponse(http.StatusUnauthorized, "an admin token is required", w, r)
+	}
+	requested := make(map[string]bool)
+	for _, cluster := range r.URL.Query()["cluster"] {
+		if _, ok := app.Config.Kafka[cluster]; !ok {
+			return makeErrorResponse(http.StatusNotFound, "cluster "+cluster+" not found", w, r)
+		}
+		requested[cluster] = true
+	}
+
+	checkpoint := app.Storage.TakeCheckpoint()
+	for cluster := range checkpoint.Clusters {
+		if ((len(requested) > 0) && !requested[cluster]) || !app.AdminAudit.ClusterAllowed(r, cluster) {
+			delete(checkpoint.Clusters, cluster)
+		}
+	}
+
+	codec := storage.CodecByContentType(r.Header.Get("Accept"))
+	if codec == nil {
+		codec = storage.CodecByName(app.Config.Storage.Codec)
+	}
+	data, err := codec.Encode(checkpoint)
+	if err != nil {
+		return makeErrorResponse(http.StatusInternalServerError, "could not encode the checkpoint", w, r)
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.Write(data)
+	return 200, ""
+}
+
+// Pull the offset rings from the peer in [bootstrap], and merge them into storage. This is done before the offset
+// sources are started, so a new instance (such as one taking over clusters when shards are rebalanced) has the peer's
+// broker offsets, groups, and recent commits, and its evaluations are complete as soon as the commits continue. The
+// clusters must have the same names on both instances
+func bootstrapFromPeer(app *ApplicationContext) error {
+	cfg := &app.Config.Bootstrap
+	token := cfg.Token
+	if cfg.TokenFile != "" {
+		contents, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return fmt.Errorf("cannot read token: %v", err)
+		}
+		token = strings.TrimSpace(string(contents))
+	}
+
+	requestUrl := strings.TrimSuffix(cfg.Peer, "/") + "/v2/export/checkpoint"
+	if len(cfg.Cluster) > 0 {
+		requestUrl += "?" + url.Values{"cluster": cfg.Cluster}.Encode()
+	}
+	req, err := http.NewRequest("GET", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", storage.CodecByName(app.Config.Storage.Codec).ContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := newPeerClient(time.Duration(cfg.Timeout) * time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	codec := storage.CodecByContentType(resp.Header.Get("Content-Type"))
+	if codec == nil {
+		codec = storage.JSONCodec{}
+	}
+	checkpoint := &storage.Checkpoint{}
+	if err := storage.DecodeAny(codec, body, checkpoint); err != nil {
+		return fmt.Errorf("cannot decode the checkpoint: %v", err)
+	}
+	app.Storage.MergeCheckpoint(checkpoint)
+
+	groups := 0
+	for cluster, clusterCheckpoint := range checkpoint.Clusters {
+		if _, ok := app.Config.Kafka[cluster]; ok {
+			groups += len(clusterCheckpoint.Consumer)
+		}
+	}
+	log.Infof("Bootstrapped %v groups in %v clusters from %s", groups, len(checkpoint.Clusters), cfg.Peer)
+	return nil
+}
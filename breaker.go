@@ -0,0 +1,182 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The states of a circuit breaker
+//   - closed: notifications are sent as usual
+//   - open: the endpoint failed breaker-failures times in a row, and nothing is sent to it until breaker-cooldown
+//     seconds have passed
+//   - half-open: the cooldown is over, and one notification is being sent to see if the endpoint is back. If it
+//     works the breaker closes, and if it fails the breaker opens for another cooldown
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// A circuit breaker for one notifier endpoint (a webhook URL, or the SMTP server), so that an endpoint that is down
+// isn't tried (and waited on until the timeout) for every notification
+type CircuitBreaker struct {
+	notifier    string
+	endpoint    string
+	threshold   int
+	cooldown    time.Duration
+	lock        sync.Mutex
+	state       string
+	failures    int
+	lastError   string
+	lastFailure time.Time
+	openedAt    time.Time
+	opens       int
+}
+
+type BreakerStatus struct {
+	Notifier            string `json:"notifier"`
+	Endpoint            string `json:"endpoint"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+	LastFailure         int64  `json:"last_failure,omitempty"`
+	OpenedAt            int64  `json:"opened_at,omitempty"`
+	RetryAt             int64  `json:"retry_at,omitempty"`
+	Opens               int    `json:"opens"`
+}
+
+func NewCircuitBreaker(app *ApplicationContext, notifier string, endpoint string) *CircuitBreaker {
+	return &CircuitBreaker{
+		notifier:  notifier,
+		endpoint:  endpoint,
+		threshold: app.Config.Notifier.BreakerFailures,
+		cooldown:  time.Duration(app.Config.Notifier.BreakerCooldown) * time.Second,
+		state:     BreakerClosed,
+	}
+}
+
+// Return whether a notification can be sent to the endpoint now. If it can, Succeeded or Failed must be called
+// with the outcome
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	switch breaker.state {
+	case BreakerOpen:
+		if time.Since(breaker.openedAt) < breaker.cooldown {
+			return false
+		}
+		breaker.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// Only the one notification that is testing the endpoint is sent
+		return false
+	}
+	return true
+}
+
+func (breaker *CircuitBreaker) Succeeded() {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	if breaker.state != BreakerClosed {
+		log.Infof("Closed the circuit breaker for %s notifier endpoint %s", breaker.notifier, breaker.endpoint)
+	}
+	breaker.state = BreakerClosed
+	breaker.failures = 0
+}
+
+func (breaker *CircuitBreaker) Failed(err string) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	breaker.failures++
+	breaker.lastError = err
+	breaker.lastFailure = time.Now()
+	if (breaker.state == BreakerHalfOpen) || ((breaker.state == BreakerClosed) && (breaker.failures >= breaker.threshold)) {
+		breaker.state = BreakerOpen
+		breaker.openedAt = breaker.lastFailure
+		breaker.opens++
+		log.Warnf("Opened the circuit breaker for %s notifier endpoint %s after %v failures (last: %s). Retrying in %v",
+			breaker.notifier, breaker.endpoint, breaker.failures, err, breaker.cooldown)
+	}
+}
+
+func (breaker *CircuitBreaker) Status() *BreakerStatus {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+	status := &BreakerStatus{
+		Notifier:            breaker.notifier,
+		Endpoint:            breaker.endpoint,
+		State:               breaker.state,
+		ConsecutiveFailures: breaker.failures,
+		LastError:           breaker.lastError,
+		Opens:               breaker.opens,
+	}
+	if !breaker.lastFailure.IsZero() {
+		status.LastFailure = breaker.lastFailure.UnixNano() / int64(time.Millisecond)
+	}
+	if breaker.state != BreakerClosed {
+		status.OpenedAt = breaker.openedAt.UnixNano() / int64(time.Millisecond)
+		status.RetryAt = breaker.openedAt.Add(breaker.cooldown).UnixNano() / int64(time.Millisecond)
+	}
+	return status
+}
+
+type HTTPResponseNotifiers struct {
+	Error    bool                    `json:"error"`
+	Message  string                  `json:"message"`
+	Leader   bool                    `json:"leader"`
+	Breakers []*BreakerStatus        `json:"breakers"`
+	Request  HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/notifiers, which returns whether this instance holds the notifier lock, and the circuit
+// breaker for each notifier endpoint
+func handleNotifiers(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	breakers := make([]*BreakerStatus, 0)
+	if app.HttpNotifier != nil {
+		for _, breaker := range app.HttpNotifier.breakers {
+			breakers = append(breakers, breaker.Status())
+		}
+	}
+	if app.Emailer != nil {
+		breakers = append(breakers, app.Emailer.breaker.Status())
+	}
+	sort.Slice(breakers, func(i, j int) bool {
+		if breakers[i].Notifier != breakers[j].Notifier {
+			return breakers[i].Notifier < breakers[j].Notifier
+		}
+		return breakers[i].Endpoint < breakers[j].Endpoint
+	})
+
+	jsonStr, err := json.Marshal(HTTPResponseNotifiers{
+		Error:    false,
+		Message:  "notifier status returned",
+		Leader:   atomic.LoadInt32(&app.notifierLeader) == 1,
+		Breakers: breakers,
+		Request:  makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
@@ -0,0 +1,224 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exactly-once sink connectors keep their progress in the transactions that write to the sink, and only commit
+// offsets to Kafka now and then, so going by their commits they look stopped most of the time. Many of them also
+// write checkpoint markers to a topic as they go. The checkpoint source reads those markers for a cluster and sends
+// them to the storage module as commits, so the group's progress is what the sink has actually written
+type CheckpointClient struct {
+	app                *ApplicationContext
+	cluster            string
+	client             sarama.Client
+	masterConsumer     sarama.Consumer
+	partitionConsumers []sarama.PartitionConsumer
+	wg                 sync.WaitGroup
+	offsetChannel      chan *storage.PartitionOffset
+	activity           activityTimer
+}
+
+// The formats of checkpoint markers
+//   - json: an object with the group, topic, partition, and offset in the configured fields
+//   - text: "group topic partition offset" separated by whitespace. The group can be left off if the group is set
+//     in the config
+const (
+	CheckpointFormatJSON = "json"
+	CheckpointFormatText = "text"
+)
+
+func init() {
+	RegisterOffsetSource("checkpoint", &OffsetSourceModule{
+		Clusters: func(config *BurrowConfig) []string {
+			clusters := make([]string, 0, len(config.Checkpoint))
+			for cluster := range config.Checkpoint {
+				clusters = append(clusters, cluster)
+			}
+			return clusters
+		},
+		New: func(app *ApplicationContext, cluster string) (OffsetSource, error) {
+			return NewCheckpointClient(app, cluster)
+		},
+	})
+}
+
+func NewCheckpointClient(app *ApplicationContext, cluster string) (*CheckpointClient, error) {
+	sclient, err := sarama.NewClient(app.Config.Kafka[cluster].Brokers, newSaramaConfig(app, cluster))
+	if err != nil {
+		return nil, err
+	}
+	master, err := sarama.NewConsumerFromClient(sclient)
+	if err != nil {
+		sclient.Close()
+		return nil, err
+	}
+
+	return &CheckpointClient{
+		app:            app,
+		cluster:        cluster,
+		client:         sclient,
+		masterConsumer: master,
+	}, nil
+}
+
+// Consume every partition of the checkpoint topics from the newest offset. Markers written before Burrow started are
+// not read, the same as for the offsets topic
+func (client *CheckpointClient) Start(offsets chan *storage.PartitionOffset) error {
+	client.offsetChannel = offsets
+	client.activity.touch()
+
+	for _, topic := range client.app.Config.Checkpoint[client.cluster].Topics {
+		partitions, err := client.client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		log.Infof("Starting consumers for %v partitions of checkpoint topic %s in cluster %s", len(partitions), topic, client.cluster)
+		for _, partition := range partitions {
+			pconsumer, err := client.masterConsumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return err
+			}
+			client.partitionConsumers = append(client.partitionConsumers, pconsumer)
+			client.wg.Add(2)
+			go func() {
+				defer client.wg.Done()
+				for msg := range pconsumer.Messages() {
+					client.activity.touch()
+					client.processCheckpoint(msg)
+				}
+			}()
+			go func() {
+				defer client.wg.Done()
+				for err := range pconsumer.Errors() {
+					log.Errorf("Consume error on checkpoint topic %s:%v: %v", err.Topic, err.Partition, err.Err)
+				}
+			}()
+		}
+	}
+	return nil
+}
+
+func (client *CheckpointClient) Stop() {
+	for _, pconsumer := range client.partitionConsumers {
+		pconsumer.AsyncClose()
+	}
+	client.wg.Wait()
+
+	client.masterConsumer.Close()
+	client.client.Close()
+}
+
+func (client *CheckpointClient) Hosts() []string {
+	hosts := append([]string{}, client.app.Config.Kafka[client.cluster].Brokers...)
+	for _, broker := range client.client.Brokers() {
+		hosts = append(hosts, broker.Addr())
+	}
+	return hosts
+}
+
+func (client *CheckpointClient) Activity() map[string]time.Time {
+	return map[string]time.Time{"checkpoints": client.activity.time()}
+}
+
+func (client *CheckpointClient) processCheckpoint(msg *sarama.ConsumerMessage) {
+	cfg := client.app.Config.Checkpoint[client.cluster]
+	partitionOffset, err := parseCheckpoint(cfg, msg.Value)
+	if err != nil {
+		log.Warnf("Failed to decode checkpoint %s:%v offset %v: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		return
+	}
+	partitionOffset.Cluster = client.cluster
+	partitionOffset.Timestamp = msg.Timestamp.UnixNano() / int64(time.Millisecond)
+	if msg.Timestamp.IsZero() {
+		// Brokers before 0.10 don't keep message timestamps
+		partitionOffset.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	timeoutSendOffset(client.offsetChannel, partitionOffset, 1)
+}
+
+// Parse a checkpoint marker into the commit it stands for. The cluster and timestamp are not set
+func parseCheckpoint(cfg *CheckpointConfig, value []byte) (*storage.PartitionOffset, error) {
+	var group, topic, partition, offset string
+	switch cfg.Format {
+	case CheckpointFormatJSON:
+		fields := make(map[string]interface{})
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&fields); err != nil {
+			return nil, err
+		}
+		group = checkpointField(fields, cfg.GroupField)
+		topic = checkpointField(fields, cfg.TopicField)
+		partition = checkpointField(fields, cfg.PartitionField)
+		offset = checkpointField(fields, cfg.OffsetField)
+	case CheckpointFormatText:
+		fields := strings.Fields(string(value))
+		switch len(fields) {
+		case 3:
+			topic, partition, offset = fields[0], fields[1], fields[2]
+		case 4:
+			group, topic, partition, offset = fields[0], fields[1], fields[2], fields[3]
+		default:
+			return nil, fmt.Errorf("expected 3 or 4 fields, got %v", len(fields))
+		}
+	}
+
+	if group == "" {
+		group = cfg.Group
+	}
+	if (group == "") || (topic == "") {
+		return nil, errors.New("no group or topic")
+	}
+	partitionID, err := strconv.ParseInt(partition, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad partition %q", partition)
+	}
+	offsetValue, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad offset %q", offset)
+	}
+	if cfg.LastWritten {
+		// The marker has the offset of the last message written, and a commit is the offset of the next one to read
+		offsetValue++
+	}
+
+	return &storage.PartitionOffset{
+		Topic:     topic,
+		Partition: int32(partitionID),
+		Group:     group,
+		Offset:    offsetValue,
+		Source:    storage.OffsetSourceCheckpoint,
+	}, nil
+}
+
+// Return a field of a JSON checkpoint as a string, or an empty string if it's missing or isn't a string or number
+func checkpointField(fields map[string]interface{}, name string) string {
+	switch value := fields[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
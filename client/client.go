@@ -0,0 +1,343 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+// Package client is a Go client for the Burrow v2 HTTP API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	// The base URL for the Burrow server, such as http://burrow.example.com:8000
+	BaseURL string
+
+	// The HTTP client to use for requests
+	HTTPClient *http.Client
+
+	// How many times to retry a request that fails with a network error or a 5xx response, and how long to wait
+	// before the first retry. The wait doubles for each retry after that
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// An error response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("burrow returned %v: %s", e.StatusCode, e.Message)
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		Retries:      3,
+		RetryBackoff: 500 * time.Millisecond,
+	}
+}
+
+// Make a request to the API, retrying if needed, and decode the response into result
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, result interface{}) error {
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		if bodyBytes, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	requestURL := c.BaseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.doOnce(ctx, method, requestURL, bodyBytes, result)
+		if (err == nil) || (attempt >= c.Retries) || (!retryable(err)) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, requestURL string, body []byte, result interface{}) error {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, requestURL, bodyReader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// The gate endpoint returns a full response with a 412 when the group does not pass
+	if (resp.StatusCode != http.StatusOK) && (resp.StatusCode != http.StatusPreconditionFailed) {
+		var errResponse Response
+		if json.Unmarshal(respBody, &errResponse) == nil && (errResponse.Message != "") {
+			return &APIError{StatusCode: resp.StatusCode, Message: errResponse.Message}
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+// Network errors and server errors are worth retrying. Anything else (such as a 404) will just fail again
+func retryable(err error) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.StatusCode >= 500
+	}
+	if (err == context.Canceled) || (err == context.DeadlineExceeded) {
+		return false
+	}
+	_, isJSONError := err.(*json.SyntaxError)
+	return !isJSONError
+}
+
+func (c *Client) ClusterList(ctx context.Context) ([]string, error) {
+	var result ClusterListResponse
+	if err := c.do(ctx, "GET", "/v2/kafka", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Clusters, nil
+}
+
+// Return the clusters with counts of what is stored for each, all taken at the same time. The counts are nil if
+// Burrow was too busy to count
+func (c *Client) ClusterCounts(ctx context.Context) (map[string]*ClusterCounts, error) {
+	var result ClusterListResponse
+	if err := c.do(ctx, "GET", "/v2/kafka", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Counts, nil
+}
+
+func (c *Client) ConsumerList(ctx context.Context, cluster string) ([]string, error) {
+	var result ConsumerListResponse
+	if err := c.do(ctx, "GET", "/v2/kafka/"+url.PathEscape(cluster)+"/consumer", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Consumers, nil
+}
+
+func (c *Client) TopicList(ctx context.Context, cluster string) ([]string, error) {
+	var result TopicListResponse
+	if err := c.do(ctx, "GET", "/v2/kafka/"+url.PathEscape(cluster)+"/topic", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Topics, nil
+}
+
+// Return the head offsets for each partition of a topic
+func (c *Client) TopicOffsets(ctx context.Context, cluster string, topic string) ([]int64, error) {
+	var result TopicDetailResponse
+	if err := c.do(ctx, "GET", "/v2/kafka/"+url.PathEscape(cluster)+"/topic/"+url.PathEscape(topic), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Offsets, nil
+}
+
+// Return the status of a consumer group, with only the partitions that are not OK
+func (c *Client) ConsumerStatus(ctx context.Context, cluster string, group string) (*ConsumerGroupStatus, error) {
+	return c.consumerStatus(ctx, cluster, group, "status")
+}
+
+// Return the status of a consumer group, with all partitions
+func (c *Client) ConsumerLag(ctx context.Context, cluster string, group string) (*ConsumerGroupStatus, error) {
+	return c.consumerStatus(ctx, cluster, group, "lag")
+}
+
+func (c *Client) consumerStatus(ctx context.Context, cluster string, group string, endpoint string) (*ConsumerGroupStatus, error) {
+	var result ConsumerStatusResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/" + endpoint
+	if err := c.do(ctx, "GET", path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Status, nil
+}
+
+// Return the status of a consumer group, with all partitions, as it was at a past time. This is evaluated from the
+// offset archive on the server, so it only goes back as far as the archive retention
+func (c *Client) ConsumerLagAt(ctx context.Context, cluster string, group string, at time.Time) (*ConsumerGroupStatus, error) {
+	var result ConsumerStatusResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/lag"
+	query := url.Values{"at": []string{strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)}}
+	if err := c.do(ctx, "GET", path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Status, nil
+}
+
+// Return the consumer group's lag and status rolled up by the topic groups configured on the server
+func (c *Client) ConsumerRollup(ctx context.Context, cluster string, group string) (*ConsumerRollupResponse, error) {
+	var result ConsumerRollupResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/rollup"
+	if err := c.do(ctx, "GET", path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Return the key/values that have been set on a consumer group, such as its owner
+func (c *Client) ConsumerMetadata(ctx context.Context, cluster string, group string) (map[string]string, error) {
+	var result ConsumerMetadataResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/metadata"
+	if err := c.do(ctx, "GET", path, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Metadata, nil
+}
+
+// Replace the key/values set on a consumer group. They are returned in its status, and an empty map removes them
+func (c *Client) SetConsumerMetadata(ctx context.Context, cluster string, group string, metadata map[string]string) error {
+	var result ConsumerMetadataResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/metadata"
+	return c.do(ctx, "PUT", path, nil, metadata, &result)
+}
+
+// Return the statuses for a list of consumer groups in one request
+func (c *Client) ConsumerStatusBatch(ctx context.Context, cluster string, groups []string) ([]*ConsumerGroupStatus, error) {
+	var result ConsumerStatusBatchResponse
+	if err := c.do(ctx, "POST", "/v2/kafka/"+url.PathEscape(cluster)+"/consumer-status", nil, groups, &result); err != nil {
+		return nil, err
+	}
+	return result.Status, nil
+}
+
+// Return the offsets the group had committed for a topic as of the given time
+func (c *Client) OffsetHistory(ctx context.Context, cluster string, group string, topic string, at time.Time) ([]*ArchivedOffset, error) {
+	var result OffsetHistoryResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/topic/" + url.PathEscape(topic) + "/history"
+	query := url.Values{"timestamp": []string{strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)}}
+	if err := c.do(ctx, "GET", path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Offsets, nil
+}
+
+// Return how far the group's committed offsets advanced between two times
+func (c *Client) OffsetDelta(ctx context.Context, cluster string, group string, start time.Time, end time.Time) (*OffsetDeltaResponse, error) {
+	var result OffsetDeltaResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/delta"
+	query := url.Values{
+		"start": []string{strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10)},
+		"end":   []string{strconv.FormatInt(end.UnixNano()/int64(time.Millisecond), 10)},
+	}
+	if err := c.do(ctx, "GET", path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Check a consumer group against the deployment gate. A negative maxLag means no lag limit, and an empty maxStatus
+// uses the server default (OK)
+func (c *Client) Gate(ctx context.Context, cluster string, group string, maxLag int64, maxStatus string) (*ConsumerGateResponse, error) {
+	var result ConsumerGateResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/gate"
+	query := url.Values{}
+	if maxLag >= 0 {
+		query.Set("max-lag", strconv.FormatInt(maxLag, 10))
+	}
+	if maxStatus != "" {
+		query.Set("max-status", maxStatus)
+	}
+	if err := c.do(ctx, "GET", path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Return the lag and rates of a consumer group, and how many replicas it needs to catch up within target. replicas is
+// the current number of consumers. A target of 0 uses the server default
+func (c *Client) ScaleHint(ctx context.Context, cluster string, group string, target time.Duration, replicas int) (*ScaleHintResponse, error) {
+	var result ScaleHintResponse
+	path := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/scale-hint"
+	query := url.Values{"replicas": []string{strconv.Itoa(replicas)}}
+	if target > 0 {
+		query.Set("target", target.String())
+	}
+	if err := c.do(ctx, "GET", path, query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Return the n most lagging partitions across all groups in the cluster, from the server's last background evaluation
+func (c *Client) MaxLag(ctx context.Context, cluster string, n int) (*MaxLagResponse, error) {
+	var result MaxLagResponse
+	query := url.Values{"n": []string{strconv.Itoa(n)}}
+	if err := c.do(ctx, "GET", "/v2/kafka/"+url.PathEscape(cluster)+"/maxlag", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Return the distribution of group total lag and partition lag in the cluster, from the server's last background
+// evaluation
+func (c *Client) LagPercentiles(ctx context.Context, cluster string) (*LagPercentilesResponse, error) {
+	var result LagPercentilesResponse
+	if err := c.do(ctx, "GET", "/v2/kafka/"+url.PathEscape(cluster)+"/lag-percentiles", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Return the startup phase of each module. The instance is giving Complete evaluations once the phase is "warmed"
+func (c *Client) Startup(ctx context.Context) (*StartupResponse, error) {
+	var result StartupResponse
+	if err := c.do(ctx, "GET", "/v2/burrow/startup", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Return the result of the server's last health check, and the limits each cluster has reached
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var result HealthResponse
+	if err := c.do(ctx, "GET", "/v2/burrow/health", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,307 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package client
+
+import (
+	"encoding/json"
+)
+
+// These types mirror the JSON returned by the Burrow v2 HTTP API. The server's types are checked against these in the
+// server tests, so a field that is added or renamed on one side but not the other will fail the build
+
+type StatusConstant int
+
+const (
+	StatusNotFound  StatusConstant = 0
+	StatusOK        StatusConstant = 1
+	StatusWarning   StatusConstant = 2
+	StatusError     StatusConstant = 3
+	StatusStop      StatusConstant = 4
+	StatusStall     StatusConstant = 5
+	StatusRewind    StatusConstant = 6
+	StatusRetention StatusConstant = 7
+	StatusWarming   StatusConstant = 8
+	StatusOffline   StatusConstant = 9
+)
+
+var StatusStrings = [...]string{"NOTFOUND", "OK", "WARN", "ERR", "STOP", "STALL", "REWIND", "RETENTION", "WARMING",
+	"PARTITION_OFFLINE"}
+
+func (c StatusConstant) String() string {
+	if (c >= 0) && (c < StatusConstant(len(StatusStrings))) {
+		return StatusStrings[c]
+	} else {
+		return "UNKNOWN"
+	}
+}
+func (c StatusConstant) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+func (c StatusConstant) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+func (c *StatusConstant) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	for i, name := range StatusStrings {
+		if name == str {
+			*c = StatusConstant(i)
+			return nil
+		}
+	}
+	*c = StatusConstant(-1)
+	return nil
+}
+
+type ConsumerOffset struct {
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+	Lag       int64  `json:"lag"`
+	Time      string `json:"time,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
+
+type PartitionStatus struct {
+	Topic           string         `json:"topic"`
+	Partition       int32          `json:"partition"`
+	Status          StatusConstant `json:"status"`
+	Start           ConsumerOffset `json:"start"`
+	End             ConsumerOffset `json:"end"`
+	TimeToRetention int64          `json:"time_to_retention"`
+	Compacted       bool           `json:"compacted"`
+	Priority        bool           `json:"priority,omitempty"`
+
+	// The partition's leader changed during the window that was evaluated, so a bad status may be from the failover
+	RecentLeaderChange bool `json:"recent_leader_change"`
+
+	// The member of the group the partition is assigned to, if it is known
+	Owner *PartitionOwner `json:"owner,omitempty"`
+}
+
+// The member of a group that a partition is assigned to, from the group's metadata in the offsets topic
+type PartitionOwner struct {
+	MemberId   string `json:"member_id"`
+	ClientId   string `json:"client_id"`
+	ClientHost string `json:"client_host"`
+}
+
+type ConsumerGroupStatus struct {
+	Cluster          string              `json:"cluster"`
+	Group            string              `json:"group"`
+	Status           StatusConstant      `json:"status"`
+	Complete         bool                `json:"complete"`
+	Partitions       []*PartitionStatus  `json:"partitions"`
+	TotalPartitions  int                 `json:"partition_count"`
+	Maxlag           *PartitionStatus    `json:"maxlag"`
+	TotalLag         uint64              `json:"totallag"`
+	Expected         bool                `json:"expected"`
+	Missing          bool                `json:"missing"`
+	MissingTopics    []string            `json:"missing_topics"`
+	MissedWindow     int64               `json:"missed_window,omitempty"`
+	Tags             map[string]string   `json:"tags,omitempty"`
+	Metadata         map[string]string   `json:"metadata,omitempty"`
+	PausedAt         int64               `json:"paused_at,omitempty"`
+	WarmingUntil     int64               `json:"warming_until,omitempty"`
+	PausedAtTime     string              `json:"paused_at_time,omitempty"`
+	WarmingUntilTime string              `json:"warming_until_time,omitempty"`
+	DeadLetter       []*DeadLetterStatus `json:"dead_letter,omitempty"`
+	Trace            []string            `json:"trace,omitempty"`
+}
+
+type DeadLetterStatus struct {
+	Topic   string  `json:"topic"`
+	Rate    float64 `json:"rate"`
+	MaxRate float64 `json:"max_rate,omitempty"`
+	Spiking bool    `json:"spiking"`
+}
+
+type ArchivedOffset struct {
+	Offset    int64 `json:"offset"`
+	Timestamp int64 `json:"timestamp"`
+	Lag       int64 `json:"lag"`
+}
+
+type RequestInfo struct {
+	URI     string `json:"url"`
+	Host    string `json:"host"`
+	Cluster string `json:"cluster"`
+	Group   string `json:"group"`
+	Topic   string `json:"topic"`
+}
+
+// The fields that are common to every response
+type Response struct {
+	Error   bool        `json:"error"`
+	Message string      `json:"message"`
+	Request RequestInfo `json:"request"`
+}
+
+type ClusterListResponse struct {
+	Response
+	Clusters      []string                     `json:"clusters"`
+	ClusterErrors map[string]map[string]string `json:"cluster_errors,omitempty"`
+	Counts        map[string]*ClusterCounts    `json:"counts,omitempty"`
+	SnapshotAt    int64                        `json:"snapshot_at,omitempty"`
+}
+
+// What Burrow has stored for a cluster. The timestamps are in milliseconds, and 0 if nothing has been stored
+type ClusterCounts struct {
+	Groups           int   `json:"groups"`
+	Topics           int   `json:"topics"`
+	Partitions       int   `json:"partitions"`
+	LastBrokerOffset int64 `json:"last_broker_offset"`
+	LastCommit       int64 `json:"last_commit"`
+}
+type ConsumerListResponse struct {
+	Response
+	Consumers []string `json:"consumers"`
+}
+type TopicListResponse struct {
+	Response
+	Topics []string `json:"topics"`
+}
+type TopicDetailResponse struct {
+	Response
+	Offsets       []int64 `json:"offsets"`
+	StableOffsets []int64 `json:"stable_offsets,omitempty"`
+}
+type ConsumerStatusResponse struct {
+	Response
+	Status ConsumerGroupStatus `json:"status"`
+}
+type ConsumerStatusBatchResponse struct {
+	Response
+	Status []*ConsumerGroupStatus `json:"status"`
+}
+type OffsetHistoryResponse struct {
+	Response
+	Timestamp int64             `json:"timestamp"`
+	Offsets   []*ArchivedOffset `json:"offsets"`
+}
+type OffsetDeltaResponse struct {
+	Response
+	Start  int64              `json:"start"`
+	End    int64              `json:"end"`
+	Topics map[string][]int64 `json:"topics"`
+	Total  int64              `json:"total"`
+}
+type ConsumerGateResponse struct {
+	Response
+	Pass     bool           `json:"pass"`
+	Reasons  []string       `json:"reasons"`
+	Status   StatusConstant `json:"status"`
+	TotalLag uint64         `json:"totallag"`
+}
+
+type WarmupStatus struct {
+	Cluster           string `json:"cluster"`
+	BrokerOffsets     bool   `json:"broker_offsets"`
+	Groups            int    `json:"groups"`
+	CompleteGroups    int    `json:"complete_groups"`
+	WarmingGroups     int    `json:"warming_groups"`
+	IdleGroups        int    `json:"idle_groups"`
+	EstimatedComplete int64  `json:"estimated_complete"`
+	Warmed            bool   `json:"warmed"`
+}
+type StartupModule struct {
+	Module  string        `json:"module"`
+	Cluster string        `json:"cluster"`
+	Phase   string        `json:"phase"`
+	Error   string        `json:"error,omitempty"`
+	Warmup  *WarmupStatus `json:"warmup,omitempty"`
+}
+type StartupResponse struct {
+	Response
+	Phase             string           `json:"phase"`
+	Uptime            int64            `json:"uptime"`
+	EstimatedComplete int64            `json:"estimated_complete"`
+	Modules           []*StartupModule `json:"modules"`
+}
+
+type LimitBreach struct {
+	Limit    string `json:"limit"`
+	Max      int    `json:"max"`
+	Current  int    `json:"current"`
+	Mode     string `json:"mode"`
+	Since    int64  `json:"since"`
+	Last     int64  `json:"last"`
+	Refused  int64  `json:"refused"`
+	Evicted  int64  `json:"evicted"`
+	Excluded int    `json:"excluded"`
+}
+type HealthResponse struct {
+	Response
+	Healthy bool                      `json:"healthy"`
+	Reasons []string                  `json:"reasons"`
+	Limits  map[string][]*LimitBreach `json:"limits"`
+}
+
+type TopicGroupStatus struct {
+	Name            string           `json:"name"`
+	Status          StatusConstant   `json:"status"`
+	Topics          []string         `json:"topics"`
+	TotalPartitions int              `json:"partition_count"`
+	TotalLag        uint64           `json:"totallag"`
+	Maxlag          *PartitionStatus `json:"maxlag"`
+}
+type ConsumerRollupResponse struct {
+	Response
+	Status   StatusConstant      `json:"status"`
+	Complete bool                `json:"complete"`
+	Rollups  []*TopicGroupStatus `json:"rollups"`
+}
+type ConsumerMetadataResponse struct {
+	Response
+	Metadata map[string]string `json:"metadata"`
+}
+
+type LaggingPartition struct {
+	Group     string         `json:"group"`
+	Topic     string         `json:"topic"`
+	Partition int32          `json:"partition"`
+	Lag       int64          `json:"lag"`
+	Status    StatusConstant `json:"status"`
+}
+type ScaleHintResponse struct {
+	Response
+	Status              StatusConstant `json:"status"`
+	TotalLag            uint64         `json:"totallag"`
+	ConsumeRate         float64        `json:"consume_rate"`
+	ProduceRate         float64        `json:"produce_rate"`
+	Target              int64          `json:"target"`
+	EstimatedCatchUp    int64          `json:"estimated_catch_up"`
+	Partitions          int            `json:"partition_count"`
+	Replicas            int            `json:"replicas"`
+	RecommendedReplicas int            `json:"recommended_replicas"`
+	ReplicaDelta        int            `json:"replica_delta"`
+}
+type MaxLagResponse struct {
+	Response
+	EvaluatedAt int64               `json:"evaluated_at"`
+	Partitions  []*LaggingPartition `json:"partitions"`
+}
+
+// Nearest-rank percentiles of a set of lags
+type LagDistribution struct {
+	Count int   `json:"count"`
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+	Max   int64 `json:"max"`
+}
+type LagPercentilesResponse struct {
+	Response
+	EvaluatedAt  int64            `json:"evaluated_at"`
+	GroupLag     *LagDistribution `json:"group_lag"`
+	PartitionLag *LagDistribution `json:"partition_lag"`
+}
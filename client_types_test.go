@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/linkedin/burrow/client"
+	"github.com/linkedin/burrow/storage"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Get the JSON field names for a struct type, including the fields of embedded structs
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			names = append(names, jsonFieldNames(field.Type)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			// Unexported
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The client package has its own copies of the API types. Make sure they haven't drifted from the server's
+func Test_clientTypesMatchServer(t *testing.T) {
+	pairs := []struct {
+		server interface{}
+		client interface{}
+	}{
+		{storage.ConsumerOffset{}, client.ConsumerOffset{}},
+		{storage.PartitionStatus{}, client.PartitionStatus{}},
+		{storage.PartitionOwner{}, client.PartitionOwner{}},
+		{storage.ConsumerGroupStatus{}, client.ConsumerGroupStatus{}},
+		{storage.DeadLetterStatus{}, client.DeadLetterStatus{}},
+		{storage.ArchivedOffset{}, client.ArchivedOffset{}},
+		{storage.ClusterCounts{}, client.ClusterCounts{}},
+		{HTTPResponseRequestInfo{}, client.RequestInfo{}},
+		{HTTPResponseClusterList{}, client.ClusterListResponse{}},
+		{HTTPResponseConsumerList{}, client.ConsumerListResponse{}},
+		{HTTPResponseTopicList{}, client.TopicListResponse{}},
+		{HTTPResponseTopicDetail{}, client.TopicDetailResponse{}},
+		{HTTPResponseConsumerStatus{}, client.ConsumerStatusResponse{}},
+		{HTTPResponseConsumerStatusBatch{}, client.ConsumerStatusBatchResponse{}},
+		{HTTPResponseOffsetHistory{}, client.OffsetHistoryResponse{}},
+		{HTTPResponseOffsetDelta{}, client.OffsetDeltaResponse{}},
+		{HTTPResponseConsumerGate{}, client.ConsumerGateResponse{}},
+		{storage.WarmupStatus{}, client.WarmupStatus{}},
+		{HTTPResponseStartupModule{}, client.StartupModule{}},
+		{HTTPResponseStartup{}, client.StartupResponse{}},
+		{storage.LimitBreach{}, client.LimitBreach{}},
+		{HTTPResponseHealth{}, client.HealthResponse{}},
+		{TopicGroupStatus{}, client.TopicGroupStatus{}},
+		{HTTPResponseConsumerRollup{}, client.ConsumerRollupResponse{}},
+		{HTTPResponseGroupMetadata{}, client.ConsumerMetadataResponse{}},
+		{LaggingPartition{}, client.LaggingPartition{}},
+		{HTTPResponseMaxLag{}, client.MaxLagResponse{}},
+		{LagDistribution{}, client.LagDistribution{}},
+		{HTTPResponseLagPercentiles{}, client.LagPercentilesResponse{}},
+		{HTTPResponseScaleHint{}, client.ScaleHintResponse{}},
+	}
+
+	for _, pair := range pairs {
+		serverType := reflect.TypeOf(pair.server)
+		clientType := reflect.TypeOf(pair.client)
+		serverFields := jsonFieldNames(serverType)
+		clientFields := jsonFieldNames(clientType)
+		if !reflect.DeepEqual(serverFields, clientFields) {
+			t.Errorf("%v has fields %v, but client.%v has %v", serverType.Name(), serverFields, clientType.Name(), clientFields)
+		}
+	}
+
+	if !reflect.DeepEqual(storage.StatusStrings[:], client.StatusStrings[:]) {
+		t.Errorf("Status strings %v do not match client %v", storage.StatusStrings, client.StatusStrings)
+	}
+}
@@ -0,0 +1,172 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// A compatibility API serves the same endpoints as /v2 under another version path (such as /v1 or /v3), but rewrites
+// the JSON in the responses so that tools written for a different version of Burrow can use it. The field names can
+// be renamed or changed to camel case, and the response envelope (error, message, and request) can be removed
+type compatHandler struct {
+	name    string
+	cfg     *APICompatConfig
+	renames map[string]string
+	handler appHandler
+}
+
+// Buffers the response from the v2 handler so it can be rewritten
+type compatResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (cw *compatResponseWriter) Header() http.Header {
+	return cw.header
+}
+func (cw *compatResponseWriter) Write(data []byte) (int, error) {
+	return cw.body.Write(data)
+}
+func (cw *compatResponseWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func newCompatHandler(name string, cfg *APICompatConfig, handler appHandler) compatHandler {
+	renames := make(map[string]string, len(cfg.Rename))
+	for _, rename := range cfg.Rename {
+		// The format was already checked when the config was validated
+		parts := strings.SplitN(rename, "=", 2)
+		renames[parts[0]] = parts[1]
+	}
+	return compatHandler{
+		name:    name,
+		cfg:     cfg,
+		renames: renames,
+		handler: handler,
+	}
+}
+
+func (ch compatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Make the request look like a v2 request. Compression is turned off, since we need to rewrite the body
+	v2Request := new(http.Request)
+	*v2Request = *r
+	v2URL := *r.URL
+	v2URL.Path = "/v2" + strings.TrimPrefix(r.URL.Path, "/"+ch.name)
+	v2Request.URL = &v2URL
+	v2Request.Header = make(http.Header, len(r.Header))
+	for key, values := range r.Header {
+		v2Request.Header[key] = values
+	}
+	v2Request.Header.Del("Accept-Encoding")
+
+	recorder := &compatResponseWriter{header: w.Header()}
+	ch.handler.ServeHTTP(recorder, v2Request)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	var body []byte
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/x-ndjson") {
+		lines := bytes.Split(bytes.TrimRight(recorder.body.Bytes(), "\n"), []byte("\n"))
+		for i, line := range lines {
+			lines[i] = ch.rewrite(line)
+		}
+		body = append(bytes.Join(lines, []byte("\n")), '\n')
+	} else {
+		body = ch.rewrite(recorder.body.Bytes())
+	}
+
+	w.Header().Del("Content-Length")
+	w.WriteHeader(recorder.status)
+	w.Write(body)
+}
+
+// Rewrite a single JSON document. Anything that isn't valid JSON is passed through unchanged
+func (ch compatHandler) rewrite(data []byte) []byte {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return data
+	}
+
+	if ch.cfg.Unwrap {
+		doc = unwrapEnvelope(doc)
+	}
+	rewritten, err := json.Marshal(ch.rewriteValue(doc))
+	if err != nil {
+		return data
+	}
+	return rewritten
+}
+
+func (ch compatHandler) rewriteValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		newMap := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			newMap[ch.rewriteKey(key)] = ch.rewriteValue(val)
+		}
+		return newMap
+	case []interface{}:
+		for i, val := range v {
+			v[i] = ch.rewriteValue(val)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+func (ch compatHandler) rewriteKey(key string) string {
+	if renamed, ok := ch.renames[key]; ok {
+		return renamed
+	}
+	if ch.cfg.Casing == "camel" {
+		parts := strings.Split(key, "_")
+		for i := 1; i < len(parts); i++ {
+			if parts[i] != "" {
+				parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+			}
+		}
+		return strings.Join(parts, "")
+	}
+	return key
+}
+
+// Remove the error, message, and request fields from a successful response. If only one field is left, the
+// response is just the value of that field
+func unwrapEnvelope(doc interface{}) interface{} {
+	docMap, ok := doc.(map[string]interface{})
+	if !ok {
+		return doc
+	}
+	if isError, _ := docMap["error"].(bool); isError {
+		return doc
+	}
+
+	delete(docMap, "error")
+	delete(docMap, "message")
+	delete(docMap, "request")
+	if len(docMap) == 1 {
+		for _, value := range docMap {
+			return value
+		}
+	}
+	return docMap
+}
@@ -13,31 +13,139 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/linkedin/burrow/storage"
 	"gopkg.in/gcfg.v1"
 	"log"
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Configuration definition
 type ClientProfile struct {
-	ClientID    string `gcfg:"client-id"`
-	TLS         bool   `gcfg:"tls"`
-	TLSNoVerify bool   `gcfg:"tls-noverify"`
+	ClientID        string `gcfg:"client-id"`
+	TLS             bool   `gcfg:"tls"`
+	TLSNoVerify     bool   `gcfg:"tls-noverify"`
+	KafkaVersion    string `gcfg:"kafka-version"`
+	DialTimeout     int    `gcfg:"dial-timeout"`
+	ReadTimeout     int    `gcfg:"read-timeout"`
+	WriteTimeout    int    `gcfg:"write-timeout"`
+	Keepalive       int    `gcfg:"keepalive"`
+	MaxOpenRequests int    `gcfg:"max-open-requests"`
+}
+type ExpectedGroupConfig struct {
+	Cluster  string   `gcfg:"cluster"`
+	Group    string   `gcfg:"group"`
+	Topics   []string `gcfg:"topic"`
+	Schedule string   `gcfg:"schedule"`
+	Window   int64    `gcfg:"window"`
+}
+type CommitMappingConfig struct {
+	CommitCluster string `gcfg:"commit-cluster"`
+	DataCluster   string `gcfg:"data-cluster"`
+	Group         string `gcfg:"group"`
+}
+type PriorityTopicConfig struct {
+	Topic     string `gcfg:"topic"`
+	MaxLag    int64  `gcfg:"max-lag"`
+	StopGrace int64  `gcfg:"stop-grace"`
+}
+type DeadLetterConfig struct {
+	Topic   string  `gcfg:"topic"`
+	MaxRate float64 `gcfg:"max-rate"`
+}
+type ProduceAlertConfig struct {
+	Topics      []string `gcfg:"topic"`
+	Interval    int64    `gcfg:"interval"`
+	ZeroAfter   int64    `gcfg:"zero-after"`
+	SpikeFactor float64  `gcfg:"spike-factor"`
+	Baseline    int64    `gcfg:"baseline"`
+	Url         string   `gcfg:"url"`
+}
+type MirrorCheckConfig struct {
+	Clusters      []string `gcfg:"cluster"`
+	Topics        []string `gcfg:"topic"`
+	Group         string   `gcfg:"group"`
+	MaxDivergence int64    `gcfg:"max-divergence"`
+	Interval      int64    `gcfg:"interval"`
+	Url           string   `gcfg:"url"`
+}
+type TimestampsConfig struct {
+	Precision string `gcfg:"precision"`
+	MaxFuture int64  `gcfg:"max-future"`
+}
+type CheckpointConfig struct {
+	Topics         []string `gcfg:"topic"`
+	Format         string   `gcfg:"format"`
+	Group          string   `gcfg:"group"`
+	GroupField     string   `gcfg:"group-field"`
+	TopicField     string   `gcfg:"topic-field"`
+	PartitionField string   `gcfg:"partition-field"`
+	OffsetField    string   `gcfg:"offset-field"`
+	LastWritten    bool     `gcfg:"last-written"`
+	IgnoreCommits  string   `gcfg:"ignore-commits"`
+}
+type RollupPolicyConfig struct {
+	Group        string `gcfg:"group"`
+	Policy       string `gcfg:"policy"`
+	ErrorPercent int    `gcfg:"error-percent"`
+}
+type AdminUserConfig struct {
+	Token     string   `gcfg:"token"`
+	TokenFile string   `gcfg:"token-file"`
+	Cluster   []string `gcfg:"cluster"`
+	ReadOnly  bool     `gcfg:"read-only"`
+}
+type GroupTagsConfig struct {
+	Pattern string `gcfg:"pattern"`
+}
+type NotifierTemplateConfig struct {
+	Locale         string   `gcfg:"locale"`
+	Timezone       string   `gcfg:"timezone"`
+	EmailTemplate  string   `gcfg:"email-template"`
+	Url            string   `gcfg:"url"`
+	TemplatePost   string   `gcfg:"template-post"`
+	TemplateDelete string   `gcfg:"template-delete"`
+	Clusters       []string `gcfg:"cluster"`
+	Group          string   `gcfg:"group"`
+	Tags           []string `gcfg:"tag"`
+}
+type IgnorePartitionConfig struct {
+	Cluster    string  `gcfg:"cluster"`
+	Topic      string  `gcfg:"topic"`
+	Partitions []int32 `gcfg:"partition"`
+	Reason     string  `gcfg:"reason"`
+}
+type TopicGroupConfig struct {
+	Topics []string `gcfg:"topic"`
+}
+type APICompatConfig struct {
+	Casing string   `gcfg:"casing"`
+	Unwrap bool     `gcfg:"unwrap"`
+	Rename []string `gcfg:"rename"`
 }
 type BurrowConfig struct {
 	General struct {
-		LogDir         string `gcfg:"logdir"`
-		LogConfig      string `gcfg:"logconfig"`
-		LogToConsole   bool   `gcfg:"logtoconsole"`
-		PIDFile        string `gcfg:"pidfile"`
-		ClientID       string `gcfg:"client-id"`
-		GroupBlacklist string `gcfg:"group-blacklist"`
-		TopicBlacklist string `gcfg:"topic-blacklist"`
+		LogDir          string `gcfg:"logdir"`
+		LogConfig       string `gcfg:"logconfig"`
+		LogToConsole    bool   `gcfg:"logtoconsole"`
+		PIDFile         string `gcfg:"pidfile"`
+		ClientID        string `gcfg:"client-id"`
+		GroupBlacklist  string `gcfg:"group-blacklist"`
+		TopicBlacklist  string `gcfg:"topic-blacklist"`
+		DumpDir         string `gcfg:"dump-dir"`
+		FIPS            bool   `gcfg:"fips"`
+		AddressFamily   string `gcfg:"address-family"`
+		DisplayTimezone string `gcfg:"display-timezone"`
+		ShutdownTimeout int64  `gcfg:"shutdown-timeout"`
 	}
 	Zookeeper struct {
 		Hosts    []string `gcfg:"hostname"`
@@ -46,14 +154,29 @@ type BurrowConfig struct {
 		LockPath string   `gcfg:"lock-path"`
 	}
 	Kafka map[string]*struct {
-		Brokers       []string `gcfg:"broker"`
-		BrokerPort    int      `gcfg:"broker-port"`
-		Zookeepers    []string `gcfg:"zookeeper"`
-		ZookeeperPort int      `gcfg:"zookeeper-port"`
-		ZookeeperPath string   `gcfg:"zookeeper-path"`
-		OffsetsTopic  string   `gcfg:"offsets-topic"`
-		ZKOffsets     bool     `gcfg:"zookeeper-offsets"`
-		Clientprofile string   `gcfg:"client-profile"`
+		Brokers             []string `gcfg:"broker"`
+		BrokerPort          int      `gcfg:"broker-port"`
+		Zookeepers          []string `gcfg:"zookeeper"`
+		ZookeeperPort       int      `gcfg:"zookeeper-port"`
+		ZookeeperPath       string   `gcfg:"zookeeper-path"`
+		OffsetsTopic        string   `gcfg:"offsets-topic"`
+		ZKOffsets           bool     `gcfg:"zookeeper-offsets"`
+		Clientprofile       string   `gcfg:"client-profile"`
+		ReadCommittedGroups string   `gcfg:"read-committed-groups"`
+		Tunnel              string   `gcfg:"tunnel"`
+		TunnelKeyFile       string   `gcfg:"tunnel-key-file"`
+		TunnelKnownHosts    string   `gcfg:"tunnel-known-hosts"`
+		TunnelPasswordFile  string   `gcfg:"tunnel-password-file"`
+		MaxGroups           int      `gcfg:"max-groups"`
+		MaxTopics           int      `gcfg:"max-topics"`
+		MaxPartitions       int      `gcfg:"max-partitions"`
+		OnLimit             string   `gcfg:"on-limit"`
+		Type                string   `gcfg:"type"`
+		TestTopics          []string `gcfg:"test-topic"`
+		TestPartitions      int      `gcfg:"test-partitions"`
+		TestProduceRate     int64    `gcfg:"test-produce-rate"`
+		TestCommitInterval  int64    `gcfg:"test-commit-interval"`
+		TestGroups          []string `gcfg:"test-group"`
 	}
 	Storm map[string]*struct {
 		Zookeepers    []string `gcfg:"zookeeper"`
@@ -62,19 +185,110 @@ type BurrowConfig struct {
 	}
 	Tickers struct {
 		BrokerOffsets int `gcfg:"broker-offsets"`
+		SourceRetry   int `gcfg:"source-retry"`
 	}
 	Lagcheck struct {
-		Intervals         int   `gcfg:"intervals"`
-		MinDistance       int64 `gcfg:"min-distance"`
-		ExpireGroup       int64 `gcfg:"expire-group"`
-		ZKCheck           int64 `gcfg:"zookeeper-interval"`
-		ZKGroupRefresh    int64 `gcfg:"zk-group-refresh"`
-		StormCheck        int64 `gcfg:"storm-interval"`
-		StormGroupRefresh int64 `gcfg:"storm-group-refresh"`
+		Intervals          int      `gcfg:"intervals"`
+		BrokerIntervals    int      `gcfg:"broker-intervals"`
+		MinDistance        int64    `gcfg:"min-distance"`
+		ExpireGroup        int64    `gcfg:"expire-group"`
+		ZKCheck            int64    `gcfg:"zookeeper-interval"`
+		ZKGroupRefresh     int64    `gcfg:"zk-group-refresh"`
+		StormCheck         int64    `gcfg:"storm-interval"`
+		StormGroupRefresh  int64    `gcfg:"storm-group-refresh"`
+		DroppedOffsets     int      `gcfg:"dropped-offsets"`
+		DropLogInterval    int64    `gcfg:"drop-log-interval"`
+		DropLogSample      int      `gcfg:"drop-log-sample"`
+		ClockSource        string   `gcfg:"clock-source"`
+		ClockSkewWarning   int64    `gcfg:"clock-skew-warning"`
+		RetentionRisk      int64    `gcfg:"retention-risk"`
+		CompactedTopics    string   `gcfg:"compacted-topics"`
+		TopicConfigRefresh int64    `gcfg:"topic-config-refresh"`
+		TombstoneRetention int64    `gcfg:"tombstone-retention"`
+		EphemeralGroups    string   `gcfg:"ephemeral-groups"`
+		EphemeralGroup     []string `gcfg:"ephemeral-group"`
+		EphemeralGroupName string   `gcfg:"ephemeral-group-name"`
+		EphemeralExpire    int64    `gcfg:"ephemeral-expire"`
+		BurnIn             int64    `gcfg:"burn-in"`
+		EvaluationWorkers  int      `gcfg:"evaluation-workers"`
+		CatchUpBacklog     int      `gcfg:"catch-up-backlog"`
+		PriorityExpire     int64    `gcfg:"priority-group-expire"`
+	}
+	Archive struct {
+		Retention int64 `gcfg:"retention"`
+		Interval  int64 `gcfg:"interval"`
+	}
+	Storage struct {
+		Backend              string `gcfg:"backend"`
+		Path                 string `gcfg:"path"`
+		Codec                string `gcfg:"codec"`
+		CheckpointInterval   int64  `gcfg:"checkpoint-interval"`
+		ObjectStoreUrl       string `gcfg:"object-store-url"`
+		ObjectStorePrefix    string `gcfg:"object-store-prefix"`
+		ObjectStoreRegion    string `gcfg:"object-store-region"`
+		ObjectStoreAccessKey string `gcfg:"object-store-access-key"`
+		ObjectStoreSecretKey string `gcfg:"object-store-secret-key"`
+		UploadInterval       int64  `gcfg:"upload-interval"`
+		UploadRetention      int64  `gcfg:"upload-retention"`
+	}
+	Audit struct {
+		File         string `gcfg:"file"`
+		MaxSize      int64  `gcfg:"max-size"`
+		MaxBackups   int    `gcfg:"max-backups"`
+		KafkaCluster string `gcfg:"kafka-cluster"`
+		KafkaTopic   string `gcfg:"kafka-topic"`
+		AdminFile    string `gcfg:"admin-file"`
+	}
+	Export struct {
+		KafkaCluster  string `gcfg:"kafka-cluster"`
+		KafkaTopic    string `gcfg:"kafka-topic"`
+		Stream        bool   `gcfg:"stream"`
+		BrokerOffsets bool   `gcfg:"broker-offsets"`
+	}
+	Bootstrap struct {
+		Peer      string   `gcfg:"peer"`
+		Token     string   `gcfg:"token"`
+		TokenFile string   `gcfg:"token-file"`
+		Cluster   []string `gcfg:"cluster"`
+		Timeout   int64    `gcfg:"timeout"`
+	}
+	Sample struct {
+		Prefix   string `gcfg:"prefix"`
+		Every    int64  `gcfg:"every"`
+		Interval int64  `gcfg:"interval"`
+		MaxSize  int64  `gcfg:"max-size"`
+		MaxFiles int    `gcfg:"max-files"`
+	}
+	Encryption struct {
+		KeyFile       string `gcfg:"key-file"`
+		KeyEnv        string `gcfg:"key-env"`
+		VaultAddress  string `gcfg:"vault-address"`
+		VaultPath     string `gcfg:"vault-path"`
+		VaultField    string `gcfg:"vault-field"`
+		VaultTokenEnv string `gcfg:"vault-token-env"`
+	}
+	Validation struct {
+		Interval int64 `gcfg:"interval"`
+		Groups   int   `gcfg:"groups"`
+	}
+	Evaluator struct {
+		Interval int64 `gcfg:"interval"`
+	}
+	Watchdog struct {
+		Timeout    int64 `gcfg:"timeout"`
+		MaxBackoff int64 `gcfg:"max-backoff"`
+	}
+	Dns struct {
+		Ttl int64 `gcfg:"ttl"`
 	}
 	Httpserver struct {
-		Enable bool `gcfg:"server"`
-		Port   int  `gcfg:"port"`
+		Enable            bool   `gcfg:"server"`
+		Address           string `gcfg:"address"`
+		Port              int    `gcfg:"port"`
+		OverloadThreshold int    `gcfg:"overload-threshold"`
+		StorageTimeout    int64  `gcfg:"storage-timeout"`
+		RetryAfter        int    `gcfg:"retry-after"`
+		RequireToken      bool   `gcfg:"require-token"`
 	}
 	Smtp struct {
 		Server   string `gcfg:"server"`
@@ -84,12 +298,18 @@ type BurrowConfig struct {
 		Password string `gcfg:"password"`
 		From     string `gcfg:"from"`
 		Template string `gcfg:"template"`
+		Timeout  int    `gcfg:"timeout"`
 	}
 	Email map[string]*struct {
-		Groups    []string `gcfg:"group"`
-		Interval  int      `gcfg:"interval"`
-		Threshold string   `gcfg:"threhsold"`
-		Warning bool `gcfg:"warning"`
+		Groups        []string `gcfg:"group"`
+		Interval      int      `gcfg:"interval"`
+		Threshold     string   `gcfg:"threhsold"`
+		Template      string   `gcfg:"template"`
+		Warning       bool     `gcfg:"warning"`
+		QuietSchedule string   `gcfg:"quiet-schedule"`
+		QuietDuration int64    `gcfg:"quiet-duration"`
+		QuietWeekends bool     `gcfg:"quiet-weekends"`
+		Timezone      string   `gcfg:"timezone"`
 	}
 	Httpnotifier struct {
 		Url            string   `gcfg:"url"`
@@ -101,8 +321,70 @@ type BurrowConfig struct {
 		PostThreshold  int      `gcfg:"post-threshold"`
 		Timeout        int      `gcfg:"timeout"`
 		Keepalive      int      `gcfg:"keepalive"`
+		ConnectTimeout int      `gcfg:"connect-timeout"`
+		IdleTimeout    int      `gcfg:"idle-timeout"`
+		MaxIdle        int      `gcfg:"max-idle"`
+		MaxIdlePerHost int      `gcfg:"max-idle-per-host"`
+		SecondaryUrl   string   `gcfg:"secondary-url"`
+		QuietSchedule  string   `gcfg:"quiet-schedule"`
+		QuietDuration  int64    `gcfg:"quiet-duration"`
+		QuietWeekends  bool     `gcfg:"quiet-weekends"`
+		Timezone       string   `gcfg:"timezone"`
+	}
+	Health struct {
+		Interval      int64  `gcfg:"interval"`
+		File          string `gcfg:"file"`
+		ConsulAddress string `gcfg:"consul-address"`
+		ConsulCheckId string `gcfg:"consul-check-id"`
+		PingUrl       string `gcfg:"ping-url"`
+		RequireWarmed bool   `gcfg:"require-warmed"`
+	}
+	Keda struct {
+		Address  string `gcfg:"address"`
+		Port     int    `gcfg:"port"`
+		CertFile string `gcfg:"cert-file"`
+		KeyFile  string `gcfg:"key-file"`
 	}
-	Clientprofile map[string]*ClientProfile
+	StaleConfig struct {
+		Days     int64 `gcfg:"days"`
+		Interval int64 `gcfg:"interval"`
+	} `gcfg:"stale-config"`
+	Accounting struct {
+		Enable    bool   `gcfg:"enable"`
+		TeamTag   string `gcfg:"team-tag"`
+		Days      int    `gcfg:"days"`
+		StateFile string `gcfg:"state-file"`
+		CsvDir    string `gcfg:"csv-dir"`
+	}
+	TrendReport struct {
+		Schedule  string   `gcfg:"schedule"`
+		Timezone  string   `gcfg:"timezone"`
+		StateFile string   `gcfg:"state-file"`
+		Email     []string `gcfg:"email"`
+		Url       string   `gcfg:"url"`
+		SlackUrl  string   `gcfg:"slack-url"`
+		Top       int      `gcfg:"top"`
+	} `gcfg:"trend-report"`
+	Notifier struct {
+		BreakerFailures int   `gcfg:"breaker-failures"`
+		BreakerCooldown int64 `gcfg:"breaker-cooldown"`
+	}
+	Clientprofile    map[string]*ClientProfile
+	ExpectedGroup    map[string]*ExpectedGroupConfig    `gcfg:"expected-group"`
+	CommitMapping    map[string]*CommitMappingConfig    `gcfg:"commit-mapping"`
+	TopicGroup       map[string]*TopicGroupConfig       `gcfg:"topic-group"`
+	PriorityTopic    map[string]*PriorityTopicConfig    `gcfg:"priority-topic"`
+	RollupPolicy     map[string]*RollupPolicyConfig     `gcfg:"rollup-policy"`
+	DeadLetter       map[string]*DeadLetterConfig       `gcfg:"dead-letter"`
+	ProduceAlert     map[string]*ProduceAlertConfig     `gcfg:"produce-alert"`
+	MirrorCheck      map[string]*MirrorCheckConfig      `gcfg:"mirror-check"`
+	Checkpoint       map[string]*CheckpointConfig       `gcfg:"checkpoint"`
+	Timestamps       map[string]*TimestampsConfig       `gcfg:"timestamps"`
+	IgnorePartition  map[string]*IgnorePartitionConfig  `gcfg:"ignore-partition"`
+	GroupTags        map[string]*GroupTagsConfig        `gcfg:"group-tags"`
+	AdminUser        map[string]*AdminUserConfig        `gcfg:"admin-user"`
+	NotifierTemplate map[string]*NotifierTemplateConfig `gcfg:"notifier-template"`
+	Api              map[string]*APICompatConfig        `gcfg:"api"`
 }
 
 func ReadConfig(cfgFile string) *BurrowConfig {
@@ -119,6 +401,133 @@ func ReadConfig(cfgFile string) *BurrowConfig {
 	return &cfg
 }
 
+// Return the location that times are shown in, in status results and notifications. The config must have been validated
+func displayLocation(cfg *BurrowConfig) *time.Location {
+	if cfg.General.DisplayTimezone == "" {
+		return time.Local
+	}
+	location, _ := time.LoadLocation(cfg.General.DisplayTimezone)
+	return location
+}
+
+// Build the configuration for the storage module from the Burrow config. This must be called after the config is
+// validated, as that sets the defaults
+func StorageConfig(cfg *BurrowConfig) *storage.Config {
+	storageConfig := &storage.Config{
+		Clusters:            make(map[string]*storage.ClusterConfig, len(cfg.Kafka)),
+		GroupBlacklist:      cfg.General.GroupBlacklist,
+		TopicBlacklist:      cfg.General.TopicBlacklist,
+		Intervals:           cfg.Lagcheck.Intervals,
+		BrokerIntervals:     cfg.Lagcheck.BrokerIntervals,
+		MinDistance:         cfg.Lagcheck.MinDistance,
+		ExpireGroup:         cfg.Lagcheck.ExpireGroup,
+		DroppedOffsets:      cfg.Lagcheck.DroppedOffsets,
+		DropLogInterval:     cfg.Lagcheck.DropLogInterval,
+		DropLogSample:       cfg.Lagcheck.DropLogSample,
+		ClockSource:         cfg.Lagcheck.ClockSource,
+		ClockSkewWarning:    cfg.Lagcheck.ClockSkewWarning,
+		DisplayLocation:     displayLocation(cfg),
+		RetentionRisk:       cfg.Lagcheck.RetentionRisk,
+		TombstoneRetention:  cfg.Lagcheck.TombstoneRetention,
+		EphemeralMode:       cfg.Lagcheck.EphemeralGroups,
+		EphemeralGroups:     cfg.Lagcheck.EphemeralGroup,
+		EphemeralGroupName:  cfg.Lagcheck.EphemeralGroupName,
+		EphemeralExpire:     cfg.Lagcheck.EphemeralExpire,
+		BurnIn:              cfg.Lagcheck.BurnIn,
+		EvaluationWorkers:   cfg.Lagcheck.EvaluationWorkers,
+		CatchUpBacklog:      cfg.Lagcheck.CatchUpBacklog,
+		PriorityGroupExpire: cfg.Lagcheck.PriorityExpire,
+		CompactedTopics:     cfg.Lagcheck.CompactedTopics,
+		ArchiveRetention:    cfg.Archive.Retention,
+		ArchiveInterval:     cfg.Archive.Interval,
+		CheckpointInterval:  cfg.Storage.CheckpointInterval,
+		ExpectedGroups:      make([]*storage.ExpectedGroupConfig, 0, len(cfg.ExpectedGroup)),
+	}
+	for cluster, kafkaConfig := range cfg.Kafka {
+		storageConfig.Clusters[cluster] = &storage.ClusterConfig{
+			ReadCommittedGroups: kafkaConfig.ReadCommittedGroups,
+			MaxGroups:           kafkaConfig.MaxGroups,
+			MaxTopics:           kafkaConfig.MaxTopics,
+			MaxPartitions:       kafkaConfig.MaxPartitions,
+			LimitMode:           kafkaConfig.OnLimit,
+		}
+	}
+	// Sort the priority topics by name, so that the first match for a topic is always the same one
+	priorityNames := make([]string, 0, len(cfg.PriorityTopic))
+	for name := range cfg.PriorityTopic {
+		priorityNames = append(priorityNames, name)
+	}
+	sort.Strings(priorityNames)
+	for _, name := range priorityNames {
+		storageConfig.PriorityTopics = append(storageConfig.PriorityTopics, &storage.PriorityTopicConfig{
+			Topics:    cfg.PriorityTopic[name].Topic,
+			MaxLag:    cfg.PriorityTopic[name].MaxLag,
+			StopGrace: cfg.PriorityTopic[name].StopGrace,
+		})
+	}
+	// Dead letter rules are sorted by name as well, as the first rule to match a topic is used
+	deadLetterNames := make([]string, 0, len(cfg.DeadLetter))
+	for name := range cfg.DeadLetter {
+		deadLetterNames = append(deadLetterNames, name)
+	}
+	sort.Strings(deadLetterNames)
+	for _, name := range deadLetterNames {
+		storageConfig.DeadLetterTopics = append(storageConfig.DeadLetterTopics, &storage.DeadLetterConfig{
+			Topics:  cfg.DeadLetter[name].Topic,
+			MaxRate: cfg.DeadLetter[name].MaxRate,
+		})
+	}
+	// Rollup policies are sorted by name as well, as the first policy to match a group is used
+	policyNames := make([]string, 0, len(cfg.RollupPolicy))
+	for name := range cfg.RollupPolicy {
+		policyNames = append(policyNames, name)
+	}
+	sort.Strings(policyNames)
+	for _, name := range policyNames {
+		storageConfig.RollupPolicies = append(storageConfig.RollupPolicies, &storage.RollupPolicyConfig{
+			Groups:       cfg.RollupPolicy[name].Group,
+			Policy:       cfg.RollupPolicy[name].Policy,
+			ErrorPercent: cfg.RollupPolicy[name].ErrorPercent,
+		})
+	}
+	// Group tag rules are sorted by name as well, as the first rule to set a tag wins
+	tagNames := make([]string, 0, len(cfg.GroupTags))
+	for name := range cfg.GroupTags {
+		tagNames = append(tagNames, name)
+	}
+	sort.Strings(tagNames)
+	for _, name := range tagNames {
+		storageConfig.GroupTagRules = append(storageConfig.GroupTagRules, cfg.GroupTags[name].Pattern)
+	}
+	for _, ignore := range cfg.IgnorePartition {
+		for _, partition := range ignore.Partitions {
+			storageConfig.IgnoredPartitions = append(storageConfig.IgnoredPartitions, &storage.IgnoredPartitionConfig{
+				Cluster:   ignore.Cluster,
+				Topic:     ignore.Topic,
+				Partition: partition,
+				Reason:    ignore.Reason,
+			})
+		}
+	}
+	for _, mapping := range cfg.CommitMapping {
+		storageConfig.CommitMappings = append(storageConfig.CommitMappings, &storage.CommitMappingConfig{
+			CommitCluster: mapping.CommitCluster,
+			DataCluster:   mapping.DataCluster,
+			Group:         mapping.Group,
+		})
+	}
+	for _, expected := range cfg.ExpectedGroup {
+		storageConfig.ExpectedGroups = append(storageConfig.ExpectedGroups, &storage.ExpectedGroupConfig{
+			Cluster:  expected.Cluster,
+			Group:    expected.Group,
+			Topics:   expected.Topics,
+			Schedule: expected.Schedule,
+			Window:   expected.Window,
+		})
+	}
+	return storageConfig
+}
+
 // Validate that the config is complete
 // For a couple values, this will set reasonable defaults for missing values
 func ValidateConfig(app *ApplicationContext) error {
@@ -131,9 +540,17 @@ func ValidateConfig(app *ApplicationContext) error {
 	if app.Config.General.LogDir == "" {
 		app.Config.General.LogDir, _ = os.Getwd()
 	}
+	if app.Config.General.DumpDir == "" {
+		app.Config.General.DumpDir = app.Config.General.LogDir
+	}
 	if _, err := os.Stat(app.Config.General.LogDir); os.IsNotExist(err) {
 		errs = append(errs, "Log directory does not exist")
 	}
+	if app.Config.General.DumpDir != app.Config.General.LogDir {
+		if _, err := os.Stat(app.Config.General.DumpDir); os.IsNotExist(err) {
+			errs = append(errs, "Diagnostics dump directory does not exist")
+		}
+	}
 	if app.Config.General.LogConfig != "" {
 		if _, err := os.Stat(app.Config.General.LogConfig); os.IsNotExist(err) {
 			errs = append(errs, "Log configuration file does not exist")
@@ -153,6 +570,22 @@ func ValidateConfig(app *ApplicationContext) error {
 			errs = append(errs, "Kafka client ID is not valid")
 		}
 	}
+	if app.Config.General.AddressFamily == "" {
+		app.Config.General.AddressFamily = "dual"
+	}
+	if !addressFamilies[app.Config.General.AddressFamily] {
+		errs = append(errs, "Address family must be dual, ipv4, ipv6, prefer-ipv4, or prefer-ipv6")
+	}
+	if app.Config.General.ShutdownTimeout == 0 {
+		app.Config.General.ShutdownTimeout = 30
+	} else if app.Config.General.ShutdownTimeout < 0 {
+		errs = append(errs, "Shutdown timeout must be positive")
+	}
+	if app.Config.General.DisplayTimezone != "" {
+		if _, err := time.LoadLocation(app.Config.General.DisplayTimezone); err != nil {
+			errs = append(errs, "Display timezone is not a known timezone")
+		}
+	}
 
 	// Zookeeper
 	if app.Config.Zookeeper.Port == 0 {
@@ -197,6 +630,14 @@ func ValidateConfig(app *ApplicationContext) error {
 				errs = append(errs, fmt.Sprintf("Kafka client ID is not valid for profile %s", name))
 			}
 		}
+		if cfg.KafkaVersion != "" {
+			if _, err := sarama.ParseKafkaVersion(cfg.KafkaVersion); err != nil {
+				errs = append(errs, fmt.Sprintf("Kafka version is not valid for profile %s", name))
+			}
+		}
+		if (cfg.DialTimeout < 0) || (cfg.ReadTimeout < 0) || (cfg.WriteTimeout < 0) || (cfg.Keepalive < 0) || (cfg.MaxOpenRequests < 0) {
+			errs = append(errs, fmt.Sprintf("Connection settings must be positive for profile %s", name))
+		}
 	}
 
 	// Kafka Clusters
@@ -204,6 +645,17 @@ func ValidateConfig(app *ApplicationContext) error {
 		errs = append(errs, "No Kafka clusters are configured")
 	}
 	for cluster, cfg := range app.Config.Kafka {
+		switch cfg.Type {
+		case "":
+			cfg.Type = "kafka"
+		case "kafka":
+		case "test":
+			errs = append(errs, validateTestCluster(app, cluster)...)
+			continue
+		default:
+			errs = append(errs, fmt.Sprintf("Cluster type is not valid for cluster %s", cluster))
+			continue
+		}
 		if cfg.BrokerPort == 0 {
 			cfg.BrokerPort = 9092
 		}
@@ -251,6 +703,32 @@ func ValidateConfig(app *ApplicationContext) error {
 				errs = append(errs, fmt.Sprintf("Kafka client profile is not defined for cluster %s", cluster))
 			}
 		}
+		if cfg.ReadCommittedGroups != "" {
+			if _, err := regexp.Compile(cfg.ReadCommittedGroups); err != nil {
+				errs = append(errs, fmt.Sprintf("Read committed groups pattern is not valid for cluster %s", cluster))
+			}
+
+			// The last stable offset can only be fetched from brokers that support transactions
+			if profile, ok := app.Config.Clientprofile[cfg.Clientprofile]; ok {
+				version, err := sarama.ParseKafkaVersion(profile.KafkaVersion)
+				if (err != nil) || (!version.IsAtLeast(sarama.V0_11_0_0)) {
+					errs = append(errs, fmt.Sprintf("Read committed groups for cluster %s require a client profile with kafka-version 0.11.0.0 or later", cluster))
+				}
+			}
+		}
+		if (cfg.MaxGroups < 0) || (cfg.MaxTopics < 0) || (cfg.MaxPartitions < 0) {
+			errs = append(errs, fmt.Sprintf("Limits for cluster %s must not be negative", cluster))
+		}
+		switch cfg.OnLimit {
+		case "":
+			cfg.OnLimit = storage.LimitModeStopAdding
+		case storage.LimitModeStopAdding, storage.LimitModeEvictOldest, storage.LimitModeSample:
+		default:
+			errs = append(errs, fmt.Sprintf("On-limit for cluster %s must be stop-adding, evict-oldest, or sample", cluster))
+		}
+		if cfg.Tunnel != "" {
+			errs = append(errs, validateTunnel(cluster, cfg.Tunnel, cfg.TunnelKeyFile, cfg.TunnelKnownHosts, cfg.TunnelPasswordFile)...)
+		}
 	}
 
 	// Storm Clusters
@@ -277,15 +755,277 @@ func ValidateConfig(app *ApplicationContext) error {
 		}
 	}
 
+	// Groups that commit offsets to a different cluster than the one they consume from
+	for name, cfg := range app.Config.CommitMapping {
+		if _, ok := app.Config.Kafka[cfg.CommitCluster]; !ok {
+			errs = append(errs, fmt.Sprintf("Commit mapping %s has a bad commit-cluster name", name))
+		}
+		if _, ok := app.Config.Kafka[cfg.DataCluster]; !ok {
+			errs = append(errs, fmt.Sprintf("Commit mapping %s has a bad data-cluster name", name))
+		}
+		if cfg.CommitCluster == cfg.DataCluster {
+			errs = append(errs, fmt.Sprintf("Commit mapping %s must have different commit and data clusters", name))
+		}
+		if cfg.Group == "" {
+			errs = append(errs, fmt.Sprintf("Commit mapping %s must have a group regular expression", name))
+		} else if _, err := regexp.Compile(cfg.Group); err != nil {
+			errs = append(errs, fmt.Sprintf("Commit mapping %s has an invalid group regular expression", name))
+		}
+	}
+
+	// Priority topics
+	for name, cfg := range app.Config.PriorityTopic {
+		if cfg.Topic == "" {
+			errs = append(errs, fmt.Sprintf("Priority topic %s must have a topic regular expression", name))
+		} else if _, err := regexp.Compile(cfg.Topic); err != nil {
+			errs = append(errs, fmt.Sprintf("Priority topic %s has an invalid topic regular expression", name))
+		}
+		if (cfg.MaxLag < 0) || (cfg.StopGrace < 0) {
+			errs = append(errs, fmt.Sprintf("Priority topic %s must not have a negative max-lag or stop-grace", name))
+		}
+	}
+
+	// Dead letter topics
+	for name, cfg := range app.Config.DeadLetter {
+		if cfg.Topic == "" {
+			errs = append(errs, fmt.Sprintf("Dead letter rule %s must have a topic regular expression", name))
+		} else if _, err := storage.CompileDeadLetterRule(cfg.Topic); err != nil {
+			errs = append(errs, fmt.Sprintf("Dead letter rule %s has an invalid topic: %v", name, err))
+		}
+		if cfg.MaxRate < 0 {
+			errs = append(errs, fmt.Sprintf("Dead letter rule %s must not have a negative max-rate", name))
+		}
+	}
+
+	// Timestamp precision, by offset source
+	for name, cfg := range app.Config.Timestamps {
+		if _, ok := offsetSourceModules[name]; !ok {
+			errs = append(errs, fmt.Sprintf("Timestamps are configured for unknown offset source %s", name))
+		}
+		if cfg.Precision == "" {
+			cfg.Precision = TimestampAuto
+		}
+		if _, ok := timestampScales[cfg.Precision]; !ok && (cfg.Precision != TimestampAuto) {
+			errs = append(errs, fmt.Sprintf("Timestamps for offset source %s must have a precision of auto, seconds, milliseconds, microseconds, or nanoseconds", name))
+		}
+		if cfg.MaxFuture == 0 {
+			cfg.MaxFuture = 86400
+		}
+		if cfg.MaxFuture < 0 {
+			errs = append(errs, fmt.Sprintf("Timestamps for offset source %s must not have a negative max-future", name))
+		}
+	}
+
+	// Produce rate alerts, by cluster
+	for cluster, cfg := range app.Config.ProduceAlert {
+		if _, ok := app.Config.Kafka[cluster]; !ok {
+			errs = append(errs, fmt.Sprintf("Produce alerts are configured for unknown cluster %s", cluster))
+		}
+		if len(cfg.Topics) == 0 {
+			errs = append(errs, fmt.Sprintf("Produce alerts for cluster %s must have a topic regular expression", cluster))
+		}
+		for _, topic := range cfg.Topics {
+			if _, err := regexp.Compile(topic); err != nil {
+				errs = append(errs, fmt.Sprintf("Produce alerts for cluster %s have an invalid topic: %v", cluster, err))
+			}
+		}
+		if cfg.Interval == 0 {
+			cfg.Interval = 60
+		}
+		if cfg.ZeroAfter == 0 {
+			cfg.ZeroAfter = 300
+		}
+		if cfg.Baseline == 0 {
+			cfg.Baseline = 3600
+		}
+		if (cfg.Interval < 0) || (cfg.ZeroAfter < 0) || (cfg.Baseline < 0) {
+			errs = append(errs, fmt.Sprintf("Produce alerts for cluster %s must have a positive interval, zero-after, and baseline", cluster))
+		}
+		if (cfg.SpikeFactor != 0) && (cfg.SpikeFactor <= 1) {
+			errs = append(errs, fmt.Sprintf("Produce alerts for cluster %s must have a spike-factor over 1", cluster))
+		}
+		if (cfg.Url != "") && !validateUrl(cfg.Url) {
+			errs = append(errs, fmt.Sprintf("Produce alerts for cluster %s have an invalid url", cluster))
+		}
+	}
+
+	// Cross-cluster checks of groups that consume mirrored topics, by name
+	for name, cfg := range app.Config.MirrorCheck {
+		if len(cfg.Clusters) < 2 {
+			errs = append(errs, fmt.Sprintf("Mirror check %s must have at least two clusters", name))
+		}
+		for _, cluster := range cfg.Clusters {
+			if _, ok := app.Config.Kafka[cluster]; !ok {
+				errs = append(errs, fmt.Sprintf("Mirror check %s has unknown cluster %s", name, cluster))
+			}
+		}
+		if len(cfg.Topics) == 0 {
+			errs = append(errs, fmt.Sprintf("Mirror check %s must have a topic regular expression", name))
+		}
+		for _, topic := range cfg.Topics {
+			if _, err := regexp.Compile(topic); err != nil {
+				errs = append(errs, fmt.Sprintf("Mirror check %s has an invalid topic: %v", name, err))
+			}
+		}
+		if cfg.Group == "" {
+			cfg.Group = ".*"
+		}
+		if _, err := regexp.Compile(cfg.Group); err != nil {
+			errs = append(errs, fmt.Sprintf("Mirror check %s has an invalid group: %v", name, err))
+		}
+		if cfg.MaxDivergence == 0 {
+			cfg.MaxDivergence = 1000
+		}
+		if cfg.Interval == 0 {
+			cfg.Interval = 60
+		}
+		if (cfg.MaxDivergence < 0) || (cfg.Interval < 0) {
+			errs = append(errs, fmt.Sprintf("Mirror check %s must have a positive max-divergence and interval", name))
+		}
+		if (cfg.Url != "") && !validateUrl(cfg.Url) {
+			errs = append(errs, fmt.Sprintf("Mirror check %s has an invalid url", name))
+		}
+	}
+
+	// Checkpoint topics of exactly-once sinks, by cluster
+	for cluster, cfg := range app.Config.Checkpoint {
+		if _, ok := app.Config.Kafka[cluster]; !ok {
+			errs = append(errs, fmt.Sprintf("Checkpoint topics are configured for unknown cluster %s", cluster))
+		}
+		if len(cfg.Topics) == 0 {
+			errs = append(errs, fmt.Sprintf("No checkpoint topics specified for cluster %s", cluster))
+		}
+		if cfg.Format == "" {
+			cfg.Format = CheckpointFormatJSON
+		}
+		if (cfg.Format != CheckpointFormatJSON) && (cfg.Format != CheckpointFormatText) {
+			errs = append(errs, fmt.Sprintf("Checkpoint format for cluster %s must be json or text", cluster))
+		}
+		if cfg.GroupField == "" {
+			cfg.GroupField = "group"
+		}
+		if cfg.TopicField == "" {
+			cfg.TopicField = "topic"
+		}
+		if cfg.PartitionField == "" {
+			cfg.PartitionField = "partition"
+		}
+		if cfg.OffsetField == "" {
+			cfg.OffsetField = "offset"
+		}
+		if cfg.IgnoreCommits != "" {
+			if _, err := regexp.Compile(cfg.IgnoreCommits); err != nil {
+				errs = append(errs, fmt.Sprintf("Checkpoint ignore-commits for cluster %s is not a valid regular expression", cluster))
+			}
+		}
+	}
+
+	// Rollup policies
+	for name, cfg := range app.Config.RollupPolicy {
+		if cfg.Group == "" {
+			errs = append(errs, fmt.Sprintf("Rollup policy %s must have a group regular expression", name))
+		} else if _, err := regexp.Compile(cfg.Group); err != nil {
+			errs = append(errs, fmt.Sprintf("Rollup policy %s has an invalid group regular expression", name))
+		}
+		switch cfg.Policy {
+		case "":
+			cfg.Policy = storage.RollupWorst
+		case storage.RollupWorst:
+		case storage.RollupPercent:
+			if (cfg.ErrorPercent < 0) || (cfg.ErrorPercent > 100) {
+				errs = append(errs, fmt.Sprintf("Rollup policy %s must have an error-percent between 0 and 100", name))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("Rollup policy %s must have a policy of worst or percent", name))
+		}
+	}
+
+	// Group tags
+	for name, cfg := range app.Config.GroupTags {
+		if cfg.Pattern == "" {
+			errs = append(errs, fmt.Sprintf("Group tags %s must have a pattern", name))
+		} else if _, err := storage.CompileGroupTagRule(cfg.Pattern); err != nil {
+			errs = append(errs, fmt.Sprintf("Group tags %s has an invalid pattern: %v", name, err))
+		}
+	}
+
+	// Ignored partitions
+	for name, cfg := range app.Config.IgnorePartition {
+		if _, ok := app.Config.Kafka[cfg.Cluster]; !ok {
+			errs = append(errs, fmt.Sprintf("Ignored partitions %s have a bad cluster name", name))
+		}
+		if !validateTopic(cfg.Topic) {
+			errs = append(errs, fmt.Sprintf("Ignored partitions %s have an invalid topic name", name))
+		}
+		if len(cfg.Partitions) == 0 {
+			errs = append(errs, fmt.Sprintf("Ignored partitions %s must list at least one partition", name))
+		}
+		for _, partition := range cfg.Partitions {
+			if partition < 0 {
+				errs = append(errs, fmt.Sprintf("Ignored partitions %s have an invalid partition ID", name))
+				break
+			}
+		}
+	}
+
+	// Topic groups for consumer roll-ups
+	for name, cfg := range app.Config.TopicGroup {
+		if len(cfg.Topics) == 0 {
+			errs = append(errs, fmt.Sprintf("Topic group %s must have at least one topic regular expression", name))
+		}
+		for _, topic := range cfg.Topics {
+			if _, err := regexp.Compile(topic); err != nil {
+				errs = append(errs, fmt.Sprintf("Topic group %s has an invalid topic regular expression", name))
+				break
+			}
+		}
+	}
+
+	// Expected consumer groups
+	for name, cfg := range app.Config.ExpectedGroup {
+		if _, ok := app.Config.Kafka[cfg.Cluster]; !ok {
+			errs = append(errs, fmt.Sprintf("Expected group %s has a bad cluster name", name))
+		}
+		if cfg.Group == "" {
+			cfg.Group = name
+		}
+		if !validateTopic(cfg.Group) {
+			errs = append(errs, fmt.Sprintf("Expected group %s has an invalid group name", name))
+		}
+		for _, topic := range cfg.Topics {
+			if !validateTopic(topic) {
+				errs = append(errs, fmt.Sprintf("Expected group %s has an invalid topic name", name))
+				break
+			}
+		}
+		if cfg.Schedule != "" {
+			if _, err := storage.ParseCronSchedule(cfg.Schedule); err != nil {
+				errs = append(errs, fmt.Sprintf("Expected group %s has an invalid schedule: %v", name, err))
+			}
+			if cfg.Window == 0 {
+				cfg.Window = 3600
+			}
+		}
+	}
+
 	// Tickers
 	if app.Config.Tickers.BrokerOffsets == 0 {
 		app.Config.Tickers.BrokerOffsets = 60
 	}
+	if app.Config.Tickers.SourceRetry == 0 {
+		app.Config.Tickers.SourceRetry = 30
+	}
 
 	// Intervals
 	if app.Config.Lagcheck.Intervals == 0 {
 		app.Config.Lagcheck.Intervals = 10
 	}
+	if app.Config.Lagcheck.BrokerIntervals == 0 {
+		app.Config.Lagcheck.BrokerIntervals = 10
+	}
+	if app.Config.Lagcheck.BrokerIntervals < 2 {
+		errs = append(errs, "Broker intervals must be at least 2")
+	}
 	if app.Config.Lagcheck.ExpireGroup == 0 {
 		app.Config.Lagcheck.ExpireGroup = 604800
 	}
@@ -304,22 +1044,340 @@ func ValidateConfig(app *ApplicationContext) error {
 	if app.Config.Lagcheck.StormGroupRefresh == 0 {
 		app.Config.Lagcheck.StormGroupRefresh = 300
 	}
+	if app.Config.Lagcheck.DroppedOffsets == 0 {
+		app.Config.Lagcheck.DroppedOffsets = 1000
+	}
+	if app.Config.Lagcheck.DropLogInterval == 0 {
+		app.Config.Lagcheck.DropLogInterval = 60
+	}
+	if app.Config.Lagcheck.TombstoneRetention == 0 {
+		app.Config.Lagcheck.TombstoneRetention = 3600
+	}
+	switch app.Config.Lagcheck.EphemeralGroups {
+	case "", "off":
+		app.Config.Lagcheck.EphemeralGroups = storage.EphemeralModeOff
+	case storage.EphemeralModeCollapse, storage.EphemeralModeExpire:
+	default:
+		errs = append(errs, "Ephemeral groups handling must be off, collapse, or expire")
+	}
+	for _, pattern := range app.Config.Lagcheck.EphemeralGroup {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Sprintf("Ephemeral group pattern %s is not a valid regular expression", pattern))
+		}
+	}
+	if app.Config.Lagcheck.EphemeralGroupName == "" {
+		app.Config.Lagcheck.EphemeralGroupName = "console"
+	}
+	if app.Config.Lagcheck.EphemeralExpire == 0 {
+		app.Config.Lagcheck.EphemeralExpire = 600
+	}
+	if app.Config.Lagcheck.EphemeralExpire < 0 {
+		errs = append(errs, "Ephemeral group expiry must be positive")
+	}
+	if app.Config.Lagcheck.BurnIn < 0 {
+		errs = append(errs, "Burn-in period for new groups must not be negative")
+	}
+	if app.Config.Lagcheck.EvaluationWorkers == 0 {
+		app.Config.Lagcheck.EvaluationWorkers = runtime.NumCPU()
+	}
+	if app.Config.Lagcheck.EvaluationWorkers < 0 {
+		errs = append(errs, "Evaluation workers must be positive")
+	}
+	if app.Config.Lagcheck.CatchUpBacklog == 0 {
+		app.Config.Lagcheck.CatchUpBacklog = 1000
+	}
+	if app.Config.Lagcheck.PriorityExpire == 0 {
+		app.Config.Lagcheck.PriorityExpire = 600
+	}
+	if app.Config.Lagcheck.PriorityExpire < 0 {
+		errs = append(errs, "Priority group expiry must be positive")
+	}
+	switch app.Config.Lagcheck.CompactedTopics {
+	case "":
+		app.Config.Lagcheck.CompactedTopics = "flag"
+	case "flag", "exclude":
+	default:
+		errs = append(errs, "Compacted topics handling must be flag or exclude")
+	}
+	if app.Config.Lagcheck.TopicConfigRefresh == 0 {
+		app.Config.Lagcheck.TopicConfigRefresh = 300
+	}
+	if app.Config.Lagcheck.RetentionRisk < 0 {
+		errs = append(errs, "Retention risk threshold must not be negative")
+	}
+	if app.Config.Lagcheck.DroppedOffsets < 0 {
+		errs = append(errs, "Dropped offsets history size must be positive")
+	}
+	if (app.Config.Lagcheck.DropLogInterval < 0) || (app.Config.Lagcheck.DropLogSample < 0) {
+		errs = append(errs, "Dropped offsets drop-log-interval and drop-log-sample must be positive")
+	}
+	switch app.Config.Lagcheck.ClockSource {
+	case "":
+		app.Config.Lagcheck.ClockSource = storage.ClockSourceLocal
+	case storage.ClockSourceLocal, storage.ClockSourceCompensated:
+	default:
+		errs = append(errs, "Clock source must be local or compensated")
+	}
+	if app.Config.Lagcheck.ClockSkewWarning == 0 {
+		app.Config.Lagcheck.ClockSkewWarning = 30
+	}
+	if app.Config.Lagcheck.ClockSkewWarning < 0 {
+		errs = append(errs, "Clock skew warning threshold must be positive")
+	}
+
+	// Watchdog for wedged offset sources. The timeout has to be longer than any of the refresh intervals, or healthy
+	// sources would be restarted between refreshes
+	if app.Config.Watchdog.Timeout == 0 {
+		app.Config.Watchdog.Timeout = 900
+	}
+	if app.Config.Watchdog.Timeout > 0 {
+		longestRefresh := int64(app.Config.Tickers.BrokerOffsets)
+		for _, refresh := range []int64{app.Config.Lagcheck.ZKGroupRefresh, app.Config.Lagcheck.StormGroupRefresh, app.Config.Lagcheck.TopicConfigRefresh} {
+			if refresh > longestRefresh {
+				longestRefresh = refresh
+			}
+		}
+		if app.Config.Watchdog.Timeout <= longestRefresh {
+			errs = append(errs, "Watchdog timeout must be longer than the broker offset and group and topic config refresh intervals")
+		}
+	}
+	if app.Config.Watchdog.MaxBackoff == 0 {
+		app.Config.Watchdog.MaxBackoff = 600
+	}
+	if app.Config.Watchdog.MaxBackoff < int64(app.Config.Tickers.SourceRetry) {
+		errs = append(errs, "Watchdog max-backoff must be at least the source-retry interval")
+	}
+	if app.Config.Dns.Ttl == 0 {
+		app.Config.Dns.Ttl = 60
+	}
+
+	// Audit log
+	if app.Config.Audit.File != "" {
+		if _, err := os.Stat(filepath.Dir(app.Config.Audit.File)); os.IsNotExist(err) {
+			errs = append(errs, "Audit file directory does not exist")
+		}
+	}
+	if app.Config.Audit.AdminFile == "" {
+		app.Config.Audit.AdminFile = filepath.Join(app.Config.General.LogDir, "burrow-admin.log")
+	} else if _, err := os.Stat(filepath.Dir(app.Config.Audit.AdminFile)); os.IsNotExist(err) {
+		errs = append(errs, "Admin audit file directory does not exist")
+	}
+	for user, cfg := range app.Config.AdminUser {
+		if (cfg.Token == "") == (cfg.TokenFile == "") {
+			errs = append(errs, fmt.Sprintf("Admin user %s must have one of token or token-file", user))
+		}
+		for _, cluster := range cfg.Cluster {
+			if _, ok := app.Config.Kafka[cluster]; !ok {
+				errs = append(errs, fmt.Sprintf("Admin user %s is scoped to cluster %s, which is not configured", user, cluster))
+			}
+		}
+		// Without require-token, a call without a token sees every cluster, so the scope would only limit the token
+		if (len(cfg.Cluster) > 0) && !app.Config.Httpserver.RequireToken {
+			errs = append(errs, fmt.Sprintf("Admin user %s is scoped to clusters, which needs require-token in [httpserver]", user))
+		}
+	}
+	if app.Config.Httpserver.RequireToken && (len(app.Config.AdminUser) == 0) {
+		errs = append(errs, "HTTP server require-token needs at least one admin-user")
+	}
+	if app.Config.Audit.MaxSize == 0 {
+		app.Config.Audit.MaxSize = 100
+	}
+	if app.Config.Audit.MaxSize < 0 {
+		errs = append(errs, "Audit file max-size must be positive")
+	}
+	if app.Config.Audit.MaxBackups < 0 {
+		errs = append(errs, "Audit file max-backups must not be negative")
+	}
+	if app.Config.Audit.KafkaTopic != "" {
+		if cfg, ok := app.Config.Kafka[app.Config.Audit.KafkaCluster]; (!ok) || (cfg.Type == "test") {
+			errs = append(errs, "Audit kafka-cluster must be one of the configured Kafka clusters")
+		}
+	}
+
+	// Commit sampling, for analytics
+	if app.Config.Sample.Prefix != "" {
+		if _, err := os.Stat(filepath.Dir(app.Config.Sample.Prefix)); os.IsNotExist(err) {
+			errs = append(errs, "Sample prefix directory does not exist")
+		}
+		if (app.Config.Sample.Every > 0) && (app.Config.Sample.Interval > 0) {
+			errs = append(errs, "Commits can be sampled by every or interval, but not both")
+		}
+		if (app.Config.Sample.Every == 0) && (app.Config.Sample.Interval == 0) {
+			app.Config.Sample.Interval = 60
+		}
+		if app.Config.Sample.MaxSize == 0 {
+			app.Config.Sample.MaxSize = 100
+		}
+		if (app.Config.Sample.Every < 0) || (app.Config.Sample.Interval < 0) || (app.Config.Sample.MaxSize < 0) || (app.Config.Sample.MaxFiles < 0) {
+			errs = append(errs, "Sample every, interval, max-size, and max-files must not be negative")
+		}
+	}
+
+	// Offset export
+	if app.Config.Export.KafkaTopic != "" {
+		if cfg, ok := app.Config.Kafka[app.Config.Export.KafkaCluster]; (!ok) || (cfg.Type == "test") {
+			errs = append(errs, "Export kafka-cluster must be one of the configured Kafka clusters")
+		}
+	}
+	if app.Config.Export.Stream && !app.Config.Httpserver.Enable {
+		errs = append(errs, "The export stream requires the HTTP server")
+	}
+
+	// Offset validation against the broker, which is off unless an interval is set
+	if app.Config.Validation.Interval < 0 {
+		errs = append(errs, "Offset validation interval must not be negative")
+	}
+	if app.Config.Validation.Groups == 0 {
+		app.Config.Validation.Groups = 20
+	}
+	if app.Config.Validation.Groups < 0 {
+		errs = append(errs, "Offset validation groups must be positive")
+	}
+
+	// Background evaluation of every group. A negative interval disables it
+	if app.Config.Evaluator.Interval == 0 {
+		app.Config.Evaluator.Interval = 60
+	}
+
+	// Offset archive
+	if app.Config.Archive.Retention == 0 {
+		app.Config.Archive.Retention = 86400
+	}
+	if app.Config.Archive.Interval == 0 {
+		app.Config.Archive.Interval = 60
+	}
+	if (app.Config.Archive.Retention < 0) || (app.Config.Archive.Interval < 0) {
+		errs = append(errs, "Offset archive retention and interval must be positive")
+	}
+
+	// Offset storage backend
+	switch app.Config.Storage.Backend {
+	case "":
+		app.Config.Storage.Backend = "memory"
+	case "memory":
+	case "file", "bolt":
+		if app.Config.Storage.Path == "" {
+			errs = append(errs, "The "+app.Config.Storage.Backend+" storage backend requires a path")
+		}
+	default:
+		errs = append(errs, "Storage backend must be memory, file, or bolt")
+	}
+	if app.Config.Storage.Codec == "" {
+		app.Config.Storage.Codec = storage.Codecs[0].Name()
+	}
+	if storage.CodecByName(app.Config.Storage.Codec) == nil {
+		names := make([]string, len(storage.Codecs))
+		for i, codec := range storage.Codecs {
+			names[i] = codec.Name()
+		}
+		errs = append(errs, "Storage codec must be one of "+strings.Join(names, ", "))
+	}
+	if app.Config.Storage.CheckpointInterval == 0 {
+		app.Config.Storage.CheckpointInterval = 60
+	}
+	if app.Config.Storage.CheckpointInterval < 0 {
+		errs = append(errs, "Storage checkpoint interval must be positive")
+	}
+	if app.Config.Storage.ObjectStoreUrl != "" {
+		if app.Config.Storage.Backend != "file" {
+			errs = append(errs, "Uploading snapshots to an object store requires the file storage backend")
+		}
+		if objectStoreUrl, err := url.Parse(app.Config.Storage.ObjectStoreUrl); (err != nil) || (objectStoreUrl.Host == "") || (strings.Trim(objectStoreUrl.Path, "/") == "") {
+			errs = append(errs, "Object store URL must be a URL with the bucket in the path")
+		}
+		if app.Config.Storage.ObjectStoreRegion == "" {
+			app.Config.Storage.ObjectStoreRegion = "us-east-1"
+		}
+		if app.Config.Storage.UploadInterval == 0 {
+			app.Config.Storage.UploadInterval = 3600
+		}
+		if app.Config.Storage.UploadRetention == 0 {
+			app.Config.Storage.UploadRetention = 604800
+		}
+		if (app.Config.Storage.UploadInterval < 0) || (app.Config.Storage.UploadRetention < 0) {
+			errs = append(errs, "Snapshot upload interval and retention must be positive")
+		}
+	}
+
+	// Bootstrap
+	if app.Config.Bootstrap.Peer != "" {
+		if peerUrl, err := url.Parse(app.Config.Bootstrap.Peer); (err != nil) || ((peerUrl.Scheme != "http") && (peerUrl.Scheme != "https")) || (peerUrl.Host == "") {
+			errs = append(errs, "Bootstrap peer must be the http or https URL of another Burrow")
+		}
+		if (app.Config.Bootstrap.Token != "") && (app.Config.Bootstrap.TokenFile != "") {
+			errs = append(errs, "Bootstrap can have a token or a token-file, but not both")
+		}
+		for _, cluster := range app.Config.Bootstrap.Cluster {
+			if _, ok := app.Config.Kafka[cluster]; !ok {
+				errs = append(errs, "Bootstrap cluster "+cluster+" is not a configured Kafka cluster")
+			}
+		}
+		if app.Config.Bootstrap.Timeout == 0 {
+			app.Config.Bootstrap.Timeout = 60
+		}
+		if app.Config.Bootstrap.Timeout < 0 {
+			errs = append(errs, "Bootstrap timeout must be positive")
+		}
+	}
 
 	// HTTP Server
 	if app.Config.Httpserver.Enable {
 		if app.Config.Httpserver.Port == 0 {
 			errs = append(errs, "HTTP server port is not specified")
 		}
+		if (app.Config.Httpserver.Address != "") && !validateHostname(trimBrackets(app.Config.Httpserver.Address)) {
+			errs = append(errs, "HTTP server address is invalid")
+		}
+		if app.Config.Httpserver.OverloadThreshold == 0 {
+			app.Config.Httpserver.OverloadThreshold = 90
+		}
+		if app.Config.Httpserver.StorageTimeout == 0 {
+			app.Config.Httpserver.StorageTimeout = 5
+		}
+		if app.Config.Httpserver.RetryAfter == 0 {
+			app.Config.Httpserver.RetryAfter = 10
+		}
+		if (app.Config.Httpserver.OverloadThreshold < 0) || (app.Config.Httpserver.OverloadThreshold > 100) {
+			errs = append(errs, "HTTP server overload-threshold must be a percentage between 1 and 100")
+		}
+		if (app.Config.Httpserver.StorageTimeout < 0) || (app.Config.Httpserver.RetryAfter < 0) {
+			errs = append(errs, "HTTP server storage-timeout and retry-after must be positive")
+		}
+	}
+
+	// Compatibility API versions
+	for name, cfg := range app.Config.Api {
+		if matches, _ := regexp.MatchString(`^v[0-9]+$`, name); (!matches) || (name == "v2") {
+			errs = append(errs, fmt.Sprintf("API compatibility version %s must be of the form vN, and not v2", name))
+		}
+		switch cfg.Casing {
+		case "":
+			cfg.Casing = "snake"
+		case "snake", "camel":
+		default:
+			errs = append(errs, fmt.Sprintf("API compatibility casing for %s must be snake or camel", name))
+		}
+		for _, rename := range cfg.Rename {
+			if matches, _ := regexp.MatchString(`^[^=]+=[^=]+$`, rename); !matches {
+				errs = append(errs, fmt.Sprintf("API compatibility rename for %s must be of the form old=new", name))
+			}
+		}
 	}
 
 	// SMTP server config
 	if app.Config.Smtp.Server != "" {
-		if !validateHostname(app.Config.Smtp.Server) {
+		if !validateHostname(trimBrackets(app.Config.Smtp.Server)) {
 			errs = append(errs, "SMTP server is invalid")
 		}
 		if app.Config.Smtp.Port == 0 {
 			app.Config.Smtp.Port = 25
 		}
+		if app.Config.Smtp.Timeout == 0 {
+			app.Config.Smtp.Timeout = 30
+		}
+		if app.Config.Smtp.Timeout < 0 {
+			errs = append(errs, "SMTP timeout must be positive")
+		}
 		if app.Config.Smtp.From == "" {
 			errs = append(errs, "Email from address is not defined")
 		} else {
@@ -376,6 +1434,7 @@ func ValidateConfig(app *ApplicationContext) error {
 					errs = append(errs, "Email notification threshold is invalid (must be WARNING or ERROR)")
 				}
 			}
+			errs = append(errs, validateQuietHours("Email "+email, cfg.QuietSchedule, cfg.QuietDuration, cfg.QuietWeekends, cfg.Timezone)...)
 		}
 	} else {
 		if len(app.Config.Email) > 0 {
@@ -383,11 +1442,136 @@ func ValidateConfig(app *ApplicationContext) error {
 		}
 	}
 
+	// Health reporter
+	if app.Config.Health.Interval == 0 {
+		app.Config.Health.Interval = 30
+	}
+	if app.Config.Health.Interval < 0 {
+		errs = append(errs, "Health check interval must be positive")
+	}
+	if app.Config.Health.File != "" {
+		if _, err := os.Stat(filepath.Dir(app.Config.Health.File)); os.IsNotExist(err) {
+			errs = append(errs, "Health file directory does not exist")
+		}
+	}
+	if app.Config.Health.ConsulCheckId != "" {
+		if app.Config.Health.ConsulAddress == "" {
+			app.Config.Health.ConsulAddress = "http://127.0.0.1:8500"
+		}
+		if !validateUrl(app.Config.Health.ConsulAddress) {
+			errs = append(errs, "Health consul-address is invalid")
+		}
+	}
+	if (app.Config.Health.PingUrl != "") && !validateUrl(app.Config.Health.PingUrl) {
+		errs = append(errs, "Health ping-url is invalid")
+	}
+
+	// Stale config checks
+	if app.Config.StaleConfig.Interval == 0 {
+		app.Config.StaleConfig.Interval = 3600
+	}
+	if (app.Config.StaleConfig.Days < 0) || (app.Config.StaleConfig.Interval < 0) {
+		errs = append(errs, "Stale config days and interval must be positive")
+	}
+
+	// Usage accounting
+	cfgAccounting := &app.Config.Accounting
+	if cfgAccounting.Enable {
+		if cfgAccounting.TeamTag == "" {
+			cfgAccounting.TeamTag = "team"
+		}
+		tagged := false
+		for _, cfg := range app.Config.GroupTags {
+			if re, err := storage.CompileGroupTagRule(cfg.Pattern); err == nil {
+				for _, name := range re.SubexpNames() {
+					tagged = tagged || (name == cfgAccounting.TeamTag)
+				}
+			}
+		}
+		if !tagged {
+			errs = append(errs, fmt.Sprintf("Accounting team-tag %s is not set by any group-tags pattern", cfgAccounting.TeamTag))
+		}
+		if cfgAccounting.Days == 0 {
+			cfgAccounting.Days = 90
+		}
+		if cfgAccounting.Days < 0 {
+			errs = append(errs, "Accounting days must be positive")
+		}
+		if cfgAccounting.StateFile == "" {
+			cfgAccounting.StateFile = filepath.Join(app.Config.General.LogDir, "burrow-accounting.json")
+		}
+	}
+
+	// Weekly trend report
+	cfgTrend := &app.Config.TrendReport
+	if trendReportConfigured(app.Config) {
+		if cfgTrend.Schedule == "" {
+			cfgTrend.Schedule = "0 9 * * 1"
+		}
+		if _, err := storage.ParseCronSchedule(cfgTrend.Schedule); err != nil {
+			errs = append(errs, fmt.Sprintf("Trend report schedule is invalid: %v", err))
+		}
+		if cfgTrend.Timezone != "" {
+			if _, err := time.LoadLocation(cfgTrend.Timezone); err != nil {
+				errs = append(errs, "Trend report timezone is not a known timezone")
+			}
+		}
+		if cfgTrend.StateFile == "" {
+			cfgTrend.StateFile = filepath.Join(app.Config.General.LogDir, "burrow-trends.json")
+		}
+		if (len(cfgTrend.Email) > 0) && (app.Config.Smtp.Server == "") {
+			errs = append(errs, "Trend report is emailed, but SMTP server is not configured")
+		}
+		for _, email := range cfgTrend.Email {
+			if !validateEmail(email) {
+				errs = append(errs, fmt.Sprintf("Trend report email address %s is invalid", email))
+			}
+		}
+		if ((cfgTrend.Url != "") && !validateUrl(cfgTrend.Url)) || ((cfgTrend.SlackUrl != "") && !validateUrl(cfgTrend.SlackUrl)) {
+			errs = append(errs, "Trend report url and slack-url must be valid URLs")
+		}
+		if cfgTrend.Top == 0 {
+			cfgTrend.Top = 10
+		}
+		if cfgTrend.Top < 0 {
+			errs = append(errs, "Trend report top must be positive")
+		}
+	}
+
+	// KEDA external scaler
+	if app.Config.Keda.Port < 0 {
+		errs = append(errs, "KEDA scaler port must be positive")
+	}
+	if app.Config.Keda.Port > 0 {
+		if (app.Config.Keda.CertFile == "") != (app.Config.Keda.KeyFile == "") {
+			errs = append(errs, "KEDA scaler needs both a cert-file and key-file, or neither for plaintext HTTP/2")
+		}
+		if app.Config.Httpserver.Enable && (app.Config.Keda.Port == app.Config.Httpserver.Port) {
+			errs = append(errs, "KEDA scaler port must not be the HTTP server port")
+		}
+	}
+
+	// Notifier circuit breakers
+	if app.Config.Notifier.BreakerFailures == 0 {
+		app.Config.Notifier.BreakerFailures = 5
+	}
+	if app.Config.Notifier.BreakerCooldown == 0 {
+		app.Config.Notifier.BreakerCooldown = 300
+	}
+	if (app.Config.Notifier.BreakerFailures < 0) || (app.Config.Notifier.BreakerCooldown < 0) {
+		errs = append(errs, "Notifier breaker-failures and breaker-cooldown must be positive")
+	}
+
 	// HTTP Notifier config
 	if app.Config.Httpnotifier.Url != "" {
 		if !validateUrl(app.Config.Httpnotifier.Url) {
 			errs = append(errs, "HTTP notifier URL is invalid")
 		}
+		if (app.Config.Httpnotifier.SecondaryUrl != "") && !validateUrl(app.Config.Httpnotifier.SecondaryUrl) {
+			errs = append(errs, "HTTP notifier secondary-url is invalid")
+		}
+		errs = append(errs, validateQuietHours("HTTP notifier", app.Config.Httpnotifier.QuietSchedule,
+			app.Config.Httpnotifier.QuietDuration, app.Config.Httpnotifier.QuietWeekends, app.Config.Httpnotifier.Timezone)...)
 		if app.Config.Httpnotifier.TemplatePost == "" {
 			app.Config.Httpnotifier.TemplatePost = "config/default-http-post.tmpl"
 		}
@@ -409,6 +1593,20 @@ func ValidateConfig(app *ApplicationContext) error {
 		if app.Config.Httpnotifier.Interval == 0 {
 			app.Config.Httpnotifier.Interval = 60
 		}
+		if app.Config.Httpnotifier.IdleTimeout == 0 {
+			// Idle connections are closed after the DNS ttl by default, so that new ones go to the current address
+			app.Config.Httpnotifier.IdleTimeout = 90
+			if app.Config.Dns.Ttl > 0 {
+				app.Config.Httpnotifier.IdleTimeout = int(app.Config.Dns.Ttl)
+			}
+		}
+		if app.Config.Httpnotifier.IdleTimeout < 0 {
+			// No limit
+			app.Config.Httpnotifier.IdleTimeout = 0
+		}
+		if (app.Config.Httpnotifier.ConnectTimeout < 0) || (app.Config.Httpnotifier.MaxIdle < 0) || (app.Config.Httpnotifier.MaxIdlePerHost < 0) {
+			errs = append(errs, "HTTP notifier connect-timeout, max-idle, and max-idle-per-host must be positive")
+		}
 		for _, extra := range app.Config.Httpnotifier.Extras {
 			// Each extra should be formatted as "string=string"
 			if matches, _ := regexp.MatchString(`^[a-zA-Z0-9_\-]+=.*$`, extra); !matches {
@@ -418,6 +1616,97 @@ func ValidateConfig(app *ApplicationContext) error {
 		}
 	}
 
+	// FIPS mode rules out settings that would use algorithms that are not approved
+	if fipsBuild || app.Config.General.FIPS {
+		for name, profile := range app.Config.Clientprofile {
+			if profile.TLSNoVerify {
+				errs = append(errs, fmt.Sprintf("Client profile %s can't set tls-noverify in FIPS mode", name))
+			}
+		}
+		if app.Config.Smtp.AuthType == "crammd5" {
+			errs = append(errs, "Email auth-type crammd5 can't be used in FIPS mode")
+		}
+	}
+
+	// Encryption of state on disk. At most one place to get the key from can be set
+	keySources := 0
+	for _, source := range []string{app.Config.Encryption.KeyFile, app.Config.Encryption.KeyEnv, app.Config.Encryption.VaultAddress} {
+		if source != "" {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		errs = append(errs, "Encryption must have only one of key-file, key-env, or vault-address")
+	}
+	if app.Config.Encryption.VaultAddress != "" {
+		if !validateUrl(app.Config.Encryption.VaultAddress) {
+			errs = append(errs, "Encryption vault-address is invalid")
+		}
+		if app.Config.Encryption.VaultPath == "" {
+			errs = append(errs, "Encryption vault-path must be set to read the key from Vault")
+		}
+		if app.Config.Encryption.VaultField == "" {
+			app.Config.Encryption.VaultField = "key"
+		}
+		if app.Config.Encryption.VaultTokenEnv == "" {
+			app.Config.Encryption.VaultTokenEnv = "VAULT_TOKEN"
+		}
+	}
+
+	// Notifier templates
+	for name, cfg := range app.Config.NotifierTemplate {
+		if (cfg.EmailTemplate == "") && (cfg.TemplatePost == "") {
+			errs = append(errs, fmt.Sprintf("Notifier template %s must have an email-template or a template-post", name))
+		}
+		for _, file := range []string{cfg.EmailTemplate, cfg.TemplatePost, cfg.TemplateDelete} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); os.IsNotExist(err) {
+				errs = append(errs, fmt.Sprintf("Notifier template %s file %s does not exist", name, file))
+			}
+		}
+		if cfg.TemplatePost != "" {
+			if !validateUrl(cfg.Url) {
+				errs = append(errs, fmt.Sprintf("Notifier template %s must have a valid url to send its template-post to", name))
+			}
+			if app.Config.Httpnotifier.Url == "" {
+				errs = append(errs, fmt.Sprintf("Notifier template %s has a template-post, but the HTTP notifier is not configured", name))
+			}
+		} else if (cfg.Url != "") || (cfg.TemplateDelete != "") {
+			errs = append(errs, fmt.Sprintf("Notifier template %s must have a template-post to use url or template-delete", name))
+		}
+		if cfg.Timezone != "" {
+			if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+				errs = append(errs, fmt.Sprintf("Notifier template %s has an unknown timezone", name))
+			}
+		}
+		for _, cluster := range cfg.Clusters {
+			if _, ok := app.Config.Kafka[cluster]; !ok {
+				errs = append(errs, fmt.Sprintf("Notifier template %s has an unknown cluster %s", name, cluster))
+			}
+		}
+		if _, err := regexp.Compile(cfg.Group); err != nil {
+			errs = append(errs, fmt.Sprintf("Notifier template %s has an invalid group regular expression", name))
+		}
+		for _, tag := range cfg.Tags {
+			if parts := strings.SplitN(tag, "=", 2); (len(parts) != 2) || (parts[0] == "") {
+				errs = append(errs, fmt.Sprintf("Notifier template %s tags must be given as name=value", name))
+				break
+			}
+		}
+	}
+	for email, cfg := range app.Config.Email {
+		if cfg.Template == "" {
+			continue
+		}
+		if variant, ok := app.Config.NotifierTemplate[cfg.Template]; !ok {
+			errs = append(errs, fmt.Sprintf("Email %s uses notifier template %s, which is not defined", email, cfg.Template))
+		} else if variant.EmailTemplate == "" {
+			errs = append(errs, fmt.Sprintf("Email %s uses notifier template %s, which has no email-template", email, cfg.Template))
+		}
+	}
+
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, ". ") + ".")
 	} else {
@@ -483,23 +1772,82 @@ func validateUrl(rawUrl string) bool {
 	return err == nil
 }
 
+// Validate the quiet hours of a notifier, returning the errors (if any)
+func validateQuietHours(name string, schedule string, duration int64, weekends bool, timezone string) []string {
+	errs := make([]string, 0)
+	if (schedule != "") && (duration <= 0) {
+		errs = append(errs, fmt.Sprintf("%s must have a positive quiet-duration to use quiet-schedule", name))
+	}
+	if _, err := NewQuietHours(schedule, duration, weekends, timezone); err != nil {
+		errs = append(errs, fmt.Sprintf("%s has invalid quiet hours: %v", name, err))
+	}
+	return errs
+}
+
 // Validate a list of ZK or Kafka hosts with optional ports
+// Check the tunnel of a Kafka cluster. Passwords can't be given in the URL, so they don't end up in diagnostics dumps
+func validateTunnel(cluster string, tunnel string, keyFile string, knownHosts string, passwordFile string) []string {
+	parsed, err := url.Parse(tunnel)
+	if (err != nil) || (parsed.Hostname() == "") || ((parsed.Path != "") && (parsed.Path != "/")) {
+		return []string{fmt.Sprintf("Tunnel for cluster %s must be socks5://[user@]host[:port] or ssh://user@host[:port]", cluster)}
+	}
+	errs := make([]string, 0)
+	if parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			errs = append(errs, fmt.Sprintf("Tunnel for cluster %s cannot have a password in the URL", cluster))
+		}
+	}
+	switch parsed.Scheme {
+	case "socks5":
+		if (keyFile != "") || (knownHosts != "") {
+			errs = append(errs, fmt.Sprintf("Tunnel key and known hosts are only used with SSH tunnels, for cluster %s", cluster))
+		}
+		if (passwordFile != "") && (parsed.User == nil) {
+			errs = append(errs, fmt.Sprintf("Tunnel password file for cluster %s needs a user in the tunnel URL", cluster))
+		}
+	case "ssh":
+		if (parsed.User == nil) || (parsed.User.Username() == "") {
+			errs = append(errs, fmt.Sprintf("SSH tunnel for cluster %s must have a user", cluster))
+		}
+		if (keyFile == "") || (knownHosts == "") {
+			errs = append(errs, fmt.Sprintf("SSH tunnel for cluster %s needs a tunnel-key-file and tunnel-known-hosts", cluster))
+		}
+		if passwordFile != "" {
+			errs = append(errs, fmt.Sprintf("Tunnel password file is only used with SOCKS5 tunnels, for cluster %s", cluster))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("Tunnel for cluster %s must be socks5://[user@]host[:port] or ssh://user@host[:port]", cluster))
+	}
+	return errs
+}
+
 func checkHostlist(hosts []string, defaultPort int, appName string) string {
 	for i, host := range hosts {
 		hostparts := strings.Split(host, ":")
 		hostport := defaultPort
 		hostname := hostparts[0]
 
-		if len(hostparts) == 2 {
+		if strings.HasPrefix(host, "[") {
+			// An IPv6 address in brackets, with an optional port
+			hostname = trimBrackets(host)
+			if name, port, err := net.SplitHostPort(host); err == nil {
+				hostname = name
+				hostport, err = strconv.Atoi(port)
+				if (err != nil) || (hostport == 0) {
+					return fmt.Sprintf("One or more %s hostnames have invalid port components", appName)
+				}
+			}
+			if !validateIP(hostname) {
+				return fmt.Sprintf("One or more %s hostnames are invalid", appName)
+			}
+		} else if len(hostparts) == 2 {
 			// Must be a hostname or IPv4 address with a port
 			var err error
 			hostport, err = strconv.Atoi(hostparts[1])
 			if (err != nil) || (hostport == 0) {
 				return fmt.Sprintf("One or more %s hostnames have invalid port components", appName)
 			}
-		}
-
-		if len(hostparts) > 2 {
+		} else if len(hostparts) > 2 {
 			// Must be an IPv6 address
 			// Try without popping off the last segment as a port number first
 			if validateIP(host) {
@@ -508,7 +1856,8 @@ func checkHostlist(hosts []string, defaultPort int, appName string) string {
 				// The full host didn't validate as an IP, so let's pull off the last piece as a port number and try again
 				hostname = strings.Join(hostparts[:len(hostparts)-1], ":")
 
-				hostport, err := strconv.Atoi(hostparts[len(hostparts)-1])
+				var err error
+				hostport, err = strconv.Atoi(hostparts[len(hostparts)-1])
 				if (err != nil) || (hostport == 0) {
 					return fmt.Sprintf("One or more %s hostnames have invalid port components", appName)
 				}
@@ -519,7 +1868,7 @@ func checkHostlist(hosts []string, defaultPort int, appName string) string {
 			return fmt.Sprintf("One or more %s hostnames are invalid", appName)
 		}
 
-		hosts[i] = fmt.Sprintf("[%s]:%v", hostname, hostport)
+		hosts[i] = net.JoinHostPort(hostname, strconv.Itoa(hostport))
 	}
 
 	return ""
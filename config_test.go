@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/gcfg.v1"
+)
+
+var (
+	sampleSection = regexp.MustCompile(`^;\[[a-z-]+( "[^"]*")?\]$`)
+	sampleSetting = regexp.MustCompile(`^;[a-z-]+=`)
+)
+
+// The commented-out example sections of the sample config (with the settings right after each of them), uncommented
+func sampleSections(t *testing.T) string {
+	contents, err := ioutil.ReadFile("config/burrow.cfg")
+	if err != nil {
+		t.Fatalf("Cannot read the sample config: %v", err)
+	}
+	examples := make([]string, 0)
+	inExample := false
+	for _, line := range strings.Split(string(contents), "\n") {
+		switch {
+		case sampleSection.MatchString(line):
+			inExample = true
+		case inExample && sampleSetting.MatchString(line):
+		default:
+			inExample = false
+			continue
+		}
+		examples = append(examples, line[1:])
+	}
+	return strings.Join(examples, "\n")
+}
+
+func Test_configSampleSections(t *testing.T) {
+	config := &BurrowConfig{}
+	if err := gcfg.ReadStringInto(config, sampleSections(t)); err != nil {
+		t.Fatalf("Cannot parse the example sections of the sample config: %v", err)
+	}
+}
+
+func Test_configStaleConfig(t *testing.T) {
+	config := &BurrowConfig{}
+	if err := gcfg.ReadStringInto(config, "[stale-config]\ndays=30\ninterval=600\n"); err != nil {
+		t.Fatalf("Cannot parse config: %v", err)
+	}
+	if (config.StaleConfig.Days != 30) || (config.StaleConfig.Interval != 600) {
+		t.Errorf("Expected days 30 and interval 600, got %v and %v", config.StaleConfig.Days,
+			config.StaleConfig.Interval)
+	}
+}
@@ -12,15 +12,16 @@ package main
 
 import (
 	"fmt"
+	"github.com/linkedin/burrow/storage"
 )
 
-func printConsumerGroupStatus(status *ConsumerGroupStatus) {
+func printConsumerGroupStatus(status *storage.ConsumerGroupStatus) {
 	fmt.Println("-------------------------------------------------")
 	fmt.Println("Group: ", status.Group)
-	if status.Status == StatusOK {
+	if status.Status == storage.StatusOK {
 		fmt.Printf("Status: OK      (complete = %t)\n", status.Complete)
 	} else {
-		if status.Status == StatusWarning {
+		if status.Status == storage.StatusWarning {
 			fmt.Printf("Status: WARNING (complete = %t)\n", status.Complete)
 		} else {
 			fmt.Printf("Status: ERROR   (complete = %t)\n", status.Complete)
@@ -29,14 +30,18 @@ func printConsumerGroupStatus(status *ConsumerGroupStatus) {
 		for _, partition := range status.Partitions {
 			prefix := "     OK"
 			switch {
-			case partition.Status == StatusWarning:
+			case partition.Status == storage.StatusWarning:
 				prefix = "   WARN"
-			case partition.Status == StatusStop:
+			case partition.Status == storage.StatusStop:
 				prefix = "   STOP"
-			case partition.Status == StatusError:
+			case partition.Status == storage.StatusError:
 				prefix = "    ERR"
-			case partition.Status == StatusStall:
+			case partition.Status == storage.StatusStall:
 				prefix = "  STALL"
+			case partition.Status == storage.StatusRetention:
+				prefix = "  RETEN"
+			case partition.Status == storage.StatusOffline:
+				prefix = "OFFLINE"
 			default:
 				prefix = "   STOP"
 			}
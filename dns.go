@@ -0,0 +1,165 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources that implement this have the hosts they connect to looked up again every DNS ttl. If the addresses of a host
+// change (such as when a Kubernetes service is recreated), the source is restarted, so that it doesn't keep connections
+// open to addresses that are gone
+type ResolvedSource interface {
+	OffsetSource
+	Hosts() []string
+}
+
+// Look up the hosts of the running sources every ttl, and restart any source with a host whose addresses changed
+func (sources *OffsetSources) dnsRefresh() {
+	defer sources.retryGroup.Done()
+
+	// The last addresses seen for each host, by source type and cluster
+	addresses := make(map[string]map[string]map[string]string)
+	ticker := time.NewTicker(time.Duration(sources.app.Config.Dns.Ttl) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sources.quit:
+			return
+		case <-ticker.C:
+			for _, changed := range sources.findResolved(addresses) {
+				sources.restartSource(changed.name, changed.cluster, changed.reason)
+			}
+		}
+	}
+}
+
+func (sources *OffsetSources) findResolved(addresses map[string]map[string]map[string]string) []wedgedSource {
+	type sourceHosts struct {
+		name    string
+		cluster string
+		hosts   []string
+	}
+
+	// Don't hold the lock while looking up hosts, as that can be slow
+	sources.lock.RLock()
+	resolved := make([]sourceHosts, 0)
+	for name, clusters := range sources.running {
+		for cluster, source := range clusters {
+			if withHosts, ok := source.(ResolvedSource); ok {
+				resolved = append(resolved, sourceHosts{name: name, cluster: cluster, hosts: withHosts.Hosts()})
+			}
+		}
+	}
+	sources.lock.RUnlock()
+
+	changed := make([]wedgedSource, 0)
+	for _, source := range resolved {
+		if _, ok := addresses[source.name]; !ok {
+			addresses[source.name] = make(map[string]map[string]string)
+		}
+		last, ok := addresses[source.name][source.cluster]
+		if !ok {
+			last = make(map[string]string)
+			addresses[source.name][source.cluster] = last
+		}
+
+		reason := ""
+		for _, host := range source.hosts {
+			current, err := lookupHostAddresses(host)
+			if err != nil {
+				// Keep the last addresses, as the lookup may only have failed for a moment
+				log.Warnf("Cannot look up %s for the %s offset source for cluster %s: %v", host, source.name, source.cluster, err)
+				continue
+			}
+			if previous, ok := last[host]; ok && (previous != current) && (reason == "") {
+				reason = fmt.Sprintf("the addresses of %s changed from %s to %s", host, previous, current)
+			}
+			last[host] = current
+		}
+		if reason != "" {
+			// The restarted source starts over with the addresses that were just looked up
+			changed = append(changed, wedgedSource{name: source.name, cluster: source.cluster, reason: reason})
+		}
+	}
+	return changed
+}
+
+// A Zookeeper host provider that gives the hosts to the dialer as they are. The library's default provider looks the
+// hosts up once, when the client is created, and keeps using those addresses when it reconnects
+type zookeeperHostProvider struct {
+	lock    sync.Mutex
+	servers []string
+	curr    int
+	last    int
+}
+
+func (provider *zookeeperHostProvider) Init(servers []string) error {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	if len(servers) == 0 {
+		return errors.New("no Zookeeper hosts")
+	}
+	provider.servers = servers
+	provider.curr = -1
+	provider.last = -1
+	return nil
+}
+
+func (provider *zookeeperHostProvider) Len() int {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	return len(provider.servers)
+}
+
+// Return the next host to try, and whether all of the hosts have been tried since the last connection
+func (provider *zookeeperHostProvider) Next() (string, bool) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.curr = (provider.curr + 1) % len(provider.servers)
+	retryStart := provider.curr == provider.last
+	if provider.last == -1 {
+		provider.last = 0
+	}
+	return provider.servers[provider.curr], retryStart
+}
+
+func (provider *zookeeperHostProvider) Connected() {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.last = provider.curr
+}
+
+// Look up the addresses of a host (with or without a port), returning them sorted so they can be compared. IP
+// addresses don't need to be looked up, and are returned as they are
+func lookupHostAddresses(host string) (string, error) {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = trimBrackets(host)
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ","), nil
+}
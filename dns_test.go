@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/linkedin/burrow/storage"
+)
+
+// An offset source that does nothing
+type testOffsetSource struct{}
+
+func (source *testOffsetSource) Start(offsets chan *storage.PartitionOffset) error { return nil }
+func (source *testOffsetSource) Stop()                                             {}
+
+// An offset source that only reports its hosts
+type testResolvedSource struct {
+	testOffsetSource
+	hosts []string
+}
+
+func (source *testResolvedSource) Hosts() []string { return source.hosts }
+
+func Test_lookupHostAddresses(t *testing.T) {
+	for host, expected := range map[string]string{"10.0.0.1:9092": "10.0.0.1", "10.0.0.1": "10.0.0.1", "[::1]:2181": "::1", "[::1]": "::1"} {
+		if addresses, err := lookupHostAddresses(host); (err != nil) || (addresses != expected) {
+			t.Errorf("Expected %s to be %s, got %s (%v)", host, expected, addresses, err)
+		}
+	}
+	if addresses, err := lookupHostAddresses("localhost:2181"); (err != nil) || !strings.Contains(addresses, "127.0.0.1") {
+		t.Errorf("Expected localhost to be looked up, got %s (%v)", addresses, err)
+	}
+	if _, err := lookupHostAddresses("no-such-host.invalid"); err == nil {
+		t.Errorf("Expected an error for a host that doesn't resolve")
+	}
+}
+
+// A source is restarted when the addresses of one of its hosts change from the last lookup. The first lookup of a
+// host, and a lookup that fails, don't restart it
+func Test_findResolved(t *testing.T) {
+	sources := &OffsetSources{running: map[string]map[string]OffsetSource{
+		"kafka": {
+			"local":   &testResolvedSource{hosts: []string{"localhost:9092", "10.0.0.1:9092"}},
+			"staging": &testResolvedSource{hosts: []string{"10.0.0.2:9092", "no-such-host.invalid:9092"}},
+		},
+		"other": {"local": &testOffsetSource{}},
+	}}
+	addresses := map[string]map[string]map[string]string{
+		"kafka": {
+			"local":   {"localhost:9092": "10.9.9.9", "10.0.0.1:9092": "10.0.0.1"},
+			"staging": {"no-such-host.invalid:9092": "10.0.0.3"},
+		},
+	}
+
+	changed := sources.findResolved(addresses)
+	if (len(changed) != 1) || (changed[0].name != "kafka") || (changed[0].cluster != "local") ||
+		!strings.Contains(changed[0].reason, "the addresses of localhost:9092 changed from 10.9.9.9") {
+		t.Fatalf("Expected the local source to be restarted, got %+v", changed)
+	}
+	if !strings.Contains(addresses["kafka"]["local"]["localhost:9092"], "127.0.0.1") {
+		t.Errorf("Expected the new addresses to be kept, got %v", addresses["kafka"]["local"])
+	}
+	if (addresses["kafka"]["staging"]["10.0.0.2:9092"] != "10.0.0.2") || (addresses["kafka"]["staging"]["no-such-host.invalid:9092"] != "10.0.0.3") {
+		t.Errorf("Expected the first lookup to be recorded, and the last addresses kept for a failed one, got %v", addresses["kafka"]["staging"])
+	}
+	if _, ok := addresses["other"]; ok {
+		t.Errorf("Expected sources without hosts to be skipped")
+	}
+
+	if changed := sources.findResolved(addresses); len(changed) != 0 {
+		t.Errorf("Expected no restarts when nothing changed, got %+v", changed)
+	}
+}
+
+func Test_zookeeperHostProvider(t *testing.T) {
+	provider := &zookeeperHostProvider{}
+	if err := provider.Init(nil); err == nil {
+		t.Errorf("Expected an error without hosts")
+	}
+	if err := provider.Init([]string{"zk1:2181", "zk2:2181", "zk3:2181"}); (err != nil) || (provider.Len() != 3) {
+		t.Fatalf("Cannot init provider: %v", err)
+	}
+
+	next := func(expectedHost string, expectedRetry bool) {
+		t.Helper()
+		if host, retryStart := provider.Next(); (host != expectedHost) || (retryStart != expectedRetry) {
+			t.Errorf("Expected %s (retry start %v), got %s (%v)", expectedHost, expectedRetry, host, retryStart)
+		}
+	}
+	next("zk1:2181", false)
+	next("zk2:2181", false)
+	next("zk3:2181", false)
+	next("zk1:2181", true)
+
+	// After connecting, every host is tried again before the retry starts over
+	next("zk2:2181", false)
+	provider.Connected()
+	next("zk3:2181", false)
+	next("zk1:2181", false)
+	next("zk2:2181", true)
+}
@@ -0,0 +1,204 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// One place a group status could be sent, and whether it would be. If it would, the body that would be sent is
+// included, so templates can be checked as well
+type NotifierRoute struct {
+	Notifier  string `json:"notifier"`
+	Target    string `json:"target"`
+	Template  string `json:"template,omitempty"`
+	Action    string `json:"action"`
+	Threshold string `json:"threshold"`
+	Fires     bool   `json:"fires"`
+	Reason    string `json:"reason"`
+	Body      string `json:"body,omitempty"`
+}
+type HTTPResponseNotifierDryRun struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Routes  []*NotifierRoute        `json:"routes"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Take a group status (in the same format the status endpoint returns) and work out what each configured notifier
+// would do with it, without sending anything
+func handleNotifierDryRun(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "POST" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	var result storage.ConsumerGroupStatus
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "could not decode group status: "+err.Error(), w, r)
+	}
+	if (result.Cluster == "") || (result.Group == "") {
+		return makeErrorResponse(http.StatusBadRequest, "group status must have a cluster and group", w, r)
+	}
+
+	routes := make([]*NotifierRoute, 0)
+	if app.Emailer != nil {
+		routes = append(routes, app.Emailer.dryRun(&result)...)
+	}
+	if app.HttpNotifier != nil {
+		routes = append(routes, app.HttpNotifier.dryRun(&result)...)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = result.Cluster
+	requestInfo.Group = result.Group
+	jsonStr, err := json.Marshal(HTTPResponseNotifierDryRun{
+		Error:   false,
+		Message: "notifier routes returned",
+		Routes:  routes,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+// Each email address is sent the statuses of its groups when any of them reaches the threshold
+func (emailer *Emailer) dryRun(result *storage.ConsumerGroupStatus) []*NotifierRoute {
+	emails := make([]string, 0, len(emailer.app.Config.Email))
+	for email := range emailer.app.Config.Email {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	routes := make([]*NotifierRoute, 0, len(emails))
+	for _, email := range emails {
+		cfg := emailer.app.Config.Email[email]
+		route := &NotifierRoute{
+			Notifier:  "email",
+			Target:    email,
+			Action:    "send",
+			Threshold: emailThreshold(cfg.Warning).String(),
+			Template:  cfg.Template,
+		}
+		routes = append(routes, route)
+
+		listed := false
+		for _, group := range cfg.Groups {
+			if group == result.Cluster+","+result.Group {
+				listed = true
+				break
+			}
+		}
+		switch {
+		case !listed:
+			route.Reason = "group is not in the list for this address"
+		case result.PausedAt > 0:
+			route.Reason = "cluster is paused"
+		case result.Status == storage.StatusWarming:
+			route.Reason = "group is new and still warming"
+		case result.Status < emailThreshold(cfg.Warning):
+			route.Reason = "status is below the threshold"
+		case emailer.quiet[email].Suppress(result.Status, time.Now()):
+			route.Reason = "WARN is not sent during quiet hours"
+		default:
+			route.Fires = true
+			route.Reason = "status is at or above the threshold"
+			body, err := emailer.assembleEmail(email, []*storage.ConsumerGroupStatus{result})
+			if err != nil {
+				route.Reason = "email template failed: " + err.Error()
+			}
+			route.Body = string(body)
+		}
+	}
+	return routes
+}
+
+// The HTTP notifier checks every group that isn't blacklisted. It POSTs when the status reaches the threshold, and
+// sends a DELETE when the group is OK again (if it POSTed for the group before). Notifier template variants that match
+// the group are sent the same way, to their own URLs
+func (notifier *HttpNotifier) dryRun(result *storage.ConsumerGroupStatus) []*NotifierRoute {
+	routes := notifier.dryRunVariant(nil, result)
+	for _, variant := range notifier.variants {
+		routes = append(routes, notifier.dryRunVariant(variant, result)...)
+	}
+	return routes
+}
+
+func (notifier *HttpNotifier) dryRunVariant(variant *NotifierVariant, result *storage.ConsumerGroupStatus) []*NotifierRoute {
+	threshold := storage.StatusConstant(notifier.app.Config.Httpnotifier.PostThreshold)
+	target := notifier.app.Config.Httpnotifier.Url
+	templateName := ""
+	if variant != nil {
+		target = variant.Url
+		templateName = variant.Name
+	}
+	post := &NotifierRoute{
+		Notifier:  "http",
+		Target:    target,
+		Template:  templateName,
+		Action:    "POST",
+		Threshold: threshold.String(),
+	}
+	routes := []*NotifierRoute{post}
+
+	if (notifier.app.Storage.GroupBlacklist != nil) && notifier.app.Storage.GroupBlacklist.MatchString(result.Group) {
+		post.Reason = "group is blacklisted"
+		return routes
+	}
+	if result.PausedAt > 0 {
+		post.Reason = "cluster is paused"
+		return routes
+	}
+	if (variant != nil) && !variant.matches(result) {
+		post.Reason = "group does not match the notifier template's cluster, group, or tags"
+		return routes
+	}
+	if notifier.quiet.Suppress(result.Status, time.Now()) {
+		post.Reason = "WARN is not sent during quiet hours"
+		return routes
+	}
+
+	if int(result.Status) >= notifier.app.Config.Httpnotifier.PostThreshold {
+		post.Fires = true
+		post.Reason = "status is at or above the threshold"
+		body, err := notifier.assemblePost(variant, result, "dry-run", time.Now())
+		if err != nil {
+			post.Reason = "POST template failed: " + err.Error()
+		}
+		post.Body = body.String()
+	} else {
+		post.Reason = "status is below the threshold"
+	}
+
+	if notifier.app.Config.Httpnotifier.SendDelete && ((variant == nil) || (variant.templateDelete != nil)) {
+		remove := &NotifierRoute{
+			Notifier:  "http",
+			Target:    target,
+			Template:  templateName,
+			Action:    "DELETE",
+			Threshold: storage.StatusOK.String(),
+			Reason:    "status is not OK",
+		}
+		if result.Status == storage.StatusOK {
+			remove.Fires = true
+			remove.Reason = "status is OK, so this is sent if a POST was sent for the group before"
+		}
+		routes = append(routes, remove)
+	}
+	return routes
+}
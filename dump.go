@@ -0,0 +1,141 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/linkedin/burrow/storage"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// A diagnostics snapshot of a running instance, written to a file on SIGUSR1 so that state can be collected from a
+// misbehaving instance without attaching a debugger
+type DiagnosticsDump struct {
+	Time       int64  `json:"time"`
+	Uptime     int64  `json:"uptime"`
+	GoVersion  string `json:"go_version"`
+	Goroutines int    `json:"goroutines"`
+	Memory     struct {
+		Alloc       uint64 `json:"alloc"`
+		Sys         uint64 `json:"sys"`
+		HeapObjects uint64 `json:"heap_objects"`
+		NumGC       uint32 `json:"num_gc"`
+	} `json:"memory"`
+	Config         *BurrowConfig                `json:"config"`
+	Sources        map[string][]string          `json:"sources"`
+	SourceErrors   map[string]map[string]string `json:"source_errors"`
+	SourceRestarts map[string]map[string]int    `json:"source_restarts"`
+	AuditLogDepth  int                          `json:"audit_log_depth"`
+	Storage        *storage.Diagnostics         `json:"storage"`
+}
+
+// Write a diagnostics snapshot to a new file in the dump directory, returning the name of the file
+func dumpDiagnostics(app *ApplicationContext) (string, error) {
+	now := time.Now()
+	dump := &DiagnosticsDump{
+		Time:           now.Unix() * 1000,
+		Uptime:         int64(now.Sub(app.Storage.StartTime()).Seconds()),
+		GoVersion:      runtime.Version(),
+		Goroutines:     runtime.NumGoroutine(),
+		Config:         redactedConfig(app.Config),
+		Sources:        app.Sources.Running(),
+		SourceErrors:   app.Sources.Errors(),
+		SourceRestarts: make(map[string]map[string]int),
+		Storage:        app.Storage.Diagnostics(),
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	dump.Memory.Alloc = memStats.Alloc
+	dump.Memory.Sys = memStats.Sys
+	dump.Memory.HeapObjects = memStats.HeapObjects
+	dump.Memory.NumGC = memStats.NumGC
+
+	for cluster := range app.Config.Kafka {
+		if restarts := app.Sources.ClusterRestarts(cluster); restarts != nil {
+			dump.SourceRestarts[cluster] = restarts
+		}
+	}
+	if app.AuditLog != nil {
+		dump.AuditLogDepth = len(app.AuditLog.records)
+	}
+
+	jsonStr, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(app.Config.General.DumpDir, fmt.Sprintf("burrow-dump-%s.json", now.UTC().Format("20060102T150405")))
+	if app.Encryptor != nil {
+		// Read it with burrow -decrypt
+		jsonStr = app.Encryptor.Seal(jsonStr)
+		filename += ".enc"
+	}
+	return filename, ioutil.WriteFile(filename, jsonStr, 0600)
+}
+
+// Copy the config, leaving out any passwords, keys, and tokens, and the passwords in URLs. Sections that hold secrets
+// in maps are copied, so the running config is never changed
+func redactedConfig(config *BurrowConfig) *BurrowConfig {
+	redacted := *config
+	redact := func(secret *string) {
+		if *secret != "" {
+			*secret = "(redacted)"
+		}
+	}
+	redact(&redacted.Smtp.Password)
+	redact(&redacted.Storage.ObjectStoreAccessKey)
+	redact(&redacted.Storage.ObjectStoreSecretKey)
+	redact(&redacted.Bootstrap.Token)
+	redact(&redacted.TrendReport.SlackUrl)
+	redacted.Bootstrap.Peer = redactedURL(redacted.Bootstrap.Peer)
+	redacted.Httpnotifier.Url = redactedURL(redacted.Httpnotifier.Url)
+	redacted.Httpnotifier.SecondaryUrl = redactedURL(redacted.Httpnotifier.SecondaryUrl)
+	redacted.Health.PingUrl = redactedURL(redacted.Health.PingUrl)
+	redacted.TrendReport.Url = redactedURL(redacted.TrendReport.Url)
+	redacted.Encryption.VaultAddress = redactedURL(redacted.Encryption.VaultAddress)
+
+	redacted.AdminUser = make(map[string]*AdminUserConfig, len(config.AdminUser))
+	for name, cfg := range config.AdminUser {
+		user := *cfg
+		redact(&user.Token)
+		redacted.AdminUser[name] = &user
+	}
+	redacted.NotifierTemplate = make(map[string]*NotifierTemplateConfig, len(config.NotifierTemplate))
+	for name, cfg := range config.NotifierTemplate {
+		variant := *cfg
+		variant.Url = redactedURL(variant.Url)
+		redacted.NotifierTemplate[name] = &variant
+	}
+	redacted.ProduceAlert = make(map[string]*ProduceAlertConfig, len(config.ProduceAlert))
+	for name, cfg := range config.ProduceAlert {
+		alert := *cfg
+		alert.Url = redactedURL(alert.Url)
+		redacted.ProduceAlert[name] = &alert
+	}
+	return &redacted
+}
+
+// Replace the password in a URL, if it has one
+func redactedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if (err != nil) || (parsed.User == nil) {
+		return rawURL
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), "redacted")
+	}
+	return parsed.String()
+}
@@ -12,11 +12,14 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"net"
 	"net/smtp"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -24,38 +27,56 @@ import (
 type Emailer struct {
 	app       *ApplicationContext
 	template  *template.Template
+	variants  map[string]*NotifierVariant
 	Tickers   map[string]*time.Ticker
 	quitSends chan struct{}
+	sending   sync.WaitGroup
 	auth      smtp.Auth
+	breaker   *CircuitBreaker
+	quiet     map[string]*QuietHours
 }
 
 func NewEmailer(app *ApplicationContext) (*Emailer, error) {
-	template, err := template.ParseFiles(app.Config.Smtp.Template)
+	template, err := parseNotifierTemplate(app.Config.Smtp.Template, notifierTemplateFuncs(displayLocation(app.Config)))
 	if err != nil {
 		log.Critical("Cannot parse email template: %v", err)
 		os.Exit(1)
 	}
 
-	var auth smtp.Auth
-	switch app.Config.Smtp.AuthType {
-	case "plain":
-		auth = smtp.PlainAuth("", app.Config.Smtp.Username, app.Config.Smtp.Password, app.Config.Smtp.Server)
-	case "crammd5":
-		auth = smtp.CRAMMD5Auth(app.Config.Smtp.Username, app.Config.Smtp.Password)
+	// Addresses that have a template set get that variant of the email instead
+	allVariants, err := loadNotifierVariants(app.Config)
+	if err != nil {
+		return nil, err
+	}
+	variants := make(map[string]*NotifierVariant)
+	quiet := make(map[string]*QuietHours)
+	for email, cfg := range app.Config.Email {
+		for _, variant := range allVariants {
+			if variant.Name == cfg.Template {
+				variants[email] = variant
+			}
+		}
+		if quiet[email], err = NewQuietHours(cfg.QuietSchedule, cfg.QuietDuration, cfg.QuietWeekends, cfg.Timezone); err != nil {
+			return nil, err
+		}
 	}
 
 	return &Emailer{
 		app:       app,
 		template:  template,
+		variants:  variants,
 		Tickers:   make(map[string]*time.Ticker),
 		quitSends: make(chan struct{}),
-		auth:      auth,
+		auth:      smtpAuth(app.Config),
+		breaker:   NewCircuitBreaker(app, "email", net.JoinHostPort(trimBrackets(app.Config.Smtp.Server), strconv.Itoa(app.Config.Smtp.Port))),
+		quiet:     quiet,
 	}, nil
 }
 
 func (emailer *Emailer) Start() {
 	for email, cfg := range emailer.app.Config.Email {
 		emailer.Tickers[email] = time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+		emailer.sending.Add(1)
 		go emailer.sendEmailNotifications(email, cfg.Threshold, cfg.Groups, emailer.Tickers[email].C, cfg.Warning)
 	}
 }
@@ -64,35 +85,125 @@ func (emailer *Emailer) Stop() {
 	close(emailer.quitSends)
 }
 
-func (emailer *Emailer) sendEmail(to string, results []*ConsumerGroupStatus) {
+// Wait, after Stop, for the emails that were being sent to finish
+func (emailer *Emailer) Wait() {
+	emailer.sending.Wait()
+}
+
+func (emailer *Emailer) assembleEmail(to string, results []*storage.ConsumerGroupStatus) ([]byte, error) {
 	var bytesToSend bytes.Buffer
 
-	err := emailer.template.Execute(&bytesToSend, struct {
+	tmpl := emailer.template
+	locale := ""
+	if variant, ok := emailer.variants[to]; ok {
+		tmpl = variant.email
+		locale = variant.Locale
+	}
+	err := tmpl.Execute(&bytesToSend, struct {
 		From    string
 		To      string
-		Results []*ConsumerGroupStatus
+		Locale  string
+		Results []*storage.ConsumerGroupStatus
 	}{
 		From:    emailer.app.Config.Smtp.From,
 		To:      to,
+		Locale:  locale,
 		Results: results,
 	})
+	return bytesToSend.Bytes(), err
+}
+
+func (emailer *Emailer) sendEmail(to string, results []*storage.ConsumerGroupStatus) {
+	bytesToSend, err := emailer.assembleEmail(to, results)
 	if err != nil {
 		log.Error("Failed to assemble email:", err)
 	}
 
-	err = smtp.SendMail(fmt.Sprintf("%s:%v", emailer.app.Config.Smtp.Server, emailer.app.Config.Smtp.Port),
-		emailer.auth, emailer.app.Config.Smtp.From, []string{to}, bytesToSend.Bytes())
+	if !emailer.breaker.Allow() {
+		log.Debugf("Not sending email to %s, as the circuit breaker for the SMTP server is open", to)
+		return
+	}
+	err = sendMail(emailer.app, emailer.auth, to, bytesToSend)
 	if err != nil {
 		log.Error("Failed to send email message:", err)
+		emailer.breaker.Failed(err.Error())
+	} else {
+		emailer.breaker.Succeeded()
 	}
 }
 
-func (emailer *Emailer) sendEmailNotifications(email string, threshold string, groups []string, ticker <-chan time.Time, warning bool) {
-	// Convert the config threshold string into a value
-	thresholdVal := StatusError
+// The auth for the configured SMTP server, or nil if it has none
+func smtpAuth(cfg *BurrowConfig) smtp.Auth {
+	switch cfg.Smtp.AuthType {
+	case "plain":
+		return smtp.PlainAuth("", cfg.Smtp.Username, cfg.Smtp.Password, trimBrackets(cfg.Smtp.Server))
+	case "crammd5":
+		return smtp.CRAMMD5Auth(cfg.Smtp.Username, cfg.Smtp.Password)
+	}
+	return nil
+}
+
+// The same as smtp.SendMail, but connecting with the configured address family. The server can be an IPv6 address
+func sendMail(app *ApplicationContext, auth smtp.Auth, to string, msg []byte) error {
+	host := trimBrackets(app.Config.Smtp.Server)
+	timeout := time.Duration(app.Config.Smtp.Timeout) * time.Second
+	conn, err := newDialer(timeout, 0).Dial("tcp", net.JoinHostPort(host, strconv.Itoa(app.Config.Smtp.Port)))
+	if err != nil {
+		return err
+	}
+	// The timeout is for the whole conversation with the server, so a connection that is dropped doesn't hang the sender
+	conn.SetDeadline(time.Now().Add(timeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		tlsConfig := newTLSConfig()
+		tlsConfig.ServerName = host
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err = client.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err = client.Mail(app.Config.Smtp.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(msg); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// The status at which an email is sent
+func emailThreshold(warning bool) storage.StatusConstant {
 	if warning {
-		thresholdVal = StatusWarning
+		return storage.StatusWarning
 	}
+	return storage.StatusError
+}
+
+func (emailer *Emailer) sendEmailNotifications(email string, threshold string, groups []string, ticker <-chan time.Time, warning bool) {
+	defer emailer.sending.Done()
+	thresholdVal := emailThreshold(warning)
 
 OUTERLOOP:
 	for {
@@ -103,13 +214,13 @@ OUTERLOOP:
 			}
 			break OUTERLOOP
 		case <-ticker:
-			results := make([]*ConsumerGroupStatus, 0, len(groups))
-			resultChannel := make(chan *ConsumerGroupStatus)
+			results := make([]*storage.ConsumerGroupStatus, 0, len(groups))
+			resultChannel := make(chan *storage.ConsumerGroupStatus)
 
 			for _, group := range groups {
 				groupParts := strings.Split(group, ",")
-				storageRequest := &RequestConsumerStatus{Result: resultChannel, Cluster: groupParts[0], Group: groupParts[1]}
-				emailer.app.Storage.requestChannel <- storageRequest
+				storageRequest := &storage.RequestConsumerStatus{Result: resultChannel, Cluster: groupParts[0], Group: groupParts[1]}
+				emailer.app.Storage.RequestChannel <- storageRequest
 			}
 
 			for {
@@ -120,10 +231,18 @@ OUTERLOOP:
 				}
 			}
 
-			// Send an email if any of the results breaches the threshold
+			// Send an email if any of the results breaches the threshold. Groups in paused clusters, new groups
+			// that are still warming, and WARNs during quiet hours are not counted
+			now := time.Now()
 			for _, result := range results {
-				if result.Status >= thresholdVal {
+				if (result.PausedAt == 0) && (result.Status != storage.StatusWarming) && (result.Status >= thresholdVal) &&
+					!emailer.quiet[email].Suppress(result.Status, now) {
 					emailer.sendEmail(email, results)
+					for _, result := range results {
+						if result.Status >= thresholdVal {
+							emailer.app.Fatigue.Notified(result.Cluster, result.Group)
+						}
+					}
 					break
 				}
 			}
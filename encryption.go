@@ -0,0 +1,191 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Everything that is sealed starts with this, so that encrypted data can be told apart from plain data, and so the
+// format can be changed later
+var sealedMagic = []byte("BRWENC1")
+
+// The encryptor seals the state that Burrow writes to disk (diagnostics dumps and the audit log file) with AES-256-GCM,
+// as group and topic names can be sensitive. The key is 32 bytes, base64 encoded, and is read from a file, an
+// environment variable, or a Vault secret
+type Encryptor struct {
+	aead cipher.AEAD
+}
+
+func NewEncryptor(app *ApplicationContext) (*Encryptor, error) {
+	encoded, err := loadEncryptionKey(app)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("encryption key is not valid base64")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, not %v", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{aead: aead}, nil
+}
+
+func loadEncryptionKey(app *ApplicationContext) (string, error) {
+	cfg := app.Config.Encryption
+	switch {
+	case cfg.KeyFile != "":
+		key, err := ioutil.ReadFile(cfg.KeyFile)
+		return string(key), err
+	case cfg.KeyEnv != "":
+		key := os.Getenv(cfg.KeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("environment variable %s is not set", cfg.KeyEnv)
+		}
+		return key, nil
+	default:
+		return readVaultKey(cfg.VaultAddress, cfg.VaultPath, cfg.VaultField, os.Getenv(cfg.VaultTokenEnv))
+	}
+}
+
+// Read the key from a Vault KV secret. Both versions of the KV engine are supported: for version 2 the path includes
+// data/ (such as secret/data/burrow), and the fields are nested one level further down
+func readVaultKey(address string, path string, field string, token string) (string, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+		DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: newTLSConfig(),
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot read encryption key from Vault: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot read encryption key from Vault: %s", resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("cannot decode Vault response: %v", err)
+	}
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		fields = nested
+	}
+	key, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no field %s", path, field)
+	}
+	return key, nil
+}
+
+// Encrypt data, with a new random nonce each time
+func (encryptor *Encryptor) Seal(plaintext []byte) []byte {
+	nonce := make([]byte, encryptor.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		// The system's random source is broken, and nothing can be encrypted safely
+		panic(err)
+	}
+	sealed := append(append([]byte{}, sealedMagic...), nonce...)
+	return encryptor.aead.Seal(sealed, nonce, plaintext, nil)
+}
+
+// Decrypt data that was encrypted by Seal
+func (encryptor *Encryptor) Open(sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, sealedMagic) {
+		return nil, errors.New("data is not encrypted by Burrow")
+	}
+	sealed = sealed[len(sealedMagic):]
+	if len(sealed) < encryptor.aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce := sealed[:encryptor.aead.NonceSize()]
+	plaintext, err := encryptor.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return nil, errors.New("cannot decrypt data (wrong key, or the data was changed)")
+	}
+	return plaintext, nil
+}
+
+// Encrypt one line of a file that is appended to, such as the audit log. The result is base64, so it is still one line
+func (encryptor *Encryptor) SealLine(line []byte) []byte {
+	sealed := encryptor.Seal(line)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+	return encoded
+}
+
+// Decrypt a file written with encryption on to the writer. Files sealed as a whole (diagnostics dumps) and files sealed
+// line by line (the audit log) are both handled
+func (encryptor *Encryptor) DecryptFile(filename string, out io.Writer) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, sealedMagic) {
+		plaintext, err := encryptor.Open(data)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(plaintext)
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		sealed, err := base64.StdEncoding.DecodeString(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %v is not encrypted by Burrow", lineNum)
+		}
+		plaintext, err := encryptor.Open(sealed)
+		if err != nil {
+			return fmt.Errorf("line %v: %v", lineNum, err)
+		}
+		if _, err := out.Write(append(plaintext, '\n')); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testEncryptor(t *testing.T, key []byte) *Encryptor {
+	os.Setenv("BURROW_TEST_KEY", base64.StdEncoding.EncodeToString(key))
+	defer os.Unsetenv("BURROW_TEST_KEY")
+
+	app := &ApplicationContext{Config: &BurrowConfig{}}
+	app.Config.Encryption.KeyEnv = "BURROW_TEST_KEY"
+	encryptor, err := NewEncryptor(app)
+	if err != nil {
+		t.Fatalf("Cannot create encryptor: %v", err)
+	}
+	return encryptor
+}
+
+func Test_encryptorRoundTrip(t *testing.T) {
+	encryptor := testEncryptor(t, bytes.Repeat([]byte{1}, 32))
+	plaintext := []byte(`{"group":"payments-consumer"}`)
+
+	sealed := encryptor.Seal(plaintext)
+	if bytes.Contains(sealed, []byte("payments")) {
+		t.Fatalf("Sealed data contains the plaintext")
+	}
+	if bytes.Equal(sealed, encryptor.Seal(plaintext)) {
+		t.Errorf("Sealing the same data twice gave the same result")
+	}
+	opened, err := encryptor.Open(sealed)
+	if (err != nil) || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %s, got %s (%v)", plaintext, opened, err)
+	}
+
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := encryptor.Open(sealed); err == nil {
+		t.Errorf("Changed data was decrypted")
+	}
+	if _, err := testEncryptor(t, bytes.Repeat([]byte{2}, 32)).Open(encryptor.Seal(plaintext)); err == nil {
+		t.Errorf("Data was decrypted with the wrong key")
+	}
+}
+
+// Both whole files (dumps) and files of sealed lines (the audit log) can be decrypted
+func Test_encryptorDecryptFile(t *testing.T) {
+	encryptor := testEncryptor(t, bytes.Repeat([]byte{1}, 32))
+	dir, err := ioutil.TempDir("", "burrow-encryption")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(dir+"/dump", encryptor.Seal([]byte("dump contents")), 0600)
+	lines := append(append(encryptor.SealLine([]byte("one")), '\n'), append(encryptor.SealLine([]byte("two")), '\n')...)
+	ioutil.WriteFile(dir+"/audit", lines, 0600)
+
+	var out bytes.Buffer
+	if err := encryptor.DecryptFile(dir+"/dump", &out); (err != nil) || (out.String() != "dump contents") {
+		t.Errorf("Expected the dump contents, got %q (%v)", out.String(), err)
+	}
+	out.Reset()
+	if err := encryptor.DecryptFile(dir+"/audit", &out); (err != nil) || (out.String() != "one\ntwo\n") {
+		t.Errorf("Expected the audit lines, got %q (%v)", out.String(), err)
+	}
+}
@@ -0,0 +1,296 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The results of the last background evaluation of a cluster
+type ClusterEvaluation struct {
+	EvaluatedAt int64
+	Groups      map[string]*storage.ConsumerGroupStatus
+	Percentiles *LagPercentiles
+}
+
+// The distribution of lag across a cluster, as the nearest-rank percentiles of the total lag of each group and of the
+// lag of each partition of each group
+type LagPercentiles struct {
+	GroupLag     *LagDistribution `json:"group_lag"`
+	PartitionLag *LagDistribution `json:"partition_lag"`
+}
+
+type LagDistribution struct {
+	Count int   `json:"count"`
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+	Max   int64 `json:"max"`
+}
+
+// One of the most lagging partitions in a cluster, across all groups
+type LaggingPartition struct {
+	Group     string                 `json:"group"`
+	Topic     string                 `json:"topic"`
+	Partition int32                  `json:"partition"`
+	Lag       int64                  `json:"lag"`
+	Status    storage.StatusConstant `json:"status"`
+}
+
+type HTTPResponseMaxLag struct {
+	Error       bool                    `json:"error"`
+	Message     string                  `json:"message"`
+	EvaluatedAt int64                   `json:"evaluated_at"`
+	Partitions  []*LaggingPartition     `json:"partitions"`
+	Request     HTTPResponseRequestInfo `json:"request"`
+}
+type HTTPResponseLagPercentiles struct {
+	Error        bool                    `json:"error"`
+	Message      string                  `json:"message"`
+	EvaluatedAt  int64                   `json:"evaluated_at"`
+	GroupLag     *LagDistribution        `json:"group_lag"`
+	PartitionLag *LagDistribution        `json:"partition_lag"`
+	Request      HTTPResponseRequestInfo `json:"request"`
+}
+
+// The background evaluator evaluates every group in every Kafka cluster each interval, and keeps the latest results.
+// Views across all of the groups in a cluster (such as the worst lagging partitions) are served from these, rather
+// than evaluating every group on request
+type BackgroundEvaluator struct {
+	app      *ApplicationContext
+	clusters map[string]*ClusterEvaluation
+	lock     sync.RWMutex
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewBackgroundEvaluator(app *ApplicationContext) *BackgroundEvaluator {
+	return &BackgroundEvaluator{
+		app:      app,
+		clusters: make(map[string]*ClusterEvaluation),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Evaluate each cluster in its own goroutine, starting right away so that results are available soon after startup
+func (evaluator *BackgroundEvaluator) Start() {
+	for cluster := range evaluator.app.Config.Kafka {
+		evaluator.wg.Add(1)
+		go func(cluster string) {
+			defer evaluator.wg.Done()
+
+			ticker := time.NewTicker(time.Duration(evaluator.app.Config.Evaluator.Interval) * time.Second)
+			defer ticker.Stop()
+			for {
+				evaluator.evaluateCluster(cluster)
+				select {
+				case <-evaluator.quit:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(cluster)
+	}
+}
+
+func (evaluator *BackgroundEvaluator) Stop() {
+	close(evaluator.quit)
+	evaluator.wg.Wait()
+}
+
+// Evaluate the groups in a cluster one at a time, so that the storage module isn't flooded with requests. If the
+// storage module is too busy, the previous results are kept until the next interval
+func (evaluator *BackgroundEvaluator) evaluateCluster(cluster string) {
+	storageRequest := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+	if !sendStorageRequest(evaluator.app, storageRequest) {
+		log.Warnf("Skipping background evaluation of cluster %s: %s", cluster, storageBusyReason)
+		return
+	}
+	groups := <-storageRequest.Result
+
+	evaluation := &ClusterEvaluation{Groups: make(map[string]*storage.ConsumerGroupStatus, len(groups))}
+	for _, group := range groups {
+		select {
+		case <-evaluator.quit:
+			return
+		default:
+		}
+
+		storageRequest := &storage.RequestConsumerStatus{
+			Result:     make(chan *storage.ConsumerGroupStatus),
+			Cluster:    cluster,
+			Group:      group,
+			Showall:    true,
+			Background: true,
+		}
+		if !sendStorageRequest(evaluator.app, storageRequest) {
+			log.Warnf("Skipping background evaluation of cluster %s: %s", cluster, storageBusyReason)
+			return
+		}
+		status := <-storageRequest.Result
+		if status.Status != storage.StatusNotFound {
+			evaluation.Groups[group] = status
+		}
+	}
+	evaluation.EvaluatedAt = time.Now().Unix() * 1000
+	evaluation.Percentiles = evaluation.lagPercentiles()
+
+	evaluator.lock.Lock()
+	evaluator.clusters[cluster] = evaluation
+	evaluator.lock.Unlock()
+}
+
+// Return the last evaluation of a cluster, or nil if it hasn't been evaluated yet. The results must not be changed
+func (evaluator *BackgroundEvaluator) Cluster(cluster string) *ClusterEvaluation {
+	evaluator.lock.RLock()
+	defer evaluator.lock.RUnlock()
+	return evaluator.clusters[cluster]
+}
+
+// Return the n partitions with the most lag across all of the groups in the evaluation, most lagging first. Ties are
+// broken by group, topic, and partition so the order is stable
+func (evaluation *ClusterEvaluation) MaxLag(n int) []*LaggingPartition {
+	partitions := make([]*LaggingPartition, 0)
+	for group, status := range evaluation.Groups {
+		for _, partition := range status.Partitions {
+			partitions = append(partitions, &LaggingPartition{
+				Group:     group,
+				Topic:     partition.Topic,
+				Partition: partition.Partition,
+				Lag:       partition.End.Lag,
+				Status:    partition.Status,
+			})
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		a, b := partitions[i], partitions[j]
+		switch {
+		case a.Lag != b.Lag:
+			return a.Lag > b.Lag
+		case a.Group != b.Group:
+			return a.Group < b.Group
+		case a.Topic != b.Topic:
+			return a.Topic < b.Topic
+		default:
+			return a.Partition < b.Partition
+		}
+	})
+	if len(partitions) > n {
+		partitions = partitions[:n]
+	}
+	return partitions
+}
+
+func (evaluation *ClusterEvaluation) lagPercentiles() *LagPercentiles {
+	groupLags := make([]int64, 0, len(evaluation.Groups))
+	partitionLags := make([]int64, 0)
+	for _, status := range evaluation.Groups {
+		groupLags = append(groupLags, int64(status.TotalLag))
+		for _, partition := range status.Partitions {
+			partitionLags = append(partitionLags, partition.End.Lag)
+		}
+	}
+	return &LagPercentiles{
+		GroupLag:     newLagDistribution(groupLags),
+		PartitionLag: newLagDistribution(partitionLags),
+	}
+}
+
+// Sort the lags and take the nearest-rank percentiles. With no lags, everything is zero
+func newLagDistribution(lags []int64) *LagDistribution {
+	distribution := &LagDistribution{Count: len(lags)}
+	if len(lags) == 0 {
+		return distribution
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i] < lags[j] })
+	percentile := func(p int) int64 {
+		rank := (p*len(lags) + 99) / 100
+		return lags[rank-1]
+	}
+	distribution.P50 = percentile(50)
+	distribution.P90 = percentile(90)
+	distribution.P99 = percentile(99)
+	distribution.Max = lags[len(lags)-1]
+	return distribution
+}
+
+// Handle GET /v2/kafka/(cluster)/maxlag?n=(N), which returns the N (default 10) most lagging partitions from the last
+// background evaluation of the cluster
+func handleClusterMaxLag(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	if app.Evaluator == nil {
+		return makeErrorResponse(http.StatusNotFound, "the background evaluator is not enabled", w, r)
+	}
+	n := 10
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		var err error
+		if n, err = strconv.Atoi(nStr); (err != nil) || (n < 1) {
+			return makeErrorResponse(http.StatusBadRequest, "n must be a positive number", w, r)
+		}
+	}
+	evaluation := app.Evaluator.Cluster(cluster)
+	if evaluation == nil {
+		w.Header().Set("Retry-After", strconv.FormatInt(app.Config.Evaluator.Interval, 10))
+		return makeErrorResponse(http.StatusServiceUnavailable, "the cluster has not been evaluated yet", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseMaxLag{
+		Error:       false,
+		Message:     "most lagging partitions returned",
+		EvaluatedAt: evaluation.EvaluatedAt,
+		Partitions:  evaluation.MaxLag(n),
+		Request:     requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+// Handle GET /v2/kafka/(cluster)/lag-percentiles, which returns the p50, p90, and p99 of group total lag and of
+// partition lag from the last background evaluation of the cluster
+func handleClusterLagPercentiles(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	if app.Evaluator == nil {
+		return makeErrorResponse(http.StatusNotFound, "the background evaluator is not enabled", w, r)
+	}
+	evaluation := app.Evaluator.Cluster(cluster)
+	if evaluation == nil {
+		w.Header().Set("Retry-After", strconv.FormatInt(app.Config.Evaluator.Interval, 10))
+		return makeErrorResponse(http.StatusServiceUnavailable, "the cluster has not been evaluated yet", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseLagPercentiles{
+		Error:        false,
+		Message:      "lag percentiles returned",
+		EvaluatedAt:  evaluation.EvaluatedAt,
+		GroupLag:     evaluation.Percentiles.GroupLag,
+		PartitionLag: evaluation.Percentiles.PartitionLag,
+		Request:      requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
@@ -0,0 +1,223 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sync"
+)
+
+// One offset in the exported stream. Type is "commit" for a consumer offset commit, or "broker" for a broker offset
+// (which has no group)
+type ExportedOffset struct {
+	Type      string `json:"type"`
+	Cluster   string `json:"cluster"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Group     string `json:"group,omitempty"`
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// How many offsets can be waiting for each stream client before offsets are dropped for it
+const exportStreamBuffer = 1000
+
+// The offset export re-publishes the offsets the storage module accepts (so after the blacklists and validation, and
+// with commit mappings and ephemeral groups applied) to a Kafka topic and/or to clients streaming them over HTTP, so
+// other systems can use Burrow's cleaned up commit feed rather than reading the offsets topic themselves. As with the
+// audit log, offsets are published in the order they are accepted by a single goroutine. A stream client that can't
+// keep up misses offsets, rather than holding up the others. The stream is a chunked HTTP response rather than a gRPC
+// stream, as there is no gRPC library or protobuf schema in Burrow for one
+type OffsetExport struct {
+	app      *ApplicationContext
+	records  chan *ExportedOffset
+	producer sarama.AsyncProducer
+	clients  map[chan *ExportedOffset]struct{}
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+	done     chan struct{}
+	stopped  bool
+	stopLock sync.RWMutex
+}
+
+func NewOffsetExport(app *ApplicationContext) (*OffsetExport, error) {
+	export := &OffsetExport{
+		app:     app,
+		records: make(chan *ExportedOffset, 10000),
+		clients: make(map[chan *ExportedOffset]struct{}),
+		done:    make(chan struct{}),
+	}
+	app.Metrics.Register("burrow_offset_export_dropped_total", MetricCounter, "Exported offsets that stream clients missed because they were not keeping up")
+
+	if app.Config.Export.KafkaTopic != "" {
+		clientConfig := newSaramaConfig(app, app.Config.Export.KafkaCluster)
+		clientConfig.Producer.RequiredAcks = sarama.WaitForAll
+		clientConfig.Producer.Return.Errors = true
+		producer, err := sarama.NewAsyncProducer(app.Config.Kafka[app.Config.Export.KafkaCluster].Brokers, clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		export.producer = producer
+
+		export.wg.Add(1)
+		go func() {
+			defer export.wg.Done()
+			for err := range producer.Errors() {
+				log.Errorf("Cannot write offset to export topic %s: %v", app.Config.Export.KafkaTopic, err.Err)
+			}
+		}()
+	}
+
+	go export.writer()
+	return export, nil
+}
+
+// Export an accepted offset. This is the storage module's commit hook, and its broker offset hook if broker offsets
+// are exported
+func (export *OffsetExport) Record(offset *storage.PartitionOffset) {
+	export.stopLock.RLock()
+	defer export.stopLock.RUnlock()
+	if export.stopped {
+		return
+	}
+
+	record := &ExportedOffset{
+		Type:      "commit",
+		Cluster:   offset.Cluster,
+		Topic:     offset.Topic,
+		Partition: offset.Partition,
+		Group:     offset.Group,
+		Offset:    offset.Offset,
+		Timestamp: offset.Timestamp,
+	}
+	if offset.Group == "" {
+		record.Type = "broker"
+	}
+	export.records <- record
+}
+
+func (export *OffsetExport) writer() {
+	defer close(export.done)
+
+	for record := range export.records {
+		if export.producer != nil {
+			line, err := json.Marshal(record)
+			if err != nil {
+				log.Errorf("Cannot encode exported offset: %v", err)
+				continue
+			}
+
+			// Commits are keyed by group and broker offsets by topic, so each is in order in the export topic
+			key := record.Group
+			if record.Type == "broker" {
+				key = record.Topic
+			}
+			export.producer.Input() <- &sarama.ProducerMessage{
+				Topic: export.app.Config.Export.KafkaTopic,
+				Key:   sarama.StringEncoder(record.Cluster + "/" + key),
+				Value: sarama.ByteEncoder(line),
+			}
+		}
+
+		export.lock.Lock()
+		for client := range export.clients {
+			select {
+			case client <- record:
+			default:
+				export.app.Metrics.Add("burrow_offset_export_dropped_total", nil, 1)
+			}
+		}
+		export.lock.Unlock()
+	}
+}
+
+// Start sending exported offsets to a stream client. The returned function must be called when the client goes away
+func (export *OffsetExport) Subscribe() (chan *ExportedOffset, func()) {
+	client := make(chan *ExportedOffset, exportStreamBuffer)
+	export.lock.Lock()
+	export.clients[client] = struct{}{}
+	export.lock.Unlock()
+
+	return client, func() {
+		export.lock.Lock()
+		delete(export.clients, client)
+		export.lock.Unlock()
+	}
+}
+
+// Stop the export, after publishing any offsets that are waiting. This should be called after the storage module is
+// stopped, so that nothing else is exported
+func (export *OffsetExport) Stop() {
+	export.stopLock.Lock()
+	export.stopped = true
+	close(export.records)
+	export.stopLock.Unlock()
+	<-export.done
+
+	if export.producer != nil {
+		export.producer.AsyncClose()
+	}
+	export.wg.Wait()
+}
+
+// Call both offset hooks. Either one can be nil
+func chainOffsetHooks(first func(*storage.PartitionOffset), second func(*storage.PartitionOffset)) func(*storage.PartitionOffset) {
+	switch {
+	case first == nil:
+		return second
+	case second == nil:
+		return first
+	}
+	return func(offset *storage.PartitionOffset) {
+		first(offset)
+		second(offset)
+	}
+}
+
+// Handle GET /v2/export/offsets, which streams the exported offsets as they are accepted until the client goes away,
+// as NDJSON or (with format=sse) server-sent "offset" events
+func handleOffsetExport(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if (app.Export == nil) || !app.Config.Export.Stream {
+		return makeErrorResponse(http.StatusNotFound, "the offset export stream is not enabled", w, r)
+	}
+
+	records, unsubscribe := app.Export.Subscribe()
+	defer unsubscribe()
+	produce := func(emit func(event string, data interface{}) error) error {
+		for {
+			select {
+			case record := <-records:
+				if err := emit("offset", record); err != nil {
+					return err
+				}
+			case <-r.Context().Done():
+				return nil
+			}
+		}
+	}
+	if statusStreamFormat(r) == "sse" {
+		streamSSE(w, r, produce)
+	} else {
+		streamNDJSON(w, r, func(emit func(interface{}) error) error {
+			return produce(func(event string, data interface{}) error {
+				return emit(data)
+			})
+		})
+	}
+	return 200, ""
+}
@@ -0,0 +1,236 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Alert fatigue is tracked for each group from its evaluations and the notifications sent for it: how many
+// notifications were sent, how many times it flapped between OK and ERR, and how long it stays in ERR. These are kept
+// in memory since startup, and reported (noisiest groups first) at /v2/burrow/alert-fatigue
+type AlertFatigue struct {
+	startedAt int64
+	lock      sync.Mutex
+	groups    map[string]map[string]*groupFatigue
+}
+
+type groupFatigue struct {
+	notifications int64
+	flaps         int64
+
+	// The last status that was OK or ERR (WARN doesn't count as a flap either way), when the group went into ERR if
+	// it is in ERR now, and the number and total length (in milliseconds) of the times it was in ERR before
+	lastStatus storage.StatusConstant
+	errSince   int64
+	errPeriods int64
+	errTotal   int64
+}
+
+// The alert fatigue of a group. The average time in ERR includes the current one, if the group is in ERR
+type GroupFatigue struct {
+	Cluster           string  `json:"cluster"`
+	Group             string  `json:"group"`
+	Notifications     int64   `json:"notifications"`
+	Flaps             int64   `json:"flaps"`
+	ErrPeriods        int64   `json:"err_periods"`
+	AverageErrSeconds float64 `json:"average_err_seconds"`
+	InErrSince        int64   `json:"in_err_since,omitempty"`
+}
+
+type HTTPResponseAlertFatigue struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Since   int64                   `json:"since"`
+	Groups  []*GroupFatigue         `json:"groups"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+func NewAlertFatigue() *AlertFatigue {
+	return &AlertFatigue{
+		startedAt: time.Now().UnixNano() / int64(time.Millisecond),
+		groups:    make(map[string]map[string]*groupFatigue),
+	}
+}
+
+// A storage status hook that tracks the flaps and time in ERR of each group
+func (fatigue *AlertFatigue) Record(status *storage.ConsumerGroupStatus) {
+	fatigue.record(status, time.Now().UnixNano()/int64(time.Millisecond))
+}
+
+func (fatigue *AlertFatigue) record(status *storage.ConsumerGroupStatus, now int64) {
+	if (status.PausedAt > 0) || (status.Status == storage.StatusWarming) {
+		// The status of a paused cluster is frozen, and new groups aren't alerted for until they are warmed up
+		return
+	}
+	fatigue.lock.Lock()
+	defer fatigue.lock.Unlock()
+
+	if status.Status == storage.StatusNotFound {
+		// The group was removed or expired
+		delete(fatigue.groups[status.Cluster], status.Group)
+		return
+	}
+	if (status.Status != storage.StatusOK) && (status.Status != storage.StatusError) {
+		return
+	}
+	group := fatigue.group(status.Cluster, status.Group)
+	if status.Status == group.lastStatus {
+		return
+	}
+
+	if status.Status == storage.StatusError {
+		group.errSince = now
+	} else if group.errSince > 0 {
+		group.errPeriods++
+		group.errTotal += now - group.errSince
+		group.errSince = 0
+	}
+	if group.lastStatus != storage.StatusNotFound {
+		group.flaps++
+	}
+	group.lastStatus = status.Status
+}
+
+// Count a notification sent for a group. A nil tracker does nothing
+func (fatigue *AlertFatigue) Notified(cluster string, group string) {
+	if fatigue == nil {
+		return
+	}
+	fatigue.lock.Lock()
+	fatigue.group(cluster, group).notifications++
+	fatigue.lock.Unlock()
+}
+
+// Must be called with the lock held
+func (fatigue *AlertFatigue) group(cluster string, group string) *groupFatigue {
+	if _, ok := fatigue.groups[cluster]; !ok {
+		fatigue.groups[cluster] = make(map[string]*groupFatigue)
+	}
+	if _, ok := fatigue.groups[cluster][group]; !ok {
+		fatigue.groups[cluster][group] = &groupFatigue{}
+	}
+	return fatigue.groups[cluster][group]
+}
+
+// Return the alert fatigue of the groups in a cluster (or every cluster, if it is empty), with the most notified
+// groups first, then the ones that flapped most
+func (fatigue *AlertFatigue) Report(cluster string, now int64) []*GroupFatigue {
+	fatigue.lock.Lock()
+	defer fatigue.lock.Unlock()
+
+	report := make([]*GroupFatigue, 0)
+	for clusterName, groups := range fatigue.groups {
+		if (cluster != "") && (clusterName != cluster) {
+			continue
+		}
+		for groupName, group := range groups {
+			entry := &GroupFatigue{
+				Cluster:       clusterName,
+				Group:         groupName,
+				Notifications: group.notifications,
+				Flaps:         group.flaps,
+				ErrPeriods:    group.errPeriods,
+				InErrSince:    group.errSince,
+			}
+			periods, total := group.errPeriods, group.errTotal
+			if group.errSince > 0 {
+				periods++
+				total += now - group.errSince
+			}
+			if periods > 0 {
+				entry.AverageErrSeconds = float64(total) / float64(periods) / 1000
+			}
+			report = append(report, entry)
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		switch {
+		case a.Notifications != b.Notifications:
+			return a.Notifications > b.Notifications
+		case a.Flaps != b.Flaps:
+			return a.Flaps > b.Flaps
+		case a.Cluster != b.Cluster:
+			return a.Cluster < b.Cluster
+		default:
+			return a.Group < b.Group
+		}
+	})
+	return report
+}
+
+// Call both status hooks. Either one can be nil
+func chainStatusHooks(first func(*storage.ConsumerGroupStatus), second func(*storage.ConsumerGroupStatus)) func(*storage.ConsumerGroupStatus) {
+	switch {
+	case first == nil:
+		return second
+	case second == nil:
+		return first
+	}
+	return func(status *storage.ConsumerGroupStatus) {
+		first(status)
+		second(status)
+	}
+}
+
+// Handle GET /v2/burrow/alert-fatigue?cluster=(cluster)&n=(N), which returns the N (default 20) noisiest groups since
+// startup, by notifications sent and then by flaps between OK and ERR
+func handleAlertFatigue(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	cluster := r.URL.Query().Get("cluster")
+	if cluster != "" {
+		if _, ok := app.Config.Kafka[cluster]; !ok {
+			return makeErrorResponse(http.StatusNotFound, "cluster not found", w, r)
+		}
+	}
+	n := 20
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		var err error
+		if n, err = strconv.Atoi(nStr); (err != nil) || (n < 1) {
+			return makeErrorResponse(http.StatusBadRequest, "n must be a positive number", w, r)
+		}
+	}
+
+	groups := make([]*GroupFatigue, 0)
+	for _, group := range app.Fatigue.Report(cluster, time.Now().UnixNano()/int64(time.Millisecond)) {
+		if app.AdminAudit.ClusterAllowed(r, group.Cluster) {
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) > n {
+		groups = groups[:n]
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseAlertFatigue{
+		Error:   false,
+		Message: "alert fatigue report returned",
+		Since:   app.Fatigue.startedAt,
+		Groups:  groups,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
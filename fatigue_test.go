@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/linkedin/burrow/storage"
+	"testing"
+)
+
+func Test_alertFatigue(t *testing.T) {
+	fatigue := NewAlertFatigue()
+	status := func(group string, value storage.StatusConstant) *storage.ConsumerGroupStatus {
+		return &storage.ConsumerGroupStatus{Cluster: "local", Group: group, Status: value}
+	}
+
+	// OK -> ERR for a minute -> WARN (not a flap) -> ERR -> OK after two minutes -> ERR, and still in ERR
+	for _, step := range []struct {
+		status storage.StatusConstant
+		at     int64
+	}{
+		{storage.StatusOK, 0},
+		{storage.StatusError, 10000},
+		{storage.StatusWarning, 70000},
+		{storage.StatusError, 80000},
+		{storage.StatusOK, 200000},
+		{storage.StatusOK, 210000},
+		{storage.StatusError, 300000},
+	} {
+		fatigue.record(status("flapping", step.status), step.at)
+	}
+	fatigue.record(status("steady", storage.StatusOK), 0)
+	fatigue.Notified("local", "steady")
+	for i := 0; i < 3; i++ {
+		fatigue.Notified("local", "flapping")
+	}
+
+	// A paused status is frozen, so it doesn't end the time in ERR
+	paused := status("flapping", storage.StatusOK)
+	paused.PausedAt = 1
+	fatigue.record(paused, 310000)
+
+	report := fatigue.Report("", 360000)
+	if (len(report) != 2) || (report[0].Group != "flapping") || (report[1].Group != "steady") {
+		t.Fatalf("Expected the flapping group first, got %+v", report)
+	}
+	flapping := report[0]
+	if (flapping.Notifications != 3) || (flapping.Flaps != 3) || (flapping.ErrPeriods != 1) || (flapping.InErrSince != 300000) {
+		t.Errorf("Unexpected fatigue for the flapping group: %+v", flapping)
+	}
+	// One time in ERR from 10s to 200s (190s), and the current one from 300s to 360s (60s)
+	if flapping.AverageErrSeconds != 125 {
+		t.Errorf("Expected an average of 125 seconds in ERR, got %v", flapping.AverageErrSeconds)
+	}
+	if (report[1].Flaps != 0) || (report[1].AverageErrSeconds != 0) {
+		t.Errorf("Unexpected fatigue for the steady group: %+v", report[1])
+	}
+
+	if report := fatigue.Report("other", 360000); len(report) != 0 {
+		t.Errorf("Expected nothing for another cluster, got %+v", report)
+	}
+	fatigue.record(status("steady", storage.StatusNotFound), 400000)
+	if report := fatigue.Report("local", 400000); len(report) != 1 {
+		t.Errorf("Expected a removed group to be forgotten, got %+v", report)
+	}
+}
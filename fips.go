@@ -0,0 +1,62 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// In FIPS mode, only FIPS-approved algorithms are used. It is on if Burrow was built with the fips tag (see
+// fips_build.go), or if fips is set in the [general] config section. Building with the tag also makes Go's own crypto
+// refuse anything else, while the config setting only restricts what Burrow asks for:
+//   - TLS (to Kafka, the HTTP notifier, and Vault) is TLS 1.2 with ECDHE and AES-GCM, on the NIST curves
+//   - Certificate checks can't be turned off (tls-noverify), and SMTP CRAM-MD5 auth can't be used
+//   - The hashes used for ETags and status link IDs are SHA-256 rather than FNV. These aren't used for security, but
+//     this keeps all hashing to approved algorithms
+var fipsMode = fipsBuild
+
+// The TLS 1.2 cipher suites that are FIPS approved. TLS 1.3 is not used in FIPS mode, as its cipher suites can't be
+// restricted
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// Return a TLS config for a client connection. In FIPS mode it only allows approved versions, cipher suites, and
+// curves. Every TLS config Burrow makes should come from here
+func newTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{}
+	if fipsMode {
+		tlsConfig.MinVersion = tls.VersionTLS12
+		tlsConfig.MaxVersion = tls.VersionTLS12
+		tlsConfig.CipherSuites = fipsCipherSuites
+		tlsConfig.CurvePreferences = fipsCurves
+	}
+	return tlsConfig
+}
+
+// Return a 64-bit hash of the data, for IDs that only need to be stable, not secure
+func idHash(data []byte) uint64 {
+	if fipsMode {
+		sum := sha256.Sum256(data)
+		return binary.BigEndian.Uint64(sum[:8])
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64()
+}
@@ -0,0 +1,21 @@
+//go:build fips
+// +build fips
+
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+// A FIPS build needs a Go toolchain with BoringCrypto (GOEXPERIMENT=boringcrypto go build -tags fips). The fipsonly
+// package restricts crypto/tls to FIPS-approved settings, and doesn't exist in other toolchains, so the build fails
+// rather than producing a binary that isn't FIPS compliant
+import _ "crypto/tls/fipsonly"
+
+const fipsBuild = true
@@ -0,0 +1,564 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// The largest query body that is accepted
+const graphQLMaxBodySize = 64 * 1024
+
+// The schema served at /graphql, which lets a UI fetch the nested data a view needs in one request. It is served as
+// text by GET /graphql/schema. Keep it in step with the resolvers below
+const graphQLSchema = `type Query {
+  clusters: [Cluster!]!
+  cluster(name: String!): Cluster
+}
+
+type Cluster {
+  name: String!
+  pausedAt: Int
+  topics: [Topic!]!
+  topic(name: String!): Topic
+  groups: [Group!]!
+  group(name: String!): Group
+}
+
+type Topic {
+  name: String!
+  partitions: [TopicPartition!]!
+  rate: Float
+}
+
+type TopicPartition {
+  partition: Int!
+  offset: Int!
+  stableOffset: Int
+}
+
+type Group {
+  name: String!
+  cluster: String!
+  tags: [Tag!]!
+  status(showall: Boolean = false): Status
+  topics: [GroupTopic!]!
+  topic(name: String!): GroupTopic
+}
+
+type Tag {
+  name: String!
+  value: String!
+}
+
+type GroupTopic {
+  name: String!
+  partitions: [GroupPartition!]!
+  # Offsets committed as of a time (milliseconds, or RFC 3339), from the offset archive
+  history(timestamp: String!): [GroupPartition!]!
+}
+
+type GroupPartition {
+  partition: Int!
+  offset: Int
+  timestamp: Int
+  lag: Int
+}
+
+type Status {
+  status: String!
+  complete: Boolean!
+  totalLag: Int!
+  maxLag: PartitionStatus
+  partitions: [PartitionStatus!]!
+  missing: Boolean!
+  missingTopics: [String!]!
+  pausedAt: Int
+  pausedAtTime: String
+  # Key/values set on the group with PUT /v2/kafka/(cluster)/consumer/(group)/metadata
+  metadata: [Tag!]!
+}
+
+type PartitionStatus {
+  topic: String!
+  partition: Int!
+  status: String!
+  start: Offset!
+  end: Offset!
+  timeToRetention: Int!
+  compacted: Boolean!
+  priority: Boolean!
+  recentLeaderChange: Boolean!
+  # The member of the group the partition is assigned to, if it is known
+  owner: PartitionOwner
+}
+
+type PartitionOwner {
+  memberId: String!
+  clientId: String!
+  clientHost: String!
+}
+
+type Offset {
+  offset: Int!
+  timestamp: Int!
+  time: String!
+  lag: Int!
+  source: String!
+}
+`
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handle GET and POST /graphql. Queries can be sent as the body of a POST (as JSON, or as the bare query with a
+// Content-Type of application/graphql), or in the query, operationName, and variables params of a GET. The response is
+// the usual GraphQL data and errors, rather than the wrapper the REST endpoints use
+func handleGraphQL(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	var request graphQLRequest
+	switch r.Method {
+	case "GET":
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return makeErrorResponse(http.StatusBadRequest, "variables must be a JSON object", w, r)
+			}
+		}
+	case "POST":
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, graphQLMaxBodySize+1))
+		if err != nil {
+			return makeErrorResponse(http.StatusBadRequest, "could not read request body", w, r)
+		}
+		if len(body) > graphQLMaxBodySize {
+			return makeErrorResponse(http.StatusRequestEntityTooLarge, "query is larger than "+strconv.Itoa(graphQLMaxBodySize)+" bytes", w, r)
+		}
+		if r.Header.Get("Content-Type") == "application/graphql" {
+			request.Query = string(body)
+		} else if err := json.Unmarshal(body, &request); err != nil {
+			return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+		}
+	default:
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if request.Query == "" {
+		return makeErrorResponse(http.StatusBadRequest, "query is required", w, r)
+	}
+
+	response, err := executeGraphQL(graphQLRoot(app), request.Query, request.OperationName, request.Variables)
+	status := http.StatusOK
+	if err != nil {
+		response = &gqlResponse{Errors: []*gqlError{{Message: err.Error()}}}
+		status = http.StatusBadRequest
+	}
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.WriteHeader(status)
+	w.Write(jsonStr)
+	return status, ""
+}
+
+func handleGraphQLSchema(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, graphQLSchema)
+	return 200, ""
+}
+
+// Send a request to the storage module for a field. If it is not taken in time, the field fails rather than the query
+// hanging
+func graphQLStorageRequest(app *ApplicationContext, request interface{}) error {
+	if !sendStorageRequest(app, request) {
+		return errors.New(storageBusyReason)
+	}
+	return nil
+}
+
+func graphQLRoot(app *ApplicationContext) *gqlObject {
+	return &gqlObject{typename: "Query", fields: map[string]*gqlField{
+		"clusters": {resolve: func(gqlArgs) (interface{}, error) {
+			names := make([]string, 0, len(app.Config.Kafka))
+			for cluster := range app.Config.Kafka {
+				names = append(names, cluster)
+			}
+			sort.Strings(names)
+
+			clusters := make([]*gqlObject, len(names))
+			for i, cluster := range names {
+				clusters[i] = graphQLCluster(app, cluster)
+			}
+			return clusters, nil
+		}},
+		"cluster": {args: []string{"name"}, resolve: func(args gqlArgs) (interface{}, error) {
+			cluster, err := args.str("name")
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := app.Config.Kafka[cluster]; !ok {
+				return (*gqlObject)(nil), nil
+			}
+			return graphQLCluster(app, cluster), nil
+		}},
+	}}
+}
+
+func graphQLCluster(app *ApplicationContext, cluster string) *gqlObject {
+	topicList := func(group string) (*storage.ResponseTopicList, error) {
+		request := &storage.RequestTopicList{Result: make(chan *storage.ResponseTopicList), Cluster: cluster, Group: group}
+		if err := graphQLStorageRequest(app, request); err != nil {
+			return nil, err
+		}
+		return <-request.Result, nil
+	}
+
+	return &gqlObject{typename: "Cluster", fields: map[string]*gqlField{
+		"name": gqlValue(cluster),
+		"pausedAt": {resolve: func(gqlArgs) (interface{}, error) {
+			if pausedAt := app.Storage.ClusterPausedAt(cluster); pausedAt > 0 {
+				return pausedAt, nil
+			}
+			return nil, nil
+		}},
+		"topics": {resolve: func(gqlArgs) (interface{}, error) {
+			result, err := topicList("")
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(result.TopicList)
+			topics := make([]*gqlObject, len(result.TopicList))
+			for i, topic := range result.TopicList {
+				topics[i] = graphQLTopic(app, cluster, topic, nil)
+			}
+			return topics, nil
+		}},
+		"topic": {args: []string{"name"}, resolve: func(args gqlArgs) (interface{}, error) {
+			topic, err := args.str("name")
+			if err != nil {
+				return nil, err
+			}
+			request := &storage.RequestOffsets{Result: make(chan *storage.ResponseOffsets), Cluster: cluster, Topic: topic}
+			if err := graphQLStorageRequest(app, request); err != nil {
+				return nil, err
+			}
+			result := <-request.Result
+			if result.ErrorTopic {
+				return (*gqlObject)(nil), nil
+			}
+			return graphQLTopic(app, cluster, topic, result), nil
+		}},
+		"groups": {resolve: func(gqlArgs) (interface{}, error) {
+			request := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+			if err := graphQLStorageRequest(app, request); err != nil {
+				return nil, err
+			}
+			names := <-request.Result
+			sort.Strings(names)
+			groups := make([]*gqlObject, len(names))
+			for i, group := range names {
+				groups[i] = graphQLGroup(app, cluster, group)
+			}
+			return groups, nil
+		}},
+		"group": {args: []string{"name"}, resolve: func(args gqlArgs) (interface{}, error) {
+			group, err := args.str("name")
+			if err != nil {
+				return nil, err
+			}
+			result, err := topicList(group)
+			if err != nil {
+				return nil, err
+			}
+			if result.Error {
+				return (*gqlObject)(nil), nil
+			}
+			return graphQLGroup(app, cluster, group), nil
+		}},
+	}}
+}
+
+// A broker topic. If the offsets have already been fetched they are passed in, otherwise they are fetched only if the
+// query asks for the partitions
+func graphQLTopic(app *ApplicationContext, cluster string, topic string, offsets *storage.ResponseOffsets) *gqlObject {
+	return &gqlObject{typename: "Topic", fields: map[string]*gqlField{
+		"name": gqlValue(topic),
+		"partitions": {resolve: func(gqlArgs) (interface{}, error) {
+			if offsets == nil {
+				request := &storage.RequestOffsets{Result: make(chan *storage.ResponseOffsets), Cluster: cluster, Topic: topic}
+				if err := graphQLStorageRequest(app, request); err != nil {
+					return nil, err
+				}
+				offsets = <-request.Result
+			}
+
+			partitions := make([]*gqlObject, len(offsets.OffsetList))
+			for i, offset := range offsets.OffsetList {
+				var stableOffset interface{}
+				if (i < len(offsets.StableOffsetList)) && (offsets.StableOffsetList[i] >= 0) {
+					stableOffset = offsets.StableOffsetList[i]
+				}
+				partitions[i] = &gqlObject{typename: "TopicPartition", fields: map[string]*gqlField{
+					"partition":    gqlValue(i),
+					"offset":       gqlValue(offset),
+					"stableOffset": gqlValue(stableOffset),
+				}}
+			}
+			return partitions, nil
+		}},
+		"rate": {resolve: func(gqlArgs) (interface{}, error) {
+			request := &storage.RequestTopicRate{Result: make(chan *storage.ResponseTopicRate), Cluster: cluster, Topic: topic}
+			if err := graphQLStorageRequest(app, request); err != nil {
+				return nil, err
+			}
+			result := <-request.Result
+			if result.ErrorTopic {
+				return nil, nil
+			}
+			return result.TotalRate, nil
+		}},
+	}}
+}
+
+func graphQLGroup(app *ApplicationContext, cluster string, group string) *gqlObject {
+	// The group's offsets are fetched once, the first time a topic field needs them
+	var offsets map[string][]*storage.ConsumerOffset
+	fetchOffsets := func() (map[string][]*storage.ConsumerOffset, error) {
+		if offsets == nil {
+			request := &storage.RequestConsumerOffsets{Result: make(chan map[string][]*storage.ConsumerOffset), Cluster: cluster, Group: group}
+			if err := graphQLStorageRequest(app, request); err != nil {
+				return nil, err
+			}
+			offsets = <-request.Result
+		}
+		return offsets, nil
+	}
+
+	return &gqlObject{typename: "Group", fields: map[string]*gqlField{
+		"name":    gqlValue(group),
+		"cluster": gqlValue(cluster),
+		"tags": {resolve: func(gqlArgs) (interface{}, error) {
+			return graphQLTags(app.Storage.GroupTags(group)), nil
+		}},
+		"status": {args: []string{"showall"}, resolve: func(args gqlArgs) (interface{}, error) {
+			showall, err := args.boolean("showall", false)
+			if err != nil {
+				return nil, err
+			}
+			status := fetchConsumerStatus(app, cluster, group, showall, false)
+			if status == nil {
+				return nil, errors.New(storageBusyReason)
+			}
+			if status.Status == storage.StatusNotFound {
+				return (*gqlObject)(nil), nil
+			}
+			return graphQLStatus(status), nil
+		}},
+		"topics": {resolve: func(gqlArgs) (interface{}, error) {
+			groupOffsets, err := fetchOffsets()
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(groupOffsets))
+			for topic := range groupOffsets {
+				names = append(names, topic)
+			}
+			sort.Strings(names)
+
+			topics := make([]*gqlObject, len(names))
+			for i, topic := range names {
+				topics[i] = graphQLGroupTopic(app, cluster, group, topic, groupOffsets[topic])
+			}
+			return topics, nil
+		}},
+		"topic": {args: []string{"name"}, resolve: func(args gqlArgs) (interface{}, error) {
+			topic, err := args.str("name")
+			if err != nil {
+				return nil, err
+			}
+			groupOffsets, err := fetchOffsets()
+			if err != nil {
+				return nil, err
+			}
+			partitions, ok := groupOffsets[topic]
+			if !ok {
+				return (*gqlObject)(nil), nil
+			}
+			return graphQLGroupTopic(app, cluster, group, topic, partitions), nil
+		}},
+	}}
+}
+
+func graphQLGroupTopic(app *ApplicationContext, cluster string, group string, topic string, offsets []*storage.ConsumerOffset) *gqlObject {
+	return &gqlObject{typename: "GroupTopic", fields: map[string]*gqlField{
+		"name": gqlValue(topic),
+		"partitions": {resolve: func(gqlArgs) (interface{}, error) {
+			partitions := make([]*gqlObject, len(offsets))
+			for i, offset := range offsets {
+				if offset == nil {
+					partitions[i] = graphQLGroupPartition(i, nil, nil, nil)
+				} else {
+					partitions[i] = graphQLGroupPartition(i, offset.Offset, offset.Timestamp, offset.Lag)
+				}
+			}
+			return partitions, nil
+		}},
+		"history": {args: []string{"timestamp"}, resolve: func(args gqlArgs) (interface{}, error) {
+			var param string
+			switch value := args["timestamp"].(type) {
+			case string:
+				param = value
+			case int64:
+				param = strconv.FormatInt(value, 10)
+			case float64:
+				param = strconv.FormatInt(int64(value), 10)
+			default:
+				return nil, errors.New("argument timestamp is required")
+			}
+			timestamp, err := parseTimestampParam(param)
+			if err != nil {
+				return nil, fmt.Errorf("bad timestamp %s", param)
+			}
+
+			request := &storage.RequestOffsetHistory{
+				Result:    make(chan *storage.ResponseOffsetHistory),
+				Cluster:   cluster,
+				Group:     group,
+				Topic:     topic,
+				Timestamp: timestamp,
+			}
+			if err := graphQLStorageRequest(app, request); err != nil {
+				return nil, err
+			}
+			result := <-request.Result
+			if result.ErrorGroup || result.ErrorTopic {
+				return []*gqlObject{}, nil
+			}
+
+			partitions := make([]*gqlObject, len(result.Offsets))
+			for i, offset := range result.Offsets {
+				if offset == nil {
+					partitions[i] = graphQLGroupPartition(i, nil, nil, nil)
+				} else {
+					partitions[i] = graphQLGroupPartition(i, offset.Offset, offset.Timestamp, offset.Lag)
+				}
+			}
+			return partitions, nil
+		}},
+	}}
+}
+
+func graphQLGroupPartition(partition int, offset interface{}, timestamp interface{}, lag interface{}) *gqlObject {
+	return &gqlObject{typename: "GroupPartition", fields: map[string]*gqlField{
+		"partition": gqlValue(partition),
+		"offset":    gqlValue(offset),
+		"timestamp": gqlValue(timestamp),
+		"lag":       gqlValue(lag),
+	}}
+}
+
+func graphQLStatus(status *storage.ConsumerGroupStatus) *gqlObject {
+	var maxLag interface{} = (*gqlObject)(nil)
+	if status.Maxlag != nil {
+		maxLag = graphQLPartitionStatus(status.Maxlag)
+	}
+	partitions := make([]*gqlObject, len(status.Partitions))
+	for i, partition := range status.Partitions {
+		partitions[i] = graphQLPartitionStatus(partition)
+	}
+	missingTopics := status.MissingTopics
+	if missingTopics == nil {
+		missingTopics = []string{}
+	}
+	var pausedAt, pausedAtTime interface{}
+	if status.PausedAt > 0 {
+		pausedAt = status.PausedAt
+		pausedAtTime = status.PausedAtTime
+	}
+
+	return &gqlObject{typename: "Status", fields: map[string]*gqlField{
+		"status":        gqlValue(status.Status.String()),
+		"complete":      gqlValue(status.Complete),
+		"totalLag":      gqlValue(status.TotalLag),
+		"maxLag":        gqlValue(maxLag),
+		"partitions":    gqlValue(partitions),
+		"missing":       gqlValue(status.Missing),
+		"missingTopics": gqlValue(missingTopics),
+		"pausedAt":      gqlValue(pausedAt),
+		"pausedAtTime":  gqlValue(pausedAtTime),
+		"metadata":      gqlValue(graphQLTags(status.Metadata)),
+	}}
+}
+
+// Return the names and values of a map as Tags, sorted by name
+func graphQLTags(tags map[string]string) []*gqlObject {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*gqlObject, len(names))
+	for i, name := range names {
+		list[i] = &gqlObject{typename: "Tag", fields: map[string]*gqlField{
+			"name":  gqlValue(name),
+			"value": gqlValue(tags[name]),
+		}}
+	}
+	return list
+}
+
+func graphQLPartitionStatus(partition *storage.PartitionStatus) *gqlObject {
+	var owner interface{} = (*gqlObject)(nil)
+	if partition.Owner != nil {
+		owner = &gqlObject{typename: "PartitionOwner", fields: map[string]*gqlField{
+			"memberId":   gqlValue(partition.Owner.MemberId),
+			"clientId":   gqlValue(partition.Owner.ClientId),
+			"clientHost": gqlValue(partition.Owner.ClientHost),
+		}}
+	}
+	return &gqlObject{typename: "PartitionStatus", fields: map[string]*gqlField{
+		"topic":              gqlValue(partition.Topic),
+		"partition":          gqlValue(partition.Partition),
+		"status":             gqlValue(partition.Status.String()),
+		"start":              gqlValue(graphQLOffset(partition.Start)),
+		"end":                gqlValue(graphQLOffset(partition.End)),
+		"timeToRetention":    gqlValue(partition.TimeToRetention),
+		"compacted":          gqlValue(partition.Compacted),
+		"priority":           gqlValue(partition.Priority),
+		"recentLeaderChange": gqlValue(partition.RecentLeaderChange),
+		"owner":              gqlValue(owner),
+	}}
+}
+
+func graphQLOffset(offset storage.ConsumerOffset) *gqlObject {
+	return &gqlObject{typename: "Offset", fields: map[string]*gqlField{
+		"offset":    gqlValue(offset.Offset),
+		"timestamp": gqlValue(offset.Timestamp),
+		"time":      gqlValue(offset.Time),
+		"lag":       gqlValue(offset.Lag),
+		"source":    gqlValue(offset.Source),
+	}}
+}
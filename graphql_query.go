@@ -0,0 +1,872 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This is a small GraphQL query executor, with only what the /graphql endpoint needs. It supports queries (not
+// mutations or subscriptions) with aliases, arguments, variables, fragments, and the @include and @skip directives.
+// There is no introspection, and the query is checked against the schema as it is run rather than up front
+
+// How deeply selections can be nested, which stops a client from sending a query that takes forever to run
+const graphQLMaxDepth = 12
+
+// A query that has been parsed
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	name       string
+	variables  []*gqlVariableDefinition
+	selections []*gqlSelection
+}
+
+type gqlVariableDefinition struct {
+	name         string
+	required     bool
+	defaultValue interface{}
+}
+
+type gqlFragment struct {
+	name       string
+	selections []*gqlSelection
+}
+
+// A field, fragment spread (spread is set), or inline fragment (name and spread are both empty)
+type gqlSelection struct {
+	alias      string
+	name       string
+	spread     string
+	args       map[string]interface{}
+	directives []*gqlDirective
+	selections []*gqlSelection
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+// Values in the query that are filled in from the variables when the query is run
+type gqlVariable string
+
+// An object in the schema. Each field is resolved only if the query asks for it
+type gqlObject struct {
+	typename string
+	fields   map[string]*gqlField
+}
+
+type gqlField struct {
+	args    []string
+	resolve func(args gqlArgs) (interface{}, error)
+}
+
+// A field with a value that is already known
+func gqlValue(value interface{}) *gqlField {
+	return &gqlField{resolve: func(gqlArgs) (interface{}, error) { return value, nil }}
+}
+
+type gqlArgs map[string]interface{}
+
+func (args gqlArgs) str(name string) (string, error) {
+	switch value := args[name].(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", fmt.Errorf("argument %s is required", name)
+	default:
+		return "", fmt.Errorf("argument %s must be a string", name)
+	}
+}
+
+func (args gqlArgs) boolean(name string, defaultValue bool) (bool, error) {
+	switch value := args[name].(type) {
+	case bool:
+		return value, nil
+	case nil:
+		return defaultValue, nil
+	default:
+		return false, fmt.Errorf("argument %s must be a boolean", name)
+	}
+}
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   *gqlResult  `json:"data"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+// The result for an object. GraphQL clients expect the fields in the order they were asked for, which a map would
+// lose when it is encoded
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (result *gqlResult) set(key string, value interface{}) {
+	if _, ok := result.values[key]; !ok {
+		result.keys = append(result.keys, key)
+	}
+	result.values[key] = value
+}
+
+func (result *gqlResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range result.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyJSON, _ := json.Marshal(key)
+		valueJSON, err := json.Marshal(result.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		buf.Write(valueJSON)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Run a query against the root object. Errors in the query itself (it can't be parsed, or the variables are wrong) are
+// returned as an error, and errors from fields are returned in the response alongside the fields that worked
+func executeGraphQL(root *gqlObject, query string, operationName string, variables map[string]interface{}) (*gqlResponse, error) {
+	document, err := parseGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var operation *gqlOperation
+	for _, op := range document.operations {
+		if (operationName == "") || (op.name == operationName) {
+			if operation != nil {
+				return nil, errors.New("operationName is required when the query has more than one operation")
+			}
+			operation = op
+		}
+	}
+	if operation == nil {
+		return nil, fmt.Errorf("operation %s not found", operationName)
+	}
+
+	// The root's selection set is the first level, as it is for the parser
+	exec := &gqlExecutor{fragments: document.fragments, variables: make(map[string]interface{}), depth: 1}
+	for _, definition := range operation.variables {
+		value, ok := variables[definition.name]
+		if !ok {
+			value = definition.defaultValue
+		}
+		if (value == nil) && definition.required {
+			return nil, fmt.Errorf("variable $%s is required", definition.name)
+		}
+		exec.variables[definition.name] = value
+	}
+
+	data := exec.executeObject(root, operation.selections, nil)
+	return &gqlResponse{Data: data, Errors: exec.errors}, nil
+}
+
+type gqlExecutor struct {
+	fragments map[string]*gqlFragment
+	variables map[string]interface{}
+	errors    []*gqlError
+
+	// How many objects deep the field being resolved is. The parser limits how deeply selection sets are nested, but a
+	// fragment that spreads itself inside a field can still recurse, so the depth is checked again here
+	depth int
+}
+
+func (exec *gqlExecutor) fail(path []interface{}, err error) {
+	exec.errors = append(exec.errors, &gqlError{Message: err.Error(), Path: append([]interface{}{}, path...)})
+}
+
+func (exec *gqlExecutor) executeObject(object *gqlObject, selections []*gqlSelection, path []interface{}) *gqlResult {
+	result := &gqlResult{values: make(map[string]interface{})}
+
+	keys := make([]string, 0)
+	fields := make(map[string][]*gqlSelection)
+	if err := exec.collectFields(selections, fields, &keys, make(map[string]bool)); err != nil {
+		exec.fail(path, err)
+		return result
+	}
+
+	for _, key := range keys {
+		field := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if field.name == "__typename" {
+			result.set(key, object.typename)
+			continue
+		}
+
+		definition, ok := object.fields[field.name]
+		if !ok {
+			exec.fail(fieldPath, fmt.Errorf("type %s has no field %s", object.typename, field.name))
+			result.set(key, nil)
+			continue
+		}
+		args, err := exec.arguments(field.args, definition.args)
+		if err != nil {
+			exec.fail(fieldPath, err)
+			result.set(key, nil)
+			continue
+		}
+		value, err := definition.resolve(args)
+		if err != nil {
+			exec.fail(fieldPath, err)
+			result.set(key, nil)
+			continue
+		}
+
+		// Fields with the same name and alias have their selections merged
+		subselections := make([]*gqlSelection, 0)
+		for _, same := range fields[key] {
+			subselections = append(subselections, same.selections...)
+		}
+		result.set(key, exec.complete(value, subselections, fieldPath))
+	}
+	return result
+}
+
+func (exec *gqlExecutor) complete(value interface{}, selections []*gqlSelection, path []interface{}) interface{} {
+	switch v := value.(type) {
+	case *gqlObject:
+		if v == nil {
+			return nil
+		}
+		if len(selections) == 0 {
+			exec.fail(path, fmt.Errorf("a selection of fields is required for type %s", v.typename))
+			return nil
+		}
+		if exec.depth >= graphQLMaxDepth {
+			exec.fail(path, fmt.Errorf("query is nested more than %v levels deep", graphQLMaxDepth))
+			return nil
+		}
+		exec.depth++
+		defer func() { exec.depth-- }()
+		return exec.executeObject(v, selections, path)
+	case []*gqlObject:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = exec.complete(item, selections, append(append([]interface{}{}, path...), i))
+		}
+		return list
+	default:
+		if len(selections) > 0 {
+			exec.fail(path, errors.New("fields can't be selected from a scalar"))
+			return nil
+		}
+		return v
+	}
+}
+
+// Flatten fragments into the list of fields to resolve, grouped by the key they are returned as
+func (exec *gqlExecutor) collectFields(selections []*gqlSelection, fields map[string][]*gqlSelection, keys *[]string, visited map[string]bool) error {
+	for _, selection := range selections {
+		include, err := exec.included(selection.directives)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+
+		switch {
+		case selection.spread != "":
+			if visited[selection.spread] {
+				continue
+			}
+			fragment, ok := exec.fragments[selection.spread]
+			if !ok {
+				return fmt.Errorf("fragment %s is not defined", selection.spread)
+			}
+			visited[selection.spread] = true
+			if err := exec.collectFields(fragment.selections, fields, keys, visited); err != nil {
+				return err
+			}
+		case selection.name == "":
+			if err := exec.collectFields(selection.selections, fields, keys, visited); err != nil {
+				return err
+			}
+		default:
+			key := selection.name
+			if selection.alias != "" {
+				key = selection.alias
+			}
+			if same, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			} else if (same[0].name != selection.name) || !reflect.DeepEqual(same[0].args, selection.args) {
+				// The results can only be merged if they are the same field
+				return fmt.Errorf("%s is returned for fields with different names or arguments, so one needs an alias", key)
+			}
+			fields[key] = append(fields[key], selection)
+		}
+	}
+	return nil
+}
+
+func (exec *gqlExecutor) included(directives []*gqlDirective) (bool, error) {
+	for _, directive := range directives {
+		if (directive.name != "include") && (directive.name != "skip") {
+			return false, fmt.Errorf("directive @%s is not supported", directive.name)
+		}
+		args, err := exec.arguments(directive.args, []string{"if"})
+		if err != nil {
+			return false, err
+		}
+		condition, ok := args["if"].(bool)
+		if !ok {
+			return false, fmt.Errorf("directive @%s needs a boolean if argument", directive.name)
+		}
+		if condition == (directive.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Fill in the variables in the arguments, and check that every argument is one that the field takes
+func (exec *gqlExecutor) arguments(values map[string]interface{}, allowed []string) (gqlArgs, error) {
+	args := make(gqlArgs, len(values))
+	for name, value := range values {
+		known := false
+		for _, allowedName := range allowed {
+			known = known || (name == allowedName)
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown argument %s", name)
+		}
+		resolved, err := exec.resolveValue(value)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = resolved
+	}
+	return args, nil
+}
+
+func (exec *gqlExecutor) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case gqlVariable:
+		resolved, ok := exec.variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", string(v))
+		}
+		return resolved, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := exec.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = resolved
+		}
+		return list, nil
+	default:
+		return value, nil
+	}
+}
+
+// Tokens are punctuation (as the punctuation itself), names, numbers, and strings
+const (
+	gqlTokenEOF = iota
+	gqlTokenPunct
+	gqlTokenName
+	gqlTokenInt
+	gqlTokenFloat
+	gqlTokenString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	query string
+	pos   int
+	token gqlToken
+	depth int
+}
+
+func parseGraphQL(query string) (document *gqlDocument, err error) {
+	parser := &gqlParser{query: query}
+	if err := parser.next(); err != nil {
+		return nil, err
+	}
+
+	document = &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for parser.token.kind != gqlTokenEOF {
+		switch {
+		case parser.isPunct("{"):
+			selections, err := parser.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			document.operations = append(document.operations, &gqlOperation{selections: selections})
+		case parser.isName("query"):
+			operation, err := parser.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			document.operations = append(document.operations, operation)
+		case parser.isName("fragment"):
+			fragment, err := parser.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			document.fragments[fragment.name] = fragment
+		case parser.isName("mutation") || parser.isName("subscription"):
+			return nil, fmt.Errorf("only queries are supported, not %s", parser.token.value)
+		default:
+			return nil, parser.unexpected()
+		}
+	}
+	if len(document.operations) == 0 {
+		return nil, errors.New("query has no operations")
+	}
+	return document, nil
+}
+
+func (parser *gqlParser) isPunct(punct string) bool {
+	return (parser.token.kind == gqlTokenPunct) && (parser.token.value == punct)
+}
+
+func (parser *gqlParser) isName(name string) bool {
+	return (parser.token.kind == gqlTokenName) && (parser.token.value == name)
+}
+
+func (parser *gqlParser) unexpected() error {
+	if parser.token.kind == gqlTokenEOF {
+		return errors.New("syntax error: unexpected end of query")
+	}
+	return fmt.Errorf("syntax error: unexpected %q at position %v", parser.token.value, parser.token.pos)
+}
+
+func (parser *gqlParser) expectPunct(punct string) error {
+	if !parser.isPunct(punct) {
+		return parser.unexpected()
+	}
+	return parser.next()
+}
+
+func (parser *gqlParser) expectName() (string, error) {
+	if parser.token.kind != gqlTokenName {
+		return "", parser.unexpected()
+	}
+	name := parser.token.value
+	return name, parser.next()
+}
+
+func (parser *gqlParser) parseOperation() (*gqlOperation, error) {
+	operation := &gqlOperation{}
+	if err := parser.next(); err != nil {
+		return nil, err
+	}
+	if parser.token.kind == gqlTokenName {
+		operation.name = parser.token.value
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if parser.isPunct("(") {
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		for !parser.isPunct(")") {
+			definition, err := parser.parseVariableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			operation.variables = append(operation.variables, definition)
+		}
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	selections, err := parser.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	operation.selections = selections
+	return operation, nil
+}
+
+// Variable types are not checked, but a non-null type (ending in !) makes the variable required
+func (parser *gqlParser) parseVariableDefinition() (*gqlVariableDefinition, error) {
+	if err := parser.expectPunct("$"); err != nil {
+		return nil, err
+	}
+	name, err := parser.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.expectPunct(":"); err != nil {
+		return nil, err
+	}
+
+	definition := &gqlVariableDefinition{name: name}
+	nesting := 0
+	for {
+		switch {
+		case parser.isPunct("["):
+			nesting++
+		case parser.isPunct("]") && (nesting > 0):
+			nesting--
+		case parser.isPunct("!"):
+		case parser.token.kind == gqlTokenName:
+		default:
+			return nil, parser.unexpected()
+		}
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		if nesting == 0 {
+			if parser.isPunct("!") {
+				definition.required = true
+				if err := parser.next(); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+	}
+
+	if parser.isPunct("=") {
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		value, err := parser.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		definition.defaultValue = value
+	}
+	return definition, nil
+}
+
+func (parser *gqlParser) parseFragment() (*gqlFragment, error) {
+	if err := parser.next(); err != nil {
+		return nil, err
+	}
+	name, err := parser.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.isName("on") {
+		return nil, parser.unexpected()
+	}
+	if err := parser.next(); err != nil {
+		return nil, err
+	}
+	if _, err := parser.expectName(); err != nil {
+		return nil, err
+	}
+	selections, err := parser.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &gqlFragment{name: name, selections: selections}, nil
+}
+
+func (parser *gqlParser) parseSelectionSet() ([]*gqlSelection, error) {
+	parser.depth++
+	defer func() { parser.depth-- }()
+	if parser.depth > graphQLMaxDepth {
+		return nil, fmt.Errorf("query is nested more than %v levels deep", graphQLMaxDepth)
+	}
+
+	if err := parser.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	selections := make([]*gqlSelection, 0)
+	for !parser.isPunct("}") {
+		selection, err := parser.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, parser.unexpected()
+	}
+	return selections, parser.next()
+}
+
+func (parser *gqlParser) parseSelection() (*gqlSelection, error) {
+	selection := &gqlSelection{}
+	var err error
+
+	if parser.isPunct("...") {
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		if parser.isName("on") {
+			// Inline fragment. There are no interfaces or unions, so the type condition doesn't change anything
+			if err := parser.next(); err != nil {
+				return nil, err
+			}
+			if _, err := parser.expectName(); err != nil {
+				return nil, err
+			}
+		} else if parser.token.kind == gqlTokenName {
+			selection.spread = parser.token.value
+			if err := parser.next(); err != nil {
+				return nil, err
+			}
+		}
+		if selection.directives, err = parser.parseDirectives(); err != nil {
+			return nil, err
+		}
+		if selection.spread == "" {
+			if selection.selections, err = parser.parseSelectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		return selection, nil
+	}
+
+	if selection.name, err = parser.expectName(); err != nil {
+		return nil, err
+	}
+	if parser.isPunct(":") {
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		selection.alias = selection.name
+		if selection.name, err = parser.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if selection.args, err = parser.parseArguments(); err != nil {
+		return nil, err
+	}
+	if selection.directives, err = parser.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if parser.isPunct("{") {
+		if selection.selections, err = parser.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return selection, nil
+}
+
+func (parser *gqlParser) parseArguments() (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	if !parser.isPunct("(") {
+		return args, nil
+	}
+	if err := parser.next(); err != nil {
+		return nil, err
+	}
+	for !parser.isPunct(")") {
+		name, err := parser.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := parser.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = parser.parseValue(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, parser.next()
+}
+
+func (parser *gqlParser) parseDirectives() ([]*gqlDirective, error) {
+	var directives []*gqlDirective
+	for parser.isPunct("@") {
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		name, err := parser.expectName()
+		if err != nil {
+			return nil, err
+		}
+		args, err := parser.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &gqlDirective{name: name, args: args})
+	}
+	return directives, nil
+}
+
+// Parse a value. Enum values are returned as strings. Variables are not allowed in constant values (defaults)
+func (parser *gqlParser) parseValue(constant bool) (interface{}, error) {
+	token := parser.token
+	switch {
+	case (token.kind == gqlTokenPunct) && (token.value == "$") && !constant:
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		name, err := parser.expectName()
+		return gqlVariable(name), err
+	case (token.kind == gqlTokenPunct) && (token.value == "["):
+		if err := parser.next(); err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, 0)
+		for !parser.isPunct("]") {
+			value, err := parser.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, parser.next()
+	case token.kind == gqlTokenInt:
+		value, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad integer %s", token.value)
+		}
+		return value, parser.next()
+	case token.kind == gqlTokenFloat:
+		value, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s", token.value)
+		}
+		return value, parser.next()
+	case token.kind == gqlTokenString:
+		return token.value, parser.next()
+	case token.kind == gqlTokenName:
+		var value interface{}
+		switch token.value {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = token.value
+		}
+		return value, parser.next()
+	default:
+		// Input objects are not used by the schema
+		return nil, parser.unexpected()
+	}
+}
+
+// Read the next token. Whitespace, commas, and comments are skipped
+func (parser *gqlParser) next() error {
+	query := parser.query
+	for parser.pos < len(query) {
+		c := query[parser.pos]
+		if (c == ' ') || (c == '\t') || (c == '\n') || (c == '\r') || (c == ',') {
+			parser.pos++
+		} else if c == '#' {
+			for (parser.pos < len(query)) && (query[parser.pos] != '\n') {
+				parser.pos++
+			}
+		} else {
+			break
+		}
+	}
+
+	start := parser.pos
+	if start >= len(query) {
+		parser.token = gqlToken{kind: gqlTokenEOF, pos: start}
+		return nil
+	}
+
+	c := query[start]
+	switch {
+	case strings.HasPrefix(query[start:], "..."):
+		parser.pos += 3
+		parser.token = gqlToken{kind: gqlTokenPunct, value: "...", pos: start}
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		parser.pos++
+		parser.token = gqlToken{kind: gqlTokenPunct, value: string(c), pos: start}
+	case (c == '_') || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')):
+		for (parser.pos < len(query)) && isGraphQLNameChar(query[parser.pos]) {
+			parser.pos++
+		}
+		parser.token = gqlToken{kind: gqlTokenName, value: query[start:parser.pos], pos: start}
+	case (c == '-') || ((c >= '0') && (c <= '9')):
+		kind := gqlTokenInt
+		parser.pos++
+		for parser.pos < len(query) {
+			c := query[parser.pos]
+			if (c == '.') || (c == 'e') || (c == 'E') || (c == '+') || ((c == '-') && (kind == gqlTokenFloat)) {
+				kind = gqlTokenFloat
+			} else if (c < '0') || (c > '9') {
+				break
+			}
+			parser.pos++
+		}
+		parser.token = gqlToken{kind: kind, value: query[start:parser.pos], pos: start}
+	case c == '"':
+		value, err := parser.readString()
+		if err != nil {
+			return err
+		}
+		parser.token = gqlToken{kind: gqlTokenString, value: value, pos: start}
+	default:
+		r, _ := utf8.DecodeRuneInString(query[start:])
+		return fmt.Errorf("syntax error: unexpected character %q at position %v", r, start)
+	}
+	return nil
+}
+
+func isGraphQLNameChar(c byte) bool {
+	return (c == '_') || ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9'))
+}
+
+// Read a quoted string. The escapes are the same as JSON's, so the JSON decoder does the work. Block strings are not
+// supported
+func (parser *gqlParser) readString() (string, error) {
+	start := parser.pos
+	if strings.HasPrefix(parser.query[start:], "\"\"\"") {
+		return "", fmt.Errorf("block strings are not supported (at position %v)", start)
+	}
+	parser.pos++
+	for parser.pos < len(parser.query) {
+		switch parser.query[parser.pos] {
+		case '\\':
+			parser.pos += 2
+			continue
+		case '\n':
+			return "", fmt.Errorf("syntax error: unterminated string at position %v", start)
+		case '"':
+			parser.pos++
+			var value string
+			if err := json.Unmarshal([]byte(parser.query[start:parser.pos]), &value); err != nil {
+				return "", fmt.Errorf("syntax error: bad string at position %v", start)
+			}
+			return value, nil
+		}
+		parser.pos++
+	}
+	return "", fmt.Errorf("syntax error: unterminated string at position %v", start)
+}
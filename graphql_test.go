@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Items have a child item, so queries can be nested as deeply as a test needs
+func testGraphQLRoot() *gqlObject {
+	var item func(name string) *gqlObject
+	item = func(name string) *gqlObject {
+		return &gqlObject{typename: "Item", fields: map[string]*gqlField{
+			"name":  gqlValue(name),
+			"fail":  {resolve: func(gqlArgs) (interface{}, error) { return nil, errors.New("broken") }},
+			"child": {resolve: func(gqlArgs) (interface{}, error) { return item(name + "/child"), nil }},
+		}}
+	}
+	return &gqlObject{typename: "Query", fields: map[string]*gqlField{
+		"items": gqlValue([]*gqlObject{item("a"), item("b")}),
+		"item": {args: []string{"name"}, resolve: func(args gqlArgs) (interface{}, error) {
+			name, err := args.str("name")
+			if err != nil {
+				return nil, err
+			}
+			if name == "missing" {
+				return (*gqlObject)(nil), nil
+			}
+			return item(name), nil
+		}},
+	}}
+}
+
+func runGraphQL(t *testing.T, query string, variables map[string]interface{}) (string, []*gqlError) {
+	response, err := executeGraphQL(testGraphQLRoot(), query, "", variables)
+	if err != nil {
+		t.Fatalf("Query %q failed: %v", query, err)
+	}
+	data, err := json.Marshal(response.Data)
+	if err != nil {
+		t.Fatalf("Cannot encode result: %v", err)
+	}
+	return string(data), response.Errors
+}
+
+func Test_graphQLQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`{ items { name } }`, `{"items":[{"name":"a"},{"name":"b"}]}`},
+		{`query Named { second: item(name: "b") { name __typename } first: item(name: "a") { name } }`,
+			`{"second":{"name":"b","__typename":"Item"},"first":{"name":"a"}}`},
+		{`{ item(name: "missing") { name } }`, `{"item":null}`},
+		{`query ($n: String = "c") { item(name: $n) { ...Fields } } fragment Fields on Item { name }`, `{"item":{"name":"c"}}`},
+		{`{ item(name: "a") { ... on Item { name } name } }`, `{"item":{"name":"a"}}`},
+		{`{ item(name: "a") { name @skip(if: true) __typename @include(if: true) } }`, `{"item":{"__typename":"Item"}}`},
+		{"{ item(name: \"\\u0041\") { name } # comment\n}", `{"item":{"name":"A"}}`},
+	}
+	for _, test := range tests {
+		data, errs := runGraphQL(t, test.query, nil)
+		if len(errs) > 0 {
+			t.Errorf("Query %q returned errors: %v", test.query, errs[0].Message)
+		}
+		if data != test.expected {
+			t.Errorf("Query %q returned %s, expected %s", test.query, data, test.expected)
+		}
+	}
+}
+
+// A field that fails is null, and the rest of the query is still returned
+func Test_graphQLFieldErrors(t *testing.T) {
+	data, errs := runGraphQL(t, `query ($n: String!) { item(name: $n) { name fail } nope }`, map[string]interface{}{"n": "a"})
+	if data != `{"item":{"name":"a","fail":null},"nope":null}` {
+		t.Errorf("Unexpected result %s", data)
+	}
+	if (len(errs) != 2) || (errs[0].Path[0] != "item") || (errs[0].Path[1] != "fail") {
+		t.Errorf("Expected errors for item.fail and nope, got %v", errs)
+	}
+}
+
+func Test_graphQLBadQueries(t *testing.T) {
+	queries := []string{
+		`{ items { name }`,
+		`mutation { items { name } }`,
+		`query ($n: String!) { item(name: $n) { name } }`,
+		`{ a: items { name } } { b: items { name } }`,
+		`{ item(name: "unterminated) { name } }`,
+		`{ a { a { a { a { a { a { a { a { a { a { a { a { a } } } } } } } } } } } } }`,
+	}
+	for _, query := range queries {
+		if _, err := executeGraphQL(testGraphQLRoot(), query, "", nil); err == nil {
+			t.Errorf("Query %q was accepted", query)
+		}
+	}
+}
+
+// Malformed queries are rejected with an error that says what is wrong, rather than running part of the query
+func Test_graphQLMalformedQueries(t *testing.T) {
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{``, "query has no operations"},
+		{`# only a comment`, "query has no operations"},
+		{`fragment F on Item { name }`, "query has no operations"},
+		{`{ }`, `unexpected "}"`},
+		{`{ items { name } } }`, `unexpected "}" at position 19`},
+		{`{ items { name } ...`, "unexpected end of query"},
+		{`{ item(name "a") { name } }`, `unexpected "a"`},
+		{`{ item(name: ) { name } }`, `unexpected ")"`},
+		{`{ item(name: "a" { name } }`, `unexpected "{"`},
+		{`{ item(name: {a: 1}) { name } }`, `unexpected "{"`},
+		{`{ ité { name } }`, `unexpected character 'é' at position 4`},
+		{`{ item(name: """block""") { name } }`, "block strings are not supported"},
+		{`{ item(name: "bad \q escape") { name } }`, "bad string at position 13"},
+		{"{ item(name: \"two\nlines\") { name } }", "unterminated string"},
+		{`{ item(name: 99999999999999999999) { name } }`, "bad integer"},
+		{`{ item(name: 1.2.3e) { name } }`, "bad number"},
+		{`query ($n String) { items { name } }`, `unexpected "String"`},
+		{`query ($n: String = $m) { items { name } }`, `unexpected "$"`},
+		{`query ($n: [String) { items { name } }`, `unexpected ")"`},
+		{`fragment F { name } { items { name } }`, `unexpected "{"`},
+		{`subscription { items { name } }`, "only queries are supported, not subscription"},
+		{`query A { items { name } } query B { items { name } }`, "operationName is required"},
+		{`query ($n: String!) { item(name: $n) { name } }`, "variable $n is required"},
+	}
+	for _, test := range tests {
+		_, err := executeGraphQL(testGraphQLRoot(), test.query, "", nil)
+		if err == nil {
+			t.Errorf("Query %q was accepted", test.query)
+		} else if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Query %q failed with %q, expected %q", test.query, err.Error(), test.message)
+		}
+	}
+}
+
+// Build a query for an item with its child nested levels deep, counting the query's own selection set
+func nestedGraphQLQuery(levels int) string {
+	return "{ item(name: \"a\") " + strings.Repeat("{ child ", levels-2) + "{ name }" + strings.Repeat(" }", levels-2) + " }"
+}
+
+func Test_graphQLDepthLimit(t *testing.T) {
+	data, errs := runGraphQL(t, nestedGraphQLQuery(graphQLMaxDepth), nil)
+	if (len(errs) > 0) || !strings.Contains(data, `"name":"a`+strings.Repeat("/child", graphQLMaxDepth-2)+`"`) {
+		t.Errorf("Expected a query %v levels deep to run, got %s (%v)", graphQLMaxDepth, data, errs)
+	}
+	if _, err := executeGraphQL(testGraphQLRoot(), nestedGraphQLQuery(graphQLMaxDepth+1), "", nil); (err == nil) ||
+		!strings.Contains(err.Error(), "nested more than 12 levels deep") {
+		t.Errorf("Expected a query %v levels deep to be rejected, got %v", graphQLMaxDepth+1, err)
+	}
+
+	// A fragment that spreads itself inside a field passes the parser, but stops at the same depth when it is run
+	data, errs = runGraphQL(t, `{ item(name: "a") { ...Nested } } fragment Nested on Item { name child { ...Nested } }`, nil)
+	if (len(errs) != 1) || !strings.Contains(errs[0].Message, "nested more than 12 levels deep") ||
+		(len(errs[0].Path) != graphQLMaxDepth) {
+		t.Fatalf("Expected the recursion to stop with an error, got %v", errs)
+	}
+	if strings.Count(data, `"name"`) != graphQLMaxDepth-1 {
+		t.Errorf("Expected the names of %v items before the limit, got %s", graphQLMaxDepth-1, data)
+	}
+}
+
+func Test_graphQLAliases(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`{ a: item(name: "a") { n: name } b: item(name: "b") { name } }`, `{"a":{"n":"a"},"b":{"name":"b"}}`},
+		{`{ all: items { label: name kind: __typename } }`, `{"all":[{"label":"a","kind":"Item"},{"label":"b","kind":"Item"}]}`},
+		{`{ item(name: "a") { name } item(name: "a") { child { name } } }`, `{"item":{"name":"a","child":{"name":"a/child"}}}`},
+		{`{ name: items { name } }`, `{"name":[{"name":"a"},{"name":"b"}]}`},
+	}
+	for _, test := range tests {
+		data, errs := runGraphQL(t, test.query, nil)
+		if len(errs) > 0 {
+			t.Errorf("Query %q returned errors: %v", test.query, errs[0].Message)
+		}
+		if data != test.expected {
+			t.Errorf("Query %q returned %s, expected %s", test.query, data, test.expected)
+		}
+	}
+
+	// Fields can only share a key if they are the same field with the same arguments
+	for _, query := range []string{
+		`{ x: item(name: "a") { name } x: items { name } }`,
+		`{ x: item(name: "a") { name } x: item(name: "b") { name } }`,
+	} {
+		if _, errs := runGraphQL(t, query, nil); (len(errs) != 1) || !strings.Contains(errs[0].Message, "needs an alias") {
+			t.Errorf("Expected query %q to fail because of the conflicting fields, got %v", query, errs)
+		}
+	}
+}
+
+func Test_graphQLArguments(t *testing.T) {
+	tests := []struct {
+		query     string
+		variables map[string]interface{}
+		expected  string
+		message   string
+	}{
+		{`{ item(name: a) { name } }`, nil, `{"item":{"name":"a"}}`, ""},
+		{`query ($n: String) { item(name: $n) { name } }`, map[string]interface{}{"n": "v"}, `{"item":{"name":"v"}}`, ""},
+		{`query ($n: String = "d") { item(name: $n) { name } }`, map[string]interface{}{"n": "v"}, `{"item":{"name":"v"}}`, ""},
+		{`query ($s: Boolean!) { items @skip(if: $s) { name } item(name: "a") { name } }`, map[string]interface{}{"s": true}, `{"item":{"name":"a"}}`, ""},
+		{`query ($n: String) { item(name: $n) { name } }`, nil, `{"item":null}`, "argument name is required"},
+		{`{ item(name: 5) { name } }`, nil, `{"item":null}`, "argument name must be a string"},
+		{`{ item(name: ["a"]) { name } }`, nil, `{"item":null}`, "argument name must be a string"},
+		{`{ item(name: null) { name } }`, nil, `{"item":null}`, "argument name is required"},
+		{`{ item(nam: "a") { name } }`, nil, `{"item":null}`, "unknown argument nam"},
+		{`{ item(name: $x) { name } }`, nil, `{"item":null}`, "variable $x is not defined"},
+		{`{ items(name: "a") { name } }`, nil, `{"items":null}`, "unknown argument name"},
+		{`{ items @skip(if: "yes") { name } }`, nil, `{}`, "needs a boolean if argument"},
+		{`{ items @defer { name } }`, nil, `{}`, "directive @defer is not supported"},
+	}
+	for _, test := range tests {
+		data, errs := runGraphQL(t, test.query, test.variables)
+		if data != test.expected {
+			t.Errorf("Query %q returned %s, expected %s", test.query, data, test.expected)
+		}
+		if test.message == "" {
+			if len(errs) > 0 {
+				t.Errorf("Query %q returned errors: %v", test.query, errs[0].Message)
+			}
+		} else if (len(errs) != 1) || !strings.Contains(errs[0].Message, test.message) {
+			t.Errorf("Query %q should have failed with %q, got %v", test.query, test.message, errs)
+		}
+	}
+}
+
+// Errors from fields have the path to the field, including the index in a list
+func Test_graphQLErrorPaths(t *testing.T) {
+	data, errs := runGraphQL(t, `{ items { name fail } item(name: "a") { child { f: fail } } }`, nil)
+	if data != `{"items":[{"name":"a","fail":null},{"name":"b","fail":null}],"item":{"child":{"f":null}}}` {
+		t.Errorf("Unexpected result %s", data)
+	}
+	paths := make([]string, len(errs))
+	for i, err := range errs {
+		path, _ := json.Marshal(err.Path)
+		paths[i] = string(path)
+	}
+	if strings.Join(paths, " ") != `["items",0,"fail"] ["items",1,"fail"] ["item","child","f"]` {
+		t.Errorf("Unexpected error paths %v", paths)
+	}
+
+	for query, message := range map[string]string{
+		`{ items }`:                          "a selection of fields is required for type Item",
+		`{ item(name: "a") { name { x } } }`: "fields can't be selected from a scalar",
+		`{ items { ...Missing } }`:           "fragment Missing is not defined",
+	} {
+		if _, errs := runGraphQL(t, query, nil); (len(errs) == 0) || !strings.Contains(errs[0].Message, message) {
+			t.Errorf("Query %q should have failed with %q, got %v", query, message, errs)
+		}
+	}
+}
+
+// The operation to run is picked by name when the query has more than one
+func Test_graphQLOperationName(t *testing.T) {
+	query := `query A { item(name: "a") { name } } query B { item(name: "b") { name } }`
+	response, err := executeGraphQL(testGraphQLRoot(), query, "B", nil)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if data, _ := json.Marshal(response.Data); string(data) != `{"item":{"name":"b"}}` {
+		t.Errorf("Expected operation B to run, got %s", data)
+	}
+	if _, err := executeGraphQL(testGraphQLRoot(), query, "C", nil); (err == nil) || (err.Error() != "operation C not found") {
+		t.Errorf("Expected an error for an operation that isn't in the query, got %v", err)
+	}
+}
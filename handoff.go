@@ -0,0 +1,194 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// During a rolling upgrade, the instance being drained hands its state to its replacement so nothing is lost between
+// them: the offset rings (so the replacement's evaluations are complete right away), and the HTTP notifier's open
+// incidents (so the replacement sends the same Ids, and the DELETEs when groups recover). Once the replacement has
+// accepted the state, the draining instance stops its notifiers and releases the Zookeeper notifier lock, and the
+// replacement (which is waiting on the lock) takes over notifying straight away
+type HandoffState struct {
+	Storage   *storage.Checkpoint         `json:"storage"`
+	Incidents map[string]map[string]Event `json:"incidents"`
+	Leader    bool                        `json:"leader"`
+}
+
+type HTTPResponseHandoff struct {
+	Error     bool                    `json:"error"`
+	Message   string                  `json:"message"`
+	Peer      string                  `json:"peer,omitempty"`
+	Groups    int                     `json:"groups"`
+	Incidents int                     `json:"incidents"`
+	Leader    bool                    `json:"leader"`
+	Request   HTTPResponseRequestInfo `json:"request"`
+}
+
+// Return the number of groups and open incidents in the state
+func (state *HandoffState) counts() (int, int) {
+	groups, incidents := 0, 0
+	if state.Storage != nil {
+		for _, cluster := range state.Storage.Clusters {
+			groups += len(cluster.Consumer)
+		}
+	}
+	for _, clusterIncidents := range state.Incidents {
+		incidents += len(clusterIncidents)
+	}
+	return groups, incidents
+}
+
+// Handle POST /v2/admin/handoff?peer=(URL of the replacement), on the instance being drained. The state is sent to the
+// replacement with the same admin token, and if this instance holds the notifier lock, it is released once the
+// replacement accepts the state. If the replacement doesn't accept it, nothing is changed here
+func handleHandoff(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "POST" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	peer := strings.TrimSuffix(r.URL.Query().Get("peer"), "/")
+	if peerUrl, err := url.Parse(peer); (err != nil) || ((peerUrl.Scheme != "http") && (peerUrl.Scheme != "https")) || (peerUrl.Host == "") {
+		return makeErrorResponse(http.StatusBadRequest, "peer must be the http or https URL of the replacement instance", w, r)
+	}
+
+	state := &HandoffState{
+		Storage: app.Storage.TakeCheckpoint(),
+		Leader:  atomic.LoadInt32(&app.notifierLeader) == 1,
+	}
+	if app.HttpNotifier != nil {
+		state.Incidents = app.HttpNotifier.Incidents()
+	}
+	if err := sendHandoff(peer, r.Header.Get("Authorization"), state, storage.CodecByName(app.Config.Storage.Codec)); err != nil {
+		log.Errorf("Cannot hand off to %s: %v", peer, err)
+		return makeErrorResponse(http.StatusBadGateway, fmt.Sprintf("the peer did not accept the hand-off: %v", err), w, r)
+	}
+
+	groups, incidents := state.counts()
+	log.Infof("Handed off %v groups and %v open incidents to %s", groups, incidents, peer)
+	if state.Leader {
+		log.Info("Releasing the notifier lock to the hand-off peer")
+		stopNotifiers(app)
+	}
+
+	jsonStr, err := json.Marshal(HTTPResponseHandoff{
+		Error:     false,
+		Message:   "state handed off",
+		Peer:      peer,
+		Groups:    groups,
+		Incidents: incidents,
+		Leader:    state.Leader,
+		Request:   makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
+
+// Send the state encoded with the codec. The peer decodes it with the codec for the Content-Type, whatever its own
+// codec is
+func sendHandoff(peer string, authorization string, state *HandoffState, codec storage.Codec) error {
+	body, err := codec.Encode(state)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", peer+"/v2/admin/handoff/receive", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", codec.ContentType())
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := newPeerClient(2 * time.Minute).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Return an HTTP client for requests to another Burrow, such as a hand-off or bootstrap peer
+func newPeerClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &http.Transport{
+		DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: newTLSConfig(),
+	}}
+}
+
+// Handle POST /v2/admin/handoff/receive, on the replacement. The offset rings are merged with the ones already stored
+// (as offsets are already coming in), and the open incidents are added to the HTTP notifier
+func handleHandoffReceive(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "POST" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if (app.Config.Httpnotifier.Url != "") && (app.HttpNotifier == nil) {
+		// The HTTP server starts before the notifiers are loaded
+		return makeErrorResponse(http.StatusServiceUnavailable, "the notifiers are not loaded yet", w, r)
+	}
+	codec := storage.CodecByContentType(r.Header.Get("Content-Type"))
+	if codec == nil {
+		// Without a known Content-Type, the state is taken to be JSON
+		codec = storage.JSONCodec{}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "cannot read the hand-off state", w, r)
+	}
+	state := &HandoffState{}
+	if err := codec.Decode(body, state); err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "cannot decode the hand-off state", w, r)
+	}
+
+	if state.Storage != nil {
+		app.Storage.MergeCheckpoint(state.Storage)
+	}
+	if (app.HttpNotifier != nil) && (len(state.Incidents) > 0) {
+		app.HttpNotifier.ImportIncidents(state.Incidents)
+	}
+	groups, incidents := state.counts()
+	log.Infof("Accepted a hand-off of %v groups and %v open incidents from %s", groups, incidents, r.RemoteAddr)
+
+	jsonStr, err := json.Marshal(HTTPResponseHandoff{
+		Error:     false,
+		Message:   "hand-off accepted",
+		Groups:    groups,
+		Incidents: incidents,
+		Leader:    state.Leader,
+		Request:   makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
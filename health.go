@@ -0,0 +1,306 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The health reporter checks whether this instance is working every interval seconds, and publishes the result where
+// failover tooling can see it, so that clients move to the standby when this instance stops working:
+//   - file: the file exists (with the time it was checked) only while the instance is healthy
+//   - consul-check-id: a Consul TTL check on the local agent is marked passing or critical, with the reasons
+//   - ping-url: the URL is fetched while the instance is healthy, for heartbeat style checks
+//
+// GET /burrow/health returns GOOD, or a 503 with BAD, for load balancer and Route53 health checks
+type HealthReporter struct {
+	app     *ApplicationContext
+	client  *http.Client
+	lock    sync.RWMutex
+	healthy bool
+	reasons []string
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func NewHealthReporter(app *ApplicationContext) *HealthReporter {
+	reporter := &HealthReporter{
+		app: app,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: newTLSConfig(),
+		}},
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	// Until the first check, the instance is reported as healthy if it isn't waiting to warm up
+	reporter.healthy = !app.Config.Health.RequireWarmed
+	go reporter.run()
+	return reporter
+}
+
+func (reporter *HealthReporter) run() {
+	defer close(reporter.done)
+
+	ticker := time.NewTicker(time.Duration(reporter.app.Config.Health.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		reporter.check()
+		select {
+		case <-ticker.C:
+		case <-reporter.quit:
+			return
+		}
+	}
+}
+
+// Assess the health and publish it
+func (reporter *HealthReporter) check() {
+	reasons := assessHealth(reporter.app)
+	healthy := len(reasons) == 0
+
+	reporter.lock.Lock()
+	if healthy != reporter.healthy {
+		if healthy {
+			log.Info("Burrow is healthy again")
+		} else {
+			log.Warnf("Burrow is unhealthy: %s", strings.Join(reasons, "; "))
+		}
+	}
+	reporter.healthy = healthy
+	reporter.reasons = reasons
+	reporter.lock.Unlock()
+
+	cfg := reporter.app.Config.Health
+	if cfg.File != "" {
+		if err := writeHealthFile(cfg.File, healthy); err != nil {
+			log.Errorf("Cannot update health file %s: %v", cfg.File, err)
+		}
+	}
+	if cfg.ConsulCheckId != "" {
+		if err := reporter.updateConsul(healthy, reasons); err != nil {
+			log.Errorf("Cannot update Consul check %s: %v", cfg.ConsulCheckId, err)
+		}
+	}
+	if (cfg.PingUrl != "") && healthy {
+		if err := reporter.ping(); err != nil {
+			log.Errorf("Cannot ping health URL: %v", err)
+		}
+	}
+}
+
+// Return the reasons the instance isn't working, or none if it is. An instance is unhealthy if an offset source isn't
+// running, if the storage module is overloaded or doesn't take requests, or (with require-warmed) if a cluster is not
+// warmed up yet
+func assessHealth(app *ApplicationContext) []string {
+	reasons := make([]string, 0)
+	clusters := make([]string, 0, len(app.Config.Kafka))
+	for cluster := range app.Config.Kafka {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	for _, name := range offsetSourceNames {
+		sourceClusters := offsetSourceModules[name].Clusters(app.Config)
+		sort.Strings(sourceClusters)
+		for _, cluster := range sourceClusters {
+			if app.Storage.ClusterPausedAt(cluster) > 0 {
+				// The sources of a paused cluster are stopped on purpose
+				continue
+			}
+			if !app.Sources.IsRunning(name, cluster) {
+				reasons = append(reasons, fmt.Sprintf("%s for cluster %s is not running", name, cluster))
+			}
+		}
+	}
+
+	threshold := app.Config.Httpserver.OverloadThreshold
+	if threshold == 0 {
+		threshold = 90
+	}
+	for _, cluster := range clusters {
+		if reason := app.Storage.Overloaded(cluster, threshold); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("storage for cluster %s is overloaded: %s", cluster, reason))
+		}
+		if app.Config.Health.RequireWarmed {
+			if warmup := app.Storage.WarmupStatus(cluster); (warmup == nil) || !warmup.Warmed {
+				reasons = append(reasons, fmt.Sprintf("cluster %s is not warmed up", cluster))
+			}
+		}
+	}
+
+	request := &storage.RequestClusterList{Result: make(chan *storage.ResponseClusterList, 1)}
+	if !app.Storage.SendRequest(request, 5*time.Second) {
+		reasons = append(reasons, "the storage module is not taking requests")
+	}
+	return reasons
+}
+
+// Write the file if the instance is healthy, or remove it if it isn't
+func writeHealthFile(path string, healthy bool) error {
+	if !healthy {
+		if err := os.Remove(path); !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := ioutil.WriteFile(path+".tmp", []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Update a TTL check on the local Consul agent. The token is read from CONSUL_HTTP_TOKEN, as the Consul CLI does
+func (reporter *HealthReporter) updateConsul(healthy bool, reasons []string) error {
+	cfg := reporter.app.Config.Health
+	update := map[string]string{"Status": "passing", "Output": "Burrow is healthy"}
+	if !healthy {
+		update = map[string]string{"Status": "critical", "Output": strings.Join(reasons, "\n")}
+	}
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", strings.TrimSuffix(cfg.ConsulAddress, "/")+"/v1/agent/check/update/"+cfg.ConsulCheckId, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	return reporter.do(req)
+}
+
+func (reporter *HealthReporter) ping() error {
+	req, err := http.NewRequest("GET", reporter.app.Config.Health.PingUrl, nil)
+	if err != nil {
+		return err
+	}
+	return reporter.do(req)
+}
+
+func (reporter *HealthReporter) do(req *http.Request) error {
+	resp, err := reporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return nil
+}
+
+// Return the result of the last check
+func (reporter *HealthReporter) Healthy() (bool, []string) {
+	reporter.lock.RLock()
+	defer reporter.lock.RUnlock()
+	return reporter.healthy, reporter.reasons
+}
+
+// Stop checking. If there is a health file it is removed, and a Consul check is marked critical, so that clients
+// move to the standby straight away
+func (reporter *HealthReporter) Stop() {
+	close(reporter.quit)
+	<-reporter.done
+
+	cfg := reporter.app.Config.Health
+	if cfg.File != "" {
+		writeHealthFile(cfg.File, false)
+	}
+	if cfg.ConsulCheckId != "" {
+		if err := reporter.updateConsul(false, []string{"Burrow is stopping"}); err != nil {
+			log.Errorf("Cannot update Consul check %s: %v", cfg.ConsulCheckId, err)
+		}
+	}
+}
+
+// Handle GET /burrow/health. This is text rather than JSON (like /burrow/admin), and doesn't need a token, so that
+// health checkers can use it. Without a health reporter, it is always GOOD
+func handleHealth(app *ApplicationContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "{\"error\":true,\"message\":\"request method not supported\",\"result\":{}}", http.StatusMethodNotAllowed)
+			return
+		}
+		if app.Health != nil {
+			if healthy, _ := app.Health.Healthy(); !healthy {
+				http.Error(w, "BAD", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, "GOOD")
+	}
+}
+
+type HTTPResponseHealth struct {
+	Error   bool                              `json:"error"`
+	Message string                            `json:"message"`
+	Healthy bool                              `json:"healthy"`
+	Reasons []string                          `json:"reasons"`
+	Limits  map[string][]*storage.LimitBreach `json:"limits"`
+	Request HTTPResponseRequestInfo           `json:"request"`
+}
+
+// Handle GET /v2/burrow/health, with the result of the last health check and why it failed, and the limits that each
+// cluster has reached. A cluster that reaches its limits doesn't make the instance unhealthy, as the rest of the
+// clusters are still monitored, so the limits are listed for operators and alerting to pick up
+func handleHealthDetail(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	response := HTTPResponseHealth{
+		Error:   false,
+		Message: "health returned",
+		Healthy: true,
+		Reasons: make([]string, 0),
+		Limits:  make(map[string][]*storage.LimitBreach),
+		Request: makeRequestInfo(r),
+	}
+	if app.Health != nil {
+		response.Healthy, response.Reasons = app.Health.Healthy()
+		if response.Reasons == nil {
+			response.Reasons = make([]string, 0)
+		}
+	}
+	for cluster := range app.Config.Kafka {
+		if !app.AdminAudit.ClusterAllowed(r, cluster) {
+			continue
+		}
+		if breaches := app.Storage.LimitBreaches(cluster); len(breaches) > 0 {
+			response.Limits[cluster] = breaches
+		}
+	}
+
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
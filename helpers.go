@@ -12,6 +12,7 @@ package main
 
 import (
 	"encoding/json"
+	"github.com/linkedin/burrow/storage"
 )
 
 // Helper function for the templates to encode an object into a JSON string
@@ -22,7 +23,7 @@ func templateJsonEncoder(encodeMe interface{}) string {
 
 // Helper - recategorize partitions as a map of lists
 // map[string][]string => status short name -> list of topics
-func classifyTopicsByStatus(partitions []*PartitionStatus) map[string][]string {
+func classifyTopicsByStatus(partitions []*storage.PartitionStatus) map[string][]string {
 	tmp_map := make(map[string]map[string]bool)
 	for _, partition := range partitions {
 		if _, ok := tmp_map[partition.Status.String()]; !ok {
@@ -43,28 +44,34 @@ func classifyTopicsByStatus(partitions []*PartitionStatus) map[string][]string {
 }
 
 // Template Helper - Return a map of partition counts
-// keys are warn, stop, stall, rewind, unknown
-func templateCountPartitions(partitions []*PartitionStatus) map[string]int {
+// keys are warn, stop, stall, rewind, retention, offline, unknown
+func templateCountPartitions(partitions []*storage.PartitionStatus) map[string]int {
 	rv := map[string]int{
-		"warn":    0,
-		"stop":    0,
-		"stall":   0,
-		"rewind":  0,
-		"unknown": 0,
+		"warn":      0,
+		"stop":      0,
+		"stall":     0,
+		"rewind":    0,
+		"retention": 0,
+		"offline":   0,
+		"unknown":   0,
 	}
 
 	for _, partition := range partitions {
 		switch partition.Status {
-		case StatusOK:
+		case storage.StatusOK:
 			break
-		case StatusWarning:
+		case storage.StatusWarning:
 			rv["warn"]++
-		case StatusStop:
+		case storage.StatusStop:
 			rv["stop"]++
-		case StatusStall:
+		case storage.StatusStall:
 			rv["stall"]++
-		case StatusRewind:
+		case storage.StatusRewind:
 			rv["rewind"]++
+		case storage.StatusRetention:
+			rv["retention"]++
+		case storage.StatusOffline:
+			rv["offline"]++
 		default:
 			rv["unknown"]++
 		}
@@ -87,7 +94,7 @@ func templateDivide(a, b int) int {
 	return a / b
 }
 
-func maxLagHelper(a *PartitionStatus) int64 {
+func maxLagHelper(a *storage.PartitionStatus) int64 {
 	if a == nil {
 		return 0
 	} else {
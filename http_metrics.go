@@ -0,0 +1,109 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The buckets for response sizes, in bytes
+var responseSizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+// The path segments under /v2/kafka/(cluster) that are part of an endpoint, rather than the name of a group, topic, or
+// partition. Anything else is replaced with * in the endpoint label, so the labels can't grow without limit
+var kafkaEndpointKeywords = map[string]bool{
+	"consumer": true, "consumer-status": true, "topic": true, "expected": true, "ignored": true, "dropped": true,
+	"offsets": true, "maxlag": true, "restore": true, "status": true, "lag": true, "gate": true, "scale-hint": true,
+	"delta": true, "rollup": true, "history": true, "partition": true, "caught-up": true, "rate": true,
+}
+
+// Counts the requests to each HTTP endpoint, and how long they take and how large the responses are. A slow endpoint
+// with small responses is waiting on the storage module, while one with large responses is spending its time encoding
+// and writing them
+type instrumentedHandler struct {
+	metrics *Metrics
+	mux     *http.ServeMux
+}
+
+func newInstrumentedHandler(metrics *Metrics, mux *http.ServeMux) *instrumentedHandler {
+	metrics.Register("burrow_http_requests_total", MetricCounter, "HTTP requests, by endpoint")
+	metrics.Register("burrow_http_request_errors_total", MetricCounter, "HTTP requests that returned a 4xx or 5xx status, by endpoint")
+	metrics.RegisterHistogram("burrow_http_request_duration_seconds", "Time taken to handle HTTP requests, by endpoint", defaultHistogramBuckets)
+	metrics.RegisterHistogram("burrow_http_response_size_bytes", "Size of HTTP response bodies, by endpoint", responseSizeBuckets)
+	return &instrumentedHandler{metrics: metrics, mux: mux}
+}
+
+// Records the status and size of a response. Flushing is passed through, so streamed responses still stream
+type meteredResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (mw *meteredResponseWriter) WriteHeader(status int) {
+	if mw.status == 0 {
+		mw.status = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+func (mw *meteredResponseWriter) Write(data []byte) (int, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	n, err := mw.ResponseWriter.Write(data)
+	mw.size += n
+	return n, err
+}
+func (mw *meteredResponseWriter) Flush() {
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (ih *instrumentedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, pattern := ih.mux.Handler(r)
+	labels := map[string]string{"endpoint": endpointLabel(pattern, r.URL.Path)}
+
+	start := time.Now()
+	recorder := &meteredResponseWriter{ResponseWriter: w}
+	ih.mux.ServeHTTP(recorder, r)
+
+	ih.metrics.Add("burrow_http_requests_total", labels, 1)
+	if recorder.status >= 400 {
+		ih.metrics.Add("burrow_http_request_errors_total", labels, 1)
+	}
+	ih.metrics.Observe("burrow_http_request_duration_seconds", labels, time.Since(start).Seconds())
+	ih.metrics.Observe("burrow_http_response_size_bytes", labels, float64(recorder.size))
+}
+
+// Return the endpoint label for a request: the pattern it was routed with, and for the /kafka/ patterns (including
+// the compatibility versions), the rest of the path with the cluster and the names of groups, topics, and partitions
+// replaced with *
+func endpointLabel(pattern string, path string) string {
+	if !strings.HasSuffix(pattern, "/kafka/") {
+		return pattern
+	}
+	parts := strings.Split(strings.TrimPrefix(path, pattern), "/")
+	label := pattern + "*"
+	for _, part := range parts[1:] {
+		switch {
+		case part == "":
+			continue
+		case kafkaEndpointKeywords[part]:
+			label += "/" + part
+		default:
+			label += "/*"
+		}
+	}
+	return label
+}
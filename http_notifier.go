@@ -12,12 +12,13 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
 	"github.com/pborman/uuid"
 	"io"
 	"io/ioutil"
 	"math/rand"
-	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -30,33 +31,29 @@ type HttpNotifier struct {
 	app            *ApplicationContext
 	templatePost   *template.Template
 	templateDelete *template.Template
+	variants       []*NotifierVariant
 	extras         map[string]string
 	refreshTicker  *time.Ticker
 	quitChan       chan struct{}
+	sending        sync.WaitGroup
 	groupIds       map[string]map[string]Event
+	eventLock      sync.Mutex
 	groupList      map[string]map[string]bool
 	groupLock      sync.RWMutex
-	resultsChannel chan *ConsumerGroupStatus
+	resultsChannel chan *storage.ConsumerGroupStatus
 	httpClient     *http.Client
+	breakers       map[string]*CircuitBreaker
+	quiet          *QuietHours
 }
 
 type Event struct {
-	Id    string
-	Start time.Time
+	Id    string    `json:"id"`
+	Start time.Time `json:"start"`
 }
 
 func NewHttpNotifier(app *ApplicationContext) (*HttpNotifier, error) {
 	// Helper functions for templates
-	fmap := template.FuncMap{
-		"jsonencoder":     templateJsonEncoder,
-		"topicsbystatus":  classifyTopicsByStatus,
-		"partitioncounts": templateCountPartitions,
-		"add":             templateAdd,
-		"minus":           templateMinus,
-		"multiply":        templateMultiply,
-		"divide":          templateDivide,
-		"maxlag":          maxLagHelper,
-	}
+	fmap := notifierTemplateFuncs(displayLocation(app.Config))
 
 	// Compile the templates
 	templatePost, err := template.New("post").Funcs(fmap).ParseFiles(app.Config.Httpnotifier.TemplatePost)
@@ -73,6 +70,18 @@ func NewHttpNotifier(app *ApplicationContext) (*HttpNotifier, error) {
 	}
 	templateDelete = templateDelete.Templates()[0]
 
+	// Only the variants with webhook templates are sent by this notifier
+	allVariants, err := loadNotifierVariants(app.Config)
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]*NotifierVariant, 0, len(allVariants))
+	for _, variant := range allVariants {
+		if variant.templatePost != nil {
+			variants = append(variants, variant)
+		}
+	}
+
 	// Parse the extra parameters for the templates
 	extras := make(map[string]string)
 	for _, extra := range app.Config.Httpnotifier.Extras {
@@ -80,34 +89,182 @@ func NewHttpNotifier(app *ApplicationContext) (*HttpNotifier, error) {
 		extras[parts[0]] = parts[1]
 	}
 
+	quiet, err := NewQuietHours(app.Config.Httpnotifier.QuietSchedule, app.Config.Httpnotifier.QuietDuration,
+		app.Config.Httpnotifier.QuietWeekends, app.Config.Httpnotifier.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each endpoint has its own circuit breaker
+	breakers := map[string]*CircuitBreaker{app.Config.Httpnotifier.Url: NewCircuitBreaker(app, "http", app.Config.Httpnotifier.Url)}
+	if app.Config.Httpnotifier.SecondaryUrl != "" {
+		breakers[app.Config.Httpnotifier.SecondaryUrl] = NewCircuitBreaker(app, "http", app.Config.Httpnotifier.SecondaryUrl)
+	}
+	for _, variant := range variants {
+		if _, ok := breakers[variant.Url]; !ok {
+			breakers[variant.Url] = NewCircuitBreaker(app, "http", variant.Url)
+		}
+	}
+
 	return &HttpNotifier{
 		app:            app,
+		breakers:       breakers,
+		quiet:          quiet,
 		templatePost:   templatePost,
 		templateDelete: templateDelete,
+		variants:       variants,
 		extras:         extras,
 		quitChan:       make(chan struct{}),
 		groupIds:       make(map[string]map[string]Event),
 		groupList:      make(map[string]map[string]bool),
 		groupLock:      sync.RWMutex{},
-		resultsChannel: make(chan *ConsumerGroupStatus),
+		resultsChannel: make(chan *storage.ConsumerGroupStatus),
 		httpClient: &http.Client{
 			Timeout: time.Duration(app.Config.Httpnotifier.Timeout) * time.Second,
 			Transport: &http.Transport{
-				Dial: (&net.Dialer{
-					KeepAlive: time.Duration(app.Config.Httpnotifier.Keepalive) * time.Second,
-				}).Dial,
-				Proxy: http.ProxyFromEnvironment,
+				DialContext: newDialer(time.Duration(app.Config.Httpnotifier.ConnectTimeout)*time.Second,
+					time.Duration(app.Config.Httpnotifier.Keepalive)*time.Second).DialContext,
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     newTLSConfig(),
+				TLSHandshakeTimeout: time.Duration(app.Config.Httpnotifier.ConnectTimeout) * time.Second,
+				MaxIdleConns:        app.Config.Httpnotifier.MaxIdle,
+				MaxIdleConnsPerHost: app.Config.Httpnotifier.MaxIdlePerHost,
+				IdleConnTimeout:     time.Duration(app.Config.Httpnotifier.IdleTimeout) * time.Second,
 			},
 		},
 	}, nil
 }
 
-func (notifier *HttpNotifier) handleEvaluationResponse(result *ConsumerGroupStatus) {
+// Render the POST body for a group, with a variant's template or (if variant is nil) the default one
+func (notifier *HttpNotifier) assemblePost(variant *NotifierVariant, result *storage.ConsumerGroupStatus, idStr string, startTime time.Time) (*bytes.Buffer, error) {
+	tmpl := notifier.templatePost
+	locale := ""
+	if variant != nil {
+		tmpl = variant.templatePost
+		locale = variant.Locale
+	}
+
+	// NOTE - I'm leaving the JsonEncode item in here so as not to break compatibility. New helpers go in notifierTemplateFuncs
+	bytesToSend := new(bytes.Buffer)
+	err := tmpl.Execute(bytesToSend, struct {
+		Cluster    string
+		Group      string
+		Id         string
+		Start      time.Time
+		Extras     map[string]string
+		Locale     string
+		Result     *storage.ConsumerGroupStatus
+		JsonEncode func(interface{}) string
+	}{
+		Cluster:    result.Cluster,
+		Group:      result.Group,
+		Id:         idStr,
+		Start:      startTime,
+		Extras:     notifier.extras,
+		Locale:     locale,
+		Result:     result,
+		JsonEncode: templateJsonEncoder,
+	})
+	return bytesToSend, err
+}
+
+// Render the DELETE body for a group, with a variant's template or (if variant is nil) the default one
+func (notifier *HttpNotifier) assembleDelete(variant *NotifierVariant, result *storage.ConsumerGroupStatus, event Event) (*bytes.Buffer, error) {
+	tmpl := notifier.templateDelete
+	locale := ""
+	if variant != nil {
+		tmpl = variant.templateDelete
+		locale = variant.Locale
+	}
+
+	bytesToSend := new(bytes.Buffer)
+	err := tmpl.Execute(bytesToSend, struct {
+		Cluster string
+		Group   string
+		Id      string
+		Start   time.Time
+		Extras  map[string]string
+		Locale  string
+	}{
+		Cluster: result.Cluster,
+		Group:   result.Group,
+		Id:      event.Id,
+		Start:   event.Start,
+		Extras:  notifier.extras,
+		Locale:  locale,
+	})
+	return bytesToSend, err
+}
+
+// Send a request to an HTTP endpoint, and return whether it was accepted. The description is used in the log messages.
+// Nothing is sent if the endpoint's circuit breaker is open
+func (notifier *HttpNotifier) send(method string, url string, bytesToSend *bytes.Buffer, description string) bool {
+	req, err := http.NewRequest(method, url, bytesToSend)
+	if err != nil {
+		log.Errorf("Failed to send %s: %v", description, err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	breaker := notifier.breakers[url]
+	if !breaker.Allow() {
+		log.Debugf("Not sending %s, as the circuit breaker for %s is open", description, url)
+		return false
+	}
+	resp, err := notifier.httpClient.Do(req)
+	if err != nil {
+		log.Errorf("Failed to send %s: %v", description, err)
+		breaker.Failed(err.Error())
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if (resp.StatusCode >= 200) && (resp.StatusCode <= 299) {
+		log.Debugf("Sent %s", description)
+		breaker.Succeeded()
+		return true
+	}
+	log.Errorf("Failed to send %s: %s", description, resp.Status)
+	if (resp.StatusCode >= 500) || (resp.StatusCode == http.StatusTooManyRequests) {
+		breaker.Failed(resp.Status)
+	} else {
+		// The endpoint is up, but didn't like this request (such as from a bad template)
+		breaker.Succeeded()
+	}
+	return false
+}
+
+// Send a request to the notifier's URL, or to the secondary URL (if there is one) if that fails
+func (notifier *HttpNotifier) sendDefault(method string, bytesToSend *bytes.Buffer, description string) {
+	body := bytesToSend.Bytes()
+	if notifier.send(method, notifier.app.Config.Httpnotifier.Url, bytes.NewBuffer(body), description) {
+		return
+	}
+	if notifier.app.Config.Httpnotifier.SecondaryUrl != "" {
+		notifier.send(method, notifier.app.Config.Httpnotifier.SecondaryUrl, bytes.NewBuffer(body), description+" to the secondary URL")
+	}
+}
+
+func (notifier *HttpNotifier) handleEvaluationResponse(result *storage.ConsumerGroupStatus) {
+	if result.PausedAt > 0 {
+		// Monitoring of the cluster is paused, so nothing is sent (including deletes) until it is resumed
+		return
+	}
+	if result.Status == storage.StatusWarming {
+		// New groups are not notified for until their burn-in period is over
+		return
+	}
+	if notifier.quiet.Suppress(result.Status, time.Now()) {
+		log.Debugf("Not sending WARN for group %s in cluster %s during quiet hours", result.Group, result.Cluster)
+		return
+	}
 	if int(result.Status) >= notifier.app.Config.Httpnotifier.PostThreshold {
 		// We only use IDs if we are sending deletes
 		idStr := ""
 		startTime := time.Now()
 		if notifier.app.Config.Httpnotifier.SendDelete {
+			notifier.eventLock.Lock()
 			if _, ok := notifier.groupIds[result.Cluster]; !ok {
 				// Create the cluster map
 				notifier.groupIds[result.Cluster] = make(map[string]Event)
@@ -124,97 +281,97 @@ func (notifier *HttpNotifier) handleEvaluationResponse(result *ConsumerGroupStat
 				idStr = notifier.groupIds[result.Cluster][result.Group].Id
 				startTime = notifier.groupIds[result.Cluster][result.Group].Start
 			}
+			notifier.eventLock.Unlock()
 		}
 
-		// NOTE - I'm leaving the JsonEncode item in here so as not to break compatibility. New helpers go in the FuncMap above
-		bytesToSend := new(bytes.Buffer)
-		err := notifier.templatePost.Execute(bytesToSend, struct {
-			Cluster    string
-			Group      string
-			Id         string
-			Start      time.Time
-			Extras     map[string]string
-			Result     *ConsumerGroupStatus
-			JsonEncode func(interface{}) string
-		}{
-			Cluster:    result.Cluster,
-			Group:      result.Group,
-			Id:         idStr,
-			Start:      startTime,
-			Extras:     notifier.extras,
-			Result:     result,
-			JsonEncode: templateJsonEncoder,
-		})
+		bytesToSend, err := notifier.assemblePost(nil, result, idStr, startTime)
 		if err != nil {
 			log.Errorf("Failed to assemble POST: %v", err)
 			return
 		}
 
 		// Send POST to HTTP endpoint
-		req, err := http.NewRequest("POST", notifier.app.Config.Httpnotifier.Url, bytesToSend)
-		req.Header.Set("Content-Type", "application/json")
+		description := fmt.Sprintf("POST for group %s in cluster %s at severity %v (Id %s)", result.Group, result.Cluster, result.Status, idStr)
+		notifier.sendDefault("POST", bytesToSend, description)
+		notifier.app.Fatigue.Notified(result.Cluster, result.Group)
 
-		resp, err := notifier.httpClient.Do(req)
-		if err != nil {
-			log.Errorf("Failed to send POST for group %s in cluster %s at severity %v (Id %s): %v", result.Group, result.Cluster, result.Status, idStr, err)
-			return
-		}
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
-
-		if (resp.StatusCode >= 200) && (resp.StatusCode <= 299) {
-			log.Debugf("Sent POST for group %s in cluster %s at severity %v (Id %s)", result.Group, result.Cluster, result.Status, idStr)
-		} else {
-			log.Errorf("Failed to send POST for group %s in cluster %s at severity %v (Id %s): %s", result.Group,
-				result.Cluster, result.Status, idStr, resp.Status)
+		// Every variant that matches the group is sent to its own endpoint as well
+		for _, variant := range notifier.variants {
+			if !variant.matches(result) {
+				continue
+			}
+			bytesToSend, err := notifier.assemblePost(variant, result, idStr, startTime)
+			if err != nil {
+				log.Errorf("Failed to assemble POST with notifier template %s: %v", variant.Name, err)
+				continue
+			}
+			notifier.send("POST", variant.Url, bytesToSend, description+" with notifier template "+variant.Name)
 		}
 	}
 
-	if notifier.app.Config.Httpnotifier.SendDelete && (result.Status == StatusOK) {
-		if _, ok := notifier.groupIds[result.Cluster][result.Group]; ok {
+	if notifier.app.Config.Httpnotifier.SendDelete && (result.Status == storage.StatusOK) {
+		notifier.eventLock.Lock()
+		event, ok := notifier.groupIds[result.Cluster][result.Group]
+		notifier.eventLock.Unlock()
+		if ok {
 			// Send DELETE to HTTP endpoint
-			bytesToSend := new(bytes.Buffer)
-			err := notifier.templateDelete.Execute(bytesToSend, struct {
-				Cluster string
-				Group   string
-				Id      string
-				Start   time.Time
-				Extras  map[string]string
-			}{
-				Cluster: result.Cluster,
-				Group:   result.Group,
-				Id:      notifier.groupIds[result.Cluster][result.Group].Id,
-				Start:   notifier.groupIds[result.Cluster][result.Group].Start,
-				Extras:  notifier.extras,
-			})
+			bytesToSend, err := notifier.assembleDelete(nil, result, event)
 			if err != nil {
 				log.Errorf("Failed to assemble DELETE for group %s in cluster %s (Id %s): %v", result.Group,
-					result.Cluster, notifier.groupIds[result.Cluster][result.Group].Id, err)
-				return
-			}
-
-			req, err := http.NewRequest("DELETE", notifier.app.Config.Httpnotifier.Url, bytesToSend)
-			req.Header.Set("Content-Type", "application/json")
-
-			resp, err := notifier.httpClient.Do(req)
-			if err != nil {
-				log.Errorf("Failed to send DELETE for group %s in cluster %s (Id %s): %v", result.Group,
-					result.Cluster, notifier.groupIds[result.Cluster][result.Group].Id, err)
+					result.Cluster, event.Id, err)
 				return
 			}
-			io.Copy(ioutil.Discard, resp.Body)
-			resp.Body.Close()
+			description := fmt.Sprintf("DELETE for group %s in cluster %s (Id %s)", result.Group, result.Cluster, event.Id)
+			notifier.sendDefault("DELETE", bytesToSend, description)
 
-			if (resp.StatusCode >= 200) && (resp.StatusCode <= 299) {
-				log.Debugf("Sent DELETE for group %s in cluster %s (Id %s)", result.Group, result.Cluster,
-					notifier.groupIds[result.Cluster][result.Group].Id)
-			} else {
-				log.Errorf("Failed to send DELETE for group %s in cluster %s (Id %s): %s", result.Group,
-					result.Cluster, notifier.groupIds[result.Cluster][result.Group].Id, resp.Status)
+			for _, variant := range notifier.variants {
+				if (variant.templateDelete == nil) || !variant.matches(result) {
+					continue
+				}
+				bytesToSend, err := notifier.assembleDelete(variant, result, event)
+				if err != nil {
+					log.Errorf("Failed to assemble DELETE with notifier template %s: %v", variant.Name, err)
+					continue
+				}
+				notifier.send("DELETE", variant.Url, bytesToSend, description+" with notifier template "+variant.Name)
 			}
 
 			// Remove ID for group that is now clear
+			notifier.eventLock.Lock()
 			delete(notifier.groupIds[result.Cluster], result.Group)
+			notifier.eventLock.Unlock()
+		}
+	}
+}
+
+// Return a copy of the open incidents (the groups that a POST has been sent for and a DELETE has not), by cluster and
+// group
+func (notifier *HttpNotifier) Incidents() map[string]map[string]Event {
+	notifier.eventLock.Lock()
+	defer notifier.eventLock.Unlock()
+	incidents := make(map[string]map[string]Event, len(notifier.groupIds))
+	for cluster, groups := range notifier.groupIds {
+		incidents[cluster] = make(map[string]Event, len(groups))
+		for group, event := range groups {
+			incidents[cluster][group] = event
+		}
+	}
+	return incidents
+}
+
+// Take over open incidents from another instance, so that the same Id is used when they are sent again and they are
+// deleted when the group is OK. Incidents that are already open here are kept as they are
+func (notifier *HttpNotifier) ImportIncidents(incidents map[string]map[string]Event) {
+	notifier.eventLock.Lock()
+	defer notifier.eventLock.Unlock()
+	for cluster, groups := range incidents {
+		if _, ok := notifier.groupIds[cluster]; !ok {
+			notifier.groupIds[cluster] = make(map[string]Event)
+		}
+		for group, event := range groups {
+			if _, ok := notifier.groupIds[cluster][group]; !ok {
+				notifier.groupIds[cluster][group] = event
+			}
 		}
 	}
 }
@@ -231,10 +388,17 @@ func (notifier *HttpNotifier) refreshConsumerGroups() {
 		}
 
 		// Get a current list of consumer groups
-		storageRequest := &RequestConsumerList{Result: make(chan []string), Cluster: cluster}
-		notifier.app.Storage.requestChannel <- storageRequest
+		storageRequest := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+		notifier.app.Storage.RequestChannel <- storageRequest
 		consumerGroups := <-storageRequest.Result
 
+		// Expected groups are evaluated even if they have never committed offsets
+		expectedRequest := &storage.RequestExpectedGroupList{Result: make(chan []*storage.ExpectedGroup), Cluster: cluster}
+		notifier.app.Storage.RequestChannel <- expectedRequest
+		for _, expected := range <-expectedRequest.Result {
+			consumerGroups = append(consumerGroups, expected.Group)
+		}
+
 		// Mark all existing groups false
 		for consumerGroup := range notifier.groupList {
 			clusterGroups[consumerGroup] = false
@@ -243,7 +407,7 @@ func (notifier *HttpNotifier) refreshConsumerGroups() {
 		// Check for new groups, mark existing groups true
 		for _, consumerGroup := range consumerGroups {
 			// Don't bother adding groups in the blacklist
-			if (notifier.app.Storage.groupBlacklist != nil) && notifier.app.Storage.groupBlacklist.MatchString(consumerGroup) {
+			if (notifier.app.Storage.GroupBlacklist != nil) && notifier.app.Storage.GroupBlacklist.MatchString(consumerGroup) {
 				continue
 			}
 
@@ -280,8 +444,8 @@ func (notifier *HttpNotifier) startConsumerGroupEvaluator(group string, cluster
 		notifier.groupLock.RUnlock()
 
 		// Send requests for group status - responses are handled by the main loop (for now)
-		storageRequest := &RequestConsumerStatus{Result: notifier.resultsChannel, Cluster: cluster, Group: group}
-		notifier.app.Storage.requestChannel <- storageRequest
+		storageRequest := &storage.RequestConsumerStatus{Result: notifier.resultsChannel, Cluster: cluster, Group: group}
+		notifier.app.Storage.RequestChannel <- storageRequest
 
 		// Sleep for the check interval
 		time.Sleep(time.Duration(notifier.app.Config.Httpnotifier.Interval) * time.Second)
@@ -295,8 +459,10 @@ func (notifier *HttpNotifier) Start() {
 	// Set a ticker to refresh the group list periodically
 	notifier.refreshTicker = time.NewTicker(time.Duration(notifier.app.Config.Lagcheck.ZKGroupRefresh) * time.Second)
 
-	// Main loop to handle refreshes and evaluation responses
+	// Main loop to handle refreshes and evaluation responses. The sends it starts are waited for by Wait
+	notifier.sending.Add(1)
 	go func() {
+		defer notifier.sending.Done()
 	OUTERLOOP:
 		for {
 			select {
@@ -305,7 +471,11 @@ func (notifier *HttpNotifier) Start() {
 			case <-notifier.refreshTicker.C:
 				notifier.refreshConsumerGroups()
 			case result := <-notifier.resultsChannel:
-				go notifier.handleEvaluationResponse(result)
+				notifier.sending.Add(1)
+				go func() {
+					defer notifier.sending.Done()
+					notifier.handleEvaluationResponse(result)
+				}()
 			}
 		}
 	}()
@@ -320,3 +490,8 @@ func (notifier *HttpNotifier) Stop() {
 	}
 	close(notifier.quitChan)
 }
+
+// Wait, after Stop, for the notifications that were being sent to finish
+func (notifier *HttpNotifier) Wait() {
+	notifier.sending.Wait()
+}
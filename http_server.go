@@ -11,17 +11,37 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// The longest a request to the status endpoints will be held waiting for a change, and how often we check
+const maxStatusWait = 2 * time.Minute
+const statusWaitInterval = time.Second
+
+// Limits for batch status requests. The groups are evaluated concurrently, but with no more than this many at a time
+const maxBatchGroups = 100
+const batchStatusWorkers = 8
+
 type HttpServer struct {
-	app *ApplicationContext
-	mux *http.ServeMux
+	app      *ApplicationContext
+	mux      *http.ServeMux
+	listener net.Listener
+	server   *http.Server
 }
 
 type appHandler struct {
@@ -30,6 +50,31 @@ type appHandler struct {
 }
 
 func NewHttpServer(app *ApplicationContext) (*HttpServer, error) {
+	server := newHttpServer(app)
+
+	// An empty address listens on all addresses (of the configured family)
+	address := net.JoinHostPort(trimBrackets(server.app.Config.Httpserver.Address), strconv.Itoa(server.app.Config.Httpserver.Port))
+	listener, err := net.Listen(listenNetwork(), address)
+	if err != nil {
+		return nil, err
+	}
+	server.listener = listener
+	server.server = &http.Server{Handler: server.Handler()}
+	return server, nil
+}
+
+// Start serving requests on the listener opened by NewHttpServer
+func (server *HttpServer) Start() error {
+	go func() {
+		if err := server.server.Serve(server.listener); err != http.ErrServerClosed {
+			log.Errorf("HTTP server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Set up the routes of the HTTP server, without listening. The integration tests serve these with httptest
+func newHttpServer(app *ApplicationContext) *HttpServer {
 	server := &HttpServer{
 		app: app,
 		mux: http.NewServeMux(),
@@ -40,29 +85,78 @@ func NewHttpServer(app *ApplicationContext) (*HttpServer, error) {
 
 	// This is a healthcheck URL. Please don't change it
 	server.mux.HandleFunc("/burrow/admin", handleAdmin)
+	server.mux.HandleFunc("/burrow/health", handleHealth(server.app))
 
 	// All valid paths go here. Make sure they use the right handler
 	server.mux.Handle("/v2/kafka", appHandler{server.app, handleClusterList})
 	server.mux.Handle("/v2/kafka/", appHandler{server.app, handleKafka})
 	server.mux.Handle("/v2/zookeeper", appHandler{server.app, handleClusterList})
+	server.mux.Handle("/v2/burrow/startup", appHandler{server.app, handleStartup})
+	server.mux.Handle("/v2/burrow/health", appHandler{server.app, handleHealthDetail})
+	server.mux.Handle("/v2/burrow/validation", appHandler{server.app, handleValidation})
+	server.mux.Handle("/v2/burrow/usage", appHandler{server.app, handleUsage})
+	server.mux.Handle("/v2/burrow/notifiers", appHandler{server.app, handleNotifiers})
+	server.mux.Handle("/v2/burrow/alert-fatigue", appHandler{server.app, handleAlertFatigue})
+	server.mux.Handle("/v2/burrow/produce-alerts", appHandler{server.app, handleProduceAlerts})
+	server.mux.Handle("/v2/burrow/mirror-divergence", appHandler{server.app, handleMirrorDivergence})
+	server.mux.Handle("/v2/burrow/trend-report", appHandler{server.app, handleTrendReport})
+	server.mux.Handle("/v2/burrow/accounting", appHandler{server.app, handleAccounting})
+	server.mux.Handle("/v2/burrow/stale-config", appHandler{server.app, handleStaleConfig})
+	server.mux.Handle("/v2/burrow/config", appHandler{server.app, handleConfig})
+	server.mux.Handle("/v2/burrow/status/", appHandler{server.app, handleStatusLink})
+	server.mux.Handle("/v2/export/offsets", appHandler{server.app, handleOffsetExport})
+	server.mux.Handle("/v2/export/checkpoint", appHandler{server.app, handleCheckpointExport})
+	server.mux.Handle("/graphql", appHandler{server.app, handleGraphQL})
+	server.mux.Handle("/graphql/schema", appHandler{server.app, handleGraphQLSchema})
+	server.mux.Handle("/v2/admin/simulate", appHandler{server.app, handleSimulate})
+	server.mux.Handle("/v2/admin/notifier-dryrun", appHandler{server.app, handleNotifierDryRun})
+	server.mux.Handle("/v2/admin/kafka/", appHandler{server.app, adminHandler("pause or resume cluster", handleClusterPause)})
+	server.mux.Handle("/v2/admin/audit", appHandler{server.app, handleAdminAudit})
+	server.mux.Handle("/v2/admin/quarantine", appHandler{server.app, handleQuarantine})
+	server.mux.Handle("/v2/admin/loglevel", appHandler{server.app, handleLogLevel})
+	server.mux.Handle("/v2/admin/handoff", appHandler{server.app, adminHandler("hand off to peer", handleHandoff)})
+	server.mux.Handle("/v2/admin/handoff/receive", appHandler{server.app, adminHandler("receive hand-off", handleHandoffReceive)})
+	server.mux.Handle("/metrics", tokenHandler{server.app, server.app.Metrics})
 	// server.mux.Handle("/v2/zookeeper/", appHandler{server.app, handleZookeeper})
 
-	go http.ListenAndServe(fmt.Sprintf(":%v", server.app.Config.Httpserver.Port), server.mux)
-	return server, nil
+	// Compatibility versions of the API, which rewrite the v2 responses
+	for name, cfg := range server.app.Config.Api {
+		server.mux.Handle("/"+name+"/kafka", newCompatHandler(name, cfg, appHandler{server.app, handleClusterList}))
+		server.mux.Handle("/"+name+"/kafka/", newCompatHandler(name, cfg, appHandler{server.app, handleKafka}))
+	}
+	return server
+}
+
+// Return the handler for every request to the server, which records the request metrics before routing it
+func (server *HttpServer) Handler() http.Handler {
+	return newInstrumentedHandler(server.app.Metrics, server.mux)
 }
 
 func (ah appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if status, message := ah.app.AdminAudit.Authorize(r); status != 0 {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		makeErrorResponse(status, message, w, r)
+		return
+	}
 	switch {
 	case r.Method == "GET":
-		if status, err := ah.handler(ah.app, w, r); status != 200 {
+		if status, err := ah.handler(ah.app, w, r); (status != 200) && (err != "") {
 			http.Error(w, err, status)
 		} else {
 			io.WriteString(w, err)
 		}
 	case r.Method == "DELETE":
-		// Later we can add authentication here
-		if status, err := ah.handler(ah.app, w, r); status != 200 {
+		// Handlers for destructive calls check for an admin token with adminAction
+		if status, err := ah.handler(ah.app, w, r); (status != 200) && (err != "") {
+			http.Error(w, err, status)
+		} else {
+			io.WriteString(w, err)
+		}
+	case (r.Method == "POST") || (r.Method == "PUT"):
+		if status, err := ah.handler(ah.app, w, r); (status != 200) && (err != "") {
 			http.Error(w, err, status)
 		} else {
 			io.WriteString(w, err)
@@ -104,6 +198,12 @@ type HTTPResponseClusterDetailCluster struct {
 	Brokers       []string `json:"brokers"`
 	BrokerPort    int      `json:"broker_port"`
 	OffsetsTopic  string   `json:"offsets_topic"`
+
+	// Errors for the offset sources of the cluster that could not be started, by source type
+	Errors map[string]string `json:"errors,omitempty"`
+
+	// How many times the watchdog has restarted each offset source of the cluster
+	Restarts map[string]int `json:"restarts,omitempty"`
 }
 type HTTPResponseClusterDetail struct {
 	Error   bool                             `json:"error"`
@@ -112,10 +212,13 @@ type HTTPResponseClusterDetail struct {
 	Request HTTPResponseRequestInfo          `json:"request"`
 }
 type HTTPResponseClusterList struct {
-	Error    bool                    `json:"error"`
-	Message  string                  `json:"message"`
-	Clusters []string                `json:"clusters"`
-	Request  HTTPResponseRequestInfo `json:"request"`
+	Error         bool                              `json:"error"`
+	Message       string                            `json:"message"`
+	Clusters      []string                          `json:"clusters"`
+	ClusterErrors map[string]map[string]string      `json:"cluster_errors,omitempty"`
+	Counts        map[string]*storage.ClusterCounts `json:"counts,omitempty"`
+	SnapshotAt    int64                             `json:"snapshot_at,omitempty"`
+	Request       HTTPResponseRequestInfo           `json:"request"`
 }
 type HTTPResponseTopicList struct {
 	Error   bool                    `json:"error"`
@@ -124,10 +227,19 @@ type HTTPResponseTopicList struct {
 	Request HTTPResponseRequestInfo `json:"request"`
 }
 type HTTPResponseTopicDetail struct {
-	Error   bool                    `json:"error"`
-	Message string                  `json:"message"`
-	Offsets []int64                 `json:"offsets"`
-	Request HTTPResponseRequestInfo `json:"request"`
+	Error         bool                    `json:"error"`
+	Message       string                  `json:"message"`
+	Offsets       []int64                 `json:"offsets"`
+	StableOffsets []int64                 `json:"stable_offsets,omitempty"`
+	Request       HTTPResponseRequestInfo `json:"request"`
+}
+type HTTPResponseTopicRate struct {
+	Error     bool                    `json:"error"`
+	Message   string                  `json:"message"`
+	Rates     []float64               `json:"rates"`
+	TotalRate float64                 `json:"total_rate"`
+	Window    int64                   `json:"window"`
+	Request   HTTPResponseRequestInfo `json:"request"`
 }
 type HTTPResponseConsumerList struct {
 	Error     bool                    `json:"error"`
@@ -135,12 +247,92 @@ type HTTPResponseConsumerList struct {
 	Consumers []string                `json:"consumers"`
 	Request   HTTPResponseRequestInfo `json:"request"`
 }
+type HTTPResponseDroppedOffsets struct {
+	Error   bool                     `json:"error"`
+	Message string                   `json:"message"`
+	Dropped []*storage.DroppedOffset `json:"dropped"`
+	Request HTTPResponseRequestInfo  `json:"request"`
+}
+type HTTPResponseExpectedGroupList struct {
+	Error    bool                     `json:"error"`
+	Message  string                   `json:"message"`
+	Expected []*storage.ExpectedGroup `json:"expected"`
+	Request  HTTPResponseRequestInfo  `json:"request"`
+}
+type HTTPRequestExpectedGroup struct {
+	Topics   []string `json:"topics"`
+	Schedule string   `json:"schedule"`
+	Window   int64    `json:"window"`
+}
+type HTTPResponseIgnoredPartitionList struct {
+	Error   bool                        `json:"error"`
+	Message string                      `json:"message"`
+	Ignored []*storage.IgnoredPartition `json:"ignored"`
+	Request HTTPResponseRequestInfo     `json:"request"`
+}
+type HTTPRequestIgnoredPartition struct {
+	Reason string `json:"reason"`
+}
+type HTTPResponseGroupMetadata struct {
+	Error    bool                    `json:"error"`
+	Message  string                  `json:"message"`
+	Metadata map[string]string       `json:"metadata"`
+	Request  HTTPResponseRequestInfo `json:"request"`
+}
 type HTTPResponseConsumerStatus struct {
+	Error   bool                        `json:"error"`
+	Message string                      `json:"message"`
+	Status  storage.ConsumerGroupStatus `json:"status"`
+	Request HTTPResponseRequestInfo     `json:"request"`
+}
+type HTTPResponseConsumerStatusBatch struct {
+	Error   bool                           `json:"error"`
+	Message string                         `json:"message"`
+	Status  []*storage.ConsumerGroupStatus `json:"status"`
+	Request HTTPResponseRequestInfo        `json:"request"`
+}
+type HTTPResponseOffsetHistory struct {
+	Error     bool                      `json:"error"`
+	Message   string                    `json:"message"`
+	Timestamp int64                     `json:"timestamp"`
+	Offsets   []*storage.ArchivedOffset `json:"offsets"`
+	Request   HTTPResponseRequestInfo   `json:"request"`
+}
+type HTTPResponseOffsetDelta struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Start   int64                   `json:"start"`
+	End     int64                   `json:"end"`
+	Topics  map[string][]int64      `json:"topics"`
+	Total   int64                   `json:"total"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+type HTTPResponseClusterOffsets struct {
 	Error   bool                    `json:"error"`
 	Message string                  `json:"message"`
-	Status  ConsumerGroupStatus     `json:"status"`
+	Offsets map[string][]int64      `json:"offsets"`
 	Request HTTPResponseRequestInfo `json:"request"`
 }
+type HTTPResponseTopicOffsets struct {
+	Topic   string  `json:"topic"`
+	Offsets []int64 `json:"offsets"`
+}
+type HTTPResponseConsumerGate struct {
+	Error    bool                    `json:"error"`
+	Message  string                  `json:"message"`
+	Pass     bool                    `json:"pass"`
+	Reasons  []string                `json:"reasons"`
+	Status   storage.StatusConstant  `json:"status"`
+	TotalLag uint64                  `json:"totallag"`
+	Request  HTTPResponseRequestInfo `json:"request"`
+}
+type HTTPResponsePartitionStatus struct {
+	Error   bool                     `json:"error"`
+	Message string                   `json:"message"`
+	Status  *storage.PartitionStatus `json:"status"`
+	Trace   []string                 `json:"trace,omitempty"`
+	Request HTTPResponseRequestInfo  `json:"request"`
+}
 
 func makeRequestInfo(r *http.Request) HTTPResponseRequestInfo {
 	hostname, _ := os.Hostname()
@@ -149,6 +341,24 @@ func makeRequestInfo(r *http.Request) HTTPResponseRequestInfo {
 		Host: hostname,
 	}
 }
+
+// Check the token for a handler that isn't an appHandler (such as the metrics), which isn't for any one cluster
+type tokenHandler struct {
+	app     *ApplicationContext
+	handler http.Handler
+}
+
+func (th tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if status, message := th.app.AdminAudit.Authorize(r); status != 0 {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, message, status)
+		return
+	}
+	th.handler.ServeHTTP(w, r)
+}
+
 func makeErrorResponse(errValue int, message string, w http.ResponseWriter, r *http.Request) (int, string) {
 	rv := HTTPResponseError{
 		Error:   true,
@@ -160,6 +370,8 @@ func makeErrorResponse(errValue int, message string, w http.ResponseWriter, r *h
 	if err != nil {
 		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
 	} else {
+		// The status code has to be written before the body, or the client will get a 200
+		w.WriteHeader(errValue)
 		w.Write(jsonStr)
 		return errValue, ""
 	}
@@ -170,19 +382,40 @@ func handleClusterList(app *ApplicationContext, w http.ResponseWriter, r *http.R
 		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
 	}
 
-	clusterList := make([]string, len(app.Config.Kafka))
-	i := 0
+	// A token that is scoped to some clusters only sees those
+	clusterList := make([]string, 0, len(app.Config.Kafka))
 	for cluster, _ := range app.Config.Kafka {
-		clusterList[i] = cluster
-		i++
+		if app.AdminAudit.ClusterAllowed(r, cluster) {
+			clusterList = append(clusterList, cluster)
+		}
 	}
-	requestInfo := makeRequestInfo(r)
-	jsonStr, err := json.Marshal(HTTPResponseClusterList{
-		Error:    false,
-		Message:  "cluster list returned",
-		Clusters: clusterList,
-		Request:  requestInfo,
-	})
+	clusterErrors := app.Sources.Errors()
+	for cluster := range clusterErrors {
+		if !app.AdminAudit.ClusterAllowed(r, cluster) {
+			delete(clusterErrors, cluster)
+		}
+	}
+	response := HTTPResponseClusterList{
+		Error:         false,
+		Message:       "cluster list returned",
+		Clusters:      clusterList,
+		ClusterErrors: clusterErrors,
+		Request:       makeRequestInfo(r),
+	}
+
+	// The counts are left out if the storage module is too busy, since the list itself comes from the config
+	storageRequest := &storage.RequestClusterList{Result: make(chan *storage.ResponseClusterList)}
+	if sendStorageRequest(app, storageRequest) {
+		snapshot := <-storageRequest.Result
+		response.Counts = snapshot.Counts
+		for cluster := range response.Counts {
+			if !app.AdminAudit.ClusterAllowed(r, cluster) {
+				delete(response.Counts, cluster)
+			}
+		}
+		response.SnapshotAt = snapshot.SnapshotAt
+	}
+	jsonStr, err := json.Marshal(response)
 	if err != nil {
 		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
 	}
@@ -202,8 +435,16 @@ func handleKafka(app *ApplicationContext, w http.ResponseWriter, r *http.Request
 		return handleClusterList(app, w, r)
 	}
 	if (len(pathParts) == 3) || (pathParts[3] == "") {
+		if r.Method != "GET" {
+			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+		}
 		return handleClusterDetail(app, w, r, pathParts[2])
 	}
+	if isStatusRequest(pathParts) {
+		if reason := app.Storage.Overloaded(pathParts[2], app.Config.Httpserver.OverloadThreshold); reason != "" {
+			return makeOverloadedResponse(app, reason, w, r)
+		}
+	}
 
 	switch pathParts[3] {
 	case "consumer":
@@ -211,10 +452,25 @@ func handleKafka(app *ApplicationContext, w http.ResponseWriter, r *http.Request
 		case r.Method == "DELETE":
 			switch {
 			case (len(pathParts) == 5) || (pathParts[5] == ""):
-				return handleConsumerDrop(app, w, r, pathParts[2], pathParts[4])
+				return adminAction(app, w, r, "delete consumer group", func() (int, string) {
+					return handleConsumerDrop(app, w, r, pathParts[2], pathParts[4])
+				})
 			default:
 				return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
 			}
+		case (r.Method == "POST") && (len(pathParts) >= 6) && (pathParts[5] == "restore"):
+			return adminAction(app, w, r, "restore consumer group", func() (int, string) {
+				return handleConsumerRestore(app, w, r, pathParts[2], pathParts[4])
+			})
+		case (r.Method == "PUT") && (len(pathParts) >= 6) && (pathParts[5] == "metadata"):
+			return adminAction(app, w, r, "set consumer group metadata", func() (int, string) {
+				return handleConsumerMetadataSet(app, w, r, pathParts[2], pathParts[4])
+			})
+		case (r.Method == "POST") && (len(pathParts) >= 10) && (pathParts[5] == "topic") && (pathParts[7] == "partition") &&
+			(pathParts[9] == "caught-up"):
+			return adminAction(app, w, r, "mark partition caught up", func() (int, string) {
+				return handleConsumerPartitionCaughtUp(app, w, r, pathParts[2], pathParts[4], pathParts[6], pathParts[8])
+			})
 		case r.Method == "GET":
 			switch {
 			case (len(pathParts) == 4) || (pathParts[4] == ""):
@@ -228,15 +484,38 @@ func handleKafka(app *ApplicationContext, w http.ResponseWriter, r *http.Request
 					return handleConsumerTopicList(app, w, r, pathParts[2], pathParts[4])
 				case (len(pathParts) == 7) || (pathParts[7] == ""):
 					return handleConsumerTopicDetail(app, w, r, pathParts[2], pathParts[4], pathParts[6])
+				case pathParts[7] == "history":
+					return handleConsumerTopicHistory(app, w, r, pathParts[2], pathParts[4], pathParts[6])
+				case (len(pathParts) >= 10) && (pathParts[7] == "partition") && (pathParts[9] == "status"):
+					return handleConsumerPartitionStatus(app, w, r, pathParts[2], pathParts[4], pathParts[6], pathParts[8])
 				}
 			case pathParts[5] == "status":
 				return handleConsumerStatus(app, w, r, pathParts[2], pathParts[4], false)
 			case pathParts[5] == "lag":
 				return handleConsumerStatus(app, w, r, pathParts[2], pathParts[4], true)
+			case pathParts[5] == "gate":
+				return handleConsumerGate(app, w, r, pathParts[2], pathParts[4])
+			case pathParts[5] == "scale-hint":
+				return handleConsumerScaleHint(app, w, r, pathParts[2], pathParts[4])
+			case pathParts[5] == "delta":
+				return handleConsumerDelta(app, w, r, pathParts[2], pathParts[4])
+			case pathParts[5] == "rollup":
+				return handleConsumerRollup(app, w, r, pathParts[2], pathParts[4])
+			case pathParts[5] == "metadata":
+				return handleConsumerMetadata(app, w, r, pathParts[2], pathParts[4])
 			}
 		default:
 			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
 		}
+	case "consumer-status":
+		switch r.Method {
+		case "GET":
+			return handleConsumerStatusAll(app, w, r, pathParts[2])
+		case "POST":
+			return handleConsumerStatusBatch(app, w, r, pathParts[2])
+		default:
+			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+		}
 	case "topic":
 		switch {
 		case r.Method != "GET":
@@ -245,10 +524,71 @@ func handleKafka(app *ApplicationContext, w http.ResponseWriter, r *http.Request
 			return handleBrokerTopicList(app, w, r, pathParts[2])
 		case (len(pathParts) == 5) || (pathParts[5] == ""):
 			return handleBrokerTopicDetail(app, w, r, pathParts[2], pathParts[4])
+		case pathParts[5] == "rate":
+			return handleBrokerTopicRate(app, w, r, pathParts[2], pathParts[4])
+		}
+	case "expected":
+		switch {
+		case (len(pathParts) == 4) || (pathParts[4] == ""):
+			if r.Method != "GET" {
+				return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+			}
+			return handleExpectedGroupList(app, w, r, pathParts[2])
+		case (len(pathParts) == 5) || (pathParts[5] == ""):
+			switch r.Method {
+			case "PUT":
+				return adminAction(app, w, r, "set expected group", func() (int, string) {
+					return handleExpectedGroupSet(app, w, r, pathParts[2], pathParts[4])
+				})
+			case "DELETE":
+				return adminAction(app, w, r, "delete expected group", func() (int, string) {
+					return handleExpectedGroupDelete(app, w, r, pathParts[2], pathParts[4])
+				})
+			default:
+				return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+			}
+		}
+	case "ignored":
+		switch {
+		case (len(pathParts) == 4) || (pathParts[4] == ""):
+			if r.Method != "GET" {
+				return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+			}
+			return handleIgnoredPartitionList(app, w, r, pathParts[2])
+		case (len(pathParts) == 6) || ((len(pathParts) == 7) && (pathParts[6] == "")):
+			switch r.Method {
+			case "PUT":
+				return adminAction(app, w, r, "ignore partition", func() (int, string) {
+					return handleIgnoredPartitionSet(app, w, r, pathParts[2], pathParts[4], pathParts[5])
+				})
+			case "DELETE":
+				return adminAction(app, w, r, "stop ignoring partition", func() (int, string) {
+					return handleIgnoredPartitionDelete(app, w, r, pathParts[2], pathParts[4], pathParts[5])
+				})
+			default:
+				return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+			}
+		}
+	case "dropped":
+		if r.Method != "GET" {
+			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
 		}
+		return handleClusterDropped(app, w, r, pathParts[2])
 	case "offsets":
-		// Reserving this endpoint to implement later
-		return makeErrorResponse(http.StatusNotFound, "unknown API call", w, r)
+		if r.Method != "GET" {
+			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+		}
+		return handleClusterOffsets(app, w, r, pathParts[2])
+	case "maxlag":
+		if r.Method != "GET" {
+			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+		}
+		return handleClusterMaxLag(app, w, r, pathParts[2])
+	case "lag-percentiles":
+		if r.Method != "GET" {
+			return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+		}
+		return handleClusterLagPercentiles(app, w, r, pathParts[2])
 	}
 
 	// If we fell through, return a 404
@@ -275,6 +615,8 @@ func handleClusterDetail(app *ApplicationContext, w http.ResponseWriter, r *http
 			Brokers:       app.Config.Kafka[cluster].Brokers,
 			BrokerPort:    app.Config.Kafka[cluster].BrokerPort,
 			OffsetsTopic:  app.Config.Kafka[cluster].OffsetsTopic,
+			Errors:        app.Sources.ClusterErrors(cluster),
+			Restarts:      app.Sources.ClusterRestarts(cluster),
 		},
 	})
 	if err != nil {
@@ -285,9 +627,123 @@ func handleClusterDetail(app *ApplicationContext, w http.ResponseWriter, r *http
 	return 200, ""
 }
 
+// For large responses, compress the body if the client accepts it. The returned function must be called once the
+// body has been written
+func compressedWriter(w http.ResponseWriter, r *http.Request) (io.Writer, func()) {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		return w, func() {}
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	gz := gzip.NewWriter(w)
+	return gz, func() { gz.Close() }
+}
+
+// Bulk endpoints can stream their results as newline delimited JSON (one object per line) instead of a single
+// document, if the client asks for it with the Accept header or ?format=ndjson
+func wantsNDJSON(r *http.Request) bool {
+	return (r.URL.Query().Get("format") == "ndjson") || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// The status of a single group can be streamed as NDJSON, or as server-sent events with the Accept header or
+// ?format=sse. This returns "ndjson", "sse", or an empty string if the status should not be streamed
+func statusStreamFormat(r *http.Request) string {
+	switch {
+	case wantsNDJSON(r):
+		return "ndjson"
+	case (r.URL.Query().Get("format") == "sse") || strings.Contains(r.Header.Get("Accept"), "text/event-stream"):
+		return "sse"
+	}
+	return ""
+}
+
+// Write the events from the produce function to the client as server-sent events as they are emitted, with the JSON
+// encoding of the data on a single data line. These are never compressed, as proxies may hold back compressed events
+func streamSSE(w http.ResponseWriter, r *http.Request, produce func(emit func(event string, data interface{}) error) error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	err := produce(func(event string, data interface{}) error {
+		jsonStr, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonStr); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("Failed to stream events for %s: %v", r.URL.Path, err)
+	}
+}
+
+// Write the lines from the produce function to the client as they are emitted, flushing after each one
+func streamNDJSON(w http.ResponseWriter, r *http.Request, produce func(emit func(interface{}) error) error) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	writer, done := compressedWriter(w, r)
+	defer done()
+
+	encoder := json.NewEncoder(writer)
+	flusher, _ := w.(http.Flusher)
+	err := produce(func(line interface{}) error {
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		if gz, ok := writer.(*gzip.Writer); ok {
+			gz.Flush()
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("Failed to stream response for %s: %v", r.URL.Path, err)
+	}
+}
+
+func handleClusterOffsets(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	storageRequest := &storage.RequestBrokerOffsets{Result: make(chan map[string][]int64), Cluster: cluster}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+
+	if wantsNDJSON(r) {
+		streamNDJSON(w, r, func(emit func(interface{}) error) error {
+			for topic, offsets := range result {
+				if err := emit(HTTPResponseTopicOffsets{Topic: topic, Offsets: offsets}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return 200, ""
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+
+	// Encode straight to the (possibly compressed) response, as this can be very large for big clusters
+	writer, done := compressedWriter(w, r)
+	defer done()
+	err := json.NewEncoder(writer).Encode(HTTPResponseClusterOffsets{
+		Error:   false,
+		Message: "cluster topic offsets returned",
+		Offsets: result,
+		Request: requestInfo,
+	})
+	if err != nil {
+		log.Errorf("Failed to write cluster offsets response: %v", err)
+	}
+	return 200, ""
+}
+
 func handleConsumerList(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
-	storageRequest := &RequestConsumerList{Result: make(chan []string), Cluster: cluster}
-	app.Storage.requestChannel <- storageRequest
+	storageRequest := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+	app.Storage.RequestChannel <- storageRequest
 
 	requestInfo := makeRequestInfo(r)
 	requestInfo.Cluster = cluster
@@ -307,8 +763,8 @@ func handleConsumerList(app *ApplicationContext, w http.ResponseWriter, r *http.
 }
 
 func handleConsumerTopicList(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
-	storageRequest := &RequestTopicList{Result: make(chan *ResponseTopicList), Cluster: cluster, Group: group}
-	app.Storage.requestChannel <- storageRequest
+	storageRequest := &storage.RequestTopicList{Result: make(chan *storage.ResponseTopicList), Cluster: cluster, Group: group}
+	app.Storage.RequestChannel <- storageRequest
 	result := <-storageRequest.Result
 	if result.Error {
 		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
@@ -331,8 +787,8 @@ func handleConsumerTopicList(app *ApplicationContext, w http.ResponseWriter, r *
 }
 
 func handleConsumerTopicDetail(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, topic string) (int, string) {
-	storageRequest := &RequestOffsets{Result: make(chan *ResponseOffsets), Cluster: cluster, Topic: topic, Group: group}
-	app.Storage.requestChannel <- storageRequest
+	storageRequest := &storage.RequestOffsets{Result: make(chan *storage.ResponseOffsets), Cluster: cluster, Topic: topic, Group: group}
+	app.Storage.RequestChannel <- storageRequest
 	result := <-storageRequest.Result
 	if result.ErrorGroup {
 		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
@@ -359,13 +815,104 @@ func handleConsumerTopicDetail(app *ApplicationContext, w http.ResponseWriter, r
 	return 200, ""
 }
 
+// Returns nil if the storage module did not take the request within the storage timeout
+func fetchConsumerStatus(app *ApplicationContext, cluster string, group string, showall bool, trace bool) *storage.ConsumerGroupStatus {
+	storageRequest := &storage.RequestConsumerStatus{
+		Result:  make(chan *storage.ConsumerGroupStatus),
+		Cluster: cluster,
+		Group:   group,
+		Showall: showall,
+		Trace:   trace,
+	}
+	if !sendStorageRequest(app, storageRequest) {
+		return nil
+	}
+	return <-storageRequest.Result
+}
+
+// The ETag for a group status only covers the parts of the status that automation acts on (the overall status,
+// whether it is complete, and which partitions are in which state). It does not change when only the lag does
+func statusETag(status *storage.ConsumerGroupStatus) string {
+	partitions := make([]string, len(status.Partitions))
+	for i, part := range status.Partitions {
+		partitions[i] = fmt.Sprintf("%s:%v:%v", part.Topic, part.Partition, part.Status)
+	}
+	sort.Strings(partitions)
+
+	data := fmt.Sprintf("%v|%v|%v|%v|%v", status.Status, status.Complete, status.Missing, status.MissingTopics, partitions)
+	return fmt.Sprintf("%x", idHash([]byte(data)))
+}
+
 func handleConsumerStatus(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, showall bool) (int, string) {
-	storageRequest := &RequestConsumerStatus{Result: make(chan *ConsumerGroupStatus), Cluster: cluster, Group: group, Showall: showall}
-	app.Storage.requestChannel <- storageRequest
-	result := <-storageRequest.Result
-	if result.Status == StatusNotFound {
+	// With wait and since, hold the request until the status no longer matches the ETag the client already has
+	var wait time.Duration
+	if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+		var err error
+		wait, err = time.ParseDuration(waitParam)
+		if (err != nil) || (wait < 0) {
+			return makeErrorResponse(http.StatusBadRequest, "bad wait duration", w, r)
+		}
+		if wait > maxStatusWait {
+			wait = maxStatusWait
+		}
+	}
+	since := strings.Trim(r.URL.Query().Get("since"), "\"")
+	trace := r.URL.Query().Get("trace") == "true"
+
+	// With at, the group is evaluated as of a past time from the offset archive. This can't be combined with wait
+	var at int64
+	if atParam := r.URL.Query().Get("at"); atParam != "" {
+		var err error
+		at, err = parseTimestampParam(atParam)
+		if (err != nil) || (at <= 0) {
+			return makeErrorResponse(http.StatusBadRequest, "bad at timestamp", w, r)
+		}
+		if wait > 0 {
+			return makeErrorResponse(http.StatusBadRequest, "wait cannot be used with at", w, r)
+		}
+	}
+	if format := statusStreamFormat(r); format != "" {
+		if wait > 0 {
+			return makeErrorResponse(http.StatusBadRequest, "wait cannot be used with a streamed status", w, r)
+		}
+		return handleConsumerStatusStream(app, w, r, cluster, group, showall, trace, at, format)
+	}
+	if at > 0 {
+		return handleConsumerStatusAt(app, w, r, cluster, group, showall, trace, at)
+	}
+
+	result := fetchConsumerStatus(app, cluster, group, showall, trace)
+	if result == nil {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	etag := statusETag(result)
+	if (wait > 0) && (etag == since) {
+		timeout := time.After(wait)
+		ticker := time.NewTicker(statusWaitInterval)
+		defer ticker.Stop()
+
+		for etag == since {
+			select {
+			case <-timeout:
+				w.Header().Set("ETag", "\""+etag+"\"")
+				w.WriteHeader(http.StatusNotModified)
+				return 200, ""
+			case <-r.Context().Done():
+				// The client went away, so there's nobody to respond to
+				return 200, ""
+			case <-ticker.C:
+				result = fetchConsumerStatus(app, cluster, group, showall, trace)
+				if result == nil {
+					return makeOverloadedResponse(app, storageBusyReason, w, r)
+				}
+				etag = statusETag(result)
+			}
+		}
+	}
+	if result.Status == storage.StatusNotFound {
 		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
 	}
+	w.Header().Set("ETag", "\""+etag+"\"")
 
 	requestInfo := makeRequestInfo(r)
 	requestInfo.Cluster = cluster
@@ -383,76 +930,829 @@ func handleConsumerStatus(app *ApplicationContext, w http.ResponseWriter, r *htt
 	return 200, ""
 }
 
-func handleConsumerDrop(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
-	storageRequest := &RequestConsumerDrop{Result: make(chan StatusConstant), Cluster: cluster, Group: group}
-	app.Storage.requestChannel <- storageRequest
+// One line (or event) of a streamed group status. Each partition is sent as soon as its topic is evaluated, and the
+// last line has the group status, without the partitions that were already sent
+type HTTPResponseStatusStreamLine struct {
+	Partition *storage.PartitionStatus     `json:"partition,omitempty"`
+	Status    *storage.ConsumerGroupStatus `json:"status,omitempty"`
+}
+
+func handleConsumerStatusStream(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, showall bool, trace bool, at int64, format string) (int, string) {
+	storageRequest := &storage.RequestConsumerStatus{
+		Result:     make(chan *storage.ConsumerGroupStatus),
+		Cluster:    cluster,
+		Group:      group,
+		Showall:    showall,
+		Trace:      trace,
+		At:         at,
+		Partitions: make(chan *storage.PartitionStatus),
+	}
+	if !sendStorageRequest(app, storageRequest) {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+
+	produce := func(emit func(event string, data interface{}) error) error {
+		var err error
+		for {
+			select {
+			case partition := <-storageRequest.Partitions:
+				// Keep reading if the client went away, so the evaluation isn't left waiting
+				if err == nil {
+					err = emit("partition", HTTPResponseStatusStreamLine{Partition: partition})
+				}
+			case result := <-storageRequest.Result:
+				if err != nil {
+					return err
+				}
+				final := *result
+				final.Partitions = make([]*storage.PartitionStatus, 0)
+				return emit("status", HTTPResponseStatusStreamLine{Status: &final})
+			}
+		}
+	}
+	if format == "sse" {
+		streamSSE(w, r, produce)
+	} else {
+		streamNDJSON(w, r, func(emit func(interface{}) error) error {
+			return produce(func(event string, data interface{}) error {
+				return emit(data)
+			})
+		})
+	}
+	return 200, ""
+}
+
+func handleConsumerStatusAt(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, showall bool, trace bool, at int64) (int, string) {
+	storageRequest := &storage.RequestConsumerStatus{
+		Result:  make(chan *storage.ConsumerGroupStatus),
+		Cluster: cluster,
+		Group:   group,
+		Showall: showall,
+		Trace:   trace,
+		At:      at,
+	}
+	if !sendStorageRequest(app, storageRequest) {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
 	result := <-storageRequest.Result
-	if result == StatusNotFound {
-		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	if result.Status == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found in the offset archive at that time", w, r)
 	}
 
 	requestInfo := makeRequestInfo(r)
 	requestInfo.Cluster = cluster
 	requestInfo.Group = group
-	jsonStr, err := json.Marshal(HTTPResponseError{
+	jsonStr, err := json.Marshal(HTTPResponseConsumerStatus{
 		Error:   false,
-		Message: "consumer group removed",
+		Message: "archived consumer group status returned",
+		Status:  *result,
 		Request: requestInfo,
 	})
 	if err != nil {
 		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
-	} else {
-		w.Write(jsonStr)
-		return 200, ""
 	}
+	w.Write(jsonStr)
+	return 200, ""
 }
 
-func handleBrokerTopicList(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
-	storageRequest := &RequestTopicList{Result: make(chan *ResponseTopicList), Cluster: cluster}
-	app.Storage.requestChannel <- storageRequest
-	result := <-storageRequest.Result
+// Return the full status (with all partitions) for every group in the cluster. As this can be very large, it is best
+// fetched as NDJSON, in which case each group is evaluated and sent as it goes
+func handleConsumerStatusAll(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	storageRequest := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+	if !sendStorageRequest(app, storageRequest) {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	groups := <-storageRequest.Result
+
+	if wantsNDJSON(r) {
+		streamNDJSON(w, r, func(emit func(interface{}) error) error {
+			for _, group := range groups {
+				result := fetchConsumerStatus(app, cluster, group, true, false)
+				if result == nil {
+					// The response has already started, so all we can do is end it early
+					return errors.New(storageBusyReason)
+				}
+				if result.Status == storage.StatusNotFound {
+					// The group went away since we got the list
+					continue
+				}
+				if err := emit(result); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return 200, ""
+	}
+
+	results := make([]*storage.ConsumerGroupStatus, 0, len(groups))
+	for _, group := range groups {
+		result := fetchConsumerStatus(app, cluster, group, true, false)
+		if result == nil {
+			return makeOverloadedResponse(app, storageBusyReason, w, r)
+		}
+		if result.Status != storage.StatusNotFound {
+			results = append(results, result)
+		}
+	}
 
 	requestInfo := makeRequestInfo(r)
 	requestInfo.Cluster = cluster
-	jsonStr, err := json.Marshal(HTTPResponseTopicList{
+	writer, done := compressedWriter(w, r)
+	defer done()
+	err := json.NewEncoder(writer).Encode(HTTPResponseConsumerStatusBatch{
 		Error:   false,
-		Message: "broker topic list returned",
-		Topics:  result.TopicList,
+		Message: "consumer group statuses returned",
+		Status:  results,
 		Request: requestInfo,
 	})
 	if err != nil {
-		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+		log.Errorf("Failed to write consumer status response: %v", err)
 	}
-
-	w.Write(jsonStr)
 	return 200, ""
 }
 
-func handleBrokerTopicDetail(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, topic string) (int, string) {
-	storageRequest := &RequestOffsets{Result: make(chan *ResponseOffsets), Cluster: cluster, Topic: topic}
-	app.Storage.requestChannel <- storageRequest
-	result := <-storageRequest.Result
-	if result.ErrorTopic {
-		return makeErrorResponse(http.StatusNotFound, "topic not found", w, r)
+func handleConsumerStatusBatch(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	var groups []string
+	if err := json.NewDecoder(r.Body).Decode(&groups); err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+	}
+	if len(groups) == 0 {
+		return makeErrorResponse(http.StatusBadRequest, "no consumer groups requested", w, r)
+	}
+	if len(groups) > maxBatchGroups {
+		return makeErrorResponse(http.StatusBadRequest, fmt.Sprintf("too many consumer groups requested (max %v)", maxBatchGroups), w, r)
+	}
+	trace := r.URL.Query().Get("trace") == "true"
+
+	// Feed the group indexes to a fixed number of workers, and keep the results in the order they were requested
+	results := make([]*storage.ConsumerGroupStatus, len(groups))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; (i < batchStatusWorkers) && (i < len(groups)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				results[idx] = fetchConsumerStatus(app, cluster, groups[idx], false, trace)
+			}
+		}()
+	}
+	for idx := range groups {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+	for _, result := range results {
+		if result == nil {
+			return makeOverloadedResponse(app, storageBusyReason, w, r)
+		}
 	}
 
 	requestInfo := makeRequestInfo(r)
 	requestInfo.Cluster = cluster
-	requestInfo.Topic = topic
-	jsonStr, err := json.Marshal(HTTPResponseTopicDetail{
+	jsonStr, err := json.Marshal(HTTPResponseConsumerStatusBatch{
 		Error:   false,
-		Message: "broker topic offsets returned",
-		Offsets: result.OffsetList,
+		Message: "consumer group statuses returned",
+		Status:  results,
 		Request: requestInfo,
 	})
 	if err != nil {
 		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
 	}
-
 	w.Write(jsonStr)
 	return 200, ""
 }
 
-func (server *HttpServer) Stop() {
-	// Nothing to do right now
+func handleConsumerPartitionStatus(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, topic string, partitionID string) (int, string) {
+	partition, err := strconv.Atoi(partitionID)
+	if (err != nil) || (partition < 0) {
+		return makeErrorResponse(http.StatusBadRequest, "bad partition ID", w, r)
+	}
+
+	// Evaluate the whole group with all partitions included, and pull out the one we want
+	result := fetchConsumerStatus(app, cluster, group, true, r.URL.Query().Get("trace") == "true")
+	if result == nil {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	if result.Status == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+
+	var partitionStatus *storage.PartitionStatus
+	for _, part := range result.Partitions {
+		if (part.Topic == topic) && (part.Partition == int32(partition)) {
+			partitionStatus = part
+			break
+		}
+	}
+	if partitionStatus == nil {
+		return makeErrorResponse(http.StatusNotFound, "partition status not available", w, r)
+	}
+
+	// Only return the trace lines for this partition (each line starts with the elapsed time)
+	var trace []string
+	prefix := fmt.Sprintf(" %s:%v: ", topic, partition)
+	for _, line := range result.Trace {
+		if strings.Contains(line, prefix) {
+			trace = append(trace, line)
+		}
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponsePartitionStatus{
+		Error:   false,
+		Message: "consumer partition status returned",
+		Status:  partitionStatus,
+		Trace:   trace,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+// Timestamps in query parameters can be given either as milliseconds since the epoch, or in RFC 3339 format
+func parseTimestampParam(value string) (int64, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ts, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+func handleConsumerTopicHistory(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, topic string) (int, string) {
+	timestamp, err := parseTimestampParam(r.URL.Query().Get("timestamp"))
+	if err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "bad timestamp", w, r)
+	}
+
+	storageRequest := &storage.RequestOffsetHistory{
+		Result:    make(chan *storage.ResponseOffsetHistory),
+		Cluster:   cluster,
+		Group:     group,
+		Topic:     topic,
+		Timestamp: timestamp,
+	}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result.ErrorGroup {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+	if result.ErrorTopic {
+		return makeErrorResponse(http.StatusNotFound, "topic not found for consumer group", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponseOffsetHistory{
+		Error:     false,
+		Message:   "consumer group historical topic offsets returned",
+		Timestamp: timestamp,
+		Offsets:   result.Offsets,
+		Request:   requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleConsumerDelta(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	start, err := parseTimestampParam(r.URL.Query().Get("start"))
+	if err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "bad start timestamp", w, r)
+	}
+	end, err := parseTimestampParam(r.URL.Query().Get("end"))
+	if err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "bad end timestamp", w, r)
+	}
+	if end < start {
+		return makeErrorResponse(http.StatusBadRequest, "end timestamp is before start timestamp", w, r)
+	}
+
+	storageRequest := &storage.RequestOffsetDelta{
+		Result:  make(chan *storage.ResponseOffsetDelta),
+		Cluster: cluster,
+		Group:   group,
+		Start:   start,
+		End:     end,
+	}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result.ErrorGroup {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseOffsetDelta{
+		Error:   false,
+		Message: "consumer group offset delta returned",
+		Start:   start,
+		End:     end,
+		Topics:  result.Topics,
+		Total:   result.Total,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+// A simple pass/fail check on a consumer group for deployment pipelines. The group passes if its status is no worse than
+// max-status (OK by default) and its total lag is no more than max-lag (if given). A failure is returned as a 412
+func handleConsumerGate(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	maxStatus := storage.StatusOK
+	if param := r.URL.Query().Get("max-status"); param != "" {
+		switch strings.ToUpper(param) {
+		case "OK":
+			maxStatus = storage.StatusOK
+		case "WARN":
+			maxStatus = storage.StatusWarning
+		case "ERR":
+			maxStatus = storage.StatusError
+		default:
+			return makeErrorResponse(http.StatusBadRequest, "max-status must be one of OK, WARN, or ERR", w, r)
+		}
+	}
+	maxLag := int64(-1)
+	if param := r.URL.Query().Get("max-lag"); param != "" {
+		var err error
+		maxLag, err = strconv.ParseInt(param, 10, 64)
+		if (err != nil) || (maxLag < 0) {
+			return makeErrorResponse(http.StatusBadRequest, "bad max-lag", w, r)
+		}
+	}
+
+	result := fetchConsumerStatus(app, cluster, group, false, false)
+	if result == nil {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	if result.Status == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+
+	reasons := make([]string, 0)
+	if result.Status > maxStatus {
+		reasons = append(reasons, fmt.Sprintf("status %v is worse than %v", result.Status, maxStatus))
+	}
+	if (maxLag >= 0) && (result.TotalLag > uint64(maxLag)) {
+		reasons = append(reasons, fmt.Sprintf("total lag %v is more than %v", result.TotalLag, maxLag))
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	response := HTTPResponseConsumerGate{
+		Error:    false,
+		Message:  "consumer group passed",
+		Pass:     len(reasons) == 0,
+		Reasons:  reasons,
+		Status:   result.Status,
+		TotalLag: result.TotalLag,
+		Request:  requestInfo,
+	}
+	if !response.Pass {
+		response.Message = "consumer group failed"
+	}
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	if !response.Pass {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write(jsonStr)
+		return http.StatusPreconditionFailed, ""
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleConsumerDrop(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	storageRequest := &storage.RequestConsumerDrop{Result: make(chan storage.StatusConstant), Cluster: cluster, Group: group}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "consumer group removed",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
+
+// Undo the removal of a group, if it was within the tombstone retention
+func handleConsumerRestore(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	storageRequest := &storage.RequestConsumerRestore{Result: make(chan storage.StatusConstant), Cluster: cluster, Group: group}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group was not removed recently", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "consumer group restored",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
+
+// Mark a partition of a group as caught up with an artificial commit at the broker offset, to clear a STALL that is
+// known to be false
+func handleConsumerPartitionCaughtUp(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string, topic string, partitionID string) (int, string) {
+	partition, err := strconv.Atoi(partitionID)
+	if (err != nil) || (partition < 0) {
+		return makeErrorResponse(http.StatusBadRequest, "bad partition ID", w, r)
+	}
+	storageRequest := &storage.RequestMarkCaughtUp{Result: make(chan storage.StatusConstant), Cluster: cluster,
+		Group: group, Topic: topic, Partition: int32(partition)}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group partition not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "partition marked caught up",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	} else {
+		w.Write(jsonStr)
+		return 200, ""
+	}
+}
+
+func handleConsumerMetadata(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	storageRequest := &storage.RequestGroupMetadata{Result: make(chan map[string]string), Cluster: cluster, Group: group}
+	app.Storage.RequestChannel <- storageRequest
+	return writeGroupMetadata(w, r, cluster, group, <-storageRequest.Result, "consumer group metadata returned")
+}
+
+// Replace the metadata of a group with the key/values in the request body, such as {"owner":"payments"}. An empty
+// object removes it. The group doesn't need to exist yet, so metadata can be set before a group first commits
+func handleConsumerMetadataSet(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	metadata := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&metadata); (err != nil) && (err != io.EOF) {
+		return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+	}
+	if err := storage.ValidateGroupMetadata(metadata); err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "invalid metadata: "+err.Error(), w, r)
+	}
+
+	storageRequest := &storage.RequestGroupMetadataSet{Result: make(chan storage.StatusConstant), Cluster: cluster,
+		Group: group, Metadata: metadata}
+	app.Storage.RequestChannel <- storageRequest
+	if <-storageRequest.Result == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "cluster not found", w, r)
+	}
+	return writeGroupMetadata(w, r, cluster, group, metadata, "consumer group metadata set")
+}
+
+func writeGroupMetadata(w http.ResponseWriter, r *http.Request, cluster string, group string, metadata map[string]string, message string) (int, string) {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseGroupMetadata{
+		Error:    false,
+		Message:  message,
+		Metadata: metadata,
+		Request:  requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleBrokerTopicList(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	storageRequest := &storage.RequestTopicList{Result: make(chan *storage.ResponseTopicList), Cluster: cluster}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseTopicList{
+		Error:   false,
+		Message: "broker topic list returned",
+		Topics:  result.TopicList,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleBrokerTopicDetail(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, topic string) (int, string) {
+	storageRequest := &storage.RequestOffsets{Result: make(chan *storage.ResponseOffsets), Cluster: cluster, Topic: topic}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result.ErrorTopic {
+		return makeErrorResponse(http.StatusNotFound, "topic not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponseTopicDetail{
+		Error:         false,
+		Message:       "broker topic offsets returned",
+		Offsets:       result.OffsetList,
+		StableOffsets: result.StableOffsetList,
+		Request:       requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleBrokerTopicRate(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, topic string) (int, string) {
+	storageRequest := &storage.RequestTopicRate{Result: make(chan *storage.ResponseTopicRate), Cluster: cluster, Topic: topic}
+	app.Storage.RequestChannel <- storageRequest
+	result := <-storageRequest.Result
+	if result.ErrorTopic {
+		return makeErrorResponse(http.StatusNotFound, "topic not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponseTopicRate{
+		Error:     false,
+		Message:   "broker topic rate returned",
+		Rates:     result.Rates,
+		TotalRate: result.TotalRate,
+		Window:    result.Window,
+		Request:   requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleClusterDropped(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	storageRequest := &storage.RequestDroppedOffsets{Result: make(chan []*storage.DroppedOffset), Cluster: cluster}
+	app.Storage.RequestChannel <- storageRequest
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseDroppedOffsets{
+		Error:   false,
+		Message: "dropped offsets returned",
+		Dropped: <-storageRequest.Result,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleExpectedGroupList(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	storageRequest := &storage.RequestExpectedGroupList{Result: make(chan []*storage.ExpectedGroup), Cluster: cluster}
+	app.Storage.RequestChannel <- storageRequest
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseExpectedGroupList{
+		Error:    false,
+		Message:  "expected group list returned",
+		Expected: <-storageRequest.Result,
+		Request:  requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleExpectedGroupSet(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	if !validateTopic(group) {
+		return makeErrorResponse(http.StatusBadRequest, "invalid group name", w, r)
+	}
+
+	var body HTTPRequestExpectedGroup
+	if err := json.NewDecoder(r.Body).Decode(&body); (err != nil) && (err != io.EOF) {
+		return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+	}
+	for _, topic := range body.Topics {
+		if !validateTopic(topic) {
+			return makeErrorResponse(http.StatusBadRequest, "invalid topic name", w, r)
+		}
+	}
+	expected, err := storage.NewExpectedGroup(group, body.Topics, body.Schedule, body.Window, "api")
+	if err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "invalid schedule: "+err.Error(), w, r)
+	}
+
+	storageRequest := &storage.RequestExpectedGroupSet{
+		Result:   make(chan storage.StatusConstant),
+		Cluster:  cluster,
+		Expected: expected,
+	}
+	app.Storage.RequestChannel <- storageRequest
+	<-storageRequest.Result
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "expected group set",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleExpectedGroupDelete(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	storageRequest := &storage.RequestExpectedGroupDelete{Result: make(chan storage.StatusConstant), Cluster: cluster, Group: group}
+	app.Storage.RequestChannel <- storageRequest
+	if <-storageRequest.Result == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "expected group not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "expected group removed",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleIgnoredPartitionList(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string) (int, string) {
+	storageRequest := &storage.RequestIgnoredPartitionList{Result: make(chan []*storage.IgnoredPartition), Cluster: cluster}
+	app.Storage.RequestChannel <- storageRequest
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseIgnoredPartitionList{
+		Error:   false,
+		Message: "ignored partition list returned",
+		Ignored: <-storageRequest.Result,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleIgnoredPartitionSet(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, topic string, partitionID string) (int, string) {
+	if !validateTopic(topic) {
+		return makeErrorResponse(http.StatusBadRequest, "invalid topic name", w, r)
+	}
+	partition, err := strconv.ParseInt(partitionID, 10, 32)
+	if (err != nil) || (partition < 0) {
+		return makeErrorResponse(http.StatusBadRequest, "invalid partition ID", w, r)
+	}
+
+	var body HTTPRequestIgnoredPartition
+	if err := json.NewDecoder(r.Body).Decode(&body); (err != nil) && (err != io.EOF) {
+		return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+	}
+
+	storageRequest := &storage.RequestIgnoredPartitionSet{
+		Result:  make(chan storage.StatusConstant),
+		Cluster: cluster,
+		Ignored: &storage.IgnoredPartition{
+			Topic:     topic,
+			Partition: int32(partition),
+			Reason:    body.Reason,
+			Source:    "api",
+		},
+	}
+	app.Storage.RequestChannel <- storageRequest
+	<-storageRequest.Result
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "partition ignored",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func handleIgnoredPartitionDelete(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, topic string, partitionID string) (int, string) {
+	partition, err := strconv.ParseInt(partitionID, 10, 32)
+	if err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "invalid partition ID", w, r)
+	}
+
+	storageRequest := &storage.RequestIgnoredPartitionDelete{
+		Result:    make(chan storage.StatusConstant),
+		Cluster:   cluster,
+		Topic:     topic,
+		Partition: int32(partition),
+	}
+	app.Storage.RequestChannel <- storageRequest
+	if <-storageRequest.Result == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "ignored partition not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Topic = topic
+	jsonStr, err := json.Marshal(HTTPResponseError{
+		Error:   false,
+		Message: "partition no longer ignored",
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+// Stop accepting connections, and wait for the requests in progress to finish or the context to be done
+func (server *HttpServer) Stop(ctx context.Context) error {
+	return server.server.Shutdown(ctx)
+}
+
+func (server *HttpServer) Flush() error {
+	return nil
 }
@@ -0,0 +1,104 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+)
+
+// The settings that apply to a group, with the notifier templates whose routing rules match it and its expected group
+// declaration, if it has one
+type ResolvedGroupConfig struct {
+	*storage.GroupSettings
+	Cluster           string                 `json:"cluster"`
+	Group             string                 `json:"group"`
+	NotifierTemplates []string               `json:"notifier_templates"`
+	Expected          *storage.ExpectedGroup `json:"expected,omitempty"`
+}
+
+type HTTPResponseConfig struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Config  *BurrowConfig           `json:"config"`
+	Group   *ResolvedGroupConfig    `json:"group,omitempty"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/config, which returns the config the instance is running with, after defaults were applied,
+// with passwords, keys, and tokens redacted. It needs an admin token. With ?cluster=(cluster)&group=(group), the
+// settings that the config's group patterns resolve to for that group are returned as well
+func handleConfig(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if _, ok := app.AdminAudit.Authenticate(r); !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return makeErrorResponse(http.StatusUnauthorized, "an admin token is required", w, r)
+	}
+
+	response := HTTPResponseConfig{
+		Error:   false,
+		Message: "configuration returned",
+		Config:  redactedConfig(app.Config),
+		Request: makeRequestInfo(r),
+	}
+	cluster, group := r.URL.Query().Get("cluster"), r.URL.Query().Get("group")
+	if (cluster != "") || (group != "") {
+		if (cluster == "") || (group == "") {
+			return makeErrorResponse(http.StatusBadRequest, "a group needs both cluster and group", w, r)
+		}
+		if !app.AdminAudit.ClusterAllowed(r, cluster) {
+			return makeErrorResponse(http.StatusForbidden, "this token cannot read cluster "+cluster, w, r)
+		}
+		settings := app.Storage.GroupSettings(cluster, group)
+		if settings == nil {
+			return makeErrorResponse(http.StatusNotFound, "cluster not found", w, r)
+		}
+		response.Group = resolveGroupConfig(app, cluster, group, settings)
+		response.Request.Cluster = cluster
+		response.Request.Group = group
+	}
+
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+func resolveGroupConfig(app *ApplicationContext, cluster string, group string, settings *storage.GroupSettings) *ResolvedGroupConfig {
+	resolved := &ResolvedGroupConfig{
+		GroupSettings:     settings,
+		Cluster:           cluster,
+		Group:             group,
+		NotifierTemplates: make([]string, 0),
+	}
+	if app.HttpNotifier != nil {
+		status := &storage.ConsumerGroupStatus{Cluster: cluster, Group: group, Tags: settings.Tags}
+		for _, variant := range app.HttpNotifier.variants {
+			if variant.matches(status) {
+				resolved.NotifierTemplates = append(resolved.NotifierTemplates, variant.Name)
+			}
+		}
+	}
+
+	expectedRequest := &storage.RequestExpectedGroupList{Result: make(chan []*storage.ExpectedGroup), Cluster: cluster}
+	app.Storage.RequestChannel <- expectedRequest
+	for _, expected := range <-expectedRequest.Result {
+		if expected.Group == group {
+			resolved.Expected = expected
+		}
+	}
+	return resolved
+}
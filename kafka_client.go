@@ -11,12 +11,10 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/binary"
-	"errors"
 	"github.com/Shopify/sarama"
 	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -34,7 +32,34 @@ type KafkaClient struct {
 	wgProcessor        sync.WaitGroup
 	topicMap           map[string]int
 	topicMapLock       sync.RWMutex
+	offline            map[string]map[int32]bool
+	leaders            map[string]map[int32]int32
+	leaderChanged      map[string]map[int32]int64
 	brokerOffsetTicker *time.Ticker
+	fetchStable        bool
+	offsetChannel      chan *storage.PartitionOffset
+	consumerActivity   activityTimer
+	brokerActivity     activityTimer
+	checkpointGroups   *regexp.Regexp
+}
+
+func init() {
+	RegisterOffsetSource("kafka", &OffsetSourceModule{
+		Clusters: kafkaClusterNames,
+		New: func(app *ApplicationContext, cluster string) (OffsetSource, error) {
+			return NewKafkaClient(app, cluster)
+		},
+	})
+}
+
+func kafkaClusterNames(config *BurrowConfig) []string {
+	clusters := make([]string, 0, len(config.Kafka))
+	for cluster, cfg := range config.Kafka {
+		if cfg.Type != "test" {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
 }
 
 type BrokerTopicRequest struct {
@@ -42,14 +67,46 @@ type BrokerTopicRequest struct {
 	Topic  string
 }
 
-func NewKafkaClient(app *ApplicationContext, cluster string) (*KafkaClient, error) {
-	// Set up sarama config from profile
+// Set up the sarama config for a cluster from its client profile
+func newSaramaConfig(app *ApplicationContext, cluster string) *sarama.Config {
 	clientConfig := sarama.NewConfig()
 	profile := app.Config.Clientprofile[app.Config.Kafka[cluster].Clientprofile]
 	clientConfig.ClientID = profile.ClientID
 	clientConfig.Net.TLS.Enable = profile.TLS
-	clientConfig.Net.TLS.Config = &tls.Config{}
+	clientConfig.Net.TLS.Config = newTLSConfig()
 	clientConfig.Net.TLS.Config.InsecureSkipVerify = profile.TLSNoVerify
+	if profile.KafkaVersion != "" {
+		// The version was already checked when the config was validated
+		clientConfig.Version, _ = sarama.ParseKafkaVersion(profile.KafkaVersion)
+	}
+	if profile.DialTimeout > 0 {
+		clientConfig.Net.DialTimeout = time.Duration(profile.DialTimeout) * time.Second
+	}
+	if profile.ReadTimeout > 0 {
+		clientConfig.Net.ReadTimeout = time.Duration(profile.ReadTimeout) * time.Second
+	}
+	if profile.WriteTimeout > 0 {
+		clientConfig.Net.WriteTimeout = time.Duration(profile.WriteTimeout) * time.Second
+	}
+	if profile.Keepalive > 0 {
+		clientConfig.Net.KeepAlive = time.Duration(profile.Keepalive) * time.Second
+	}
+	if profile.MaxOpenRequests > 0 {
+		clientConfig.Net.MaxOpenRequests = profile.MaxOpenRequests
+	}
+	if tunnel, ok := app.Tunnels[cluster]; ok {
+		// Sarama only takes a custom dialer as a proxy
+		clientConfig.Net.Proxy.Enable = true
+		clientConfig.Net.Proxy.Dialer = &tunnelDialer{tunnel: tunnel, timeout: clientConfig.Net.DialTimeout}
+	} else if addressFamily != "dual" {
+		clientConfig.Net.Proxy.Enable = true
+		clientConfig.Net.Proxy.Dialer = newDialer(clientConfig.Net.DialTimeout, clientConfig.Net.KeepAlive)
+	}
+	return clientConfig
+}
+
+func NewKafkaClient(app *ApplicationContext, cluster string) (*KafkaClient, error) {
+	clientConfig := newSaramaConfig(app, cluster)
 
 	sclient, err := sarama.NewClient(app.Config.Kafka[cluster].Brokers, clientConfig)
 	if err != nil {
@@ -75,13 +132,31 @@ func NewKafkaClient(app *ApplicationContext, cluster string) (*KafkaClient, erro
 		wgProcessor:    sync.WaitGroup{},
 		topicMap:       make(map[string]int),
 		topicMapLock:   sync.RWMutex{},
+		leaders:        make(map[string]map[int32]int32),
+		leaderChanged:  make(map[string]map[int32]int64),
+
+		// Brokers before 0.11 don't have transactions, so there is no separate last stable offset to fetch
+		fetchStable: clientConfig.Version.IsAtLeast(sarama.V0_11_0_0),
+	}
+	if cfg, ok := app.Config.Checkpoint[cluster]; ok && (cfg.IgnoreCommits != "") {
+		// The regular expression was already checked when the config was validated
+		client.checkpointGroups = regexp.MustCompile(cfg.IgnoreCommits)
 	}
 
+	return client, nil
+}
+
+func (client *KafkaClient) Start(offsets chan *storage.PartitionOffset) error {
+	client.offsetChannel = offsets
+	client.consumerActivity.touch()
+	client.brokerActivity.touch()
+
 	// Start the main processor goroutines for __consumer_offset messages
 	client.wgProcessor.Add(2)
 	go func() {
 		defer client.wgProcessor.Done()
 		for msg := range client.messageChannel {
+			client.consumerActivity.touch()
 			go client.processConsumerOffsetsMessage(msg)
 		}
 	}()
@@ -112,7 +187,7 @@ func NewKafkaClient(app *ApplicationContext, cluster string) (*KafkaClient, erro
 	// Get a partition count for the consumption topic
 	partitions, err := client.client.Partitions(client.app.Config.Kafka[client.cluster].OffsetsTopic)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Start consumers for each partition with fan in
@@ -121,7 +196,7 @@ func NewKafkaClient(app *ApplicationContext, cluster string) (*KafkaClient, erro
 	for i, partition := range partitions {
 		pconsumer, err := client.masterConsumer.ConsumePartition(client.app.Config.Kafka[client.cluster].OffsetsTopic, partition, sarama.OffsetNewest)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		client.partitionConsumers[i] = pconsumer
 		client.wgFanIn.Add(2)
@@ -139,13 +214,16 @@ func NewKafkaClient(app *ApplicationContext, cluster string) (*KafkaClient, erro
 		}()
 	}
 
-	return client, nil
+	return nil
 }
 
 func (client *KafkaClient) Stop() {
 	// We don't really need to do a safe stop, because we're not maintaining offsets. But we'll do it anyways
+	// If the client failed to start, some of the partition consumers may not have been created
 	for _, pconsumer := range client.partitionConsumers {
-		pconsumer.AsyncClose()
+		if pconsumer != nil {
+			pconsumer.AsyncClose()
+		}
 	}
 
 	// Wait for the Messages and Errors channel to be fully drained.
@@ -155,12 +233,34 @@ func (client *KafkaClient) Stop() {
 	client.wgProcessor.Wait()
 
 	// Stop the offset checker and the topic metdata refresh and request channel
-	client.brokerOffsetTicker.Stop()
+	if client.brokerOffsetTicker != nil {
+		client.brokerOffsetTicker.Stop()
+	}
 	close(client.requestChannel)
+
+	client.masterConsumer.Close()
+	client.client.Close()
+}
+
+// The configured brokers, and the brokers from the cluster metadata (which are what the client is connected to)
+func (client *KafkaClient) Hosts() []string {
+	hosts := append([]string{}, client.app.Config.Kafka[client.cluster].Brokers...)
+	for _, broker := range client.client.Brokers() {
+		hosts = append(hosts, broker.Addr())
+	}
+	return hosts
+}
+
+// The offsets consumer and the broker offset fetcher are supervised separately, since either one can get stuck
+func (client *KafkaClient) Activity() map[string]time.Time {
+	return map[string]time.Time{
+		"consumer offsets": client.consumerActivity.time(),
+		"broker offsets":   client.brokerActivity.time(),
+	}
 }
 
 // Send the offset on the specified channel, but wait no more than maxTime seconds to do so
-func timeoutSendOffset(offsetChannel chan *PartitionOffset, offset *PartitionOffset, maxTime int) {
+func timeoutSendOffset(offsetChannel chan *storage.PartitionOffset, offset *storage.PartitionOffset, maxTime int) {
 	timeout := time.After(time.Duration(maxTime) * time.Second)
 	select {
 	case offsetChannel <- offset:
@@ -175,7 +275,10 @@ func (client *KafkaClient) getOffsets() error {
 	client.RefreshTopicMap()
 
 	requests := make(map[int32]*sarama.OffsetRequest)
+	oldestRequests := make(map[int32]*sarama.OffsetRequest)
+	stableRequests := make(map[int32]*sarama.OffsetRequest)
 	brokers := make(map[int32]*sarama.Broker)
+	offline := make(map[string]map[int32]bool)
 
 	client.topicMapLock.RLock()
 
@@ -183,6 +286,15 @@ func (client *KafkaClient) getOffsets() error {
 	for topic, partitions := range client.topicMap {
 		for i := 0; i < partitions; i++ {
 			broker, err := client.client.Leader(topic, int32(i))
+			if err == sarama.ErrLeaderNotAvailable {
+				// The partition is offline. Its consumers are evaluated as PARTITION_OFFLINE, and the rest of the
+				// offsets are still fetched
+				if _, ok := offline[topic]; !ok {
+					offline[topic] = make(map[int32]bool)
+				}
+				offline[topic][int32(i)] = true
+				continue
+			}
 			if err != nil {
 				client.topicMapLock.RUnlock()
 				log.Errorf("Topic leader error on %s:%v: %v", topic, int32(i), err)
@@ -190,17 +302,29 @@ func (client *KafkaClient) getOffsets() error {
 			}
 			if _, ok := requests[broker.ID()]; !ok {
 				requests[broker.ID()] = &sarama.OffsetRequest{}
+				oldestRequests[broker.ID()] = &sarama.OffsetRequest{}
+				if client.fetchStable {
+					// A read_committed request for the newest offset returns the last stable offset
+					stableRequests[broker.ID()] = &sarama.OffsetRequest{Version: 2, IsolationLevel: sarama.ReadCommitted}
+				}
 			}
 			brokers[broker.ID()] = broker
+			client.recordLeader(topic, int32(i), broker.ID())
 			requests[broker.ID()].AddBlock(topic, int32(i), sarama.OffsetNewest, 1)
+			oldestRequests[broker.ID()].AddBlock(topic, int32(i), sarama.OffsetOldest, 1)
+			if client.fetchStable {
+				stableRequests[broker.ID()].AddBlock(topic, int32(i), sarama.OffsetNewest, 1)
+			}
 		}
 	}
 
+	client.setOfflinePartitions(offline)
+
 	// Send out the OffsetRequest to each broker for all the partitions it is leader for
 	// The results go to the offset storage module
 	var wg sync.WaitGroup
 
-	getBrokerOffsets := func(brokerID int32, request *sarama.OffsetRequest) {
+	getBrokerOffsets := func(brokerID int32, request *sarama.OffsetRequest, oldestRequest *sarama.OffsetRequest, stableRequest *sarama.OffsetRequest) {
 		defer wg.Done()
 		response, err := brokers[brokerID].GetAvailableOffsets(request)
 		if err != nil {
@@ -208,29 +332,51 @@ func (client *KafkaClient) getOffsets() error {
 			_ = brokers[brokerID].Close()
 			return
 		}
+		client.brokerActivity.touch()
 		ts := time.Now().Unix() * 1000
+
+		// The oldest offsets are only used for retention checks, so a failure here is not fatal
+		oldestResponse, err := brokers[brokerID].GetAvailableOffsets(oldestRequest)
+		if err != nil {
+			log.Warnf("Cannot fetch oldest offsets from broker %v: %v", brokerID, err)
+			oldestResponse = nil
+		}
+
+		// Same for the last stable offsets, which are only used for groups that consume with read_committed
+		var stableResponse *sarama.OffsetResponse
+		if stableRequest != nil {
+			stableResponse, err = brokers[brokerID].GetAvailableOffsets(stableRequest)
+			if err != nil {
+				log.Warnf("Cannot fetch last stable offsets from broker %v: %v", brokerID, err)
+				stableResponse = nil
+			}
+		}
+
 		for topic, partitions := range response.Blocks {
 			for partition, offsetResponse := range partitions {
 				if offsetResponse.Err != sarama.ErrNoError {
 					log.Warnf("Error in OffsetResponse for %s:%v from broker %v: %s", topic, partition, brokerID, offsetResponse.Err.Error())
 					continue
 				}
-				offset := &PartitionOffset{
+				offset := &storage.PartitionOffset{
 					Cluster:             client.cluster,
 					Topic:               topic,
 					Partition:           partition,
 					Offset:              offsetResponse.Offsets[0],
+					OldestOffset:        getResponseOffset(oldestResponse, topic, partition),
+					StableOffset:        getResponseOffset(stableResponse, topic, partition),
 					Timestamp:           ts,
 					TopicPartitionCount: client.topicMap[topic],
+					LeaderChanged:       client.leaderChanged[topic][partition],
 				}
-				timeoutSendOffset(client.app.Storage.offsetChannel, offset, 1)
+				timeoutSendOffset(client.offsetChannel, offset, 1)
 			}
 		}
 	}
 
 	for brokerID, request := range requests {
 		wg.Add(1)
-		go getBrokerOffsets(brokerID, request)
+		go getBrokerOffsets(brokerID, request, oldestRequests[brokerID], stableRequests[brokerID])
 	}
 
 	wg.Wait()
@@ -238,11 +384,58 @@ func (client *KafkaClient) getOffsets() error {
 	return nil
 }
 
+// Remember the leader of a partition, and when it last changed. The first leader that is seen is not a change
+func (client *KafkaClient) recordLeader(topic string, partition int32, leader int32) {
+	if _, ok := client.leaders[topic]; !ok {
+		client.leaders[topic] = make(map[int32]int32)
+		client.leaderChanged[topic] = make(map[int32]int64)
+	}
+	previous, ok := client.leaders[topic][partition]
+	client.leaders[topic][partition] = leader
+	if ok && (previous != leader) {
+		log.Infof("Partition leader changed: cluster=%s topic=%s partition=%v from=%v to=%v", client.cluster, topic,
+			partition, previous, leader)
+		client.leaderChanged[topic][partition] = time.Now().Unix() * 1000
+	}
+}
+
+// Store the partitions that have no leader, logging the ones that went offline or came back since the last fetch
+func (client *KafkaClient) setOfflinePartitions(offline map[string]map[int32]bool) {
+	for topic, partitions := range offline {
+		for partition := range partitions {
+			if !client.offline[topic][partition] {
+				log.Warnf("Partition has no leader: cluster=%s topic=%s partition=%v", client.cluster, topic, partition)
+			}
+		}
+	}
+	for topic, partitions := range client.offline {
+		for partition := range partitions {
+			if !offline[topic][partition] {
+				log.Infof("Partition has a leader again: cluster=%s topic=%s partition=%v", client.cluster, topic, partition)
+			}
+		}
+	}
+	client.offline = offline
+	client.app.Storage.SetOfflinePartitions(client.cluster, offline)
+}
+
+// Pull a single partition's offset out of an OffsetResponse, returning -1 if it is not available
+func getResponseOffset(response *sarama.OffsetResponse, topic string, partition int32) int64 {
+	if response == nil {
+		return -1
+	}
+	block := response.GetBlock(topic, partition)
+	if (block == nil) || (block.Err != sarama.ErrNoError) || (len(block.Offsets) == 0) {
+		return -1
+	}
+	return block.Offsets[0]
+}
+
 func (client *KafkaClient) RefreshTopicMap() {
 	client.topicMapLock.Lock()
 	topics, _ := client.client.Topics()
 	for _, topic := range topics {
-		if (client.app.Storage.topicBlacklist != nil) && client.app.Storage.topicBlacklist.MatchString(topic) {
+		if (client.app.Storage.TopicBlacklist != nil) && client.app.Storage.TopicBlacklist.MatchString(topic) {
 			continue
 		}
 		partitions, _ := client.client.Partitions(topic)
@@ -261,84 +454,50 @@ func (client *KafkaClient) getPartitionCount(r *BrokerTopicRequest) {
 	client.topicMapLock.RUnlock()
 }
 
-func readString(buf *bytes.Buffer) (string, error) {
-	var strlen uint16
-	err := binary.Read(buf, binary.BigEndian, &strlen)
+// Keep the members of groups from their metadata, so the client that owns each partition is in its status
+func (client *KafkaClient) processGroupMetadataMessage(msg *sarama.ConsumerMessage) {
+	membership, err := decodeGroupMetadataMessage(msg.Key, msg.Value)
 	if err != nil {
-		return "", err
+		client.app.Quarantine.Add(client.cluster, msg.Partition, msg.Offset, msg.Key, msg.Value, err)
+		return
+	}
+	if membership == nil {
+		return
 	}
-	strbytes := make([]byte, strlen)
-	n, err := buf.Read(strbytes)
-	if (err != nil) || (n != int(strlen)) {
-		return "", errors.New("string underflow")
+	request := &storage.RequestGroupMembersSet{Cluster: client.cluster, Group: membership.group, Members: membership.members}
+	if !client.app.Storage.SendRequest(request, time.Second) {
+		log.Warnf("Cannot set the members of group %s in cluster %s: %s", membership.group, client.cluster, storageBusyReason)
 	}
-	return string(strbytes), nil
 }
 
 func (client *KafkaClient) processConsumerOffsetsMessage(msg *sarama.ConsumerMessage) {
-	var keyver, valver uint16
-	var group, topic string
-	var partition uint32
-	var offset, timestamp uint64
-
-	buf := bytes.NewBuffer(msg.Key)
-	err := binary.Read(buf, binary.BigEndian, &keyver)
-	switch keyver {
-	case 0, 1:
-		group, err = readString(buf)
-		if err != nil {
-			log.Warnf("Failed to decode %s:%v offset %v: group", msg.Topic, msg.Partition, msg.Offset)
-			return
-		}
-		topic, err = readString(buf)
-		if err != nil {
-			log.Warnf("Failed to decode %s:%v offset %v: topic", msg.Topic, msg.Partition, msg.Offset)
-			return
-		}
-		err = binary.Read(buf, binary.BigEndian, &partition)
-		if err != nil {
-			log.Warnf("Failed to decode %s:%v offset %v: partition", msg.Topic, msg.Partition, msg.Offset)
-			return
-		}
-	case 2:
-		log.Debugf("Discarding group metadata message with key version 2")
-		return
-	default:
-		log.Warnf("Failed to decode %s:%v offset %v: keyver %v", msg.Topic, msg.Partition, msg.Offset, keyver)
-		return
-	}
-
-	buf = bytes.NewBuffer(msg.Value)
-	err = binary.Read(buf, binary.BigEndian, &valver)
-	if (err != nil) || ((valver != 0) && (valver != 1)) {
-		log.Warnf("Failed to decode %s:%v offset %v: valver %v", msg.Topic, msg.Partition, msg.Offset, valver)
-		return
-	}
-	err = binary.Read(buf, binary.BigEndian, &offset)
+	commit, err := decodeOffsetsMessage(msg.Key, msg.Value)
 	if err != nil {
-		log.Warnf("Failed to decode %s:%v offset %v: offset", msg.Topic, msg.Partition, msg.Offset)
+		client.app.Quarantine.Add(client.cluster, msg.Partition, msg.Offset, msg.Key, msg.Value, err)
 		return
 	}
-	_, err = readString(buf)
-	if err != nil {
-		log.Warnf("Failed to decode %s:%v offset %v: metadata", msg.Topic, msg.Partition, msg.Offset)
+	if commit == nil {
+		// Group metadata, or a tombstone for a deleted offset
+		client.processGroupMetadataMessage(msg)
 		return
 	}
-	err = binary.Read(buf, binary.BigEndian, &timestamp)
-	if err != nil {
-		log.Warnf("Failed to decode %s:%v offset %v: timestamp", msg.Topic, msg.Partition, msg.Offset)
+	group := commit.group
+
+	// The progress of groups with checkpoints comes from the checkpoint source, and their own commits lag behind it
+	if (client.checkpointGroups != nil) && client.checkpointGroups.MatchString(group) {
 		return
 	}
 
 	// fmt.Printf("[%s,%s,%v]::OffsetAndMetadata[%v,%s,%v]\n", group, topic, partition, offset, metadata, timestamp)
-	partitionOffset := &PartitionOffset{
+	partitionOffset := &storage.PartitionOffset{
 		Cluster:   client.cluster,
-		Topic:     topic,
-		Partition: int32(partition),
+		Topic:     commit.topic,
+		Partition: commit.partition,
 		Group:     group,
-		Timestamp: int64(timestamp),
-		Offset:    int64(offset),
+		Timestamp: commit.timestamp,
+		Offset:    commit.offset,
+		Source:    storage.OffsetSourceKafka,
 	}
-	timeoutSendOffset(client.app.Storage.offsetChannel, partitionOffset, 1)
+	timeoutSendOffset(client.offsetChannel, partitionOffset, 1)
 	return
 }
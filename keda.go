@@ -0,0 +1,526 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often StreamIsActive sends the group's state to KEDA
+const kedaStreamInterval = 15 * time.Second
+
+// The largest request message that is accepted. KEDA's requests are a few hundred bytes
+const kedaMaxMessage = 1 << 20
+
+// gRPC status codes
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcNotFound        = 5
+	grpcUnimplemented   = 12
+	grpcUnavailable     = 14
+)
+
+// The KEDA scaler serves KEDA's external scaler gRPC service (externalscaler.ExternalScaler), so that a ScaledObject
+// with an external trigger scales a consumer on the lag Burrow evaluates, rather than the point-in-time lag KEDA's own
+// Kafka scaler reads. The trigger metadata names the group:
+//   - cluster: the Burrow cluster name (required)
+//   - group: the consumer group (required)
+//   - lagThreshold: the lag each replica should handle, as KEDA's Kafka scaler has it (10 by default)
+//   - activationLagThreshold: the lag above which the group is active, and scaled up from zero (0 by default)
+//   - activateUnknownGroup: whether a group Burrow doesn't know is active (false by default)
+//
+// The group is also active when its status is WARN, ERR, STOP, or STALL, and groups that have expired or aren't known
+// are handled as groupState describes. gRPC is served over TLS if there is a certificate, in which case the
+// ScaledObject needs its caCert (or tlsClientCert for mutual TLS), or as plaintext HTTP/2 (h2c) if not. Only the
+// framing and the few messages the service uses are implemented here, rather than pulling in the gRPC and protobuf
+// libraries. Requests can be compressed with gzip, but responses are not compressed
+type KedaScaler struct {
+	app    *ApplicationContext
+	server *http.Server
+}
+
+func NewKedaScaler(app *ApplicationContext) (*KedaScaler, error) {
+	cfg := app.Config.Keda
+	scaler := &KedaScaler{app: app}
+	if cfg.CertFile == "" {
+		scaler.server = &http.Server{Handler: h2c.NewHandler(scaler, &http2.Server{})}
+	} else {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig := newTLSConfig()
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		tlsConfig.NextProtos = []string{"h2"}
+		scaler.server = &http.Server{Handler: scaler, TLSConfig: tlsConfig}
+	}
+
+	address := net.JoinHostPort(trimBrackets(cfg.Address), strconv.Itoa(cfg.Port))
+	listener, err := net.Listen(listenNetwork(), address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CertFile == "" {
+		go scaler.server.Serve(listener)
+	} else {
+		go scaler.server.ServeTLS(listener, "", "")
+	}
+	return scaler, nil
+}
+
+func (scaler *KedaScaler) Stop() {
+	scaler.server.Close()
+}
+
+func (scaler *KedaScaler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (r.ProtoMajor != 2) || (r.Method != "POST") || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "this port only serves the KEDA external scaler gRPC service", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	message, err := readGrpcMessage(r.Body, r.Header.Get("Grpc-Encoding"))
+	if err != nil {
+		writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+		return
+	}
+	switch r.URL.Path {
+	case "/externalscaler.ExternalScaler/IsActive":
+		ref, err := decodeScaledObjectRef(message)
+		if err != nil {
+			writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+			return
+		}
+		active, code, reason := scaler.isActive(ref)
+		writeGrpcResponse(w, encodeIsActiveResponse(active), code, reason)
+	case "/externalscaler.ExternalScaler/StreamIsActive":
+		ref, err := decodeScaledObjectRef(message)
+		if err != nil {
+			writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+			return
+		}
+		scaler.streamIsActive(w, r, ref)
+	case "/externalscaler.ExternalScaler/GetMetricSpec":
+		ref, err := decodeScaledObjectRef(message)
+		if err != nil {
+			writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+			return
+		}
+		trigger, err := parseKedaTrigger(ref)
+		if err != nil {
+			writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+			return
+		}
+		writeGrpcResponse(w, encodeGetMetricSpecResponse(trigger.metricName(), trigger.lagThreshold), grpcOK, "")
+	case "/externalscaler.ExternalScaler/GetMetrics":
+		ref, metricName, err := decodeGetMetricsRequest(message)
+		if err != nil {
+			writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+			return
+		}
+		trigger, err := parseKedaTrigger(ref)
+		if err != nil {
+			writeGrpcResponse(w, nil, grpcInvalidArgument, err.Error())
+			return
+		}
+		if metricName == "" {
+			metricName = trigger.metricName()
+		}
+		lag, _, code, reason := scaler.groupState(trigger)
+		if code != grpcOK {
+			writeGrpcResponse(w, nil, code, reason)
+			return
+		}
+		writeGrpcResponse(w, encodeGetMetricsResponse(metricName, lag), grpcOK, "")
+	default:
+		writeGrpcResponse(w, nil, grpcUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+// The trigger metadata of a ScaledObject
+type kedaTrigger struct {
+	cluster                string
+	group                  string
+	lagThreshold           int64
+	activationLagThreshold int64
+	activateUnknownGroup   bool
+}
+
+// The name of the metric, which KEDA prefixes with the trigger index
+func (trigger *kedaTrigger) metricName() string {
+	return "burrow-lag-" + trigger.cluster + "-" + trigger.group
+}
+
+// A ScaledObjectRef, with only the trigger metadata (the name and namespace aren't needed)
+type scaledObjectRef struct {
+	metadata map[string]string
+}
+
+func parseKedaTrigger(ref *scaledObjectRef) (*kedaTrigger, error) {
+	trigger := &kedaTrigger{
+		cluster:      ref.metadata["cluster"],
+		group:        ref.metadata["group"],
+		lagThreshold: 10,
+	}
+	if (trigger.cluster == "") || (trigger.group == "") {
+		return nil, errors.New("the trigger metadata must have a cluster and a group")
+	}
+	if value, ok := ref.metadata["lagThreshold"]; ok {
+		threshold, err := strconv.ParseInt(value, 10, 64)
+		if (err != nil) || (threshold < 1) {
+			return nil, errors.New("lagThreshold must be a positive number")
+		}
+		trigger.lagThreshold = threshold
+	}
+	if value, ok := ref.metadata["activationLagThreshold"]; ok {
+		threshold, err := strconv.ParseInt(value, 10, 64)
+		if (err != nil) || (threshold < 0) {
+			return nil, errors.New("activationLagThreshold must be a number that is not negative")
+		}
+		trigger.activationLagThreshold = threshold
+	}
+	if value, ok := ref.metadata["activateUnknownGroup"]; ok {
+		activate, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("activateUnknownGroup must be true or false")
+		}
+		trigger.activateUnknownGroup = activate
+	}
+	return trigger, nil
+}
+
+// Return the lag of the trigger's group and whether it is active, or the gRPC status code and message if the cluster
+// is not known or storage is busy. A group is active if its lag is above the activation threshold, or if Burrow sees
+// it falling behind or stopped.
+//
+// A consumer that is scaled to zero stops committing, so its group expires. An expired group's lag is taken from its
+// last archived commits, so that it is scaled up from zero once the lag is above the activation threshold. A group
+// that isn't in the archive either (such as after a restart, or if the group name is wrong) is logged, and reported as
+// inactive with no lag. With activateUnknownGroup, it is reported as active with one replica's worth of lag instead, so
+// that KEDA starts a consumer and Burrow has commits to evaluate again
+func (scaler *KedaScaler) groupState(trigger *kedaTrigger) (int64, bool, int, string) {
+	if _, ok := scaler.app.Config.Kafka[trigger.cluster]; !ok {
+		return 0, false, grpcNotFound, "cluster " + trigger.cluster + " not found"
+	}
+	status := fetchConsumerStatus(scaler.app, trigger.cluster, trigger.group, false, false)
+	if status == nil {
+		return 0, false, grpcUnavailable, storageBusyReason
+	}
+	if status.Status != storage.StatusNotFound {
+		lag := clampLag(status.TotalLag)
+		active := (lag > trigger.activationLagThreshold) ||
+			((status.Status >= storage.StatusWarning) && (status.Status <= storage.StatusStall))
+		return lag, active, grpcOK, ""
+	}
+
+	if totalLag, ok := scaler.app.Storage.ArchivedGroupLag(trigger.cluster, trigger.group); ok {
+		lag := clampLag(totalLag)
+		return lag, lag > trigger.activationLagThreshold, grpcOK, ""
+	}
+	if trigger.activateUnknownGroup {
+		log.Warnf("KEDA trigger for unknown group %s in cluster %s, reporting it as active", trigger.group, trigger.cluster)
+		return trigger.lagThreshold, true, grpcOK, ""
+	}
+	log.Warnf("KEDA trigger for unknown group %s in cluster %s, reporting it as inactive", trigger.group, trigger.cluster)
+	return 0, false, grpcOK, ""
+}
+
+func (scaler *KedaScaler) isActive(ref *scaledObjectRef) (bool, int, string) {
+	trigger, err := parseKedaTrigger(ref)
+	if err != nil {
+		return false, grpcInvalidArgument, err.Error()
+	}
+	_, active, code, reason := scaler.groupState(trigger)
+	return active, code, reason
+}
+
+// Send whether the group is active every kedaStreamInterval, until KEDA closes the stream or the scaler is stopped
+func (scaler *KedaScaler) streamIsActive(w http.ResponseWriter, r *http.Request, ref *scaledObjectRef) {
+	startGrpcResponse(w)
+	flusher, _ := w.(http.Flusher)
+	ticker := time.NewTicker(kedaStreamInterval)
+	defer ticker.Stop()
+	for {
+		active, code, reason := scaler.isActive(ref)
+		if code != grpcOK {
+			finishGrpcResponse(w, code, reason)
+			return
+		}
+		if _, err := w.Write(grpcFrame(encodeIsActiveResponse(active))); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Lag is an int64 in KEDA's messages
+func clampLag(lag uint64) int64 {
+	if lag > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(lag)
+}
+
+// Read the one message of a unary request. Messages are framed with a compression flag and a 4 byte length. A
+// compressed message is decompressed with the encoding from the grpc-encoding header, which must be gzip
+func readGrpcMessage(body io.Reader, encoding string) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return nil, errors.New("cannot read the request message")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > kedaMaxMessage {
+		return nil, errors.New("the request message is too large")
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, errors.New("cannot read the request message")
+	}
+	if header[0] == 0 {
+		return message, nil
+	}
+	if encoding != "gzip" {
+		return nil, errors.New("compressed messages must use gzip")
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(message))
+	if err != nil {
+		return nil, errors.New("cannot decompress the request message")
+	}
+	message, err = ioutil.ReadAll(io.LimitReader(reader, kedaMaxMessage+1))
+	if (err != nil) || (len(message) > kedaMaxMessage) {
+		return nil, errors.New("cannot decompress the request message")
+	}
+	return message, nil
+}
+
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+func startGrpcResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "gzip")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+}
+
+func finishGrpcResponse(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(message))
+	}
+}
+
+// Write a unary response. The message is left out if the call failed
+func writeGrpcResponse(w http.ResponseWriter, message []byte, code int, reason string) {
+	startGrpcResponse(w)
+	if code == grpcOK {
+		w.Write(grpcFrame(message))
+	}
+	finishGrpcResponse(w, code, reason)
+}
+
+// The grpc-message trailer is percent-encoded
+func grpcEscape(message string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(message); i++ {
+		if (message[i] < 0x20) || (message[i] > 0x7e) || (message[i] == '%') {
+			fmt.Fprintf(&escaped, "%%%02X", message[i])
+		} else {
+			escaped.WriteByte(message[i])
+		}
+	}
+	return escaped.String()
+}
+
+// Protocol buffer wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// A field of a protocol buffer message. Only varints and length-delimited fields keep their value, as the messages
+// that are decoded have no others
+type protoField struct {
+	number   uint64
+	wireType uint64
+	varint   uint64
+	bytes    []byte
+}
+
+// Split a message into its fields
+func decodeProto(message []byte) ([]protoField, error) {
+	fields := make([]protoField, 0)
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, errors.New("malformed message")
+		}
+		message = message[n:]
+		field := protoField{number: key >> 3, wireType: key & 7}
+		switch field.wireType {
+		case protoVarint:
+			if field.varint, n = binary.Uvarint(message); n <= 0 {
+				return nil, errors.New("malformed message")
+			}
+			message = message[n:]
+		case protoFixed64:
+			if len(message) < 8 {
+				return nil, errors.New("malformed message")
+			}
+			message = message[8:]
+		case protoBytes:
+			length, n := binary.Uvarint(message)
+			if (n <= 0) || (length > uint64(len(message)-n)) {
+				return nil, errors.New("malformed message")
+			}
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		case protoFixed32:
+			if len(message) < 4 {
+				return nil, errors.New("malformed message")
+			}
+			message = message[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %v", field.wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// ScaledObjectRef is name (1), namespace (2), and scalerMetadata (3), a map of strings
+func decodeScaledObjectRef(message []byte) (*scaledObjectRef, error) {
+	fields, err := decodeProto(message)
+	if err != nil {
+		return nil, err
+	}
+	ref := &scaledObjectRef{metadata: make(map[string]string)}
+	for _, field := range fields {
+		if (field.wireType != protoBytes) || (field.number != 3) {
+			continue
+		}
+		// Each map entry is a message with the key (1) and the value (2)
+		entry, err := decodeProto(field.bytes)
+		if err != nil {
+			return nil, err
+		}
+		var key, value string
+		for _, entryField := range entry {
+			if (entryField.wireType == protoBytes) && (entryField.number == 1) {
+				key = string(entryField.bytes)
+			} else if (entryField.wireType == protoBytes) && (entryField.number == 2) {
+				value = string(entryField.bytes)
+			}
+		}
+		ref.metadata[key] = value
+	}
+	return ref, nil
+}
+
+// GetMetricsRequest is scaledObjectRef (1) and metricName (2)
+func decodeGetMetricsRequest(message []byte) (*scaledObjectRef, string, error) {
+	fields, err := decodeProto(message)
+	if err != nil {
+		return nil, "", err
+	}
+	ref := &scaledObjectRef{metadata: make(map[string]string)}
+	metricName := ""
+	for _, field := range fields {
+		if (field.wireType == protoBytes) && (field.number == 1) {
+			if ref, err = decodeScaledObjectRef(field.bytes); err != nil {
+				return nil, "", err
+			}
+		} else if (field.wireType == protoBytes) && (field.number == 2) {
+			metricName = string(field.bytes)
+		}
+	}
+	return ref, metricName, nil
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	encoded := make([]byte, binary.MaxVarintLen64)
+	return append(buf, encoded[:binary.PutUvarint(encoded, value)]...)
+}
+
+func appendProtoKey(buf []byte, number uint64, wireType uint64) []byte {
+	return appendUvarint(buf, number<<3|wireType)
+}
+
+func appendProtoVarint(buf []byte, number uint64, value uint64) []byte {
+	return appendUvarint(appendProtoKey(buf, number, protoVarint), value)
+}
+
+func appendProtoDouble(buf []byte, number uint64, value float64) []byte {
+	encoded := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encoded, math.Float64bits(value))
+	return append(appendProtoKey(buf, number, protoFixed64), encoded...)
+}
+
+func appendProtoBytes(buf []byte, number uint64, value []byte) []byte {
+	buf = appendUvarint(appendProtoKey(buf, number, protoBytes), uint64(len(value)))
+	return append(buf, value...)
+}
+
+// IsActiveResponse is result (1)
+func encodeIsActiveResponse(active bool) []byte {
+	if !active {
+		// Fields with the default value are left out
+		return []byte{}
+	}
+	return appendProtoVarint(nil, 1, 1)
+}
+
+// GetMetricSpecResponse is metricSpecs (1), each with metricName (1), targetSize (2), and targetSizeFloat (3)
+func encodeGetMetricSpecResponse(metricName string, targetSize int64) []byte {
+	spec := appendProtoBytes(nil, 1, []byte(metricName))
+	spec = appendProtoVarint(spec, 2, uint64(targetSize))
+	spec = appendProtoDouble(spec, 3, float64(targetSize))
+	return appendProtoBytes(nil, 1, spec)
+}
+
+// GetMetricsResponse is metricValues (1), each with metricName (1), metricValue (2), and metricValueFloat (3)
+func encodeGetMetricsResponse(metricName string, value int64) []byte {
+	metric := appendProtoBytes(nil, 1, []byte(metricName))
+	if value != 0 {
+		metric = appendProtoVarint(metric, 2, uint64(value))
+		metric = appendProtoDouble(metric, 3, float64(value))
+	}
+	return appendProtoBytes(nil, 1, metric)
+}
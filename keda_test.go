@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func testScaledObjectRef(metadata map[string]string) []byte {
+	ref := appendProtoBytes(nil, 1, []byte("consumer"))
+	ref = appendProtoBytes(ref, 2, []byte("default"))
+	for key, value := range metadata {
+		entry := appendProtoBytes(nil, 1, []byte(key))
+		entry = appendProtoBytes(entry, 2, []byte(value))
+		ref = appendProtoBytes(ref, 3, entry)
+	}
+	return ref
+}
+
+func Test_decodeGetMetricsRequest(t *testing.T) {
+	request := appendProtoBytes(nil, 1, testScaledObjectRef(map[string]string{"cluster": "local", "group": "orders", "lagThreshold": "50"}))
+	request = appendProtoBytes(request, 2, []byte("s0-burrow-lag-local-orders"))
+
+	ref, metricName, err := decodeGetMetricsRequest(request)
+	if err != nil {
+		t.Fatalf("Cannot decode request: %v", err)
+	}
+	if metricName != "s0-burrow-lag-local-orders" {
+		t.Errorf("Expected the metric name from the request, not %q", metricName)
+	}
+	trigger, err := parseKedaTrigger(ref)
+	if err != nil {
+		t.Fatalf("Cannot parse trigger: %v", err)
+	}
+	if (trigger.cluster != "local") || (trigger.group != "orders") || (trigger.lagThreshold != 50) || (trigger.activationLagThreshold != 0) {
+		t.Errorf("Unexpected trigger %+v", trigger)
+	}
+
+	if _, _, err := decodeGetMetricsRequest([]byte{0x0a, 0x10, 0x01}); err == nil {
+		t.Errorf("Expected an error for a truncated message")
+	}
+	if _, err := parseKedaTrigger(&scaledObjectRef{metadata: map[string]string{"cluster": "local"}}); err == nil {
+		t.Errorf("Expected an error without a group")
+	}
+}
+
+func Test_encodeGetMetricsResponse(t *testing.T) {
+	fields, err := decodeProto(encodeGetMetricsResponse("lag", 300))
+	if (err != nil) || (len(fields) != 1) || (fields[0].number != 1) {
+		t.Fatalf("Unexpected response fields %+v (%v)", fields, err)
+	}
+	metric, err := decodeProto(fields[0].bytes)
+	if (err != nil) || (len(metric) != 3) {
+		t.Fatalf("Unexpected metric fields %+v (%v)", metric, err)
+	}
+	if (string(metric[0].bytes) != "lag") || (metric[1].varint != 300) || (metric[2].wireType != protoFixed64) {
+		t.Errorf("Unexpected metric fields %+v", metric)
+	}
+}
+
+func Test_kedaScalerGetMetricSpec(t *testing.T) {
+	server := httptest.NewUnstartedServer(&KedaScaler{})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	body := grpcFrame(testScaledObjectRef(map[string]string{"cluster": "local", "group": "orders"}))
+	req, _ := http.NewRequest("POST", server.URL+"/externalscaler.ExternalScaler/GetMetricSpec", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	message, err := readGrpcMessage(resp.Body, "")
+	if err != nil {
+		t.Fatalf("Cannot read response: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Expected grpc-status 0, not %q (%s)", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"))
+	}
+	if !bytes.Equal(message, encodeGetMetricSpecResponse("burrow-lag-local-orders", 10)) {
+		t.Errorf("Unexpected metric spec %x", message)
+	}
+
+	req, _ = http.NewRequest("POST", server.URL+"/externalscaler.ExternalScaler/Unknown", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err = server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Trailer.Get("Grpc-Status") != "12" {
+		t.Errorf("Expected grpc-status 12 for an unknown method, not %q", resp.Trailer.Get("Grpc-Status"))
+	}
+}
+
+// A consumer that is scaled to zero stops committing and its group expires, but it is still scaled on the lag from its
+// last archived commits. A group Burrow doesn't know is inactive, unless the trigger asks for it to be active so that a
+// consumer is started
+func Test_kedaScalerExpiredGroup(t *testing.T) {
+	harness := newTestHarness(t, `
+[lagcheck]
+expire-group=5
+`)
+	broker := harness.broker
+	broker.createTopic("orders", 1)
+	broker.produce("orders", 0, 500)
+	for _, ago := range []time.Duration{30 * time.Second, 20 * time.Second, 10 * time.Second} {
+		broker.commit("scaled-down", "orders", 0, 200, time.Now().Add(-ago))
+	}
+	scaler := &KedaScaler{app: harness.app}
+
+	tests := []struct {
+		trigger *kedaTrigger
+		lag     int64
+		active  bool
+		code    int
+	}{
+		{&kedaTrigger{cluster: "local", group: "scaled-down", lagThreshold: 10, activationLagThreshold: 100}, 300, true, grpcOK},
+		{&kedaTrigger{cluster: "local", group: "scaled-down", lagThreshold: 10, activationLagThreshold: 1000}, 300, false, grpcOK},
+		{&kedaTrigger{cluster: "local", group: "unknown", lagThreshold: 10}, 0, false, grpcOK},
+		{&kedaTrigger{cluster: "local", group: "unknown", lagThreshold: 10, activateUnknownGroup: true}, 10, true, grpcOK},
+		{&kedaTrigger{cluster: "unknown", group: "scaled-down", lagThreshold: 10}, 0, false, grpcNotFound},
+	}
+	for i, test := range tests {
+		lag, active, code, reason := scaler.groupState(test.trigger)
+		if (lag != test.lag) || (active != test.active) || (code != test.code) {
+			t.Errorf("Test %v: expected lag %v, active %v, and code %v, got %v, %v, and %v (%s)", i, test.lag,
+				test.active, test.code, lag, active, code, reason)
+		}
+	}
+}
+
+func Test_readGrpcMessageCompressed(t *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("message"))
+	writer.Close()
+	frame := grpcFrame(compressed.Bytes())
+	frame[0] = 1
+
+	if message, err := readGrpcMessage(bytes.NewReader(frame), "gzip"); (err != nil) || (string(message) != "message") {
+		t.Errorf("Expected the message to be decompressed, got %q (%v)", message, err)
+	}
+	if _, err := readGrpcMessage(bytes.NewReader(frame), "snappy"); err == nil {
+		t.Errorf("Expected an error for a compression other than gzip")
+	}
+}
+
+// Without a certificate, the service is served as plaintext HTTP/2
+func Test_kedaScalerH2C(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	app := &ApplicationContext{Config: &BurrowConfig{}}
+	app.Config.Keda.Address = "127.0.0.1"
+	app.Config.Keda.Port = port
+	scaler, err := NewKedaScaler(app)
+	if err != nil {
+		t.Fatalf("Cannot start the scaler: %v", err)
+	}
+	defer scaler.Stop()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network string, address string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, address)
+		},
+	}}
+	body := grpcFrame(testScaledObjectRef(map[string]string{"cluster": "local", "group": "orders"}))
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:"+strconv.Itoa(port)+"/externalscaler.ExternalScaler/GetMetricSpec", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	message, err := readGrpcMessage(resp.Body, "")
+	if err != nil {
+		t.Fatalf("Cannot read response: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if (resp.ProtoMajor != 2) || (resp.Trailer.Get("Grpc-Status") != "0") {
+		t.Errorf("Expected an HTTP/2 response with grpc-status 0, got HTTP/%v and %q", resp.ProtoMajor, resp.Trailer.Get("Grpc-Status"))
+	}
+	if !bytes.Equal(message, encodeGetMetricSpecResponse("burrow-lag-local-orders", 10)) {
+		t.Errorf("Unexpected metric spec %x", message)
+	}
+}
@@ -0,0 +1,162 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// The phases of shutdown, in the order they are stopped. Every module is flushed after the ingestion phase is stopped,
+// so the state that is saved has every offset that came in. Storage is stopped last, as all the others use it
+type ShutdownPhase int
+
+const (
+	PhaseIngestion ShutdownPhase = iota
+	PhaseAPI
+	PhaseNotifiers
+	PhaseStorage
+)
+
+var shutdownPhaseNames = []string{"ingestion", "API", "notifiers", "storage"}
+
+func (phase ShutdownPhase) String() string {
+	return shutdownPhaseNames[phase]
+}
+
+// A part of the application that is started and stopped with it. Stop is given a context that is done when the time
+// for shutting down runs out, and Flush writes out any state that the module holds
+type Module interface {
+	Start() error
+	Stop(ctx context.Context) error
+	Flush() error
+}
+
+// A Module made from the functions of something with its own Start and Stop, any of which can be nil. As the stop
+// function does not take a context, it is left running in the background if the context is done first
+type moduleFuncs struct {
+	start func()
+	stop  func()
+	flush func() error
+}
+
+func (module *moduleFuncs) Start() error {
+	if module.start != nil {
+		module.start()
+	}
+	return nil
+}
+
+func (module *moduleFuncs) Stop(ctx context.Context) error {
+	if module.stop == nil {
+		return nil
+	}
+	stopped := make(chan struct{})
+	go func() {
+		module.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (module *moduleFuncs) Flush() error {
+	if module.flush == nil {
+		return nil
+	}
+	return module.flush()
+}
+
+type lifecycleModule struct {
+	name   string
+	phase  ShutdownPhase
+	module Module
+}
+
+// The modules of the application, which are stopped at shutdown a phase at a time. Within a phase, modules are stopped
+// in the reverse of the order they were added, so a module can rely on the ones added before it in the same phase
+type Lifecycle struct {
+	lock    sync.Mutex
+	modules []*lifecycleModule
+	stopped bool
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{modules: make([]*lifecycleModule, 0)}
+}
+
+// Add a module that has already been started
+func (lifecycle *Lifecycle) Add(phase ShutdownPhase, name string, module Module) {
+	lifecycle.lock.Lock()
+	defer lifecycle.lock.Unlock()
+	lifecycle.modules = append(lifecycle.modules, &lifecycleModule{name: name, phase: phase, module: module})
+}
+
+// Start a module, and add it if it started
+func (lifecycle *Lifecycle) Start(phase ShutdownPhase, name string, module Module) error {
+	if err := module.Start(); err != nil {
+		return err
+	}
+	lifecycle.Add(phase, name, module)
+	return nil
+}
+
+// Stop the modules: first ingestion, then flush them all, then the API, the notifiers, and storage. Modules that fail
+// to stop or flush, or that are still stopping when the timeout runs out, are logged and shutdown moves on. The first
+// error is returned. Only the first call does anything
+func (lifecycle *Lifecycle) Shutdown(timeout time.Duration) error {
+	lifecycle.lock.Lock()
+	defer lifecycle.lock.Unlock()
+	if lifecycle.stopped {
+		return nil
+	}
+	lifecycle.stopped = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var firstErr error
+	failed := func(action string, module *lifecycleModule, err error) {
+		log.Errorf("Cannot %s %s: %v", action, module.name, err)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("cannot %s %s: %v", action, module.name, err)
+		}
+	}
+
+	for phase := PhaseIngestion; phase <= PhaseStorage; phase++ {
+		log.Infof("Stopping %s", phase)
+		for i := len(lifecycle.modules) - 1; i >= 0; i-- {
+			if module := lifecycle.modules[i]; module.phase == phase {
+				log.Debugf("Stopping %s", module.name)
+				if err := module.module.Stop(ctx); err != nil {
+					failed("stop", module, err)
+				}
+			}
+		}
+
+		if phase == PhaseIngestion {
+			log.Info("Flushing state")
+			for _, module := range lifecycle.modules {
+				if err := module.module.Flush(); err != nil {
+					failed("flush", module, err)
+				}
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_lifecycleShutdownOrder(t *testing.T) {
+	lifecycle := NewLifecycle()
+	events := make([]string, 0)
+	module := func(name string) *moduleFuncs {
+		return &moduleFuncs{
+			stop:  func() { events = append(events, "stop "+name) },
+			flush: func() error { events = append(events, "flush "+name); return nil },
+		}
+	}
+	lifecycle.Add(PhaseStorage, "storage", module("storage"))
+	lifecycle.Add(PhaseAPI, "server", module("server"))
+	lifecycle.Add(PhaseIngestion, "sources", module("sources"))
+	lifecycle.Add(PhaseNotifiers, "notifiers", module("notifiers"))
+	lifecycle.Add(PhaseIngestion, "health", module("health"))
+
+	if err := lifecycle.Shutdown(time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"stop health", "stop sources",
+		"flush storage", "flush server", "flush sources", "flush notifiers", "flush health",
+		"stop server", "stop notifiers", "stop storage",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v, not %v", expected, events)
+	}
+
+	// A second shutdown does nothing
+	lifecycle.Shutdown(time.Second)
+	if len(events) != len(expected) {
+		t.Errorf("Expected nothing more from a second shutdown, not %v", events[len(expected):])
+	}
+}
+
+func Test_lifecycleShutdownErrors(t *testing.T) {
+	lifecycle := NewLifecycle()
+	stopped := make(chan struct{})
+	blocked := make(chan struct{})
+	defer close(blocked)
+	lifecycle.Add(PhaseStorage, "storage", &moduleFuncs{stop: func() { close(stopped) }})
+	lifecycle.Add(PhaseAPI, "server", &moduleFuncs{stop: func() { <-blocked }})
+	lifecycle.Add(PhaseIngestion, "sources", &moduleFuncs{flush: func() error { return errors.New("flush failed") }})
+
+	err := lifecycle.Shutdown(50 * time.Millisecond)
+	if (err == nil) || (err.Error() != "cannot flush sources: flush failed") {
+		t.Errorf("Expected the flush error, not %v", err)
+	}
+	// Storage is still stopped after the server times out, but in the background as the time is up
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected storage to be stopped after the server timed out")
+	}
+}
+
+func Test_moduleFuncsStopTimeout(t *testing.T) {
+	blocked := make(chan struct{})
+	defer close(blocked)
+	module := &moduleFuncs{stop: func() { <-blocked }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := module.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, not %v", err)
+	}
+}
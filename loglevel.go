@@ -0,0 +1,259 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// The modules that can have their own log level, by the source files that they log from
+var logModules = map[string][]string{
+	"storage":   {"*/storage/*"},
+	"kafka":     {"*/kafka_client.go", "*/quarantine.go"},
+	"zookeeper": {"*/zookeeper.go"},
+	"storm":     {"*/storm.go"},
+	"notifier":  {"*/http_notifier.go", "*/emailer.go"},
+	"http":      {"*/http_server.go"},
+}
+
+var logLevelNames = map[string]bool{
+	"trace":    true,
+	"debug":    true,
+	"info":     true,
+	"warn":     true,
+	"error":    true,
+	"critical": true,
+	"off":      true,
+}
+
+// The log level, and the levels of modules that have their own, as changed at runtime. Changing them rebuilds the
+// logger from the logging config (or the default logger, if there isn't one) with the levels set in it, so the
+// evaluation rings survive turning on trace logging. An empty level is the one in the logging config
+type LogLevels struct {
+	lock    sync.Mutex
+	config  []byte
+	level   string
+	modules map[string]string
+}
+
+func NewLogLevels(cfgfile string) (*LogLevels, error) {
+	config := []byte("<seelog />")
+	if cfgfile != "" {
+		var err error
+		if config, err = ioutil.ReadFile(cfgfile); err != nil {
+			return nil, err
+		}
+	}
+	return &LogLevels{config: config, modules: make(map[string]string)}, nil
+}
+
+// Return the log level, and the levels of the modules that have their own
+func (levels *LogLevels) Get() (string, map[string]string) {
+	levels.lock.Lock()
+	defer levels.lock.Unlock()
+	modules := make(map[string]string, len(levels.modules))
+	for module, level := range levels.modules {
+		modules[module] = level
+	}
+	return levels.level, modules
+}
+
+// Change the log level (if not empty) and the levels of the modules given, and replace the logger. A level of
+// "default" goes back to the one in the logging config, and for a module, to the log level
+func (levels *LogLevels) Set(level string, modules map[string]string) error {
+	levels.lock.Lock()
+	defer levels.lock.Unlock()
+
+	newLevel := levels.level
+	if level == "" {
+		level = "unchanged"
+	} else {
+		if (level != "default") && !logLevelNames[level] {
+			return fmt.Errorf("unknown log level %s", level)
+		}
+		newLevel = level
+		if level == "default" {
+			newLevel = ""
+		}
+	}
+	newModules := make(map[string]string, len(levels.modules))
+	for module, moduleLevel := range levels.modules {
+		newModules[module] = moduleLevel
+	}
+	for module, moduleLevel := range modules {
+		if _, ok := logModules[module]; !ok {
+			return fmt.Errorf("unknown module %s", module)
+		}
+		switch {
+		case moduleLevel == "default":
+			delete(newModules, module)
+		case logLevelNames[moduleLevel]:
+			newModules[module] = moduleLevel
+		default:
+			return fmt.Errorf("unknown log level %s for module %s", moduleLevel, module)
+		}
+	}
+
+	config, err := logConfigWithLevels(levels.config, newLevel, newModules)
+	if err != nil {
+		return err
+	}
+	logger, err := log.LoggerFromConfigAsBytes(config)
+	if err != nil {
+		return err
+	}
+	log.ReplaceLogger(logger)
+	levels.level = newLevel
+	levels.modules = newModules
+	log.Infof("Log level set to %s, with module levels %v", level, newModules)
+	return nil
+}
+
+// Rewrite a seelog config with the log level on the root element, and an exception for each module with its own
+// level. These go before any exceptions already in the config, as the first exception that matches is used
+func logConfigWithLevels(config []byte, level string, modules map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(modules))
+	for module := range modules {
+		names = append(names, module)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(config))
+	encoder := xml.NewEncoder(&out)
+	addedExceptions := false
+	addExceptions := func() {
+		for _, module := range names {
+			for _, pattern := range logModules[module] {
+				exception := xml.StartElement{Name: xml.Name{Local: "exception"}, Attr: []xml.Attr{
+					{Name: xml.Name{Local: "filepattern"}, Value: pattern},
+					{Name: xml.Name{Local: "minlevel"}, Value: modules[module]},
+				}}
+				encoder.EncodeToken(exception)
+				encoder.EncodeToken(exception.End())
+			}
+		}
+		addedExceptions = true
+	}
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse logging config: %v", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			if (depth == 1) && (level != "") {
+				attrs := make([]xml.Attr, 0, len(element.Attr)+1)
+				for _, attr := range element.Attr {
+					if (attr.Name.Local != "minlevel") && (attr.Name.Local != "maxlevel") && (attr.Name.Local != "levels") {
+						attrs = append(attrs, attr)
+					}
+				}
+				element.Attr = append(attrs, xml.Attr{Name: xml.Name{Local: "minlevel"}, Value: level})
+			}
+			if err := encoder.EncodeToken(element); err != nil {
+				return nil, err
+			}
+			if (depth == 2) && (element.Name.Local == "exceptions") {
+				addExceptions()
+			}
+			continue
+		case xml.EndElement:
+			if (depth == 1) && !addedExceptions && (len(names) > 0) {
+				exceptions := xml.StartElement{Name: xml.Name{Local: "exceptions"}}
+				encoder.EncodeToken(exceptions)
+				addExceptions()
+				encoder.EncodeToken(exceptions.End())
+			}
+			depth--
+		}
+		if err := encoder.EncodeToken(token); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+type HTTPResponseLogLevel struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Level   string                  `json:"level"`
+	Modules map[string]string       `json:"modules"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/admin/loglevel, which returns the log level and the modules with their own level and needs an admin
+// token, and PUT, which changes them with a body such as {"level":"debug","modules":{"storage":"trace"}}. Modules are
+// storage, kafka, zookeeper, storm, notifier, and http, and a level of "default" undoes a change
+func handleLogLevel(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	switch r.Method {
+	case "GET":
+		if _, ok := app.AdminAudit.Authenticate(r); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			return makeErrorResponse(http.StatusUnauthorized, "an admin token is required", w, r)
+		}
+		return writeLogLevels(app, w, r, "log levels returned")
+	case "PUT":
+		return adminAction(app, w, r, "set log level", func() (int, string) {
+			var body struct {
+				Level   string            `json:"level"`
+				Modules map[string]string `json:"modules"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); (err != nil) && (err != io.EOF) {
+				return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+			}
+			if err := app.LogLevels.Set(body.Level, body.Modules); err != nil {
+				return makeErrorResponse(http.StatusBadRequest, err.Error(), w, r)
+			}
+			return writeLogLevels(app, w, r, "log levels set")
+		})
+	default:
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+}
+
+func writeLogLevels(app *ApplicationContext, w http.ResponseWriter, r *http.Request, message string) (int, string) {
+	level, modules := app.LogLevels.Get()
+	if level == "" {
+		level = "default"
+	}
+	jsonStr, err := json.Marshal(HTTPResponseLogLevel{
+		Error:   false,
+		Message: message,
+		Level:   level,
+		Modules: modules,
+		Request: makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	log "github.com/cihub/seelog"
+	"reflect"
+	"testing"
+)
+
+func Test_logConfigWithLevels(t *testing.T) {
+	config := []byte(`<seelog minlevel="info"><exceptions><exception funcpattern="*main.zk*" minlevel="error"/></exceptions>` +
+		`<outputs><console/></outputs></seelog>`)
+	rewritten, err := logConfigWithLevels(config, "debug", map[string]string{"storage": "trace", "notifier": "warn"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `<seelog minlevel="debug"><exceptions>` +
+		`<exception filepattern="*/http_notifier.go" minlevel="warn"></exception>` +
+		`<exception filepattern="*/emailer.go" minlevel="warn"></exception>` +
+		`<exception filepattern="*/storage/*" minlevel="trace"></exception>` +
+		`<exception funcpattern="*main.zk*" minlevel="error"></exception></exceptions>` +
+		`<outputs><console></console></outputs></seelog>`
+	if string(rewritten) != expected {
+		t.Errorf("Expected %s, not %s", expected, rewritten)
+	}
+
+	// Without exceptions in the config, they are added, and the level in the config is kept if none is given
+	rewritten, err = logConfigWithLevels([]byte(`<seelog levels="info,error"/>`), "", map[string]string{"kafka": "trace"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = `<seelog levels="info,error"><exceptions>` +
+		`<exception filepattern="*/kafka_client.go" minlevel="trace"></exception>` +
+		`<exception filepattern="*/quarantine.go" minlevel="trace"></exception></exceptions></seelog>`
+	if string(rewritten) != expected {
+		t.Errorf("Expected %s, not %s", expected, rewritten)
+	}
+}
+
+func Test_logLevelsSet(t *testing.T) {
+	defer log.ReplaceLogger(log.Default)
+	levels, _ := NewLogLevels("")
+
+	if err := levels.Set("loud", nil); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if err := levels.Set("", map[string]string{"everything": "trace"}); err == nil {
+		t.Error("Expected an error for an unknown module")
+	}
+	if err := levels.Set("", map[string]string{"storage": "verbose"}); err == nil {
+		t.Error("Expected an error for an unknown module level")
+	}
+
+	if err := levels.Set("debug", map[string]string{"storage": "trace", "http": "off"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := levels.Set("", map[string]string{"http": "default"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	level, modules := levels.Get()
+	if (level != "debug") || !reflect.DeepEqual(modules, map[string]string{"storage": "trace"}) {
+		t.Errorf("Expected debug with storage at trace, not %s with %v", level, modules)
+	}
+
+	if err := levels.Set("default", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if level, _ := levels.Get(); level != "" {
+		t.Errorf("Expected the configured level, not %s", level)
+	}
+}
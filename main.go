@@ -14,32 +14,48 @@ import (
 	"flag"
 	"fmt"
 	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
 	"github.com/samuel/go-zookeeper/zk"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-type KafkaCluster struct {
-	Client    *KafkaClient
-	Zookeeper *ZookeeperClient
-}
-
-type StormCluster struct {
-	Storm *StormClient
-}
-
 type ApplicationContext struct {
-	Config       *BurrowConfig
-	Storage      *OffsetStorage
-	Clusters     map[string]*KafkaCluster
-	Storms       map[string]*StormCluster
-	Server       *HttpServer
-	Emailer      *Emailer
-	HttpNotifier *HttpNotifier
-	NotifierLock *zk.Lock
+	Config         *BurrowConfig
+	Storage        *storage.OffsetStorage
+	Sources        *OffsetSources
+	Validator      *OffsetValidator
+	Evaluator      *BackgroundEvaluator
+	Metrics        *Metrics
+	StatusLinks    *StatusLinks
+	AuditLog       *AuditLog
+	Export         *OffsetExport
+	AdminAudit     *AdminAudit
+	Quarantine     *OffsetQuarantine
+	Fatigue        *AlertFatigue
+	LogLevels      *LogLevels
+	Encryptor      *Encryptor
+	TopicGroups    []*TopicGroup
+	Tunnels        map[string]*Tunnel
+	Server         *HttpServer
+	Emailer        *Emailer
+	HttpNotifier   *HttpNotifier
+	Health         *HealthReporter
+	ProduceMonitor *ProduceMonitor
+	MirrorChecker  *MirrorChecker
+	TrendReporter  *TrendReporter
+	Accounting     *UsageAccounting
+	StaleConfig    *StaleConfigChecker
+	NotifierLock   *zk.Lock
+
+	// Set to 1 (atomically) once the notifier lock is held, and stopNotifiers only runs once
+	notifierLeader int32
+	notifierStop   sync.Once
 }
 
 func loadNotifiers(app *ApplicationContext) error {
@@ -76,6 +92,7 @@ func startNotifiers(app *ApplicationContext) {
 		os.Exit(1)
 	}
 	log.Info("Acquired Zookeeper notifier lock")
+	atomic.StoreInt32(&app.notifierLeader, 1)
 
 	if app.Emailer != nil {
 		log.Info("Starting Email notifier")
@@ -87,34 +104,160 @@ func startNotifiers(app *ApplicationContext) {
 	}
 }
 
+// Stop the notifiers and release the lock. This is done at shutdown, or earlier if the notifiers are handed off to
+// another instance
 func stopNotifiers(app *ApplicationContext) {
-	// Ignore errors on unlock - we're quitting anyways, and it might not be locked
-	app.NotifierLock.Unlock()
+	app.notifierStop.Do(func() {
+		// Ignore errors on unlock - we're quitting anyways, and it might not be locked
+		app.NotifierLock.Unlock()
+		atomic.StoreInt32(&app.notifierLeader, 0)
+
+		if app.Emailer != nil {
+			log.Info("Stopping Email notifier")
+			app.Emailer.Stop()
+		}
+		if app.HttpNotifier != nil {
+			log.Info("Stopping HTTP notifier")
+			app.HttpNotifier.Stop()
+		}
+	})
+}
 
+// Wait, after the notifiers are stopped, for the notifications they were sending
+func waitNotifiers(app *ApplicationContext) {
 	if app.Emailer != nil {
-		log.Info("Stopping Email notifier")
-		app.Emailer.Stop()
+		app.Emailer.Wait()
 	}
 	if app.HttpNotifier != nil {
-		log.Info("Stopping HTTP notifier")
-		app.HttpNotifier.Stop()
+		app.HttpNotifier.Wait()
+	}
+}
+
+// Build the storage module's config, starting the modules that storage sends commits and statuses to (the audit log,
+// offset export, commit sampler, usage accounting, and trend reporter) and adding them to the lifecycle. The
+// integration test harness uses this too, so that it stores offsets the same way Burrow does
+func loadStorageConfig(app *ApplicationContext, lifecycle *Lifecycle) (*storage.Config, error) {
+	var err error
+	storageConfig := StorageConfig(app.Config)
+
+	// Start the audit log, if configured. This has to be before the storage module, which sends it commits
+	if (app.Config.Audit.File != "") || (app.Config.Audit.KafkaTopic != "") {
+		log.Info("Starting offset commit audit log")
+		app.AuditLog, err = NewAuditLog(app)
+		if err != nil {
+			log.Criticalf("Cannot start audit log: %v", err)
+			return nil, err
+		}
+		lifecycle.Add(PhaseStorage, "offset commit audit log", &moduleFuncs{stop: app.AuditLog.Stop})
+		storageConfig.CommitHook = app.AuditLog.Record
+	}
+
+	// Start the offset export, if configured. As with the audit log, this has to be before the storage module
+	if (app.Config.Export.KafkaTopic != "") || app.Config.Export.Stream {
+		log.Info("Starting offset export")
+		app.Export, err = NewOffsetExport(app)
+		if err != nil {
+			log.Criticalf("Cannot start offset export: %v", err)
+			return nil, err
+		}
+		lifecycle.Add(PhaseStorage, "offset export", &moduleFuncs{stop: app.Export.Stop})
+		storageConfig.CommitHook = chainOffsetHooks(storageConfig.CommitHook, app.Export.Record)
+		if app.Config.Export.BrokerOffsets {
+			storageConfig.BrokerHook = app.Export.Record
+		}
+	}
+
+	// Start the commit sampler, if configured
+	if app.Config.Sample.Prefix != "" {
+		log.Info("Starting commit sampler")
+		sampler, err := NewCommitSampler(app)
+		if err != nil {
+			log.Criticalf("Cannot start commit sampler: %v", err)
+			return nil, err
+		}
+		lifecycle.Add(PhaseStorage, "commit sampler", &moduleFuncs{stop: sampler.Stop})
+		storageConfig.CommitHook = chainOffsetHooks(storageConfig.CommitHook, sampler.Record)
+	}
+
+	// Count the messages each team consumes, if configured. This is started once storage is running
+	if app.Config.Accounting.Enable {
+		app.Accounting, err = NewUsageAccounting(app)
+		if err != nil {
+			log.Criticalf("Cannot load usage accounting state: %v", err)
+			return nil, err
+		}
+		storageConfig.CommitHook = chainOffsetHooks(storageConfig.CommitHook, app.Accounting.Record)
+	}
+	app.Fatigue = NewAlertFatigue()
+	storageConfig.StatusHook = chainStatusHooks(groupStatusMetrics(app.Metrics, app.StatusLinks), app.Fatigue.Record)
+
+	// Collect the trends of groups for the trend report, if configured. This is started once storage is running
+	if trendReportConfigured(app.Config) {
+		app.TrendReporter, err = NewTrendReporter(app)
+		if err != nil {
+			log.Criticalf("Cannot load trend report state: %v", err)
+			return nil, err
+		}
+		storageConfig.StatusHook = chainStatusHooks(storageConfig.StatusHook, app.TrendReporter.Record)
+	}
+	storageConfig.DropHook = droppedOffsetMetrics(app.Metrics)
+	storageConfig.SkewHook = clockSkewMetrics(app.Metrics)
+	switch app.Config.Storage.Backend {
+	case "file":
+		storageConfig.Backend = storage.NewFileBackend(app.Config.Storage.Path, storage.CodecByName(app.Config.Storage.Codec))
+	case "bolt":
+		storageConfig.Backend, err = storage.NewBoltBackend(app.Config.Storage.Path, storage.CodecByName(app.Config.Storage.Codec))
+		if err != nil {
+			log.Criticalf("Cannot open BoltDB storage backend: %v", err)
+			return nil, err
+		}
 	}
+	return storageConfig, nil
 }
 
 // Why two mains? Golang doesn't let main() return, which means defers will not run.
 // So we do everything in a separate main, that way we can easily exit out with an error code and still run defers
 func burrowMain() int {
-	// The only command line arg is the config file
+	// The command line args are the config file, and a file to decrypt (instead of running)
 	var cfgfile = flag.String("config", "burrow.cfg", "Full path to the configuration file")
+	var decryptFile = flag.String("decrypt", "", "Decrypt a diagnostics dump or audit log file to stdout, using the key in the config, and exit")
+	var restoreSnapshotFlag = flag.Bool("restore-from-object-store", false, "Load the newest storage snapshot from the object store before starting")
 	flag.Parse()
 
 	// Load and validate the configuration
 	fmt.Fprintln(os.Stderr, "Reading configuration from", *cfgfile)
-	appContext := &ApplicationContext{Config: ReadConfig(*cfgfile)}
+	appContext := &ApplicationContext{Config: ReadConfig(*cfgfile), Metrics: NewMetrics(), StatusLinks: NewStatusLinks()}
 	if err := ValidateConfig(appContext); err != nil {
 		log.Criticalf("Cannot validate configuration: %v", err)
 		return 1
 	}
+	appContext.TopicGroups = loadTopicGroups(appContext.Config)
+	if appContext.Config.General.FIPS {
+		fipsMode = true
+	}
+	addressFamily = appContext.Config.General.AddressFamily
+
+	// Load the key for encrypting state on disk, if configured
+	cfgEncryption := appContext.Config.Encryption
+	if (cfgEncryption.KeyFile != "") || (cfgEncryption.KeyEnv != "") || (cfgEncryption.VaultAddress != "") {
+		encryptor, err := NewEncryptor(appContext)
+		if err != nil {
+			log.Criticalf("Cannot load encryption key: %v", err)
+			return 1
+		}
+		appContext.Encryptor = encryptor
+	}
+	if *decryptFile != "" {
+		if appContext.Encryptor == nil {
+			log.Critical("Cannot decrypt without an encryption key in the configuration")
+			return 1
+		}
+		if err := appContext.Encryptor.DecryptFile(*decryptFile, os.Stdout); err != nil {
+			log.Criticalf("Cannot decrypt %s: %v", *decryptFile, err)
+			return 1
+		}
+		return 0
+	}
 
 	// Create the PID file to lock out other processes. Defer removal so it's the last thing to go
 	createPidFile(appContext.Config.General.LogDir + "/" + appContext.Config.General.PIDFile)
@@ -126,73 +269,184 @@ func burrowMain() int {
 	}
 
 	fmt.Println("Started Burrow at", time.Now().Format("January 2, 2006 at 3:04pm (MST)"))
+	if fipsMode {
+		fmt.Println("Running in FIPS mode")
+	}
 
 	// If a logging config is specified, replace the existing loggers
 	if appContext.Config.General.LogConfig != "" {
 		NewLogger(appContext.Config.General.LogConfig)
 	}
 
+	// The log levels can be changed at runtime through /v2/admin/loglevel, which rebuilds the logger from the same config
+	logLevels, err := NewLogLevels(appContext.Config.General.LogConfig)
+	if err != nil {
+		log.Criticalf("Cannot read logging config: %v", err)
+		return 1
+	}
+	appContext.LogLevels = logLevels
+
+	// Load the tunnels to clusters in networks that can't be reached directly, if configured
+	appContext.Tunnels, err = loadTunnels(appContext.Config)
+	if err != nil {
+		log.Criticalf("Cannot load tunnels: %v", err)
+		return 1
+	}
+
 	// Start a local Zookeeper client (used for application locks)
 	log.Info("Starting Zookeeper client")
-	zkconn, _, err := zk.Connect(appContext.Config.Zookeeper.Hosts, time.Duration(appContext.Config.Zookeeper.Timeout)*time.Second)
+	zkconn, err := connectZookeeper(appContext.Config.Zookeeper.Hosts, time.Duration(appContext.Config.Zookeeper.Timeout)*time.Second, zookeeperDialer)
 	if err != nil {
 		log.Criticalf("Cannot start Zookeeper client: %v", err)
 		return 1
 	}
 	defer zkconn.Close()
 
+	// Everything started from here on is stopped in order by the lifecycle, rather than by defers: ingestion first,
+	// then state is flushed, then the API, the notifiers, and storage last
+	lifecycle := NewLifecycle()
+	defer lifecycle.Shutdown(time.Duration(appContext.Config.General.ShutdownTimeout) * time.Second)
+	if len(appContext.Tunnels) > 0 {
+		// Added first, so the tunnels are closed after everything else that is ingesting
+		lifecycle.Add(PhaseIngestion, "tunnels", &moduleFuncs{stop: func() { closeTunnels(appContext.Tunnels) }})
+	}
+
+	// Start the modules that storage sends commits and statuses to, and build the storage config with their hooks
+	storageConfig, err := loadStorageConfig(appContext, lifecycle)
+	if err != nil {
+		return 1
+	}
+	if *restoreSnapshotFlag {
+		if appContext.Config.Storage.ObjectStoreUrl == "" {
+			log.Critical("Cannot restore from the object store, as no object-store-url is configured")
+			return 1
+		}
+		if err := restoreSnapshot(appContext); err != nil {
+			log.Criticalf("Cannot restore storage snapshot from the object store: %v", err)
+			return 1
+		}
+	}
+
 	// Start an offsets storage module
 	log.Info("Starting Offsets Storage module")
-	appContext.Storage, err = NewOffsetStorage(appContext)
+	appContext.Storage, err = storage.NewOffsetStorage(storageConfig)
 	if err != nil {
 		log.Criticalf("Cannot configure offsets storage module: %v", err)
 		return 1
 	}
-	defer appContext.Storage.Stop()
+	lifecycle.Add(PhaseStorage, "offsets storage", &moduleFuncs{stop: appContext.Storage.Stop, flush: appContext.Storage.Flush})
+
+	// Pull the offset rings from a peer, if configured, before any offsets come in from the sources
+	if appContext.Config.Bootstrap.Peer != "" {
+		log.Infof("Bootstrapping from %s", appContext.Config.Bootstrap.Peer)
+		if err := bootstrapFromPeer(appContext); err != nil {
+			log.Warnf("Cannot bootstrap from %s, so groups will warm up from their own commits: %v", appContext.Config.Bootstrap.Peer, err)
+		}
+	}
+
+	// Start uploading storage snapshots, if configured
+	if appContext.Config.Storage.ObjectStoreUrl != "" {
+		log.Info("Starting storage snapshot uploader")
+		uploader, err := NewSnapshotUploader(appContext)
+		if err != nil {
+			log.Criticalf("Cannot start storage snapshot uploader: %v", err)
+			return 1
+		}
+		lifecycle.Add(PhaseStorage, "storage snapshot uploader", &moduleFuncs{stop: uploader.Stop})
+	}
+
+	// Start the admin audit log, which records destructive API calls
+	appContext.AdminAudit, err = NewAdminAudit(appContext)
+	if err != nil {
+		log.Criticalf("Cannot start admin audit log: %v", err)
+		return 1
+	}
+	lifecycle.Add(PhaseStorage, "admin audit log", &moduleFuncs{stop: appContext.AdminAudit.Stop})
 
 	// Start an HTTP server
 	log.Info("Starting HTTP server")
 	appContext.Server, err = NewHttpServer(appContext)
+	if err == nil {
+		err = lifecycle.Start(PhaseAPI, "HTTP server", appContext.Server)
+	}
 	if err != nil {
 		log.Criticalf("Cannot start HTTP server: %v", err)
 		return 1
 	}
-	defer appContext.Server.Stop()
 
-	// Start Kafka clients and Zookeepers for each cluster
-	appContext.Clusters = make(map[string]*KafkaCluster, len(appContext.Config.Kafka))
-	for cluster, _ := range appContext.Config.Kafka {
-		log.Infof("Starting Zookeeper client for cluster %s", cluster)
-		zkconn, err := NewZookeeperClient(appContext, cluster)
-		if err != nil {
-			log.Criticalf("Cannot start Zookeeper client for cluster %s: %v", cluster, err)
-			return 1
-		}
-		defer zkconn.Stop()
+	// Offsets topic messages that the Kafka clients cannot decode are kept here
+	appContext.Quarantine = NewOffsetQuarantine(appContext)
+
+	// Start the offset sources (Kafka clients, Zookeeper and Storm checkers) for each cluster. Clusters that fail to
+	// start are retried in the background
+	appContext.Sources = startOffsetSources(appContext)
+	lifecycle.Add(PhaseIngestion, "offset sources", &moduleFuncs{stop: appContext.Sources.Stop})
 
-		log.Infof("Starting Kafka client for cluster %s", cluster)
-		client, err := NewKafkaClient(appContext, cluster)
+	// Start the KEDA external scaler, if configured
+	if appContext.Config.Keda.Port > 0 {
+		log.Info("Starting KEDA external scaler")
+		scaler, err := NewKedaScaler(appContext)
 		if err != nil {
-			log.Criticalf("Cannot start Kafka client for cluster %s: %v", cluster, err)
+			log.Criticalf("Cannot start KEDA external scaler: %v", err)
 			return 1
 		}
-		defer client.Stop()
+		lifecycle.Add(PhaseAPI, "KEDA external scaler", &moduleFuncs{stop: scaler.Stop})
+	}
 
-		appContext.Clusters[cluster] = &KafkaCluster{Client: client, Zookeeper: zkconn}
+	// Start publishing this instance's health for failover, if configured. It is stopped (and reports the instance
+	// as unhealthy) before the sources are
+	cfgHealth := appContext.Config.Health
+	if (cfgHealth.File != "") || (cfgHealth.ConsulCheckId != "") || (cfgHealth.PingUrl != "") {
+		log.Info("Starting health reporter")
+		appContext.Health = NewHealthReporter(appContext)
+		lifecycle.Add(PhaseIngestion, "health reporter", &moduleFuncs{stop: appContext.Health.Stop})
 	}
 
-	// Start Storm Clients for each storm cluster
-	appContext.Storms = make(map[string]*StormCluster, len(appContext.Config.Storm))
-	for cluster, _ := range appContext.Config.Storm {
-		log.Infof("Starting Storm client for cluster %s", cluster)
-		stormClient, err := NewStormClient(appContext, cluster)
-		if err != nil {
-			log.Criticalf("Cannot start Storm client for cluster %s: %v", cluster, err)
-			return 1
-		}
-		defer stormClient.Stop()
+	// Start cross-checking stored offsets against the brokers, if configured
+	if appContext.Config.Validation.Interval > 0 {
+		log.Info("Starting offset validator")
+		appContext.Validator = NewOffsetValidator(appContext)
+		lifecycle.Start(PhaseIngestion, "offset validator", &moduleFuncs{start: appContext.Validator.Start, stop: appContext.Validator.Stop})
+	}
+
+	// Start watching the produce rates of topics, if configured
+	if len(appContext.Config.ProduceAlert) > 0 {
+		log.Info("Starting produce monitor")
+		appContext.ProduceMonitor = NewProduceMonitor(appContext)
+		lifecycle.Start(PhaseIngestion, "produce monitor", &moduleFuncs{start: appContext.ProduceMonitor.Start, stop: appContext.ProduceMonitor.Stop})
+	}
+
+	// Start comparing groups that consume mirrored topics across clusters, if configured
+	if len(appContext.Config.MirrorCheck) > 0 {
+		log.Info("Starting mirror checker")
+		appContext.MirrorChecker = NewMirrorChecker(appContext)
+		lifecycle.Start(PhaseIngestion, "mirror checker", &moduleFuncs{start: appContext.MirrorChecker.Start, stop: appContext.MirrorChecker.Stop})
+	}
+
+	// Start exporting the usage of each day, if configured
+	if appContext.Accounting != nil {
+		log.Info("Starting usage accounting")
+		lifecycle.Start(PhaseStorage, "usage accounting", &moduleFuncs{start: appContext.Accounting.Start, stop: appContext.Accounting.Stop, flush: appContext.Accounting.Flush})
+	}
+
+	// Start checking whether a trend report is due, if configured
+	if appContext.TrendReporter != nil {
+		log.Info("Starting trend reporter")
+		lifecycle.Start(PhaseNotifiers, "trend reporter", &moduleFuncs{start: appContext.TrendReporter.Start, stop: appContext.TrendReporter.Stop, flush: appContext.TrendReporter.Flush})
+	}
 
-		appContext.Storms[cluster] = &StormCluster{Storm: stormClient}
+	// Start checking for group names in the config that no longer match any group, if configured
+	if appContext.Config.StaleConfig.Days > 0 {
+		log.Info("Starting stale config checker")
+		appContext.StaleConfig = NewStaleConfigChecker(appContext)
+		lifecycle.Start(PhaseIngestion, "stale config checker", &moduleFuncs{start: appContext.StaleConfig.Start, stop: appContext.StaleConfig.Stop})
+	}
+
+	// Start evaluating every group in the background, for the views across a whole cluster
+	if appContext.Config.Evaluator.Interval > 0 {
+		log.Info("Starting background evaluator")
+		appContext.Evaluator = NewBackgroundEvaluator(appContext)
+		lifecycle.Start(PhaseIngestion, "background evaluator", &moduleFuncs{start: appContext.Evaluator.Start, stop: appContext.Evaluator.Stop})
 	}
 
 	// Set up the Zookeeper lock for notification
@@ -207,7 +461,22 @@ func burrowMain() int {
 
 	// Notifiers are started in a goroutine if we get the ZK lock
 	go startNotifiers(appContext)
-	defer stopNotifiers(appContext)
+	lifecycle.Add(PhaseNotifiers, "notifiers", &moduleFuncs{stop: func() { stopNotifiers(appContext); waitNotifiers(appContext) }})
+
+	// Write a diagnostics snapshot on SIGUSR1
+	dumpChannel := make(chan os.Signal, 1)
+	signal.Notify(dumpChannel, syscall.SIGUSR1)
+	defer signal.Stop(dumpChannel)
+	go func() {
+		for _ = range dumpChannel {
+			filename, err := dumpDiagnostics(appContext)
+			if err != nil {
+				log.Errorf("Cannot write diagnostics dump: %v", err)
+				continue
+			}
+			log.Infof("Wrote diagnostics dump to %s", filename)
+		}
+	}()
 
 	// Register signal handlers for exiting
 	exitChannel := make(chan os.Signal, 1)
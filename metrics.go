@@ -0,0 +1,358 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The types of metric that can be registered. They are named the way Prometheus names them
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// The buckets for histograms that are observed without being registered, for latencies in seconds
+var defaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A registry of counters, gauges, and histograms, which is served in the Prometheus text format at /metrics. Each metric has a set
+// of labels, and a value is kept for each combination of label values that has been used. A value can also have an
+// exemplar, which is only served to scrapers that ask for the OpenMetrics format. It is safe to use from multiple
+// goroutines
+type Metrics struct {
+	families map[string]*metricFamily
+	lock     sync.RWMutex
+}
+
+type metricFamily struct {
+	kind       string
+	help       string
+	values     map[string]float64
+	exemplars  map[string]*metricExemplar
+	buckets    []float64
+	histograms map[string]*metricHistogram
+}
+
+// The observations of a histogram for one set of labels. The counts are for each bucket alone, and are summed when
+// the histogram is served
+type metricHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// An exemplar points from a value to something that explains it, such as the request that gives the details. The
+// labels are already formatted, and the timestamp is in seconds
+type metricExemplar struct {
+	labels    string
+	value     float64
+	timestamp float64
+}
+
+// OpenMetrics limits the labels of an exemplar to this many characters in total
+const maxExemplarLabelLength = 128
+
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+// Register a metric, so that it is listed with its type and help text even before it has any values. Registering the
+// same name twice replaces the type and help text, but keeps the values
+func (metrics *Metrics) Register(name string, kind string, help string) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	if family, ok := metrics.families[name]; ok {
+		family.kind = kind
+		family.help = help
+		return
+	}
+	metrics.families[name] = &metricFamily{kind: kind, help: help, values: make(map[string]float64)}
+}
+
+// Register a histogram with the upper bounds of its buckets, which must be sorted. Registering the same name twice
+// replaces the help text, but keeps the buckets and observations
+func (metrics *Metrics) RegisterHistogram(name string, help string, buckets []float64) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	if family, ok := metrics.families[name]; ok {
+		family.help = help
+		return
+	}
+	metrics.families[name] = &metricFamily{kind: MetricHistogram, help: help, values: make(map[string]float64),
+		buckets: buckets, histograms: make(map[string]*metricHistogram)}
+}
+
+// Add an observation to a histogram. If the metric was not registered, it is created with the default buckets and no
+// help text
+func (metrics *Metrics) Observe(name string, labels map[string]string, value float64) {
+	key := formatLabels(labels)
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	family, ok := metrics.families[name]
+	if !ok {
+		family = &metricFamily{kind: MetricHistogram, values: make(map[string]float64), buckets: defaultHistogramBuckets,
+			histograms: make(map[string]*metricHistogram)}
+		metrics.families[name] = family
+	}
+	if family.histograms == nil {
+		family.histograms = make(map[string]*metricHistogram)
+	}
+	histogram, ok := family.histograms[key]
+	if !ok {
+		histogram = &metricHistogram{counts: make([]uint64, len(family.buckets))}
+		family.histograms[key] = histogram
+	}
+	for i, bound := range family.buckets {
+		if value <= bound {
+			histogram.counts[i]++
+			break
+		}
+	}
+	histogram.sum += value
+	histogram.count++
+}
+
+// Add to a counter. If the metric was not registered, it is created as a counter with no help text
+func (metrics *Metrics) Add(name string, labels map[string]string, delta float64) {
+	metrics.update(name, MetricCounter, labels, func(value float64) float64 { return value + delta })
+}
+
+// Set a gauge. If the metric was not registered, it is created as a gauge with no help text
+func (metrics *Metrics) Set(name string, labels map[string]string, value float64) {
+	metrics.update(name, MetricGauge, labels, func(float64) float64 { return value })
+}
+
+// Remove the value for one set of labels, such as for a group that has gone away
+func (metrics *Metrics) Delete(name string, labels map[string]string) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	if family, ok := metrics.families[name]; ok {
+		delete(family.values, formatLabels(labels))
+		delete(family.exemplars, formatLabels(labels))
+		delete(family.histograms, formatLabels(labels))
+	}
+}
+
+// Set a gauge, with an exemplar for the new value. Exemplars with labels that are too long for OpenMetrics are left off
+func (metrics *Metrics) SetWithExemplar(name string, labels map[string]string, value float64, exemplar map[string]string) {
+	metrics.Set(name, labels, value)
+
+	length := 0
+	if len(exemplar) == 0 {
+		return
+	}
+	for labelName, labelValue := range exemplar {
+		length += utf8.RuneCountInString(labelName) + utf8.RuneCountInString(labelValue)
+	}
+	if length > maxExemplarLabelLength {
+		return
+	}
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	family := metrics.families[name]
+	if family.exemplars == nil {
+		family.exemplars = make(map[string]*metricExemplar)
+	}
+	family.exemplars[formatLabels(labels)] = &metricExemplar{
+		labels:    formatLabels(exemplar),
+		value:     value,
+		timestamp: float64(time.Now().UnixNano()/int64(time.Millisecond)) / 1000,
+	}
+}
+
+func (metrics *Metrics) update(name string, kind string, labels map[string]string, update func(float64) float64) {
+	key := formatLabels(labels)
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	family, ok := metrics.families[name]
+	if !ok {
+		family = &metricFamily{kind: kind, values: make(map[string]float64)}
+		metrics.families[name] = family
+	}
+	family.values[key] = update(family.values[key])
+}
+
+// Labels are written sorted by name, so the same set of labels always gives the same key
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=\"" + escapeLabelValue(labels[name]) + "\""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// Serve the metrics in the Prometheus text format, sorted by name and then labels. Scrapers that accept OpenMetrics
+// get that format instead, which has the exemplars
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "request method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	}
+
+	metrics.lock.RLock()
+	defer metrics.lock.RUnlock()
+
+	names := make([]string, 0, len(metrics.families))
+	for name := range metrics.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := bufio.NewWriter(w)
+	for _, name := range names {
+		family := metrics.families[name]
+
+		// In OpenMetrics, a counter family is named without the _total that its samples have
+		familyName := name
+		if openMetrics && (family.kind == MetricCounter) {
+			familyName = strings.TrimSuffix(name, "_total")
+		}
+		if family.help != "" {
+			fmt.Fprintf(out, "# HELP %s %s\n", familyName, family.help)
+		}
+		fmt.Fprintf(out, "# TYPE %s %s\n", familyName, family.kind)
+
+		keys := make([]string, 0, len(family.values))
+		for key := range family.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(out, "%s%s %s", name, key, strconv.FormatFloat(family.values[key], 'g', -1, 64))
+			if exemplar, ok := family.exemplars[key]; ok && openMetrics {
+				fmt.Fprintf(out, " # %s %s %s", exemplar.labels, strconv.FormatFloat(exemplar.value, 'g', -1, 64),
+					strconv.FormatFloat(exemplar.timestamp, 'f', 3, 64))
+			}
+			out.WriteString("\n")
+		}
+		writeHistograms(out, name, family)
+	}
+	if openMetrics {
+		out.WriteString("# EOF\n")
+	}
+	out.Flush()
+}
+
+// Write the samples of a histogram family: the cumulative count for each bucket (ending with +Inf), the sum, and the
+// count, for each set of labels
+func writeHistograms(out *bufio.Writer, name string, family *metricFamily) {
+	keys := make([]string, 0, len(family.histograms))
+	for key := range family.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		histogram := family.histograms[key]
+		var cumulative uint64
+		for i, bound := range family.buckets {
+			cumulative += histogram.counts[i]
+			fmt.Fprintf(out, "%s_bucket%s %v\n", name, withLabel(key, "le", strconv.FormatFloat(bound, 'g', -1, 64)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %v\n", name, withLabel(key, "le", "+Inf"), histogram.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", name, key, strconv.FormatFloat(histogram.sum, 'g', -1, 64))
+		fmt.Fprintf(out, "%s_count%s %v\n", name, key, histogram.count)
+	}
+}
+
+// Add a label to a set of labels that is already formatted
+func withLabel(key string, name string, value string) string {
+	label := name + "=\"" + escapeLabelValue(value) + "\""
+	if key == "" {
+		return "{" + label + "}"
+	}
+	return key[:len(key)-1] + "," + label + "}"
+}
+
+// Return a storage skew hook that sets the estimated clock skew of each cluster
+func clockSkewMetrics(metrics *Metrics) func(cluster string, skew int64) {
+	metrics.Register("burrow_clock_skew_seconds", MetricGauge, "Estimated skew of the cluster's clock from commit timestamps, positive if it is behind this host")
+	return func(cluster string, skew int64) {
+		metrics.Set("burrow_clock_skew_seconds", map[string]string{"cluster": cluster}, float64(skew)/1000)
+	}
+}
+
+// Return a storage drop hook that counts the dropped offsets for each cluster and reason
+func droppedOffsetMetrics(metrics *Metrics) func(cluster string, counts map[string]int64) {
+	metrics.Register("burrow_dropped_offsets_total", MetricCounter, "Consumer offset commits that were dropped rather than stored, by reason")
+
+	return func(cluster string, counts map[string]int64) {
+		for reason, count := range counts {
+			metrics.Add("burrow_dropped_offsets_total", map[string]string{"cluster": cluster, "reason": reason}, float64(count))
+		}
+	}
+}
+
+// Return a storage status hook that keeps the per-group status and lag gauges up to date. The group's tags are added
+// as labels, and each value has an exemplar with the group's status link ID. A group that is no longer found has its
+// values removed
+func groupStatusMetrics(metrics *Metrics, links *StatusLinks) func(status *storage.ConsumerGroupStatus) {
+	metrics.Register("burrow_group_status", MetricGauge, "Status of the consumer group from its last evaluation (0 not found, 1 OK, 2 warning, 3 error, 4 stop, 5 stall, 6 rewind, 7 retention, 8 warming)")
+	metrics.Register("burrow_group_total_lag", MetricGauge, "Total lag of the consumer group across all partitions from its last evaluation")
+
+	return func(status *storage.ConsumerGroupStatus) {
+		labels := make(map[string]string, len(status.Tags)+2)
+		for name, value := range status.Tags {
+			labels[name] = value
+		}
+		labels["cluster"] = status.Cluster
+		labels["group"] = status.Group
+
+		if status.Status == storage.StatusNotFound {
+			metrics.Delete("burrow_group_status", labels)
+			metrics.Delete("burrow_group_total_lag", labels)
+			links.Remove(status.Cluster, status.Group)
+			return
+		}
+		exemplar := map[string]string{"status_id": links.Add(status.Cluster, status.Group)}
+		metrics.SetWithExemplar("burrow_group_status", labels, float64(status.Status), exemplar)
+		metrics.SetWithExemplar("burrow_group_total_lag", labels, float64(status.TotalLag), exemplar)
+	}
+}
@@ -0,0 +1,324 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A group whose committed offsets for a mirrored topic differ between two clusters by more than the check's
+// max-divergence. The partition is the one that differs the most, and Offset is the one in Cluster, the first cluster
+// of the check
+type MirrorDivergence struct {
+	Check        string `json:"check"`
+	Group        string `json:"group"`
+	Cluster      string `json:"cluster"`
+	OtherCluster string `json:"other_cluster"`
+	Topic        string `json:"topic"`
+	Partition    int32  `json:"partition"`
+	Offset       int64  `json:"offset"`
+	OtherOffset  int64  `json:"other_offset"`
+	Divergence   int64  `json:"divergence"`
+	State        string `json:"state,omitempty"`
+	Since        int64  `json:"since"`
+}
+
+// The mirror checker compares the committed offsets of groups that consume mirrored topics under the same name in
+// more than one cluster, for each [mirror-check] section every interval seconds. Each cluster of a check is compared
+// to the first, and a group whose offsets for a partition differ by more than max-divergence is consuming in both
+// (split-brain) or has fallen behind in one. As each cluster's own evaluation of the group can be fine, this is the
+// only place that is caught. Divergent groups are logged, counted in metrics, listed at /v2/burrow/mirror-divergence,
+// and POSTed to the section's url when they are raised and cleared
+type MirrorChecker struct {
+	app    *ApplicationContext
+	client *http.Client
+	topics map[string][]*regexp.Regexp
+	groups map[string]*regexp.Regexp
+	lock   sync.RWMutex
+	alerts map[string]map[string]*MirrorDivergence
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewMirrorChecker(app *ApplicationContext) *MirrorChecker {
+	checker := &MirrorChecker{
+		app: app,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: newTLSConfig(),
+		}},
+		topics: make(map[string][]*regexp.Regexp),
+		groups: make(map[string]*regexp.Regexp),
+		alerts: make(map[string]map[string]*MirrorDivergence),
+		quit:   make(chan struct{}),
+	}
+	for name, cfg := range app.Config.MirrorCheck {
+		// The config has already been validated
+		for _, topic := range cfg.Topics {
+			checker.topics[name] = append(checker.topics[name], regexp.MustCompile(topic))
+		}
+		checker.groups[name] = regexp.MustCompile(cfg.Group)
+		checker.alerts[name] = make(map[string]*MirrorDivergence)
+	}
+
+	app.Metrics.Register("burrow_mirror_divergence_total", MetricCounter, "Groups whose offsets for a mirrored topic diverged between clusters")
+	app.Metrics.Register("burrow_mirror_divergent_groups", MetricGauge, "Groups whose offsets for a mirrored topic differ between clusters")
+	return checker
+}
+
+func (checker *MirrorChecker) Start() {
+	for name := range checker.app.Config.MirrorCheck {
+		checker.wg.Add(1)
+		go func(name string) {
+			defer checker.wg.Done()
+
+			ticker := time.NewTicker(time.Duration(checker.app.Config.MirrorCheck[name].Interval) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-checker.quit:
+					return
+				case <-ticker.C:
+					checker.check(name)
+				}
+			}
+		}(name)
+	}
+}
+
+func (checker *MirrorChecker) Stop() {
+	close(checker.quit)
+	checker.wg.Wait()
+}
+
+// Return the divergent groups, sorted by check, group, and cluster
+func (checker *MirrorChecker) Divergences() []*MirrorDivergence {
+	checker.lock.RLock()
+	defer checker.lock.RUnlock()
+
+	divergences := make([]*MirrorDivergence, 0)
+	for _, alerts := range checker.alerts {
+		for _, alert := range alerts {
+			divergence := *alert
+			divergences = append(divergences, &divergence)
+		}
+	}
+	sort.Slice(divergences, func(i, j int) bool {
+		if divergences[i].Check != divergences[j].Check {
+			return divergences[i].Check < divergences[j].Check
+		}
+		if divergences[i].Group != divergences[j].Group {
+			return divergences[i].Group < divergences[j].Group
+		}
+		return divergences[i].OtherCluster < divergences[j].OtherCluster
+	})
+	return divergences
+}
+
+// Compare the groups of a check across its clusters, and raise or clear their alerts. If storage is too busy to
+// answer, the check is skipped rather than clearing the alerts
+func (checker *MirrorChecker) check(name string) {
+	cfg := checker.app.Config.MirrorCheck[name]
+	cluster := cfg.Clusters[0]
+	groups, ok := checker.consumerList(cluster)
+	if !ok {
+		log.Warnf("Cannot run mirror check %s: %s", name, storageBusyReason)
+		return
+	}
+
+	found := make(map[string]*MirrorDivergence)
+	for _, otherCluster := range cfg.Clusters[1:] {
+		otherGroups, ok := checker.consumerList(otherCluster)
+		if !ok {
+			log.Warnf("Cannot run mirror check %s: %s", name, storageBusyReason)
+			return
+		}
+		inOther := make(map[string]bool, len(otherGroups))
+		for _, group := range otherGroups {
+			inOther[group] = true
+		}
+
+		for _, group := range groups {
+			if !inOther[group] || !checker.groups[name].MatchString(group) {
+				continue
+			}
+			offsets, ok := checker.consumerOffsets(cluster, group)
+			otherOffsets, otherOk := checker.consumerOffsets(otherCluster, group)
+			if !ok || !otherOk {
+				log.Warnf("Cannot run mirror check %s: %s", name, storageBusyReason)
+				return
+			}
+			divergence := mirrorDivergence(checker.topics[name], offsets, otherOffsets)
+			if (divergence != nil) && (divergence.Divergence > cfg.MaxDivergence) {
+				divergence.Check, divergence.Group, divergence.Cluster, divergence.OtherCluster = name, group, cluster, otherCluster
+				found[otherCluster+"/"+group] = divergence
+			}
+		}
+	}
+	checker.update(name, found, time.Now())
+}
+
+// Replace the alerts of a check with the divergences found, keeping when the ones that were already raised started
+func (checker *MirrorChecker) update(name string, found map[string]*MirrorDivergence, now time.Time) {
+	raised := make([]*MirrorDivergence, 0)
+	cleared := make([]*MirrorDivergence, 0)
+	checker.lock.Lock()
+	for key, divergence := range found {
+		if alert, ok := checker.alerts[name][key]; ok {
+			divergence.Since = alert.Since
+		} else {
+			divergence.Since = now.UnixNano() / int64(time.Millisecond)
+			raised = append(raised, divergence)
+		}
+	}
+	for key, alert := range checker.alerts[name] {
+		if _, ok := found[key]; !ok {
+			cleared = append(cleared, alert)
+		}
+	}
+	checker.alerts[name] = found
+	checker.lock.Unlock()
+
+	url := checker.app.Config.MirrorCheck[name].Url
+	checker.app.Metrics.Set("burrow_mirror_divergent_groups", map[string]string{"check": name}, float64(len(found)))
+	for _, alert := range cleared {
+		log.Infof("Offsets of group %s in clusters %s and %s are back in step", alert.Group, alert.Cluster, alert.OtherCluster)
+		checker.notify(url, alert, "cleared")
+	}
+	for _, alert := range raised {
+		checker.app.Metrics.Add("burrow_mirror_divergence_total", map[string]string{"check": name}, 1)
+		log.Warnf("Offsets of group %s for %s:%v differ by %v between clusters %s (%v) and %s (%v)", alert.Group,
+			alert.Topic, alert.Partition, alert.Divergence, alert.Cluster, alert.Offset, alert.OtherCluster, alert.OtherOffset)
+		checker.notify(url, alert, "raised")
+	}
+}
+
+func (checker *MirrorChecker) consumerList(cluster string) ([]string, bool) {
+	request := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+	if !sendStorageRequest(checker.app, request) {
+		return nil, false
+	}
+	return <-request.Result, true
+}
+
+func (checker *MirrorChecker) consumerOffsets(cluster string, group string) (map[string][]*storage.ConsumerOffset, bool) {
+	request := &storage.RequestConsumerOffsets{Result: make(chan map[string][]*storage.ConsumerOffset), Cluster: cluster, Group: group}
+	if !sendStorageRequest(checker.app, request) {
+		return nil, false
+	}
+	return <-request.Result, true
+}
+
+// Find the partition of the topics matching a check whose committed offsets differ the most between two clusters.
+// Only partitions with an offset in both are compared. Returns nil if there are none
+func mirrorDivergence(topics []*regexp.Regexp, offsets map[string][]*storage.ConsumerOffset, otherOffsets map[string][]*storage.ConsumerOffset) *MirrorDivergence {
+	names := make([]string, 0, len(offsets))
+	for topic := range offsets {
+		for _, re := range topics {
+			if re.MatchString(topic) {
+				names = append(names, topic)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var worst *MirrorDivergence
+	for _, topic := range names {
+		otherPartitions := otherOffsets[topic]
+		for partition, offset := range offsets[topic] {
+			if (offset == nil) || (partition >= len(otherPartitions)) || (otherPartitions[partition] == nil) {
+				continue
+			}
+			otherOffset := otherPartitions[partition].Offset
+			divergence := offset.Offset - otherOffset
+			if divergence < 0 {
+				divergence = -divergence
+			}
+			if (worst == nil) || (divergence > worst.Divergence) {
+				worst = &MirrorDivergence{Topic: topic, Partition: int32(partition), Offset: offset.Offset,
+					OtherOffset: otherOffset, Divergence: divergence}
+			}
+		}
+	}
+	return worst
+}
+
+// POST the alert to the section's url, if it has one
+func (checker *MirrorChecker) notify(url string, alert *MirrorDivergence, state string) {
+	if url == "" {
+		return
+	}
+	event := *alert
+	event.State = state
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Cannot encode mirror divergence for group %s: %v", alert.Group, err)
+		return
+	}
+	resp, err := checker.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Cannot send mirror divergence for group %s to %s: %v", alert.Group, url, err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		log.Errorf("Cannot send mirror divergence for group %s to %s: %s", alert.Group, url, resp.Status)
+	}
+}
+
+type HTTPResponseMirrorDivergence struct {
+	Error       bool                    `json:"error"`
+	Message     string                  `json:"message"`
+	Divergences []*MirrorDivergence     `json:"divergences"`
+	Request     HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/mirror-divergence, which returns the groups whose offsets for mirrored topics differ between
+// clusters. Groups are left out unless the token can see both clusters
+func handleMirrorDivergence(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if app.MirrorChecker == nil {
+		return makeErrorResponse(http.StatusNotFound, "mirror checks are not configured", w, r)
+	}
+
+	divergences := make([]*MirrorDivergence, 0)
+	for _, divergence := range app.MirrorChecker.Divergences() {
+		if app.AdminAudit.ClusterAllowed(r, divergence.Cluster) && app.AdminAudit.ClusterAllowed(r, divergence.OtherCluster) {
+			divergences = append(divergences, divergence)
+		}
+	}
+	jsonStr, err := json.Marshal(HTTPResponseMirrorDivergence{
+		Error:       false,
+		Message:     "mirror divergences returned",
+		Divergences: divergences,
+		Request:     makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
@@ -0,0 +1,121 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The address family that Burrow listens and connects with, from address-family in the [general] config section:
+//   - dual: both IPv4 and IPv6, in the order the resolver returns addresses (the default)
+//   - ipv4 or ipv6: only that family, for hosts that only have one
+//   - prefer-ipv4 or prefer-ipv6: both, but addresses of that family are tried first
+//
+// The HTTP server, Kafka and Zookeeper clients, the HTTP notifier, and SMTP all follow this setting
+var addressFamily = "dual"
+
+var addressFamilies = map[string]bool{
+	"dual":        true,
+	"ipv4":        true,
+	"ipv6":        true,
+	"prefer-ipv4": true,
+	"prefer-ipv6": true,
+}
+
+// The network to listen on for TCP, which restricts the listener to one family if needed
+func listenNetwork() string {
+	switch addressFamily {
+	case "ipv4":
+		return "tcp4"
+	case "ipv6":
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// Strip the brackets from an IPv6 literal, as they may be given in the config ([::1]) but are not part of the address
+func trimBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// A dialer that follows the configured address family. It can be used as a sarama proxy dialer, a Zookeeper dialer,
+// and for HTTP transports
+type familyDialer struct {
+	dialer net.Dialer
+}
+
+func newDialer(timeout time.Duration, keepalive time.Duration) *familyDialer {
+	return &familyDialer{dialer: net.Dialer{Timeout: timeout, KeepAlive: keepalive}}
+}
+
+func (d *familyDialer) Dial(network string, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *familyDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+	switch addressFamily {
+	case "ipv4":
+		return d.dialer.DialContext(ctx, "tcp4", address)
+	case "ipv6":
+		return d.dialer.DialContext(ctx, "tcp6", address)
+	case "prefer-ipv4":
+		return d.dialPreferred(ctx, address, false)
+	case "prefer-ipv6":
+		return d.dialPreferred(ctx, address, true)
+	default:
+		return d.dialer.DialContext(ctx, network, address)
+	}
+}
+
+// Resolve the host and try each of its addresses in turn, with those of the preferred family first
+func (d *familyDialer) dialPreferred(ctx context.Context, address string, preferIPv6 bool) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, "tcp", address)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return ((addrs[i].IP.To4() == nil) == preferIPv6) && ((addrs[j].IP.To4() == nil) != preferIPv6)
+	})
+
+	err = errors.New("no addresses found for " + host)
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = d.dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Dial function for the Zookeeper client
+func zookeeperDialer(network string, address string, timeout time.Duration) (net.Conn, error) {
+	return newDialer(timeout, 0).Dial(network, address)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Set the address family for the test, and put it back after
+func setAddressFamily(t *testing.T, family string) {
+	previous := addressFamily
+	addressFamily = family
+	t.Cleanup(func() { addressFamily = previous })
+}
+
+func Test_listenNetwork(t *testing.T) {
+	for family, expected := range map[string]string{"dual": "tcp", "ipv4": "tcp4", "ipv6": "tcp6", "prefer-ipv4": "tcp", "prefer-ipv6": "tcp"} {
+		setAddressFamily(t, family)
+		if network := listenNetwork(); network != expected {
+			t.Errorf("Expected %s to listen on %s, not %s", family, expected, network)
+		}
+	}
+}
+
+func Test_trimBrackets(t *testing.T) {
+	for host, expected := range map[string]string{"[::1]": "::1", "::1": "::1", "localhost": "localhost", "[::1": "[::1", "": ""} {
+		if trimmed := trimBrackets(host); trimmed != expected {
+			t.Errorf("Expected %q to be %q, got %q", host, expected, trimmed)
+		}
+	}
+}
+
+func Test_familyDialer(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	ipv4 := listener.Addr().String()
+	dialer := newDialer(5*time.Second, 0)
+
+	for _, family := range []string{"dual", "ipv4", "prefer-ipv4", "prefer-ipv6"} {
+		setAddressFamily(t, family)
+		conn, err := dialer.Dial("tcp", ipv4)
+		if err != nil {
+			t.Errorf("Expected %s to connect to an IPv4 address, got %v", family, err)
+			continue
+		}
+		conn.Close()
+	}
+
+	setAddressFamily(t, "ipv6")
+	if conn, err := dialer.Dial("tcp", ipv4); err == nil {
+		conn.Close()
+		t.Errorf("Expected ipv6 not to connect to an IPv4 address")
+	}
+
+	// With a preference, a host name that also has an address of the other family still connects to the IPv4 one
+	setAddressFamily(t, "prefer-ipv6")
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Errorf("Expected localhost to connect on port %v, got %v", port, err)
+	} else {
+		conn.Close()
+	}
+	if _, err := dialer.Dial("tcp", "no-such-host.invalid:80"); err == nil {
+		t.Errorf("Expected an error for a host that doesn't resolve")
+	}
+}
@@ -0,0 +1,137 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"github.com/linkedin/burrow/storage"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A variant of the notifier messages, such as in another language or format for one team's NOC. Email addresses pick
+// a variant by name. For the HTTP notifier, each variant that matches a group (by cluster, group name, and tags) is
+// sent to its own URL, as well as the usual message being sent to the [httpnotifier] URL
+type NotifierVariant struct {
+	Name           string
+	Locale         string
+	Url            string
+	email          *template.Template
+	templatePost   *template.Template
+	templateDelete *template.Template
+	clusters       map[string]bool
+	group          *regexp.Regexp
+	tags           map[string]string
+}
+
+// The helper functions that notifier templates can use. Times are shown in the given location by localtime (for a
+// time.Time) and mstime (for a timestamp in milliseconds, as in the offsets)
+func notifierTemplateFuncs(location *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"jsonencoder":     templateJsonEncoder,
+		"topicsbystatus":  classifyTopicsByStatus,
+		"partitioncounts": templateCountPartitions,
+		"add":             templateAdd,
+		"minus":           templateMinus,
+		"multiply":        templateMultiply,
+		"divide":          templateDivide,
+		"maxlag":          maxLagHelper,
+		"localtime": func(t time.Time) time.Time {
+			return t.In(location)
+		},
+		"mstime": func(ms int64) time.Time {
+			return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).In(location)
+		},
+	}
+}
+
+func parseNotifierTemplate(filename string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New(filepath.Base(filename)).Funcs(funcs).ParseFiles(filename)
+}
+
+// Load the notifier variants, sorted by name. The config must have been validated
+func loadNotifierVariants(cfg *BurrowConfig) ([]*NotifierVariant, error) {
+	names := make([]string, 0, len(cfg.NotifierTemplate))
+	for name := range cfg.NotifierTemplate {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	variants := make([]*NotifierVariant, 0, len(names))
+	for _, name := range names {
+		variantCfg := cfg.NotifierTemplate[name]
+		location := displayLocation(cfg)
+		if variantCfg.Timezone != "" {
+			var err error
+			if location, err = time.LoadLocation(variantCfg.Timezone); err != nil {
+				return nil, err
+			}
+		}
+		funcs := notifierTemplateFuncs(location)
+
+		variant := &NotifierVariant{
+			Name:     name,
+			Locale:   variantCfg.Locale,
+			Url:      variantCfg.Url,
+			clusters: make(map[string]bool),
+			tags:     make(map[string]string),
+		}
+		var err error
+		if variantCfg.EmailTemplate != "" {
+			if variant.email, err = parseNotifierTemplate(variantCfg.EmailTemplate, funcs); err != nil {
+				return nil, err
+			}
+		}
+		if variantCfg.TemplatePost != "" {
+			if variant.templatePost, err = parseNotifierTemplate(variantCfg.TemplatePost, funcs); err != nil {
+				return nil, err
+			}
+		}
+		if variantCfg.TemplateDelete != "" {
+			if variant.templateDelete, err = parseNotifierTemplate(variantCfg.TemplateDelete, funcs); err != nil {
+				return nil, err
+			}
+		}
+		for _, cluster := range variantCfg.Clusters {
+			variant.clusters[cluster] = true
+		}
+		if variantCfg.Group != "" {
+			if variant.group, err = regexp.Compile(variantCfg.Group); err != nil {
+				return nil, err
+			}
+		}
+		for _, tag := range variantCfg.Tags {
+			parts := strings.SplitN(tag, "=", 2)
+			variant.tags[parts[0]] = parts[1]
+		}
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+// Check whether the routing rules of the variant match a group. A variant with no rules matches every group
+func (variant *NotifierVariant) matches(result *storage.ConsumerGroupStatus) bool {
+	if (len(variant.clusters) > 0) && !variant.clusters[result.Cluster] {
+		return false
+	}
+	if (variant.group != nil) && !variant.group.MatchString(result.Group) {
+		return false
+	}
+	for name, value := range variant.tags {
+		if result.Tags[name] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The reason given when the storage module does not take a request within the storage timeout
+const storageBusyReason = "storage module did not accept the request in time"
+
+// Status requests are turned away when the storage module is behind, rather than being left to wait. The 503 lets a
+// load balancer send them to another instance
+func isStatusRequest(pathParts []string) bool {
+	switch {
+	case pathParts[3] == "consumer-status":
+		return true
+	case (pathParts[3] == "consumer") && (len(pathParts) > 5):
+		switch pathParts[5] {
+		case "status", "lag", "gate", "rollup", "scale-hint":
+			return true
+		case "topic":
+			return (len(pathParts) >= 10) && (pathParts[7] == "partition") && (pathParts[9] == "status")
+		}
+	}
+	return false
+}
+
+// Send a request to the storage module, waiting no longer than the storage timeout for it to be taken. Returns false
+// if it was not
+func sendStorageRequest(app *ApplicationContext, request interface{}) bool {
+	return app.Storage.SendRequest(request, time.Duration(app.Config.Httpserver.StorageTimeout)*time.Second)
+}
+
+// Tell the client that the storage module is overloaded, and when to try again
+func makeOverloadedResponse(app *ApplicationContext, reason string, w http.ResponseWriter, r *http.Request) (int, string) {
+	w.Header().Set("Retry-After", strconv.Itoa(app.Config.Httpserver.RetryAfter))
+	return makeErrorResponse(http.StatusServiceUnavailable, "storage is overloaded: "+reason, w, r)
+}
@@ -0,0 +1,90 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type HTTPResponseClusterPause struct {
+	Error    bool                    `json:"error"`
+	Message  string                  `json:"message"`
+	PausedAt int64                   `json:"paused_at"`
+	Sources  []string                `json:"sources"`
+	Request  HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle POST /v2/admin/kafka/(cluster)/pause and /resume. Pausing a cluster stops its offset sources and freezes the
+// evaluation of its groups (so notifiers don't alert on stale offsets) during planned maintenance. Resuming starts the
+// sources again, with the stored offsets moved forward past the pause
+func handleClusterPause(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "POST" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	// Path is /v2/admin/kafka/(cluster)/(action)
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path[1:], "/"), "/")
+	if len(pathParts) != 5 {
+		return makeErrorResponse(http.StatusNotFound, "unknown API call", w, r)
+	}
+	cluster := pathParts[3]
+	if _, ok := app.Config.Kafka[cluster]; !ok {
+		return makeErrorResponse(http.StatusNotFound, "cluster not found", w, r)
+	}
+
+	var message string
+	var sources []string
+	switch pathParts[4] {
+	case "pause":
+		if !app.Storage.PauseCluster(cluster) {
+			return makeErrorResponse(http.StatusConflict, "cluster is already paused", w, r)
+		}
+		if app.Sources != nil {
+			sources = app.Sources.PauseCluster(cluster)
+		}
+		message = "cluster paused"
+	case "resume":
+		if app.Storage.ClusterPausedAt(cluster) == 0 {
+			return makeErrorResponse(http.StatusConflict, "cluster is not paused", w, r)
+		}
+
+		// Start the sources before resuming storage, so that no time passes where groups are evaluated without offsets
+		// coming in
+		if app.Sources != nil {
+			sources = app.Sources.ResumeCluster(cluster)
+		}
+		app.Storage.ResumeCluster(cluster)
+		message = "cluster resumed"
+	default:
+		return makeErrorResponse(http.StatusNotFound, "unknown API call", w, r)
+	}
+	if sources == nil {
+		sources = []string{}
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	jsonStr, err := json.Marshal(HTTPResponseClusterPause{
+		Error:    false,
+		Message:  message,
+		PausedAt: app.Storage.ClusterPausedAt(cluster),
+		Sources:  sources,
+		Request:  requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
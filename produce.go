@@ -0,0 +1,348 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The kinds of produce alert
+const (
+	ProduceAlertZero  = "zero"
+	ProduceAlertSpike = "spike"
+)
+
+// A topic whose produce rate is out of the ordinary. Baseline is the average rate over the baseline window before the
+// alert was raised, in messages per second
+type ProduceAlert struct {
+	Cluster  string  `json:"cluster"`
+	Topic    string  `json:"topic"`
+	Kind     string  `json:"kind"`
+	State    string  `json:"state,omitempty"`
+	Rate     float64 `json:"rate"`
+	Baseline float64 `json:"baseline"`
+	Since    int64   `json:"since"`
+}
+
+// A produce rate sample for a topic
+type produceSample struct {
+	time time.Time
+	rate float64
+}
+
+type topicProduceState struct {
+	samples   []produceSample
+	zeroSince time.Time
+	alert     *ProduceAlert
+}
+
+// The produce monitor checks the produce rate of the topics that match a [produce-alert] section every interval
+// seconds, and raises an alert when a topic that was being produced to has had nothing produced for zero-after
+// seconds (a producer died), or when the rate is more than spike-factor times its average over the baseline window.
+// The baseline isn't updated while a topic has a zero alert, so it stays raised until messages are produced again,
+// but a spike is folded into it, so a rate that stays high becomes the new normal. Alerts are logged, counted in
+// metrics, listed at /v2/burrow/produce-alerts, and POSTed to the section's url when they are raised and cleared
+type ProduceMonitor struct {
+	app    *ApplicationContext
+	client *http.Client
+	topics map[string][]*regexp.Regexp
+	lock   sync.RWMutex
+	state  map[string]map[string]*topicProduceState
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewProduceMonitor(app *ApplicationContext) *ProduceMonitor {
+	monitor := &ProduceMonitor{
+		app: app,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: newTLSConfig(),
+		}},
+		topics: make(map[string][]*regexp.Regexp),
+		state:  make(map[string]map[string]*topicProduceState),
+		quit:   make(chan struct{}),
+	}
+	for cluster, cfg := range app.Config.ProduceAlert {
+		for _, topic := range cfg.Topics {
+			// The config has already been validated
+			monitor.topics[cluster] = append(monitor.topics[cluster], regexp.MustCompile(topic))
+		}
+		monitor.state[cluster] = make(map[string]*topicProduceState)
+	}
+
+	app.Metrics.Register("burrow_produce_alerts_total", MetricCounter, "Produce alerts raised for topics, by kind")
+	app.Metrics.Register("burrow_produce_alert_active", MetricGauge, "Topics with a produce alert raised, by kind")
+	return monitor
+}
+
+func (monitor *ProduceMonitor) Start() {
+	for cluster := range monitor.app.Config.ProduceAlert {
+		monitor.wg.Add(1)
+		go func(cluster string) {
+			defer monitor.wg.Done()
+
+			ticker := time.NewTicker(time.Duration(monitor.app.Config.ProduceAlert[cluster].Interval) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-monitor.quit:
+					return
+				case <-ticker.C:
+					monitor.checkCluster(cluster)
+				}
+			}
+		}(cluster)
+	}
+}
+
+func (monitor *ProduceMonitor) Stop() {
+	close(monitor.quit)
+	monitor.wg.Wait()
+}
+
+// Return the alerts that are raised, sorted by cluster and topic
+func (monitor *ProduceMonitor) Alerts() []*ProduceAlert {
+	monitor.lock.RLock()
+	defer monitor.lock.RUnlock()
+
+	alerts := make([]*ProduceAlert, 0)
+	for _, topics := range monitor.state {
+		for _, state := range topics {
+			if state.alert != nil {
+				alert := *state.alert
+				alerts = append(alerts, &alert)
+			}
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Cluster != alerts[j].Cluster {
+			return alerts[i].Cluster < alerts[j].Cluster
+		}
+		return alerts[i].Topic < alerts[j].Topic
+	})
+	return alerts
+}
+
+func (monitor *ProduceMonitor) matches(cluster string, topic string) bool {
+	for _, re := range monitor.topics[cluster] {
+		if re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (monitor *ProduceMonitor) checkCluster(cluster string) {
+	topicRequest := &storage.RequestTopicList{Result: make(chan *storage.ResponseTopicList), Cluster: cluster}
+	if !sendStorageRequest(monitor.app, topicRequest) {
+		log.Warnf("Cannot check produce rates for cluster %s: %s", cluster, storageBusyReason)
+		return
+	}
+	topicList := <-topicRequest.Result
+	if topicList.Error {
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, topic := range topicList.TopicList {
+		if !monitor.matches(cluster, topic) {
+			continue
+		}
+		rateRequest := &storage.RequestTopicRate{Result: make(chan *storage.ResponseTopicRate), Cluster: cluster, Topic: topic}
+		if !sendStorageRequest(monitor.app, rateRequest) {
+			log.Warnf("Cannot check produce rates for cluster %s: %s", cluster, storageBusyReason)
+			return
+		}
+		rate := <-rateRequest.Result
+		if rate.ErrorTopic || (rate.Window == 0) {
+			// There aren't enough broker offsets yet to have a rate
+			continue
+		}
+		seen[topic] = true
+		monitor.checkTopic(cluster, topic, rate.TotalRate, now)
+	}
+
+	// Topics that were deleted (or no longer match) are forgotten, along with their alerts
+	monitor.lock.Lock()
+	for topic, state := range monitor.state[cluster] {
+		if !seen[topic] {
+			if state.alert != nil {
+				monitor.app.Metrics.Delete("burrow_produce_alert_active", map[string]string{"cluster": cluster, "topic": topic, "kind": state.alert.Kind})
+			}
+			delete(monitor.state[cluster], topic)
+		}
+	}
+	monitor.lock.Unlock()
+}
+
+// Update a topic's baseline with the current rate, and raise or clear its alert
+func (monitor *ProduceMonitor) checkTopic(cluster string, topic string, rate float64, now time.Time) {
+	cfg := monitor.app.Config.ProduceAlert[cluster]
+	monitor.lock.Lock()
+	state, ok := monitor.state[cluster][topic]
+	if !ok {
+		state = &topicProduceState{}
+		monitor.state[cluster][topic] = state
+	}
+	raised, cleared := updateProduceState(state, cfg, cluster, topic, rate, now)
+	monitor.lock.Unlock()
+
+	if cleared != nil {
+		labels := map[string]string{"cluster": cluster, "topic": topic, "kind": cleared.Kind}
+		monitor.app.Metrics.Delete("burrow_produce_alert_active", labels)
+		log.Infof("Produce rate for topic %s in cluster %s is back to normal (%.2f/s)", topic, cluster, rate)
+		monitor.notify(cfg.Url, cleared, "cleared")
+	}
+	if raised != nil {
+		labels := map[string]string{"cluster": cluster, "topic": topic, "kind": raised.Kind}
+		monitor.app.Metrics.Add("burrow_produce_alerts_total", labels, 1)
+		monitor.app.Metrics.Set("burrow_produce_alert_active", labels, 1)
+		if raised.Kind == ProduceAlertZero {
+			log.Warnf("Nothing has been produced to topic %s in cluster %s for %vs (baseline %.2f/s)", topic, cluster,
+				cfg.ZeroAfter, raised.Baseline)
+		} else {
+			log.Warnf("Produce rate for topic %s in cluster %s is %.2f/s, over %v times its baseline of %.2f/s", topic,
+				cluster, rate, cfg.SpikeFactor, raised.Baseline)
+		}
+		monitor.notify(cfg.Url, raised, "raised")
+	}
+}
+
+// Add a sample to the topic's state, and return the alert that was raised and the one that was cleared, if any
+func updateProduceState(state *topicProduceState, cfg *ProduceAlertConfig, cluster string, topic string, rate float64, now time.Time) (*ProduceAlert, *ProduceAlert) {
+	baseline, covered := produceBaseline(state.samples, now)
+	var raised, cleared *ProduceAlert
+
+	if rate > 0 {
+		state.zeroSince = time.Time{}
+	} else if state.zeroSince.IsZero() {
+		state.zeroSince = now
+	}
+
+	// Clear an alert that no longer applies before checking for a new one
+	if state.alert != nil {
+		if ((state.alert.Kind == ProduceAlertZero) && (rate > 0)) ||
+			((state.alert.Kind == ProduceAlertSpike) && (rate <= cfg.SpikeFactor*baseline)) {
+			cleared = state.alert
+			cleared.Rate = rate
+			state.alert = nil
+		}
+	}
+	if state.alert == nil {
+		switch {
+		case (rate == 0) && (baseline > 0) && (now.Sub(state.zeroSince) >= time.Duration(cfg.ZeroAfter)*time.Second):
+			raised = &ProduceAlert{Kind: ProduceAlertZero, Since: state.zeroSince.UnixNano() / int64(time.Millisecond)}
+		case (cfg.SpikeFactor > 0) && (baseline > 0) && (covered >= time.Duration(cfg.Baseline)*time.Second/2) &&
+			(rate > cfg.SpikeFactor*baseline):
+			raised = &ProduceAlert{Kind: ProduceAlertSpike, Since: now.UnixNano() / int64(time.Millisecond)}
+		}
+		if raised != nil {
+			raised.Cluster, raised.Topic, raised.Rate, raised.Baseline = cluster, topic, rate, baseline
+			state.alert = raised
+		}
+	} else {
+		state.alert.Rate = rate
+	}
+
+	if (state.alert == nil) || (state.alert.Kind != ProduceAlertZero) {
+		state.samples = append(state.samples, produceSample{time: now, rate: rate})
+	}
+	cutoff := now.Add(-time.Duration(cfg.Baseline) * time.Second)
+	for (len(state.samples) > 0) && state.samples[0].time.Before(cutoff) {
+		state.samples = state.samples[1:]
+	}
+	return raised, cleared
+}
+
+// Return the average rate of the samples, and how long a time they cover
+func produceBaseline(samples []produceSample, now time.Time) (float64, time.Duration) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	total := float64(0)
+	for _, sample := range samples {
+		total += sample.rate
+	}
+	return total / float64(len(samples)), now.Sub(samples[0].time)
+}
+
+// POST the alert to the section's url, if it has one
+func (monitor *ProduceMonitor) notify(url string, alert *ProduceAlert, state string) {
+	if url == "" {
+		return
+	}
+	event := *alert
+	event.State = state
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Cannot encode produce alert for topic %s: %v", alert.Topic, err)
+		return
+	}
+	resp, err := monitor.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Cannot send produce alert for topic %s to %s: %v", alert.Topic, url, err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		log.Errorf("Cannot send produce alert for topic %s to %s: %s", alert.Topic, url, resp.Status)
+	}
+}
+
+type HTTPResponseProduceAlerts struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Alerts  []*ProduceAlert         `json:"alerts"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/produce-alerts, which returns the topics whose produce rate is out of the ordinary. Topics in
+// clusters the token can't see are left out
+func handleProduceAlerts(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if app.ProduceMonitor == nil {
+		return makeErrorResponse(http.StatusNotFound, "produce alerts are not configured", w, r)
+	}
+
+	alerts := make([]*ProduceAlert, 0)
+	for _, alert := range app.ProduceMonitor.Alerts() {
+		if app.AdminAudit.ClusterAllowed(r, alert.Cluster) {
+			alerts = append(alerts, alert)
+		}
+	}
+	jsonStr, err := json.Marshal(HTTPResponseProduceAlerts{
+		Error:   false,
+		Message: "produce alerts returned",
+		Alerts:  alerts,
+		Request: makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
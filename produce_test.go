@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testProduceAlertConfig = &ProduceAlertConfig{Topics: []string{"^orders$"}, Interval: 60, ZeroAfter: 120, SpikeFactor: 3, Baseline: 600}
+
+// A topic that has had nothing produced for zero-after seconds raises a zero alert, which is cleared when messages are
+// produced again. The baseline isn't lowered any further by the zero rates while the alert is raised
+func Test_updateProduceStateZero(t *testing.T) {
+	state := &topicProduceState{}
+	start := time.Unix(1600000000, 0)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	for seconds := 0; seconds <= 60; seconds += 60 {
+		if raised, cleared := updateProduceState(state, testProduceAlertConfig, "local", "orders", 10, at(seconds)); (raised != nil) || (cleared != nil) {
+			t.Fatalf("Expected no alert at a steady rate, got %+v and %+v", raised, cleared)
+		}
+	}
+	if raised, _ := updateProduceState(state, testProduceAlertConfig, "local", "orders", 0, at(120)); raised != nil {
+		t.Errorf("Expected no alert as soon as the rate is zero, got %+v", raised)
+	}
+	if raised, _ := updateProduceState(state, testProduceAlertConfig, "local", "orders", 0, at(180)); raised != nil {
+		t.Errorf("Expected no alert before zero-after, got %+v", raised)
+	}
+	raised, _ := updateProduceState(state, testProduceAlertConfig, "local", "orders", 0, at(240))
+	if (raised == nil) || (raised.Kind != ProduceAlertZero) || (raised.Baseline != 5) || (raised.Since != at(120).Unix()*1000) {
+		t.Fatalf("Expected a zero alert since the rate was first zero, got %+v", raised)
+	}
+	updateProduceState(state, testProduceAlertConfig, "local", "orders", 0, at(300))
+	if baseline, _ := produceBaseline(state.samples, at(300)); baseline != 5 {
+		t.Errorf("Expected the baseline to leave out the zero rates after the alert was raised, got %v", baseline)
+	}
+
+	raised, cleared := updateProduceState(state, testProduceAlertConfig, "local", "orders", 5, at(360))
+	if (raised != nil) || (cleared == nil) || (cleared.Kind != ProduceAlertZero) || (cleared.Rate != 5) || (state.alert != nil) {
+		t.Errorf("Expected the zero alert to be cleared, got %+v and %+v", raised, cleared)
+	}
+}
+
+// A spike needs a baseline over half the window, and is folded into the baseline
+func Test_updateProduceStateSpike(t *testing.T) {
+	state := &topicProduceState{}
+	start := time.Unix(1600000000, 0)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	updateProduceState(state, testProduceAlertConfig, "local", "orders", 10, at(0))
+	if raised, _ := updateProduceState(state, testProduceAlertConfig, "local", "orders", 100, at(60)); raised != nil {
+		t.Errorf("Expected no spike alert without enough of a baseline, got %+v", raised)
+	}
+
+	state = &topicProduceState{}
+	for seconds := 0; seconds <= 300; seconds += 60 {
+		updateProduceState(state, testProduceAlertConfig, "local", "orders", 10, at(seconds))
+	}
+	raised, _ := updateProduceState(state, testProduceAlertConfig, "local", "orders", 50, at(360))
+	if (raised == nil) || (raised.Kind != ProduceAlertSpike) || (raised.Rate != 50) || (raised.Baseline != 10) {
+		t.Fatalf("Expected a spike alert, got %+v", raised)
+	}
+	if (len(state.samples) != 7) || (state.samples[6].rate != 50) {
+		t.Errorf("Expected the spike to be added to the baseline, got %+v", state.samples)
+	}
+	_, cleared := updateProduceState(state, testProduceAlertConfig, "local", "orders", 10, at(420))
+	if (cleared == nil) || (cleared.Kind != ProduceAlertSpike) {
+		t.Errorf("Expected the spike alert to be cleared, got %+v", cleared)
+	}
+}
+
+// Alerts are POSTed to the section's url when they are raised and cleared, and listed at /v2/burrow/produce-alerts
+func Test_produceMonitorAlerts(t *testing.T) {
+	events := make(chan *ProduceAlert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		event := &ProduceAlert{}
+		json.Unmarshal(body, event)
+		events <- event
+	}))
+	defer server.Close()
+
+	cfg := *testProduceAlertConfig
+	cfg.Url = server.URL
+	app := &ApplicationContext{Config: &BurrowConfig{ProduceAlert: map[string]*ProduceAlertConfig{"local": &cfg}}, Metrics: NewMetrics()}
+	app.ProduceMonitor = NewProduceMonitor(app)
+	if !app.ProduceMonitor.matches("local", "orders") || app.ProduceMonitor.matches("local", "orders-retry") {
+		t.Errorf("Expected only the topics that match the section to be checked")
+	}
+
+	now := time.Now()
+	app.ProduceMonitor.checkTopic("local", "orders", 10, now.Add(-10*time.Minute))
+	app.ProduceMonitor.checkTopic("local", "orders", 0, now.Add(-4*time.Minute))
+	app.ProduceMonitor.checkTopic("local", "orders", 0, now)
+	if event := <-events; (event.State != "raised") || (event.Kind != ProduceAlertZero) || (event.Topic != "orders") {
+		t.Errorf("Expected the zero alert to be sent, got %+v", event)
+	}
+
+	recorder := httptest.NewRecorder()
+	handleProduceAlerts(app, recorder, httptest.NewRequest("GET", "/v2/burrow/produce-alerts", nil))
+	response := &HTTPResponseProduceAlerts{}
+	json.Unmarshal(recorder.Body.Bytes(), response)
+	if (len(response.Alerts) != 1) || (response.Alerts[0].Cluster != "local") || (response.Alerts[0].Kind != ProduceAlertZero) {
+		t.Errorf("Expected the zero alert to be listed, got %s", recorder.Body.String())
+	}
+
+	app.ProduceMonitor.checkTopic("local", "orders", 10, now.Add(time.Minute))
+	if event := <-events; (event.State != "cleared") || (event.Rate != 10) {
+		t.Errorf("Expected the cleared alert to be sent, got %+v", event)
+	}
+	if alerts := app.ProduceMonitor.Alerts(); len(alerts) != 0 {
+		t.Errorf("Expected no alerts after it was cleared, got %+v", alerts)
+	}
+}
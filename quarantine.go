@@ -0,0 +1,509 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many undecodable messages are kept for each cluster, and how much of each message's value is kept
+const (
+	quarantineSamples   = 100
+	quarantineValueSize = 256
+)
+
+// An offset commit decoded from a message in the offsets topic
+type offsetCommit struct {
+	group     string
+	topic     string
+	partition int32
+	offset    int64
+	timestamp int64
+}
+
+// An error decoding a message from the offsets topic. The reason is the part of the message that could not be
+// decoded, which undecodable messages are counted by
+type decodeError struct {
+	reason string
+	err    error
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason, e.err)
+}
+
+func readString(buf *bytes.Buffer) (string, error) {
+	var strlen int16
+	err := binary.Read(buf, binary.BigEndian, &strlen)
+	if err != nil {
+		return "", err
+	}
+	if strlen < 0 {
+		// A null string
+		return "", nil
+	}
+	if int(strlen) > buf.Len() {
+		return "", errors.New("string underflow")
+	}
+	return string(buf.Next(int(strlen))), nil
+}
+
+// Decode a message from the offsets topic. Messages that are not offset commits (group metadata, and the tombstones
+// written when offsets are deleted) return nil with no error. This never panics, whatever the message is, so that one
+// bad message can't take down the consumer
+//
+// The key versions are 0 and 1 for offset commits, and 2 for group metadata. The value versions are:
+//
+//	0 - offset, metadata, timestamp
+//	1 - offset, metadata, commit timestamp, expire timestamp
+//	2 - offset, metadata, commit timestamp
+//	3 - offset, leader epoch, metadata, commit timestamp
+func decodeOffsetsMessage(key []byte, value []byte) (commit *offsetCommit, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			commit, err = nil, &decodeError{reason: "panic", err: fmt.Errorf("%v", r)}
+		}
+	}()
+	fail := func(reason string, err error) (*offsetCommit, error) {
+		return nil, &decodeError{reason: reason, err: err}
+	}
+
+	var keyver, valver int16
+	buf := bytes.NewBuffer(key)
+	if err := binary.Read(buf, binary.BigEndian, &keyver); err != nil {
+		return fail("key version", err)
+	}
+	switch keyver {
+	case 0, 1:
+	case 2:
+		return nil, nil
+	default:
+		return fail("key version", fmt.Errorf("unknown key version %v", keyver))
+	}
+
+	commit = &offsetCommit{}
+	if commit.group, err = readString(buf); err != nil {
+		return fail("group", err)
+	}
+	if commit.topic, err = readString(buf); err != nil {
+		return fail("topic", err)
+	}
+	if err := binary.Read(buf, binary.BigEndian, &commit.partition); err != nil {
+		return fail("partition", err)
+	}
+	if commit.partition < 0 {
+		return fail("partition", fmt.Errorf("negative partition %v", commit.partition))
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+	buf = bytes.NewBuffer(value)
+	if err := binary.Read(buf, binary.BigEndian, &valver); err != nil {
+		return fail("value version", err)
+	}
+	if (valver < 0) || (valver > 3) {
+		return fail("value version", fmt.Errorf("unknown value version %v", valver))
+	}
+	if err := binary.Read(buf, binary.BigEndian, &commit.offset); err != nil {
+		return fail("offset", err)
+	}
+	if valver == 3 {
+		var leaderEpoch int32
+		if err := binary.Read(buf, binary.BigEndian, &leaderEpoch); err != nil {
+			return fail("leader epoch", err)
+		}
+	}
+	if _, err := readString(buf); err != nil {
+		return fail("metadata", err)
+	}
+	if err := binary.Read(buf, binary.BigEndian, &commit.timestamp); err != nil {
+		return fail("timestamp", err)
+	}
+	return commit, nil
+}
+
+func readBytes(buf *bytes.Buffer) ([]byte, error) {
+	var length int32
+	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	if int(length) > buf.Len() {
+		return nil, errors.New("bytes underflow")
+	}
+	return buf.Next(int(length)), nil
+}
+
+// Read an array length, checking that the buffer has room for that many elements of at least size bytes, so that a
+// bad length can't make us allocate a huge slice. A null array has no elements
+func readArrayLength(buf *bytes.Buffer, size int) (int, error) {
+	var length int32
+	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+		return 0, err
+	}
+	if length < 0 {
+		return 0, nil
+	}
+	if int(length) > buf.Len()/size {
+		return 0, errors.New("array underflow")
+	}
+	return int(length), nil
+}
+
+// The members of a group, decoded from a group metadata message in the offsets topic
+type groupMembership struct {
+	group   string
+	members []*storage.GroupMember
+}
+
+// Decode a group metadata message from the offsets topic. Other messages return nil with no error. The tombstone
+// written when a group is deleted, and groups that aren't consumers (such as Kafka Connect workers), have no members.
+// As with offset commits, this never panics
+//
+// The key version is 2. The value versions are 0 to 3, and later versions (which Kafka only writes once every broker
+// supports them) are skipped, as the members are only used to say which client owns a partition. Each member has:
+//
+//	member id, group instance id (3+), client id, client host, rebalance timeout (1+), session timeout, subscription,
+//	assignment
+func decodeGroupMetadataMessage(key []byte, value []byte) (membership *groupMembership, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			membership, err = nil, &decodeError{reason: "panic", err: fmt.Errorf("%v", r)}
+		}
+	}()
+	fail := func(reason string, err error) (*groupMembership, error) {
+		return nil, &decodeError{reason: reason, err: err}
+	}
+
+	var keyver, valver int16
+	buf := bytes.NewBuffer(key)
+	if err := binary.Read(buf, binary.BigEndian, &keyver); err != nil {
+		return fail("key version", err)
+	}
+	if keyver != 2 {
+		return nil, nil
+	}
+	membership = &groupMembership{members: make([]*storage.GroupMember, 0)}
+	if membership.group, err = readString(buf); err != nil {
+		return fail("group", err)
+	}
+	if value == nil {
+		return membership, nil
+	}
+
+	buf = bytes.NewBuffer(value)
+	if err := binary.Read(buf, binary.BigEndian, &valver); err != nil {
+		return fail("metadata version", err)
+	}
+	if valver < 0 {
+		return fail("metadata version", fmt.Errorf("unknown metadata version %v", valver))
+	}
+	if valver > 3 {
+		return nil, nil
+	}
+	protocolType, err := readString(buf)
+	if err != nil {
+		return fail("protocol type", err)
+	}
+	var generation int32
+	if err := binary.Read(buf, binary.BigEndian, &generation); err != nil {
+		return fail("generation", err)
+	}
+	if _, err := readString(buf); err != nil {
+		return fail("protocol", err)
+	}
+	if _, err := readString(buf); err != nil {
+		return fail("leader", err)
+	}
+	if valver >= 2 {
+		var stateTimestamp int64
+		if err := binary.Read(buf, binary.BigEndian, &stateTimestamp); err != nil {
+			return fail("state timestamp", err)
+		}
+	}
+	if protocolType != "consumer" {
+		return membership, nil
+	}
+
+	count, err := readArrayLength(buf, 1)
+	if err != nil {
+		return fail("members", err)
+	}
+	for i := 0; i < count; i++ {
+		member := &storage.GroupMember{}
+		if member.MemberId, err = readString(buf); err != nil {
+			return fail("member id", err)
+		}
+		if valver >= 3 {
+			if _, err := readString(buf); err != nil {
+				return fail("group instance id", err)
+			}
+		}
+		if member.ClientId, err = readString(buf); err != nil {
+			return fail("client id", err)
+		}
+		if member.ClientHost, err = readString(buf); err != nil {
+			return fail("client host", err)
+		}
+		var timeout int32
+		if valver >= 1 {
+			if err := binary.Read(buf, binary.BigEndian, &timeout); err != nil {
+				return fail("rebalance timeout", err)
+			}
+		}
+		if err := binary.Read(buf, binary.BigEndian, &timeout); err != nil {
+			return fail("session timeout", err)
+		}
+		if _, err := readBytes(buf); err != nil {
+			return fail("subscription", err)
+		}
+		assignment, err := readBytes(buf)
+		if err != nil {
+			return fail("assignment", err)
+		}
+		if member.Assignment, err = decodeMemberAssignment(assignment); err != nil {
+			return fail("assignment", err)
+		}
+		membership.members = append(membership.members, member)
+	}
+	return membership, nil
+}
+
+// Decode the partitions assigned to a member of a consumer group: version, then an array of topics, each with an
+// array of partitions, then user data (which is ignored). A member has no assignment while the group rebalances
+func decodeMemberAssignment(assignment []byte) (map[string][]int32, error) {
+	topics := make(map[string][]int32)
+	if len(assignment) == 0 {
+		return topics, nil
+	}
+	buf := bytes.NewBuffer(assignment)
+	var version int16
+	if err := binary.Read(buf, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	count, err := readArrayLength(buf, 2)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		topic, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		partitions, err := readArrayLength(buf, 4)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < partitions; j++ {
+			var partition int32
+			if err := binary.Read(buf, binary.BigEndian, &partition); err != nil {
+				return nil, err
+			}
+			topics[topic] = append(topics[topic], partition)
+		}
+	}
+	return topics, nil
+}
+
+// A message from the offsets topic that could not be decoded. Key and Value are base64 in JSON, and only the first
+// 256 bytes of the value are kept
+type QuarantinedMessage struct {
+	Cluster       string `json:"cluster"`
+	Partition     int32  `json:"partition"`
+	Offset        int64  `json:"offset"`
+	Reason        string `json:"reason"`
+	Error         string `json:"error"`
+	Key           []byte `json:"key"`
+	Value         []byte `json:"value"`
+	ValueSize     int    `json:"value_size"`
+	QuarantinedAt int64  `json:"quarantined_at"`
+}
+
+// Messages from the offsets topic that could not be decoded are counted by cluster and reason, and the last few of
+// each cluster are kept for diagnosis at /v2/admin/quarantine. The first of each reason for a cluster is logged
+type OffsetQuarantine struct {
+	app     *ApplicationContext
+	lock    sync.RWMutex
+	counts  map[string]map[string]int64
+	samples map[string][]*QuarantinedMessage
+}
+
+func NewOffsetQuarantine(app *ApplicationContext) *OffsetQuarantine {
+	app.Metrics.Register("burrow_offsets_quarantined_total", MetricCounter, "Messages in the offsets topic that could not be decoded")
+	return &OffsetQuarantine{
+		app:     app,
+		counts:  make(map[string]map[string]int64),
+		samples: make(map[string][]*QuarantinedMessage),
+	}
+}
+
+// Quarantine a message that could not be decoded. A nil quarantine only logs it
+func (quarantine *OffsetQuarantine) Add(cluster string, partition int32, offset int64, key []byte, value []byte, err error) {
+	reason := "unknown"
+	if decodeErr, ok := err.(*decodeError); ok {
+		reason = decodeErr.reason
+	}
+	if quarantine == nil {
+		log.Warnf("Failed to decode offsets topic message in cluster %s at %v:%v: %v", cluster, partition, offset, err)
+		return
+	}
+
+	message := &QuarantinedMessage{
+		Cluster:       cluster,
+		Partition:     partition,
+		Offset:        offset,
+		Reason:        reason,
+		Error:         err.Error(),
+		Key:           append([]byte{}, key...),
+		ValueSize:     len(value),
+		QuarantinedAt: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if len(value) > quarantineValueSize {
+		value = value[:quarantineValueSize]
+	}
+	message.Value = append([]byte{}, value...)
+
+	quarantine.lock.Lock()
+	if _, ok := quarantine.counts[cluster]; !ok {
+		quarantine.counts[cluster] = make(map[string]int64)
+	}
+	quarantine.counts[cluster][reason]++
+	first := quarantine.counts[cluster][reason] == 1
+	samples := append(quarantine.samples[cluster], message)
+	if len(samples) > quarantineSamples {
+		samples = samples[len(samples)-quarantineSamples:]
+	}
+	quarantine.samples[cluster] = samples
+	quarantine.lock.Unlock()
+
+	quarantine.app.Metrics.Add("burrow_offsets_quarantined_total", map[string]string{"cluster": cluster, "reason": reason}, 1)
+	if first {
+		log.Warnf("Quarantined offsets topic message in cluster %s at %v:%v (%v). Others that fail for the same reason are counted at /v2/admin/quarantine",
+			cluster, partition, offset, err)
+	} else {
+		log.Debugf("Quarantined offsets topic message in cluster %s at %v:%v: %v", cluster, partition, offset, err)
+	}
+}
+
+// Return the counts by reason and the kept messages (oldest first) for a cluster
+func (quarantine *OffsetQuarantine) Cluster(cluster string) (map[string]int64, []*QuarantinedMessage) {
+	if quarantine == nil {
+		return map[string]int64{}, []*QuarantinedMessage{}
+	}
+	quarantine.lock.RLock()
+	defer quarantine.lock.RUnlock()
+
+	counts := make(map[string]int64, len(quarantine.counts[cluster]))
+	for reason, count := range quarantine.counts[cluster] {
+		counts[reason] = count
+	}
+	return counts, append([]*QuarantinedMessage{}, quarantine.samples[cluster]...)
+}
+
+// Forget what was quarantined for a cluster
+func (quarantine *OffsetQuarantine) Clear(cluster string) {
+	if quarantine == nil {
+		return
+	}
+	quarantine.lock.Lock()
+	defer quarantine.lock.Unlock()
+	delete(quarantine.counts, cluster)
+	delete(quarantine.samples, cluster)
+}
+
+type HTTPResponseQuarantine struct {
+	Error    bool                        `json:"error"`
+	Message  string                      `json:"message"`
+	Counts   map[string]map[string]int64 `json:"counts"`
+	Messages []*QuarantinedMessage       `json:"messages"`
+	Request  HTTPResponseRequestInfo     `json:"request"`
+}
+
+// Handle GET /v2/admin/quarantine?cluster=(cluster), which returns the offsets topic messages that could not be
+// decoded (for every cluster, if none is given) and needs an admin token, and DELETE, which clears them
+func handleQuarantine(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if (r.Method != "GET") && (r.Method != "DELETE") {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	clusters := make([]string, 0, len(app.Config.Kafka))
+	if cluster := r.URL.Query().Get("cluster"); cluster != "" {
+		if _, ok := app.Config.Kafka[cluster]; !ok {
+			return makeErrorResponse(http.StatusNotFound, "cluster not found", w, r)
+		}
+		clusters = append(clusters, cluster)
+	} else {
+		for cluster := range app.Config.Kafka {
+			if app.AdminAudit.ClusterAllowed(r, cluster) {
+				clusters = append(clusters, cluster)
+			}
+		}
+		sort.Strings(clusters)
+	}
+
+	if r.Method == "DELETE" {
+		return adminAction(app, w, r, "clear offsets quarantine", func() (int, string) {
+			for _, cluster := range clusters {
+				if !app.AdminAudit.ClusterAllowed(r, cluster) {
+					return makeErrorResponse(http.StatusForbidden, "this token cannot manage cluster "+cluster, w, r)
+				}
+			}
+			for _, cluster := range clusters {
+				app.Quarantine.Clear(cluster)
+			}
+			return writeQuarantine(app, w, r, nil, "offsets quarantine cleared")
+		})
+	}
+	if _, ok := app.AdminAudit.Authenticate(r); !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return makeErrorResponse(http.StatusUnauthorized, "an admin token is required", w, r)
+	}
+	return writeQuarantine(app, w, r, clusters, "offsets quarantine returned")
+}
+
+func writeQuarantine(app *ApplicationContext, w http.ResponseWriter, r *http.Request, clusters []string, message string) (int, string) {
+	response := HTTPResponseQuarantine{
+		Error:    false,
+		Message:  message,
+		Counts:   make(map[string]map[string]int64),
+		Messages: make([]*QuarantinedMessage, 0),
+		Request:  makeRequestInfo(r),
+	}
+	for _, cluster := range clusters {
+		if !app.AdminAudit.ClusterAllowed(r, cluster) {
+			continue
+		}
+		counts, messages := app.Quarantine.Cluster(cluster)
+		if len(counts) > 0 {
+			response.Counts[cluster] = counts
+		}
+		response.Messages = append(response.Messages, messages...)
+	}
+
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// Build a message from the offsets topic, writing each value big-endian (strings with an int16 length)
+func offsetsMessagePart(values ...interface{}) []byte {
+	buf := &bytes.Buffer{}
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			binary.Write(buf, binary.BigEndian, int16(len(str)))
+			buf.WriteString(str)
+			continue
+		}
+		binary.Write(buf, binary.BigEndian, value)
+	}
+	return buf.Bytes()
+}
+
+func Test_decodeOffsetsMessage(t *testing.T) {
+	key := offsetsMessagePart(int16(1), "group", "topic", int32(3))
+	tests := []struct {
+		key    []byte
+		value  []byte
+		commit *offsetCommit
+		reason string
+	}{
+		{key, offsetsMessagePart(int16(0), int64(100), "", int64(1000)), &offsetCommit{"group", "topic", 3, 100, 1000}, ""},
+		{key, offsetsMessagePart(int16(1), int64(100), "meta", int64(1000), int64(2000)), &offsetCommit{"group", "topic", 3, 100, 1000}, ""},
+		{key, offsetsMessagePart(int16(2), int64(100), "", int64(1000)), &offsetCommit{"group", "topic", 3, 100, 1000}, ""},
+		{key, offsetsMessagePart(int16(3), int64(100), int32(7), "", int64(1000)), &offsetCommit{"group", "topic", 3, 100, 1000}, ""},
+		{key, nil, nil, ""},
+		{offsetsMessagePart(int16(2), "group"), []byte{0xff}, nil, ""},
+		{offsetsMessagePart(int16(9), "group"), nil, nil, "key version"},
+		{offsetsMessagePart(int16(1), "group", int16(20)), nil, nil, "topic"},
+		{offsetsMessagePart(int16(1), "group", "topic", int32(-1)), nil, nil, "partition"},
+		{key, offsetsMessagePart(int16(4), int64(100)), nil, "value version"},
+		{key, offsetsMessagePart(int16(1), int64(100), "", int32(0)), nil, "timestamp"},
+		{[]byte{0}, nil, nil, "key version"},
+	}
+	for i, test := range tests {
+		commit, err := decodeOffsetsMessage(test.key, test.value)
+		reason := ""
+		if err != nil {
+			reason = err.(*decodeError).reason
+		}
+		if reason != test.reason {
+			t.Errorf("Test %v: expected reason %q, got %q (%v)", i, test.reason, reason, err)
+		}
+		if ((commit == nil) != (test.commit == nil)) || ((commit != nil) && (*commit != *test.commit)) {
+			t.Errorf("Test %v: expected commit %+v, got %+v", i, test.commit, commit)
+		}
+	}
+}
+
+func Test_decodeGroupMetadataMessage(t *testing.T) {
+	key := offsetsMessagePart(int16(2), "group")
+	assignment := offsetsMessagePart(int16(0), int32(1), "topic", int32(2), int32(0), int32(3), int32(0))
+	member := func(version int16, host string) []byte {
+		part := offsetsMessagePart("consumer-1-abc")
+		if version >= 3 {
+			part = append(part, offsetsMessagePart(int16(-1))...)
+		}
+		part = append(part, offsetsMessagePart("consumer-1", host)...)
+		if version >= 1 {
+			part = append(part, offsetsMessagePart(int32(300000))...)
+		}
+		return append(part, offsetsMessagePart(int32(10000), int32(0), int32(len(assignment)), assignment)...)
+	}
+	header := func(version int16, protocolType string) []byte {
+		part := offsetsMessagePart(version, protocolType, int32(5), "range", "consumer-1-abc")
+		if version >= 2 {
+			part = append(part, offsetsMessagePart(int64(1000))...)
+		}
+		return append(part, offsetsMessagePart(int32(1))...)
+	}
+
+	for version := int16(0); version <= 3; version++ {
+		membership, err := decodeGroupMetadataMessage(key, append(header(version, "consumer"), member(version, "/10.0.0.1")...))
+		if err != nil {
+			t.Fatalf("Version %v: cannot decode: %v", version, err)
+		}
+		if (membership.group != "group") || (len(membership.members) != 1) || (membership.members[0].ClientHost != "/10.0.0.1") ||
+			(membership.members[0].ClientId != "consumer-1") || (len(membership.members[0].Assignment["topic"]) != 2) ||
+			(membership.members[0].Assignment["topic"][1] != 3) {
+			t.Errorf("Version %v: expected consumer-1 on /10.0.0.1 with topic:0 and topic:3, got %+v", version, membership.members)
+		}
+	}
+
+	// Deleted groups and other protocols have no members, offset commits and later versions are skipped, and
+	// truncated messages fail
+	if membership, err := decodeGroupMetadataMessage(key, nil); (err != nil) || (len(membership.members) != 0) {
+		t.Errorf("Expected no members for a deleted group, got %+v (%v)", membership, err)
+	}
+	if membership, err := decodeGroupMetadataMessage(key, append(header(3, "connect"), member(3, "host")...)); (err != nil) || (len(membership.members) != 0) {
+		t.Errorf("Expected no members for a connect group, got %+v (%v)", membership, err)
+	}
+	if membership, err := decodeGroupMetadataMessage(offsetsMessagePart(int16(1), "group", "topic", int32(3)), nil); (err != nil) || (membership != nil) {
+		t.Errorf("Expected offset commits to be skipped, got %+v (%v)", membership, err)
+	}
+	if membership, err := decodeGroupMetadataMessage(key, offsetsMessagePart(int16(4))); (err != nil) || (membership != nil) {
+		t.Errorf("Expected version 4 to be skipped, got %+v (%v)", membership, err)
+	}
+	value := append(header(3, "consumer"), member(3, "host")...)
+	if _, err := decodeGroupMetadataMessage(key, value[:len(value)-3]); (err == nil) || (err.(*decodeError).reason != "assignment") {
+		t.Errorf("Expected a truncated message to fail on the assignment, got %v", err)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		garbage := make([]byte, random.Intn(64))
+		random.Read(garbage)
+		decodeGroupMetadataMessage(key, garbage)
+		decodeGroupMetadataMessage(key, value[:random.Intn(len(value)+1)])
+	}
+}
+
+// Random and truncated messages are quarantined rather than crashing the decoder
+func Test_decodeOffsetsMessageRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	key := offsetsMessagePart(int16(1), "group", "topic", int32(3))
+	value := offsetsMessagePart(int16(3), int64(100), int32(7), "metadata", int64(1000))
+	for i := 0; i < 10000; i++ {
+		garbage := make([]byte, random.Intn(64))
+		random.Read(garbage)
+		decodeOffsetsMessage(garbage, garbage)
+		decodeOffsetsMessage(key[:random.Intn(len(key)+1)], value[:random.Intn(len(value)+1)])
+	}
+
+	quarantine := NewOffsetQuarantine(&ApplicationContext{Metrics: NewMetrics()})
+	for i := 0; i < quarantineSamples+10; i++ {
+		_, err := decodeOffsetsMessage(key, value[:5])
+		quarantine.Add("local", 0, int64(i), key, value[:5], err)
+	}
+	counts, messages := quarantine.Cluster("local")
+	if (counts["offset"] != quarantineSamples+10) || (len(messages) != quarantineSamples) || (messages[0].Offset != 10) {
+		t.Errorf("Expected %v quarantined for offset, keeping the last %v, got %v and %v starting at %v", quarantineSamples+10,
+			quarantineSamples, counts, len(messages), messages[0].Offset)
+	}
+}
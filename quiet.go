@@ -0,0 +1,73 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"github.com/linkedin/burrow/storage"
+	"time"
+)
+
+// Quiet hours for a notifier, when WARN statuses aren't sent (ERR and worse still are). Quiet hours start at each time
+// that matches the cron expression and last for the duration, and with weekends set, all of Saturday and Sunday are
+// quiet as well. Times are in the timezone, or local time if it isn't set
+type QuietHours struct {
+	schedule *storage.CronSchedule
+	duration time.Duration
+	weekends bool
+	location *time.Location
+}
+
+// Return the quiet hours, or nil if neither a schedule nor weekends are set
+func NewQuietHours(schedule string, duration int64, weekends bool, timezone string) (*QuietHours, error) {
+	if (schedule == "") && !weekends {
+		return nil, nil
+	}
+	quiet := &QuietHours{
+		duration: time.Duration(duration) * time.Second,
+		weekends: weekends,
+		location: time.Local,
+	}
+	if schedule != "" {
+		var err error
+		if quiet.schedule, err = storage.ParseCronSchedule(schedule); err != nil {
+			return nil, err
+		}
+	}
+	if timezone != "" {
+		var err error
+		if quiet.location, err = time.LoadLocation(timezone); err != nil {
+			return nil, err
+		}
+	}
+	return quiet, nil
+}
+
+// Return whether t is in quiet hours. Nil quiet hours are never quiet
+func (quiet *QuietHours) Quiet(t time.Time) bool {
+	if quiet == nil {
+		return false
+	}
+	t = t.In(quiet.location)
+	if quiet.weekends && ((t.Weekday() == time.Saturday) || (t.Weekday() == time.Sunday)) {
+		return true
+	}
+	if quiet.schedule != nil {
+		if start := quiet.schedule.Prev(t); !start.IsZero() && t.Before(start.Add(quiet.duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return whether a notification for the status isn't sent at t. Only WARN is held back
+func (quiet *QuietHours) Suppress(status storage.StatusConstant, t time.Time) bool {
+	return (status == storage.StatusWarning) && quiet.Quiet(t)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/linkedin/burrow/storage"
+)
+
+func Test_quietHours(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	// 3rd March 2021 is a Wednesday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, time.March, day, hour, minute, 0, 0, berlin).UTC()
+	}
+	nightly, err := NewQuietHours("0 22 * * *", 32400, false, "Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Cannot create quiet hours: %v", err)
+	}
+	weekends, err := NewQuietHours("0 22 * * *", 32400, true, "Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Cannot create quiet hours: %v", err)
+	}
+
+	tests := []struct {
+		quiet    *QuietHours
+		t        time.Time
+		expected bool
+	}{
+		{nightly, at(3, 21, 59), false},
+		{nightly, at(3, 22, 0), true},
+		{nightly, at(4, 6, 59), true},
+		{nightly, at(4, 7, 0), false},
+		{nightly, at(6, 12, 0), false},
+		{weekends, at(6, 12, 0), true},
+		{weekends, at(7, 23, 59), true},
+		{weekends, at(8, 12, 0), false},
+		{nil, at(3, 23, 0), false},
+	}
+	for i, test := range tests {
+		if quiet := test.quiet.Quiet(test.t); quiet != test.expected {
+			t.Errorf("Test %v: expected quiet to be %v at %v, got %v", i, test.expected, test.t, quiet)
+		}
+	}
+
+	if !nightly.Suppress(storage.StatusWarning, at(3, 23, 0)) {
+		t.Errorf("Expected WARN to be held back in quiet hours")
+	}
+	if nightly.Suppress(storage.StatusError, at(3, 23, 0)) || nightly.Suppress(storage.StatusWarning, at(3, 12, 0)) {
+		t.Errorf("Expected ERR, and WARN outside quiet hours, to be sent")
+	}
+}
+
+func Test_newQuietHours(t *testing.T) {
+	if quiet, err := NewQuietHours("", 3600, false, "Europe/Berlin"); (quiet != nil) || (err != nil) {
+		t.Errorf("Expected no quiet hours without a schedule or weekends, got %+v (%v)", quiet, err)
+	}
+	if _, err := NewQuietHours("not a schedule", 3600, false, ""); err == nil {
+		t.Errorf("Expected an error for a bad schedule")
+	}
+	if _, err := NewQuietHours("0 22 * * *", 3600, false, "Nowhere/Special"); err == nil {
+		t.Errorf("Expected an error for an unknown timezone")
+	}
+}
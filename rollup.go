@@ -0,0 +1,147 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+// A logical group of topics, such as hundreds of per-tenant topics, that a consumer's status can be rolled up into
+type TopicGroup struct {
+	Name   string
+	Topics []*regexp.Regexp
+}
+
+// The roll-up of a consumer's partitions for one topic group. Topics that are not in any topic group get a roll-up of
+// their own, named for the topic
+type TopicGroupStatus struct {
+	Name            string                   `json:"name"`
+	Status          storage.StatusConstant   `json:"status"`
+	Topics          []string                 `json:"topics"`
+	TotalPartitions int                      `json:"partition_count"`
+	TotalLag        uint64                   `json:"totallag"`
+	Maxlag          *storage.PartitionStatus `json:"maxlag"`
+}
+
+type HTTPResponseConsumerRollup struct {
+	Error    bool                    `json:"error"`
+	Message  string                  `json:"message"`
+	Status   storage.StatusConstant  `json:"status"`
+	Complete bool                    `json:"complete"`
+	Rollups  []*TopicGroupStatus     `json:"rollups"`
+	Request  HTTPResponseRequestInfo `json:"request"`
+}
+
+// Compile the topic groups from the config, sorted by name. The regular expressions were already checked when the
+// config was validated
+func loadTopicGroups(config *BurrowConfig) []*TopicGroup {
+	names := make([]string, 0, len(config.TopicGroup))
+	for name := range config.TopicGroup {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	topicGroups := make([]*TopicGroup, len(names))
+	for i, name := range names {
+		topicGroups[i] = &TopicGroup{Name: name, Topics: make([]*regexp.Regexp, len(config.TopicGroup[name].Topics))}
+		for j, topic := range config.TopicGroup[name].Topics {
+			topicGroups[i].Topics[j] = regexp.MustCompile(topic)
+		}
+	}
+	return topicGroups
+}
+
+// Return the name of the topic group that a topic is in. If it matches more than one, the first by name is used, and
+// if it matches none, the topic is its own group
+func topicGroupName(topicGroups []*TopicGroup, topic string) string {
+	for _, topicGroup := range topicGroups {
+		for _, re := range topicGroup.Topics {
+			if re.MatchString(topic) {
+				return topicGroup.Name
+			}
+		}
+	}
+	return topic
+}
+
+// Roll up the partitions of a consumer group status (which must have all partitions) by topic group. The status of
+// each roll-up is worked out the same way as the status of the consumer group
+func rollupStatus(topicGroups []*TopicGroup, status *storage.ConsumerGroupStatus) []*TopicGroupStatus {
+	rollups := make(map[string]*TopicGroupStatus)
+	topicsSeen := make(map[string]bool)
+	for _, partition := range status.Partitions {
+		name := topicGroupName(topicGroups, partition.Topic)
+		rollup, ok := rollups[name]
+		if !ok {
+			rollup = &TopicGroupStatus{Name: name, Status: storage.StatusOK, Topics: make([]string, 0)}
+			rollups[name] = rollup
+		}
+		if !topicsSeen[partition.Topic] {
+			topicsSeen[partition.Topic] = true
+			rollup.Topics = append(rollup.Topics, partition.Topic)
+		}
+
+		rollup.TotalPartitions++
+		rollup.TotalLag += uint64(partition.End.Lag)
+		if (rollup.Maxlag == nil) || (partition.End.Lag > rollup.Maxlag.End.Lag) {
+			rollup.Maxlag = partition
+		}
+		switch partition.Status {
+		case storage.StatusOK:
+		case storage.StatusWarning:
+			if rollup.Status == storage.StatusOK {
+				rollup.Status = storage.StatusWarning
+			}
+		default:
+			rollup.Status = storage.StatusError
+		}
+	}
+
+	result := make([]*TopicGroupStatus, 0, len(rollups))
+	for _, rollup := range rollups {
+		sort.Strings(rollup.Topics)
+		result = append(result, rollup)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func handleConsumerRollup(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	result := fetchConsumerStatus(app, cluster, group, true, false)
+	if result == nil {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	if result.Status == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = cluster
+	requestInfo.Group = group
+	jsonStr, err := json.Marshal(HTTPResponseConsumerRollup{
+		Error:    false,
+		Message:  "consumer group topic roll-up returned",
+		Status:   result.Status,
+		Complete: result.Complete,
+		Rollups:  rollupStatus(app.TopicGroups, result),
+		Request:  requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
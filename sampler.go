@@ -0,0 +1,218 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// One sampled commit. Every field is always present and has a fixed type, so the files can be loaded with
+// sampledCommitSchema as Avro, or converted to Parquet, without any cleaning
+type SampledCommit struct {
+	Cluster         string `json:"cluster"`
+	Group           string `json:"group"`
+	Topic           string `json:"topic"`
+	Partition       int32  `json:"partition"`
+	Offset          int64  `json:"offset"`
+	CommitTimestamp int64  `json:"commit_timestamp"`
+	SampledAt       int64  `json:"sampled_at"`
+}
+
+// The Avro schema for SampledCommit, written next to the sample files
+const sampledCommitSchema = `{
+  "type": "record",
+  "name": "SampledCommit",
+  "namespace": "com.linkedin.burrow",
+  "fields": [
+    {"name": "cluster", "type": "string"},
+    {"name": "group", "type": "string"},
+    {"name": "topic", "type": "string"},
+    {"name": "partition", "type": "int"},
+    {"name": "offset", "type": "long"},
+    {"name": "commit_timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "sampled_at", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+  ]
+}
+`
+
+// The commit sampler writes a sample of the accepted commits to files for long term analytics, as one JSON record per
+// line. Either every Nth commit is sampled, or the first commit for each partition of each group in every interval.
+// Each file is named with the prefix and the time it was started, and a new one is started when it reaches max-size.
+// As with the audit log, records are written by a single goroutine, so sampling a commit never waits on disk
+type CommitSampler struct {
+	app      *ApplicationContext
+	records  chan *SampledCommit
+	count    uint64
+	last     map[string]int64
+	lastLock sync.Mutex
+	file     *os.File
+	fileSize int64
+	done     chan struct{}
+	stopped  bool
+	stopLock sync.RWMutex
+}
+
+func NewCommitSampler(app *ApplicationContext) (*CommitSampler, error) {
+	sampler := &CommitSampler{
+		app:     app,
+		records: make(chan *SampledCommit, 10000),
+		last:    make(map[string]int64),
+		done:    make(chan struct{}),
+	}
+	if err := ioutil.WriteFile(app.Config.Sample.Prefix+"schema.avsc", []byte(sampledCommitSchema), 0644); err != nil {
+		return nil, err
+	}
+	if err := sampler.openFile(); err != nil {
+		return nil, err
+	}
+
+	go sampler.writer()
+	return sampler, nil
+}
+
+// Sample an accepted offset commit. This is one of the storage module's commit hooks
+func (sampler *CommitSampler) Record(offset *storage.PartitionOffset) {
+	now := time.Now().Unix() * 1000
+	if sampler.app.Config.Sample.Every > 0 {
+		if atomic.AddUint64(&sampler.count, 1)%uint64(sampler.app.Config.Sample.Every) != 0 {
+			return
+		}
+	} else {
+		key := fmt.Sprintf("%s/%s/%s/%v", offset.Cluster, offset.Group, offset.Topic, offset.Partition)
+		sampler.lastLock.Lock()
+		if now-sampler.last[key] < sampler.app.Config.Sample.Interval*1000 {
+			sampler.lastLock.Unlock()
+			return
+		}
+		sampler.last[key] = now
+		sampler.lastLock.Unlock()
+	}
+
+	sampler.stopLock.RLock()
+	defer sampler.stopLock.RUnlock()
+	if sampler.stopped {
+		return
+	}
+	sampler.records <- &SampledCommit{
+		Cluster:         offset.Cluster,
+		Group:           offset.Group,
+		Topic:           offset.Topic,
+		Partition:       offset.Partition,
+		Offset:          offset.Offset,
+		CommitTimestamp: offset.Timestamp,
+		SampledAt:       now,
+	}
+}
+
+func (sampler *CommitSampler) writer() {
+	defer close(sampler.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case record, ok := <-sampler.records:
+			if !ok {
+				return
+			}
+			sampler.write(record)
+		case <-ticker.C:
+			sampler.prune()
+		}
+	}
+}
+
+func (sampler *CommitSampler) write(record *SampledCommit) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Cannot encode sampled commit: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if (sampler.file != nil) && (sampler.fileSize+int64(len(line)) > sampler.app.Config.Sample.MaxSize*1024*1024) {
+		sampler.file.Close()
+		sampler.file = nil
+	}
+	if sampler.file == nil {
+		// Either the file reached max-size, or a new one could not be started for the last record
+		if err := sampler.openFile(); err != nil {
+			log.Errorf("Cannot start a new sample file: %v", err)
+			return
+		}
+	}
+	written, err := sampler.file.Write(line)
+	sampler.fileSize += int64(written)
+	if err != nil {
+		log.Errorf("Cannot write sampled commit to %s: %v", sampler.file.Name(), err)
+	}
+}
+
+// Start a new file, and remove the oldest ones if there are more than max-files
+func (sampler *CommitSampler) openFile() error {
+	prefix := sampler.app.Config.Sample.Prefix
+	filename := prefix + time.Now().UTC().Format("20060102T150405.000") + ".json"
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	sampler.file = file
+	sampler.fileSize = 0
+
+	if sampler.app.Config.Sample.MaxFiles > 0 {
+		files, _ := filepath.Glob(prefix + "*.json")
+		sort.Strings(files)
+		for len(files) > sampler.app.Config.Sample.MaxFiles {
+			if err := os.Remove(files[0]); err != nil {
+				log.Errorf("Cannot remove old sample file %s: %v", files[0], err)
+			}
+			files = files[1:]
+		}
+	}
+	return nil
+}
+
+// Forget the partitions that haven't been sampled for longer than groups are kept, so groups that have gone away don't
+// build up. This only matters when sampling by interval
+func (sampler *CommitSampler) prune() {
+	cutoff := time.Now().Unix()*1000 - sampler.app.Config.Lagcheck.ExpireGroup*1000
+	sampler.lastLock.Lock()
+	for key, sampled := range sampler.last {
+		if sampled < cutoff {
+			delete(sampler.last, key)
+		}
+	}
+	sampler.lastLock.Unlock()
+}
+
+// Stop the sampler, after writing any records that are waiting. This should be called after the storage module is
+// stopped, so that nothing else is sampled
+func (sampler *CommitSampler) Stop() {
+	sampler.stopLock.Lock()
+	sampler.stopped = true
+	close(sampler.records)
+	sampler.stopLock.Unlock()
+	<-sampler.done
+
+	if sampler.file != nil {
+		sampler.file.Close()
+	}
+}
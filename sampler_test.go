@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkedin/burrow/storage"
+)
+
+// The config for a sampler that writes to a new directory, which is removed when the test ends
+func testSamplerApp(t *testing.T) (*ApplicationContext, string) {
+	dir, err := ioutil.TempDir("", "burrow-samples")
+	if err != nil {
+		t.Fatalf("Cannot create dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	app := &ApplicationContext{Config: &BurrowConfig{}}
+	app.Config.Sample.Prefix = filepath.Join(dir, "commits-")
+	app.Config.Sample.MaxSize = 100
+	app.Config.Lagcheck.ExpireGroup = 600
+	return app, dir
+}
+
+// Read the sampled commits from every sample file
+func readSamples(t *testing.T, app *ApplicationContext) []*SampledCommit {
+	files, _ := filepath.Glob(app.Config.Sample.Prefix + "*.json")
+	samples := make([]*SampledCommit, 0)
+	for _, filename := range files {
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Cannot read sample file: %v", err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+			if line == "" {
+				continue
+			}
+			sample := &SampledCommit{}
+			if err := json.Unmarshal([]byte(line), sample); err != nil {
+				t.Fatalf("Cannot decode sample %q: %v", line, err)
+			}
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func Test_commitSamplerEvery(t *testing.T) {
+	app, _ := testSamplerApp(t)
+	app.Config.Sample.Every = 3
+	sampler, err := NewCommitSampler(app)
+	if err != nil {
+		t.Fatalf("Cannot start sampler: %v", err)
+	}
+	for offset := int64(1); offset <= 7; offset++ {
+		sampler.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Partition: 1, Offset: offset, Timestamp: offset * 1000})
+	}
+	sampler.Stop()
+
+	samples := readSamples(t, app)
+	if (len(samples) != 2) || (samples[0].Offset != 3) || (samples[1].Offset != 6) {
+		t.Fatalf("Expected every third commit to be sampled, got %+v", samples)
+	}
+	if (samples[0].Cluster != "local") || (samples[0].Group != "payments") || (samples[0].Topic != "orders") ||
+		(samples[0].Partition != 1) || (samples[0].CommitTimestamp != 3000) || (samples[0].SampledAt == 0) {
+		t.Errorf("Unexpected sample %+v", samples[0])
+	}
+	if schema, err := ioutil.ReadFile(app.Config.Sample.Prefix + "schema.avsc"); (err != nil) || (string(schema) != sampledCommitSchema) {
+		t.Errorf("Expected the Avro schema next to the samples (%v)", err)
+	}
+}
+
+// Without every, the first commit for each partition in each interval is sampled
+func Test_commitSamplerInterval(t *testing.T) {
+	app, _ := testSamplerApp(t)
+	app.Config.Sample.Interval = 60
+	sampler, err := NewCommitSampler(app)
+	if err != nil {
+		t.Fatalf("Cannot start sampler: %v", err)
+	}
+	sampler.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Partition: 0, Offset: 10})
+	sampler.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Partition: 0, Offset: 11})
+	sampler.Record(&storage.PartitionOffset{Cluster: "local", Group: "payments", Topic: "orders", Partition: 1, Offset: 20})
+	sampler.Record(&storage.PartitionOffset{Cluster: "local", Group: "billing", Topic: "orders", Partition: 0, Offset: 30})
+	sampler.Stop()
+
+	samples := readSamples(t, app)
+	if (len(samples) != 3) || (samples[0].Offset != 10) || (samples[1].Offset != 20) || (samples[2].Offset != 30) {
+		t.Errorf("Expected the first commit of each partition to be sampled, got %+v", samples)
+	}
+}
+
+func Test_commitSamplerPrune(t *testing.T) {
+	app, _ := testSamplerApp(t)
+	sampler := &CommitSampler{app: app, last: map[string]int64{
+		"local/gone/orders/0":   time.Now().Add(-time.Hour).Unix() * 1000,
+		"local/active/orders/0": time.Now().Unix() * 1000,
+	}}
+	sampler.prune()
+	if _, ok := sampler.last["local/gone/orders/0"]; ok {
+		t.Errorf("Expected a partition not sampled for longer than expire-group to be forgotten")
+	}
+	if _, ok := sampler.last["local/active/orders/0"]; !ok {
+		t.Errorf("Expected a recently sampled partition to be kept")
+	}
+}
+
+// Only max-files sample files are kept, removing the oldest
+func Test_commitSamplerMaxFiles(t *testing.T) {
+	app, dir := testSamplerApp(t)
+	app.Config.Sample.MaxFiles = 2
+	for _, name := range []string{"commits-20000101T000000.000.json", "commits-20000102T000000.000.json"} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	sampler, err := NewCommitSampler(app)
+	if err != nil {
+		t.Fatalf("Cannot start sampler: %v", err)
+	}
+	sampler.Stop()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "commits-*.json"))
+	if (len(files) != 2) || (filepath.Base(files[0]) != "commits-20000102T000000.000.json") {
+		t.Errorf("Expected the oldest file to be removed, got %v", files)
+	}
+}
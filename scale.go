@@ -0,0 +1,144 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type HTTPResponseScaleHint struct {
+	Error               bool                    `json:"error"`
+	Message             string                  `json:"message"`
+	Status              storage.StatusConstant  `json:"status"`
+	TotalLag            uint64                  `json:"totallag"`
+	ConsumeRate         float64                 `json:"consume_rate"`
+	ProduceRate         float64                 `json:"produce_rate"`
+	Target              int64                   `json:"target"`
+	EstimatedCatchUp    int64                   `json:"estimated_catch_up"`
+	Partitions          int                     `json:"partition_count"`
+	Replicas            int                     `json:"replicas"`
+	RecommendedReplicas int                     `json:"recommended_replicas"`
+	ReplicaDelta        int                     `json:"replica_delta"`
+	Request             HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/kafka/(cluster)/consumer/(group)/scale-hint?target=(duration)&replicas=(N), for autoscalers. The
+// consume rate is measured over the offsets in each partition's window, and the produce rate over the broker offset
+// history of the group's topics (both in messages per second). The recommended replicas are how many consumers,
+// each consuming at the rate the current ones do, it takes to keep up with the produce rate and work off the lag
+// within the target time (5m by default). It is never more than the number of partitions, as more consumers than that
+// would sit idle, or less than 1. replicas is the current number of consumers (1 by default)
+func handleConsumerScaleHint(app *ApplicationContext, w http.ResponseWriter, r *http.Request, cluster string, group string) (int, string) {
+	target := 5 * time.Minute
+	if param := r.URL.Query().Get("target"); param != "" {
+		var err error
+		if target, err = time.ParseDuration(param); (err != nil) || (target < time.Second) {
+			return makeErrorResponse(http.StatusBadRequest, "target must be a duration of at least 1s, such as 5m", w, r)
+		}
+	}
+	replicas := 1
+	if param := r.URL.Query().Get("replicas"); param != "" {
+		var err error
+		if replicas, err = strconv.Atoi(param); (err != nil) || (replicas < 1) {
+			return makeErrorResponse(http.StatusBadRequest, "replicas must be a positive number", w, r)
+		}
+	}
+
+	result := fetchConsumerStatus(app, cluster, group, true, false)
+	if result == nil {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	if result.Status == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+
+	consumeRate := float64(0)
+	topics := make(map[string]bool)
+	for _, partition := range result.Partitions {
+		topics[partition.Topic] = true
+		if partition.End.Timestamp > partition.Start.Timestamp {
+			consumeRate += float64(partition.End.Offset-partition.Start.Offset) * 1000 / float64(partition.End.Timestamp-partition.Start.Timestamp)
+		}
+	}
+	produceRate := float64(0)
+	for topic := range topics {
+		storageRequest := &storage.RequestTopicRate{Result: make(chan *storage.ResponseTopicRate), Cluster: cluster, Topic: topic}
+		if !sendStorageRequest(app, storageRequest) {
+			return makeOverloadedResponse(app, storageBusyReason, w, r)
+		}
+		if rate := <-storageRequest.Result; !rate.ErrorTopic {
+			produceRate += rate.TotalRate
+		}
+	}
+
+	response := HTTPResponseScaleHint{
+		Error:            false,
+		Message:          "consumer group scale hint returned",
+		Status:           result.Status,
+		TotalLag:         result.TotalLag,
+		ConsumeRate:      consumeRate,
+		ProduceRate:      produceRate,
+		Target:           int64(target.Seconds()),
+		EstimatedCatchUp: estimateCatchUp(float64(result.TotalLag), consumeRate, produceRate),
+		Partitions:       result.TotalPartitions,
+		Replicas:         replicas,
+	}
+	response.RecommendedReplicas = recommendReplicas(float64(result.TotalLag), consumeRate, produceRate, target.Seconds(),
+		replicas, result.TotalPartitions)
+	response.ReplicaDelta = response.RecommendedReplicas - replicas
+
+	response.Request = makeRequestInfo(r)
+	response.Request.Cluster = cluster
+	response.Request.Group = group
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
+
+// Return how many seconds it will take to work off the lag at the current rates, or -1 if the group isn't catching up
+func estimateCatchUp(lag float64, consumeRate float64, produceRate float64) int64 {
+	if lag == 0 {
+		return 0
+	}
+	if consumeRate <= produceRate {
+		return -1
+	}
+	return int64(math.Ceil(lag / (consumeRate - produceRate)))
+}
+
+// Return the number of replicas needed to keep up with the produce rate and work off the lag within target seconds,
+// between 1 and the number of partitions. If the group isn't consuming, the rate of a replica isn't known, so one more
+// replica is recommended if there is lag
+func recommendReplicas(lag float64, consumeRate float64, produceRate float64, target float64, replicas int, partitions int) int {
+	recommended := replicas
+	if consumeRate > 0 {
+		needed := produceRate + lag/target
+		recommended = int(math.Ceil(needed / (consumeRate / float64(replicas))))
+	} else if lag > 0 {
+		recommended = replicas + 1
+	}
+
+	if (partitions > 0) && (recommended > partitions) {
+		recommended = partitions
+	}
+	if recommended < 1 {
+		recommended = 1
+	}
+	return recommended
+}
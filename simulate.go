@@ -0,0 +1,98 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+)
+
+// Any parameters that are left out of the request keep their configured values
+type HTTPRequestSimulate struct {
+	Cluster string                    `json:"cluster"`
+	Group   string                    `json:"group"`
+	Params  *storage.EvaluationParams `json:"params"`
+	Showall bool                      `json:"showall"`
+	Trace   bool                      `json:"trace"`
+}
+type HTTPResponseSimulate struct {
+	Error   bool                         `json:"error"`
+	Message string                       `json:"message"`
+	Params  *storage.EvaluationParams    `json:"params"`
+	Status  *storage.ConsumerGroupStatus `json:"status"`
+	Current *storage.ConsumerGroupStatus `json:"current"`
+	Request HTTPResponseRequestInfo      `json:"request"`
+}
+
+// Evaluate a group over its stored offsets with other rule parameters, and return that status along with the current
+// one, so thresholds can be tuned without redeploying
+func handleSimulate(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "POST" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	body := HTTPRequestSimulate{Params: app.Storage.DefaultEvaluationParams()}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return makeErrorResponse(http.StatusBadRequest, "could not decode request body", w, r)
+	}
+	if _, ok := app.Config.Kafka[body.Cluster]; !ok {
+		return makeErrorResponse(http.StatusNotFound, "cluster not found", w, r)
+	}
+	if body.Params == nil {
+		// An explicit null is the same as leaving the parameters out
+		body.Params = app.Storage.DefaultEvaluationParams()
+	}
+	if err := app.Storage.ValidateEvaluationParams(body.Params); err != nil {
+		return makeErrorResponse(http.StatusBadRequest, err.Error(), w, r)
+	}
+	if reason := app.Storage.Overloaded(body.Cluster, app.Config.Httpserver.OverloadThreshold); reason != "" {
+		return makeOverloadedResponse(app, reason, w, r)
+	}
+
+	storageRequest := &storage.RequestConsumerStatus{
+		Result:  make(chan *storage.ConsumerGroupStatus),
+		Cluster: body.Cluster,
+		Group:   body.Group,
+		Showall: body.Showall,
+		Trace:   body.Trace,
+		Params:  body.Params,
+	}
+	if !sendStorageRequest(app, storageRequest) {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	result := <-storageRequest.Result
+	if result.Status == storage.StatusNotFound {
+		return makeErrorResponse(http.StatusNotFound, "consumer group not found", w, r)
+	}
+	current := fetchConsumerStatus(app, body.Cluster, body.Group, body.Showall, false)
+	if current == nil {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+
+	requestInfo := makeRequestInfo(r)
+	requestInfo.Cluster = body.Cluster
+	requestInfo.Group = body.Group
+	jsonStr, err := json.Marshal(HTTPResponseSimulate{
+		Error:   false,
+		Message: "simulated consumer group status returned",
+		Params:  body.Params,
+		Status:  result,
+		Current: current,
+		Request: requestInfo,
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
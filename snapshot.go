@@ -0,0 +1,361 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A client for an S3 compatible object store, with the bucket in the path of the URL (such as
+// https://s3.us-east-1.amazonaws.com/bucket). Requests are signed with AWS signature version 4, which GCS also accepts
+// with HMAC keys at https://storage.googleapis.com/bucket. Only the few calls that snapshots need are supported
+type ObjectStore struct {
+	bucketUrl *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// An object in a listing
+type StoredObject struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+func NewObjectStore(app *ApplicationContext) (*ObjectStore, error) {
+	cfg := app.Config.Storage
+	bucketUrl, err := url.Parse(strings.TrimSuffix(cfg.ObjectStoreUrl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	accessKey, secretKey := cfg.ObjectStoreAccessKey, cfg.ObjectStoreSecretKey
+	if accessKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if (accessKey == "") || (secretKey == "") {
+		return nil, errors.New("no object store credentials in the config or the environment")
+	}
+
+	return &ObjectStore{
+		bucketUrl: bucketUrl,
+		region:    cfg.ObjectStoreRegion,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client: &http.Client{Timeout: 5 * time.Minute, Transport: &http.Transport{
+			DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: newTLSConfig(),
+		}},
+	}, nil
+}
+
+func (store *ObjectStore) Put(key string, body []byte) error {
+	resp, err := store.do("PUT", key, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Write an object to w
+func (store *ObjectStore) Get(key string, w io.Writer) error {
+	resp, err := store.do("GET", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (store *ObjectStore) Delete(key string) error {
+	resp, err := store.do("DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List every object with a key that starts with prefix, following the listing across pages
+func (store *ObjectStore) List(prefix string) ([]*StoredObject, error) {
+	var objects []*StoredObject
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := store.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var listing struct {
+			Contents              []*StoredObject `xml:"Contents"`
+			IsTruncated           bool            `xml:"IsTruncated"`
+			NextContinuationToken string          `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&listing)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode object listing: %v", err)
+		}
+		objects = append(objects, listing.Contents...)
+		if !listing.IsTruncated || (listing.NextContinuationToken == "") {
+			return objects, nil
+		}
+		query.Set("continuation-token", listing.NextContinuationToken)
+	}
+}
+
+// Make a signed request for an object (or the bucket, if key is empty). The response is only returned if it is a 2xx
+func (store *ObjectStore) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	objectUrl := *store.bucketUrl
+	if key != "" {
+		objectUrl.Path += "/" + key
+	}
+	objectUrl.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	req, err := http.NewRequest(method, objectUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	store.sign(req, body, time.Now().UTC())
+
+	resp, err := store.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, objectUrl.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Add AWS signature version 4 headers to a request
+func (store *ObjectStore) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// The signed headers are lowercased and sorted, and host is always one of them
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + store.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+store.secretKey), now.Format("20060102"))
+	for _, part := range []string{store.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		store.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// The snapshot uploader copies the file storage backend's checkpoint to an object store every upload-interval
+// seconds, as an object named with the prefix and the time it was uploaded, and removes the snapshots that are older
+// than upload-retention. A replacement Burrow (in another zone, say) started with --restore-from-object-store loads the
+// newest snapshot, and so starts with the offset history rather than without it
+type SnapshotUploader struct {
+	app   *ApplicationContext
+	store *ObjectStore
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func NewSnapshotUploader(app *ApplicationContext) (*SnapshotUploader, error) {
+	store, err := NewObjectStore(app)
+	if err != nil {
+		return nil, err
+	}
+	uploader := &SnapshotUploader{
+		app:   app,
+		store: store,
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	app.Metrics.Register("burrow_snapshot_upload_errors_total", MetricCounter, "Storage snapshots that could not be uploaded to the object store")
+
+	go uploader.run()
+	return uploader, nil
+}
+
+func (uploader *SnapshotUploader) run() {
+	defer close(uploader.done)
+
+	ticker := time.NewTicker(time.Duration(uploader.app.Config.Storage.UploadInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := uploader.upload(); err != nil {
+				log.Errorf("Cannot upload storage snapshot: %v", err)
+				uploader.app.Metrics.Add("burrow_snapshot_upload_errors_total", nil, 1)
+				continue
+			}
+			if err := uploader.expire(); err != nil {
+				log.Errorf("Cannot remove expired storage snapshots: %v", err)
+			}
+		case <-uploader.quit:
+			return
+		}
+	}
+}
+
+func (uploader *SnapshotUploader) upload() error {
+	data, err := ioutil.ReadFile(uploader.app.Config.Storage.Path)
+	if os.IsNotExist(err) {
+		// No checkpoint has been saved yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key := uploader.app.Config.Storage.ObjectStorePrefix + time.Now().UTC().Format("20060102T150405") + "." +
+		uploader.app.Config.Storage.Codec
+	if err := uploader.store.Put(key, data); err != nil {
+		return err
+	}
+	log.Debugf("Uploaded storage snapshot %s", key)
+	return nil
+}
+
+// Remove the snapshots older than upload-retention. The newest snapshot is always kept, however old it is
+func (uploader *SnapshotUploader) expire() error {
+	snapshots, err := listSnapshots(uploader.store, uploader.app.Config.Storage.ObjectStorePrefix)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) <= 1 {
+		// Nothing has been uploaded yet (there was no checkpoint to upload), or there is only the newest snapshot
+		return nil
+	}
+	cutoff := time.Now().Add(-time.Duration(uploader.app.Config.Storage.UploadRetention) * time.Second)
+	for _, snapshot := range snapshots[:len(snapshots)-1] {
+		if snapshot.LastModified.Before(cutoff) {
+			if err := uploader.store.Delete(snapshot.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (uploader *SnapshotUploader) Stop() {
+	close(uploader.quit)
+	<-uploader.done
+}
+
+// List the snapshots under the prefix, oldest first. The keys are named with the time they were uploaded, so they
+// sort by age. Snapshots saved with any codec are listed, as the codec can change
+func listSnapshots(store *ObjectStore, prefix string) ([]*StoredObject, error) {
+	objects, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*StoredObject, 0, len(objects))
+	for _, object := range objects {
+		for _, codec := range storage.Codecs {
+			if strings.HasSuffix(object.Key, "."+codec.Name()) {
+				snapshots = append(snapshots, object)
+				break
+			}
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Key < snapshots[j].Key })
+	return snapshots, nil
+}
+
+// Download the newest snapshot from the object store to the file backend's path, so the storage module loads it when
+// it starts. If there are no snapshots, the existing file (if any) is left alone
+func restoreSnapshot(app *ApplicationContext) error {
+	store, err := NewObjectStore(app)
+	if err != nil {
+		return err
+	}
+	snapshots, err := listSnapshots(store, app.Config.Storage.ObjectStorePrefix)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		log.Warnf("There are no storage snapshots in the object store to restore")
+		return nil
+	}
+	newest := snapshots[len(snapshots)-1]
+
+	path := app.Config.Storage.Path
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = store.Get(newest.Key, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	log.Infof("Restored storage snapshot %s to %s", newest.Key, path)
+	return os.Rename(path+".tmp", path)
+}
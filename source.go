@@ -0,0 +1,323 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An offset source gets broker and/or consumer offsets for one cluster from somewhere (Kafka, Zookeeper, Storm) and
+// sends them to the storage module. Sources are created for each configured cluster when Burrow starts
+type OffsetSource interface {
+	// Start fetching offsets, sending them on the offsets channel. This should not block
+	Start(offsets chan *storage.PartitionOffset) error
+
+	// Stop fetching offsets and close any connections
+	Stop()
+}
+
+// A registered offset source type. Clusters returns the names of the clusters in the config that this type of source
+// should run for, and New creates (but does not start) the source for one of them
+type OffsetSourceModule struct {
+	Clusters func(config *BurrowConfig) []string
+	New      func(app *ApplicationContext, cluster string) (OffsetSource, error)
+}
+
+// Source modules in the order they were registered, so they are started in a predictable order
+var offsetSourceNames []string
+var offsetSourceModules = make(map[string]*OffsetSourceModule)
+
+// Register a type of offset source. This is meant to be called from an init function
+func RegisterOffsetSource(name string, module *OffsetSourceModule) {
+	if _, ok := offsetSourceModules[name]; ok {
+		panic(fmt.Sprintf("Offset source %s is already registered", name))
+	}
+	offsetSourceNames = append(offsetSourceNames, name)
+	offsetSourceModules[name] = module
+}
+
+// The running offset sources, by type and then cluster. Sources that fail to start, or that are restarted by the
+// watchdog, are retried in the background until they succeed, and the error is kept so it can be shown in the API
+type OffsetSources struct {
+	app         *ApplicationContext
+	running     map[string]map[string]OffsetSource
+	errors      map[string]map[string]string
+	restarts    map[string]map[string]int
+	paused      map[string][]string
+	normalizers map[string]map[string]*timestampNormalizer
+	lock        sync.RWMutex
+	quit        chan struct{}
+	retryGroup  sync.WaitGroup
+}
+
+// Create and start the sources of every registered type for each cluster they are configured for. A cluster that
+// cannot be started (such as one with a bad broker list) does not stop the others from running
+func startOffsetSources(app *ApplicationContext) *OffsetSources {
+	sources := &OffsetSources{
+		app:         app,
+		running:     make(map[string]map[string]OffsetSource, len(offsetSourceNames)),
+		errors:      make(map[string]map[string]string),
+		restarts:    make(map[string]map[string]int),
+		paused:      make(map[string][]string),
+		normalizers: make(map[string]map[string]*timestampNormalizer),
+		quit:        make(chan struct{}),
+	}
+	app.Metrics.Register("burrow_rejected_timestamps_total", MetricCounter, "Offsets dropped because their timestamps could not be right")
+
+	for _, name := range offsetSourceNames {
+		sources.running[name] = make(map[string]OffsetSource)
+		for _, cluster := range offsetSourceModules[name].Clusters(app.Config) {
+			log.Infof("Starting %s offset source for cluster %s", name, cluster)
+			if err := sources.startSource(name, cluster); err != nil {
+				log.Errorf("Cannot start %s offset source for cluster %s, will retry in the background: %v", name, cluster, err)
+				sources.retryGroup.Add(1)
+				go sources.retrySource(name, cluster)
+			}
+		}
+	}
+
+	if app.Config.Watchdog.Timeout > 0 {
+		app.Metrics.Register("burrow_source_restarts_total", MetricCounter, "Offset sources restarted by the watchdog because they were wedged")
+		sources.retryGroup.Add(1)
+		go sources.watchdog()
+	}
+	if app.Config.Dns.Ttl > 0 {
+		sources.retryGroup.Add(1)
+		go sources.dnsRefresh()
+	}
+	return sources
+}
+
+func (sources *OffsetSources) startSource(name string, cluster string) error {
+	source, err := offsetSourceModules[name].New(sources.app, cluster)
+	if err == nil {
+		err = source.Start(sources.timestampChannel(name, cluster))
+		if err != nil {
+			// Clean up whatever was started before the error
+			source.Stop()
+		}
+	}
+
+	sources.lock.Lock()
+	defer sources.lock.Unlock()
+	if err != nil {
+		sources.setError(name, cluster, err.Error())
+		return err
+	}
+	sources.running[name][cluster] = source
+	if _, ok := sources.errors[cluster]; ok {
+		delete(sources.errors[cluster], name)
+		if len(sources.errors[cluster]) == 0 {
+			delete(sources.errors, cluster)
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held
+func (sources *OffsetSources) setError(name string, cluster string, message string) {
+	if _, ok := sources.errors[cluster]; !ok {
+		sources.errors[cluster] = make(map[string]string)
+	}
+	sources.errors[cluster][name] = message
+}
+
+// Keep trying to start a source, backing off from the source-retry interval up to the watchdog max-backoff
+func (sources *OffsetSources) retrySource(name string, cluster string) {
+	defer sources.retryGroup.Done()
+
+	delay := time.Duration(sources.app.Config.Tickers.SourceRetry) * time.Second
+	maxDelay := time.Duration(sources.app.Config.Watchdog.MaxBackoff) * time.Second
+	for {
+		select {
+		case <-sources.quit:
+			return
+		case <-time.After(delay):
+			if sources.isPaused(cluster) {
+				// Keep waiting until the cluster is resumed
+				continue
+			}
+			if err := sources.startSource(name, cluster); err != nil {
+				log.Warnf("Retry of %s offset source for cluster %s failed: %v", name, cluster, err)
+				if delay *= 2; delay > maxDelay {
+					delay = maxDelay
+				}
+				continue
+			}
+			log.Infof("Started %s offset source for cluster %s", name, cluster)
+			return
+		}
+	}
+}
+
+// Return true if the source of the given type for a cluster is running
+func (sources *OffsetSources) IsRunning(name string, cluster string) bool {
+	if sources == nil {
+		return false
+	}
+	sources.lock.RLock()
+	defer sources.lock.RUnlock()
+
+	_, ok := sources.running[name][cluster]
+	return ok
+}
+
+// Return the clusters that each type of source is running for
+func (sources *OffsetSources) Running() map[string][]string {
+	if sources == nil {
+		return nil
+	}
+	sources.lock.RLock()
+	defer sources.lock.RUnlock()
+
+	running := make(map[string][]string, len(sources.running))
+	for name, clusters := range sources.running {
+		running[name] = make([]string, 0, len(clusters))
+		for cluster := range clusters {
+			running[name] = append(running[name], cluster)
+		}
+		sort.Strings(running[name])
+	}
+	return running
+}
+
+// Return the errors for the sources of a cluster that are not running, by source type. If the cluster has no
+// errors, nil is returned
+func (sources *OffsetSources) ClusterErrors(cluster string) map[string]string {
+	if sources == nil {
+		// The HTTP server is started before the sources are
+		return nil
+	}
+	sources.lock.RLock()
+	defer sources.lock.RUnlock()
+
+	if len(sources.errors[cluster]) == 0 {
+		return nil
+	}
+	errors := make(map[string]string, len(sources.errors[cluster]))
+	for name, err := range sources.errors[cluster] {
+		errors[name] = err
+	}
+	return errors
+}
+
+// Return the errors for every cluster that has a source that is not running
+func (sources *OffsetSources) Errors() map[string]map[string]string {
+	if sources == nil {
+		return nil
+	}
+	sources.lock.RLock()
+	clusters := make([]string, 0, len(sources.errors))
+	for cluster := range sources.errors {
+		clusters = append(clusters, cluster)
+	}
+	sources.lock.RUnlock()
+
+	errors := make(map[string]map[string]string, len(clusters))
+	for _, cluster := range clusters {
+		if clusterErrors := sources.ClusterErrors(cluster); clusterErrors != nil {
+			errors[cluster] = clusterErrors
+		}
+	}
+	return errors
+}
+
+// Return how many times each source for a cluster has been restarted by the watchdog. If none have, nil is returned
+func (sources *OffsetSources) ClusterRestarts(cluster string) map[string]int {
+	if sources == nil {
+		return nil
+	}
+	sources.lock.RLock()
+	defer sources.lock.RUnlock()
+
+	if len(sources.restarts[cluster]) == 0 {
+		return nil
+	}
+	restarts := make(map[string]int, len(sources.restarts[cluster]))
+	for name, count := range sources.restarts[cluster] {
+		restarts[name] = count
+	}
+	return restarts
+}
+
+func (sources *OffsetSources) isPaused(cluster string) bool {
+	sources.lock.RLock()
+	defer sources.lock.RUnlock()
+
+	_, ok := sources.paused[cluster]
+	return ok
+}
+
+// Stop the running sources for a cluster, and keep any that are being retried from starting, until the cluster is
+// resumed. Returns the types of the sources that were stopped
+func (sources *OffsetSources) PauseCluster(cluster string) []string {
+	sources.lock.Lock()
+	stopped := make([]string, 0)
+	toStop := make([]OffsetSource, 0)
+	for i := len(offsetSourceNames) - 1; i >= 0; i-- {
+		name := offsetSourceNames[i]
+		if source, ok := sources.running[name][cluster]; ok {
+			delete(sources.running[name], cluster)
+			stopped = append(stopped, name)
+			toStop = append(toStop, source)
+		}
+	}
+	sources.paused[cluster] = stopped
+	sources.lock.Unlock()
+
+	// Stopping a source can take a while, so it is not done with the lock held
+	for i, source := range toStop {
+		log.Infof("Pausing %s offset source for cluster %s", stopped[i], cluster)
+		source.Stop()
+	}
+	return stopped
+}
+
+// Start the sources for a cluster that were stopped when it was paused. Any that fail to start are retried in the
+// background. Returns the types of the sources that were started (or are being retried)
+func (sources *OffsetSources) ResumeCluster(cluster string) []string {
+	sources.lock.Lock()
+	stopped := sources.paused[cluster]
+	delete(sources.paused, cluster)
+	sources.lock.Unlock()
+
+	// Start them in the order they were registered, the reverse of how they were stopped
+	started := make([]string, 0, len(stopped))
+	for i := len(stopped) - 1; i >= 0; i-- {
+		name := stopped[i]
+		log.Infof("Resuming %s offset source for cluster %s", name, cluster)
+		if err := sources.startSource(name, cluster); err != nil {
+			log.Errorf("Cannot resume %s offset source for cluster %s, will retry in the background: %v", name, cluster, err)
+			sources.retryGroup.Add(1)
+			go sources.retrySource(name, cluster)
+		}
+		started = append(started, name)
+	}
+	return started
+}
+
+// Stop retrying and the watchdog, then stop all running sources in the reverse order they were started in
+func (sources *OffsetSources) Stop() {
+	close(sources.quit)
+	sources.retryGroup.Wait()
+
+	for i := len(offsetSourceNames) - 1; i >= 0; i-- {
+		for cluster, source := range sources.running[offsetSourceNames[i]] {
+			log.Infof("Stopping %s offset source for cluster %s", offsetSourceNames[i], cluster)
+			source.Stop()
+		}
+	}
+}
@@ -0,0 +1,300 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A place in the config (or an expected group declared with the API) that names a consumer group, or a group regex.
+// It is stale if no group it names has existed for the configured number of days. Only the time since Burrow started
+// is known, so LastSeen is 0 for a reference that hasn't matched a group since then
+type StaleReference struct {
+	Source   string `json:"source"`
+	Cluster  string `json:"cluster,omitempty"`
+	Group    string `json:"group"`
+	Regex    bool   `json:"regex"`
+	LastSeen int64  `json:"last_seen"`
+	Since    int64  `json:"since"`
+	Stale    bool   `json:"stale"`
+}
+
+// A reference to check, with the clusters to look in (all of them if none are given)
+type groupReference struct {
+	source   string
+	clusters []string
+	group    string
+	regex    *regexp.Regexp
+}
+
+func (reference *groupReference) matches(group string) bool {
+	if reference.regex != nil {
+		return reference.regex.MatchString(group)
+	}
+	return group == reference.group
+}
+
+// The stale config checker looks for group names in the config that no longer match anything, every interval seconds.
+// A config file that names groups which were renamed or decommissioned long ago hides typos in the names of new
+// groups, as a missing group looks the same either way. The references that are checked are:
+//   - the groups of each [email] section
+//   - expected groups, from the config or the API
+//   - the group regexes of [commit-mapping], [rollup-policy], and [notifier-template] sections
+//
+// A reference is stale when no group it names has been in storage for days days. They are logged when they become
+// stale, counted in the burrow_stale_config_references metric, and listed at /v2/burrow/stale-config
+type StaleConfigChecker struct {
+	app        *ApplicationContext
+	references []*groupReference
+	lock       sync.RWMutex
+	results    map[string]*StaleReference
+	quit       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewStaleConfigChecker(app *ApplicationContext) *StaleConfigChecker {
+	checker := &StaleConfigChecker{
+		app:        app,
+		references: configGroupReferences(app.Config),
+		results:    make(map[string]*StaleReference),
+		quit:       make(chan struct{}),
+	}
+	app.Metrics.Register("burrow_stale_config_references", MetricGauge, "Group names in the config that have not matched a group for the configured number of days")
+	return checker
+}
+
+// Return the references to groups in the config. Expected groups from the API are added on each check, as they change
+func configGroupReferences(config *BurrowConfig) []*groupReference {
+	references := make([]*groupReference, 0)
+	for email, cfg := range config.Email {
+		for _, group := range cfg.Groups {
+			parts := strings.SplitN(group, ",", 2)
+			if len(parts) == 2 {
+				references = append(references, &groupReference{source: "email " + email, clusters: []string{parts[0]}, group: parts[1]})
+			}
+		}
+	}
+	for name, cfg := range config.CommitMapping {
+		if re, err := regexp.Compile(cfg.Group); err == nil {
+			references = append(references, &groupReference{source: "commit-mapping " + name, clusters: []string{cfg.DataCluster}, group: cfg.Group, regex: re})
+		}
+	}
+	for name, cfg := range config.RollupPolicy {
+		if re, err := regexp.Compile(cfg.Group); (cfg.Group != "") && (err == nil) {
+			references = append(references, &groupReference{source: "rollup-policy " + name, group: cfg.Group, regex: re})
+		}
+	}
+	for name, cfg := range config.NotifierTemplate {
+		if re, err := regexp.Compile(cfg.Group); (cfg.Group != "") && (err == nil) {
+			references = append(references, &groupReference{source: "notifier-template " + name, clusters: cfg.Clusters, group: cfg.Group, regex: re})
+		}
+	}
+	return references
+}
+
+func (checker *StaleConfigChecker) Start() {
+	checker.wg.Add(1)
+	go func() {
+		defer checker.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(checker.app.Config.StaleConfig.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-checker.quit:
+				return
+			case <-ticker.C:
+				checker.check()
+			}
+		}
+	}()
+}
+
+func (checker *StaleConfigChecker) Stop() {
+	close(checker.quit)
+	checker.wg.Wait()
+}
+
+// Return every reference that was checked, sorted by source and group
+func (checker *StaleConfigChecker) Results() []*StaleReference {
+	checker.lock.RLock()
+	defer checker.lock.RUnlock()
+
+	results := make([]*StaleReference, 0, len(checker.results))
+	for _, result := range checker.results {
+		copied := *result
+		results = append(results, &copied)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Source != results[j].Source {
+			return results[i].Source < results[j].Source
+		}
+		if results[i].Cluster != results[j].Cluster {
+			return results[i].Cluster < results[j].Cluster
+		}
+		return results[i].Group < results[j].Group
+	})
+	return results
+}
+
+func (checker *StaleConfigChecker) check() {
+	clusters := make([]string, 0, len(checker.app.Config.Kafka))
+	for cluster := range checker.app.Config.Kafka {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	groups := make(map[string][]string)
+	references := append([]*groupReference{}, checker.references...)
+	for _, cluster := range clusters {
+		listRequest := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+		expectedRequest := &storage.RequestExpectedGroupList{Result: make(chan []*storage.ExpectedGroup), Cluster: cluster}
+		if !sendStorageRequest(checker.app, listRequest) {
+			log.Warnf("Cannot check for stale config: %s", storageBusyReason)
+			return
+		}
+		groups[cluster] = <-listRequest.Result
+		if !sendStorageRequest(checker.app, expectedRequest) {
+			log.Warnf("Cannot check for stale config: %s", storageBusyReason)
+			return
+		}
+		for _, expected := range <-expectedRequest.Result {
+			references = append(references, &groupReference{source: "expected-group (" + expected.Source + ")",
+				clusters: []string{cluster}, group: expected.Group})
+		}
+	}
+
+	now := time.Now()
+	staleAfter := time.Duration(checker.app.Config.StaleConfig.Days) * 24 * time.Hour
+	seen := make(map[string]bool)
+	staleCount := 0
+
+	checker.lock.Lock()
+	for _, reference := range references {
+		// A reference without clusters is one reference to all of them, rather than one for each
+		scopes := make([][]string, 0, len(reference.clusters))
+		for _, cluster := range reference.clusters {
+			scopes = append(scopes, []string{cluster})
+		}
+		if len(scopes) == 0 {
+			scopes = append(scopes, clusters)
+		}
+		for _, scope := range scopes {
+			cluster := ""
+			if len(reference.clusters) > 0 {
+				cluster = scope[0]
+			}
+			key := reference.source + "|" + cluster + "|" + reference.group
+			seen[key] = true
+			result, ok := checker.results[key]
+			if !ok {
+				result = &StaleReference{
+					Source:  reference.source,
+					Cluster: cluster,
+					Group:   reference.group,
+					Regex:   reference.regex != nil,
+					Since:   now.UnixNano() / int64(time.Millisecond),
+				}
+				checker.results[key] = result
+			}
+			if referenceMatches(reference, scope, groups) {
+				result.LastSeen = now.UnixNano() / int64(time.Millisecond)
+			}
+
+			last := result.Since
+			if result.LastSeen > 0 {
+				last = result.LastSeen
+			}
+			stale := now.Sub(time.Unix(0, last*int64(time.Millisecond))) >= staleAfter
+			if stale && !result.Stale {
+				where := "any cluster"
+				if cluster != "" {
+					where = "cluster " + cluster
+				}
+				log.Warnf("The %s config names group %s, which has not matched a group in %s for %v days", reference.source,
+					reference.group, where, checker.app.Config.StaleConfig.Days)
+			}
+			result.Stale = stale
+			if stale {
+				staleCount++
+			}
+		}
+	}
+
+	// Expected groups that were removed are no longer checked
+	for key := range checker.results {
+		if !seen[key] {
+			delete(checker.results, key)
+		}
+	}
+	checker.lock.Unlock()
+	checker.app.Metrics.Set("burrow_stale_config_references", nil, float64(staleCount))
+}
+
+// Return whether any group in the clusters matches the reference
+func referenceMatches(reference *groupReference, clusters []string, groups map[string][]string) bool {
+	for _, cluster := range clusters {
+		for _, group := range groups[cluster] {
+			if reference.matches(group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type HTTPResponseStaleConfig struct {
+	Error      bool                    `json:"error"`
+	Message    string                  `json:"message"`
+	Days       int64                   `json:"days"`
+	References []*StaleReference       `json:"references"`
+	Request    HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/stale-config, which returns the group references in the config and when each last matched a
+// group. With ?stale=true, only the stale references are returned
+func handleStaleConfig(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if app.StaleConfig == nil {
+		return makeErrorResponse(http.StatusNotFound, "stale config checks are not enabled", w, r)
+	}
+
+	onlyStale := r.URL.Query().Get("stale") == "true"
+	references := make([]*StaleReference, 0)
+	for _, reference := range app.StaleConfig.Results() {
+		if (onlyStale && !reference.Stale) || ((reference.Cluster != "") && !app.AdminAudit.ClusterAllowed(r, reference.Cluster)) {
+			continue
+		}
+		references = append(references, reference)
+	}
+	jsonStr, err := json.Marshal(HTTPResponseStaleConfig{
+		Error:      false,
+		Message:    "stale config references returned",
+		Days:       app.Config.StaleConfig.Days,
+		References: references,
+		Request:    makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
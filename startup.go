@@ -0,0 +1,119 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The phases a module goes through after Burrow starts. A source is connecting until it has been started, consuming
+// until the storage module has enough offsets for its cluster to give Complete evaluations, and then warmed
+const (
+	PhaseConnecting = "connecting"
+	PhaseConsuming  = "consuming"
+	PhaseWarmed     = "warmed"
+)
+
+var phaseOrder = map[string]int{PhaseConnecting: 0, PhaseConsuming: 1, PhaseWarmed: 2}
+
+type HTTPResponseStartupModule struct {
+	Module  string                `json:"module"`
+	Cluster string                `json:"cluster"`
+	Phase   string                `json:"phase"`
+	Error   string                `json:"error,omitempty"`
+	Warmup  *storage.WarmupStatus `json:"warmup,omitempty"`
+}
+type HTTPResponseStartup struct {
+	Error             bool                         `json:"error"`
+	Message           string                       `json:"message"`
+	Phase             string                       `json:"phase"`
+	Uptime            int64                        `json:"uptime"`
+	EstimatedComplete int64                        `json:"estimated_complete"`
+	Modules           []*HTTPResponseStartupModule `json:"modules"`
+	Request           HTTPResponseRequestInfo      `json:"request"`
+}
+
+// Report the startup phase of each module, so deploy automation can tell when a new instance is giving Complete
+// evaluations. The overall phase is that of the least warmed module
+func handleStartup(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	clusters := make([]string, 0, len(app.Config.Kafka))
+	for cluster := range app.Config.Kafka {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	response := HTTPResponseStartup{
+		Error:   false,
+		Message: "startup status returned",
+		Phase:   PhaseWarmed,
+		Uptime:  int64(time.Since(app.Storage.StartTime()).Seconds()),
+		Modules: make([]*HTTPResponseStartupModule, 0),
+		Request: makeRequestInfo(r),
+	}
+	addModule := func(module *HTTPResponseStartupModule) {
+		response.Modules = append(response.Modules, module)
+		if phaseOrder[module.Phase] < phaseOrder[response.Phase] {
+			response.Phase = module.Phase
+		}
+	}
+
+	// The storage module warms up separately for each cluster
+	warmed := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		module := &HTTPResponseStartupModule{Module: "storage", Cluster: cluster, Phase: PhaseConsuming}
+		module.Warmup = app.Storage.WarmupStatus(cluster)
+		if module.Warmup != nil {
+			warmed[cluster] = module.Warmup.Warmed
+			if module.Warmup.Warmed {
+				module.Phase = PhaseWarmed
+			}
+			if module.Warmup.EstimatedComplete > response.EstimatedComplete {
+				response.EstimatedComplete = module.Warmup.EstimatedComplete
+			}
+		}
+		addModule(module)
+	}
+
+	for _, name := range offsetSourceNames {
+		sourceClusters := offsetSourceModules[name].Clusters(app.Config)
+		sort.Strings(sourceClusters)
+		for _, cluster := range sourceClusters {
+			module := &HTTPResponseStartupModule{Module: name, Cluster: cluster}
+			switch {
+			case !app.Sources.IsRunning(name, cluster):
+				module.Phase = PhaseConnecting
+				module.Error = app.Sources.ClusterErrors(cluster)[name]
+			case warmed[cluster] || (app.Config.Kafka[cluster] == nil):
+				// Sources for clusters that the storage module doesn't evaluate are warmed as soon as they run
+				module.Phase = PhaseWarmed
+			default:
+				module.Phase = PhaseConsuming
+			}
+			addModule(module)
+		}
+	}
+
+	jsonStr, err := json.Marshal(response)
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
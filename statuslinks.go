@@ -0,0 +1,95 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Each group that has metrics is given a short ID. Exemplars can only have a few labels, so they carry the ID rather
+// than the group's status URL. A Grafana data link to /v2/burrow/status/${__value.raw}?at=${__value.time} then goes
+// from a point on a lag graph to the group's full status as of that time
+type StatusLinks struct {
+	groups map[string]*statusLink
+	lock   sync.RWMutex
+}
+
+type statusLink struct {
+	cluster string
+	group   string
+}
+
+func NewStatusLinks() *StatusLinks {
+	return &StatusLinks{groups: make(map[string]*statusLink)}
+}
+
+func statusLinkID(cluster string, group string) string {
+	return fmt.Sprintf("%016x", idHash([]byte(cluster+"/"+group)))
+}
+
+// Return the ID for a group, remembering it so that it can be looked up
+func (links *StatusLinks) Add(cluster string, group string) string {
+	id := statusLinkID(cluster, group)
+
+	links.lock.RLock()
+	_, ok := links.groups[id]
+	links.lock.RUnlock()
+	if !ok {
+		links.lock.Lock()
+		links.groups[id] = &statusLink{cluster: cluster, group: group}
+		links.lock.Unlock()
+	}
+	return id
+}
+
+func (links *StatusLinks) Remove(cluster string, group string) {
+	links.lock.Lock()
+	defer links.lock.Unlock()
+	delete(links.groups, statusLinkID(cluster, group))
+}
+
+func (links *StatusLinks) Lookup(id string) (string, string, bool) {
+	links.lock.RLock()
+	defer links.lock.RUnlock()
+	if link, ok := links.groups[id]; ok {
+		return link.cluster, link.group, true
+	}
+	return "", "", false
+}
+
+// Handle GET /v2/burrow/status/(id), which redirects to the status of the group with that ID. If at is given, the
+// status is as of that time (from the offset archive)
+func handleStatusLink(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/burrow/status/"), "/")
+	cluster, group, ok := app.StatusLinks.Lookup(id)
+	if !ok {
+		return makeErrorResponse(http.StatusNotFound, "status link not found", w, r)
+	}
+
+	location := "/v2/kafka/" + url.PathEscape(cluster) + "/consumer/" + url.PathEscape(group) + "/status"
+	if at := r.URL.Query().Get("at"); at != "" {
+		if _, err := parseTimestampParam(at); err != nil {
+			return makeErrorResponse(http.StatusBadRequest, "bad at timestamp", w, r)
+		}
+		location += "?at=" + url.QueryEscape(at)
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusFound)
+	return http.StatusFound, ""
+}
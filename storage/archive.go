@@ -0,0 +1,371 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The offset archive keeps a longer history of consumer offset commits than the evaluation rings do. It is downsampled
+// so that entries for a partition are at least the archive interval apart, and entries older than the retention
+// period are pruned. Groups are kept in the archive after they are removed from the consumer map, so that we can
+// still look back at what they were doing before they went away
+type ArchivedOffset struct {
+	Offset    int64 `json:"offset"`
+	Timestamp int64 `json:"timestamp"`
+	Lag       int64 `json:"lag"`
+}
+
+type OffsetArchive struct {
+	groups map[string]map[string][][]ArchivedOffset
+	lock   *sync.RWMutex
+}
+
+type RequestOffsetHistory struct {
+	Result    chan *ResponseOffsetHistory
+	Cluster   string
+	Group     string
+	Topic     string
+	Timestamp int64
+}
+type ResponseOffsetHistory struct {
+	Offsets    []*ArchivedOffset
+	ErrorGroup bool
+	ErrorTopic bool
+}
+type RequestOffsetDelta struct {
+	Result  chan *ResponseOffsetDelta
+	Cluster string
+	Group   string
+	Start   int64
+	End     int64
+}
+type ResponseOffsetDelta struct {
+	Topics     map[string][]int64
+	Total      int64
+	ErrorGroup bool
+}
+
+func NewOffsetArchive() *OffsetArchive {
+	return &OffsetArchive{
+		groups: make(map[string]map[string][][]ArchivedOffset),
+		lock:   &sync.RWMutex{},
+	}
+}
+
+// Add a commit, with the lag it had when it was stored, to the archive
+func (archive *OffsetArchive) record(offset *PartitionOffset, lag int64, interval int64, retention int64) {
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+
+	topicMap, ok := archive.groups[offset.Group]
+	if !ok {
+		topicMap = make(map[string][][]ArchivedOffset)
+		archive.groups[offset.Group] = topicMap
+	}
+	partitions := topicMap[offset.Topic]
+	for i := len(partitions); i <= int(offset.Partition); i++ {
+		partitions = append(partitions, nil)
+	}
+	topicMap[offset.Topic] = partitions
+
+	// If the last entry is less than an interval after the one before it, replace it instead of adding a new one
+	entries := partitions[offset.Partition]
+	entry := ArchivedOffset{Offset: offset.Offset, Timestamp: offset.Timestamp, Lag: lag}
+	count := len(entries)
+	switch {
+	case (count > 0) && (offset.Timestamp < entries[count-1].Timestamp):
+		// Out of order commits are not archived
+		return
+	case (count > 1) && (entries[count-1].Timestamp-entries[count-2].Timestamp < interval*1000):
+		entries[count-1] = entry
+	default:
+		entries = append(entries, entry)
+	}
+	partitions[offset.Partition] = pruneArchivedOffsets(entries, retention)
+}
+
+// Drop entries older than the retention period from the front of the list
+func pruneArchivedOffsets(entries []ArchivedOffset, retention int64) []ArchivedOffset {
+	cutoff := (time.Now().Unix() - retention) * 1000
+	idx := sort.Search(len(entries), func(i int) bool { return entries[i].Timestamp >= cutoff })
+	if idx == 0 {
+		return entries
+	}
+	if idx == len(entries) {
+		return nil
+	}
+	return append([]ArchivedOffset(nil), entries[idx:]...)
+}
+
+// Prune every partition in the archive, and remove groups that have nothing left
+func (archive *OffsetArchive) prune(retention int64) {
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+
+	for group, topicMap := range archive.groups {
+		for topic, partitions := range topicMap {
+			empty := true
+			for partition, entries := range partitions {
+				partitions[partition] = pruneArchivedOffsets(entries, retention)
+				if len(partitions[partition]) > 0 {
+					empty = false
+				}
+			}
+			if empty {
+				delete(topicMap, topic)
+			}
+		}
+		if len(topicMap) == 0 {
+			delete(archive.groups, group)
+		}
+	}
+}
+
+// Find the committed offset that was current at the given timestamp (the latest at or before it). If there are no
+// entries that old, the earliest entry after it is returned instead. Returns nil if there are no entries at all
+func archivedOffsetAt(entries []ArchivedOffset, timestamp int64) *ArchivedOffset {
+	if len(entries) == 0 {
+		return nil
+	}
+	idx := sort.Search(len(entries), func(i int) bool { return entries[i].Timestamp > timestamp })
+	if idx > 0 {
+		idx -= 1
+	}
+	entry := entries[idx]
+	return &entry
+}
+
+func (storage *OffsetStorage) requestOffsetHistory(request *RequestOffsetHistory) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- &ResponseOffsetHistory{ErrorGroup: true, ErrorTopic: true}
+		return
+	}
+
+	clusterMap.archive.lock.RLock()
+	defer clusterMap.archive.lock.RUnlock()
+
+	topicMap, ok := clusterMap.archive.groups[request.Group]
+	if !ok {
+		request.Result <- &ResponseOffsetHistory{ErrorGroup: true}
+		return
+	}
+	partitions, ok := topicMap[request.Topic]
+	if !ok {
+		request.Result <- &ResponseOffsetHistory{ErrorTopic: true}
+		return
+	}
+
+	response := &ResponseOffsetHistory{Offsets: make([]*ArchivedOffset, len(partitions))}
+	for partition, entries := range partitions {
+		response.Offsets[partition] = archivedOffsetAt(entries, request.Timestamp)
+	}
+	request.Result <- response
+}
+
+// Calculate how far the group's committed offsets advanced for each partition between the start and end timestamps
+func (storage *OffsetStorage) requestOffsetDelta(request *RequestOffsetDelta) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- &ResponseOffsetDelta{ErrorGroup: true}
+		return
+	}
+
+	clusterMap.archive.lock.RLock()
+	defer clusterMap.archive.lock.RUnlock()
+
+	topicMap, ok := clusterMap.archive.groups[request.Group]
+	if !ok {
+		request.Result <- &ResponseOffsetDelta{ErrorGroup: true}
+		return
+	}
+
+	response := &ResponseOffsetDelta{Topics: make(map[string][]int64, len(topicMap))}
+	for topic, partitions := range topicMap {
+		response.Topics[topic] = make([]int64, len(partitions))
+		for partition, entries := range partitions {
+			startOffset := archivedOffsetAt(entries, request.Start)
+			endOffset := archivedOffsetAt(entries, request.End)
+			if (startOffset == nil) || (endOffset == nil) {
+				continue
+			}
+
+			// A rewind between the two timestamps is not counted as negative consumption
+			delta := endOffset.Offset - startOffset.Offset
+			if delta < 0 {
+				delta = 0
+			}
+			response.Topics[topic][partition] = delta
+			response.Total += delta
+		}
+	}
+	request.Result <- response
+}
+
+// Return the lag of a group that is only in the archive (it expired, or was removed) from its last archived commit on
+// each partition to the current broker offset, and whether the archive has any commits for the group
+func (storage *OffsetStorage) ArchivedGroupLag(cluster string, group string) (uint64, bool) {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return 0, false
+	}
+
+	clusterMap.archive.lock.RLock()
+	topicMap, ok := clusterMap.archive.groups[group]
+	committed := make(map[string][]int64, len(topicMap))
+	for topic, partitions := range topicMap {
+		committed[topic] = make([]int64, len(partitions))
+		for partition, entries := range partitions {
+			committed[topic][partition] = -1
+			if len(entries) > 0 {
+				committed[topic][partition] = entries[len(entries)-1].Offset
+			}
+		}
+	}
+	clusterMap.archive.lock.RUnlock()
+	if !ok {
+		return 0, false
+	}
+
+	var totalLag uint64
+	clusterMap.brokerLock.RLock()
+	defer clusterMap.brokerLock.RUnlock()
+	for topic, offsets := range committed {
+		topicPartitions, ok := clusterMap.broker[topic]
+		if !ok {
+			continue
+		}
+		for partition, offset := range offsets {
+			if (offset < 0) || (partition >= len(topicPartitions.partitions)) || (topicPartitions.partitions[partition] == nil) {
+				continue
+			}
+			if lag := topicPartitions.partitions[partition].Offset - offset; lag > 0 {
+				totalLag += uint64(lag)
+			}
+		}
+	}
+	return totalLag, true
+}
+
+// Evaluate a group as it was at a past time, using the archived commits up to then in place of the offset rings. The
+// archive is downsampled, so the window covers the last intervals archived commits, which are further apart than the
+// commits in the rings. The broker offsets at the time are not known, so a partition is only treated as caught up
+// (with an artificial commit, like a live evaluation) if its lag was zero at its last commit, and the retention risk
+// rule and group expectations are not checked
+func (storage *OffsetStorage) evaluateGroupAt(request *RequestConsumerStatus) {
+	status := &ConsumerGroupStatus{
+		Cluster:    request.Cluster,
+		Group:      request.Group,
+		Status:     StatusNotFound,
+		Complete:   true,
+		Partitions: make([]*PartitionStatus, 0),
+		Maxlag:     nil,
+		TotalLag:   0,
+		Tags:       storage.GroupTags(request.Group),
+	}
+	evalStart := time.Now()
+	tracef := func(format string, params ...interface{}) {
+		if request.Trace {
+			status.Trace = append(status.Trace, fmt.Sprintf("+%v ", time.Since(evalStart))+fmt.Sprintf(format, params...))
+		}
+	}
+
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		tracef("cluster %s not found", request.Cluster)
+		request.Result <- status
+		return
+	}
+	status.Metadata = clusterMap.groupMetadata(request.Group)
+	params := request.Params
+	if params == nil {
+		params = storage.DefaultEvaluationParams()
+	}
+	ignoredPartitions := clusterMap.ignoredPartitions()
+
+	clusterMap.brokerLock.RLock()
+	compactedTopics := make(map[string]bool, len(clusterMap.compacted))
+	for topic := range clusterMap.compacted {
+		compactedTopics[topic] = true
+	}
+	clusterMap.brokerLock.RUnlock()
+
+	clusterMap.archive.lock.RLock()
+	topicMap, ok := clusterMap.archive.groups[request.Group]
+	if !ok {
+		clusterMap.archive.lock.RUnlock()
+		tracef("group not found in the archive")
+		request.Result <- status
+		return
+	}
+
+	offsetList := make(map[string][][]ConsumerOffset, len(topicMap))
+	brokerList := make(map[string][]BrokerOffset, len(topicMap))
+	produceRates := make(map[string][]float64, len(topicMap))
+	for topic, partitions := range topicMap {
+		offsetList[topic] = make([][]ConsumerOffset, len(partitions))
+		brokerList[topic] = make([]BrokerOffset, len(partitions))
+		produceRates[topic] = make([]float64, len(partitions))
+		for partition, entries := range partitions {
+			brokerList[topic][partition].OldestOffset = -1
+			if ignoredPartitions[topic][int32(partition)] {
+				tracef("%s:%v: partition is ignored", topic, partition)
+				continue
+			}
+
+			// Only the commits up to the time we're evaluating at count
+			count := sort.Search(len(entries), func(i int) bool { return entries[i].Timestamp > request.At })
+			if count == 0 {
+				// The group wasn't committing for this partition yet
+				continue
+			}
+			status.TotalPartitions += 1
+
+			// Leave room for the artificial commit, which pushes the oldest commit out of the window
+			window := params.Intervals
+			lastEntry := entries[count-1]
+			if lastEntry.Lag == 0 {
+				window -= 1
+			}
+			if count < window {
+				tracef("%s:%v: not enough archived commits before %v, group is incomplete", topic, partition, request.At)
+				status.Complete = false
+				continue
+			}
+
+			offsets := make([]ConsumerOffset, 0, params.Intervals)
+			for _, entry := range entries[count-window : count] {
+				offsets = append(offsets, ConsumerOffset{Offset: entry.Offset, Timestamp: entry.Timestamp, Lag: entry.Lag})
+			}
+			if lastEntry.Lag == 0 {
+				offsets = append(offsets, ConsumerOffset{Offset: lastEntry.Offset, Timestamp: request.At, Lag: 0, artificial: true, Source: OffsetSourceArtificial})
+				tracef("%s:%v: artificial commit at offset %v, lag 0", topic, partition, lastEntry.Offset)
+			}
+			offsetList[topic][partition] = offsets
+		}
+	}
+	clusterMap.archive.lock.RUnlock()
+
+	if status.TotalPartitions == 0 {
+		tracef("group had no archived commits before %v", request.At)
+		request.Result <- status
+		return
+	}
+	status.Status = StatusOK
+	storage.evaluatePartitions(status, offsetList, brokerList, produceRates, compactedTopics, nil, nil, params, request.At,
+		false, 0, request.Showall, request.Partitions, tracef)
+	tracef("evaluation complete, group status as of %v is %v", request.At, status.Status)
+	request.Result <- status
+}
@@ -0,0 +1,315 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+	log "github.com/cihub/seelog"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// The offsets are always stored in memory, in the rings that groups are evaluated from. A Backend keeps checkpoints of
+// the rings somewhere that outlives the process, so that after a restart the rings are reloaded and groups can be
+// evaluated right away, rather than being incomplete until enough commits come in. If Config.Backend is nil, nothing
+// is kept across restarts. A backend may be saved to from more than one goroutine at once
+type Backend interface {
+	// Save a checkpoint, replacing the last one
+	Save(checkpoint *Checkpoint) error
+
+	// Load the last checkpoint that was saved, or return nil if there isn't one
+	Load() (*Checkpoint, error)
+
+	Close() error
+}
+
+// The contents of the offset rings for every cluster at a point in time (in milliseconds)
+type Checkpoint struct {
+	Time     int64                         `json:"time"`
+	Clusters map[string]*ClusterCheckpoint `json:"clusters"`
+}
+
+// The offset rings for a cluster. Each ring is saved as a list, oldest first
+type ClusterCheckpoint struct {
+	Broker      map[string]*TopicCheckpoint               `json:"broker"`
+	Consumer    map[string]map[string][][]*ConsumerOffset `json:"consumer"`
+	FirstCommit map[string]int64                          `json:"first_commit"`
+	Metadata    map[string]map[string]string              `json:"metadata,omitempty"`
+}
+
+type TopicCheckpoint struct {
+	Partitions []*BrokerOffset   `json:"partitions"`
+	History    [][]*BrokerOffset `json:"history"`
+}
+
+// A backend that saves checkpoints to a local file with a codec. The file is replaced in one step, so a crash while
+// saving leaves the last checkpoint in place
+type FileBackend struct {
+	path  string
+	codec Codec
+	lock  sync.Mutex
+}
+
+func NewFileBackend(path string, codec Codec) *FileBackend {
+	return &FileBackend{path: path, codec: codec}
+}
+
+func (backend *FileBackend) Save(checkpoint *Checkpoint) error {
+	data, err := backend.codec.Encode(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+	if err := ioutil.WriteFile(backend.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(backend.path+".tmp", backend.path)
+}
+
+func (backend *FileBackend) Load() (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(backend.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{}
+	if err := DecodeAny(backend.codec, data, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (backend *FileBackend) Close() error {
+	return nil
+}
+
+// The bucket and key that the BoltDB backend keeps the checkpoint under
+var (
+	boltBucket        = []byte("burrow")
+	boltCheckpointKey = []byte("checkpoint")
+)
+
+// A backend that saves checkpoints to a BoltDB database with a codec. Each save is a transaction that is synced to disk
+// before it returns, so a crash while saving leaves the last checkpoint in place. The database is locked while it is
+// open, so it can't be shared by more than one Burrow
+type BoltBackend struct {
+	db    *bolt.DB
+	codec Codec
+}
+
+// Open (or create) the database. This fails if another process has it open for longer than a few seconds
+func NewBoltBackend(path string, codec Codec) (*BoltBackend, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltBackend{db: db, codec: codec}, nil
+}
+
+func (backend *BoltBackend) Save(checkpoint *Checkpoint) error {
+	data, err := backend.codec.Encode(checkpoint)
+	if err != nil {
+		return err
+	}
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(boltCheckpointKey, data)
+	})
+}
+
+func (backend *BoltBackend) Load() (*Checkpoint, error) {
+	var checkpoint *Checkpoint
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		// The value is only valid during the transaction, so it is decoded here
+		data := tx.Bucket(boltBucket).Get(boltCheckpointKey)
+		if data == nil {
+			return nil
+		}
+		checkpoint = &Checkpoint{}
+		return DecodeAny(backend.codec, data, checkpoint)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (backend *BoltBackend) Close() error {
+	return backend.db.Close()
+}
+
+// Return the values in a ring, oldest first
+func ringValues(r *ring.Ring) []interface{} {
+	values := make([]interface{}, 0, r.Len())
+	r.Do(func(val interface{}) {
+		if val != nil {
+			values = append(values, val)
+		}
+	})
+	return values
+}
+
+// Copy the offset rings of every cluster. Each cluster is locked as a group evaluation locks it while it is copied
+func (storage *OffsetStorage) TakeCheckpoint() *Checkpoint {
+	checkpoint := &Checkpoint{
+		Time:     time.Now().Unix() * 1000,
+		Clusters: make(map[string]*ClusterCheckpoint, len(storage.offsets)),
+	}
+	for cluster, clusterMap := range storage.offsets {
+		clusterCheckpoint := &ClusterCheckpoint{
+			Broker:      make(map[string]*TopicCheckpoint),
+			Consumer:    make(map[string]map[string][][]*ConsumerOffset),
+			FirstCommit: make(map[string]int64),
+			Metadata:    make(map[string]map[string]string),
+		}
+
+		clusterMap.consumerLock.RLock()
+		clusterMap.brokerLock.RLock()
+		for topic, partitions := range clusterMap.broker {
+			topicCheckpoint := &TopicCheckpoint{
+				Partitions: make([]*BrokerOffset, len(partitions.partitions)),
+				History:    make([][]*BrokerOffset, len(partitions.history)),
+			}
+			for i, offset := range partitions.partitions {
+				if offset != nil {
+					offsetCopy := *offset
+					topicCheckpoint.Partitions[i] = &offsetCopy
+				}
+			}
+			for i, history := range partitions.history {
+				if history == nil {
+					continue
+				}
+				for _, val := range ringValues(history) {
+					offsetCopy := *val.(*BrokerOffset)
+					topicCheckpoint.History[i] = append(topicCheckpoint.History[i], &offsetCopy)
+				}
+			}
+			clusterCheckpoint.Broker[topic] = topicCheckpoint
+		}
+		for group, topics := range clusterMap.consumer {
+			clusterCheckpoint.Consumer[group] = make(map[string][][]*ConsumerOffset, len(topics))
+			for topic, partitions := range topics {
+				offsets := make([][]*ConsumerOffset, len(partitions))
+				for i, offsetRing := range partitions {
+					if offsetRing == nil {
+						continue
+					}
+					for _, val := range ringValues(offsetRing) {
+						offsetCopy := *val.(*ConsumerOffset)
+						offsets[i] = append(offsets[i], &offsetCopy)
+					}
+				}
+				clusterCheckpoint.Consumer[group][topic] = offsets
+			}
+		}
+		for group, firstCommit := range clusterMap.firstCommit {
+			clusterCheckpoint.FirstCommit[group] = firstCommit
+		}
+		clusterMap.brokerLock.RUnlock()
+		clusterMap.consumerLock.RUnlock()
+
+		clusterMap.metadataLock.RLock()
+		for group, metadata := range clusterMap.metadata {
+			clusterCheckpoint.Metadata[group] = make(map[string]string, len(metadata))
+			for key, value := range metadata {
+				clusterCheckpoint.Metadata[group][key] = value
+			}
+		}
+		clusterMap.metadataLock.RUnlock()
+
+		checkpoint.Clusters[cluster] = clusterCheckpoint
+	}
+	return checkpoint
+}
+
+// Fill the offset rings from a checkpoint. This is only done when the storage module is created, before any offsets
+// come in. Clusters that are no longer configured are skipped, and if the rings are now shorter than they were, only
+// the newest offsets are kept
+func (storage *OffsetStorage) restoreCheckpoint(checkpoint *Checkpoint) {
+	for cluster, clusterCheckpoint := range checkpoint.Clusters {
+		clusterMap, ok := storage.offsets[cluster]
+		if !ok {
+			continue
+		}
+
+		for topic, topicCheckpoint := range clusterCheckpoint.Broker {
+			partitions := newTopicPartitions(len(topicCheckpoint.Partitions))
+			copy(partitions.partitions, topicCheckpoint.Partitions)
+			for i, history := range topicCheckpoint.History {
+				if (i >= len(partitions.history)) || (len(history) == 0) {
+					continue
+				}
+				partitions.history[i] = ring.New(storage.config.BrokerIntervals)
+				for _, offset := range history {
+					partitions.history[i].Value = offset
+					partitions.history[i] = partitions.history[i].Next()
+				}
+			}
+			clusterMap.broker[topic] = partitions
+			if group := storage.deadLetterGroup(topic); group != "" {
+				clusterMap.deadLetter[group] = append(clusterMap.deadLetter[group], topic)
+			}
+		}
+		for group, topics := range clusterCheckpoint.Consumer {
+			clusterMap.consumer[group] = make(map[string][]*ring.Ring, len(topics))
+			for topic, partitions := range topics {
+				rings := make([]*ring.Ring, len(partitions))
+				for i, offsets := range partitions {
+					if len(offsets) == 0 {
+						continue
+					}
+					rings[i] = ring.New(storage.config.Intervals)
+					for _, offset := range offsets {
+						rings[i].Value = offset
+						rings[i] = rings[i].Next()
+					}
+				}
+				clusterMap.consumer[group][topic] = rings
+			}
+		}
+		for group, firstCommit := range clusterCheckpoint.FirstCommit {
+			clusterMap.firstCommit[group] = firstCommit
+		}
+		for group, metadata := range clusterCheckpoint.Metadata {
+			clusterMap.metadata[group] = metadata
+		}
+	}
+	log.Infof("Restored offsets from the checkpoint taken at %v", checkpoint.Time)
+}
+
+func (storage *OffsetStorage) saveCheckpoint() {
+	if err := storage.Flush(); err != nil {
+		log.Errorf("Cannot save offsets checkpoint: %v", err)
+	}
+}
+
+// Save a checkpoint now, if there is a backend, rather than waiting for the next one
+func (storage *OffsetStorage) Flush() error {
+	if storage.config.Backend == nil {
+		return nil
+	}
+	return storage.config.Backend.Save(storage.TakeCheckpoint())
+}
@@ -0,0 +1,102 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// When Burrow falls behind on a cluster's offsets (after a restart, or a burst of commits), the statuses people are
+// looking at should recover first. Groups that were queried, or that were not OK when they were last evaluated, are
+// priority groups for PriorityGroupExpire seconds. While more than CatchUpBacklog offsets are waiting for the
+// cluster, the waiting offsets are taken as a batch, and the broker offsets and priority group commits in it are
+// stored before the rest
+
+// Make the group a priority group from now, if catching up is enabled
+func (storage *OffsetStorage) markPriorityGroup(clusterMap *ClusterOffsets, group string) {
+	if storage.config.CatchUpBacklog <= 0 {
+		return
+	}
+	clusterMap.priorityLock.Lock()
+	clusterMap.priorityGroups[group] = time.Now().Unix() * 1000
+	clusterMap.priorityLock.Unlock()
+}
+
+// Mark a group that was evaluated as a priority group if it is alerting
+func (storage *OffsetStorage) markAlertingGroup(clusterMap *ClusterOffsets, status *ConsumerGroupStatus) {
+	switch status.Status {
+	case StatusNotFound, StatusOK, StatusWarming:
+		return
+	}
+	storage.markPriorityGroup(clusterMap, status.Group)
+}
+
+// Process a batch of offsets for a cluster that has fallen behind: the first one that was received, and all of the
+// ones that are waiting behind it. Only the cluster's pipeline may call this, as it reads from the offset channel
+func (storage *OffsetStorage) catchUp(first *PartitionOffset, offsets chan *PartitionOffset) {
+	batch := make([]*PartitionOffset, 1, len(offsets)+1)
+	batch[0] = first
+	for waiting := len(offsets); waiting > 0; waiting-- {
+		batch = append(batch, <-offsets)
+	}
+
+	clusterMap, ok := storage.offsets[first.Cluster]
+	if !ok {
+		for _, offset := range batch {
+			storage.dispatchOffset(offset)
+		}
+		return
+	}
+	clusterMap.priorityLock.RLock()
+	rest := make([]*PartitionOffset, 0, len(batch))
+	wg := sync.WaitGroup{}
+	for _, offset := range batch {
+		if _, ok := clusterMap.priorityGroups[offset.Group]; (offset.Group != "") && !ok {
+			rest = append(rest, offset)
+			continue
+		}
+		wg.Add(1)
+		go func(offset *PartitionOffset) {
+			defer wg.Done()
+			if offset.Group == "" {
+				storage.addBrokerOffset(offset)
+			} else {
+				storage.addConsumerOffset(offset)
+			}
+		}(offset)
+	}
+	clusterMap.priorityLock.RUnlock()
+	log.Debugf("Catching up on %v offsets for cluster %s, %v of them first", len(batch), first.Cluster,
+		len(batch)-len(rest))
+
+	// The rest are dispatched as usual once the priority offsets are stored
+	wg.Wait()
+	for _, offset := range rest {
+		storage.dispatchOffset(offset)
+	}
+}
+
+// Forget the priority groups that haven't been queried or alerting for PriorityGroupExpire seconds. This runs on the
+// archive ticker
+func (storage *OffsetStorage) prunePriorityGroups() {
+	cutoff := time.Now().Unix()*1000 - storage.config.PriorityGroupExpire*1000
+	for _, clusterMap := range storage.offsets {
+		clusterMap.priorityLock.Lock()
+		for group, marked := range clusterMap.priorityGroups {
+			if marked < cutoff {
+				delete(clusterMap.priorityGroups, group)
+			}
+		}
+		clusterMap.priorityLock.Unlock()
+	}
+}
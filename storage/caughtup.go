@@ -0,0 +1,59 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// Mark a partition of a group as caught up by request, by adding an artificial commit at the current broker offset
+// with zero lag (as the evaluation does for a partition with no lag). This clears a STALL that was checked by hand and
+// is known to be false, such as for a partition that gets no new messages. The next real commit replaces it as usual
+func (storage *OffsetStorage) markCaughtUp(request *RequestMarkCaughtUp) {
+	clusterMap := storage.offsets[request.Cluster]
+
+	clusterMap.brokerLock.RLock()
+	topic, ok := clusterMap.broker[request.Topic]
+	if (!ok) || (request.Partition < 0) || (request.Partition >= int32(len(topic.partitions))) ||
+		(topic.partitions[request.Partition] == nil) {
+		clusterMap.brokerLock.RUnlock()
+		request.Result <- StatusNotFound
+		return
+	}
+	headOffset := clusterMap.lagOffset(request.Group, topic.partitions[request.Partition])
+	clusterMap.brokerLock.RUnlock()
+
+	clusterMap.consumerLock.Lock()
+	defer clusterMap.consumerLock.Unlock()
+
+	partitions, ok := clusterMap.consumer[request.Group][request.Topic]
+	if (!ok) || (request.Partition >= int32(len(partitions))) || (partitions[request.Partition] == nil) {
+		request.Result <- StatusNotFound
+		return
+	}
+
+	partitionRing := partitions[request.Partition]
+	if partitionRing.Value == nil {
+		partitionRing.Value = &ConsumerOffset{}
+	}
+	ringval, _ := partitionRing.Value.(*ConsumerOffset)
+	ringval.Offset = headOffset
+	ringval.Timestamp = time.Now().Unix() * 1000
+	ringval.Lag = 0
+	ringval.artificial = true
+	ringval.Source = OffsetSourceCaughtUp
+	partitions[request.Partition] = partitionRing.Next()
+
+	log.Infof("Marking partition caught up by request: cluster=%s topic=%s partition=%v group=%s offset=%v",
+		request.Cluster, request.Topic, request.Partition, request.Group, headOffset)
+	request.Result <- StatusOK
+}
@@ -0,0 +1,125 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// Commit timestamps are set by the brokers, but groups are evaluated against the time on this host, so if the clocks
+// disagree, a consumer that is committing can look stopped. The skew of each cluster's clock is estimated from the
+// commits as they are received: the smallest difference between the time a commit was received and its timestamp
+// over a window is the skew, plus the (small) time it took to arrive. While old commits are read after a start, the
+// estimate is too high, which only holds back STOPs (as rule 4 already does until a window of commits is read)
+const (
+	// Evaluate against the time on this host
+	ClockSourceLocal = "local"
+
+	// Evaluate against the time on this host, less the estimated skew of the cluster's clock
+	ClockSourceCompensated = "compensated"
+)
+
+// How long each skew estimate is taken over
+const clockSkewWindow int64 = 60000
+
+type clockSkew struct {
+	lock        sync.Mutex
+	windowStart int64
+	windowMin   int64
+	estimate    int64
+	known       bool
+	warned      bool
+}
+
+// Count a commit toward the cluster's skew estimate, as of now (in milliseconds). At the end of each window, the
+// estimate is updated and passed to SkewHook
+func (storage *OffsetStorage) recordClockSkew(cluster string, clusterMap *ClusterOffsets, timestamp int64, now int64) {
+	skew := &clusterMap.skew
+	delay := now - timestamp
+
+	skew.lock.Lock()
+	if skew.windowStart == 0 {
+		skew.windowStart = now
+		skew.windowMin = delay
+	} else if delay < skew.windowMin {
+		skew.windowMin = delay
+	}
+	if now-skew.windowStart < clockSkewWindow {
+		skew.lock.Unlock()
+		return
+	}
+	skew.estimate = skew.windowMin
+	skew.known = true
+	skew.windowStart = 0
+	estimate := skew.estimate
+
+	// Only warn when the skew goes over the threshold, rather than for every window it is over
+	threshold := storage.config.ClockSkewWarning * 1000
+	over := (threshold > 0) && ((estimate > threshold) || (estimate < -threshold))
+	warn := over && !skew.warned
+	skew.warned = over
+	skew.lock.Unlock()
+
+	if warn {
+		if estimate > 0 {
+			log.Warnf("The clock of cluster %s is about %vms behind this host, from the commit timestamps", cluster, estimate)
+		} else {
+			log.Warnf("The clock of cluster %s is about %vms ahead of this host, from the commit timestamps", cluster, -estimate)
+		}
+	}
+	if storage.config.SkewHook != nil {
+		storage.config.SkewHook(cluster, estimate)
+	}
+}
+
+// Return the estimated skew of the cluster's clock in milliseconds (positive if it is behind this host), and whether
+// there is an estimate yet
+func (storage *OffsetStorage) ClockSkew(cluster string) (int64, bool) {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return 0, false
+	}
+	clusterMap.skew.lock.Lock()
+	defer clusterMap.skew.lock.Unlock()
+	return clusterMap.skew.estimate, clusterMap.skew.known
+}
+
+// Return the time (in milliseconds) that the cluster's groups are evaluated as of: the time on this host, less the
+// estimated skew of the cluster's clock if the clock source is compensated
+func (storage *OffsetStorage) clusterNow(clusterMap *ClusterOffsets) int64 {
+	now := time.Now().Unix() * 1000
+	if storage.config.ClockSource != ClockSourceCompensated {
+		return now
+	}
+	clusterMap.skew.lock.Lock()
+	defer clusterMap.skew.lock.Unlock()
+	if clusterMap.skew.known {
+		now -= clusterMap.skew.estimate
+	}
+	return now
+}
+
+// The format of the ISO-8601 times in status results, with milliseconds as in the offset timestamps
+const DisplayTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Return a timestamp (in milliseconds) as an ISO-8601 time in the display location, or "" if it isn't set
+func (storage *OffsetStorage) displayTime(timestamp int64) string {
+	if timestamp <= 0 {
+		return ""
+	}
+	location := storage.config.DisplayLocation
+	if location == nil {
+		location = time.UTC
+	}
+	return time.Unix(timestamp/1000, (timestamp%1000)*int64(time.Millisecond)).In(location).Format(DisplayTimeFormat)
+}
@@ -0,0 +1,152 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// A Codec turns checkpoints (and anything else that is saved or sent between Burrows, like the hand-off state) into
+// bytes and back. JSON can be read by anything, while gob is smaller and faster to encode and decode for large
+// checkpoints. Other formats can be added by implementing this and adding them to Codecs
+type Codec interface {
+	// The name of the codec in the config, which is also used as the extension of files that it encodes
+	Name() string
+
+	// The MIME type of encoded data, for HTTP requests
+	ContentType() string
+
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte, value interface{}) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+func (JSONCodec) Encode(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+func (JSONCodec) Decode(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+func (GobCodec) ContentType() string {
+	return "application/x-gob"
+}
+func (GobCodec) Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+func (GobCodec) Decode(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// The codecs that can be configured, with the default first
+var Codecs = []Codec{JSONCodec{}, GobCodec{}}
+
+// Return the codec with the name, or nil if there isn't one
+func CodecByName(name string) Codec {
+	for _, codec := range Codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Return the codec for a MIME type, or nil if there isn't one. Parameters (such as charset) are ignored
+func CodecByContentType(contentType string) Codec {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	for _, codec := range Codecs {
+		if codec.ContentType() == contentType {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Decode data with the codec, or if that fails, with each of the other codecs in turn. This lets a checkpoint that was
+// saved before the codec was changed in the config still be loaded. The error is from the first codec
+func DecodeAny(codec Codec, data []byte, value interface{}) error {
+	err := codec.Decode(data, value)
+	if err == nil {
+		return nil
+	}
+	for _, other := range Codecs {
+		if other.Name() != codec.Name() {
+			if other.Decode(data, value) == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// The partitions of a topic without a broker offset are nil, which gob can't encode in a list. For gob, the topic
+// is encoded with the partitions that have offsets and a list of the ones that don't
+type gobTopicCheckpoint struct {
+	Partitions []*BrokerOffset
+	Missing    []int
+	History    [][]*BrokerOffset
+}
+
+func (topic *TopicCheckpoint) GobEncode() ([]byte, error) {
+	encoded := &gobTopicCheckpoint{History: topic.History}
+	for i, offset := range topic.Partitions {
+		if offset == nil {
+			encoded.Missing = append(encoded.Missing, i)
+		} else {
+			encoded.Partitions = append(encoded.Partitions, offset)
+		}
+	}
+	return GobCodec{}.Encode(encoded)
+}
+
+func (topic *TopicCheckpoint) GobDecode(data []byte) error {
+	encoded := &gobTopicCheckpoint{}
+	if err := (GobCodec{}).Decode(data, encoded); err != nil {
+		return err
+	}
+	topic.History = encoded.History
+	topic.Partitions = make([]*BrokerOffset, len(encoded.Partitions)+len(encoded.Missing))
+	next, missing := 0, 0
+	for i := range topic.Partitions {
+		if (missing < len(encoded.Missing)) && (encoded.Missing[missing] == i) {
+			missing++
+			continue
+		}
+		if next >= len(encoded.Partitions) {
+			return errors.New("gob: topic checkpoint has too few partitions")
+		}
+		topic.Partitions[i] = encoded.Partitions[next]
+		next++
+	}
+	return nil
+}
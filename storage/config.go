@@ -0,0 +1,192 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+// Package storage is Burrow's offset storage and lag evaluation engine. It can be embedded in other programs, which
+// feed it broker and consumer offsets with AddOffset and query it with the request types or the helper methods.
+package storage
+
+import "time"
+
+// Config is everything the storage module needs to know. Burrow fills this in from its own configuration file, but
+// programs that embed the storage module can set it up directly. Zero values are not defaulted here, so all of the
+// interval and size settings must be set
+type Config struct {
+	// The clusters to store offsets for. Offsets for any other cluster are ignored
+	Clusters map[string]*ClusterConfig
+
+	// Regular expressions for consumer groups and topics to ignore
+	GroupBlacklist string
+	TopicBlacklist string
+
+	// Lag evaluation settings. These match the options in the [lagcheck] section of the Burrow config
+	Intervals       int
+	BrokerIntervals int
+	MinDistance     int64
+	ExpireGroup     int64
+	DroppedOffsets  int
+	RetentionRisk   int64
+
+	// Dropped offsets are counted by reason rather than logged one by one, as there can be many of them. Every
+	// DropLogInterval seconds, the counts for each cluster are logged (at debug level) and passed to DropHook. One in
+	// every DropLogSample drops is also logged in detail. If DropLogInterval is not positive the counts are not
+	// reported, and if DropLogSample is not positive no drops are logged in detail
+	DropLogInterval int64
+	DropLogSample   int
+	CompactedTopics string
+
+	// How many topics of a group with many partitions are evaluated at once. If this is not more than 1, the topics
+	// are always evaluated one after another
+	EvaluationWorkers int
+
+	// How long (in seconds) after its first commit a new group is WARMING, rather than evaluated. If this is not
+	// positive, new groups are evaluated right away
+	BurnIn int64
+
+	// How ephemeral groups (such as console consumers) are handled, as one of the EphemeralMode constants. Groups that
+	// match DefaultEphemeralGroups or any of the EphemeralGroups regular expressions are ephemeral. They are collapsed
+	// into EphemeralGroupName, or expire after EphemeralExpire seconds
+	EphemeralMode      string
+	EphemeralGroups    []string
+	EphemeralGroupName string
+	EphemeralExpire    int64
+
+	// While more than CatchUpBacklog offsets are waiting for a cluster, the commits of priority groups (groups that were
+	// queried or alerting in the last PriorityGroupExpire seconds) are stored before the others. If CatchUpBacklog is
+	// not positive, offsets are always processed in the order they come in
+	CatchUpBacklog      int
+	PriorityGroupExpire int64
+
+	// How long (in seconds) a group that is removed by request can be restored for. If this is not positive, groups
+	// are removed for good
+	TombstoneRetention int64
+
+	// The clock that groups are evaluated against, as one of the ClockSource constants. The skew of each cluster's
+	// clock is always estimated, and a warning is logged when it is more than ClockSkewWarning seconds (if positive)
+	ClockSource      string
+	ClockSkewWarning int64
+
+	// Times in status results are also given as ISO-8601 strings in this location (UTC if it isn't set)
+	DisplayLocation *time.Location
+
+	// If set, the offsets are saved to the backend every CheckpointInterval seconds and when the storage module is
+	// stopped, and loaded from it when the storage module is created
+	Backend            Backend
+	CheckpointInterval int64
+
+	// How long to keep consumer offset commits in the archive, and the minimum time between archived commits (seconds)
+	ArchiveRetention int64
+	ArchiveInterval  int64
+
+	// Consumer groups that are expected to be committing offsets
+	ExpectedGroups []*ExpectedGroupConfig
+
+	// Groups that commit their offsets to a different cluster than the one they consume from
+	CommitMappings []*CommitMappingConfig
+
+	// Critical topics that are evaluated with tighter thresholds
+	PriorityTopics []*PriorityTopicConfig
+
+	// Topics that are the dead letter topics of groups, and the produce rate over which they make the group a warning.
+	// Rules earlier in the list take precedence
+	DeadLetterTopics []*DeadLetterConfig
+
+	// How the partition statuses of the groups matching each policy are rolled up into the group status. Groups that
+	// don't match any policy have the worst status of their partitions. Policies earlier in the list take precedence
+	RollupPolicies []*RollupPolicyConfig
+
+	// Partitions that are left out of all group evaluations
+	IgnoredPartitions []*IgnoredPartitionConfig
+
+	// Regular expressions with named capture groups, which are matched against group names to tag the group. For
+	// example, ^(?P<team>[a-z]+)[.](?P<service>[a-z-]+)$ gives the group payments.billing the tags team=payments and
+	// service=billing. Rules earlier in the list take precedence
+	GroupTagRules []string
+
+	// If set, this is called with every consumer offset commit that is accepted (not dropped). It is called from
+	// many goroutines at once, and should not block for long
+	CommitHook func(offset *PartitionOffset)
+
+	// If set, this is called with every broker offset that is stored, in the same way as CommitHook
+	BrokerHook func(offset *PartitionOffset)
+
+	// If set, this is called every DropLogInterval seconds for each cluster that dropped offsets in that time, with
+	// the number of offsets dropped for each reason
+	DropHook func(cluster string, counts map[string]int64)
+
+	// If set, this is called every minute for each cluster that received commits, with the estimated skew of its clock
+	// in milliseconds (positive if it is behind this host)
+	SkewHook func(cluster string, skew int64)
+
+	// If set, this is called with the result of every group evaluation, except for simulations, evaluations as of a
+	// past time, and evaluations while the cluster is paused. As with CommitHook, it should not block for long
+	StatusHook func(status *ConsumerGroupStatus)
+}
+
+type ClusterConfig struct {
+	// Groups matching this regular expression have lag calculated against the last stable offset
+	ReadCommittedGroups string
+
+	// The most groups, topics, and partitions (over all of the topics) to store for the cluster, and what is done with
+	// a new group or topic that would go over one of them (one of the LimitMode constants). Limits that are not
+	// positive are not enforced
+	MaxGroups     int
+	MaxTopics     int
+	MaxPartitions int
+	LimitMode     string
+}
+
+type ExpectedGroupConfig struct {
+	Cluster  string
+	Group    string
+	Topics   []string
+	Schedule string
+	Window   int64
+}
+
+// Offsets committed to CommitCluster by groups matching the Group regular expression are stored and evaluated under
+// DataCluster, so lag is calculated against the broker offsets for the topics the group actually consumes
+type CommitMappingConfig struct {
+	CommitCluster string
+	DataCluster   string
+	Group         string
+}
+
+// Partitions of topics matching the Topics regular expression are evaluated more strictly. If MaxLag is set, a
+// partition with more lag than that is a warning. If StopGrace is set, a partition is stopped when the consumer has not
+// committed for that many seconds, even if that is shorter than the evaluation window. A priority partition that is not
+// OK always makes the group an error
+type PriorityTopicConfig struct {
+	Topics    string
+	MaxLag    int64
+	StopGrace int64
+}
+
+// Topics matching the Topics regular expression are dead letter topics for the group named by its "group" capture group
+// (see CompileDeadLetterRule). If MaxRate is set, a group is a warning when its dead letter topic is produced to faster
+// than that many messages per second
+type DeadLetterConfig struct {
+	Topics  string
+	MaxRate float64
+}
+
+// Groups matching the Groups regular expression have their status rolled up with Policy, one of the Rollup constants.
+// ErrorPercent is the percentage of partitions that must not be OK for the percent policy to make the group an error
+type RollupPolicyConfig struct {
+	Groups       string
+	Policy       string
+	ErrorPercent int
+}
+
+type IgnoredPartitionConfig struct {
+	Cluster   string
+	Topic     string
+	Partition int32
+	Reason    string
+}
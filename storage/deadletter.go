@@ -0,0 +1,91 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"errors"
+	"regexp"
+)
+
+// The produce rate of a dead letter topic of a group. Messages that a consumer can't process are often produced to a
+// dead letter topic, so a jump in its rate is a sign of poison messages, even when the group's lag looks fine
+type DeadLetterStatus struct {
+	Topic   string  `json:"topic"`
+	Rate    float64 `json:"rate"`
+	MaxRate float64 `json:"max_rate,omitempty"`
+	Spiking bool    `json:"spiking"`
+}
+
+type deadLetterRule struct {
+	topics  *regexp.Regexp
+	maxRate float64
+}
+
+// Compile a dead letter topic rule. The regular expression is matched against topic names, and must have a capture
+// group named "group" for the name of the group that the topic is the dead letter topic for
+func CompileDeadLetterRule(rule string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(rule)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range re.SubexpNames() {
+		if name == "group" {
+			return re, nil
+		}
+	}
+	return nil, errors.New("rule has no capture group named group, such as (?P<group>.+)")
+}
+
+// Return the group that a topic is the dead letter topic for, or an empty string if it isn't one. If more than one
+// rule matches, the first one configured is used
+func (storage *OffsetStorage) deadLetterGroup(topic string) string {
+	for _, rule := range storage.deadLetterRules {
+		if match := rule.topics.FindStringSubmatch(topic); match != nil {
+			return match[rule.topics.SubexpIndex("group")]
+		}
+	}
+	return ""
+}
+
+func (storage *OffsetStorage) deadLetterRuleFor(topic string) *deadLetterRule {
+	for _, rule := range storage.deadLetterRules {
+		if rule.topics.MatchString(topic) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Add the produce rate of each of the group's dead letter topics to its status. If any of them is over the max rate
+// of its rule, an OK group is a warning
+func (storage *OffsetStorage) applyDeadLetter(clusterMap *ClusterOffsets, status *ConsumerGroupStatus,
+	tracef func(string, ...interface{})) {
+	clusterMap.brokerLock.RLock()
+	defer clusterMap.brokerLock.RUnlock()
+
+	for _, topic := range clusterMap.deadLetter[status.Group] {
+		deadLetter := &DeadLetterStatus{Topic: topic, MaxRate: storage.deadLetterRuleFor(topic).maxRate}
+		for _, history := range clusterMap.broker[topic].history {
+			rate, _ := brokerOffsetRate(history)
+			deadLetter.Rate += rate
+		}
+		status.DeadLetter = append(status.DeadLetter, deadLetter)
+
+		if (deadLetter.MaxRate > 0) && (deadLetter.Rate > deadLetter.MaxRate) {
+			tracef("dead letter topic %s is being produced to at %.2f/s, over %.2f/s", topic, deadLetter.Rate,
+				deadLetter.MaxRate)
+			deadLetter.Spiking = true
+			if status.Status == StatusOK {
+				status.Status = StatusWarning
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// How many groups to list in each cluster's biggest groups, unless the request asks for another number
+const diagnosticsTopGroups = 20
+
+// A snapshot of what the storage module is holding, for troubleshooting
+type Diagnostics struct {
+	OffsetChannelDepth    int                            `json:"offset_channel_depth"`
+	OffsetChannelCapacity int                            `json:"offset_channel_capacity"`
+	Clusters              map[string]*ClusterDiagnostics `json:"clusters"`
+}
+
+type ClusterDiagnostics struct {
+	Topics              int                 `json:"topics"`
+	Partitions          int                 `json:"partitions"`
+	OffsetChannelDepth  int                 `json:"offset_channel_depth"`
+	PartitionExpansions int                 `json:"partition_expansions"`
+	BrokerRings         int                 `json:"broker_rings"`
+	GroupRings          int                 `json:"group_rings"`
+	Groups              int                 `json:"groups"`
+	GroupPartitions     int                 `json:"group_partitions"`
+	ExpectedGroups      int                 `json:"expected_groups"`
+	ArchivedOffsets     int                 `json:"archived_offsets"`
+	EstimatedBytes      int64               `json:"estimated_bytes"`
+	LargestGroups       []*GroupDiagnostics `json:"largest_groups"`
+	CompactedTopics     int                 `json:"compacted_topics"`
+	DroppedOffsetRing   int                 `json:"dropped_offset_ring"`
+}
+
+type GroupDiagnostics struct {
+	Group           string `json:"group"`
+	Topics          int    `json:"topics"`
+	Partitions      int    `json:"partitions"`
+	Rings           int    `json:"rings"`
+	ArchivedOffsets int    `json:"archived_offsets"`
+	EstimatedBytes  int64  `json:"estimated_bytes"`
+}
+
+// If TopGroups is zero, the default number of largest groups is returned for each cluster
+type RequestDiagnostics struct {
+	Result    chan *Diagnostics
+	TopGroups int
+}
+
+// Return a snapshot of the storage module's internal state
+func (storage *OffsetStorage) Diagnostics() *Diagnostics {
+	return storage.Usage(0)
+}
+
+// Return a snapshot of the storage module's internal state, with the given number of largest groups for each cluster
+func (storage *OffsetStorage) Usage(topGroups int) *Diagnostics {
+	request := &RequestDiagnostics{Result: make(chan *Diagnostics), TopGroups: topGroups}
+	storage.RequestChannel <- request
+	return <-request.Result
+}
+
+// The memory estimates only count the offsets themselves (broker offsets, their history, consumer offsets, and
+// archived offsets), not the maps and rings that hold them, so they are useful for comparing clusters and groups but
+// are lower than the real usage
+func (storage *OffsetStorage) requestDiagnostics(request *RequestDiagnostics) {
+	brokerOffsetSize := int64(unsafe.Sizeof(BrokerOffset{}))
+	consumerOffsetSize := int64(unsafe.Sizeof(ConsumerOffset{}))
+	archivedOffsetSize := int64(unsafe.Sizeof(ArchivedOffset{}))
+	topGroups := request.TopGroups
+	if topGroups <= 0 {
+		topGroups = diagnosticsTopGroups
+	}
+
+	diagnostics := &Diagnostics{
+		OffsetChannelDepth:    len(storage.OffsetChannel),
+		OffsetChannelCapacity: cap(storage.OffsetChannel),
+		Clusters:              make(map[string]*ClusterDiagnostics, len(storage.offsets)),
+	}
+
+	for cluster, clusterMap := range storage.offsets {
+		clusterMap.droppedLock.Lock()
+		clusterDiagnostics := &ClusterDiagnostics{DroppedOffsetRing: clusterMap.dropped.Len()}
+		clusterMap.droppedLock.Unlock()
+		if pipeline, ok := storage.pipelines[cluster]; ok {
+			clusterDiagnostics.OffsetChannelDepth = len(pipeline.offsets)
+		}
+
+		clusterMap.brokerLock.RLock()
+		clusterDiagnostics.Topics = len(clusterMap.broker)
+		for _, topic := range clusterMap.broker {
+			clusterDiagnostics.Partitions += len(topic.partitions)
+			clusterDiagnostics.PartitionExpansions += topic.version
+			for _, history := range topic.history {
+				if history != nil {
+					clusterDiagnostics.BrokerRings++
+				}
+			}
+		}
+		clusterDiagnostics.EstimatedBytes = int64(clusterDiagnostics.Partitions+clusterDiagnostics.BrokerRings*storage.config.BrokerIntervals) * brokerOffsetSize
+		clusterDiagnostics.CompactedTopics = len(clusterMap.compacted)
+		clusterMap.brokerLock.RUnlock()
+
+		groups := make(map[string]*GroupDiagnostics)
+		clusterMap.consumerLock.RLock()
+		for group, topics := range clusterMap.consumer {
+			groupDiagnostics := &GroupDiagnostics{Group: group, Topics: len(topics)}
+			for _, partitions := range topics {
+				groupDiagnostics.Partitions += len(partitions)
+				for _, offsetRing := range partitions {
+					if offsetRing != nil {
+						groupDiagnostics.Rings++
+					}
+				}
+			}
+			groupDiagnostics.EstimatedBytes = int64(groupDiagnostics.Rings*storage.config.Intervals) * consumerOffsetSize
+			groups[group] = groupDiagnostics
+		}
+		clusterMap.consumerLock.RUnlock()
+
+		clusterMap.archive.lock.RLock()
+		for group, topics := range clusterMap.archive.groups {
+			groupDiagnostics, ok := groups[group]
+			if !ok {
+				// The group has expired, but its archive hasn't yet
+				groupDiagnostics = &GroupDiagnostics{Group: group}
+				groups[group] = groupDiagnostics
+			}
+			for _, partitions := range topics {
+				for _, archived := range partitions {
+					groupDiagnostics.ArchivedOffsets += len(archived)
+				}
+			}
+			groupDiagnostics.EstimatedBytes += int64(groupDiagnostics.ArchivedOffsets) * archivedOffsetSize
+		}
+		clusterMap.archive.lock.RUnlock()
+
+		clusterMap.expectedLock.RLock()
+		clusterDiagnostics.ExpectedGroups = len(clusterMap.expected)
+		clusterMap.expectedLock.RUnlock()
+
+		largest := make([]*GroupDiagnostics, 0, len(groups))
+		for _, groupDiagnostics := range groups {
+			clusterDiagnostics.Groups++
+			clusterDiagnostics.GroupPartitions += groupDiagnostics.Partitions
+			clusterDiagnostics.GroupRings += groupDiagnostics.Rings
+			clusterDiagnostics.ArchivedOffsets += groupDiagnostics.ArchivedOffsets
+			clusterDiagnostics.EstimatedBytes += groupDiagnostics.EstimatedBytes
+			largest = append(largest, groupDiagnostics)
+		}
+		sort.Slice(largest, func(i, j int) bool {
+			if largest[i].EstimatedBytes != largest[j].EstimatedBytes {
+				return largest[i].EstimatedBytes > largest[j].EstimatedBytes
+			}
+			return largest[i].Group < largest[j].Group
+		})
+		if len(largest) > topGroups {
+			largest = largest[:topGroups]
+		}
+		clusterDiagnostics.LargestGroups = largest
+
+		diagnostics.Clusters[cluster] = clusterDiagnostics
+	}
+
+	request.Result <- diagnostics
+}
@@ -0,0 +1,79 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Ephemeral groups are ones that are created for a single run and never come back, such as the groups with random
+// names that the console consumer uses. They can be handled in one of two ways (EphemeralMode):
+//   - collapse: their offsets are all stored under one group (EphemeralGroupName), which is listed but not evaluated
+//   - expire: they are removed after EphemeralExpire seconds without commits, rather than ExpireGroup, and any later
+//     commits for the same group are dropped (for ExpireGroup seconds)
+const (
+	EphemeralModeOff      = ""
+	EphemeralModeCollapse = "collapse"
+	EphemeralModeExpire   = "expire"
+)
+
+// The built-in patterns for ephemeral groups: the console consumer, and KSQL transient queries
+var DefaultEphemeralGroups = []string{
+	`^console-consumer-[0-9]+$`,
+	`^_confluent-ksql-.*transient_.*$`,
+}
+
+// Compile the built-in and configured patterns into one regular expression
+func compileEphemeralGroups(patterns []string) (*regexp.Regexp, error) {
+	all := append(append([]string{}, DefaultEphemeralGroups...), patterns...)
+	return regexp.Compile("(" + strings.Join(all, ")|(") + ")")
+}
+
+func (storage *OffsetStorage) isEphemeral(group string) bool {
+	return (storage.ephemeralGroups != nil) && storage.ephemeralGroups.MatchString(group)
+}
+
+// Whether the group is the one that ephemeral groups are collapsed into
+func (storage *OffsetStorage) isCollapsedGroup(group string) bool {
+	return (storage.config.EphemeralMode == EphemeralModeCollapse) && (group == storage.config.EphemeralGroupName)
+}
+
+// How long the group is kept without commits before it is removed, in seconds
+func (storage *OffsetStorage) groupExpiry(group string) int64 {
+	if (storage.config.EphemeralMode == EphemeralModeExpire) && storage.isEphemeral(group) {
+		return storage.config.EphemeralExpire
+	}
+	return storage.config.ExpireGroup
+}
+
+// Check whether an ephemeral group has expired, so its commits should be dropped
+func (clusterOffsets *ClusterOffsets) ephemeralExpired(group string) bool {
+	clusterOffsets.consumerLock.RLock()
+	defer clusterOffsets.consumerLock.RUnlock()
+	_, ok := clusterOffsets.expiredEphemeral[group]
+	return ok
+}
+
+// Forget the ephemeral groups that expired more than ExpireGroup seconds ago. This runs on the archive ticker
+func (storage *OffsetStorage) pruneEphemeral() {
+	cutoff := time.Now().Unix()*1000 - storage.config.ExpireGroup*1000
+	for _, clusterOffsets := range storage.offsets {
+		clusterOffsets.consumerLock.Lock()
+		for group, expired := range clusterOffsets.expiredEphemeral {
+			if expired < cutoff {
+				delete(clusterOffsets.expiredEphemeral, group)
+			}
+		}
+		clusterOffsets.consumerLock.Unlock()
+	}
+}
@@ -0,0 +1,225 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"errors"
+	log "github.com/cihub/seelog"
+	"sort"
+	"time"
+)
+
+// An expected group is a consumer group that has been declared (in the config or via the HTTP API) as one that should
+// be committing offsets. Unlike groups we only know about because we have seen commits, an expected group that never
+// commits (or does not commit for some of its topics) is reported as an error.
+//
+// A group can also have a schedule, given as a cron expression for the start of a window during which it is expected
+// to commit (such as a daily batch job). Outside of the window, a stopped group is not an error, but a group that did
+// not commit during the most recent window is
+type ExpectedGroup struct {
+	Group    string   `json:"group"`
+	Topics   []string `json:"topics"`
+	Schedule string   `json:"schedule,omitempty"`
+	Window   int64    `json:"window,omitempty"`
+	Source   string   `json:"source"`
+	schedule *CronSchedule
+}
+
+type RequestExpectedGroupList struct {
+	Result  chan []*ExpectedGroup
+	Cluster string
+}
+type RequestExpectedGroupSet struct {
+	Result   chan StatusConstant
+	Cluster  string
+	Expected *ExpectedGroup
+}
+type RequestExpectedGroupDelete struct {
+	Result  chan StatusConstant
+	Cluster string
+	Group   string
+}
+
+// Create an expected group. If a schedule is given, it is parsed here, and the window defaults to an hour
+func NewExpectedGroup(group string, topics []string, schedule string, window int64, source string) (*ExpectedGroup, error) {
+	expected := &ExpectedGroup{
+		Group:    group,
+		Topics:   topics,
+		Schedule: schedule,
+		Window:   window,
+		Source:   source,
+	}
+	if schedule != "" {
+		var err error
+		if expected.schedule, err = ParseCronSchedule(schedule); err != nil {
+			return nil, err
+		}
+		if expected.Window <= 0 {
+			expected.Window = 3600
+		}
+	}
+	return expected, nil
+}
+
+// Load the expected groups from the configuration into the storage module. This is called before the storage
+// goroutine is started, so no locking is needed
+func (storage *OffsetStorage) loadExpectedGroups() error {
+	for _, cfg := range storage.config.ExpectedGroups {
+		clusterMap, ok := storage.offsets[cfg.Cluster]
+		if !ok {
+			return errors.New("expected group " + cfg.Group + " has an unknown cluster " + cfg.Cluster)
+		}
+		expected, err := NewExpectedGroup(cfg.Group, cfg.Topics, cfg.Schedule, cfg.Window, "config")
+		if err != nil {
+			return err
+		}
+		clusterMap.expected[cfg.Group] = expected
+	}
+	return nil
+}
+
+// Return the start and end of the most recent scheduled window at or before now, and whether or not now is inside it
+func (expected *ExpectedGroup) scheduledWindow(now time.Time) (time.Time, time.Time, bool) {
+	windowStart := expected.schedule.Prev(now)
+	windowEnd := windowStart.Add(time.Duration(expected.Window) * time.Second)
+	return windowStart, windowEnd, (!windowStart.IsZero()) && now.Before(windowEnd)
+}
+
+// Returns true if the group is expected on a schedule and we are currently outside of its window. Stopped partitions
+// are not an error for such a group
+func (storage *OffsetStorage) outsideScheduledWindow(clusterMap *ClusterOffsets, group string) bool {
+	clusterMap.expectedLock.RLock()
+	expected, ok := clusterMap.expected[group]
+	clusterMap.expectedLock.RUnlock()
+	if (!ok) || (expected.schedule == nil) {
+		return false
+	}
+
+	_, _, inWindow := expected.scheduledWindow(time.Now())
+	return !inWindow
+}
+
+func (storage *OffsetStorage) requestExpectedGroupList(request *RequestExpectedGroupList) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- make([]*ExpectedGroup, 0)
+		return
+	}
+
+	clusterMap.expectedLock.RLock()
+	expectedList := make([]*ExpectedGroup, 0, len(clusterMap.expected))
+	for _, expected := range clusterMap.expected {
+		expectedList = append(expectedList, expected)
+	}
+	clusterMap.expectedLock.RUnlock()
+
+	request.Result <- expectedList
+}
+
+func (storage *OffsetStorage) setExpectedGroup(request *RequestExpectedGroupSet) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- StatusNotFound
+		return
+	}
+
+	clusterMap.expectedLock.Lock()
+	log.Infof("Setting expected group %s in cluster %s with topics %v by request", request.Expected.Group, request.Cluster,
+		request.Expected.Topics)
+	clusterMap.expected[request.Expected.Group] = request.Expected
+	clusterMap.expectedLock.Unlock()
+
+	request.Result <- StatusOK
+}
+
+func (storage *OffsetStorage) deleteExpectedGroup(request *RequestExpectedGroupDelete) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- StatusNotFound
+		return
+	}
+
+	clusterMap.expectedLock.Lock()
+	if _, ok := clusterMap.expected[request.Group]; ok {
+		log.Infof("Removing expected group %s from cluster %s by request", request.Group, request.Cluster)
+		delete(clusterMap.expected, request.Group)
+		request.Result <- StatusOK
+	} else {
+		request.Result <- StatusNotFound
+	}
+	clusterMap.expectedLock.Unlock()
+}
+
+// If the group is expected, check that it exists and that it is consuming all the topics it is expected to. A nil
+// topics map means that the group has no offsets at all. Any gap in coverage makes the group an error. lastCommit is
+// the timestamp of the most recent (non-artificial) commit for the group
+func (storage *OffsetStorage) applyGroupExpectation(clusterMap *ClusterOffsets, status *ConsumerGroupStatus, topics map[string][][]ConsumerOffset,
+	lastCommit int64, tracef func(string, ...interface{})) {
+	clusterMap.expectedLock.RLock()
+	expected, ok := clusterMap.expected[status.Group]
+	clusterMap.expectedLock.RUnlock()
+	if !ok {
+		return
+	}
+	status.Expected = true
+
+	if expected.schedule != nil {
+		windowStart, windowEnd, inWindow := expected.scheduledWindow(time.Now())
+		switch {
+		case inWindow:
+			// The group may not have gotten to all of its topics yet, so coverage is only checked after the window
+			tracef("inside scheduled window %v to %v", windowStart, windowEnd)
+			if topics == nil {
+				status.Status = StatusOK
+				status.Complete = false
+				status.Missing = true
+			}
+			return
+		case (!windowStart.IsZero()) && (!windowStart.Before(storage.startTime)) && (lastCommit < windowStart.Unix()*1000):
+			// We were running for the entire window, and the group did not commit during it
+			tracef("no commits during scheduled window %v to %v, ERR", windowStart, windowEnd)
+			status.Status = StatusError
+			status.Complete = false
+			status.Missing = (topics == nil)
+			status.MissedWindow = windowStart.Unix() * 1000
+			return
+		case topics == nil:
+			// We have not seen a full window yet, so we don't know if the group is running
+			tracef("expected group has no committed offsets, but no full scheduled window has been seen yet")
+			status.Status = StatusOK
+			status.Complete = false
+			status.Missing = true
+			return
+		}
+	}
+
+	if topics == nil {
+		tracef("expected group has no committed offsets, ERR")
+		status.Status = StatusError
+		status.Complete = false
+		status.Missing = true
+		status.MissingTopics = expected.Topics
+		return
+	}
+
+	missingTopics := make([]string, 0)
+	for _, topic := range expected.Topics {
+		if _, ok := topics[topic]; !ok {
+			missingTopics = append(missingTopics, topic)
+		}
+	}
+	if len(missingTopics) > 0 {
+		sort.Strings(missingTopics)
+		tracef("expected group has no committed offsets for topics %v, ERR", missingTopics)
+		status.Status = StatusError
+		status.MissingTopics = missingTopics
+	}
+}
@@ -0,0 +1,128 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+	log "github.com/cihub/seelog"
+)
+
+// Merge a checkpoint from another instance (such as the one this instance is replacing) into the offset rings while
+// offsets are coming in. Unlike restoring a checkpoint at startup, the offsets that are already stored are kept: for
+// each ring, only the offsets in the checkpoint that are older than everything in the ring are added, ahead of the
+// ring's own. Clusters that are not configured here are skipped
+func (storage *OffsetStorage) MergeCheckpoint(checkpoint *Checkpoint) {
+	for cluster, clusterCheckpoint := range checkpoint.Clusters {
+		clusterMap, ok := storage.offsets[cluster]
+		if !ok {
+			continue
+		}
+
+		clusterMap.brokerLock.Lock()
+		for topicName, topicCheckpoint := range clusterCheckpoint.Broker {
+			topic, ok := clusterMap.broker[topicName]
+			if !ok {
+				topic = newTopicPartitions(len(topicCheckpoint.Partitions))
+				clusterMap.broker[topicName] = topic
+				if group := storage.deadLetterGroup(topicName); group != "" {
+					clusterMap.deadLetter[group] = append(clusterMap.deadLetter[group], topicName)
+				}
+			} else if len(topicCheckpoint.Partitions) > len(topic.partitions) {
+				topic = topic.grow(len(topicCheckpoint.Partitions))
+				clusterMap.broker[topicName] = topic
+			}
+			for i, offset := range topicCheckpoint.Partitions {
+				if topic.partitions[i] == nil {
+					topic.partitions[i] = offset
+				}
+			}
+			for i, history := range topicCheckpoint.History {
+				if i >= len(topic.history) {
+					continue
+				}
+				saved := make([]interface{}, len(history))
+				for j, offset := range history {
+					saved[j] = offset
+				}
+				topic.history[i] = mergeRing(saved, topic.history[i], storage.config.BrokerIntervals, func(val interface{}) int64 {
+					return val.(*BrokerOffset).Timestamp
+				})
+			}
+		}
+		clusterMap.brokerLock.Unlock()
+
+		clusterMap.consumerLock.Lock()
+		for group, topics := range clusterCheckpoint.Consumer {
+			if _, ok := clusterMap.consumer[group]; !ok {
+				clusterMap.consumer[group] = make(map[string][]*ring.Ring, len(topics))
+			}
+			for topic, partitions := range topics {
+				rings := growConsumerPartitions(clusterMap.consumer[group][topic], len(partitions))
+				for i, offsets := range partitions {
+					saved := make([]interface{}, len(offsets))
+					for j, offset := range offsets {
+						saved[j] = offset
+					}
+					rings[i] = mergeRing(saved, rings[i], storage.config.Intervals, func(val interface{}) int64 {
+						return val.(*ConsumerOffset).Timestamp
+					})
+				}
+				clusterMap.consumer[group][topic] = rings
+			}
+		}
+		for group, firstCommit := range clusterCheckpoint.FirstCommit {
+			if current, ok := clusterMap.firstCommit[group]; !ok || (firstCommit < current) {
+				clusterMap.firstCommit[group] = firstCommit
+			}
+		}
+		clusterMap.consumerLock.Unlock()
+
+		// Metadata that is set here is newer than the checkpoint's
+		clusterMap.metadataLock.Lock()
+		for group, metadata := range clusterCheckpoint.Metadata {
+			if _, ok := clusterMap.metadata[group]; !ok {
+				clusterMap.metadata[group] = metadata
+			}
+		}
+		clusterMap.metadataLock.Unlock()
+	}
+	log.Infof("Merged offsets from the checkpoint taken at %v", checkpoint.Time)
+}
+
+// Return a new ring of the given size with the saved offsets (oldest first) that are older than the oldest one in
+// current, followed by the ones in current. If there are more than fit, the oldest are dropped. If there is nothing
+// to add, current is returned as it is
+func mergeRing(saved []interface{}, current *ring.Ring, size int, timestamp func(interface{}) int64) *ring.Ring {
+	var values []interface{}
+	if current != nil {
+		values = ringValues(current)
+	}
+	merged := make([]interface{}, 0, len(saved)+len(values))
+	for _, val := range saved {
+		if (len(values) == 0) || (timestamp(val) < timestamp(values[0])) {
+			merged = append(merged, val)
+		}
+	}
+	if len(merged) == 0 {
+		return current
+	}
+	merged = append(merged, values...)
+	if len(merged) > size {
+		merged = merged[len(merged)-size:]
+	}
+
+	r := ring.New(size)
+	for _, val := range merged {
+		r.Value = val
+		r = r.Next()
+	}
+	return r
+}
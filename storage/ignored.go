@@ -0,0 +1,152 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"errors"
+	log "github.com/cihub/seelog"
+	"sort"
+	"time"
+)
+
+// An ignored partition is left out of the evaluation of every group that consumes it, such as a corrupted partition
+// that is waiting to be recreated. Ignored partitions are set in the config or via the HTTP API
+type IgnoredPartition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Reason    string `json:"reason"`
+	Source    string `json:"source"`
+	Added     int64  `json:"added"`
+}
+
+type RequestIgnoredPartitionList struct {
+	Result  chan []*IgnoredPartition
+	Cluster string
+}
+type RequestIgnoredPartitionSet struct {
+	Result  chan StatusConstant
+	Cluster string
+	Ignored *IgnoredPartition
+}
+type RequestIgnoredPartitionDelete struct {
+	Result    chan StatusConstant
+	Cluster   string
+	Topic     string
+	Partition int32
+}
+
+// Load the ignored partitions from the configuration into the storage module. This is called before the storage
+// goroutine is started, so no locking is needed
+func (storage *OffsetStorage) loadIgnoredPartitions() error {
+	for _, cfg := range storage.config.IgnoredPartitions {
+		clusterMap, ok := storage.offsets[cfg.Cluster]
+		if !ok {
+			return errors.New("ignored partition of topic " + cfg.Topic + " has an unknown cluster " + cfg.Cluster)
+		}
+		clusterMap.setIgnored(&IgnoredPartition{
+			Topic:     cfg.Topic,
+			Partition: cfg.Partition,
+			Reason:    cfg.Reason,
+			Source:    "config",
+			Added:     storage.startTime.Unix() * 1000,
+		})
+	}
+	return nil
+}
+
+// Must be called with the ignored lock held (or before the storage goroutine is started)
+func (clusterMap *ClusterOffsets) setIgnored(ignored *IgnoredPartition) {
+	if _, ok := clusterMap.ignored[ignored.Topic]; !ok {
+		clusterMap.ignored[ignored.Topic] = make(map[int32]*IgnoredPartition)
+	}
+	clusterMap.ignored[ignored.Topic][ignored.Partition] = ignored
+}
+
+// Return a copy of the ignored partitions for the cluster as a set of topic and partition, so that a group can be
+// evaluated without holding the lock
+func (clusterMap *ClusterOffsets) ignoredPartitions() map[string]map[int32]bool {
+	clusterMap.ignoredLock.RLock()
+	defer clusterMap.ignoredLock.RUnlock()
+
+	ignored := make(map[string]map[int32]bool, len(clusterMap.ignored))
+	for topic, partitions := range clusterMap.ignored {
+		ignored[topic] = make(map[int32]bool, len(partitions))
+		for partition := range partitions {
+			ignored[topic][partition] = true
+		}
+	}
+	return ignored
+}
+
+func (storage *OffsetStorage) requestIgnoredPartitionList(request *RequestIgnoredPartitionList) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- make([]*IgnoredPartition, 0)
+		return
+	}
+
+	clusterMap.ignoredLock.RLock()
+	ignoredList := make([]*IgnoredPartition, 0)
+	for _, partitions := range clusterMap.ignored {
+		for _, ignored := range partitions {
+			ignoredList = append(ignoredList, ignored)
+		}
+	}
+	clusterMap.ignoredLock.RUnlock()
+
+	sort.Slice(ignoredList, func(i, j int) bool {
+		if ignoredList[i].Topic != ignoredList[j].Topic {
+			return ignoredList[i].Topic < ignoredList[j].Topic
+		}
+		return ignoredList[i].Partition < ignoredList[j].Partition
+	})
+	request.Result <- ignoredList
+}
+
+func (storage *OffsetStorage) setIgnoredPartition(request *RequestIgnoredPartitionSet) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- StatusNotFound
+		return
+	}
+
+	if request.Ignored.Added == 0 {
+		request.Ignored.Added = time.Now().Unix() * 1000
+	}
+	clusterMap.ignoredLock.Lock()
+	log.Infof("Ignoring partition %s:%v in cluster %s by request: %s", request.Ignored.Topic, request.Ignored.Partition,
+		request.Cluster, request.Ignored.Reason)
+	clusterMap.setIgnored(request.Ignored)
+	clusterMap.ignoredLock.Unlock()
+
+	request.Result <- StatusOK
+}
+
+func (storage *OffsetStorage) deleteIgnoredPartition(request *RequestIgnoredPartitionDelete) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- StatusNotFound
+		return
+	}
+
+	clusterMap.ignoredLock.Lock()
+	if _, ok := clusterMap.ignored[request.Topic][request.Partition]; ok {
+		log.Infof("No longer ignoring partition %s:%v in cluster %s by request", request.Topic, request.Partition, request.Cluster)
+		delete(clusterMap.ignored[request.Topic], request.Partition)
+		if len(clusterMap.ignored[request.Topic]) == 0 {
+			delete(clusterMap.ignored, request.Topic)
+		}
+		request.Result <- StatusOK
+	} else {
+		request.Result <- StatusNotFound
+	}
+	clusterMap.ignoredLock.Unlock()
+}
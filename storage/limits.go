@@ -0,0 +1,355 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+	log "github.com/cihub/seelog"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Limits keep one cluster from using up the memory and evaluation time every other cluster needs, such as when
+// automation creates a new group for every run. When a new group or topic would take a cluster over one of its limits,
+// the cluster's LimitMode decides what happens:
+//   - stop-adding: it is not stored, and offsets for it are dropped (with the reason "limit")
+//   - evict-oldest: the groups that have gone longest without a commit (or the topics with the oldest broker offsets)
+//     are removed to make room, down to 90% of the limit so that this is not done for every new one
+//   - sample: it takes the place of a random one that is stored, with a chance of the limit over how many have been
+//     seen, so what is stored stays a random sample of what the cluster has
+//
+// A group or topic that is turned away or evicted is kept out, rather than offered again with each of its offsets, so
+// the stored groups and topics don't churn. It is offered again once the cluster is under 90% of the limit, or after
+// ExpireGroup seconds without an offset for it (such as a topic that was deleted and created again)
+const (
+	LimitModeStopAdding  = "stop-adding"
+	LimitModeEvictOldest = "evict-oldest"
+	LimitModeSample      = "sample"
+)
+
+// The limits a cluster can reach
+const (
+	LimitGroups     = "groups"
+	LimitTopics     = "topics"
+	LimitPartitions = "partitions"
+)
+
+// A limit a cluster has reached. Since and Last are when it was first and last reached (in milliseconds), and Current
+// is how many are stored now. Refused counts the groups or topics that were turned away, Evicted the ones that were
+// removed to make room, and Excluded the ones of either that are being kept out now
+type LimitBreach struct {
+	Limit    string `json:"limit"`
+	Max      int    `json:"max"`
+	Current  int    `json:"current"`
+	Mode     string `json:"mode"`
+	Since    int64  `json:"since"`
+	Last     int64  `json:"last"`
+	Refused  int64  `json:"refused"`
+	Evicted  int64  `json:"evicted"`
+	Excluded int    `json:"excluded"`
+
+	// How many new groups or topics have been offered since the limit was reached
+	offered int64
+}
+
+// A group or topic that is kept out by a limit, and when an offset for it was last seen (in milliseconds)
+type limitedName struct {
+	limit    string
+	lastSeen int64
+}
+
+// Whether count is no more than percent of max. A limit that is not positive is not enforced
+func withinLimit(count int, max int, percent int) bool {
+	return (max <= 0) || (count*100 <= max*percent)
+}
+
+// Return the limits the cluster has reached, by name. A limit is reported while groups or topics are kept out by it,
+// and until ExpireGroup seconds have passed without it being reached again
+func (storage *OffsetStorage) LimitBreaches(cluster string) []*LimitBreach {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return nil
+	}
+	current := make(map[string]int)
+	excluded := make(map[string]int)
+	clusterMap.consumerLock.RLock()
+	current[LimitGroups] = len(clusterMap.consumer)
+	for _, limited := range clusterMap.limitedGroups {
+		excluded[limited.limit]++
+	}
+	clusterMap.consumerLock.RUnlock()
+	clusterMap.brokerLock.RLock()
+	current[LimitTopics] = len(clusterMap.broker)
+	current[LimitPartitions] = clusterMap.partitionCount()
+	for _, limited := range clusterMap.limitedTopics {
+		excluded[limited.limit]++
+	}
+	clusterMap.brokerLock.RUnlock()
+
+	clusterMap.limitLock.Lock()
+	defer clusterMap.limitLock.Unlock()
+	cutoff := time.Now().Unix()*1000 - storage.config.ExpireGroup*1000
+	breaches := make([]*LimitBreach, 0, len(clusterMap.limits))
+	for name, breach := range clusterMap.limits {
+		if (breach.Last < cutoff) && (excluded[name] == 0) {
+			log.Infof("Cluster %s is back under its limit of %v %s", cluster, breach.Max, name)
+			delete(clusterMap.limits, name)
+			continue
+		}
+		reported := *breach
+		reported.Current = current[name]
+		reported.Excluded = excluded[name]
+		breaches = append(breaches, &reported)
+	}
+	sort.Slice(breaches, func(i, j int) bool { return breaches[i].Limit < breaches[j].Limit })
+	return breaches
+}
+
+// Count a new group or topic against the limit, as refused or as having evicted others to make room for it
+func (clusterMap *ClusterOffsets) recordLimit(cluster string, limit string, max int, mode string, evicted int) {
+	clusterMap.limitLock.Lock()
+	defer clusterMap.limitLock.Unlock()
+
+	now := time.Now().Unix() * 1000
+	breach, ok := clusterMap.limits[limit]
+	if !ok {
+		breach = &LimitBreach{Limit: limit, Max: max, Mode: mode, Since: now}
+		clusterMap.limits[limit] = breach
+		log.Warnf("Cluster %s reached its limit of %v %s, new ones are now handled with %s", cluster, max, limit, mode)
+	}
+	breach.Last = now
+	breach.offered++
+	if evicted > 0 {
+		breach.Evicted += int64(evicted)
+	} else {
+		breach.Refused++
+	}
+}
+
+// In sample mode, whether a new group or topic should take the place of one that is stored. As each one is only
+// offered once, taking it with a chance of the limit over how many have been seen keeps a random sample of them
+func (clusterMap *ClusterOffsets) sampleLimit(limit string, max int) bool {
+	clusterMap.limitLock.Lock()
+	defer clusterMap.limitLock.Unlock()
+
+	seen := int64(max) + 1
+	if breach, ok := clusterMap.limits[limit]; ok {
+		seen += breach.offered
+	}
+	return rand.Int63n(seen) < int64(max)
+}
+
+// Decide whether a new group can be stored, making room for it if the cluster's limit mode allows. This must be called
+// with the consumer lock held
+func (storage *OffsetStorage) admitGroup(clusterMap *ClusterOffsets, cluster string, group string) bool {
+	limits := storage.config.Clusters[cluster]
+	if (limits == nil) || (limits.MaxGroups <= 0) {
+		return true
+	}
+	now := time.Now().Unix() * 1000
+	if limited, ok := clusterMap.limitedGroups[group]; ok {
+		limited.lastSeen = now
+		if !withinLimit(len(clusterMap.consumer)+1, limits.MaxGroups, 90) {
+			return false
+		}
+		delete(clusterMap.limitedGroups, group)
+	}
+	if withinLimit(len(clusterMap.consumer)+1, limits.MaxGroups, 100) {
+		return true
+	}
+
+	evicted := make([]string, 0)
+	switch limits.LimitMode {
+	case LimitModeEvictOldest:
+		for _, victim := range clusterMap.oldestGroups() {
+			if withinLimit(len(clusterMap.consumer)-len(evicted)+1, limits.MaxGroups, 90) {
+				break
+			}
+			evicted = append(evicted, victim)
+		}
+	case LimitModeSample:
+		if clusterMap.sampleLimit(LimitGroups, limits.MaxGroups) {
+			// Map iteration order is random enough to pick which group makes way
+			for victim := range clusterMap.consumer {
+				evicted = append(evicted, victim)
+				break
+			}
+		}
+	}
+	for _, victim := range evicted {
+		delete(clusterMap.consumer, victim)
+		delete(clusterMap.firstCommit, victim)
+		clusterMap.limitedGroups[victim] = &limitedName{limit: LimitGroups, lastSeen: now}
+	}
+	if len(evicted) == 0 {
+		clusterMap.limitedGroups[group] = &limitedName{limit: LimitGroups, lastSeen: now}
+	}
+	clusterMap.recordLimit(cluster, LimitGroups, limits.MaxGroups, limits.LimitMode, len(evicted))
+	if len(evicted) > 0 {
+		log.Debugf("Evicted %v groups from cluster %s to make room for group %s", len(evicted), cluster, group)
+	}
+	return len(evicted) > 0
+}
+
+// Return every group, starting with the ones that have gone longest without a commit
+func (clusterMap *ClusterOffsets) oldestGroups() []string {
+	lastCommits := make(map[string]int64, len(clusterMap.consumer))
+	groups := make([]string, 0, len(clusterMap.consumer))
+	for group, topics := range clusterMap.consumer {
+		for _, partitions := range topics {
+			for _, offsetRing := range partitions {
+				if last := lastRingTimestamp(offsetRing); last > lastCommits[group] {
+					lastCommits[group] = last
+				}
+			}
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return lastCommits[groups[i]] < lastCommits[groups[j]] })
+	return groups
+}
+
+func lastRingTimestamp(offsetRing *ring.Ring) int64 {
+	if offsetRing == nil {
+		return 0
+	}
+	if offset, ok := offsetRing.Prev().Value.(*ConsumerOffset); ok && (offset != nil) {
+		return offset.Timestamp
+	}
+	return 0
+}
+
+// Decide whether a new topic with the given number of partitions can be stored, making room for it if the cluster's
+// limit mode allows. This must be called with the broker lock held
+func (storage *OffsetStorage) admitTopic(clusterMap *ClusterOffsets, cluster string, topic string, partitions int) bool {
+	limits := storage.config.Clusters[cluster]
+	if (limits == nil) || ((limits.MaxTopics <= 0) && (limits.MaxPartitions <= 0)) {
+		return true
+	}
+	topics, total := len(clusterMap.broker), clusterMap.partitionCount()
+	fits := func(percent int) bool {
+		return withinLimit(topics+1, limits.MaxTopics, percent) && withinLimit(total+partitions, limits.MaxPartitions, percent)
+	}
+	now := time.Now().Unix() * 1000
+	if limited, ok := clusterMap.limitedTopics[topic]; ok {
+		limited.lastSeen = now
+		if !fits(90) {
+			return false
+		}
+		delete(clusterMap.limitedTopics, topic)
+	}
+	if fits(100) {
+		return true
+	}
+	limit, max := LimitPartitions, limits.MaxPartitions
+	if !withinLimit(topics+1, limits.MaxTopics, 100) {
+		limit, max = LimitTopics, limits.MaxTopics
+	}
+
+	// Topics are removed until the new one fits (for evict-oldest, with 10% of the limit to spare)
+	var victims []string
+	percent := 100
+	switch {
+	case !withinLimit(partitions, limits.MaxPartitions, 100):
+		// The topic can't fit even on its own
+	case limits.LimitMode == LimitModeEvictOldest:
+		victims = clusterMap.oldestTopics()
+		percent = 90
+	case (limits.LimitMode == LimitModeSample) && clusterMap.sampleLimit(limit, max):
+		victims = make([]string, 0, len(clusterMap.broker))
+		for victim := range clusterMap.broker {
+			victims = append(victims, victim)
+		}
+		rand.Shuffle(len(victims), func(i, j int) { victims[i], victims[j] = victims[j], victims[i] })
+	}
+
+	evicted := 0
+	for _, victim := range victims {
+		if fits(percent) {
+			break
+		}
+		topics--
+		total -= len(clusterMap.broker[victim].partitions)
+		clusterMap.removeTopic(victim)
+		clusterMap.limitedTopics[victim] = &limitedName{limit: limit, lastSeen: now}
+		evicted++
+	}
+	if evicted == 0 {
+		clusterMap.limitedTopics[topic] = &limitedName{limit: limit, lastSeen: now}
+	}
+	clusterMap.recordLimit(cluster, limit, max, limits.LimitMode, evicted)
+	if evicted > 0 {
+		log.Debugf("Evicted %v topics from cluster %s to make room for topic %s", evicted, cluster, topic)
+	}
+	return evicted > 0
+}
+
+// Return every topic, starting with the ones whose newest broker offset is the oldest
+func (clusterMap *ClusterOffsets) oldestTopics() []string {
+	lastOffsets := make(map[string]int64, len(clusterMap.broker))
+	topics := make([]string, 0, len(clusterMap.broker))
+	for name, topic := range clusterMap.broker {
+		for _, partition := range topic.partitions {
+			if (partition != nil) && (partition.Timestamp > lastOffsets[name]) {
+				lastOffsets[name] = partition.Timestamp
+			}
+		}
+		topics = append(topics, name)
+	}
+	sort.Slice(topics, func(i, j int) bool { return lastOffsets[topics[i]] < lastOffsets[topics[j]] })
+	return topics
+}
+
+// Remove a topic's broker offsets, and the topic from the dead letter topics of its group
+func (clusterMap *ClusterOffsets) removeTopic(topic string) {
+	delete(clusterMap.broker, topic)
+	for group, topics := range clusterMap.deadLetter {
+		for i, name := range topics {
+			if name == topic {
+				clusterMap.deadLetter[group] = append(topics[:i:i], topics[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// The number of partitions of all of the cluster's topics. This must be called with the broker lock held
+func (clusterMap *ClusterOffsets) partitionCount() int {
+	count := 0
+	for _, topic := range clusterMap.broker {
+		count += len(topic.partitions)
+	}
+	return count
+}
+
+// Forget the groups and topics that were kept out by a limit, but haven't had an offset for ExpireGroup seconds. This
+// runs on the archive ticker
+func (storage *OffsetStorage) pruneLimited() {
+	cutoff := time.Now().Unix()*1000 - storage.config.ExpireGroup*1000
+	for _, clusterMap := range storage.offsets {
+		clusterMap.consumerLock.Lock()
+		for group, limited := range clusterMap.limitedGroups {
+			if limited.lastSeen < cutoff {
+				delete(clusterMap.limitedGroups, group)
+			}
+		}
+		clusterMap.consumerLock.Unlock()
+
+		clusterMap.brokerLock.Lock()
+		for topic, limited := range clusterMap.limitedTopics {
+			if limited.lastSeen < cutoff {
+				delete(clusterMap.limitedTopics, topic)
+			}
+		}
+		clusterMap.brokerLock.Unlock()
+	}
+}
@@ -0,0 +1,85 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// The member of a group that a partition is assigned to, from the group's metadata in the offsets topic. This is the
+// host (or pod) to restart when the partition is stuck, rather than every consumer in the group
+type PartitionOwner struct {
+	MemberId   string `json:"member_id"`
+	ClientId   string `json:"client_id"`
+	ClientHost string `json:"client_host"`
+}
+
+// A member of a group, and the partitions of each topic that are assigned to it
+type GroupMember struct {
+	PartitionOwner
+	Assignment map[string][]int32
+}
+
+// Replace the members of a group. This is sent for every group metadata message in the offsets topic, and no members
+// (the group is empty, or was deleted) removes them. Nothing is sent back. Members are only kept in memory, as they
+// are read again from the offsets topic on a restart
+type RequestGroupMembersSet struct {
+	Cluster string
+	Group   string
+	Members []*GroupMember
+}
+
+func (storage *OffsetStorage) setGroupMembers(request *RequestGroupMembersSet) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		return
+	}
+
+	owners := make(map[string]map[int32]*PartitionOwner)
+	for _, member := range request.Members {
+		owner := &PartitionOwner{MemberId: member.MemberId, ClientId: member.ClientId, ClientHost: member.ClientHost}
+		for topic, partitions := range member.Assignment {
+			if _, ok := owners[topic]; !ok {
+				owners[topic] = make(map[int32]*PartitionOwner, len(partitions))
+			}
+			for _, partition := range partitions {
+				owners[topic][partition] = owner
+			}
+		}
+	}
+
+	clusterMap.membersLock.Lock()
+	if len(owners) == 0 {
+		delete(clusterMap.members, request.Group)
+	} else {
+		clusterMap.members[request.Group] = owners
+	}
+	clusterMap.membersLock.Unlock()
+	log.Debugf("Set members of group %s in cluster %s: %v members", request.Group, request.Cluster, len(request.Members))
+}
+
+// Return the owner of each partition of a group by topic, or nil if the group has no members. This is not copied, as
+// the owners of a group are replaced, never changed
+func (clusterMap *ClusterOffsets) partitionOwners(group string) map[string]map[int32]*PartitionOwner {
+	clusterMap.membersLock.RLock()
+	defer clusterMap.membersLock.RUnlock()
+	return clusterMap.members[group]
+}
+
+// Set the owner of the partitions of a topic that were evaluated, and of the one with the most lag
+func attributePartitions(result *ConsumerGroupStatus, owners map[int32]*PartitionOwner) {
+	for _, partition := range result.Partitions {
+		partition.Owner = owners[partition.Partition]
+	}
+	if result.Maxlag != nil {
+		result.Maxlag.Owner = owners[result.Maxlag.Partition]
+	}
+}
@@ -0,0 +1,107 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+)
+
+// Limits on group metadata, so that it stays small enough to be put in every status result and notification
+const (
+	MaxGroupMetadataKeys        = 32
+	MaxGroupMetadataKeyLength   = 64
+	MaxGroupMetadataValueLength = 1024
+)
+
+// Group metadata is a set of key/values that are set on a group via the HTTP API (such as its owner, runbook, or a
+// severity override), and returned in its status. It is kept in checkpoints, so it survives a restart if a checkpoint
+// backend is configured
+type RequestGroupMetadata struct {
+	Result  chan map[string]string
+	Cluster string
+	Group   string
+}
+type RequestGroupMetadataSet struct {
+	Result   chan StatusConstant
+	Cluster  string
+	Group    string
+	Metadata map[string]string
+}
+
+// Check that metadata is within the limits
+func ValidateGroupMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxGroupMetadataKeys {
+		return fmt.Errorf("no more than %v keys can be set", MaxGroupMetadataKeys)
+	}
+	for key, value := range metadata {
+		if key == "" {
+			return errors.New("keys cannot be empty")
+		}
+		if len(key) > MaxGroupMetadataKeyLength {
+			return fmt.Errorf("key %s is longer than %v characters", key, MaxGroupMetadataKeyLength)
+		}
+		if len(value) > MaxGroupMetadataValueLength {
+			return fmt.Errorf("value of key %s is longer than %v characters", key, MaxGroupMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// Return a copy of the metadata for a group, or nil if it has none
+func (clusterMap *ClusterOffsets) groupMetadata(group string) map[string]string {
+	clusterMap.metadataLock.RLock()
+	defer clusterMap.metadataLock.RUnlock()
+
+	metadata, ok := clusterMap.metadata[group]
+	if !ok {
+		return nil
+	}
+	metadataCopy := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		metadataCopy[key] = value
+	}
+	return metadataCopy
+}
+
+func (storage *OffsetStorage) requestGroupMetadata(request *RequestGroupMetadata) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- nil
+		return
+	}
+	request.Result <- clusterMap.groupMetadata(request.Group)
+}
+
+// Replace the metadata for a group. Setting no metadata removes it
+func (storage *OffsetStorage) setGroupMetadata(request *RequestGroupMetadataSet) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- StatusNotFound
+		return
+	}
+
+	metadata := make(map[string]string, len(request.Metadata))
+	for key, value := range request.Metadata {
+		metadata[key] = value
+	}
+	clusterMap.metadataLock.Lock()
+	if len(metadata) == 0 {
+		delete(clusterMap.metadata, request.Group)
+	} else {
+		clusterMap.metadata[request.Group] = metadata
+	}
+	clusterMap.metadataLock.Unlock()
+
+	log.Infof("Set metadata for group %s in cluster %s by request: %v keys", request.Group, request.Cluster, len(metadata))
+	request.Result <- StatusOK
+}
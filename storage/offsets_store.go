@@ -0,0 +1,1914 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A broker offset (if Group is empty) or consumer offset commit. For broker offsets, TopicPartitionCount must be set,
+// and OldestOffset and StableOffset should be -1 if they are not known
+type PartitionOffset struct {
+	Cluster             string
+	Topic               string
+	Partition           int32
+	Offset              int64
+	OldestOffset        int64
+	StableOffset        int64
+	Timestamp           int64
+	Group               string
+	TopicPartitionCount int
+
+	// For broker offsets, when the partition's leader last changed (in milliseconds), or 0 if it hasn't been seen to
+	LeaderChanged int64
+
+	// For consumer offsets, where the commit came from (one of the OffsetSource constants)
+	Source string
+}
+
+// Where a consumer offset came from. Commits are read from Kafka's offsets topic or from Zookeeper (by the zookeeper,
+// storm, and checkpoint sources, or the test cluster). Storage adds artificial commits itself when a group has no lag,
+// and when a partition is marked caught up by request
+const (
+	OffsetSourceKafka      = "kafka-commit"
+	OffsetSourceZookeeper  = "zk"
+	OffsetSourceStorm      = "storm"
+	OffsetSourceCheckpoint = "checkpoint"
+	OffsetSourceTest       = "test"
+	OffsetSourceArtificial = "artificial"
+	OffsetSourceCaughtUp   = "caught-up"
+)
+
+type BrokerOffset struct {
+	Offset        int64
+	OldestOffset  int64
+	StableOffset  int64
+	Timestamp     int64
+	LeaderChanged int64
+}
+
+type ConsumerOffset struct {
+	Offset     int64 `json:"offset"`
+	Timestamp  int64 `json:"timestamp"`
+	Lag        int64 `json:"lag"`
+	artificial bool
+
+	// The timestamp as an ISO-8601 time in the display location. This is only set in status results
+	Time string `json:"time,omitempty"`
+
+	// Where the offset came from, so that a suspicious commit can be traced back to the path that made it
+	Source string `json:"source,omitempty"`
+}
+
+type DroppedOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Group     string `json:"group"`
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+	Reason    string `json:"reason"`
+	DroppedAt int64  `json:"dropped_at"`
+}
+
+type ClusterOffsets struct {
+	broker           map[string]*topicPartitions
+	compacted        map[string]bool
+	offline          map[string]map[int32]bool
+	skew             clockSkew
+	deadLetter       map[string][]string
+	consumer         map[string]map[string][]*ring.Ring
+	dropped          *ring.Ring
+	dropCounts       map[string]int64
+	dropTotal        uint64
+	tombstones       map[string]*tombstone
+	expiredEphemeral map[string]int64
+	firstCommit      map[string]int64
+	watchingSince    int64
+	expected         map[string]*ExpectedGroup
+	ignored          map[string]map[int32]*IgnoredPartition
+	metadata         map[string]map[string]string
+	members          map[string]map[string]map[int32]*PartitionOwner
+	limits           map[string]*LimitBreach
+	limitedGroups    map[string]*limitedName
+	limitedTopics    map[string]*limitedName
+	readCommitted    *regexp.Regexp
+	commitMapping    []*commitMapping
+	archive          *OffsetArchive
+	paused           int64
+	priorityGroups   map[string]int64
+	brokerLock       *sync.RWMutex
+	consumerLock     *sync.RWMutex
+	droppedLock      *sync.Mutex
+	expectedLock     *sync.RWMutex
+	ignoredLock      *sync.RWMutex
+	limitLock        *sync.Mutex
+	metadataLock     *sync.RWMutex
+	membersLock      *sync.RWMutex
+	pauseLock        *sync.RWMutex
+	priorityLock     *sync.RWMutex
+}
+type commitMapping struct {
+	groups      *regexp.Regexp
+	dataCluster string
+}
+
+type priorityTopic struct {
+	topics    *regexp.Regexp
+	maxLag    int64
+	stopGrace int64
+}
+
+type OffsetStorage struct {
+	config           *Config
+	priorityTopics   []*priorityTopic
+	rollupPolicies   []*rollupPolicy
+	deadLetterRules  []*deadLetterRule
+	quit             chan struct{}
+	OffsetChannel    chan *PartitionOffset
+	RequestChannel   chan interface{}
+	offsets          map[string]*ClusterOffsets
+	pipelines        map[string]*clusterPipeline
+	groupTagRules    []*regexp.Regexp
+	ephemeralGroups  *regexp.Regexp
+	GroupBlacklist   *regexp.Regexp
+	TopicBlacklist   *regexp.Regexp
+	startTime        time.Time
+	archiveTicker    *time.Ticker
+	checkpointTicker *time.Ticker
+}
+
+type StatusConstant int
+
+const (
+	StatusNotFound  StatusConstant = 0
+	StatusOK        StatusConstant = 1
+	StatusWarning   StatusConstant = 2
+	StatusError     StatusConstant = 3
+	StatusStop      StatusConstant = 4
+	StatusStall     StatusConstant = 5
+	StatusRewind    StatusConstant = 6
+	StatusRetention StatusConstant = 7
+	StatusWarming   StatusConstant = 8
+	StatusOffline   StatusConstant = 9
+)
+
+var StatusStrings = [...]string{"NOTFOUND", "OK", "WARN", "ERR", "STOP", "STALL", "REWIND", "RETENTION", "WARMING",
+	"PARTITION_OFFLINE"}
+
+func (c StatusConstant) String() string {
+	if (c >= 0) && (c < StatusConstant(len(StatusStrings))) {
+		return StatusStrings[c]
+	} else {
+		return "UNKNOWN"
+	}
+}
+func (c StatusConstant) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+func (c StatusConstant) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+func (c *StatusConstant) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	for i, name := range StatusStrings {
+		if name == str {
+			*c = StatusConstant(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status %s", str)
+}
+
+type PartitionStatus struct {
+	Topic           string         `json:"topic"`
+	Partition       int32          `json:"partition"`
+	Status          StatusConstant `json:"status"`
+	Start           ConsumerOffset `json:"start"`
+	End             ConsumerOffset `json:"end"`
+	TimeToRetention int64          `json:"time_to_retention"`
+	Compacted       bool           `json:"compacted"`
+	Priority        bool           `json:"priority,omitempty"`
+
+	// The partition's leader changed during the window that was evaluated, so a bad status may be from the failover
+	RecentLeaderChange bool `json:"recent_leader_change"`
+
+	// The member of the group the partition is assigned to, if it is known
+	Owner *PartitionOwner `json:"owner,omitempty"`
+}
+
+type ConsumerGroupStatus struct {
+	Cluster          string              `json:"cluster"`
+	Group            string              `json:"group"`
+	Status           StatusConstant      `json:"status"`
+	Complete         bool                `json:"complete"`
+	Partitions       []*PartitionStatus  `json:"partitions"`
+	TotalPartitions  int                 `json:"partition_count"`
+	Maxlag           *PartitionStatus    `json:"maxlag"`
+	TotalLag         uint64              `json:"totallag"`
+	Expected         bool                `json:"expected"`
+	Missing          bool                `json:"missing"`
+	MissingTopics    []string            `json:"missing_topics"`
+	MissedWindow     int64               `json:"missed_window,omitempty"`
+	Tags             map[string]string   `json:"tags,omitempty"`
+	Metadata         map[string]string   `json:"metadata,omitempty"`
+	PausedAt         int64               `json:"paused_at,omitempty"`
+	WarmingUntil     int64               `json:"warming_until,omitempty"`
+	PausedAtTime     string              `json:"paused_at_time,omitempty"`
+	WarmingUntilTime string              `json:"warming_until_time,omitempty"`
+	DeadLetter       []*DeadLetterStatus `json:"dead_letter,omitempty"`
+	Trace            []string            `json:"trace,omitempty"`
+}
+
+type ResponseTopicList struct {
+	TopicList []string
+	Error     bool
+}
+type RequestBrokerOffsets struct {
+	Result  chan map[string][]int64
+	Cluster string
+}
+type ResponseOffsets struct {
+	OffsetList       []int64
+	StableOffsetList []int64
+	ErrorGroup       bool
+	ErrorTopic       bool
+}
+type RequestClusterList struct {
+	Result chan *ResponseClusterList
+}
+
+// The clusters that offsets are stored for, with counts of what is stored for each of them, all taken at SnapshotAt
+type ResponseClusterList struct {
+	Clusters   []string
+	Counts     map[string]*ClusterCounts
+	SnapshotAt int64
+}
+
+// What is stored for a cluster, and how fresh it is. LastBrokerOffset and LastCommit are the timestamps (in
+// milliseconds) of the newest broker offset and consumer commit, or 0 if there are none
+type ClusterCounts struct {
+	Groups           int   `json:"groups"`
+	Topics           int   `json:"topics"`
+	Partitions       int   `json:"partitions"`
+	LastBrokerOffset int64 `json:"last_broker_offset"`
+	LastCommit       int64 `json:"last_commit"`
+}
+type RequestConsumerList struct {
+	Result  chan []string
+	Cluster string
+}
+type RequestTopicList struct {
+	Result  chan *ResponseTopicList
+	Cluster string
+	Group   string
+}
+type ResponseTopicRate struct {
+	Rates      []float64
+	TotalRate  float64
+	Window     int64
+	ErrorTopic bool
+}
+type RequestTopicRate struct {
+	Result  chan *ResponseTopicRate
+	Cluster string
+	Topic   string
+}
+type RequestOffsets struct {
+	Result  chan *ResponseOffsets
+	Cluster string
+	Topic   string
+	Group   string
+}
+type RequestConsumerOffsets struct {
+	Result  chan map[string][]*ConsumerOffset
+	Cluster string
+	Group   string
+}
+type RequestConsumerStatus struct {
+	Result  chan *ConsumerGroupStatus
+	Cluster string
+	Group   string
+	Showall bool
+	Trace   bool
+
+	// If set, the group is evaluated with these parameters instead of the configured ones, as a simulation
+	Params *EvaluationParams
+
+	// If set, the group is evaluated as of this time (in milliseconds) from the offset archive
+	At int64
+
+	// If set, the partitions of each topic are sent on this as soon as the topic is evaluated, so that they can be
+	// streamed to a client. Every partition is sent before the result, and the channel is never closed
+	Partitions chan *PartitionStatus
+
+	// If set, the evaluation is a routine one rather than someone looking at the group, so it doesn't make the group a
+	// priority group when catching up on offsets
+	Background bool
+}
+type RequestConsumerDrop struct {
+	Result  chan StatusConstant
+	Cluster string
+	Group   string
+}
+type RequestConsumerRestore struct {
+	Result  chan StatusConstant
+	Cluster string
+	Group   string
+}
+type RequestMarkCaughtUp struct {
+	Result    chan StatusConstant
+	Cluster   string
+	Group     string
+	Topic     string
+	Partition int32
+}
+type RequestDroppedOffsets struct {
+	Result  chan []*DroppedOffset
+	Cluster string
+}
+
+func NewOffsetStorage(config *Config) (*OffsetStorage, error) {
+	storage := &OffsetStorage{
+		config:         config,
+		quit:           make(chan struct{}),
+		OffsetChannel:  make(chan *PartitionOffset, 10000),
+		RequestChannel: make(chan interface{}),
+		offsets:        make(map[string]*ClusterOffsets),
+		pipelines:      make(map[string]*clusterPipeline),
+		startTime:      time.Now(),
+	}
+
+	if config.GroupBlacklist != "" {
+		re, err := regexp.Compile(config.GroupBlacklist)
+		if err != nil {
+			return nil, err
+		}
+		storage.GroupBlacklist = re
+	}
+
+	if config.EphemeralMode != EphemeralModeOff {
+		re, err := compileEphemeralGroups(config.EphemeralGroups)
+		if err != nil {
+			return nil, err
+		}
+		storage.ephemeralGroups = re
+	}
+
+	if config.TopicBlacklist != "" {
+		re, err := regexp.Compile(config.TopicBlacklist)
+		if err != nil {
+			return nil, err
+		}
+		storage.TopicBlacklist = re
+	}
+
+	for cluster, _ := range config.Clusters {
+		storage.offsets[cluster] = &ClusterOffsets{
+			broker:           make(map[string]*topicPartitions),
+			compacted:        make(map[string]bool),
+			offline:          make(map[string]map[int32]bool),
+			consumer:         make(map[string]map[string][]*ring.Ring),
+			dropped:          ring.New(config.DroppedOffsets),
+			dropCounts:       make(map[string]int64),
+			tombstones:       make(map[string]*tombstone),
+			expiredEphemeral: make(map[string]int64),
+			firstCommit:      make(map[string]int64),
+			deadLetter:       make(map[string][]string),
+			priorityGroups:   make(map[string]int64),
+			expected:         make(map[string]*ExpectedGroup),
+			ignored:          make(map[string]map[int32]*IgnoredPartition),
+			metadata:         make(map[string]map[string]string),
+			members:          make(map[string]map[string]map[int32]*PartitionOwner),
+			limits:           make(map[string]*LimitBreach),
+			limitedGroups:    make(map[string]*limitedName),
+			limitedTopics:    make(map[string]*limitedName),
+			archive:          NewOffsetArchive(),
+			brokerLock:       &sync.RWMutex{},
+			consumerLock:     &sync.RWMutex{},
+			droppedLock:      &sync.Mutex{},
+			expectedLock:     &sync.RWMutex{},
+			ignoredLock:      &sync.RWMutex{},
+			limitLock:        &sync.Mutex{},
+			metadataLock:     &sync.RWMutex{},
+			membersLock:      &sync.RWMutex{},
+			pauseLock:        &sync.RWMutex{},
+			priorityLock:     &sync.RWMutex{},
+		}
+		storage.pipelines[cluster] = newClusterPipeline(cluster)
+
+		// Groups that consume with read_committed have their lag calculated against the last stable offset
+		if config.Clusters[cluster].ReadCommittedGroups != "" {
+			re, err := regexp.Compile(config.Clusters[cluster].ReadCommittedGroups)
+			if err != nil {
+				return nil, err
+			}
+			storage.offsets[cluster].readCommitted = re
+		}
+	}
+	for _, rule := range config.GroupTagRules {
+		re, err := CompileGroupTagRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		storage.groupTagRules = append(storage.groupTagRules, re)
+	}
+	for _, priority := range config.PriorityTopics {
+		re, err := regexp.Compile(priority.Topics)
+		if err != nil {
+			return nil, err
+		}
+		storage.priorityTopics = append(storage.priorityTopics, &priorityTopic{
+			topics:    re,
+			maxLag:    priority.MaxLag,
+			stopGrace: priority.StopGrace,
+		})
+	}
+	for _, deadLetter := range config.DeadLetterTopics {
+		re, err := CompileDeadLetterRule(deadLetter.Topics)
+		if err != nil {
+			return nil, err
+		}
+		storage.deadLetterRules = append(storage.deadLetterRules, &deadLetterRule{
+			topics:  re,
+			maxRate: deadLetter.MaxRate,
+		})
+	}
+	for _, policy := range config.RollupPolicies {
+		re, err := regexp.Compile(policy.Groups)
+		if err != nil {
+			return nil, err
+		}
+		storage.rollupPolicies = append(storage.rollupPolicies, &rollupPolicy{
+			groups:       re,
+			policy:       policy.Policy,
+			errorPercent: policy.ErrorPercent,
+		})
+	}
+	for _, mapping := range config.CommitMappings {
+		commitCluster, ok := storage.offsets[mapping.CommitCluster]
+		if !ok {
+			return nil, fmt.Errorf("commit mapping has unknown commit cluster %s", mapping.CommitCluster)
+		}
+		if _, ok := storage.offsets[mapping.DataCluster]; !ok {
+			return nil, fmt.Errorf("commit mapping has unknown data cluster %s", mapping.DataCluster)
+		}
+		re, err := regexp.Compile(mapping.Group)
+		if err != nil {
+			return nil, err
+		}
+		commitCluster.commitMapping = append(commitCluster.commitMapping, &commitMapping{groups: re, dataCluster: mapping.DataCluster})
+	}
+	if err := storage.loadExpectedGroups(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadIgnoredPartitions(); err != nil {
+		return nil, err
+	}
+	if config.Backend != nil {
+		checkpoint, err := config.Backend.Load()
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			storage.restoreCheckpoint(checkpoint)
+		}
+	}
+	storage.archiveTicker = time.NewTicker(time.Duration(config.ArchiveInterval) * time.Second)
+	if config.Backend != nil {
+		storage.checkpointTicker = time.NewTicker(time.Duration(config.CheckpointInterval) * time.Second)
+		go func() {
+			for {
+				select {
+				case <-storage.checkpointTicker.C:
+					storage.saveCheckpoint()
+				case <-storage.quit:
+					return
+				}
+			}
+		}()
+	}
+
+	if config.DropLogInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(config.DropLogInterval) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					storage.reportDroppedOffsets()
+				case <-storage.quit:
+					return
+				}
+			}
+		}()
+	}
+
+	for _, pipeline := range storage.pipelines {
+		go storage.runPipeline(pipeline)
+	}
+
+	// Offsets and requests on the shared channels are routed to their cluster's pipeline. Offsets are routed separately,
+	// so that a cluster with a full offset channel doesn't hold up requests
+	go func() {
+		for {
+			select {
+			case o := <-storage.OffsetChannel:
+				storage.routeOffset(o)
+			case <-storage.quit:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-storage.archiveTicker.C:
+				go storage.pruneArchives()
+				go storage.pruneTombstones()
+				go storage.pruneEphemeral()
+				go storage.pruneLimited()
+				go storage.prunePriorityGroups()
+			case r := <-storage.RequestChannel:
+				storage.routeRequest(r)
+			case <-storage.quit:
+				return
+			}
+		}
+	}()
+
+	return storage, nil
+}
+
+func (storage *OffsetStorage) addBrokerOffset(offset *PartitionOffset) {
+	clusterMap, ok := storage.offsets[offset.Cluster]
+	if !ok {
+		// Ignore offsets for clusters that we don't know about - should never happen anyways
+		return
+	}
+	if clusterMap.pausedAt() > 0 {
+		return
+	}
+
+	if (offset.Partition < 0) || (int(offset.Partition) >= offset.TopicPartitionCount) {
+		log.Warnf("Got a broker offset for a partition outside the topic: cluster=%s topic=%s partition=%v partitions=%v",
+			offset.Cluster, offset.Topic, offset.Partition, offset.TopicPartitionCount)
+		return
+	}
+
+	clusterMap.brokerLock.Lock()
+	topic, ok := clusterMap.broker[offset.Topic]
+	if !ok {
+		if !storage.admitTopic(clusterMap, offset.Cluster, offset.Topic, offset.TopicPartitionCount) {
+			clusterMap.brokerLock.Unlock()
+			return
+		}
+		topic = newTopicPartitions(offset.TopicPartitionCount)
+		clusterMap.broker[offset.Topic] = topic
+		if group := storage.deadLetterGroup(offset.Topic); group != "" {
+			clusterMap.deadLetter[group] = append(clusterMap.deadLetter[group], offset.Topic)
+		}
+	} else if offset.TopicPartitionCount > len(topic.partitions) {
+		// The partition count has increased. Swap in a longer copy of the topic rather than growing the one readers
+		// may already have
+		topic = topic.grow(offset.TopicPartitionCount)
+		clusterMap.broker[offset.Topic] = topic
+		log.Infof("Partitions expanded: cluster=%s topic=%s partitions=%v version=%v",
+			offset.Cluster, offset.Topic, offset.TopicPartitionCount, topic.version)
+	}
+
+	partitionEntry := topic.partitions[offset.Partition]
+	if partitionEntry == nil {
+		topic.partitions[offset.Partition] = &BrokerOffset{
+			Offset:        offset.Offset,
+			OldestOffset:  offset.OldestOffset,
+			StableOffset:  offset.StableOffset,
+			Timestamp:     offset.Timestamp,
+			LeaderChanged: offset.LeaderChanged,
+		}
+	} else {
+		partitionEntry.Offset = offset.Offset
+		partitionEntry.OldestOffset = offset.OldestOffset
+		partitionEntry.StableOffset = offset.StableOffset
+		partitionEntry.Timestamp = offset.Timestamp
+		partitionEntry.LeaderChanged = offset.LeaderChanged
+	}
+
+	// Keep a short history of broker offsets for each partition so we can calculate produce rates
+	if topic.history[offset.Partition] == nil {
+		topic.history[offset.Partition] = ring.New(storage.config.BrokerIntervals)
+	}
+	topic.history[offset.Partition].Value = &BrokerOffset{
+		Offset:       offset.Offset,
+		OldestOffset: offset.OldestOffset,
+		StableOffset: offset.StableOffset,
+		Timestamp:    offset.Timestamp,
+	}
+	topic.history[offset.Partition] = topic.history[offset.Partition].Next()
+
+	clusterMap.brokerLock.Unlock()
+	if storage.config.BrokerHook != nil {
+		storage.config.BrokerHook(offset)
+	}
+}
+
+// Return the broker offset that a group's lag should be calculated against. For groups that consume with
+// read_committed, this is the last stable offset (if we have it), as they can't read past open transactions
+func (clusterMap *ClusterOffsets) lagOffset(group string, brokerOffset *BrokerOffset) int64 {
+	if (clusterMap.readCommitted != nil) && (brokerOffset.StableOffset >= 0) && clusterMap.readCommitted.MatchString(group) {
+		return brokerOffset.StableOffset
+	}
+	return brokerOffset.Offset
+}
+
+// Replace the set of compacted topics for the cluster
+func (storage *OffsetStorage) SetCompactedTopics(cluster string, topics map[string]bool) {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return
+	}
+
+	clusterMap.brokerLock.Lock()
+	clusterMap.compacted = topics
+	clusterMap.brokerLock.Unlock()
+}
+
+// Replace the set of partitions of the cluster that have no leader. These get no new broker offsets, and their
+// consumers can't commit, so they are PARTITION_OFFLINE instead of being evaluated
+func (storage *OffsetStorage) SetOfflinePartitions(cluster string, partitions map[string]map[int32]bool) {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return
+	}
+
+	clusterMap.brokerLock.Lock()
+	clusterMap.offline = partitions
+	clusterMap.brokerLock.Unlock()
+}
+
+// Calculate the produce rate (messages per second) for a partition from its broker offset history. Also returns the
+// length of the window the rate was calculated over, in milliseconds. If there are not enough offsets in the history,
+// the rate and window are both zero
+func brokerOffsetRate(history *ring.Ring) (float64, int64) {
+	var oldest, newest *BrokerOffset
+	if history != nil {
+		history.Do(func(val interface{}) {
+			if val == nil {
+				return
+			}
+			ptr, _ := val.(*BrokerOffset)
+			if oldest == nil {
+				oldest = ptr
+			}
+			newest = ptr
+		})
+	}
+	if (oldest == nil) || (newest.Timestamp <= oldest.Timestamp) {
+		return 0, 0
+	}
+
+	window := newest.Timestamp - oldest.Timestamp
+	return float64(newest.Offset-oldest.Offset) * 1000 / float64(window), window
+}
+
+// Estimate how many seconds it will be until the oldest offset on the broker passes the consumer's offset, assuming
+// that retention removes messages from the head of the partition as fast as they are produced. Returns -1 if the
+// consumer is keeping up (or we can't tell)
+func timeToRetention(consumerOffset int64, oldestOffset int64, produceRate float64, consumeRate float64) int64 {
+	closingRate := produceRate - consumeRate
+	if (oldestOffset < 0) || (closingRate <= 0) {
+		return -1
+	}
+	if consumerOffset <= oldestOffset {
+		return 0
+	}
+	return int64(float64(consumerOffset-oldestOffset) / closingRate)
+}
+
+func (storage *OffsetStorage) addConsumerOffset(offset *PartitionOffset) {
+	// Ignore offsets for clusters that we don't know about - should never happen anyways
+	clusterOffsets, ok := storage.offsets[offset.Cluster]
+	if !ok {
+		return
+	}
+	storage.recordClockSkew(offset.Cluster, clusterOffsets, offset.Timestamp, time.Now().UnixNano()/int64(time.Millisecond))
+
+	// If the group commits to this cluster but consumes from another, store the offset under the other cluster
+	for _, mapping := range clusterOffsets.commitMapping {
+		if mapping.groups.MatchString(offset.Group) {
+			mappedOffset := *offset
+			mappedOffset.Cluster = mapping.dataCluster
+			offset = &mappedOffset
+			clusterOffsets = storage.offsets[mapping.dataCluster]
+			break
+		}
+	}
+	if clusterOffsets.pausedAt() > 0 {
+		return
+	}
+
+	// Ignore groups that match our blacklist
+	if (storage.GroupBlacklist != nil) && storage.GroupBlacklist.MatchString(offset.Group) || (storage.TopicBlacklist != nil) && storage.TopicBlacklist.MatchString(offset.Topic) {
+		storage.recordDroppedOffset(clusterOffsets, offset, "blacklist")
+		return
+	}
+
+	// Ephemeral groups are either stored under the one collapsed group, or dropped once they have expired
+	if storage.isEphemeral(offset.Group) {
+		if storage.config.EphemeralMode == EphemeralModeCollapse {
+			collapsedOffset := *offset
+			collapsedOffset.Group = storage.config.EphemeralGroupName
+			offset = &collapsedOffset
+		} else if clusterOffsets.ephemeralExpired(offset.Group) {
+			storage.recordDroppedOffset(clusterOffsets, offset, "ephemeral")
+			return
+		}
+	}
+
+	// Get broker partition count and offset for this topic and partition first
+	clusterOffsets.brokerLock.RLock()
+	topic, ok := clusterOffsets.broker[offset.Topic]
+	if !ok {
+		// We don't know about this topic from the brokers yet - skip consumer offsets for now
+		clusterOffsets.brokerLock.RUnlock()
+		storage.recordDroppedOffset(clusterOffsets, offset, "no topic")
+		return
+	}
+	if offset.Partition < 0 {
+		// This should never happen, but if it does, log an warning with the offset information for review
+		log.Warnf("Got a negative partition ID: cluster=%s topic=%s partition=%v group=%s timestamp=%v offset=%v",
+			offset.Cluster, offset.Topic, offset.Partition, offset.Group, offset.Timestamp, offset.Offset)
+		clusterOffsets.brokerLock.RUnlock()
+		storage.recordDroppedOffset(clusterOffsets, offset, "negative partition")
+		return
+	}
+	if offset.Partition >= int32(len(topic.partitions)) {
+		// We know about the topic, but partitions have been expanded and we haven't seen that from the broker yet
+		clusterOffsets.brokerLock.RUnlock()
+		storage.recordDroppedOffset(clusterOffsets, offset, "expanded")
+		return
+	}
+	if topic.partitions[offset.Partition] == nil {
+		// We know about the topic and partition, but we haven't actually gotten the broker offset yet
+		clusterOffsets.brokerLock.RUnlock()
+		storage.recordDroppedOffset(clusterOffsets, offset, "broker offset")
+		return
+	}
+	brokerOffset := clusterOffsets.lagOffset(offset.Group, topic.partitions[offset.Partition])
+	partitionCount := len(topic.partitions)
+	clusterOffsets.brokerLock.RUnlock()
+
+	clusterOffsets.consumerLock.Lock()
+	if clusterOffsets.watchingSince == 0 {
+		clusterOffsets.watchingSince = time.Now().Unix() * 1000
+	}
+	consumerMap, ok := clusterOffsets.consumer[offset.Group]
+	if !ok {
+		if !storage.admitGroup(clusterOffsets, offset.Cluster, offset.Group) {
+			clusterOffsets.consumerLock.Unlock()
+			storage.recordDroppedOffset(clusterOffsets, offset, "limit")
+			return
+		}
+		clusterOffsets.consumer[offset.Group] = make(map[string][]*ring.Ring)
+		consumerMap = clusterOffsets.consumer[offset.Group]
+		clusterOffsets.firstCommit[offset.Group] = offset.Timestamp
+	}
+	consumerTopicMap, ok := consumerMap[offset.Topic]
+	if !ok {
+		consumerTopicMap = make([]*ring.Ring, partitionCount)
+		consumerMap[offset.Topic] = consumerTopicMap
+	} else if partitionCount > len(consumerTopicMap) {
+		// The partition count has increased since the group first committed to the topic
+		consumerTopicMap = growConsumerPartitions(consumerTopicMap, partitionCount)
+		consumerMap[offset.Topic] = consumerTopicMap
+	}
+
+	consumerPartitionRing := consumerTopicMap[offset.Partition]
+	if consumerPartitionRing == nil {
+		consumerTopicMap[offset.Partition] = ring.New(storage.config.Intervals)
+		consumerPartitionRing = consumerTopicMap[offset.Partition]
+	} else {
+		lastOffset := consumerPartitionRing.Prev().Value.(*ConsumerOffset)
+		timestampDifference := offset.Timestamp - lastOffset.Timestamp
+
+		// Prevent old offset commits, but only if the offsets don't advance (because of artifical commits below)
+		if (timestampDifference <= 0) && (offset.Offset <= lastOffset.Offset) {
+			clusterOffsets.consumerLock.Unlock()
+			storage.recordDroppedOffset(clusterOffsets, offset, "noadvance")
+			return
+		}
+
+		// Prevent new commits that are too fast (less than the min-distance config) if the last offset was not artificial
+		if (!lastOffset.artificial) && (timestampDifference >= 0) && (timestampDifference < (storage.config.MinDistance * 1000)) {
+			clusterOffsets.consumerLock.Unlock()
+			storage.recordDroppedOffset(clusterOffsets, offset, "mindistance")
+			return
+		}
+	}
+
+	// Calculate the lag against the brokerOffset
+	partitionLag := brokerOffset - offset.Offset
+	if partitionLag < 0 {
+		// Little bit of a hack - because we only get broker offsets periodically, it's possible the consumer offset could be ahead of where we think the broker
+		// is. In this case, just mark it as zero lag.
+		partitionLag = 0
+	}
+
+	// Update or create the ring value at the current pointer
+	if consumerPartitionRing.Value == nil {
+		consumerPartitionRing.Value = &ConsumerOffset{
+			Offset:     offset.Offset,
+			Timestamp:  offset.Timestamp,
+			Lag:        partitionLag,
+			artificial: false,
+			Source:     offset.Source,
+		}
+	} else {
+		ringval, _ := consumerPartitionRing.Value.(*ConsumerOffset)
+		ringval.Offset = offset.Offset
+		ringval.Timestamp = offset.Timestamp
+		ringval.Lag = partitionLag
+		ringval.artificial = false
+		ringval.Source = offset.Source
+	}
+
+	log.Tracef("Commit offset: cluster=%s topic=%s partition=%v group=%s timestamp=%v offset=%v lag=%v source=%s",
+		offset.Cluster, offset.Topic, offset.Partition, offset.Group, offset.Timestamp, offset.Offset,
+		partitionLag, offset.Source)
+
+	// Advance the ring pointer
+	consumerTopicMap[offset.Partition] = consumerTopicMap[offset.Partition].Next()
+	clusterOffsets.consumerLock.Unlock()
+
+	clusterOffsets.archive.record(offset, partitionLag, storage.config.ArchiveInterval, storage.config.ArchiveRetention)
+	if storage.config.CommitHook != nil {
+		storage.config.CommitHook(offset)
+	}
+}
+
+func (storage *OffsetStorage) pruneArchives() {
+	for _, clusterMap := range storage.offsets {
+		clusterMap.archive.prune(storage.config.ArchiveRetention)
+	}
+}
+
+// Keep a record of the offset and why it was dropped in the cluster's dropped offsets ring, and count it. Drops are
+// not logged here (other than the sampled ones), as this is called for every offset that is dropped
+func (storage *OffsetStorage) recordDroppedOffset(clusterOffsets *ClusterOffsets, offset *PartitionOffset, reason string) {
+	clusterOffsets.droppedLock.Lock()
+	clusterOffsets.dropCounts[reason]++
+	clusterOffsets.dropTotal++
+	sampled := (storage.config.DropLogSample > 0) && (clusterOffsets.dropTotal%uint64(storage.config.DropLogSample) == 0)
+	clusterOffsets.dropped.Value = &DroppedOffset{
+		Topic:     offset.Topic,
+		Partition: offset.Partition,
+		Group:     offset.Group,
+		Offset:    offset.Offset,
+		Timestamp: offset.Timestamp,
+		Reason:    reason,
+		DroppedAt: time.Now().Unix() * 1000,
+	}
+	clusterOffsets.dropped = clusterOffsets.dropped.Next()
+	clusterOffsets.droppedLock.Unlock()
+
+	if sampled {
+		log.Debugf("Dropped offset (%s, 1 in %v sampled): cluster=%s topic=%s partition=%v group=%s timestamp=%v offset=%v",
+			reason, storage.config.DropLogSample, offset.Cluster, offset.Topic, offset.Partition, offset.Group,
+			offset.Timestamp, offset.Offset)
+	}
+}
+
+// Log the number of offsets each cluster dropped for each reason since the last report, pass them to the drop hook,
+// and start counting again
+func (storage *OffsetStorage) reportDroppedOffsets() {
+	for cluster, clusterOffsets := range storage.offsets {
+		clusterOffsets.droppedLock.Lock()
+		counts := clusterOffsets.dropCounts
+		clusterOffsets.dropCounts = make(map[string]int64)
+		clusterOffsets.droppedLock.Unlock()
+		if len(counts) == 0 {
+			continue
+		}
+
+		reasons := make([]string, 0, len(counts))
+		for reason, count := range counts {
+			reasons = append(reasons, fmt.Sprintf("%s=%v", reason, count))
+		}
+		sort.Strings(reasons)
+		log.Debugf("Dropped offsets in the last %vs: cluster=%s %s", storage.config.DropLogInterval, cluster,
+			strings.Join(reasons, " "))
+		if storage.config.DropHook != nil {
+			storage.config.DropHook(cluster, counts)
+		}
+	}
+}
+
+func (storage *OffsetStorage) Stop() {
+	storage.archiveTicker.Stop()
+	close(storage.quit)
+
+	// Save the offsets one last time, so a restart picks up right where this left off
+	if storage.config.Backend != nil {
+		storage.checkpointTicker.Stop()
+		storage.saveCheckpoint()
+		storage.config.Backend.Close()
+	}
+}
+
+// Feed an offset to the storage module. Offsets are processed asynchronously
+func (storage *OffsetStorage) AddOffset(offset *PartitionOffset) {
+	storage.ClusterOffsetChannel(offset.Cluster) <- offset
+}
+
+// Send a request to the storage module, giving up if it is not accepted within the timeout. Returns false if the
+// request was not sent, in which case nothing will ever be sent on its result channel
+func (storage *OffsetStorage) SendRequest(request interface{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case storage.requestChannel(request) <- request:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Return why the storage module is overloaded for the cluster, or an empty string if it is not. It is overloaded when
+// the shared offset channel or the cluster's own offset channel is at least threshold percent full, as anything it
+// answers then is based on offsets that are behind
+func (storage *OffsetStorage) Overloaded(cluster string, threshold int) string {
+	if reason := channelOverloaded("offset", storage.OffsetChannel, threshold); reason != "" {
+		return reason
+	}
+	if pipeline, ok := storage.pipelines[cluster]; ok {
+		return channelOverloaded("cluster "+cluster+" offset", pipeline.offsets, threshold)
+	}
+	return ""
+}
+
+func channelOverloaded(name string, channel chan *PartitionOffset, threshold int) string {
+	depth := len(channel)
+	capacity := cap(channel)
+	if (capacity == 0) || (depth*100 < capacity*threshold) {
+		return ""
+	}
+	return fmt.Sprintf("%s channel is %v%% full (%v of %v offsets waiting)", name, depth*100/capacity, depth, capacity)
+}
+
+// Evaluate a consumer group. If showall is false, only the partitions that are not OK are included in the status
+func (storage *OffsetStorage) GroupStatus(cluster string, group string, showall bool) *ConsumerGroupStatus {
+	request := &RequestConsumerStatus{Result: make(chan *ConsumerGroupStatus), Cluster: cluster, Group: group, Showall: showall}
+	storage.RequestChannel <- request
+	return <-request.Result
+}
+
+// Return the clusters we store offsets for, with a consistent snapshot of their counts
+func (storage *OffsetStorage) ClusterList() *ResponseClusterList {
+	request := &RequestClusterList{Result: make(chan *ResponseClusterList)}
+	storage.RequestChannel <- request
+	return <-request.Result
+}
+
+// Return the list of consumer groups we have offsets for in the cluster
+func (storage *OffsetStorage) ConsumerList(cluster string) []string {
+	request := &RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+	storage.RequestChannel <- request
+	return <-request.Result
+}
+
+// Return the most recent offset for each partition that the group has committed for, by topic. Partitions that have
+// no offsets are nil, and if the group is not known nil is returned
+func (storage *OffsetStorage) ConsumerOffsets(cluster string, group string) map[string][]*ConsumerOffset {
+	request := &RequestConsumerOffsets{Result: make(chan map[string][]*ConsumerOffset), Cluster: cluster, Group: group}
+	storage.RequestChannel <- request
+	return <-request.Result
+}
+
+// Return how far the cluster is through warming up, or nil if the cluster is not known
+func (storage *OffsetStorage) WarmupStatus(cluster string) *WarmupStatus {
+	request := &RequestWarmupStatus{Result: make(chan *WarmupStatus), Cluster: cluster}
+	storage.RequestChannel <- request
+	return <-request.Result
+}
+
+// The time the storage module was started
+func (storage *OffsetStorage) StartTime() time.Time {
+	return storage.startTime
+}
+
+// Return the priority settings for a topic, or nil if it is not a priority topic. If more than one matches, the first
+// one configured is used
+func (storage *OffsetStorage) priorityTopicFor(topic string) *priorityTopic {
+	for _, priority := range storage.priorityTopics {
+		if priority.topics.MatchString(topic) {
+			return priority
+		}
+	}
+	return nil
+}
+
+// Evaluate a consumer group based on specific rules about lag
+// Rule 1:  If over the stored period, the lag is ever zero for the partition, the period is OK
+// Rule 2:  If the consumer offset does not change, and the lag is non-zero, it's an error (partition is stalled)
+// Rule 3:  If the consumer offsets are moving, but the lag is consistently increasing, it's a warning (consumer is slow)
+// Rule 4:  If the difference between now and the last offset timestamp is greater than the difference between the last and first offset timestamps, the
+//          consumer has stopped committing offsets for that partition (error), unless
+// Rule 5:  If the lag is -1, this is a special value that means there is no broker offset yet. Consider it good (will get caught in the next refresh of topics)
+// Rule 6:  If the consumer offset decreases from one interval to the next the partition is marked as a rewind (error)
+// Rule 7:  If the consumer will fall off the retention window of the partition in less than the configured retention-risk
+//          time, based on the oldest broker offset and the produce and consume rates, it is at risk of losing data (error)
+// Rule 8:  If the partition is in a priority topic with a lag threshold (or a simulation sets one), and the current lag
+//          is over it, it's a warning
+// Priority topics can also have a stop grace period that is shorter than the window for rule 4, and a priority
+// partition that is not OK always makes the group an error
+// If trace is set, every step of the evaluation is recorded in the Trace field of the result. If params is set, the group
+// is evaluated with them instead of the config, and nothing that is stored is changed (no artificial commits are
+// added, and expired groups are not removed)
+func (storage *OffsetStorage) evaluateGroup(cluster string, group string, resultChannel chan *ConsumerGroupStatus, showall bool, trace bool, params *EvaluationParams, partitionChannel chan *PartitionStatus) {
+	status := &ConsumerGroupStatus{
+		Cluster:    cluster,
+		Group:      group,
+		Status:     StatusNotFound,
+		Complete:   true,
+		Partitions: make([]*PartitionStatus, 0),
+		Maxlag:     nil,
+		TotalLag:   0,
+		Tags:       storage.GroupTags(group),
+	}
+	evalStart := time.Now()
+	tracef := func(format string, params ...interface{}) {
+		if trace {
+			status.Trace = append(status.Trace, fmt.Sprintf("+%v ", time.Since(evalStart))+fmt.Sprintf(format, params...))
+		}
+	}
+
+	simulate := params != nil
+	if !simulate {
+		params = storage.DefaultEvaluationParams()
+	}
+	defer func() {
+		if simulate {
+			return
+		}
+		if clusterMap, ok := storage.offsets[cluster]; ok {
+			storage.markAlertingGroup(clusterMap, status)
+		}
+		if storage.config.StatusHook != nil {
+			storage.config.StatusHook(status)
+		}
+	}()
+
+	// Make sure the cluster exists
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		tracef("cluster %s not found", cluster)
+		resultChannel <- status
+		return
+	}
+	status.Metadata = clusterMap.groupMetadata(group)
+	owners := clusterMap.partitionOwners(group)
+
+	// While the cluster is paused, the group is evaluated as it was when the pause started, and (as with a simulation)
+	// nothing that is stored is changed
+	now := storage.clusterNow(clusterMap)
+	if pausedAt := clusterMap.pausedAt(); pausedAt > 0 {
+		tracef("cluster is paused, evaluating as of %v", pausedAt)
+		status.PausedAt = pausedAt
+		status.PausedAtTime = storage.displayTime(pausedAt)
+		now = pausedAt
+		simulate = true
+	}
+
+	// Get the ignored partitions before locking, so we don't hold two locks at once
+	ignoredPartitions := clusterMap.ignoredPartitions()
+
+	// Make sure the group even exists
+	tracef("acquiring consumer lock")
+	clusterMap.consumerLock.Lock()
+	tracef("acquired consumer lock")
+	consumerMap, ok := clusterMap.consumer[group]
+	if !ok {
+		clusterMap.consumerLock.Unlock()
+		tracef("released consumer lock: group not found")
+		storage.applyGroupExpectation(clusterMap, status, nil, 0, tracef)
+		resultChannel <- status
+		return
+	}
+
+	// Scan the offsets table once and store all the offsets for the group locally
+	status.Status = StatusOK
+	offsetList := make(map[string][][]ConsumerOffset, len(consumerMap))
+	brokerList := make(map[string][]BrokerOffset, len(consumerMap))
+	produceRates := make(map[string][]float64, len(consumerMap))
+	compactedTopics := make(map[string]bool)
+	offlinePartitions := make(map[string]map[int32]bool)
+	var youngestOffset int64
+	var youngestCommit int64
+	clusterMap.brokerLock.RLock()
+	for topic, partitions := range consumerMap {
+		offsetList[topic] = make([][]ConsumerOffset, len(partitions))
+		brokerList[topic] = make([]BrokerOffset, len(partitions))
+		produceRates[topic] = make([]float64, len(partitions))
+		if clusterMap.compacted[topic] {
+			compactedTopics[topic] = true
+		}
+		if offline, ok := clusterMap.offline[topic]; ok {
+			offlinePartitions[topic] = offline
+		}
+		brokerTopic := clusterMap.broker[topic]
+		for partition, offsetRing := range partitions {
+			// Copy the broker information needed for the retention check
+			brokerPartition := brokerTopic.partition(partition)
+			if brokerPartition != nil {
+				brokerList[topic][partition] = *brokerPartition
+				produceRates[topic][partition], _ = brokerOffsetRate(brokerTopic.history[partition])
+			} else {
+				brokerList[topic][partition].OldestOffset = -1
+			}
+
+			// Ignored partitions are left out entirely, so they can't make the group incomplete either
+			if ignoredPartitions[topic][int32(partition)] {
+				tracef("%s:%v: partition is ignored", topic, partition)
+				continue
+			}
+
+			status.TotalPartitions += 1
+
+			// If we don't have our ring full yet, make sure we let the caller know
+			if (offsetRing == nil) || (offsetRing.Value == nil) {
+				tracef("%s:%v: offset ring is not full, group is incomplete", topic, partition)
+				status.Complete = false
+				continue
+			}
+
+			// Add an artificial offset commit if the consumer has no lag against the current broker offset. When
+			// simulating, this is only added to the copy of the offsets below
+			lastOffset := offsetRing.Prev().Value.(*ConsumerOffset)
+			var headOffset int64 = -1
+			if brokerPartition != nil {
+				headOffset = clusterMap.lagOffset(group, brokerPartition)
+			}
+			addArtificial := (brokerPartition != nil) && (lastOffset.Offset >= headOffset)
+			if addArtificial && (!simulate) {
+				ringval, _ := offsetRing.Value.(*ConsumerOffset)
+				ringval.Offset = lastOffset.Offset
+				ringval.Timestamp = now
+				ringval.Lag = 0
+				ringval.artificial = true
+				ringval.Source = OffsetSourceArtificial
+				partitions[partition] = partitions[partition].Next()
+
+				log.Tracef("Artificial offset: cluster=%s topic=%s partition=%v group=%s timestamp=%v offset=%v lag=0",
+					cluster, topic, partition, group, ringval.Timestamp, lastOffset.Offset)
+				tracef("%s:%v: artificial commit at offset %v (broker offset %v), lag 0", topic, partition,
+					lastOffset.Offset, headOffset)
+			}
+
+			// Pull out the offsets once so we can unlock the map
+			offsetList[topic][partition] = make([]ConsumerOffset, storage.config.Intervals)
+			partitionMap := offsetList[topic][partition]
+			idx := -1
+			partitions[partition].Do(func(val interface{}) {
+				idx += 1
+				ptr, _ := val.(*ConsumerOffset)
+				partitionMap[idx] = *ptr
+
+				// Track the youngest offset we have found to check expiration
+				if partitionMap[idx].Timestamp > youngestOffset {
+					youngestOffset = partitionMap[idx].Timestamp
+				}
+				if (!partitionMap[idx].artificial) && (partitionMap[idx].Timestamp > youngestCommit) {
+					youngestCommit = partitionMap[idx].Timestamp
+				}
+			})
+			if addArtificial && simulate {
+				copy(partitionMap, partitionMap[1:])
+				partitionMap[idx] = ConsumerOffset{
+					Offset:     lastOffset.Offset,
+					Timestamp:  now,
+					Lag:        0,
+					artificial: true,
+					Source:     OffsetSourceArtificial,
+				}
+				tracef("%s:%v: artificial commit at offset %v (broker offset %v), lag 0", topic, partition,
+					lastOffset.Offset, headOffset)
+			}
+
+			// A simulation can use a shorter window than is stored
+			if params.Intervals < len(partitionMap) {
+				offsetList[topic][partition] = partitionMap[len(partitionMap)-params.Intervals:]
+			}
+		}
+	}
+
+	clusterMap.brokerLock.RUnlock()
+
+	// If the youngest offset is earlier than our expiration window, flush the group
+	if (youngestOffset > 0) && (youngestOffset < (now - storage.groupExpiry(group)*1000)) {
+		if !simulate {
+			log.Infof("Removing expired group %s from cluster %s", group, cluster)
+			delete(clusterMap.consumer, group)
+			delete(clusterMap.firstCommit, group)
+			if (storage.config.EphemeralMode == EphemeralModeExpire) && storage.isEphemeral(group) {
+				clusterMap.expiredEphemeral[group] = now
+			}
+		}
+		clusterMap.consumerLock.Unlock()
+		tracef("released consumer lock: group expired (youngest offset %v)", youngestOffset)
+
+		// Return the group as a 404
+		status.Status = StatusNotFound
+		storage.applyGroupExpectation(clusterMap, status, nil, youngestCommit, tracef)
+		resultChannel <- status
+		return
+	}
+	firstCommit := clusterMap.firstCommit[group]
+	watchingSince := clusterMap.watchingSince
+	clusterMap.consumerLock.Unlock()
+	tracef("released consumer lock")
+
+	// The collapsed group has the commits of many groups that are unrelated, so the rules can't be applied to it
+	if storage.isCollapsedGroup(group) {
+		tracef("ephemeral groups are collapsed into this group, so it is not evaluated")
+		resultChannel <- status
+		return
+	}
+
+	// Groups that run on a schedule are allowed to be stopped outside of their window
+	suppressStop := storage.outsideScheduledWindow(clusterMap, group)
+
+	storage.evaluatePartitions(status, offsetList, brokerList, produceRates, compactedTopics, offlinePartitions, owners, params,
+		now, suppressStop, watchingSince, showall, partitionChannel, tracef)
+	storage.applyGroupExpectation(clusterMap, status, offsetList, youngestCommit, tracef)
+	storage.applyDeadLetter(clusterMap, status, tracef)
+	storage.applyBurnIn(status, firstCommit, now, tracef)
+	tracef("evaluation complete, group status is %v", status.Status)
+	resultChannel <- status
+}
+
+// A group is WARMING for the burn-in period after its first commit, whatever its partitions look like, as new groups
+// are often stopped or incomplete while they start up. The first commit is the commit timestamp (not when it was read),
+// so groups that were replayed from the offsets topic when Burrow started are not new
+func (storage *OffsetStorage) applyBurnIn(status *ConsumerGroupStatus, firstCommit int64, now int64,
+	tracef func(string, ...interface{})) {
+	if (storage.config.BurnIn <= 0) || (firstCommit == 0) {
+		return
+	}
+	until := firstCommit + storage.config.BurnIn*1000
+	if now < until {
+		tracef("first commit was %vms ago, in the burn-in period of %vs, WARMING", now-firstCommit, storage.config.BurnIn)
+		status.Status = StatusWarming
+		status.WarmingUntil = until
+		status.WarmingUntilTime = storage.displayTime(until)
+	}
+}
+
+// Groups with fewer partitions than this are evaluated one topic after another, as starting workers would take longer
+const parallelEvaluationPartitions = 1000
+
+// Apply the rules to the offsets that were copied out for each partition of a group, as of now (in milliseconds). If
+// watchingSince is set, it is when the first commit for the cluster was read, and partitions are not stopped until
+// commits have been read for longer than their window. Each topic is evaluated into a status of its own, and these are
+// merged into the group status. For large groups, the topics are evaluated by a pool of workers. If partitionChannel is
+// set, the partitions of each topic are sent on it as soon as the topic is done. If any partition is offline and the
+// group is otherwise OK or WARN, the group is PARTITION_OFFLINE. If owners is set, each partition has the member of the
+// group it is assigned to
+func (storage *OffsetStorage) evaluatePartitions(status *ConsumerGroupStatus, offsetList map[string][][]ConsumerOffset,
+	brokerList map[string][]BrokerOffset, produceRates map[string][]float64, compactedTopics map[string]bool,
+	offlinePartitions map[string]map[int32]bool, owners map[string]map[int32]*PartitionOwner, params *EvaluationParams, now int64, suppressStop bool, watchingSince int64, showall bool,
+	partitionChannel chan *PartitionStatus, tracef func(string, ...interface{})) {
+	topics := make([]string, 0, len(offsetList))
+	partitionCount := 0
+	for topic, partitions := range offsetList {
+		topics = append(topics, topic)
+		partitionCount += len(partitions)
+	}
+
+	results := make([]*ConsumerGroupStatus, len(topics))
+	evaluate := func(i int, tracef func(string, ...interface{})) {
+		results[i] = &ConsumerGroupStatus{Status: StatusOK, Complete: true, Partitions: make([]*PartitionStatus, 0)}
+		storage.evaluateTopic(results[i], topics[i], offsetList[topics[i]], brokerList, produceRates, compactedTopics,
+			offlinePartitions[topics[i]], params, now, suppressStop, watchingSince, showall, tracef)
+		attributePartitions(results[i], owners[topics[i]])
+		if partitionChannel != nil {
+			for _, partition := range results[i].Partitions {
+				partitionChannel <- partition
+			}
+		}
+	}
+	workers := storage.config.EvaluationWorkers
+	if (workers > 1) && (len(topics) > 1) && (partitionCount >= parallelEvaluationPartitions) {
+		// The trace is shared by the workers, so writes to it are locked. Lines for different topics can be interleaved
+		var traceLock sync.Mutex
+		lockedTracef := func(format string, params ...interface{}) {
+			traceLock.Lock()
+			defer traceLock.Unlock()
+			tracef(format, params...)
+		}
+		tracef("evaluating %v partitions in %v topics with %v workers", partitionCount, len(topics), workers)
+
+		next := make(chan int)
+		wg := &sync.WaitGroup{}
+		for w := 0; (w < workers) && (w < len(topics)); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					evaluate(i, lockedTracef)
+				}
+			}()
+		}
+		for i := range topics {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	} else {
+		for i := range topics {
+			evaluate(i, tracef)
+		}
+	}
+
+	// Until here, the group status can only be OK, WARN, or ERR, so the worst is the highest
+	var maxlag int64
+	offline := 0
+	for _, result := range results {
+		if !result.Complete {
+			status.Complete = false
+		}
+		if result.Status > status.Status {
+			status.Status = result.Status
+		}
+		if (result.Maxlag != nil) && (result.Maxlag.End.Lag > maxlag) {
+			status.Maxlag = result.Maxlag
+			maxlag = result.Maxlag.End.Lag
+		}
+		status.TotalLag += result.TotalLag
+		status.Partitions = append(status.Partitions, result.Partitions...)
+		for _, partition := range result.Partitions {
+			if partition.Status == StatusOffline {
+				offline++
+			}
+		}
+	}
+	storage.applyRollupPolicy(status, tracef)
+
+	// An offline partition is a broker problem, so it doesn't make the group ERR, but it doesn't leave it OK either
+	if (offline > 0) && ((status.Status == StatusOK) || (status.Status == StatusWarning)) {
+		tracef("%v partitions are offline, group is PARTITION_OFFLINE", offline)
+		status.Status = StatusOffline
+	}
+}
+
+// Apply the rules to the partitions of one topic of a group, with the same arguments as evaluatePartitions (but only the
+// offline partitions of this topic). This can run for many topics at once, so it must only change the status it is given
+func (storage *OffsetStorage) evaluateTopic(status *ConsumerGroupStatus, topic string, partitions [][]ConsumerOffset,
+	brokerList map[string][]BrokerOffset, produceRates map[string][]float64, compactedTopics map[string]bool,
+	offlinePartitions map[int32]bool, params *EvaluationParams, now int64, suppressStop bool, watchingSince int64, showall bool,
+	tracef func(string, ...interface{})) {
+	var maxlag int64
+	for partition, offsets := range partitions {
+		// Skip partitions we're missing offsets for
+		if len(offsets) == 0 {
+			continue
+		}
+		maxidx := len(offsets) - 1
+		firstOffset := offsets[0]
+		lastOffset := offsets[maxidx]
+
+		// Rule 5 - we're missing broker offsets so we're not complete yet
+		if firstOffset.Lag == -1 {
+			tracef("%s:%v: rule 5: no broker offset yet, group is incomplete", topic, partition)
+			status.Complete = false
+			continue
+		}
+
+		// We may always add this partition, so create it once
+		thispart := &PartitionStatus{
+			Topic:     topic,
+			Partition: int32(partition),
+			Status:    StatusOK,
+			Start:     firstOffset,
+			End:       lastOffset,
+		}
+		thispart.Start.Time = storage.displayTime(firstOffset.Timestamp)
+		thispart.End.Time = storage.displayTime(lastOffset.Timestamp)
+
+		// Estimate when the consumer will fall off the retention window
+		consumeRate := float64(0)
+		if lastOffset.Timestamp > firstOffset.Timestamp {
+			consumeRate = float64(lastOffset.Offset-firstOffset.Offset) * 1000 / float64(lastOffset.Timestamp-firstOffset.Timestamp)
+		}
+		thispart.TimeToRetention = timeToRetention(lastOffset.Offset, brokerList[topic][partition].OldestOffset,
+			produceRates[topic][partition], consumeRate)
+
+		// Flag a leader change in the window, so a failover isn't mistaken for a problem with the consumer
+		leaderChanged := brokerList[topic][partition].LeaderChanged
+		if (leaderChanged > 0) && (leaderChanged >= firstOffset.Timestamp) {
+			tracef("%s:%v: leader changed at %v, during the window", topic, partition, leaderChanged)
+			thispart.RecentLeaderChange = true
+		}
+
+		// Head minus committed offset overstates the lag for compacted topics. Depending on the config, we either
+		// just flag these partitions, or leave them out of the lag calculations entirely
+		thispart.Compacted = compactedTopics[topic]
+		excludeLag := thispart.Compacted && (params.CompactedTopics == "exclude")
+
+		// Check if this partition is the one with the most lag currently
+		if (!excludeLag) && (lastOffset.Lag > maxlag) {
+			status.Maxlag = thispart
+			maxlag = lastOffset.Lag
+		}
+		if !excludeLag {
+			status.TotalLag += uint64(lastOffset.Lag)
+		}
+
+		// Priority topics can have a shorter stop grace period than the window, and a lag threshold. A simulation can
+		// set these for every partition
+		priority := storage.priorityTopicFor(topic)
+		thispart.Priority = priority != nil
+
+		// A partition without a leader can't be consumed from, so the consumer looks stopped or stalled when it isn't
+		// at fault. The rules are skipped, and the partition is always listed
+		if offlinePartitions[int32(partition)] {
+			tracef("%s:%v: partition has no leader, PARTITION_OFFLINE", topic, partition)
+			thispart.Status = StatusOffline
+			status.Partitions = append(status.Partitions, thispart)
+			continue
+		}
+		stopGrace, maxLag := params.StopGrace, params.MaxLag
+		if priority != nil {
+			if stopGrace == 0 {
+				stopGrace = priority.stopGrace
+			}
+			if maxLag == 0 {
+				maxLag = priority.maxLag
+			}
+		}
+		stopWindow := lastOffset.Timestamp - firstOffset.Timestamp
+		if (stopGrace > 0) && (stopGrace*1000 < stopWindow) {
+			stopWindow = stopGrace * 1000
+		}
+
+		// Rule 4 - Offsets haven't been committed in a while
+		if (now - lastOffset.Timestamp) > stopWindow {
+			if suppressStop {
+				tracef("%s:%v: rule 4: consumer has stopped, but is outside of its scheduled window, OK", topic, partition)
+				if showall {
+					status.Partitions = append(status.Partitions, thispart)
+				}
+				continue
+			}
+			if (watchingSince > 0) && (now-watchingSince < stopWindow) {
+				// When Burrow starts, it reads old commits before it catches up to the latest ones, so a consumer
+				// that is committing can look stopped until commits have been read for a whole window
+				tracef("%s:%v: rule 4: last commit %vms ago is longer than the window of %vms, but commits have only "+
+					"been read for %vms, OK", topic, partition, now-lastOffset.Timestamp, stopWindow, now-watchingSince)
+				if showall {
+					status.Partitions = append(status.Partitions, thispart)
+				}
+				continue
+			}
+			tracef("%s:%v: rule 4: last commit %vms ago is longer than the window of %vms, STOP", topic, partition,
+				now-lastOffset.Timestamp, stopWindow)
+			status.Status = StatusError
+			thispart.Status = StatusStop
+			status.Partitions = append(status.Partitions, thispart)
+			continue
+		}
+
+		// Rule 6 - Did the consumer offsets rewind at any point?
+		// We check this before the retention and lag rules because we always want to know about a rewind - it's bad
+		// behavior. A partition that rewinds while it is near the retention window is a REWIND, not a RETENTION
+		rewound := false
+		for i := 1; i <= maxidx; i++ {
+			if offsets[i].Offset < offsets[i-1].Offset {
+				tracef("%s:%v: rule 6: offset went from %v to %v, REWIND", topic, partition, offsets[i-1].Offset, offsets[i].Offset)
+				rewound = true
+				break
+			}
+		}
+		if rewound {
+			status.Status = StatusError
+			thispart.Status = StatusRewind
+			status.Partitions = append(status.Partitions, thispart)
+			continue
+		}
+
+		// Rule 7 - Is the consumer about to fall off the retention window for the partition?
+		if (params.RetentionRisk > 0) && (!excludeLag) && (lastOffset.Lag > 0) && (thispart.TimeToRetention >= 0) &&
+			(thispart.TimeToRetention < params.RetentionRisk) {
+			tracef("%s:%v: rule 7: %vs until the consumer falls off the retention window, RETENTION", topic, partition, thispart.TimeToRetention)
+			status.Status = StatusError
+			thispart.Status = StatusRetention
+			status.Partitions = append(status.Partitions, thispart)
+			continue
+		}
+
+		// Rule 8 - Priority topics can have a lag threshold
+		if (maxLag > 0) && (!excludeLag) && (lastOffset.Lag > maxLag) {
+			tracef("%s:%v: rule 8: lag %v is over the threshold of %v, WARN", topic, partition, lastOffset.Lag, maxLag)
+			if thispart.Priority {
+				status.Status = StatusError
+			} else if status.Status == StatusOK {
+				status.Status = StatusWarning
+			}
+			thispart.Status = StatusWarning
+			status.Partitions = append(status.Partitions, thispart)
+			continue
+		}
+
+		// The remaining rules are all based on lag
+		if excludeLag {
+			tracef("%s:%v: compacted topic is excluded from lag rules", topic, partition)
+			if (thispart.Status == StatusOK) && showall {
+				status.Partitions = append(status.Partitions, thispart)
+			}
+			continue
+		}
+
+		// Rule 1
+		if lastOffset.Lag == 0 {
+			tracef("%s:%v: rule 1: current lag is zero, OK", topic, partition)
+			if showall {
+				status.Partitions = append(status.Partitions, thispart)
+			}
+			continue
+		}
+		if lastOffset.Offset == firstOffset.Offset {
+			// Rule 1
+			if firstOffset.Lag == 0 {
+				tracef("%s:%v: rule 1: lag was zero at the start of the window, OK", topic, partition)
+				if showall {
+					status.Partitions = append(status.Partitions, thispart)
+				}
+				continue
+			}
+
+			// Rule 2
+			tracef("%s:%v: rule 2: offset %v has not moved and lag is %v, STALL", topic, partition, lastOffset.Offset, lastOffset.Lag)
+			status.Status = StatusError
+			thispart.Status = StatusStall
+		} else {
+			// Rule 1 passes, or shortcut a full check on Rule 3 if we can
+			if (firstOffset.Lag == 0) || (lastOffset.Lag <= firstOffset.Lag) {
+				tracef("%s:%v: rule 3: lag did not increase over the window (%v -> %v), OK", topic, partition, firstOffset.Lag, lastOffset.Lag)
+				if showall {
+					status.Partitions = append(status.Partitions, thispart)
+				}
+				continue
+			}
+
+			lagDropped := false
+			for i := 0; i <= maxidx; i++ {
+				// Rule 1 passes or Rule 3 is shortcut (lag dropped somewhere in the period)
+				if (offsets[i].Lag == 0) || ((i > 0) && (offsets[i].Lag < offsets[i-1].Lag)) {
+					tracef("%s:%v: rule 3: lag dropped at interval %v, OK", topic, partition, i)
+					lagDropped = true
+					break
+				}
+			}
+
+			if !lagDropped {
+				// Rule 3
+				tracef("%s:%v: rule 3: lag increased at every interval (%v -> %v), WARN", topic, partition, firstOffset.Lag, lastOffset.Lag)
+				if thispart.Priority {
+					// A priority partition can't hide behind healthy ones
+					status.Status = StatusError
+				} else if status.Status == StatusOK {
+					status.Status = StatusWarning
+				}
+				thispart.Status = StatusWarning
+			}
+		}
+
+		// Always add the partition if it's not OK
+		if (thispart.Status != StatusOK) || showall {
+			status.Partitions = append(status.Partitions, thispart)
+		}
+	}
+}
+
+func (storage *OffsetStorage) requestClusterList(request *RequestClusterList) {
+	request.Result <- storage.clusterSnapshot()
+}
+
+// The counts for every cluster are taken with all of the clusters locked at once, so that they are consistent with
+// each other. The locks are taken in the same order as a group evaluation takes them, and released before the result
+// is sent
+func (storage *OffsetStorage) clusterSnapshot() *ResponseClusterList {
+	response := &ResponseClusterList{
+		Clusters: make([]string, 0, len(storage.offsets)),
+		Counts:   make(map[string]*ClusterCounts, len(storage.offsets)),
+	}
+	for cluster := range storage.offsets {
+		response.Clusters = append(response.Clusters, cluster)
+	}
+	sort.Strings(response.Clusters)
+
+	for _, cluster := range response.Clusters {
+		storage.offsets[cluster].consumerLock.RLock()
+		defer storage.offsets[cluster].consumerLock.RUnlock()
+		storage.offsets[cluster].brokerLock.RLock()
+		defer storage.offsets[cluster].brokerLock.RUnlock()
+	}
+	response.SnapshotAt = time.Now().Unix() * 1000
+
+	for _, cluster := range response.Clusters {
+		clusterMap := storage.offsets[cluster]
+		counts := &ClusterCounts{Groups: len(clusterMap.consumer), Topics: len(clusterMap.broker)}
+		for _, topic := range clusterMap.broker {
+			counts.Partitions += len(topic.partitions)
+			for _, partition := range topic.partitions {
+				if (partition != nil) && (partition.Timestamp > counts.LastBrokerOffset) {
+					counts.LastBrokerOffset = partition.Timestamp
+				}
+			}
+		}
+		for _, topics := range clusterMap.consumer {
+			for _, partitions := range topics {
+				for _, offsetRing := range partitions {
+					if offsetRing == nil {
+						continue
+					}
+					if offset, ok := offsetRing.Prev().Value.(*ConsumerOffset); ok && (offset.Timestamp > counts.LastCommit) {
+						counts.LastCommit = offset.Timestamp
+					}
+				}
+			}
+		}
+		response.Counts[cluster] = counts
+	}
+	return response
+}
+
+func (storage *OffsetStorage) requestConsumerList(request *RequestConsumerList) {
+	if _, ok := storage.offsets[request.Cluster]; !ok {
+		request.Result <- make([]string, 0)
+		return
+	}
+
+	storage.offsets[request.Cluster].consumerLock.RLock()
+	consumerList := make([]string, len(storage.offsets[request.Cluster].consumer))
+	i := 0
+	for group := range storage.offsets[request.Cluster].consumer {
+		consumerList[i] = group
+		i += 1
+	}
+	storage.offsets[request.Cluster].consumerLock.RUnlock()
+
+	request.Result <- consumerList
+}
+
+func (storage *OffsetStorage) requestTopicList(request *RequestTopicList) {
+	if _, ok := storage.offsets[request.Cluster]; !ok {
+		request.Result <- &ResponseTopicList{Error: true}
+		return
+	}
+
+	response := &ResponseTopicList{Error: false}
+	if request.Group == "" {
+		storage.offsets[request.Cluster].brokerLock.RLock()
+		response.TopicList = make([]string, len(storage.offsets[request.Cluster].broker))
+		i := 0
+		for topic := range storage.offsets[request.Cluster].broker {
+			response.TopicList[i] = topic
+			i += 1
+		}
+		storage.offsets[request.Cluster].brokerLock.RUnlock()
+	} else {
+		storage.offsets[request.Cluster].consumerLock.RLock()
+		if _, ok := storage.offsets[request.Cluster].consumer[request.Group]; ok {
+			response.TopicList = make([]string, len(storage.offsets[request.Cluster].consumer[request.Group]))
+			i := 0
+			for topic := range storage.offsets[request.Cluster].consumer[request.Group] {
+				response.TopicList[i] = topic
+				i += 1
+			}
+		} else {
+			response.Error = true
+		}
+		storage.offsets[request.Cluster].consumerLock.RUnlock()
+	}
+	request.Result <- response
+}
+
+// Return the head offsets for every topic in the cluster. Partitions we don't have an offset for yet are -1
+func (storage *OffsetStorage) requestBrokerOffsets(request *RequestBrokerOffsets) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- nil
+		return
+	}
+
+	clusterMap.brokerLock.RLock()
+	topics := make(map[string][]int64, len(clusterMap.broker))
+	for topic, partitions := range clusterMap.broker {
+		topics[topic] = make([]int64, len(partitions.partitions))
+		for partition, offset := range partitions.partitions {
+			if offset == nil {
+				topics[topic][partition] = -1
+			} else {
+				topics[topic][partition] = offset.Offset
+			}
+		}
+	}
+	clusterMap.brokerLock.RUnlock()
+
+	request.Result <- topics
+}
+
+func (storage *OffsetStorage) requestConsumerOffsets(request *RequestConsumerOffsets) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- nil
+		return
+	}
+
+	clusterMap.consumerLock.RLock()
+	defer clusterMap.consumerLock.RUnlock()
+	topics, ok := clusterMap.consumer[request.Group]
+	if !ok {
+		request.Result <- nil
+		return
+	}
+
+	// Copy the offsets, since the rings they are in keep changing after the lock is released
+	offsets := make(map[string][]*ConsumerOffset, len(topics))
+	for topic, partitions := range topics {
+		offsets[topic] = make([]*ConsumerOffset, len(partitions))
+		for partition, offsetRing := range partitions {
+			if offsetRing == nil {
+				continue
+			}
+			if offset, ok := offsetRing.Prev().Value.(*ConsumerOffset); ok {
+				offsetCopy := *offset
+				offsets[topic][partition] = &offsetCopy
+			}
+		}
+	}
+	request.Result <- offsets
+}
+
+func (storage *OffsetStorage) requestOffsets(request *RequestOffsets) {
+	if _, ok := storage.offsets[request.Cluster]; !ok {
+		request.Result <- &ResponseOffsets{ErrorTopic: true, ErrorGroup: true}
+		return
+	}
+
+	response := &ResponseOffsets{ErrorGroup: false, ErrorTopic: false}
+	if request.Group == "" {
+		storage.offsets[request.Cluster].brokerLock.RLock()
+		if topic, ok := storage.offsets[request.Cluster].broker[request.Topic]; ok {
+			response.OffsetList = make([]int64, len(topic.partitions))
+			response.StableOffsetList = make([]int64, len(topic.partitions))
+			for partition, offset := range topic.partitions {
+				if offset == nil {
+					response.OffsetList[partition] = -1
+					response.StableOffsetList[partition] = -1
+				} else {
+					response.OffsetList[partition] = offset.Offset
+					response.StableOffsetList[partition] = offset.StableOffset
+				}
+			}
+		} else {
+			response.ErrorTopic = true
+		}
+		storage.offsets[request.Cluster].brokerLock.RUnlock()
+	} else {
+		storage.offsets[request.Cluster].consumerLock.RLock()
+		if _, ok := storage.offsets[request.Cluster].consumer[request.Group]; ok {
+			if _, ok := storage.offsets[request.Cluster].consumer[request.Group][request.Topic]; ok {
+				response.OffsetList = make([]int64, len(storage.offsets[request.Cluster].consumer[request.Group][request.Topic]))
+				for partition, oring := range storage.offsets[request.Cluster].consumer[request.Group][request.Topic] {
+					if oring == nil {
+						response.OffsetList[partition] = -1
+					} else {
+						offset, _ := oring.Prev().Value.(*ConsumerOffset)
+						if offset == nil {
+							response.OffsetList[partition] = -1
+						} else {
+							response.OffsetList[partition] = offset.Offset
+						}
+					}
+				}
+			} else {
+				response.ErrorTopic = true
+			}
+		} else {
+			response.ErrorGroup = true
+		}
+		storage.offsets[request.Cluster].consumerLock.RUnlock()
+	}
+	request.Result <- response
+}
+
+func (storage *OffsetStorage) requestDroppedOffsets(request *RequestDroppedOffsets) {
+	clusterOffsets, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- make([]*DroppedOffset, 0)
+		return
+	}
+
+	// Walk the ring from the oldest entry to the newest
+	droppedList := make([]*DroppedOffset, 0)
+	clusterOffsets.droppedLock.Lock()
+	clusterOffsets.dropped.Do(func(val interface{}) {
+		if val != nil {
+			ptr, _ := val.(*DroppedOffset)
+			droppedCopy := *ptr
+			droppedList = append(droppedList, &droppedCopy)
+		}
+	})
+	clusterOffsets.droppedLock.Unlock()
+
+	request.Result <- droppedList
+}
+
+func (storage *OffsetStorage) requestTopicRate(request *RequestTopicRate) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- &ResponseTopicRate{ErrorTopic: true}
+		return
+	}
+
+	response := &ResponseTopicRate{ErrorTopic: false}
+	clusterMap.brokerLock.RLock()
+	if topic, ok := clusterMap.broker[request.Topic]; ok {
+		historyList := topic.history
+		response.Rates = make([]float64, len(historyList))
+		for partition, history := range historyList {
+			rate, window := brokerOffsetRate(history)
+			response.Rates[partition] = rate
+			response.TotalRate += rate
+			if window > response.Window {
+				response.Window = window
+			}
+		}
+	} else {
+		response.ErrorTopic = true
+	}
+	clusterMap.brokerLock.RUnlock()
+
+	request.Result <- response
+}
+
+func (storage *OffsetStorage) debugPrintGroup(cluster string, group string) {
+	// Make sure the cluster exists
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		log.Debugf("Detail cluster=%s,group=%s: No Cluster", cluster, group)
+		return
+	}
+
+	// Make sure the group even exists
+	clusterMap.consumerLock.RLock()
+	consumerMap, ok := clusterMap.consumer[group]
+	if !ok {
+		clusterMap.consumerLock.RUnlock()
+		log.Debugf("Detail cluster=%s,group=%s: No Group", cluster, group)
+		return
+	}
+
+	// Scan the offsets table and print all partitions (full ring) for the group
+	for topic, partitions := range consumerMap {
+		for partition, offsetRing := range partitions {
+			if (offsetRing == nil) || (offsetRing.Value == nil) {
+				log.Debugf("Detail cluster=%s,group=%s,topic=%s,partition=%v: No Ring", cluster, group, topic, partition)
+				continue
+			}
+
+			// Pull out the offsets once so we can unlock the map
+			ringStr := ""
+			offsetRing.Do(func(val interface{}) {
+				if val == nil {
+					ringStr += "(),"
+				} else {
+					ptr, _ := val.(*ConsumerOffset)
+					ringStr += fmt.Sprintf("(%v,%v,%v,%v,%s)", ptr.Timestamp, ptr.Offset, ptr.Lag, ptr.artificial, ptr.Source)
+				}
+			})
+			log.Debugf("Detail cluster=%s,group=%s,topic=%s,partition=%v: %s", cluster, group, topic, partition, ringStr)
+		}
+	}
+	clusterMap.consumerLock.RUnlock()
+}
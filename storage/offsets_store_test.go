@@ -0,0 +1,1211 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) *OffsetStorage {
+	storage, err := NewOffsetStorage(&Config{
+		Clusters:         map[string]*ClusterConfig{"test": {}},
+		Intervals:        3,
+		BrokerIntervals:  3,
+		ExpireGroup:      3600,
+		DroppedOffsets:   100,
+		ArchiveRetention: 3600,
+		ArchiveInterval:  60,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	return storage
+}
+
+func brokerOffset(topic string, partition int32, partitions int, offset int64, ts int64) *PartitionOffset {
+	return &PartitionOffset{
+		Cluster:             "test",
+		Topic:               topic,
+		Partition:           partition,
+		Offset:              offset,
+		OldestOffset:        0,
+		StableOffset:        -1,
+		Timestamp:           ts,
+		TopicPartitionCount: partitions,
+	}
+}
+
+func consumerOffset(group string, topic string, partition int32, offset int64, ts int64) *PartitionOffset {
+	return &PartitionOffset{
+		Cluster:   "test",
+		Topic:     topic,
+		Partition: partition,
+		Group:     group,
+		Offset:    offset,
+		Timestamp: ts,
+	}
+}
+
+// Offsets for partitions added to a topic must be kept, not written to a slice that is thrown away
+func Test_brokerOffsetPartitionExpansion(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 2, 100, now))
+	storage.addBrokerOffset(brokerOffset("topic", 1, 2, 200, now))
+	before := storage.offsets["test"].broker["topic"]
+
+	storage.addBrokerOffset(brokerOffset("topic", 5, 8, 500, now))
+	storage.addBrokerOffset(brokerOffset("topic", 0, 8, 110, now))
+
+	after := storage.offsets["test"].broker["topic"]
+	if len(after.partitions) != 8 {
+		t.Fatalf("Expected 8 partitions after expansion, got %v", len(after.partitions))
+	}
+	if after.version != before.version+1 {
+		t.Errorf("Expected version %v after expansion, got %v", before.version+1, after.version)
+	}
+	if (after.partition(5) == nil) || (after.partition(5).Offset != 500) {
+		t.Errorf("Offset for the new partition 5 was not kept")
+	}
+	if (after.partition(0) == nil) || (after.partition(0).Offset != 110) || (after.partition(1).Offset != 200) {
+		t.Errorf("Offsets for the existing partitions were not carried over")
+	}
+	if len(before.partitions) != 2 {
+		t.Errorf("The topic that was swapped out was resized to %v partitions", len(before.partitions))
+	}
+
+	// A partition count that goes down (from a stale metadata refresh) must not shrink the topic or cause a panic
+	storage.addBrokerOffset(brokerOffset("topic", 1, 4, 210, now))
+	if len(storage.offsets["test"].broker["topic"].partitions) != 8 {
+		t.Errorf("Topic was shrunk by a lower partition count")
+	}
+
+	// A partition outside of the partition count it came with is dropped
+	storage.addBrokerOffset(brokerOffset("topic", 9, 8, 900, now))
+	if len(storage.offsets["test"].broker["topic"].partitions) != 8 {
+		t.Errorf("Topic was grown by an offset outside of its partition count")
+	}
+}
+
+func Test_reportDroppedOffsets(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	reports := make(map[string]map[string]int64)
+	storage.config.DropHook = func(cluster string, counts map[string]int64) {
+		reports[cluster] = counts
+	}
+
+	now := time.Now().Unix() * 1000
+	for i := int64(0); i < 3; i++ {
+		storage.addConsumerOffset(consumerOffset("group", "unknown", 0, 100+i, now+i))
+	}
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now))
+
+	storage.reportDroppedOffsets()
+	if (reports["test"]["no topic"] != 3) || (reports["test"]["noadvance"] != 1) || (len(reports["test"]) != 2) {
+		t.Errorf("Unexpected dropped offset counts %v", reports["test"])
+	}
+
+	// The counts start again after each report, and clusters with no drops aren't reported
+	delete(reports, "test")
+	storage.reportDroppedOffsets()
+	if _, ok := reports["test"]; ok {
+		t.Errorf("Cluster with no new drops was reported")
+	}
+	if storage.offsets["test"].dropped.Prev().Value.(*DroppedOffset).Reason != "noadvance" {
+		t.Errorf("Dropped offset was not kept in the dropped offsets ring")
+	}
+}
+
+func Test_consumerOffsetPartitionExpansion(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now))
+
+	// The group can't commit to the new partitions until the broker offsets show them
+	storage.addConsumerOffset(consumerOffset("group", "topic", 3, 10, now))
+	if len(storage.offsets["test"].consumer["group"]["topic"]) != 1 {
+		t.Fatalf("Group partitions were grown before the broker partitions")
+	}
+
+	for partition := int32(0); partition < 4; partition++ {
+		storage.addBrokerOffset(brokerOffset("topic", partition, 4, 1000, now))
+	}
+	storage.addConsumerOffset(consumerOffset("group", "topic", 3, 10, now))
+
+	offsets := storage.ConsumerOffsets("test", "group")
+	if len(offsets["topic"]) != 4 {
+		t.Fatalf("Expected 4 group partitions after expansion, got %v", len(offsets["topic"]))
+	}
+	if (offsets["topic"][0] == nil) || (offsets["topic"][0].Offset != 900) {
+		t.Errorf("Offset for the existing partition 0 was not carried over")
+	}
+	if (offsets["topic"][3] == nil) || (offsets["topic"][3].Offset != 10) {
+		t.Errorf("Offset for the new partition 3 was not kept")
+	}
+}
+
+// Expand a topic while consumer offsets are being committed to it and the storage is being read. This is most useful
+// with the race detector (go test -race)
+func Test_partitionExpansionUnderLoad(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	const maxPartitions = 64
+	start := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000000, start))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Broker offsets, with the partition count going up by one each round
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for count := 1; count <= maxPartitions; count++ {
+			for partition := 0; partition < count; partition++ {
+				storage.addBrokerOffset(brokerOffset("topic", int32(partition), count, 1000000, start))
+			}
+		}
+	}()
+
+	// Consumer commits for every partition the topic might have
+	for group := 0; group < 4; group++ {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+			for i := int64(1); i <= 50; i++ {
+				for partition := int32(0); partition < maxPartitions; partition++ {
+					storage.addConsumerOffset(consumerOffset(group, "topic", partition, 1000*i, start+1000*i))
+				}
+			}
+		}(string(rune('a' + group)))
+	}
+
+	// Readers
+	readers := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				storage.GroupStatus("test", "a", true)
+				storage.ConsumerOffsets("test", "b")
+				storage.Diagnostics()
+
+				request := &RequestOffsets{Result: make(chan *ResponseOffsets), Cluster: "test", Topic: "topic"}
+				storage.RequestChannel <- request
+				<-request.Result
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	topic := storage.offsets["test"].broker["topic"]
+	if len(topic.partitions) != maxPartitions {
+		t.Fatalf("Expected %v partitions, got %v", maxPartitions, len(topic.partitions))
+	}
+	if topic.version != maxPartitions-1 {
+		t.Errorf("Expected version %v, got %v", maxPartitions-1, topic.version)
+	}
+	for partition, offset := range topic.partitions {
+		if offset == nil {
+			t.Errorf("Broker offset for partition %v was lost", partition)
+		}
+	}
+
+	// Now that the topic is at its full size, every group's next commit to every partition must be kept
+	for _, group := range []string{"a", "b", "c", "d"} {
+		for partition := int32(0); partition < maxPartitions; partition++ {
+			storage.addConsumerOffset(consumerOffset(group, "topic", partition, 51000, start+51000))
+		}
+
+		offsets := storage.ConsumerOffsets("test", group)
+		if len(offsets["topic"]) != maxPartitions {
+			t.Errorf("Expected %v partitions for group %s, got %v", maxPartitions, group, len(offsets["topic"]))
+			continue
+		}
+		for partition, offset := range offsets["topic"] {
+			if (offset == nil) || (offset.Offset != 51000) {
+				t.Errorf("Commit to partition %v was lost for group %s", partition, group)
+			}
+		}
+	}
+}
+
+// Stopping one cluster's pipeline drops its offsets, but leaves the other clusters running and its stored offsets
+// readable
+func Test_stopClusterPipeline(t *testing.T) {
+	storage, err := NewOffsetStorage(&Config{
+		Clusters:         map[string]*ClusterConfig{"test": {}, "other": {}},
+		Intervals:        3,
+		BrokerIntervals:  3,
+		ExpireGroup:      3600,
+		DroppedOffsets:   100,
+		ArchiveRetention: 3600,
+		ArchiveInterval:  60,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 100, now))
+	storage.StopCluster("test")
+
+	for i := 0; i < 2*clusterOffsetChannelSize; i++ {
+		storage.AddOffset(brokerOffset("topic", 0, 1, 200, now))
+	}
+	other := brokerOffset("topic", 0, 1, 300, now)
+	other.Cluster = "other"
+	storage.AddOffset(other)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		request := &RequestOffsets{Result: make(chan *ResponseOffsets), Cluster: "other", Topic: "topic"}
+		if !storage.SendRequest(request, time.Second) {
+			t.Fatalf("Request for the running cluster was not taken")
+		}
+		if response := <-request.Result; !response.ErrorTopic {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Offset for the running cluster was never stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	request := &RequestOffsets{Result: make(chan *ResponseOffsets), Cluster: "test", Topic: "topic"}
+	if !storage.SendRequest(request, time.Second) {
+		t.Fatalf("Request for the stopped cluster was not taken")
+	}
+	if response := <-request.Result; (response.ErrorTopic) || (response.OffsetList[0] != 100) {
+		t.Errorf("Expected the stored offset 100 for the stopped cluster, got %+v", response)
+	}
+}
+
+// Offsets are dropped while a cluster is paused, and stored offsets are moved forward past the pause when it resumes
+func Test_pauseAndResumeCluster(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now))
+
+	if !storage.PauseCluster("test") {
+		t.Fatalf("Cluster was not paused")
+	}
+	if storage.PauseCluster("test") {
+		t.Errorf("Cluster was paused twice")
+	}
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 950, now+1000))
+	if offsets := storage.ConsumerOffsets("test", "group"); offsets["topic"][0].Offset != 900 {
+		t.Errorf("Offset was stored while the cluster was paused")
+	}
+	if status := storage.GroupStatus("test", "group", true); status.PausedAt == 0 {
+		t.Errorf("Group status does not show the cluster is paused")
+	}
+
+	// Pretend the pause started a minute ago
+	storage.offsets["test"].paused -= 60000
+	if !storage.ResumeCluster("test") {
+		t.Fatalf("Cluster was not resumed")
+	}
+	offsets := storage.ConsumerOffsets("test", "group")
+	if shifted := offsets["topic"][0].Timestamp - now; shifted < 60000 {
+		t.Errorf("Expected the stored offset to be moved forward at least 60000ms, it was moved %vms", shifted)
+	}
+	if storage.ClusterPausedAt("test") != 0 {
+		t.Errorf("Cluster is still paused after resuming")
+	}
+}
+
+// Tags come from every matching rule, with earlier rules winning, and end up in the group status
+func Test_groupTags(t *testing.T) {
+	storage, err := NewOffsetStorage(&Config{
+		Clusters:         map[string]*ClusterConfig{"test": {}},
+		Intervals:        3,
+		BrokerIntervals:  3,
+		ExpireGroup:      3600,
+		DroppedOffsets:   100,
+		ArchiveRetention: 3600,
+		ArchiveInterval:  60,
+		GroupTagRules: []string{
+			`^(?P<team>[a-z]+)[.](?P<service>[a-z-]+)(?:[.](?P<env>prod|staging))?$`,
+			`^(?P<team>[a-z]+)[.](?P<owner>[a-z]+)`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	defer storage.Stop()
+
+	tags := storage.GroupTags("payments.billing")
+	if (len(tags) != 3) || (tags["team"] != "payments") || (tags["service"] != "billing") || (tags["owner"] != "billing") {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+	if tags := storage.GroupTags("console-consumer-1234"); tags != nil {
+		t.Errorf("Expected no tags for a group that matches no rule, got %v", tags)
+	}
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addConsumerOffset(consumerOffset("payments.billing.prod", "topic", 0, 900, now))
+	if status := storage.GroupStatus("test", "payments.billing.prod", true); status.Tags["env"] != "prod" {
+		t.Errorf("Group status does not have the tags, got %v", status.Tags)
+	}
+
+	if _, err := CompileGroupTagRule(`^(?P<group>.*)$`); err == nil {
+		t.Errorf("Rule with a reserved tag name was accepted")
+	}
+	if _, err := CompileGroupTagRule(`^([a-z]+)$`); err == nil {
+		t.Errorf("Rule with no named captures was accepted")
+	}
+}
+
+// Metadata set on a group is returned in its status and kept in checkpoints, and setting none removes it
+func Test_groupMetadata(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now))
+
+	result := make(chan StatusConstant, 1)
+	storage.setGroupMetadata(&RequestGroupMetadataSet{Result: result, Cluster: "test", Group: "group",
+		Metadata: map[string]string{"owner": "payments", "runbook": "https://wiki/payments"}})
+	if status := <-result; status != StatusOK {
+		t.Fatalf("Expected the metadata to be set, got %v", status)
+	}
+	if status := storage.GroupStatus("test", "group", true); status.Metadata["owner"] != "payments" {
+		t.Errorf("Group status does not have the metadata, got %v", status.Metadata)
+	}
+	storage.setGroupMetadata(&RequestGroupMetadataSet{Result: result, Cluster: "unknown", Group: "group"})
+	if status := <-result; status != StatusNotFound {
+		t.Errorf("Expected an unknown cluster to be not found, got %v", status)
+	}
+
+	restored := newTestStorage(t)
+	defer restored.Stop()
+	restored.restoreCheckpoint(storage.TakeCheckpoint())
+	if metadata := restored.offsets["test"].groupMetadata("group"); (len(metadata) != 2) || (metadata["runbook"] != "https://wiki/payments") {
+		t.Errorf("Metadata was not restored from the checkpoint, got %v", metadata)
+	}
+
+	storage.setGroupMetadata(&RequestGroupMetadataSet{Result: result, Cluster: "test", Group: "group", Metadata: map[string]string{}})
+	<-result
+	if metadata := storage.offsets["test"].groupMetadata("group"); metadata != nil {
+		t.Errorf("Expected the metadata to be removed, got %v", metadata)
+	}
+
+	if err := ValidateGroupMetadata(map[string]string{"": "value"}); err == nil {
+		t.Errorf("Metadata with an empty key was accepted")
+	}
+	if err := ValidateGroupMetadata(map[string]string{"owner": strings.Repeat("x", MaxGroupMetadataValueLength+1)}); err == nil {
+		t.Errorf("Metadata with a value that is too long was accepted")
+	}
+}
+
+// A removed group keeps its offsets in a tombstone, and restoring it brings them back over anything committed since
+func Test_dropAndRestoreGroup(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	storage.config.TombstoneRetention = 3600
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 2, 1000, now))
+	storage.addBrokerOffset(brokerOffset("topic", 1, 2, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now))
+
+	result := make(chan StatusConstant, 1)
+	storage.dropGroup("test", "group", result)
+	if status := <-result; status != StatusOK {
+		t.Fatalf("Expected the group to be removed, got %v", status)
+	}
+	if offsets := storage.ConsumerOffsets("test", "group"); len(offsets) != 0 {
+		t.Fatalf("Group still has offsets after being removed")
+	}
+
+	storage.addConsumerOffset(consumerOffset("group", "topic", 1, 500, now))
+	storage.restoreGroup("test", "group", result)
+	if status := <-result; status != StatusOK {
+		t.Fatalf("Expected the group to be restored, got %v", status)
+	}
+	offsets := storage.ConsumerOffsets("test", "group")
+	if (offsets["topic"][0] == nil) || (offsets["topic"][0].Offset != 900) {
+		t.Errorf("Offset for partition 0 was not restored")
+	}
+	if (offsets["topic"][1] == nil) || (offsets["topic"][1].Offset != 500) {
+		t.Errorf("Offset committed after the removal was not kept")
+	}
+
+	storage.restoreGroup("test", "group", result)
+	if status := <-result; status != StatusNotFound {
+		t.Errorf("Group was restored twice")
+	}
+}
+
+// Marking a partition caught up adds an artificial commit at the broker offset, which the next real commit follows
+func Test_markCaughtUp(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	commit := consumerOffset("group", "topic", 0, 900, now-120000)
+	commit.Source = OffsetSourceKafka
+	storage.addConsumerOffset(commit)
+	if offsets := storage.ConsumerOffsets("test", "group"); offsets["topic"][0].Source != OffsetSourceKafka {
+		t.Errorf("Expected the commit to keep its source, got %+v", offsets["topic"][0])
+	}
+
+	result := make(chan StatusConstant, 1)
+	storage.markCaughtUp(&RequestMarkCaughtUp{Result: result, Cluster: "test", Group: "group", Topic: "topic", Partition: 0})
+	if status := <-result; status != StatusOK {
+		t.Fatalf("Expected the partition to be marked, got %v", status)
+	}
+	offsets := storage.ConsumerOffsets("test", "group")
+	if (offsets["topic"][0] == nil) || (offsets["topic"][0].Offset != 1000) || (offsets["topic"][0].Lag != 0) ||
+		(offsets["topic"][0].Source != OffsetSourceCaughtUp) {
+		t.Errorf("Expected an artificial commit at offset 1000 with no lag, got %+v", offsets["topic"][0])
+	}
+
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 950, now+1000))
+	if offsets := storage.ConsumerOffsets("test", "group"); offsets["topic"][0].Offset != 950 {
+		t.Errorf("Commit after the artificial one was not stored")
+	}
+
+	storage.markCaughtUp(&RequestMarkCaughtUp{Result: result, Cluster: "test", Group: "group", Topic: "topic", Partition: 1})
+	if status := <-result; status != StatusNotFound {
+		t.Errorf("Expected an unknown partition to be not found, got %v", status)
+	}
+	storage.markCaughtUp(&RequestMarkCaughtUp{Result: result, Cluster: "test", Group: "other", Topic: "topic", Partition: 0})
+	if status := <-result; status != StatusNotFound {
+		t.Errorf("Expected an unknown group to be not found, got %v", status)
+	}
+}
+
+// The percent policy only makes the group an error when enough partitions are bad, unless a priority one is
+func Test_rollupPolicy(t *testing.T) {
+	storage, err := NewOffsetStorage(&Config{
+		Clusters:         map[string]*ClusterConfig{"test": {}},
+		Intervals:        3,
+		BrokerIntervals:  3,
+		ExpireGroup:      3600,
+		DroppedOffsets:   100,
+		ArchiveRetention: 3600,
+		ArchiveInterval:  60,
+		RollupPolicies: []*RollupPolicyConfig{
+			{Groups: "^batch-", Policy: RollupPercent, ErrorPercent: 25},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	defer storage.Stop()
+	tracef := func(string, ...interface{}) {}
+
+	tests := []struct {
+		group    string
+		bad      []*PartitionStatus
+		expected StatusConstant
+	}{
+		{"batch-1", []*PartitionStatus{{Topic: "topic", Partition: 0, Status: StatusStop}}, StatusWarning},
+		{"batch-1", []*PartitionStatus{
+			{Topic: "topic", Partition: 0, Status: StatusStop},
+			{Topic: "topic", Partition: 1, Status: StatusStall},
+		}, StatusError},
+		{"batch-1", []*PartitionStatus{{Topic: "topic", Partition: 0, Status: StatusStop, Priority: true}}, StatusError},
+		{"stream-1", []*PartitionStatus{{Topic: "topic", Partition: 0, Status: StatusStop}}, StatusError},
+	}
+	for i, test := range tests {
+		status := &ConsumerGroupStatus{Group: test.group, Status: StatusError, TotalPartitions: 5, Partitions: test.bad}
+		storage.applyRollupPolicy(status, tracef)
+		if status.Status != test.expected {
+			t.Errorf("Test %v: expected group status %v, got %v", i, test.expected, status.Status)
+		}
+	}
+}
+
+// A stalled partition that has no leader is PARTITION_OFFLINE, and so is the group unless another partition is bad
+func Test_offlinePartition(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	tracef := func(string, ...interface{}) {}
+
+	now := time.Now().Unix() * 1000
+	stalled := []ConsumerOffset{{Offset: 100, Timestamp: now - 20000, Lag: 50}, {Offset: 100, Timestamp: now - 10000, Lag: 60},
+		{Offset: 100, Timestamp: now, Lag: 70}}
+	caughtUp := []ConsumerOffset{{Offset: 100, Timestamp: now - 20000, Lag: 0}, {Offset: 200, Timestamp: now - 10000, Lag: 0},
+		{Offset: 300, Timestamp: now, Lag: 0}}
+	brokerList := map[string][]BrokerOffset{"topic": make([]BrokerOffset, 2)}
+	produceRates := map[string][]float64{"topic": make([]float64, 2)}
+
+	tests := []struct {
+		offline  map[string]map[int32]bool
+		second   []ConsumerOffset
+		expected StatusConstant
+	}{
+		{nil, caughtUp, StatusError},
+		{map[string]map[int32]bool{"topic": {0: true}}, caughtUp, StatusOffline},
+		{map[string]map[int32]bool{"topic": {0: true}}, stalled, StatusError},
+	}
+	for i, test := range tests {
+		status := &ConsumerGroupStatus{Group: "group", Status: StatusOK, Complete: true, TotalPartitions: 2}
+		offsetList := map[string][][]ConsumerOffset{"topic": {stalled, test.second}}
+		storage.evaluatePartitions(status, offsetList, brokerList, produceRates, map[string]bool{}, test.offline, nil,
+			&EvaluationParams{Intervals: 3}, now, false, 0, false, nil, tracef)
+		if status.Status != test.expected {
+			t.Errorf("Test %v: expected group status %v, got %v", i, test.expected, status.Status)
+		}
+	}
+}
+
+// The skew is the smallest delay of the commits received in a window, and a compensated clock is moved back by it
+func Test_clockSkew(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	var reported int64
+	storage.config.SkewHook = func(cluster string, skew int64) {
+		reported = skew
+	}
+	clusterMap := storage.offsets["test"]
+
+	now := time.Now().Unix() * 1000
+	storage.recordClockSkew("test", clusterMap, now-120000, now)
+	storage.recordClockSkew("test", clusterMap, now-30000+1000, now+1000)
+	if _, known := storage.ClockSkew("test"); known {
+		t.Fatalf("Expected no estimate before the window is over")
+	}
+	storage.recordClockSkew("test", clusterMap, now-45000+clockSkewWindow, now+clockSkewWindow)
+	if skew, known := storage.ClockSkew("test"); (!known) || (skew != 30000) || (reported != 30000) {
+		t.Errorf("Expected a skew of 30000ms, got %v (known %v, reported %v)", skew, known, reported)
+	}
+
+	if clusterNow := storage.clusterNow(clusterMap); clusterNow < now {
+		t.Errorf("Expected the local clock without compensation, got %v for %v", clusterNow, now)
+	}
+	storage.config.ClockSource = ClockSourceCompensated
+	if clusterNow := storage.clusterNow(clusterMap); (clusterNow < now-30000) || (clusterNow > now-29000) {
+		t.Errorf("Expected the clock to be compensated by 30000ms, got %v for %v", clusterNow, now)
+	}
+}
+
+// Partitions are flagged if their leader changed during the window, but not before it
+func Test_recentLeaderChange(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	tracef := func(string, ...interface{}) {}
+
+	now := time.Now().Unix() * 1000
+	offsets := []ConsumerOffset{{Offset: 100, Timestamp: now - 20000, Lag: 50}, {Offset: 100, Timestamp: now - 10000, Lag: 60},
+		{Offset: 100, Timestamp: now, Lag: 70}}
+	offsetList := map[string][][]ConsumerOffset{"topic": {offsets, offsets}}
+	brokerList := map[string][]BrokerOffset{"topic": {{LeaderChanged: now - 15000}, {LeaderChanged: now - 60000}}}
+	produceRates := map[string][]float64{"topic": make([]float64, 2)}
+
+	status := &ConsumerGroupStatus{Group: "group", Status: StatusOK, Complete: true, TotalPartitions: 2}
+	storage.evaluatePartitions(status, offsetList, brokerList, produceRates, map[string]bool{}, nil, nil,
+		&EvaluationParams{Intervals: 3}, now, false, 0, true, nil, tracef)
+	if len(status.Partitions) != 2 {
+		t.Fatalf("Expected 2 partitions, got %v", len(status.Partitions))
+	}
+	for _, partition := range status.Partitions {
+		if partition.RecentLeaderChange != (partition.Partition == 0) {
+			t.Errorf("Partition %v: unexpected recent leader change %v", partition.Partition, partition.RecentLeaderChange)
+		}
+	}
+}
+
+// Partitions have the member of the group they are assigned to, until the group has no members
+func Test_partitionOwners(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	tracef := func(string, ...interface{}) {}
+
+	storage.setGroupMembers(&RequestGroupMembersSet{Cluster: "test", Group: "group", Members: []*GroupMember{
+		{PartitionOwner: PartitionOwner{MemberId: "consumer-1-abc", ClientId: "consumer-1", ClientHost: "/10.0.0.1"},
+			Assignment: map[string][]int32{"topic": {0}}},
+		{PartitionOwner: PartitionOwner{MemberId: "consumer-2-def", ClientId: "consumer-2", ClientHost: "/10.0.0.2"},
+			Assignment: map[string][]int32{"other": {0, 1}}},
+	}})
+	owners := storage.offsets["test"].partitionOwners("group")
+
+	now := time.Now().Unix() * 1000
+	offsets := []ConsumerOffset{{Offset: 100, Timestamp: now - 20000, Lag: 50}, {Offset: 100, Timestamp: now - 10000, Lag: 60},
+		{Offset: 100, Timestamp: now, Lag: 70}}
+	offsetList := map[string][][]ConsumerOffset{"topic": {offsets, offsets}}
+	brokerList := map[string][]BrokerOffset{"topic": make([]BrokerOffset, 2)}
+	produceRates := map[string][]float64{"topic": make([]float64, 2)}
+
+	status := &ConsumerGroupStatus{Group: "group", Status: StatusOK, Complete: true, TotalPartitions: 2}
+	storage.evaluatePartitions(status, offsetList, brokerList, produceRates, map[string]bool{}, nil, owners,
+		&EvaluationParams{Intervals: 3}, now, false, 0, true, nil, tracef)
+	if len(status.Partitions) != 2 {
+		t.Fatalf("Expected 2 partitions, got %v", len(status.Partitions))
+	}
+	for _, partition := range status.Partitions {
+		if partition.Partition == 0 {
+			if (partition.Owner == nil) || (partition.Owner.ClientHost != "/10.0.0.1") {
+				t.Errorf("Expected partition 0 to be owned by consumer-1, got %+v", partition.Owner)
+			}
+		} else if partition.Owner != nil {
+			t.Errorf("Expected partition %v to have no owner, got %+v", partition.Partition, partition.Owner)
+		}
+	}
+
+	storage.setGroupMembers(&RequestGroupMembersSet{Cluster: "test", Group: "group"})
+	if owners := storage.offsets["test"].partitionOwners("group"); owners != nil {
+		t.Errorf("Expected no owners once the group is empty, got %v", owners)
+	}
+}
+
+// Status results have the offset timestamps as ISO-8601 times in the display location
+func Test_displayTimes(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	tracef := func(string, ...interface{}) {}
+
+	now := int64(1709259165120)
+	offsets := []ConsumerOffset{{Offset: 100, Timestamp: now - 20000, Lag: 50}, {Offset: 150, Timestamp: now - 10000, Lag: 50},
+		{Offset: 200, Timestamp: now, Lag: 50}}
+	offsetList := map[string][][]ConsumerOffset{"topic": {offsets}}
+	brokerList := map[string][]BrokerOffset{"topic": {{}}}
+	produceRates := map[string][]float64{"topic": make([]float64, 1)}
+
+	for _, test := range []struct {
+		location *time.Location
+		start    string
+		end      string
+	}{
+		{nil, "2024-03-01T02:12:25.120Z", "2024-03-01T02:12:45.120Z"},
+		{time.FixedZone("CET", 3600), "2024-03-01T03:12:25.120+01:00", "2024-03-01T03:12:45.120+01:00"},
+	} {
+		storage.config.DisplayLocation = test.location
+		status := &ConsumerGroupStatus{Group: "group", Status: StatusOK, Complete: true, TotalPartitions: 1}
+		storage.evaluatePartitions(status, offsetList, brokerList, produceRates, map[string]bool{}, nil, nil,
+			&EvaluationParams{Intervals: 3}, now, false, 0, true, nil, tracef)
+		if len(status.Partitions) != 1 {
+			t.Fatalf("Expected 1 partition, got %v", len(status.Partitions))
+		}
+		if (status.Partitions[0].Start.Time != test.start) || (status.Partitions[0].End.Time != test.end) {
+			t.Errorf("Expected times %s and %s, got %s and %s", test.start, test.end, status.Partitions[0].Start.Time,
+				status.Partitions[0].End.Time)
+		}
+	}
+	if offsets[0].Time != "" {
+		t.Errorf("Expected the stored offsets to be left without times")
+	}
+}
+
+// A group is WARMING for the burn-in period after its first commit, but not if that commit was long ago
+func Test_burnIn(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	storage.config.BurnIn = 300
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addConsumerOffset(consumerOffset("new", "topic", 0, 900, now))
+	storage.addConsumerOffset(consumerOffset("replayed", "topic", 0, 900, now-600000))
+
+	status := storage.GroupStatus("test", "new", true)
+	if (status.Status != StatusWarming) || (status.WarmingUntil != now+300000) {
+		t.Errorf("Expected new group to be WARMING until %v, got %v until %v", now+300000, status.Status, status.WarmingUntil)
+	}
+	if status := storage.GroupStatus("test", "replayed", true); status.Status == StatusWarming {
+		t.Errorf("Group with an old first commit is WARMING")
+	}
+}
+
+// Old commits read just after a start don't stop the partition until commits have been read for a whole window
+func Test_stopAfterStartup(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	for i := int64(0); i < 3; i++ {
+		storage.addConsumerOffset(consumerOffset("group", "topic", 0, 100+i*100, now-600000+i*60000))
+	}
+	if status := storage.GroupStatus("test", "group", true); status.Status != StatusOK {
+		t.Errorf("Expected the group to be OK right after startup, got %v", status.Status)
+	}
+
+	storage.offsets["test"].consumerLock.Lock()
+	storage.offsets["test"].watchingSince = now - 600000
+	storage.offsets["test"].consumerLock.Unlock()
+	if status := storage.GroupStatus("test", "group", true); status.Status != StatusError {
+		t.Errorf("Expected the group to be stopped once commits were read for a window, got %v", status.Status)
+	}
+}
+
+// Evaluating the topics of a large group in parallel gives the same result as one after another
+func Test_parallelEvaluation(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	for _, topic := range []string{"topic1", "topic2", "topic3"} {
+		for partition := int32(0); partition < 400; partition++ {
+			storage.addBrokerOffset(brokerOffset(topic, partition, 400, 10000, now))
+			for i := int64(0); i < 3; i++ {
+				// Every tenth partition is stalled
+				offset := 100 + i*100
+				if partition%10 == 0 {
+					offset = 100
+				}
+				storage.addConsumerOffset(consumerOffset("group", topic, partition, offset, now-120000+i*60000))
+			}
+		}
+	}
+
+	storage.config.EvaluationWorkers = 1
+	sequential := storage.GroupStatus("test", "group", true)
+	storage.config.EvaluationWorkers = 4
+	parallel := storage.GroupStatus("test", "group", true)
+	if (parallel.Status != sequential.Status) || (parallel.TotalLag != sequential.TotalLag) ||
+		(len(parallel.Partitions) != len(sequential.Partitions)) || (parallel.Maxlag.End.Lag != sequential.Maxlag.End.Lag) {
+		t.Errorf("Parallel evaluation gave %v (lag %v, %v partitions), sequential gave %v (lag %v, %v partitions)",
+			parallel.Status, parallel.TotalLag, len(parallel.Partitions), sequential.Status, sequential.TotalLag,
+			len(sequential.Partitions))
+	}
+	if sequential.Status != StatusError {
+		t.Errorf("Expected the group to be an error, got %v", sequential.Status)
+	}
+}
+
+// A dead letter topic is linked to its group by the rule's capture, and makes the group a warning when it spikes
+func Test_deadLetter(t *testing.T) {
+	storage, err := NewOffsetStorage(&Config{
+		Clusters:         map[string]*ClusterConfig{"test": {}},
+		Intervals:        3,
+		BrokerIntervals:  3,
+		ExpireGroup:      3600,
+		DroppedOffsets:   100,
+		ArchiveRetention: 3600,
+		ArchiveInterval:  60,
+		DeadLetterTopics: []*DeadLetterConfig{{Topics: `^(?P<group>.+)[.]dlq$`, MaxRate: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	storage.addBrokerOffset(brokerOffset("orders.dlq", 0, 1, 0, now-10000))
+	storage.addBrokerOffset(brokerOffset("orders.dlq", 0, 1, 100, now))
+	storage.addConsumerOffset(consumerOffset("orders", "topic", 0, 1000, now))
+
+	status := storage.GroupStatus("test", "orders", true)
+	if (len(status.DeadLetter) != 1) || (status.DeadLetter[0].Topic != "orders.dlq") || (status.DeadLetter[0].Rate != 10) {
+		t.Fatalf("Expected the dead letter topic with a rate of 10, got %v", status.DeadLetter)
+	}
+	if (!status.DeadLetter[0].Spiking) || (status.Status != StatusWarning) {
+		t.Errorf("Expected the group to be a warning with a spiking dead letter topic, got %v", status.Status)
+	}
+
+	if _, err := CompileDeadLetterRule(`^(.+)[.]dlq$`); err == nil {
+		t.Errorf("Rule with no group capture was accepted")
+	}
+}
+
+func Test_catchUpPriorityGroups(t *testing.T) {
+	storage, err := NewOffsetStorage(&Config{
+		Clusters:            map[string]*ClusterConfig{"test": {}},
+		Intervals:           3,
+		BrokerIntervals:     3,
+		ExpireGroup:         3600,
+		DroppedOffsets:      100,
+		ArchiveRetention:    3600,
+		ArchiveInterval:     60,
+		CatchUpBacklog:      1,
+		PriorityGroupExpire: 600,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+
+	// Looking at a group makes it a priority group, even before it has committed
+	storage.GroupStatus("test", "hot", false)
+	clusterMap := storage.offsets["test"]
+	if _, ok := clusterMap.priorityGroups["hot"]; !ok {
+		t.Fatalf("Expected the queried group to be a priority group")
+	}
+
+	offsets := make(chan *PartitionOffset, 2)
+	offsets <- consumerOffset("hot", "topic", 0, 900, now)
+	storage.catchUp(consumerOffset("cold", "topic", 0, 900, now), offsets)
+	clusterMap.consumerLock.RLock()
+	_, ok := clusterMap.consumer["hot"]
+	clusterMap.consumerLock.RUnlock()
+	if !ok {
+		t.Errorf("Expected the priority group's commit to be stored when the catch up returned")
+	}
+
+	clusterMap.priorityGroups["hot"] = now - 601000
+	storage.prunePriorityGroups()
+	if _, ok := clusterMap.priorityGroups["hot"]; ok {
+		t.Errorf("Expected the priority group to expire")
+	}
+}
+
+func Test_clusterListCounts(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 2, 1000, now-1000))
+	storage.addBrokerOffset(brokerOffset("topic", 1, 2, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900, now-500))
+
+	list := storage.ClusterList()
+	counts, ok := list.Counts["test"]
+	if (!ok) || (len(list.Clusters) != 1) {
+		t.Fatalf("Expected counts for the test cluster, got %v", list)
+	}
+	if (counts.Groups != 1) || (counts.Topics != 1) || (counts.Partitions != 2) {
+		t.Errorf("Expected 1 group, 1 topic, and 2 partitions, got %+v", counts)
+	}
+	if (counts.LastBrokerOffset != now) || (counts.LastCommit != now-500) {
+		t.Errorf("Expected the newest broker offset and commit timestamps, got %+v", counts)
+	}
+}
+
+func Test_fileBackendRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "burrow")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	testBackendRestart(t, func() Backend { return NewFileBackend(filepath.Join(dir, "offsets.json"), JSONCodec{}) })
+}
+
+func Test_boltBackendRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "burrow")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	testBackendRestart(t, func() Backend {
+		backend, err := NewBoltBackend(filepath.Join(dir, "offsets.db"), GobCodec{})
+		if err != nil {
+			t.Fatalf("Cannot open BoltDB backend: %v", err)
+		}
+		return backend
+	})
+}
+
+// Store a group and stop storage, which saves a checkpoint and closes the backend, then start storage again with the
+// backend reopened
+func testBackendRestart(t *testing.T, open func() Backend) {
+	config := &Config{
+		Clusters:           map[string]*ClusterConfig{"test": {}},
+		Intervals:          3,
+		BrokerIntervals:    3,
+		ExpireGroup:        3600,
+		DroppedOffsets:     100,
+		ArchiveRetention:   3600,
+		ArchiveInterval:    60,
+		Backend:            open(),
+		CheckpointInterval: 60,
+	}
+
+	storage, err := NewOffsetStorage(config)
+	if err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	for i := int64(0); i < 3; i++ {
+		storage.addConsumerOffset(consumerOffset("group", "topic", 0, 900+i*50, now-(3-i)*60000))
+	}
+	before := storage.GroupStatus("test", "group", true)
+	storage.Stop()
+
+	// The rings are saved when the storage module stops, and loaded by the next one
+	config.Backend = open()
+	storage, err = NewOffsetStorage(config)
+	if err != nil {
+		t.Fatalf("Cannot create storage from the checkpoint: %v", err)
+	}
+	defer storage.Stop()
+	after := storage.GroupStatus("test", "group", true)
+	if (!after.Complete) || (after.Status != before.Status) || (after.TotalLag != before.TotalLag) {
+		t.Errorf("Expected the same complete status after the restart, got %v (lag %v), was %v (lag %v)",
+			after.Status, after.TotalLag, before.Status, before.TotalLag)
+	}
+}
+
+// A checkpoint comes back the same from each codec, including the partitions without broker offsets, and a checkpoint
+// saved with one codec can be loaded with another
+func Test_checkpointCodecs(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 1, 3, 1000, now))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 1, 900, now))
+	checkpoint := storage.TakeCheckpoint()
+
+	for _, codec := range Codecs {
+		data, err := codec.Encode(checkpoint)
+		if err != nil {
+			t.Fatalf("%s: cannot encode checkpoint: %v", codec.Name(), err)
+		}
+		for _, other := range Codecs {
+			decoded := &Checkpoint{}
+			if err := DecodeAny(other, data, decoded); err != nil {
+				t.Fatalf("%s: cannot decode checkpoint with %s first: %v", codec.Name(), other.Name(), err)
+			}
+			partitions := decoded.Clusters["test"].Broker["topic"].Partitions
+			if (len(partitions) != 3) || (partitions[0] != nil) || (partitions[1] == nil) || (partitions[1].Offset != 1000) ||
+				(partitions[2] != nil) {
+				t.Errorf("%s: unexpected broker partitions %v", codec.Name(), partitions)
+			}
+			if offsets := decoded.Clusters["test"].Consumer["group"]["topic"]; (len(offsets) != 3) || (offsets[1][0].Offset != 900) {
+				t.Errorf("%s: unexpected consumer offsets %v", codec.Name(), offsets)
+			}
+		}
+	}
+}
+
+// Compare the codecs on a checkpoint of 100 groups with 100 partitions each
+func Benchmark_checkpointCodecs(b *testing.B) {
+	storage, _ := NewOffsetStorage(&Config{Clusters: map[string]*ClusterConfig{"test": {}}, Intervals: 10,
+		BrokerIntervals: 10, ExpireGroup: 3600, DroppedOffsets: 100, ArchiveRetention: 3600, ArchiveInterval: 60})
+	defer storage.Stop()
+	now := time.Now().Unix() * 1000
+	for partition := int32(0); partition < 100; partition++ {
+		storage.addBrokerOffset(brokerOffset("topic", partition, 100, 100000, now))
+	}
+	for group := 0; group < 100; group++ {
+		for partition := int32(0); partition < 100; partition++ {
+			for i := int64(0); i < 10; i++ {
+				storage.addConsumerOffset(consumerOffset(fmt.Sprintf("group-%v", group), "topic", partition, 1000+i*100, now-(10-i)*60000))
+			}
+		}
+	}
+	checkpoint := storage.TakeCheckpoint()
+
+	for _, codec := range Codecs {
+		data, _ := codec.Encode(checkpoint)
+		b.Run(codec.Name()+"-encode", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				codec.Encode(checkpoint)
+			}
+		})
+		b.Run(codec.Name()+"-decode", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				codec.Decode(data, &Checkpoint{})
+			}
+		})
+	}
+}
+
+// Merging a checkpoint keeps the offsets that are already stored, and adds the older ones from the checkpoint ahead of
+// them
+func Test_mergeCheckpoint(t *testing.T) {
+	now := time.Now().Unix() * 1000
+	old := newTestStorage(t)
+	defer old.Stop()
+	old.addBrokerOffset(brokerOffset("topic", 0, 1, 1000, now))
+	for i := int64(0); i < 3; i++ {
+		old.addConsumerOffset(consumerOffset("group", "topic", 0, 800+i*50, now-(4-i)*60000))
+	}
+	old.addConsumerOffset(consumerOffset("other", "topic", 0, 500, now-60000))
+
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	storage.addBrokerOffset(brokerOffset("topic", 0, 1, 1100, now+1000))
+	storage.addConsumerOffset(consumerOffset("group", "topic", 0, 1000, now))
+	storage.MergeCheckpoint(old.TakeCheckpoint())
+
+	clusterMap := storage.offsets["test"]
+	if offset := clusterMap.broker["topic"].partitions[0].Offset; offset != 1100 {
+		t.Errorf("Expected the stored broker offset of 1100 to be kept, got %v", offset)
+	}
+	var offsets []int64
+	for _, val := range ringValues(clusterMap.consumer["group"]["topic"][0]) {
+		offsets = append(offsets, val.(*ConsumerOffset).Offset)
+	}
+	if (len(offsets) != 3) || (offsets[0] != 850) || (offsets[1] != 900) || (offsets[2] != 1000) {
+		t.Errorf("Expected the newest checkpoint offsets ahead of the stored one (850, 900, 1000), got %v", offsets)
+	}
+	if _, ok := clusterMap.consumer["other"]; !ok {
+		t.Errorf("Expected a group only in the checkpoint to be added")
+	}
+}
+
+// A cluster that reaches its group limit either refuses new groups or makes room for them by evicting the groups that
+// have gone longest without a commit, and a new topic that would go over the partition limit is not stored. Groups and
+// topics that are turned away are only counted once, however many offsets they have
+func Test_clusterLimits(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	limits := storage.config.Clusters["test"]
+	limits.MaxGroups = 10
+	limits.MaxPartitions = 4
+	limits.LimitMode = LimitModeStopAdding
+
+	now := time.Now().Unix() * 1000
+	storage.addBrokerOffset(brokerOffset("topic", 0, 2, 1000, now))
+	storage.addBrokerOffset(brokerOffset("topic", 1, 2, 1000, now))
+	storage.addBrokerOffset(brokerOffset("large", 0, 3, 1000, now))
+	storage.addBrokerOffset(brokerOffset("large", 1, 3, 1000, now))
+	if _, ok := storage.offsets["test"].broker["large"]; ok {
+		t.Errorf("Expected a topic that goes over the partition limit not to be stored")
+	}
+	for i := 0; i < 12; i++ {
+		storage.addConsumerOffset(consumerOffset(fmt.Sprintf("group-%v", i), "topic", 0, 900, now-int64(12-i)*1000))
+	}
+	storage.addConsumerOffset(consumerOffset("group-11", "topic", 1, 900, now))
+	if groups := len(storage.ConsumerList("test")); groups != 10 {
+		t.Errorf("Expected new groups to be refused at the limit of 10, got %v", groups)
+	}
+
+	breaches := storage.LimitBreaches("test")
+	if (len(breaches) != 2) || (breaches[0].Limit != LimitGroups) || (breaches[0].Refused != 2) || (breaches[0].Current != 10) || (breaches[0].Excluded != 2) {
+		t.Fatalf("Expected the group limit to be reported with 2 refused, got %+v", breaches)
+	}
+	if (breaches[1].Limit != LimitPartitions) || (breaches[1].Refused != 1) || (breaches[1].Excluded != 1) {
+		t.Errorf("Expected the partition limit to be reported with 1 refused, got %+v", breaches[1])
+	}
+
+	limits.LimitMode = LimitModeEvictOldest
+	storage.addConsumerOffset(consumerOffset("newest", "topic", 0, 900, now))
+	groups := storage.ConsumerList("test")
+	if len(groups) != 9 {
+		t.Fatalf("Expected 8 groups and the new one after evicting down to 90%%, got %v", groups)
+	}
+	for _, group := range groups {
+		if (group == "group-0") || (group == "group-1") {
+			t.Errorf("Expected the groups with the oldest commits to be evicted, got %v", groups)
+		}
+	}
+
+	// The evicted groups are kept out, rather than coming back and evicting others
+	storage.addConsumerOffset(consumerOffset("group-0", "topic", 0, 950, now+1000))
+	if groups := storage.ConsumerList("test"); len(groups) != 9 {
+		t.Errorf("Expected an evicted group to be kept out, got %v", groups)
+	}
+	if breach := storage.LimitBreaches("test")[0]; (breach.Evicted != 2) || (breach.Refused != 2) || (breach.Excluded != 4) {
+		t.Errorf("Expected 2 evicted and 4 excluded groups, got %+v", breach)
+	}
+}
+
+// Topics evicted to make room stay out while the brokers keep reporting them, so the topics don't churn, and in
+// sample mode the stored topics are a random sample of all of them
+func Test_topicLimits(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	limits := storage.config.Clusters["test"]
+	limits.MaxTopics = 4
+	limits.LimitMode = LimitModeEvictOldest
+
+	now := time.Now().Unix() * 1000
+	for tick := int64(0); tick < 3; tick++ {
+		for i := int64(0); i < 5; i++ {
+			storage.addBrokerOffset(brokerOffset(fmt.Sprintf("topic-%v", i), 0, 1, 1000, now-(5-i)*1000+tick*60000))
+		}
+	}
+	clusterMap := storage.offsets["test"]
+	if (len(clusterMap.broker) != 3) || (clusterMap.broker["topic-4"] == nil) {
+		t.Errorf("Expected the 2 oldest topics to be evicted for topic-4, got %v topics", len(clusterMap.broker))
+	}
+	breach := storage.LimitBreaches("test")[0]
+	if (breach.Limit != LimitTopics) || (breach.Evicted != 2) || (breach.Refused != 0) || (breach.Excluded != 2) {
+		t.Errorf("Expected the evicted topics to stay out, got %+v", breach)
+	}
+	storage.addBrokerOffset(brokerOffset("topic-5", 0, 1, 1000, now))
+	if clusterMap.broker["topic-5"] == nil {
+		t.Errorf("Expected a new topic to be stored while there is room")
+	}
+
+	// Each of 20 topics is kept with a chance of 4 in 20, whatever order the brokers report them in
+	kept := 0
+	for trial := 0; trial < 200; trial++ {
+		sampled := newTestStorage(t)
+		sampled.config.Clusters["test"].MaxTopics = 4
+		sampled.config.Clusters["test"].LimitMode = LimitModeSample
+		for tick := 0; tick < 2; tick++ {
+			for i := 0; i < 20; i++ {
+				sampled.addBrokerOffset(brokerOffset(fmt.Sprintf("topic-%v", i), 0, 1, 1000, now))
+			}
+		}
+		if topics := len(sampled.offsets["test"].broker); topics != 4 {
+			t.Fatalf("Expected 4 topics to be sampled, got %v", topics)
+		}
+		if breach := sampled.LimitBreaches("test")[0]; breach.Refused+breach.Evicted != 16 {
+			t.Fatalf("Expected each topic over the limit to be offered once, got %+v", breach)
+		}
+		for i := 0; i < 4; i++ {
+			if sampled.offsets["test"].broker[fmt.Sprintf("topic-%v", i)] != nil {
+				kept++
+			}
+		}
+		sampled.Stop()
+	}
+	if (kept < 80) || (kept > 240) {
+		t.Errorf("Expected the first 4 topics to be kept about 160 times in 200 trials, got %v", kept)
+	}
+}
+
+// A partition that rewinds while it is close to falling off the retention window is a REWIND, as rewinds are checked
+// before the retention risk
+func Test_rewindBeforeRetentionRisk(t *testing.T) {
+	storage := newTestStorage(t)
+	defer storage.Stop()
+	storage.config.RetentionRisk = 3600
+
+	now := time.Now().Unix() * 1000
+	for i := int64(0); i < 3; i++ {
+		offset := brokerOffset("topic", 0, 1, 1000*(i+1), now-(2-i)*60000)
+		offset.OldestOffset = 790
+		storage.addBrokerOffset(offset)
+	}
+	for i, offset := range []int64{900, 950, 800} {
+		storage.addConsumerOffset(consumerOffset("group", "topic", 0, offset, now-int64(3-i)*10000))
+	}
+
+	status := storage.GroupStatus("test", "group", false)
+	if len(status.Partitions) != 1 {
+		t.Fatalf("Expected one partition with a problem, got %v", len(status.Partitions))
+	}
+	if status.Partitions[0].Status != StatusRewind {
+		t.Errorf("Expected the partition to be REWIND, got %v", status.Partitions[0].Status)
+	}
+	if status.Partitions[0].TimeToRetention >= storage.config.RetentionRisk {
+		t.Errorf("Expected the partition to be at risk of falling off the retention window, got %v", status.Partitions[0].TimeToRetention)
+	}
+}
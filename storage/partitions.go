@@ -0,0 +1,66 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+)
+
+// The broker offsets and offset history for one topic. Once a topicPartitions is in the cluster's broker map, its
+// slices are never resized. When the topic's partition count goes up, a new topicPartitions with longer slices and the
+// next version is built and swapped into the map under the broker write lock, so anything that looked up the old one
+// keeps a consistent (if shorter) view of the topic
+type topicPartitions struct {
+	version    int
+	partitions []*BrokerOffset
+	history    []*ring.Ring
+}
+
+func newTopicPartitions(count int) *topicPartitions {
+	return &topicPartitions{
+		partitions: make([]*BrokerOffset, count),
+		history:    make([]*ring.Ring, count),
+	}
+}
+
+// Return a copy of the topic that holds count partitions, with the version bumped. The existing partitions are shared
+// with the copy, not duplicated. If the topic already holds that many partitions, it is returned as it is
+func (topic *topicPartitions) grow(count int) *topicPartitions {
+	if count <= len(topic.partitions) {
+		return topic
+	}
+
+	grown := newTopicPartitions(count)
+	grown.version = topic.version + 1
+	copy(grown.partitions, topic.partitions)
+	copy(grown.history, topic.history)
+	return grown
+}
+
+// Return the broker offset for the partition, or nil if we don't have one yet
+func (topic *topicPartitions) partition(partition int) *BrokerOffset {
+	if (topic == nil) || (partition < 0) || (partition >= len(topic.partitions)) {
+		return nil
+	}
+	return topic.partitions[partition]
+}
+
+// Return a copy of a group's offset rings for a topic that holds count partitions. As with the broker offsets, the
+// caller must store the result back in the group's map, rather than appending to the slice that is there
+func growConsumerPartitions(rings []*ring.Ring, count int) []*ring.Ring {
+	if count <= len(rings) {
+		return rings
+	}
+
+	grown := make([]*ring.Ring, count)
+	copy(grown, rings)
+	return grown
+}
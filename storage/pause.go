@@ -0,0 +1,107 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// Pause monitoring of a cluster, such as for planned broker maintenance. While a cluster is paused, offsets for it are
+// dropped, and its groups are evaluated as they were when the pause started, so they don't go stale. Returns false if
+// the cluster is not known or is already paused
+func (storage *OffsetStorage) PauseCluster(cluster string) bool {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return false
+	}
+
+	clusterMap.pauseLock.Lock()
+	defer clusterMap.pauseLock.Unlock()
+	if clusterMap.paused > 0 {
+		return false
+	}
+	clusterMap.paused = time.Now().Unix() * 1000
+	log.Infof("Paused monitoring of cluster %s", cluster)
+	return true
+}
+
+// Resume monitoring of a paused cluster. Every stored offset is moved forward by however long the cluster was paused,
+// so the gap is not seen as groups having stopped committing. Returns false if the cluster is not known or is not
+// paused
+func (storage *OffsetStorage) ResumeCluster(cluster string) bool {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return false
+	}
+
+	clusterMap.pauseLock.Lock()
+	defer clusterMap.pauseLock.Unlock()
+	if clusterMap.paused == 0 {
+		return false
+	}
+	shift := time.Now().Unix()*1000 - clusterMap.paused
+
+	clusterMap.brokerLock.Lock()
+	for _, topic := range clusterMap.broker {
+		for _, offset := range topic.partitions {
+			if offset != nil {
+				offset.Timestamp += shift
+			}
+		}
+		for _, history := range topic.history {
+			shiftRing(history, shift, func(val interface{}) *int64 { return &val.(*BrokerOffset).Timestamp })
+		}
+	}
+	clusterMap.brokerLock.Unlock()
+
+	clusterMap.consumerLock.Lock()
+	for _, topics := range clusterMap.consumer {
+		for _, partitions := range topics {
+			for _, offsetRing := range partitions {
+				shiftRing(offsetRing, shift, func(val interface{}) *int64 { return &val.(*ConsumerOffset).Timestamp })
+			}
+		}
+	}
+	clusterMap.consumerLock.Unlock()
+
+	clusterMap.paused = 0
+	log.Infof("Resumed monitoring of cluster %s after %v", cluster, time.Duration(shift)*time.Millisecond)
+	return true
+}
+
+// Return when monitoring of the cluster was paused (in milliseconds), or zero if it is not paused
+func (storage *OffsetStorage) ClusterPausedAt(cluster string) int64 {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return 0
+	}
+	return clusterMap.pausedAt()
+}
+
+func (clusterMap *ClusterOffsets) pausedAt() int64 {
+	clusterMap.pauseLock.RLock()
+	defer clusterMap.pauseLock.RUnlock()
+	return clusterMap.paused
+}
+
+// Move the timestamp of every value in the ring forward
+func shiftRing(offsetRing *ring.Ring, shift int64, timestamp func(val interface{}) *int64) {
+	if offsetRing == nil {
+		return
+	}
+	offsetRing.Do(func(val interface{}) {
+		if val != nil {
+			*timestamp(val) += shift
+		}
+	})
+}
@@ -0,0 +1,292 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// How many offsets can be waiting for each cluster's pipeline
+const clusterOffsetChannelSize = 10000
+
+// Each cluster has its own pipeline: a goroutine with its own offset and request channels. A flood of offsets from one
+// cluster fills only that cluster's channel, and doesn't hold up requests for the others. Offsets and requests that
+// come in on the shared OffsetChannel and RequestChannel are routed to the pipeline for their cluster
+type clusterPipeline struct {
+	cluster  string
+	offsets  chan *PartitionOffset
+	requests chan interface{}
+	quit     chan struct{}
+	stopped  chan struct{}
+}
+
+func newClusterPipeline(cluster string) *clusterPipeline {
+	return &clusterPipeline{
+		cluster:  cluster,
+		offsets:  make(chan *PartitionOffset, clusterOffsetChannelSize),
+		requests: make(chan interface{}),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (storage *OffsetStorage) runPipeline(pipeline *clusterPipeline) {
+	for {
+		select {
+		case o := <-pipeline.offsets:
+			if (storage.config.CatchUpBacklog > 0) && (len(pipeline.offsets) >= storage.config.CatchUpBacklog) {
+				storage.catchUp(o, pipeline.offsets)
+			} else {
+				storage.dispatchOffset(o)
+			}
+		case r := <-pipeline.requests:
+			storage.dispatchRequest(r)
+		case <-pipeline.quit:
+			close(pipeline.stopped)
+
+			// Keep taking offsets for the cluster so that sources sending them don't block, but drop them
+			for {
+				select {
+				case <-pipeline.offsets:
+				case <-storage.quit:
+					return
+				}
+			}
+		case <-storage.quit:
+			return
+		}
+	}
+}
+
+// Stop processing offsets for one cluster. Offsets that are waiting for the cluster, and any that are sent after this,
+// are dropped. Requests for the cluster are still answered from what is stored, by the shared request loop
+func (storage *OffsetStorage) StopCluster(cluster string) {
+	pipeline, ok := storage.pipelines[cluster]
+	if !ok {
+		return
+	}
+
+	select {
+	case <-pipeline.quit:
+		// Already stopped
+	default:
+		log.Infof("Stopping storage pipeline for cluster %s", cluster)
+		close(pipeline.quit)
+	}
+	<-pipeline.stopped
+}
+
+// Return the channel that offsets for the cluster should be sent on. Offset sources should use this rather than the
+// shared OffsetChannel, so that they only ever wait on their own cluster
+func (storage *OffsetStorage) ClusterOffsetChannel(cluster string) chan *PartitionOffset {
+	if pipeline, ok := storage.pipelines[cluster]; ok {
+		return pipeline.offsets
+	}
+	return storage.OffsetChannel
+}
+
+// Route an offset from the shared channel to its cluster's pipeline. If the pipeline has been stopped, the offset is
+// dropped
+func (storage *OffsetStorage) routeOffset(offset *PartitionOffset) {
+	pipeline, ok := storage.pipelines[offset.Cluster]
+	if !ok {
+		// Offsets for clusters that we don't know about are ignored anyways
+		storage.dispatchOffset(offset)
+		return
+	}
+
+	select {
+	case pipeline.offsets <- offset:
+	case <-pipeline.stopped:
+	}
+}
+
+// Return the channel a request should be sent on: its cluster's pipeline if it is for a single cluster that is
+// running, or the shared request channel if it is not
+func (storage *OffsetStorage) requestChannel(request interface{}) chan interface{} {
+	if pipeline, ok := storage.pipelines[requestCluster(request)]; ok {
+		select {
+		case <-pipeline.stopped:
+		default:
+			return pipeline.requests
+		}
+	}
+	return storage.RequestChannel
+}
+
+// Route a request from the shared channel to its cluster's pipeline. Requests that are not for a single cluster, and
+// requests for clusters whose pipeline has been stopped, are handled here
+func (storage *OffsetStorage) routeRequest(request interface{}) {
+	pipeline, ok := storage.pipelines[requestCluster(request)]
+	if !ok {
+		storage.dispatchRequest(request)
+		return
+	}
+
+	select {
+	case pipeline.requests <- request:
+	case <-pipeline.stopped:
+		storage.dispatchRequest(request)
+	}
+}
+
+// Return the cluster a request is for, or an empty string if it is not for a single cluster
+func requestCluster(request interface{}) string {
+	switch r := request.(type) {
+	case *RequestConsumerList:
+		return r.Cluster
+	case *RequestTopicList:
+		return r.Cluster
+	case *RequestOffsets:
+		return r.Cluster
+	case *RequestTopicRate:
+		return r.Cluster
+	case *RequestBrokerOffsets:
+		return r.Cluster
+	case *RequestConsumerOffsets:
+		return r.Cluster
+	case *RequestConsumerStatus:
+		return r.Cluster
+	case *RequestConsumerDrop:
+		return r.Cluster
+	case *RequestConsumerRestore:
+		return r.Cluster
+	case *RequestMarkCaughtUp:
+		return r.Cluster
+	case *RequestDroppedOffsets:
+		return r.Cluster
+	case *RequestOffsetHistory:
+		return r.Cluster
+	case *RequestOffsetDelta:
+		return r.Cluster
+	case *RequestExpectedGroupList:
+		return r.Cluster
+	case *RequestExpectedGroupSet:
+		return r.Cluster
+	case *RequestExpectedGroupDelete:
+		return r.Cluster
+	case *RequestIgnoredPartitionList:
+		return r.Cluster
+	case *RequestIgnoredPartitionSet:
+		return r.Cluster
+	case *RequestIgnoredPartitionDelete:
+		return r.Cluster
+	case *RequestGroupMetadata:
+		return r.Cluster
+	case *RequestGroupMetadataSet:
+		return r.Cluster
+	case *RequestGroupMembersSet:
+		return r.Cluster
+	case *RequestWarmupStatus:
+		return r.Cluster
+	default:
+		return ""
+	}
+}
+
+func (storage *OffsetStorage) dispatchOffset(o *PartitionOffset) {
+	if o.Group == "" {
+		go storage.addBrokerOffset(o)
+	} else {
+		go storage.addConsumerOffset(o)
+	}
+}
+
+func (storage *OffsetStorage) dispatchRequest(r interface{}) {
+	switch r.(type) {
+	case *RequestClusterList:
+		request, _ := r.(*RequestClusterList)
+		go storage.requestClusterList(request)
+	case *RequestConsumerList:
+		request, _ := r.(*RequestConsumerList)
+		go storage.requestConsumerList(request)
+	case *RequestTopicList:
+		request, _ := r.(*RequestTopicList)
+		go storage.requestTopicList(request)
+	case *RequestOffsets:
+		request, _ := r.(*RequestOffsets)
+		go storage.requestOffsets(request)
+	case *RequestTopicRate:
+		request, _ := r.(*RequestTopicRate)
+		go storage.requestTopicRate(request)
+	case *RequestBrokerOffsets:
+		request, _ := r.(*RequestBrokerOffsets)
+		go storage.requestBrokerOffsets(request)
+	case *RequestConsumerOffsets:
+		request, _ := r.(*RequestConsumerOffsets)
+		go storage.requestConsumerOffsets(request)
+	case *RequestConsumerStatus:
+		request, _ := r.(*RequestConsumerStatus)
+		if clusterMap, ok := storage.offsets[request.Cluster]; ok && (!request.Background) && (request.Params == nil) {
+			storage.markPriorityGroup(clusterMap, request.Group)
+		}
+		if request.At > 0 {
+			go storage.evaluateGroupAt(request)
+		} else {
+			go storage.evaluateGroup(request.Cluster, request.Group, request.Result, request.Showall, request.Trace,
+				request.Params, request.Partitions)
+		}
+	case *RequestConsumerDrop:
+		request, _ := r.(*RequestConsumerDrop)
+		go storage.dropGroup(request.Cluster, request.Group, request.Result)
+	case *RequestConsumerRestore:
+		request, _ := r.(*RequestConsumerRestore)
+		go storage.restoreGroup(request.Cluster, request.Group, request.Result)
+	case *RequestMarkCaughtUp:
+		request, _ := r.(*RequestMarkCaughtUp)
+		go storage.markCaughtUp(request)
+	case *RequestDroppedOffsets:
+		request, _ := r.(*RequestDroppedOffsets)
+		go storage.requestDroppedOffsets(request)
+	case *RequestOffsetHistory:
+		request, _ := r.(*RequestOffsetHistory)
+		go storage.requestOffsetHistory(request)
+	case *RequestOffsetDelta:
+		request, _ := r.(*RequestOffsetDelta)
+		go storage.requestOffsetDelta(request)
+	case *RequestExpectedGroupList:
+		request, _ := r.(*RequestExpectedGroupList)
+		go storage.requestExpectedGroupList(request)
+	case *RequestExpectedGroupSet:
+		request, _ := r.(*RequestExpectedGroupSet)
+		go storage.setExpectedGroup(request)
+	case *RequestExpectedGroupDelete:
+		request, _ := r.(*RequestExpectedGroupDelete)
+		go storage.deleteExpectedGroup(request)
+	case *RequestIgnoredPartitionList:
+		request, _ := r.(*RequestIgnoredPartitionList)
+		go storage.requestIgnoredPartitionList(request)
+	case *RequestIgnoredPartitionSet:
+		request, _ := r.(*RequestIgnoredPartitionSet)
+		go storage.setIgnoredPartition(request)
+	case *RequestIgnoredPartitionDelete:
+		request, _ := r.(*RequestIgnoredPartitionDelete)
+		go storage.deleteIgnoredPartition(request)
+	case *RequestGroupMetadata:
+		request, _ := r.(*RequestGroupMetadata)
+		go storage.requestGroupMetadata(request)
+	case *RequestGroupMetadataSet:
+		request, _ := r.(*RequestGroupMetadataSet)
+		go storage.setGroupMetadata(request)
+	case *RequestGroupMembersSet:
+		// Set in the order the messages were read, so older members never replace newer ones
+		request, _ := r.(*RequestGroupMembersSet)
+		storage.setGroupMembers(request)
+	case *RequestWarmupStatus:
+		request, _ := r.(*RequestWarmupStatus)
+		go storage.requestWarmupStatus(request)
+	case *RequestDiagnostics:
+		request, _ := r.(*RequestDiagnostics)
+		go storage.requestDiagnostics(request)
+	default:
+		// Silently drop unknown requests
+	}
+}
@@ -0,0 +1,75 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// How the status of a group's partitions is rolled up into the group status
+//   - worst: the group has the worst status of its partitions, so a single stopped partition makes it an error
+//   - percent: the group is an error only if more than ErrorPercent of its partitions are not OK, and otherwise a
+//     warning if any are not OK. A priority partition that is not OK still makes the group an error
+const (
+	RollupWorst   = "worst"
+	RollupPercent = "percent"
+)
+
+type rollupPolicy struct {
+	groups       *regexp.Regexp
+	policy       string
+	errorPercent int
+}
+
+// Return the rollup policy for a group, or nil if it has the default (worst). If more than one matches, the first
+// one configured is used
+func (storage *OffsetStorage) rollupPolicyFor(group string) *rollupPolicy {
+	for _, policy := range storage.rollupPolicies {
+		if policy.groups.MatchString(group) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// Roll the partition statuses up into the group status with the group's policy. The partitions were already rolled up
+// as worst-of while they were evaluated, so this only has to soften that
+func (storage *OffsetStorage) applyRollupPolicy(status *ConsumerGroupStatus, tracef func(string, ...interface{})) {
+	policy := storage.rollupPolicyFor(status.Group)
+	if (policy == nil) || (policy.policy != RollupPercent) || (status.Status != StatusError) || (status.TotalPartitions == 0) {
+		return
+	}
+
+	// A partition can be in the list more than once if it rewound more than once in the window
+	bad := make(map[string]bool)
+	for _, partition := range status.Partitions {
+		if (partition.Status == StatusOK) || (partition.Status == StatusOffline) {
+			continue
+		}
+		if partition.Priority {
+			tracef("rollup: priority partition %s:%v is %v, group stays ERR", partition.Topic, partition.Partition,
+				partition.Status)
+			return
+		}
+		bad[fmt.Sprintf("%s:%v", partition.Topic, partition.Partition)] = true
+	}
+
+	percent := len(bad) * 100 / status.TotalPartitions
+	if len(bad)*100 > policy.errorPercent*status.TotalPartitions {
+		tracef("rollup: %v of %v partitions (%v%%) are not OK, over %v%%, ERR", len(bad), status.TotalPartitions,
+			percent, policy.errorPercent)
+		return
+	}
+	tracef("rollup: %v of %v partitions (%v%%) are not OK, up to %v%%, WARN", len(bad), status.TotalPartitions,
+		percent, policy.errorPercent)
+	status.Status = StatusWarning
+}
@@ -0,0 +1,57 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+// The settings that apply to a group in a cluster, from the patterns in the config that match its name. The rollup
+// policy is worst unless a rollup policy matches, and the data cluster is set if a commit mapping moves the group's
+// commits to another cluster
+type GroupSettings struct {
+	Blacklisted   bool              `json:"blacklisted"`
+	Ephemeral     bool              `json:"ephemeral"`
+	ExpireSeconds int64             `json:"expire_seconds"`
+	Rollup        string            `json:"rollup"`
+	ErrorPercent  int               `json:"error_percent,omitempty"`
+	Tags          map[string]string `json:"tags"`
+	DataCluster   string            `json:"data_cluster,omitempty"`
+}
+
+// Resolve the settings for a group. These only come from the config, which doesn't change once storage is started, so
+// this doesn't go through the request channel. Returns nil if the cluster is not known
+func (storage *OffsetStorage) GroupSettings(cluster string, group string) *GroupSettings {
+	clusterMap, ok := storage.offsets[cluster]
+	if !ok {
+		return nil
+	}
+
+	settings := &GroupSettings{
+		Blacklisted:   (storage.GroupBlacklist != nil) && storage.GroupBlacklist.MatchString(group),
+		Ephemeral:     storage.isEphemeral(group),
+		ExpireSeconds: storage.groupExpiry(group),
+		Rollup:        RollupWorst,
+		Tags:          storage.GroupTags(group),
+	}
+	if policy := storage.rollupPolicyFor(group); policy != nil {
+		settings.Rollup = policy.policy
+		if policy.policy == RollupPercent {
+			settings.ErrorPercent = policy.errorPercent
+		}
+	}
+	if settings.Tags == nil {
+		settings.Tags = make(map[string]string)
+	}
+	for _, mapping := range clusterMap.commitMapping {
+		if mapping.groups.MatchString(group) {
+			settings.DataCluster = mapping.dataCluster
+			break
+		}
+	}
+	return settings
+}
@@ -0,0 +1,56 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"errors"
+)
+
+// The rule parameters a group is evaluated with. Normally these come from the config, but a status request can give
+// its own to simulate what the status would be with other settings, without changing anything that is stored
+type EvaluationParams struct {
+	// How many of the most recent offsets to evaluate. This can't be more than the configured intervals, since that
+	// is all that is stored
+	Intervals int `json:"intervals"`
+
+	// Settings with the same meaning as in the config
+	RetentionRisk   int64  `json:"retention_risk"`
+	CompactedTopics string `json:"compacted_topics"`
+
+	// If set, these are used for every partition in place of the priority topic thresholds. Partitions over MaxLag are
+	// a warning, and partitions that have not committed for StopGrace seconds are stopped
+	MaxLag    int64 `json:"max_lag"`
+	StopGrace int64 `json:"stop_grace"`
+}
+
+// Return the parameters that groups are evaluated with from the config
+func (storage *OffsetStorage) DefaultEvaluationParams() *EvaluationParams {
+	return &EvaluationParams{
+		Intervals:       storage.config.Intervals,
+		RetentionRisk:   storage.config.RetentionRisk,
+		CompactedTopics: storage.config.CompactedTopics,
+	}
+}
+
+// Check that the parameters can be used to evaluate a group
+func (storage *OffsetStorage) ValidateEvaluationParams(params *EvaluationParams) error {
+	switch {
+	case (params.Intervals < 2) || (params.Intervals > storage.config.Intervals):
+		return errors.New("intervals must be at least 2 and no more than the configured intervals")
+	case params.RetentionRisk < 0:
+		return errors.New("retention_risk must not be negative")
+	case (params.CompactedTopics != "") && (params.CompactedTopics != "flag") && (params.CompactedTopics != "exclude"):
+		return errors.New("compacted_topics must be flag or exclude")
+	case (params.MaxLag < 0) || (params.StopGrace < 0):
+		return errors.New("max_lag and stop_grace must not be negative")
+	}
+	return nil
+}
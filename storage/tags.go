@@ -0,0 +1,68 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Tag names that can't be used, as they would clash with the labels Burrow already puts on a group
+var reservedTagNames = map[string]bool{"cluster": true, "group": true}
+
+// Compile a group tag rule, and check that it has at least one named capture group and that none of its names are
+// reserved
+func CompileGroupTagRule(rule string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	named := 0
+	for _, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if reservedTagNames[name] {
+			return nil, fmt.Errorf("tag name %s is reserved", name)
+		}
+		named++
+	}
+	if named == 0 {
+		return nil, fmt.Errorf("rule has no named capture groups, such as (?P<team>[a-z]+)")
+	}
+	return re, nil
+}
+
+// Return the tags for a group, from the named capture groups of every tag rule that matches the group name. If more
+// than one rule sets the same tag, the first one wins. Captures that match nothing are left out. Returns nil if the
+// group has no tags
+func (storage *OffsetStorage) GroupTags(group string) map[string]string {
+	var tags map[string]string
+	for _, rule := range storage.groupTagRules {
+		match := rule.FindStringSubmatch(group)
+		if match == nil {
+			continue
+		}
+		for i, name := range rule.SubexpNames() {
+			if (name == "") || (match[i] == "") {
+				continue
+			}
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			if _, ok := tags[name]; !ok {
+				tags[name] = match[i]
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,105 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"container/ring"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// The offsets of a group that was removed by request, kept for the tombstone retention so the removal can be undone
+type tombstone struct {
+	consumer map[string][]*ring.Ring
+	removed  int64
+}
+
+// Remove a group by request. Its offsets are moved to the cluster's tombstones, so it can be restored until the
+// tombstone retention runs out
+func (storage *OffsetStorage) dropGroup(cluster string, group string, resultChannel chan StatusConstant) {
+	clusterOffsets := storage.offsets[cluster]
+	clusterOffsets.consumerLock.Lock()
+	defer clusterOffsets.consumerLock.Unlock()
+
+	consumerMap, ok := clusterOffsets.consumer[group]
+	if !ok {
+		resultChannel <- StatusNotFound
+		return
+	}
+	log.Infof("Removing group %s from cluster %s by request", group, cluster)
+	delete(clusterOffsets.consumer, group)
+	delete(clusterOffsets.firstCommit, group)
+	if storage.config.TombstoneRetention > 0 {
+		clusterOffsets.tombstones[group] = &tombstone{
+			consumer: consumerMap,
+			removed:  time.Now().Unix() * 1000,
+		}
+	}
+	resultChannel <- StatusOK
+}
+
+// Put back the offsets of a group that was removed by request. If the group has committed offsets since it was
+// removed, the restored offsets replace those for the same partitions (the commits in between are lost, but the
+// evaluation window from before the removal is kept)
+func (storage *OffsetStorage) restoreGroup(cluster string, group string, resultChannel chan StatusConstant) {
+	clusterOffsets := storage.offsets[cluster]
+	clusterOffsets.consumerLock.Lock()
+	defer clusterOffsets.consumerLock.Unlock()
+	storage.expireTombstones(clusterOffsets)
+
+	removed, ok := clusterOffsets.tombstones[group]
+	if !ok {
+		resultChannel <- StatusNotFound
+		return
+	}
+	log.Infof("Restoring group %s in cluster %s by request", group, cluster)
+	delete(clusterOffsets.tombstones, group)
+
+	consumerMap, ok := clusterOffsets.consumer[group]
+	if !ok {
+		clusterOffsets.consumer[group] = removed.consumer
+		resultChannel <- StatusOK
+		return
+	}
+	for topic, partitions := range removed.consumer {
+		if len(consumerMap[topic]) < len(partitions) {
+			// Grow the partition list as addConsumerOffset would
+			grown := make([]*ring.Ring, len(partitions))
+			copy(grown, consumerMap[topic])
+			consumerMap[topic] = grown
+		}
+		for partition, partitionRing := range partitions {
+			if partitionRing != nil {
+				consumerMap[topic][partition] = partitionRing
+			}
+		}
+	}
+	resultChannel <- StatusOK
+}
+
+// Forget the tombstones that are older than the retention in every cluster. This runs on the archive ticker
+func (storage *OffsetStorage) pruneTombstones() {
+	for _, clusterOffsets := range storage.offsets {
+		clusterOffsets.consumerLock.Lock()
+		storage.expireTombstones(clusterOffsets)
+		clusterOffsets.consumerLock.Unlock()
+	}
+}
+
+// Forget the tombstones that are older than the retention. Must be called with the consumer lock held
+func (storage *OffsetStorage) expireTombstones(clusterOffsets *ClusterOffsets) {
+	cutoff := time.Now().Unix()*1000 - storage.config.TombstoneRetention*1000
+	for group, removed := range clusterOffsets.tombstones {
+		if removed.removed < cutoff {
+			delete(clusterOffsets.tombstones, group)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package storage
+
+import (
+	"time"
+)
+
+// How far a cluster is through warming up after a start. Group evaluations are not Complete until there are broker
+// offsets and a full window of commits for every partition, so a new instance can't be trusted right away
+type WarmupStatus struct {
+	Cluster       string `json:"cluster"`
+	BrokerOffsets bool   `json:"broker_offsets"`
+
+	// Groups that have a full window of offsets for all partitions, and groups that are committing but don't yet.
+	// Groups that have only committed once since the start (or have stopped committing) are idle, and are not waited
+	// for, since there is no way to know when they will fill their window
+	Groups         int `json:"groups"`
+	CompleteGroups int `json:"complete_groups"`
+	WarmingGroups  int `json:"warming_groups"`
+	IdleGroups     int `json:"idle_groups"`
+
+	// The estimated number of seconds until all of the warming groups are complete, based on how often they commit
+	EstimatedComplete int64 `json:"estimated_complete"`
+	Warmed            bool  `json:"warmed"`
+}
+
+type RequestWarmupStatus struct {
+	Result  chan *WarmupStatus
+	Cluster string
+}
+
+func (storage *OffsetStorage) requestWarmupStatus(request *RequestWarmupStatus) {
+	clusterMap, ok := storage.offsets[request.Cluster]
+	if !ok {
+		request.Result <- nil
+		return
+	}
+	status := &WarmupStatus{Cluster: request.Cluster}
+
+	clusterMap.brokerLock.RLock()
+	status.BrokerOffsets = len(clusterMap.broker) > 0
+	clusterMap.brokerLock.RUnlock()
+
+	now := time.Now().Unix() * 1000
+	clusterMap.consumerLock.RLock()
+	for _, topics := range clusterMap.consumer {
+		status.Groups++
+
+		var groupRemaining int64
+		complete, idle := true, false
+		for _, partitions := range topics {
+			for _, offsetRing := range partitions {
+				if (offsetRing != nil) && (offsetRing.Value != nil) {
+					// The ring is full
+					continue
+				}
+				complete = false
+
+				// Look at the commits we have so far to estimate how long the rest will take
+				var count int
+				var first, last int64
+				if offsetRing != nil {
+					offsetRing.Do(func(val interface{}) {
+						if offset, ok := val.(*ConsumerOffset); ok {
+							if count == 0 {
+								first = offset.Timestamp
+							}
+							last = offset.Timestamp
+							count++
+						}
+					})
+				}
+				if count < 2 {
+					idle = true
+					continue
+				}
+				interval := (last - first) / int64(count-1)
+				if now-last > 2*interval {
+					idle = true
+					continue
+				}
+				remaining := (int64(storage.config.Intervals-count)*interval - (now - last)) / 1000
+				if remaining > groupRemaining {
+					groupRemaining = remaining
+				}
+			}
+		}
+
+		switch {
+		case complete:
+			status.CompleteGroups++
+		case idle && (groupRemaining == 0):
+			status.IdleGroups++
+		default:
+			status.WarmingGroups++
+			if groupRemaining > status.EstimatedComplete {
+				status.EstimatedComplete = groupRemaining
+			}
+		}
+	}
+	clusterMap.consumerLock.RUnlock()
+
+	status.Warmed = status.BrokerOffsets && (status.WarmingGroups == 0)
+	request.Result <- status
+}
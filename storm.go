@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"errors"
 	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
 	"github.com/samuel/go-zookeeper/zk"
 	"math/rand"
 	"regexp"
@@ -29,6 +30,23 @@ type StormClient struct {
 	stormRefreshTicker *time.Ticker
 	stormGroupList     map[string]bool
 	stormGroupLock     sync.RWMutex
+	offsetChannel      chan *storage.PartitionOffset
+	groupActivity      activityTimer
+}
+
+func init() {
+	RegisterOffsetSource("storm", &OffsetSourceModule{
+		Clusters: func(config *BurrowConfig) []string {
+			clusters := make([]string, 0, len(config.Storm))
+			for cluster := range config.Storm {
+				clusters = append(clusters, cluster)
+			}
+			return clusters
+		},
+		New: func(app *ApplicationContext, cluster string) (OffsetSource, error) {
+			return NewStormClient(app, cluster)
+		},
+	})
 }
 
 type Topology struct {
@@ -51,7 +69,7 @@ type SpoutState struct {
 
 func NewStormClient(app *ApplicationContext, cluster string) (*StormClient, error) {
 	// here we share the timeout w/ global zk
-	zkconn, _, err := zk.Connect(app.Config.Storm[cluster].Zookeepers, time.Duration(app.Config.Zookeeper.Timeout)*time.Second)
+	zkconn, err := connectZookeeper(app.Config.Storm[cluster].Zookeepers, time.Duration(app.Config.Zookeeper.Timeout)*time.Second, zookeeperDialer)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +82,13 @@ func NewStormClient(app *ApplicationContext, cluster string) (*StormClient, erro
 		stormGroupList: make(map[string]bool),
 	}
 
+	return client, nil
+}
+
+func (client *StormClient) Start(offsets chan *storage.PartitionOffset) error {
+	client.offsetChannel = offsets
+	client.groupActivity.touch()
+
 	// Now get the first set of offsets and start a goroutine to continually check them
 	client.refreshConsumerGroups()
 	client.stormRefreshTicker = time.NewTicker(time.Duration(client.app.Config.Lagcheck.StormGroupRefresh) * time.Second)
@@ -73,7 +98,7 @@ func NewStormClient(app *ApplicationContext, cluster string) (*StormClient, erro
 		}
 	}()
 
-	return client, nil
+	return nil
 }
 
 func (stormClient *StormClient) Stop() {
@@ -88,6 +113,14 @@ func (stormClient *StormClient) Stop() {
 	stormClient.conn.Close()
 }
 
+func (stormClient *StormClient) Hosts() []string {
+	return stormClient.app.Config.Storm[stormClient.cluster].Zookeepers
+}
+
+func (stormClient *StormClient) Activity() map[string]time.Time {
+	return map[string]time.Time{"consumer group list": stormClient.groupActivity.time()}
+}
+
 func parsePartitionId(partitionStr string) (int, error) {
 	re := regexp.MustCompile(`^partition_([0-9]+)$`)
 	if parsed := re.FindStringSubmatch(partitionStr); len(parsed) == 2 {
@@ -144,22 +177,23 @@ func (stormClient *StormClient) getOffsetsForPartition(consumerGroup string, par
 	switch {
 	case err == nil:
 		offset, topic, errConversion := parseStormSpoutStateJson(string(stateStr))
-		if (stormClient.app.Storage.topicBlacklist != nil) && stormClient.app.Storage.topicBlacklist.MatchString(topic) {
+		if (stormClient.app.Storage.TopicBlacklist != nil) && stormClient.app.Storage.TopicBlacklist.MatchString(topic) {
 			log.Debugf("Skip checking Storn offsets for topic %s from group %s in cluster %s as topic has been blacklisted", topic, consumerGroup, stormClient.cluster)
 			return
 		}
 		switch {
 		case errConversion == nil:
 			log.Debugf("About to sync Storm offset: [%s,%s,%v]::[%v,%v]\n", consumerGroup, topic, partition, offset, zkNodeStat.Mtime)
-			partitionOffset := &PartitionOffset{
+			partitionOffset := &storage.PartitionOffset{
 				Cluster:   stormClient.cluster,
 				Topic:     topic,
 				Partition: int32(partition),
 				Group:     consumerGroup,
 				Timestamp: int64(zkNodeStat.Mtime), // note: this is millis
 				Offset:    int64(offset),
+				Source:    storage.OffsetSourceStorm,
 			}
-			timeoutSendOffset(stormClient.app.Storage.offsetChannel, partitionOffset, 1)
+			timeoutSendOffset(stormClient.offsetChannel, partitionOffset, 1)
 		default:
 			log.Errorf("Something is very wrong! Cannot parse state json for partition %v of consumer group %s in ZK path %s: %s. Error: %v",
 				partition, consumerGroup, partitionPath, stateStr, errConversion)
@@ -179,6 +213,7 @@ func (stormClient *StormClient) refreshConsumerGroups() {
 		log.Errorf("Cannot get Storm Kafka consumer group list for cluster %s: %s", stormClient.cluster, err)
 		return
 	}
+	stormClient.groupActivity.touch()
 
 	// Mark all existing groups false
 	for consumerGroup := range stormClient.stormGroupList {
@@ -188,7 +223,7 @@ func (stormClient *StormClient) refreshConsumerGroups() {
 	// Check for new groups, mark existing groups true
 	for _, consumerGroup := range consumerGroups {
 		// Don't bother adding groups in the blacklist
-		if (stormClient.app.Storage.groupBlacklist != nil) && stormClient.app.Storage.groupBlacklist.MatchString(consumerGroup) {
+		if (stormClient.app.Storage.GroupBlacklist != nil) && stormClient.app.Storage.GroupBlacklist.MatchString(consumerGroup) {
 			continue
 		}
 
@@ -0,0 +1,263 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The scenarios a test cluster group can follow
+const (
+	ScenarioSteady    = "steady"
+	ScenarioStalling  = "stalling"
+	ScenarioRewinding = "rewinding"
+	ScenarioBursty    = "bursty"
+)
+
+var testScenarios = map[string]bool{ScenarioSteady: true, ScenarioStalling: true, ScenarioRewinding: true, ScenarioBursty: true}
+
+// How many commits a stalling group makes before it stops moving, and how often rewinding and bursty groups rewind or
+// catch up
+const (
+	stallAfterCommits = 6
+	rewindEveryCommit = 10
+	burstEveryCommit  = 6
+)
+
+// A test cluster doesn't connect to anything. It makes up broker offsets for its topics, which are produced to at a
+// steady rate, and consumer offset commits for its groups according to each group's scenario:
+//
+//	steady    - keeps up with the broker, with a little lag (OK)
+//	stalling  - keeps committing, but stops moving after a few commits (STALL)
+//	rewinding - keeps up, but periodically jumps back (REWIND)
+//	bursty    - consumes at half the produce rate and periodically catches up, so lag rises and falls
+//
+// This is for exercising notifiers and dashboards in staging without a real Kafka cluster
+type TestClusterClient struct {
+	app           *ApplicationContext
+	cluster       string
+	topics        []string
+	heads         map[string][]int64
+	groups        []*testGroup
+	offsetChannel chan *storage.PartitionOffset
+	activity      activityTimer
+	quit          chan struct{}
+	wg            sync.WaitGroup
+}
+
+type testGroup struct {
+	name     string
+	scenario string
+	offsets  map[string][]int64
+	commits  int
+}
+
+func init() {
+	RegisterOffsetSource("test", &OffsetSourceModule{
+		Clusters: testClusterNames,
+		New: func(app *ApplicationContext, cluster string) (OffsetSource, error) {
+			return NewTestClusterClient(app, cluster), nil
+		},
+	})
+}
+
+func testClusterNames(config *BurrowConfig) []string {
+	clusters := make([]string, 0)
+	for cluster, cfg := range config.Kafka {
+		if cfg.Type == "test" {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// Check the settings for a test cluster, and set the defaults. Returns a list of errors
+func validateTestCluster(app *ApplicationContext, cluster string) []string {
+	cfg := app.Config.Kafka[cluster]
+	errs := make([]string, 0)
+
+	if len(cfg.TestTopics) == 0 {
+		errs = append(errs, fmt.Sprintf("No test topics specified for test cluster %s", cluster))
+	}
+	for _, topic := range cfg.TestTopics {
+		if !validateTopic(topic) {
+			errs = append(errs, fmt.Sprintf("Test topic %s is not valid for test cluster %s", topic, cluster))
+		}
+	}
+	if cfg.TestPartitions == 0 {
+		cfg.TestPartitions = 4
+	}
+	if cfg.TestProduceRate == 0 {
+		cfg.TestProduceRate = 100
+	}
+	if cfg.TestCommitInterval == 0 {
+		cfg.TestCommitInterval = 10
+	}
+	if (cfg.TestPartitions < 0) || (cfg.TestProduceRate < 0) || (cfg.TestCommitInterval < 0) {
+		errs = append(errs, fmt.Sprintf("Test partitions, produce rate, and commit interval must be positive for test cluster %s", cluster))
+	}
+	if cfg.TestCommitInterval < app.Config.Lagcheck.MinDistance {
+		errs = append(errs, fmt.Sprintf("Test commit interval must be at least the lagcheck min-distance for test cluster %s", cluster))
+	}
+
+	if len(cfg.TestGroups) == 0 {
+		errs = append(errs, fmt.Sprintf("No test groups specified for test cluster %s", cluster))
+	}
+	for _, groupSpec := range cfg.TestGroups {
+		parts := strings.SplitN(groupSpec, ":", 2)
+		if (len(parts) != 2) || (parts[0] == "") || (!testScenarios[parts[1]]) {
+			errs = append(errs, fmt.Sprintf("Test group %s must be group:scenario (steady, stalling, rewinding, or bursty) for test cluster %s", groupSpec, cluster))
+		}
+	}
+	return errs
+}
+
+func NewTestClusterClient(app *ApplicationContext, cluster string) *TestClusterClient {
+	cfg := app.Config.Kafka[cluster]
+	client := &TestClusterClient{
+		app:     app,
+		cluster: cluster,
+		topics:  cfg.TestTopics,
+		heads:   make(map[string][]int64, len(cfg.TestTopics)),
+		groups:  make([]*testGroup, 0, len(cfg.TestGroups)),
+		quit:    make(chan struct{}),
+	}
+
+	// Start the topics with a full window of messages, so groups have somewhere to start from
+	start := cfg.TestProduceRate * cfg.TestCommitInterval * int64(app.Config.Lagcheck.Intervals)
+	for _, topic := range client.topics {
+		client.heads[topic] = make([]int64, cfg.TestPartitions)
+		for partition := range client.heads[topic] {
+			client.heads[topic][partition] = start
+		}
+	}
+
+	// The groups were checked when the config was validated
+	for _, groupSpec := range cfg.TestGroups {
+		parts := strings.SplitN(groupSpec, ":", 2)
+		group := &testGroup{name: parts[0], scenario: parts[1], offsets: make(map[string][]int64, len(client.topics))}
+		for _, topic := range client.topics {
+			group.offsets[topic] = make([]int64, cfg.TestPartitions)
+		}
+		client.groups = append(client.groups, group)
+	}
+	return client
+}
+
+func (client *TestClusterClient) Start(offsets chan *storage.PartitionOffset) error {
+	client.offsetChannel = offsets
+	client.activity.touch()
+
+	client.wg.Add(1)
+	go func() {
+		defer client.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(client.app.Config.Kafka[client.cluster].TestCommitInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			client.tick()
+			select {
+			case <-client.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func (client *TestClusterClient) Stop() {
+	close(client.quit)
+	client.wg.Wait()
+}
+
+func (client *TestClusterClient) Activity() map[string]time.Time {
+	return map[string]time.Time{"test offsets": client.activity.time()}
+}
+
+// Produce to every partition, send the new broker offsets, then have each group commit according to its scenario
+func (client *TestClusterClient) tick() {
+	cfg := client.app.Config.Kafka[client.cluster]
+	produced := cfg.TestProduceRate * cfg.TestCommitInterval
+	ts := time.Now().Unix() * 1000
+
+	for _, topic := range client.topics {
+		for partition := range client.heads[topic] {
+			client.heads[topic][partition] += produced
+			timeoutSendOffset(client.offsetChannel, &storage.PartitionOffset{
+				Cluster:             client.cluster,
+				Topic:               topic,
+				Partition:           int32(partition),
+				Offset:              client.heads[topic][partition],
+				OldestOffset:        0,
+				StableOffset:        -1,
+				Timestamp:           ts,
+				TopicPartitionCount: len(client.heads[topic]),
+			}, 1)
+		}
+	}
+
+	for _, group := range client.groups {
+		group.commits++
+		for _, topic := range client.topics {
+			for partition, head := range client.heads[topic] {
+				offset := group.nextOffset(group.offsets[topic][partition], head, produced)
+				group.offsets[topic][partition] = offset
+				timeoutSendOffset(client.offsetChannel, &storage.PartitionOffset{
+					Cluster:   client.cluster,
+					Topic:     topic,
+					Partition: int32(partition),
+					Group:     group.name,
+					Timestamp: ts,
+					Offset:    offset,
+					Source:    storage.OffsetSourceTest,
+				}, 1)
+			}
+		}
+	}
+
+	client.activity.touch()
+	log.Tracef("Sent test offsets for cluster %s", client.cluster)
+}
+
+// Work out the group's next commit for a partition from its last commit and the partition's head offset
+func (group *testGroup) nextOffset(last int64, head int64, produced int64) int64 {
+	caughtUp := head - (produced / 10)
+	if group.commits == 1 {
+		// Every group starts out close to the head
+		return caughtUp
+	}
+
+	switch group.scenario {
+	case ScenarioStalling:
+		if group.commits > stallAfterCommits {
+			return last
+		}
+		return caughtUp
+	case ScenarioRewinding:
+		if group.commits%rewindEveryCommit == 0 {
+			return last - 5*produced
+		}
+		return caughtUp
+	case ScenarioBursty:
+		if group.commits%burstEveryCommit == 0 {
+			return caughtUp
+		}
+		return last + produced/2
+	default:
+		return caughtUp
+	}
+}
@@ -0,0 +1,148 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"time"
+)
+
+// The precisions that offset timestamps can be configured with. With auto, the precision of each timestamp is guessed
+// from its size, which works for any time after 1973 (in milliseconds) and before 5138 (in seconds)
+const (
+	TimestampAuto         = "auto"
+	TimestampSeconds      = "seconds"
+	TimestampMilliseconds = "milliseconds"
+	TimestampMicroseconds = "microseconds"
+	TimestampNanoseconds  = "nanoseconds"
+)
+
+// Timestamps before this many milliseconds (March 1973) can only have been given in the wrong precision
+const minTimestamp int64 = 100000000000
+
+// The reasons that a timestamp is rejected, used as the reason label of burrow_rejected_timestamps_total
+const (
+	rejectNotPositive = "not_positive"
+	rejectTooOld      = "too_old"
+	rejectFuture      = "future"
+)
+
+var timestampScales = map[string]func(int64) int64{
+	TimestampSeconds:      func(ts int64) int64 { return ts * 1000 },
+	TimestampMilliseconds: func(ts int64) int64 { return ts },
+	TimestampMicroseconds: func(ts int64) int64 { return ts / 1000 },
+	TimestampNanoseconds:  func(ts int64) int64 { return ts / 1000000 },
+}
+
+// Return the precision of a timestamp, going by its size
+func guessPrecision(timestamp int64) string {
+	switch {
+	case timestamp < 100000000000:
+		return TimestampSeconds
+	case timestamp < 100000000000000:
+		return TimestampMilliseconds
+	case timestamp < 100000000000000000:
+		return TimestampMicroseconds
+	default:
+		return TimestampNanoseconds
+	}
+}
+
+// Convert a timestamp in the precision (or auto) to milliseconds, which is what storage expects. If the timestamp
+// cannot be right, the reason is returned instead. The precision it was converted from is returned either way
+func normalizeTimestamp(timestamp int64, precision string, maxFuture int64, now int64) (int64, string, string) {
+	if precision == TimestampAuto {
+		precision = guessPrecision(timestamp)
+	}
+	if timestamp <= 0 {
+		return 0, precision, rejectNotPositive
+	}
+	normalized := timestampScales[precision](timestamp)
+	if normalized < minTimestamp {
+		return 0, precision, rejectTooOld
+	}
+	if (maxFuture > 0) && (normalized > now+(maxFuture*1000)) {
+		return 0, precision, rejectFuture
+	}
+	return normalized, precision, ""
+}
+
+// Sits between an offset source and the storage channel for its cluster, converting each offset's timestamp to
+// milliseconds and dropping offsets whose timestamps are nonsensical
+type timestampNormalizer struct {
+	app       *ApplicationContext
+	source    string
+	cluster   string
+	precision string
+	maxFuture int64
+	offsets   chan *storage.PartitionOffset
+	converted map[string]bool
+}
+
+// Return the relay channel for offsets from the source for the cluster, starting it the first time it's needed. The
+// relay lasts as long as Burrow does, so the same channel is given to the source each time it is restarted
+func (sources *OffsetSources) timestampChannel(name string, cluster string) chan *storage.PartitionOffset {
+	sources.lock.Lock()
+	defer sources.lock.Unlock()
+
+	if _, ok := sources.normalizers[name]; !ok {
+		sources.normalizers[name] = make(map[string]*timestampNormalizer)
+	}
+	if normalizer, ok := sources.normalizers[name][cluster]; ok {
+		return normalizer.offsets
+	}
+
+	normalizer := &timestampNormalizer{
+		app:       sources.app,
+		source:    name,
+		cluster:   cluster,
+		precision: TimestampAuto,
+		maxFuture: 86400,
+		offsets:   make(chan *storage.PartitionOffset, 1000),
+		converted: make(map[string]bool),
+	}
+	if cfg, ok := sources.app.Config.Timestamps[name]; ok {
+		normalizer.precision = cfg.Precision
+		normalizer.maxFuture = cfg.MaxFuture
+	}
+	sources.normalizers[name][cluster] = normalizer
+	go normalizer.run(sources.app.Storage.ClusterOffsetChannel(cluster))
+	return normalizer.offsets
+}
+
+func (normalizer *timestampNormalizer) run(out chan *storage.PartitionOffset) {
+	for offset := range normalizer.offsets {
+		if normalizer.normalize(offset) {
+			out <- offset
+		}
+	}
+}
+
+// Fix the timestamp of the offset in place, returning false if the offset should be dropped
+func (normalizer *timestampNormalizer) normalize(offset *storage.PartitionOffset) bool {
+	timestamp, precision, reason := normalizeTimestamp(offset.Timestamp, normalizer.precision, normalizer.maxFuture,
+		time.Now().UnixNano()/int64(time.Millisecond))
+	if reason != "" {
+		log.Debugf("Dropped offset from %s source for %s:%s:%v with timestamp %v: %s", normalizer.source,
+			offset.Cluster, offset.Topic, offset.Partition, offset.Timestamp, reason)
+		normalizer.app.Metrics.Add("burrow_rejected_timestamps_total",
+			map[string]string{"cluster": normalizer.cluster, "source": normalizer.source, "reason": reason}, 1)
+		return false
+	}
+	if (precision != TimestampMilliseconds) && !normalizer.converted[precision] {
+		normalizer.converted[precision] = true
+		log.Infof("Converting timestamps in %s from %s source for cluster %s to milliseconds", precision,
+			normalizer.source, normalizer.cluster)
+	}
+	offset.Timestamp = timestamp
+	return true
+}
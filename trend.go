@@ -0,0 +1,471 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often the trend reporter checks whether a report is due, and saves what it has collected
+const trendCheckInterval = time.Minute
+const trendSaveInterval = 10 * time.Minute
+
+// What the trend reporter has collected since the last report. This is saved to the state file, so it survives restarts
+type trendState struct {
+	WindowStart int64                    `json:"window_start"`
+	LastReport  int64                    `json:"last_report"`
+	Clusters    map[string]*clusterTrend `json:"clusters"`
+}
+
+// The groups a cluster had at the start of the window (nil until they are listed), and what each group did since
+type clusterTrend struct {
+	StartGroups []string               `json:"start_groups"`
+	Groups      map[string]*groupTrend `json:"groups"`
+}
+
+// The most lag a group had, and how long (in milliseconds) it was in ERR, including since ErrSince if it is in ERR now
+type groupTrend struct {
+	MaxLag   uint64 `json:"max_lag"`
+	ErrSince int64  `json:"err_since,omitempty"`
+	ErrTime  int64  `json:"err_time"`
+}
+
+// A summary of the clusters over the window from Start to End (in milliseconds)
+type TrendReport struct {
+	Start    int64                 `json:"start"`
+	End      int64                 `json:"end"`
+	Clusters []*ClusterTrendReport `json:"clusters"`
+}
+
+type ClusterTrendReport struct {
+	Cluster    string           `json:"cluster"`
+	Groups     int              `json:"groups"`
+	Added      []string         `json:"added"`
+	Removed    []string         `json:"removed"`
+	ErrMinutes int64            `json:"err_minutes"`
+	TopLag     []*GroupLagTrend `json:"top_lag"`
+}
+
+type GroupLagTrend struct {
+	Group      string `json:"group"`
+	MaxLag     uint64 `json:"max_lag"`
+	ErrMinutes int64  `json:"err_minutes"`
+}
+
+// The trend reporter collects the most lag and the time in ERR of each group from its evaluations, and on the
+// [trend-report] schedule sends a summary of each cluster since the last one: the groups that were added and removed,
+// the groups that lagged the most, and the minutes in ERR. The report is emailed, POSTed as JSON, and posted to Slack,
+// as configured, and a new window is started
+type TrendReporter struct {
+	app      *ApplicationContext
+	client   *http.Client
+	schedule *storage.CronSchedule
+	location *time.Location
+	lock     sync.Mutex
+	state    *trendState
+	lastSave time.Time
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// The trend report is only collected if it is sent somewhere
+func trendReportConfigured(cfg *BurrowConfig) bool {
+	return (len(cfg.TrendReport.Email) > 0) || (cfg.TrendReport.Url != "") || (cfg.TrendReport.SlackUrl != "")
+}
+
+// Create the trend reporter, loading what was collected before a restart from the state file. The config must have
+// been validated
+func NewTrendReporter(app *ApplicationContext) (*TrendReporter, error) {
+	cfg := app.Config.TrendReport
+	reporter := &TrendReporter{
+		app: app,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			DialContext:     newDialer(10*time.Second, 30*time.Second).DialContext,
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: newTLSConfig(),
+		}},
+		location: displayLocation(app.Config),
+		quit:     make(chan struct{}),
+	}
+	reporter.schedule, _ = storage.ParseCronSchedule(cfg.Schedule)
+	if cfg.Timezone != "" {
+		reporter.location, _ = time.LoadLocation(cfg.Timezone)
+	}
+
+	state := &trendState{}
+	data, err := ioutil.ReadFile(cfg.StateFile)
+	switch {
+	case os.IsNotExist(err):
+		// Start the first window now, and don't send a report until the next scheduled time
+		now := time.Now()
+		state.WindowStart = now.UnixNano() / int64(time.Millisecond)
+		state.LastReport = reporter.schedule.Prev(now.In(reporter.location)).UnixNano() / int64(time.Millisecond)
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %v", cfg.StateFile, err)
+		}
+	}
+	if state.Clusters == nil {
+		state.Clusters = make(map[string]*clusterTrend)
+	}
+	reporter.state = state
+	return reporter, nil
+}
+
+// A storage status hook that tracks the most lag and the time in ERR of each group
+func (reporter *TrendReporter) Record(status *storage.ConsumerGroupStatus) {
+	reporter.record(status, time.Now().UnixNano()/int64(time.Millisecond))
+}
+
+func (reporter *TrendReporter) record(status *storage.ConsumerGroupStatus, now int64) {
+	if (status.PausedAt > 0) || (status.Status == storage.StatusWarming) || (status.Status == storage.StatusNotFound) {
+		return
+	}
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+
+	group := reporter.state.cluster(status.Cluster).group(status.Group)
+	if status.TotalLag > group.MaxLag {
+		group.MaxLag = status.TotalLag
+	}
+	if status.Status == storage.StatusError {
+		if group.ErrSince == 0 {
+			group.ErrSince = now
+		}
+	} else if group.ErrSince > 0 {
+		group.ErrTime += now - group.ErrSince
+		group.ErrSince = 0
+	}
+}
+
+// Must be called with the lock held
+func (state *trendState) cluster(cluster string) *clusterTrend {
+	if _, ok := state.Clusters[cluster]; !ok {
+		state.Clusters[cluster] = &clusterTrend{Groups: make(map[string]*groupTrend)}
+	}
+	return state.Clusters[cluster]
+}
+
+func (trend *clusterTrend) group(group string) *groupTrend {
+	if trend.Groups == nil {
+		trend.Groups = make(map[string]*groupTrend)
+	}
+	if _, ok := trend.Groups[group]; !ok {
+		trend.Groups[group] = &groupTrend{}
+	}
+	return trend.Groups[group]
+}
+
+func (reporter *TrendReporter) Start() {
+	reporter.wg.Add(1)
+	go func() {
+		defer reporter.wg.Done()
+
+		ticker := time.NewTicker(trendCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-reporter.quit:
+				return
+			case now := <-ticker.C:
+				reporter.check(now)
+			}
+		}
+	}()
+}
+
+// Stop checking for reports, and save what has been collected
+func (reporter *TrendReporter) Stop() {
+	close(reporter.quit)
+	reporter.wg.Wait()
+	if err := reporter.Flush(); err != nil {
+		log.Errorf("Cannot save trend report state: %v", err)
+	}
+}
+
+// Save what has been collected to the state file. It is written to a temporary file first, so a crash while writing
+// leaves the last one in place
+func (reporter *TrendReporter) Flush() error {
+	reporter.lock.Lock()
+	data, err := json.Marshal(reporter.state)
+	reporter.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	filename := reporter.app.Config.TrendReport.StateFile
+	if err := ioutil.WriteFile(filename+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// List the groups of clusters that haven't been listed yet in this window, send the report if one is due, and save
+// the state now and then. Only the instance holding the notifier lock sends the report, but every instance starts a
+// new window on schedule, so that a new leader doesn't send a report of more than one window
+func (reporter *TrendReporter) check(now time.Time) {
+	groups, ok := reporter.listGroups()
+	if !ok {
+		log.Warnf("Cannot check for a trend report: %s", storageBusyReason)
+		return
+	}
+
+	reporter.lock.Lock()
+	for cluster, clusterGroups := range groups {
+		if trend := reporter.state.cluster(cluster); trend.StartGroups == nil {
+			trend.StartGroups = clusterGroups
+		}
+	}
+	due := reporter.schedule.Prev(now.In(reporter.location))
+	var report *TrendReport
+	if !due.IsZero() && (due.UnixNano()/int64(time.Millisecond) > reporter.state.LastReport) {
+		report = reporter.report(groups, now.UnixNano()/int64(time.Millisecond), true)
+	}
+	reporter.lock.Unlock()
+
+	if (report != nil) && (atomic.LoadInt32(&reporter.app.notifierLeader) == 1) {
+		log.Infof("Sending trend report for %s to %s", time.Unix(0, report.Start*int64(time.Millisecond)).In(reporter.location).Format("2006-01-02 15:04"),
+			time.Unix(0, report.End*int64(time.Millisecond)).In(reporter.location).Format("2006-01-02 15:04"))
+		reporter.send(report)
+	}
+	if (report != nil) || (now.Sub(reporter.lastSave) >= trendSaveInterval) {
+		reporter.lastSave = now
+		if err := reporter.Flush(); err != nil {
+			log.Errorf("Cannot save trend report state: %v", err)
+		}
+	}
+}
+
+// Return the groups in each Kafka cluster, or false if storage is too busy to answer
+func (reporter *TrendReporter) listGroups() (map[string][]string, bool) {
+	groups := make(map[string][]string)
+	for _, cluster := range kafkaClusterNames(reporter.app.Config) {
+		request := &storage.RequestConsumerList{Result: make(chan []string), Cluster: cluster}
+		if !sendStorageRequest(reporter.app, request) {
+			return nil, false
+		}
+		groups[cluster] = <-request.Result
+	}
+	return groups, true
+}
+
+// Return the report so far, for /v2/burrow/trend-report
+func (reporter *TrendReporter) Preview() (*TrendReport, bool) {
+	groups, ok := reporter.listGroups()
+	if !ok {
+		return nil, false
+	}
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+	return reporter.report(groups, time.Now().UnixNano()/int64(time.Millisecond), false), true
+}
+
+// Build the report of the window up to now from the current groups of each cluster. With reset, a new window is
+// started: the current groups are the ones the next report is compared to, and what was collected is cleared. Must
+// be called with the lock held
+func (reporter *TrendReporter) report(groups map[string][]string, now int64, reset bool) *TrendReport {
+	report := &TrendReport{
+		Start:    reporter.state.WindowStart,
+		End:      now,
+		Clusters: make([]*ClusterTrendReport, 0, len(groups)),
+	}
+	for _, cluster := range kafkaClusterNames(reporter.app.Config) {
+		trend := reporter.state.cluster(cluster)
+		clusterReport := &ClusterTrendReport{
+			Cluster: cluster,
+			Groups:  len(groups[cluster]),
+			Added:   groupsNotIn(groups[cluster], trend.StartGroups),
+			Removed: groupsNotIn(trend.StartGroups, groups[cluster]),
+			TopLag:  make([]*GroupLagTrend, 0),
+		}
+
+		lagging := make([]*GroupLagTrend, 0, len(trend.Groups))
+		for name, group := range trend.Groups {
+			errTime := group.ErrTime
+			if group.ErrSince > 0 {
+				errTime += now - group.ErrSince
+			}
+			entry := &GroupLagTrend{Group: name, MaxLag: group.MaxLag, ErrMinutes: errTime / int64(time.Minute/time.Millisecond)}
+			clusterReport.ErrMinutes += entry.ErrMinutes
+			if (entry.MaxLag > 0) || (entry.ErrMinutes > 0) {
+				lagging = append(lagging, entry)
+			}
+		}
+		sort.Slice(lagging, func(i, j int) bool {
+			switch {
+			case lagging[i].MaxLag != lagging[j].MaxLag:
+				return lagging[i].MaxLag > lagging[j].MaxLag
+			case lagging[i].ErrMinutes != lagging[j].ErrMinutes:
+				return lagging[i].ErrMinutes > lagging[j].ErrMinutes
+			default:
+				return lagging[i].Group < lagging[j].Group
+			}
+		})
+		if len(lagging) > reporter.app.Config.TrendReport.Top {
+			lagging = lagging[:reporter.app.Config.TrendReport.Top]
+		}
+		clusterReport.TopLag = append(clusterReport.TopLag, lagging...)
+		report.Clusters = append(report.Clusters, clusterReport)
+
+		if reset {
+			trend.StartGroups = groups[cluster]
+			for name, group := range trend.Groups {
+				if group.ErrSince > 0 {
+					trend.Groups[name] = &groupTrend{ErrSince: now}
+				} else {
+					delete(trend.Groups, name)
+				}
+			}
+		}
+	}
+	if reset {
+		reporter.state.WindowStart = now
+		reporter.state.LastReport = now
+	}
+	return report
+}
+
+// Return the groups in the first list that aren't in the second, sorted
+func groupsNotIn(groups []string, others []string) []string {
+	in := make(map[string]bool, len(others))
+	for _, group := range others {
+		in[group] = true
+	}
+	result := make([]string, 0)
+	for _, group := range groups {
+		if !in[group] {
+			result = append(result, group)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Write the report as plain text, for email and Slack
+func (reporter *TrendReporter) text(report *TrendReport) string {
+	var text strings.Builder
+	format := "Mon Jan 2 15:04 MST"
+	fmt.Fprintf(&text, "Burrow trend report from %s to %s\n",
+		time.Unix(0, report.Start*int64(time.Millisecond)).In(reporter.location).Format(format),
+		time.Unix(0, report.End*int64(time.Millisecond)).In(reporter.location).Format(format))
+	for _, cluster := range report.Clusters {
+		fmt.Fprintf(&text, "\nCluster %s: %v groups, %v added, %v removed, %v minutes in ERR\n", cluster.Cluster,
+			cluster.Groups, len(cluster.Added), len(cluster.Removed), cluster.ErrMinutes)
+		if len(cluster.Added) > 0 {
+			fmt.Fprintf(&text, "  Added: %s\n", strings.Join(cluster.Added, ", "))
+		}
+		if len(cluster.Removed) > 0 {
+			fmt.Fprintf(&text, "  Removed: %s\n", strings.Join(cluster.Removed, ", "))
+		}
+		if len(cluster.TopLag) > 0 {
+			text.WriteString("  Most lag:\n")
+			for _, group := range cluster.TopLag {
+				fmt.Fprintf(&text, "    %s: %v messages, %v minutes in ERR\n", group.Group, group.MaxLag, group.ErrMinutes)
+			}
+		}
+	}
+	return text.String()
+}
+
+// Send the report to every destination that is configured. Failures are logged, as the report isn't sent again
+func (reporter *TrendReporter) send(report *TrendReport) {
+	cfg := reporter.app.Config.TrendReport
+	text := reporter.text(report)
+	if len(cfg.Email) > 0 {
+		auth := smtpAuth(reporter.app.Config)
+		for _, to := range cfg.Email {
+			msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Burrow trend report\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+				reporter.app.Config.Smtp.From, to, strings.Replace(text, "\n", "\r\n", -1))
+			if err := sendMail(reporter.app, auth, to, []byte(msg)); err != nil {
+				log.Errorf("Cannot email trend report to %s: %v", to, err)
+			}
+		}
+	}
+	if cfg.Url != "" {
+		reporter.post(cfg.Url, report)
+	}
+	if cfg.SlackUrl != "" {
+		reporter.post(cfg.SlackUrl, map[string]string{"text": text})
+	}
+}
+
+func (reporter *TrendReporter) post(url string, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("Cannot encode trend report: %v", err)
+		return
+	}
+	resp, err := reporter.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Errorf("Cannot send trend report to %s: %v", redactedURL(url), err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if (resp.StatusCode < 200) || (resp.StatusCode > 299) {
+		log.Errorf("Cannot send trend report to %s: %s", redactedURL(url), resp.Status)
+	}
+}
+
+type HTTPResponseTrendReport struct {
+	Error   bool                    `json:"error"`
+	Message string                  `json:"message"`
+	Report  *TrendReport            `json:"report"`
+	Request HTTPResponseRequestInfo `json:"request"`
+}
+
+// Handle GET /v2/burrow/trend-report, which returns the trend report so far, without sending it or starting a new
+// window. Clusters the token can't see are left out
+func handleTrendReport(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if app.TrendReporter == nil {
+		return makeErrorResponse(http.StatusNotFound, "trend reports are not configured", w, r)
+	}
+	report, ok := app.TrendReporter.Preview()
+	if !ok {
+		return makeOverloadedResponse(app, storageBusyReason, w, r)
+	}
+	clusters := make([]*ClusterTrendReport, 0, len(report.Clusters))
+	for _, cluster := range report.Clusters {
+		if app.AdminAudit.ClusterAllowed(r, cluster.Cluster) {
+			clusters = append(clusters, cluster)
+		}
+	}
+	report.Clusters = clusters
+
+	jsonStr, err := json.Marshal(HTTPResponseTrendReport{
+		Error:   false,
+		Message: "trend report returned",
+		Report:  report,
+		Request: makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+	w.Write(jsonStr)
+	return 200, ""
+}
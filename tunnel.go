@@ -0,0 +1,320 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The SOCKS5 replies (RFC 1928) other than success
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// A tunnel reaches a Kafka cluster's brokers and Zookeeper ensemble, from the tunnel option in its [kafka] section,
+// when they are in a network Burrow can't connect to directly:
+//   - socks5://[user@]host[:port] connects through a SOCKS5 proxy (by default on port 1080), with the password in
+//     tunnel-password-file if it needs one
+//   - ssh://user@host[:port] forwards connections through an SSH jump host (by default on port 22), logging in with
+//     the private key in tunnel-key-file. The jump host's key must be in tunnel-known-hosts
+//
+// Host names are resolved by the proxy or jump host, so names that are only known inside the network work. One SSH
+// connection is shared by every connection to the cluster, and is opened again if it drops
+type Tunnel struct {
+	cluster  string
+	scheme   string
+	address  string
+	username string
+	password string
+	config   *ssh.ClientConfig
+	lock     sync.Mutex
+	client   *ssh.Client
+}
+
+// Load the tunnel of each Kafka cluster that has one, reading the SSH keys and known hosts and the SOCKS5 passwords.
+// The config must have been validated
+func loadTunnels(config *BurrowConfig) (map[string]*Tunnel, error) {
+	tunnels := make(map[string]*Tunnel)
+	for cluster, cfg := range config.Kafka {
+		if cfg.Tunnel == "" {
+			continue
+		}
+		parsed, err := url.Parse(cfg.Tunnel)
+		if err != nil {
+			return nil, err
+		}
+		tunnel := &Tunnel{cluster: cluster, scheme: parsed.Scheme, address: parsed.Host}
+		if parsed.User != nil {
+			tunnel.username = parsed.User.Username()
+		}
+		if parsed.Port() == "" {
+			port := "1080"
+			if tunnel.scheme == "ssh" {
+				port = "22"
+			}
+			tunnel.address = net.JoinHostPort(trimBrackets(parsed.Host), port)
+		}
+
+		switch tunnel.scheme {
+		case "socks5":
+			if cfg.TunnelPasswordFile != "" {
+				password, err := ioutil.ReadFile(cfg.TunnelPasswordFile)
+				if err != nil {
+					return nil, fmt.Errorf("cannot read the tunnel password of cluster %s: %v", cluster, err)
+				}
+				tunnel.password = strings.TrimSpace(string(password))
+			}
+		case "ssh":
+			key, err := ioutil.ReadFile(cfg.TunnelKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the tunnel key of cluster %s: %v", cluster, err)
+			}
+			signer, err := ssh.ParsePrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse the tunnel key of cluster %s (keys with a passphrase are not supported): %v", cluster, err)
+			}
+			hostKeys, err := knownhosts.New(cfg.TunnelKnownHosts)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the tunnel known hosts of cluster %s: %v", cluster, err)
+			}
+			tunnel.config = &ssh.ClientConfig{
+				User:            tunnel.username,
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+				HostKeyCallback: hostKeys,
+			}
+		}
+		tunnels[cluster] = tunnel
+	}
+	return tunnels, nil
+}
+
+// Close the SSH connections of the tunnels
+func closeTunnels(tunnels map[string]*Tunnel) {
+	for _, tunnel := range tunnels {
+		tunnel.lock.Lock()
+		if tunnel.client != nil {
+			tunnel.client.Close()
+			tunnel.client = nil
+		}
+		tunnel.lock.Unlock()
+	}
+}
+
+// A dialer for sarama, which only takes a custom dialer as a proxy, with the dial timeout of the client profile
+type tunnelDialer struct {
+	tunnel  *Tunnel
+	timeout time.Duration
+}
+
+func (d *tunnelDialer) Dial(network string, address string) (net.Conn, error) {
+	return d.tunnel.DialTimeout(network, address, d.timeout)
+}
+
+// Connect to an address through the tunnel. This is also the Zookeeper client's dial function
+func (tunnel *Tunnel) DialTimeout(network string, address string, timeout time.Duration) (net.Conn, error) {
+	if tunnel.scheme == "ssh" {
+		return tunnel.dialSSH(address, timeout)
+	}
+	return tunnel.dialSOCKS(address, timeout)
+}
+
+func (tunnel *Tunnel) dialSOCKS(address string, timeout time.Duration) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newDialer(timeout, 30*time.Second).Dial("tcp", tunnel.address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := tunnel.socksConnect(conn, trimBrackets(host), port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s cannot connect to %s: %v", tunnel.address, address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Ask the SOCKS5 proxy to connect to the host, logging in with the username and password if there are any
+func (tunnel *Tunnel) socksConnect(conn net.Conn, host string, port int) error {
+	methods := []byte{0}
+	if tunnel.username != "" {
+		methods = append(methods, 2)
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("not a SOCKS5 proxy (version %v)", reply[0])
+	}
+	switch {
+	case reply[1] == 0:
+	case (reply[1] == 2) && (tunnel.username != ""):
+		if (len(tunnel.username) > 255) || (len(tunnel.password) > 255) {
+			return errors.New("username and password must be no longer than 255 bytes")
+		}
+		auth := append([]byte{1, byte(len(tunnel.username))}, tunnel.username...)
+		auth = append(append(auth, byte(len(tunnel.password))), tunnel.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("username and password were rejected")
+		}
+	default:
+		return errors.New("proxy needs a login method that is not supported")
+	}
+
+	request := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("host name is longer than 255 bytes")
+		}
+		request = append(append(request, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(append(request, 1), ip4...)
+	} else {
+		request = append(append(request, 4), ip.To16()...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// The reply ends with the address the proxy connected from, which isn't needed
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if reason, ok := socksReplies[header[1]]; ok {
+			return errors.New(reason)
+		}
+		return fmt.Errorf("unknown reply %v", header[1])
+	}
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = 4 + 2
+	case 4:
+		skip = 16 + 2
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("unknown address type %v", header[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+func (tunnel *Tunnel) dialSSH(address string, timeout time.Duration) (net.Conn, error) {
+	client, err := tunnel.sshClient(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to SSH jump host %s: %v", tunnel.address, err)
+	}
+
+	// Forwarded connections can't be given a timeout, so one that connects after we give up is closed
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := client.Dial("tcp", address)
+		results <- result{conn, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case dialed := <-results:
+		if dialed.err != nil {
+			return nil, fmt.Errorf("SSH jump host %s cannot connect to %s: %v", tunnel.address, address, dialed.err)
+		}
+		return dialed.conn, nil
+	case <-timer.C:
+		go func() {
+			if dialed := <-results; dialed.conn != nil {
+				dialed.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("SSH jump host %s timed out connecting to %s", tunnel.address, address)
+	}
+}
+
+// Return the SSH connection to the jump host, opening it if there isn't one
+func (tunnel *Tunnel) sshClient(timeout time.Duration) (*ssh.Client, error) {
+	tunnel.lock.Lock()
+	defer tunnel.lock.Unlock()
+	if tunnel.client != nil {
+		return tunnel.client, nil
+	}
+
+	conn, err := newDialer(timeout, 30*time.Second).Dial("tcp", tunnel.address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, tunnel.address, tunnel.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, channels, requests)
+	tunnel.client = client
+	log.Infof("Opened SSH tunnel to cluster %s through %s", tunnel.cluster, tunnel.address)
+
+	go func() {
+		err := client.Wait()
+		tunnel.lock.Lock()
+		defer tunnel.lock.Unlock()
+		if tunnel.client == client {
+			tunnel.client = nil
+			log.Warnf("SSH tunnel to cluster %s through %s closed: %v", tunnel.cluster, tunnel.address, err)
+		}
+	}()
+	return client, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/gcfg.v1"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Start a server that echoes what it is sent, and return its address
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// Load the tunnel of cluster local from the [kafka "local"] section
+func testTunnel(t *testing.T, section string) (*Tunnel, error) {
+	config := &BurrowConfig{}
+	if err := gcfg.ReadStringInto(config, "[kafka \"local\"]\n"+section); err != nil {
+		t.Fatalf("Cannot parse config: %v", err)
+	}
+	tunnels, err := loadTunnels(config)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { closeTunnels(tunnels) })
+	return tunnels["local"], nil
+}
+
+// Send a line through a connection from the tunnel, and check that it comes back
+func checkEcho(t *testing.T, tunnel *Tunnel, address string) {
+	conn, err := tunnel.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Cannot dial through the tunnel: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping\n"))
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); (err != nil) || (string(reply) != "ping\n") {
+		t.Errorf("Expected the line back, got %q (%v)", reply, err)
+	}
+}
+
+func Test_tunnelSOCKS(t *testing.T) {
+	echo := startEchoServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer listener.Close()
+
+	// A SOCKS5 proxy that needs a login, and knows the echo server as echo.internal
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 512)
+				io.ReadFull(conn, buf[:2])
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 2})
+				io.ReadFull(conn, buf[:2])
+				length := buf[1]
+				io.ReadFull(conn, buf[:length])
+				username := string(buf[:length])
+				io.ReadFull(conn, buf[:1])
+				length = buf[0]
+				io.ReadFull(conn, buf[:length])
+				if (username != "burrow") || (string(buf[:length]) != "secret") {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				io.ReadFull(conn, buf[:5])
+				length = buf[4]
+				io.ReadFull(conn, buf[5:5+int(length)+2])
+				if (buf[3] != 3) || (string(buf[5:5+length]) != "echo.internal") {
+					conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				target, err := net.Dial("tcp", echo)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 1})
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(echo)
+	dir, err := ioutil.TempDir("", "burrow-tunnel")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600)
+	tunnel, err := testTunnel(t, "tunnel=socks5://burrow@"+listener.Addr().String()+"\ntunnel-password-file="+passwordFile+"\n")
+	if err != nil {
+		t.Fatalf("Cannot load tunnel: %v", err)
+	}
+	checkEcho(t, tunnel, "echo.internal:"+port)
+	if _, err := tunnel.DialTimeout("tcp", "unknown.internal:"+port, 5*time.Second); err == nil {
+		t.Errorf("Expected an unknown host to fail")
+	}
+
+	ioutil.WriteFile(passwordFile, []byte("wrong"), 0600)
+	tunnel, _ = testTunnel(t, "tunnel=socks5://burrow@"+listener.Addr().String()+"\ntunnel-password-file="+passwordFile+"\n")
+	if _, err := tunnel.DialTimeout("tcp", "echo.internal:"+port, 5*time.Second); err == nil {
+		t.Errorf("Expected a wrong password to be rejected")
+	}
+}
+
+func Test_tunnelSSH(t *testing.T) {
+	echo := startEchoServer(t)
+	dir, err := ioutil.TempDir("", "burrow-tunnel")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+	clientPublic, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(clientKey)
+	ioutil.WriteFile(filepath.Join(dir, "key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	authorized, _ := ssh.NewPublicKey(clientPublic)
+
+	// A jump host that only lets our key in, and knows the echo server as echo.internal
+	serverConfig := &ssh.ServerConfig{PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if (meta.User() != "burrow") || (string(key.Marshal()) != string(authorized.Marshal())) {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}}
+	serverConfig.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sshConn, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				defer sshConn.Close()
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					var forward struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					ssh.Unmarshal(newChannel.ExtraData(), &forward)
+					if (newChannel.ChannelType() != "direct-tcpip") || (forward.Host != "echo.internal") {
+						newChannel.Reject(ssh.ConnectionFailed, "unknown host")
+						continue
+					}
+					target, err := net.Dial("tcp", echo)
+					if err != nil {
+						newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, channelRequests, _ := newChannel.Accept()
+					go ssh.DiscardRequests(channelRequests)
+					go func() {
+						io.Copy(target, channel)
+						target.Close()
+					}()
+					go func() {
+						io.Copy(channel, target)
+						channel.Close()
+					}()
+				}
+			}()
+		}
+	}()
+
+	ioutil.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownhosts.Line([]string{listener.Addr().String()}, hostSigner.PublicKey())+"\n"), 0600)
+	section := "tunnel=ssh://burrow@" + listener.Addr().String() + "\ntunnel-key-file=" + filepath.Join(dir, "key") +
+		"\ntunnel-known-hosts=" + filepath.Join(dir, "known_hosts") + "\n"
+	tunnel, err := testTunnel(t, section)
+	if err != nil {
+		t.Fatalf("Cannot load tunnel: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(echo)
+	checkEcho(t, tunnel, "echo.internal:"+port)
+	if _, err := tunnel.DialTimeout("tcp", "unknown.internal:"+port, 5*time.Second); err == nil {
+		t.Errorf("Expected an unknown host to fail")
+	}
+
+	// The SSH connection is opened again once it drops
+	tunnel.lock.Lock()
+	dropped := tunnel.client
+	tunnel.lock.Unlock()
+	dropped.Close()
+	for i := 0; i < 100; i++ {
+		tunnel.lock.Lock()
+		closed := tunnel.client == nil
+		tunnel.lock.Unlock()
+		if closed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkEcho(t, tunnel, "echo.internal:"+port)
+
+	// A jump host with a key that isn't known is refused
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+	ioutil.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownhosts.Line([]string{listener.Addr().String()}, otherSigner.PublicKey())+"\n"), 0600)
+	tunnel, err = testTunnel(t, section)
+	if err != nil {
+		t.Fatalf("Cannot load tunnel: %v", err)
+	}
+	if _, err := tunnel.DialTimeout("tcp", "echo.internal:"+port, 5*time.Second); err == nil {
+		t.Errorf("Expected an unknown host key to be refused")
+	}
+}
@@ -0,0 +1,58 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/linkedin/burrow/storage"
+	"net/http"
+	"strconv"
+)
+
+// The most groups that can be asked for in each cluster's largest groups
+const maxUsageTopGroups = 1000
+
+type HTTPResponseUsage struct {
+	Error    bool                                   `json:"error"`
+	Message  string                                 `json:"message"`
+	Clusters map[string]*storage.ClusterDiagnostics `json:"clusters"`
+	Request  HTTPResponseRequestInfo                `json:"request"`
+}
+
+// Return the approximate memory used by each cluster (topics, partitions, offset rings, and archived offsets), with
+// the groups that use the most. ?top=N sets how many groups are listed for each cluster
+func handleUsage(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+
+	top := 0
+	if param := r.URL.Query().Get("top"); param != "" {
+		var err error
+		top, err = strconv.Atoi(param)
+		if (err != nil) || (top < 1) || (top > maxUsageTopGroups) {
+			return makeErrorResponse(http.StatusBadRequest, "top must be a number from 1 to "+strconv.Itoa(maxUsageTopGroups), w, r)
+		}
+	}
+
+	jsonStr, err := json.Marshal(HTTPResponseUsage{
+		Error:    false,
+		Message:  "storage usage returned",
+		Clusters: app.Storage.Usage(top).Clusters,
+		Request:  makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
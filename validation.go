@@ -0,0 +1,343 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How long to wait before checking a partition that doesn't match again. Commits that were in flight when the broker
+// was asked will have reached the storage module by then
+const validationConfirmDelay = 10 * time.Second
+
+// The most divergent partitions kept for each cluster from the last run
+const maxDivergences = 100
+
+// A partition where the offset Burrow has stored is not the offset the broker has committed for the group. Missed
+// means the broker is ahead of Burrow (Burrow did not see commits), and ahead means Burrow has an offset the broker
+// does not
+type OffsetDivergence struct {
+	Group           string `json:"group"`
+	Topic           string `json:"topic"`
+	Partition       int32  `json:"partition"`
+	Kind            string `json:"kind"`
+	StoredOffset    int64  `json:"stored_offset"`
+	StoredTimestamp int64  `json:"stored_timestamp"`
+	BrokerOffset    int64  `json:"broker_offset"`
+}
+
+// The results of the last validation run for a cluster
+type ClusterValidation struct {
+	Cluster    string              `json:"cluster"`
+	LastRun    int64               `json:"last_run"`
+	Groups     int                 `json:"groups"`
+	Partitions int                 `json:"partitions"`
+	Divergent  []*OffsetDivergence `json:"divergent"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// The offset validator periodically takes a random sample of the groups in each Kafka cluster, fetches their committed
+// offsets from the group coordinator, and compares them to what the storage module has. Groups that don't commit to
+// Kafka (such as Zookeeper or Storm groups) have no committed offsets on the broker, and are skipped
+type OffsetValidator struct {
+	app     *ApplicationContext
+	clients map[string]sarama.Client
+	results map[string]*ClusterValidation
+	lock    sync.RWMutex
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewOffsetValidator(app *ApplicationContext) *OffsetValidator {
+	validator := &OffsetValidator{
+		app:     app,
+		clients: make(map[string]sarama.Client),
+		results: make(map[string]*ClusterValidation),
+		quit:    make(chan struct{}),
+	}
+
+	app.Metrics.Register("burrow_offset_validation_partitions_total", MetricCounter, "Partitions whose stored offset was checked against the broker")
+	app.Metrics.Register("burrow_offset_validation_divergent_total", MetricCounter, "Partitions whose stored offset did not match the broker, by kind")
+	app.Metrics.Register("burrow_offset_validation_divergent_partitions", MetricGauge, "Partitions that did not match the broker in the last run")
+	app.Metrics.Register("burrow_offset_validation_errors_total", MetricCounter, "Validation runs that could not talk to the cluster")
+	return validator
+}
+
+func (validator *OffsetValidator) Start() {
+	validator.wg.Add(1)
+	go func() {
+		defer validator.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(validator.app.Config.Validation.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-validator.quit:
+				return
+			case <-ticker.C:
+				validator.run()
+			}
+		}
+	}()
+}
+
+func (validator *OffsetValidator) Stop() {
+	close(validator.quit)
+	validator.wg.Wait()
+
+	for _, client := range validator.clients {
+		client.Close()
+	}
+}
+
+// Return the results of the last run for each cluster, sorted by cluster
+func (validator *OffsetValidator) Results() []*ClusterValidation {
+	validator.lock.RLock()
+	defer validator.lock.RUnlock()
+
+	results := make([]*ClusterValidation, 0, len(validator.results))
+	for _, result := range validator.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Cluster < results[j].Cluster })
+	return results
+}
+
+func (validator *OffsetValidator) run() {
+	for _, cluster := range kafkaClusterNames(validator.app.Config) {
+		result := validator.validateCluster(cluster)
+		labels := map[string]string{"cluster": cluster}
+
+		if result.Error != "" {
+			log.Warnf("Cannot validate offsets for cluster %s: %s", cluster, result.Error)
+			validator.app.Metrics.Add("burrow_offset_validation_errors_total", labels, 1)
+		} else {
+			validator.app.Metrics.Set("burrow_offset_validation_divergent_partitions", labels, float64(len(result.Divergent)))
+			if len(result.Divergent) > 0 {
+				log.Warnf("%v of %v sampled partitions in cluster %s do not match the offsets committed on the broker", len(result.Divergent), result.Partitions, cluster)
+			}
+		}
+		if len(result.Divergent) > maxDivergences {
+			result.Divergent = result.Divergent[:maxDivergences]
+		}
+
+		validator.lock.Lock()
+		validator.results[cluster] = result
+		validator.lock.Unlock()
+	}
+}
+
+// A partition that did not match the broker the first time, and is checked again after a delay
+type suspectPartition struct {
+	topic     string
+	partition int32
+	stored    *storage.ConsumerOffset
+}
+
+func (validator *OffsetValidator) validateCluster(cluster string) *ClusterValidation {
+	result := &ClusterValidation{
+		Cluster:   cluster,
+		LastRun:   time.Now().Unix() * 1000,
+		Divergent: make([]*OffsetDivergence, 0),
+	}
+
+	client, err := validator.getClient(cluster)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	groups := validator.app.Storage.ConsumerList(cluster)
+	rand.Shuffle(len(groups), func(i, j int) { groups[i], groups[j] = groups[j], groups[i] })
+	if len(groups) > validator.app.Config.Validation.Groups {
+		groups = groups[:validator.app.Config.Validation.Groups]
+	}
+
+	suspects := make(map[string][]*suspectPartition)
+	for _, group := range groups {
+		stored := validator.app.Storage.ConsumerOffsets(cluster, group)
+		if len(stored) == 0 {
+			// The group expired since the list was fetched
+			continue
+		}
+		committed, err := fetchCommittedOffsets(client, group, stored)
+		if err != nil {
+			log.Warnf("Cannot fetch committed offsets for group %s in cluster %s: %v", group, cluster, err)
+			continue
+		}
+		result.Groups++
+
+		for topic, partitions := range committed {
+			for partition, brokerOffset := range partitions {
+				result.Partitions++
+				if offset := stored[topic][partition]; offset.Offset != brokerOffset {
+					suspects[group] = append(suspects[group], &suspectPartition{topic: topic, partition: partition, stored: offset})
+				}
+			}
+		}
+	}
+	validator.app.Metrics.Add("burrow_offset_validation_partitions_total", map[string]string{"cluster": cluster}, float64(result.Partitions))
+
+	if len(suspects) == 0 {
+		return result
+	}
+	select {
+	case <-validator.quit:
+		return result
+	case <-time.After(validationConfirmDelay):
+	}
+
+	// A partition only diverges if the broker still doesn't match and Burrow has not seen a commit since the first check
+	for group, partitions := range suspects {
+		stored := validator.app.Storage.ConsumerOffsets(cluster, group)
+		committed, err := fetchCommittedOffsets(client, group, stored)
+		if err != nil {
+			log.Warnf("Cannot fetch committed offsets for group %s in cluster %s: %v", group, cluster, err)
+			continue
+		}
+
+		for _, suspect := range partitions {
+			brokerOffset, ok := committed[suspect.topic][suspect.partition]
+			if !ok {
+				continue
+			}
+			divergence := confirmDivergence(group, suspect, stored[suspect.topic][suspect.partition], brokerOffset)
+			if divergence == nil {
+				continue
+			}
+			result.Divergent = append(result.Divergent, divergence)
+			validator.app.Metrics.Add("burrow_offset_validation_divergent_total", map[string]string{"cluster": cluster, "kind": divergence.Kind}, 1)
+		}
+	}
+
+	sort.Slice(result.Divergent, func(i, j int) bool {
+		if result.Divergent[i].Group != result.Divergent[j].Group {
+			return result.Divergent[i].Group < result.Divergent[j].Group
+		}
+		if result.Divergent[i].Topic != result.Divergent[j].Topic {
+			return result.Divergent[i].Topic < result.Divergent[j].Topic
+		}
+		return result.Divergent[i].Partition < result.Divergent[j].Partition
+	})
+	return result
+}
+
+// Check a suspect partition again with the offset now stored and the broker's offset. This returns nil if they match,
+// or if Burrow has seen a commit since the first check (as the broker may have been asked before it was made)
+func confirmDivergence(group string, suspect *suspectPartition, offset *storage.ConsumerOffset, brokerOffset int64) *OffsetDivergence {
+	if (offset == nil) || (offset.Offset == brokerOffset) || (offset.Timestamp != suspect.stored.Timestamp) {
+		return nil
+	}
+
+	divergence := &OffsetDivergence{
+		Group:           group,
+		Topic:           suspect.topic,
+		Partition:       suspect.partition,
+		Kind:            "missed",
+		StoredOffset:    offset.Offset,
+		StoredTimestamp: offset.Timestamp,
+		BrokerOffset:    brokerOffset,
+	}
+	if brokerOffset < offset.Offset {
+		divergence.Kind = "ahead"
+	}
+	return divergence
+}
+
+// The validator has its own client for each cluster, so it is not affected by offset sources being restarted
+func (validator *OffsetValidator) getClient(cluster string) (sarama.Client, error) {
+	if client, ok := validator.clients[cluster]; ok {
+		return client, nil
+	}
+	client, err := sarama.NewClient(validator.app.Config.Kafka[cluster].Brokers, newSaramaConfig(validator.app, cluster))
+	if err != nil {
+		return nil, err
+	}
+	validator.clients[cluster] = client
+	return client, nil
+}
+
+// Fetch the offsets the group coordinator has committed for each of the partitions that Burrow has an offset for.
+// Partitions that the group has not committed to Kafka are left out
+func fetchCommittedOffsets(client sarama.Client, group string, stored map[string][]*storage.ConsumerOffset) (map[string]map[int32]int64, error) {
+	request := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: group}
+	for topic, partitions := range stored {
+		for partition, offset := range partitions {
+			if offset != nil {
+				request.AddPartition(topic, int32(partition))
+			}
+		}
+	}
+
+	broker, err := client.Coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+	response, err := broker.FetchOffset(request)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := make(map[string]map[int32]int64)
+	for topic, partitions := range stored {
+		for partition, offset := range partitions {
+			if offset == nil {
+				continue
+			}
+			block := response.GetBlock(topic, int32(partition))
+			if (block == nil) || (block.Err != sarama.ErrNoError) || (block.Offset == -1) {
+				continue
+			}
+			if _, ok := committed[topic]; !ok {
+				committed[topic] = make(map[int32]int64)
+			}
+			committed[topic][int32(partition)] = block.Offset
+		}
+	}
+	return committed, nil
+}
+
+type HTTPResponseValidation struct {
+	Error    bool                    `json:"error"`
+	Message  string                  `json:"message"`
+	Clusters []*ClusterValidation    `json:"clusters"`
+	Request  HTTPResponseRequestInfo `json:"request"`
+}
+
+func handleValidation(app *ApplicationContext, w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.Method != "GET" {
+		return makeErrorResponse(http.StatusMethodNotAllowed, "request method not supported", w, r)
+	}
+	if app.Validator == nil {
+		return makeErrorResponse(http.StatusNotFound, "offset validation is not enabled", w, r)
+	}
+
+	jsonStr, err := json.Marshal(HTTPResponseValidation{
+		Error:    false,
+		Message:  "offset validation results returned",
+		Clusters: app.Validator.Results(),
+		Request:  makeRequestInfo(r),
+	})
+	if err != nil {
+		return http.StatusInternalServerError, "{\"error\":true,\"message\":\"could not encode JSON\",\"result\":{}}"
+	}
+
+	w.Write(jsonStr)
+	return 200, ""
+}
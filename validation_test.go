@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/linkedin/burrow/storage"
+)
+
+func Test_confirmDivergence(t *testing.T) {
+	suspect := &suspectPartition{topic: "orders", partition: 3, stored: &storage.ConsumerOffset{Offset: 100, Timestamp: 1000}}
+
+	tests := []struct {
+		offset *storage.ConsumerOffset
+		broker int64
+		kind   string
+	}{
+		{&storage.ConsumerOffset{Offset: 100, Timestamp: 1000}, 150, "missed"},
+		{&storage.ConsumerOffset{Offset: 100, Timestamp: 1000}, 50, "ahead"},
+		{&storage.ConsumerOffset{Offset: 100, Timestamp: 1000}, 100, ""},
+		{&storage.ConsumerOffset{Offset: 120, Timestamp: 2000}, 150, ""},
+		{nil, 150, ""},
+	}
+	for i, test := range tests {
+		divergence := confirmDivergence("payments", suspect, test.offset, test.broker)
+		switch {
+		case (test.kind == "") && (divergence != nil):
+			t.Errorf("Test %v: expected no divergence, got %+v", i, divergence)
+		case (test.kind != "") && (divergence == nil):
+			t.Errorf("Test %v: expected a %s divergence, got none", i, test.kind)
+		case divergence != nil:
+			if (divergence.Kind != test.kind) || (divergence.Group != "payments") || (divergence.Topic != "orders") ||
+				(divergence.Partition != 3) || (divergence.StoredOffset != 100) || (divergence.BrokerOffset != test.broker) {
+				t.Errorf("Test %v: unexpected divergence %+v", i, divergence)
+			}
+		}
+	}
+}
+
+func Test_offsetValidatorResults(t *testing.T) {
+	validator := NewOffsetValidator(&ApplicationContext{Metrics: NewMetrics()})
+	for _, cluster := range []string{"staging", "local", "prod"} {
+		validator.results[cluster] = &ClusterValidation{Cluster: cluster, Divergent: make([]*OffsetDivergence, 0)}
+	}
+
+	results := validator.Results()
+	if (len(results) != 3) || (results[0].Cluster != "local") || (results[1].Cluster != "prod") || (results[2].Cluster != "staging") {
+		t.Errorf("Expected the results sorted by cluster, got %+v", results)
+	}
+}
+
+func Test_handleValidation(t *testing.T) {
+	app := &ApplicationContext{Metrics: NewMetrics()}
+	recorder := httptest.NewRecorder()
+	if status, _ := handleValidation(app, recorder, httptest.NewRequest("GET", "/v2/burrow/validation", nil)); status != http.StatusNotFound {
+		t.Errorf("Expected not found without a validator, got %v", status)
+	}
+
+	app.Validator = NewOffsetValidator(app)
+	app.Validator.results["local"] = &ClusterValidation{Cluster: "local", Groups: 2, Partitions: 6, Divergent: []*OffsetDivergence{
+		{Group: "payments", Topic: "orders", Partition: 3, Kind: "missed", StoredOffset: 100, BrokerOffset: 150},
+	}}
+	recorder = httptest.NewRecorder()
+	if status, _ := handleValidation(app, recorder, httptest.NewRequest("GET", "/v2/burrow/validation", nil)); status != http.StatusOK {
+		t.Fatalf("Expected the results, got %v", status)
+	}
+	response := &HTTPResponseValidation{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("Cannot decode response: %v", err)
+	}
+	if (len(response.Clusters) != 1) || (response.Clusters[0].Partitions != 6) || (len(response.Clusters[0].Divergent) != 1) ||
+		(response.Clusters[0].Divergent[0].Kind != "missed") {
+		t.Errorf("Unexpected results %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	if status, _ := handleValidation(app, recorder, httptest.NewRequest("POST", "/v2/burrow/validation", nil)); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be refused, got %v", status)
+	}
+}
@@ -0,0 +1,119 @@
+/* Copyright 2015 LinkedIn Corp. Licensed under the Apache License, Version
+ * 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ */
+
+package main
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"sync/atomic"
+	"time"
+)
+
+// Sources that implement this can be supervised by the watchdog. Activity returns the last time each part of the
+// source (such as the offsets consumer or the broker offset fetcher) got data. If any part has been idle for longer
+// than the watchdog timeout, the source is assumed to be wedged and is restarted
+type SupervisedSource interface {
+	OffsetSource
+	Activity() map[string]time.Time
+}
+
+// Keeps the last time a part of a source got data. It is safe to use from multiple goroutines
+type activityTimer struct {
+	last int64
+}
+
+func (timer *activityTimer) touch() {
+	atomic.StoreInt64(&timer.last, time.Now().UnixNano())
+}
+
+func (timer *activityTimer) time() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&timer.last))
+}
+
+// Check the running sources periodically, and restart any that are wedged
+func (sources *OffsetSources) watchdog() {
+	defer sources.retryGroup.Done()
+
+	timeout := time.Duration(sources.app.Config.Watchdog.Timeout) * time.Second
+	ticker := time.NewTicker(time.Duration(sources.app.Config.Tickers.SourceRetry) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sources.quit:
+			return
+		case <-ticker.C:
+			for _, wedged := range sources.findWedged(timeout) {
+				sources.restartSource(wedged.name, wedged.cluster, wedged.reason)
+			}
+		}
+	}
+}
+
+type wedgedSource struct {
+	name    string
+	cluster string
+	reason  string
+}
+
+func (sources *OffsetSources) findWedged(timeout time.Duration) []wedgedSource {
+	sources.lock.RLock()
+	defer sources.lock.RUnlock()
+
+	wedged := make([]wedgedSource, 0)
+	now := time.Now()
+	for name, clusters := range sources.running {
+		for cluster, source := range clusters {
+			supervised, ok := source.(SupervisedSource)
+			if !ok {
+				continue
+			}
+			for part, last := range supervised.Activity() {
+				if idle := now.Sub(last); idle > timeout {
+					wedged = append(wedged, wedgedSource{
+						name:    name,
+						cluster: cluster,
+						reason:  fmt.Sprintf("no %s data for %v", part, idle.Truncate(time.Second)),
+					})
+					break
+				}
+			}
+		}
+	}
+	return wedged
+}
+
+// Stop a wedged source and start retrying it in the background. The restart is counted in the cluster detail and in
+// the burrow_source_restarts_total metric (to alert on), and the reason is kept as the source's error until it is
+// running again
+func (sources *OffsetSources) restartSource(name string, cluster string, reason string) {
+	sources.lock.Lock()
+	source, ok := sources.running[name][cluster]
+	if !ok {
+		sources.lock.Unlock()
+		return
+	}
+	delete(sources.running[name], cluster)
+	sources.setError(name, cluster, "restarting: "+reason)
+	if _, ok := sources.restarts[cluster]; !ok {
+		sources.restarts[cluster] = make(map[string]int)
+	}
+	sources.restarts[cluster][name]++
+	sources.lock.Unlock()
+
+	log.Criticalf("The %s offset source for cluster %s is wedged (%s), restarting it", name, cluster, reason)
+	sources.app.Metrics.Add("burrow_source_restarts_total", map[string]string{"cluster": cluster, "source": name}, 1)
+
+	// A wedged source might not stop cleanly, so don't let it hold up the restart
+	go source.Stop()
+
+	sources.retryGroup.Add(1)
+	go sources.retrySource(name, cluster)
+}
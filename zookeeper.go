@@ -11,25 +11,59 @@
 package main
 
 import (
+	"encoding/json"
 	log "github.com/cihub/seelog"
+	"github.com/linkedin/burrow/storage"
 	"github.com/samuel/go-zookeeper/zk"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 type ZookeeperClient struct {
-	app             *ApplicationContext
-	cluster         string
-	conn            *zk.Conn
-	zkRefreshTicker *time.Ticker
-	zkGroupList     map[string]bool
-	zkGroupLock     sync.RWMutex
+	app               *ApplicationContext
+	cluster           string
+	conn              *zk.Conn
+	zkRefreshTicker   *time.Ticker
+	zkGroupList       map[string]bool
+	zkGroupLock       sync.RWMutex
+	topicConfigTicker *time.Ticker
+	offsetChannel     chan *storage.PartitionOffset
+	groupActivity     activityTimer
+	configActivity    activityTimer
+}
+
+func init() {
+	RegisterOffsetSource("zookeeper", &OffsetSourceModule{
+		Clusters: kafkaClusterNames,
+		New: func(app *ApplicationContext, cluster string) (OffsetSource, error) {
+			return NewZookeeperClient(app, cluster)
+		},
+	})
+}
+
+// This is the format of the topic config znodes (/config/topics/(topic)) that Kafka writes
+type ZookeeperTopicConfig struct {
+	Version int               `json:"version"`
+	Config  map[string]string `json:"config"`
+}
+
+// Connect to a Zookeeper ensemble. The hosts are looked up each time the client connects (rather than only the first
+// time, as the Zookeeper library does), and are connected to with the dialer, which is zookeeperDialer unless the
+// cluster has a tunnel
+func connectZookeeper(servers []string, timeout time.Duration, dialer zk.Dialer) (*zk.Conn, error) {
+	conn, _, err := zk.Connect(servers, timeout, zk.WithDialer(dialer), zk.WithHostProvider(&zookeeperHostProvider{}))
+	return conn, err
 }
 
 func NewZookeeperClient(app *ApplicationContext, cluster string) (*ZookeeperClient, error) {
-	zkconn, _, err := zk.Connect(app.Config.Kafka[cluster].Zookeepers, time.Duration(app.Config.Zookeeper.Timeout)*time.Second)
+	dialer := zookeeperDialer
+	if tunnel, ok := app.Tunnels[cluster]; ok {
+		dialer = tunnel.DialTimeout
+	}
+	zkconn, err := connectZookeeper(app.Config.Kafka[cluster].Zookeepers, time.Duration(app.Config.Zookeeper.Timeout)*time.Second, dialer)
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +76,16 @@ func NewZookeeperClient(app *ApplicationContext, cluster string) (*ZookeeperClie
 		zkGroupList: make(map[string]bool),
 	}
 
+	return client, nil
+}
+
+func (client *ZookeeperClient) Start(offsets chan *storage.PartitionOffset) error {
+	client.offsetChannel = offsets
+	client.groupActivity.touch()
+	client.configActivity.touch()
+
 	// Check if this cluster is configured to check Zookeeper consumer offsets
-	if client.app.Config.Kafka[cluster].ZKOffsets {
+	if client.app.Config.Kafka[client.cluster].ZKOffsets {
 		// Get a group list to start with (this will start the offset checkers)
 		client.refreshConsumerGroups()
 
@@ -56,7 +98,16 @@ func NewZookeeperClient(app *ApplicationContext, cluster string) (*ZookeeperClie
 		}()
 	}
 
-	return client, nil
+	// Topic configs are used to find compacted topics, which need to be treated differently for lag
+	client.refreshTopicConfigs()
+	client.topicConfigTicker = time.NewTicker(time.Duration(client.app.Config.Lagcheck.TopicConfigRefresh) * time.Second)
+	go func() {
+		for _ = range client.topicConfigTicker.C {
+			client.refreshTopicConfigs()
+		}
+	}()
+
+	return nil
 }
 
 func (zkClient *ZookeeperClient) Stop() {
@@ -66,10 +117,61 @@ func (zkClient *ZookeeperClient) Stop() {
 		zkClient.zkGroupList = make(map[string]bool)
 		zkClient.zkGroupLock.Unlock()
 	}
+	if zkClient.topicConfigTicker != nil {
+		zkClient.topicConfigTicker.Stop()
+	}
 
 	zkClient.conn.Close()
 }
 
+func (zkClient *ZookeeperClient) Hosts() []string {
+	return zkClient.app.Config.Kafka[zkClient.cluster].Zookeepers
+}
+
+func (zkClient *ZookeeperClient) Activity() map[string]time.Time {
+	activity := map[string]time.Time{"topic config": zkClient.configActivity.time()}
+	if zkClient.app.Config.Kafka[zkClient.cluster].ZKOffsets {
+		activity["consumer group list"] = zkClient.groupActivity.time()
+	}
+	return activity
+}
+
+func (zkClient *ZookeeperClient) refreshTopicConfigs() {
+	configPath := zkClient.app.Config.Kafka[zkClient.cluster].ZookeeperPath + "/config/topics"
+	topics, _, err := zkClient.conn.Children(configPath)
+	if err != nil {
+		log.Errorf("Cannot get topic config list for cluster %s: %s", zkClient.cluster, err)
+		return
+	}
+	zkClient.configActivity.touch()
+
+	compactedTopics := make(map[string]bool)
+	for _, topic := range topics {
+		configStr, _, err := zkClient.conn.Get(configPath + "/" + topic)
+		if err != nil {
+			log.Warnf("Cannot read config for topic %s in cluster %s: %s", topic, zkClient.cluster, err)
+			continue
+		}
+
+		var topicConfig ZookeeperTopicConfig
+		if err := json.Unmarshal(configStr, &topicConfig); err != nil {
+			log.Warnf("Cannot parse config for topic %s in cluster %s: %s", topic, zkClient.cluster, err)
+			continue
+		}
+
+		// The cleanup policy can be a list, such as "compact,delete"
+		for _, policy := range strings.Split(topicConfig.Config["cleanup.policy"], ",") {
+			if strings.TrimSpace(policy) == "compact" {
+				compactedTopics[topic] = true
+				break
+			}
+		}
+	}
+
+	log.Debugf("Found %v compacted topics in cluster %s", len(compactedTopics), zkClient.cluster)
+	zkClient.app.Storage.SetCompactedTopics(zkClient.cluster, compactedTopics)
+}
+
 func (zkClient *ZookeeperClient) refreshConsumerGroups() {
 	zkClient.zkGroupLock.Lock()
 	defer zkClient.zkGroupLock.Unlock()
@@ -80,6 +182,7 @@ func (zkClient *ZookeeperClient) refreshConsumerGroups() {
 		log.Errorf("Cannot get consumer group list for cluster %s: %s", zkClient.cluster, err)
 		return
 	}
+	zkClient.groupActivity.touch()
 
 	// Mark all existing groups false
 	for consumerGroup := range zkClient.zkGroupList {
@@ -89,7 +192,7 @@ func (zkClient *ZookeeperClient) refreshConsumerGroups() {
 	// Check for new groups, mark existing groups true
 	for _, consumerGroup := range consumerGroups {
 		// Don't bother adding groups in the blacklist
-		if (zkClient.app.Storage.groupBlacklist != nil) && zkClient.app.Storage.groupBlacklist.MatchString(consumerGroup) {
+		if (zkClient.app.Storage.GroupBlacklist != nil) && zkClient.app.Storage.GroupBlacklist.MatchString(consumerGroup) {
 			continue
 		}
 
@@ -139,7 +242,7 @@ func (zkClient *ZookeeperClient) getOffsetsForConsumerGroup(consumerGroup string
 	case err == nil:
 		// Spawn a goroutine for each topic. This provides parallelism for multi-topic consumers
 		for _, topic := range topics {
-			if (zkClient.app.Storage.topicBlacklist != nil) && zkClient.app.Storage.topicBlacklist.MatchString(topic) {
+			if (zkClient.app.Storage.TopicBlacklist != nil) && zkClient.app.Storage.TopicBlacklist.MatchString(topic) {
 				log.Debugf("Skip checking ZK offsets for topic %s from group %s in cluster %s as topic has been blacklisted", topic, consumerGroup, zkClient.cluster)
 				continue
 			}
@@ -185,15 +288,16 @@ func (zkClient *ZookeeperClient) getOffsetForPartition(consumerGroup string, top
 		return
 	}
 
-	partitionOffset := &PartitionOffset{
+	partitionOffset := &storage.PartitionOffset{
 		Cluster:   zkClient.cluster,
 		Topic:     topic,
 		Partition: int32(partitionNum),
 		Group:     consumerGroup,
 		Timestamp: zkNodeStat.Mtime,
 		Offset:    offset,
+		Source:    storage.OffsetSourceZookeeper,
 	}
-	timeoutSendOffset(zkClient.app.Storage.offsetChannel, partitionOffset, 1)
+	timeoutSendOffset(zkClient.offsetChannel, partitionOffset, 1)
 }
 
 func (zkClient *ZookeeperClient) NewLock(path string) *zk.Lock {